@@ -0,0 +1,359 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/client"
+	log "github.com/romana/rlog"
+)
+
+const (
+	// shapingFilterPref is a priority of tc filter policing
+	// traffic sent by endpoint.
+	shapingFilterPref = "49153"
+
+	// minShapingBurst is a minimal burst in bytes, smaller
+	// bursts make tbf and police inaccurate.
+	minShapingBurst = 16 * 1024
+
+	// bandwidthSourceEndpoint marks limits requested by endpoint.
+	bandwidthSourceEndpoint = "endpoint"
+)
+
+// Shaper applies bandwidth limits to endpoint interfaces. Traffic
+// towards the endpoint is shaped with tbf qdisc on the host side of
+// the veth, traffic sent by the endpoint is policed on ingress of
+// the same interface.
+type Shaper struct {
+	exec utilexec.Executable
+
+	// limits applied to interfaces.
+	applied map[string]api.Bandwidth
+}
+
+// NewShaper returns Shaper that programs tc using exec. Limits
+// already applied to interfaces, e.g. by previous run of the agent,
+// are read from tc, so that they are removed when not needed anymore.
+func NewShaper(exec utilexec.Executable) *Shaper {
+	s := &Shaper{exec: exec, applied: make(map[string]api.Bandwidth)}
+	if err := s.load(); err != nil {
+		log.Errorf("Failed to read bandwidth limits applied to interfaces, %s", err)
+	}
+	return s
+}
+
+// load reads limits from tbf qdiscs and police filters of interfaces.
+func (s *Shaper) load() error {
+	out, err := s.exec.Exec(TcBin, []string{"qdisc", "show"})
+	if err != nil {
+		return errors.Wrapf(err, "tc qdisc show failed, out=%s", out)
+	}
+
+	var clsact []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		link := fieldAfter(fields, "dev")
+		if len(fields) < 2 || fields[0] != "qdisc" || link == "" {
+			continue
+		}
+
+		switch fields[1] {
+		case "tbf":
+			if !hasField(fields, "root") {
+				continue
+			}
+			if kbps, ok := parseKbit(fieldAfter(fields, "rate")); ok {
+				bandwidth := s.applied[link]
+				bandwidth.IngressKbps = kbps
+				s.applied[link] = bandwidth
+			}
+		case "clsact":
+			clsact = append(clsact, link)
+		}
+	}
+
+	for _, link := range clsact {
+		out, err := s.exec.Exec(TcBin, []string{"filter", "show", "dev", link, "ingress", "pref", shapingFilterPref})
+		if err != nil {
+			return errors.Wrapf(err, "tc filter show dev %s failed, out=%s", link, out)
+		}
+
+		fields := strings.Fields(string(out))
+		for i, field := range fields {
+			if field != "police" {
+				continue
+			}
+			if kbps, ok := parseKbit(fieldAfter(fields[i:], "rate")); ok {
+				bandwidth := s.applied[link]
+				bandwidth.EgressKbps = kbps
+				s.applied[link] = bandwidth
+			}
+			break
+		}
+	}
+
+	if len(s.applied) > 0 {
+		log.Infof("Found bandwidth limits applied to %d interfaces", len(s.applied))
+	}
+	return nil
+}
+
+// Sync applies bandwidth limits to endpoints, limits requested by
+// endpoint take precedence over limits of policies applied to it.
+// Returns endpoints with updated status.
+func (s *Shaper) Sync(endpoints []api.EndpointBandwidth, policies []api.Policy, blocks []api.IPAMBlockResponse) []api.EndpointBandwidth {
+	var updated []api.EndpointBandwidth
+
+	current := make(map[string]bool)
+	for _, e := range endpoints {
+		current[e.Interface] = true
+
+		bandwidth, source := EffectiveBandwidth(e, policies, blocks)
+		status := api.BandwidthStatus{Applied: bandwidth, Source: source}
+		if err := s.apply(e.Interface, bandwidth); err != nil {
+			log.Errorf("Failed to apply bandwidth %+v to %s, %s", bandwidth, e.Name, err)
+			status.Applied = s.applied[e.Interface]
+			status.Error = err.Error()
+		}
+
+		if e.Status != nil && e.Status.Applied == status.Applied &&
+			e.Status.Source == status.Source && e.Status.Error == status.Error {
+			continue
+		}
+		status.UpdatedAt = time.Now()
+		e.Status = &status
+		updated = append(updated, e)
+	}
+
+	// interfaces of deleted endpoints are gone together
+	// with their qdiscs.
+	for link := range s.applied {
+		if !current[link] {
+			delete(s.applied, link)
+		}
+	}
+
+	return updated
+}
+
+// apply programs tc for the interface if limits changed.
+func (s *Shaper) apply(link string, bandwidth api.Bandwidth) error {
+	old := s.applied[link]
+
+	if bandwidth.IngressKbps != old.IngressKbps {
+		var err error
+		if bandwidth.IngressKbps == 0 {
+			err = s.run(TcBin, "qdisc", "del", "dev", link, "root")
+		} else {
+			err = s.run(TcBin, "qdisc", "replace", "dev", link, "root", "tbf",
+				"rate", kbit(bandwidth.IngressKbps), "burst", shapingBurst(bandwidth.IngressKbps), "latency", "50ms")
+		}
+		if err != nil {
+			return err
+		}
+		old.IngressKbps = bandwidth.IngressKbps
+		s.applied[link] = old
+	}
+
+	if bandwidth.EgressKbps != old.EgressKbps {
+		var err error
+		if bandwidth.EgressKbps == 0 {
+			err = s.run(TcBin, "filter", "del", "dev", link, "ingress", "pref", shapingFilterPref)
+		} else {
+			if err = s.run(TcBin, "qdisc", "replace", "dev", link, "clsact"); err != nil {
+				return err
+			}
+			err = s.run(TcBin, "filter", "replace", "dev", link, "ingress", "pref", shapingFilterPref,
+				"matchall", "action", "police", "rate", kbit(bandwidth.EgressKbps),
+				"burst", shapingBurst(bandwidth.EgressKbps), "conform-exceed", "drop")
+		}
+		if err != nil {
+			return err
+		}
+		old.EgressKbps = bandwidth.EgressKbps
+		s.applied[link] = old
+	}
+
+	return nil
+}
+
+func (s *Shaper) run(cmd string, args ...string) error {
+	out, err := s.exec.Exec(cmd, args)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s failed, out=%s", cmd, strings.Join(args, " "), out)
+	}
+	return nil
+}
+
+// fieldAfter returns field following the name, or empty string.
+func fieldAfter(fields []string, name string) string {
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == name {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+func hasField(fields []string, name string) bool {
+	for _, field := range fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKbit parses rate printed by tc, e.g. 512Kbit or 1Mbit, into kbps.
+func parseKbit(rate string) (uint64, bool) {
+	multipliers := []struct {
+		suffix string
+		bps    uint64
+	}{
+		{"Tbit", 1000 * 1000 * 1000 * 1000},
+		{"Gbit", 1000 * 1000 * 1000},
+		{"Mbit", 1000 * 1000},
+		{"Kbit", 1000},
+		{"bit", 1},
+	}
+
+	for _, m := range multipliers {
+		if !strings.HasSuffix(rate, m.suffix) {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSuffix(rate, m.suffix), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return value * m.bps / 1000, true
+	}
+	return 0, false
+}
+
+func kbit(kbps uint64) string {
+	return fmt.Sprintf("%dkbit", kbps)
+}
+
+// shapingBurst returns burst allowing 100ms of traffic at the rate.
+func shapingBurst(kbps uint64) string {
+	burst := kbps * 1000 / 8 / 10
+	if burst < minShapingBurst {
+		burst = minShapingBurst
+	}
+	return fmt.Sprintf("%db", burst)
+}
+
+// EffectiveBandwidth returns limits for the endpoint and their source.
+// Directions not limited by endpoint itself are limited by the first,
+// ordered by ID, policy applied to tenant or segment of the endpoint.
+func EffectiveBandwidth(e api.EndpointBandwidth, policies []api.Policy, blocks []api.IPAMBlockResponse) (api.Bandwidth, string) {
+	result := e.Bandwidth
+	var sources []string
+	if !result.IsZero() {
+		sources = append(sources, bandwidthSourceEndpoint)
+	}
+	if result.IngressKbps != 0 && result.EgressKbps != 0 {
+		return result, bandwidthSourceEndpoint
+	}
+
+	var tenant, segment string
+	var found bool
+	for _, block := range blocks {
+		if block.CIDR.IPNet.Contains(e.IP) {
+			tenant, segment, found = block.Tenant, block.Segment, true
+			break
+		}
+	}
+	if !found {
+		return result, strings.Join(sources, ",")
+	}
+
+	sorted := make([]api.Policy, len(policies))
+	copy(sorted, policies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	for _, policy := range sorted {
+		if policy.Bandwidth == nil || !policyAppliesTo(policy, tenant, segment) {
+			continue
+		}
+
+		var used bool
+		if result.IngressKbps == 0 && policy.Bandwidth.IngressKbps != 0 {
+			result.IngressKbps, used = policy.Bandwidth.IngressKbps, true
+		}
+		if result.EgressKbps == 0 && policy.Bandwidth.EgressKbps != 0 {
+			result.EgressKbps, used = policy.Bandwidth.EgressKbps, true
+		}
+		if used {
+			sources = append(sources, "policy:"+policy.ID)
+		}
+	}
+
+	return result, strings.Join(sources, ",")
+}
+
+func policyAppliesTo(policy api.Policy, tenant, segment string) bool {
+	for _, target := range policy.AppliedTo {
+		if target.TenantID == tenant && (target.SegmentID == "" || target.SegmentID == segment) {
+			return true
+		}
+	}
+	return false
+}
+
+// BandwidthSync periodically applies bandwidth limits to endpoints
+// of the host and reports applied limits into endpoint records,
+// until context is cancelled.
+func BandwidthSync(ctx context.Context, romanaClient *client.Client, hostname string, shaper *Shaper, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		endpoints, err := romanaClient.ListEndpointBandwidth(hostname)
+		if err != nil {
+			log.Errorf("Failed to list endpoint bandwidth, %s", err)
+		} else {
+			var policies []api.Policy
+			policies, err = romanaClient.ListPolicies()
+			if err != nil {
+				log.Errorf("Failed to list policies for bandwidth limits, %s", err)
+			}
+
+			blocks := romanaClient.IPAM.ListAllBlocks()
+			for _, e := range shaper.Sync(endpoints, policies, blocks.Blocks) {
+				if err := romanaClient.SetEndpointBandwidth(e); err != nil {
+					log.Errorf("Failed to update bandwidth status of %s, %s", e.Name, err)
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
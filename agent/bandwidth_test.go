@@ -0,0 +1,152 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/common/api"
+)
+
+func TestEffectiveBandwidth(t *testing.T) {
+	_, block, _ := net.ParseCIDR("10.0.0.0/28")
+	blocks := []api.IPAMBlockResponse{
+		{Tenant: "T1", Segment: "S1", CIDR: api.IPNet{IPNet: *block}, Host: "host1"},
+	}
+	policies := []api.Policy{
+		{ID: "b", AppliedTo: []api.Endpoint{{TenantID: "T1"}}, Bandwidth: &api.Bandwidth{IngressKbps: 2000, EgressKbps: 2000}},
+		{ID: "a", AppliedTo: []api.Endpoint{{TenantID: "T1", SegmentID: "S1"}}, Bandwidth: &api.Bandwidth{IngressKbps: 1000}},
+		{ID: "c", AppliedTo: []api.Endpoint{{TenantID: "T2"}}, Bandwidth: &api.Bandwidth{EgressKbps: 5}},
+	}
+
+	cases := []struct {
+		name      string
+		endpoint  api.EndpointBandwidth
+		bandwidth api.Bandwidth
+		source    string
+	}{
+		{
+			name:      "policies",
+			endpoint:  api.EndpointBandwidth{IP: net.ParseIP("10.0.0.1")},
+			bandwidth: api.Bandwidth{IngressKbps: 1000, EgressKbps: 2000},
+			source:    "policy:a,policy:b",
+		},
+		{
+			name:      "endpoint overrides policy",
+			endpoint:  api.EndpointBandwidth{IP: net.ParseIP("10.0.0.1"), Bandwidth: api.Bandwidth{IngressKbps: 300}},
+			bandwidth: api.Bandwidth{IngressKbps: 300, EgressKbps: 2000},
+			source:    "endpoint,policy:b",
+		},
+		{
+			name:     "endpoint outside of blocks",
+			endpoint: api.EndpointBandwidth{IP: net.ParseIP("10.1.0.1")},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			bandwidth, source := EffectiveBandwidth(tc.endpoint, policies, blocks)
+			if bandwidth != tc.bandwidth || source != tc.source {
+				t.Fatalf("expected %+v from %q, got %+v from %q", tc.bandwidth, tc.source, bandwidth, source)
+			}
+		})
+	}
+}
+
+func TestShaperSync(t *testing.T) {
+	exec := &utilexec.FakeExecutor{}
+	shaper := NewShaper(exec)
+
+	endpoint := api.EndpointBandwidth{
+		Name:      "pod1",
+		Interface: "romana-abc",
+		IP:        net.ParseIP("10.0.0.1"),
+		Bandwidth: api.Bandwidth{IngressKbps: 1000, EgressKbps: 512},
+	}
+
+	updated := shaper.Sync([]api.EndpointBandwidth{endpoint}, nil, nil)
+	if len(updated) != 1 || updated[0].Status == nil || updated[0].Status.Applied != endpoint.Bandwidth {
+		t.Fatalf("expected status with applied limits, got %+v", updated)
+	}
+	for _, expect := range []string{
+		"tc qdisc replace dev romana-abc root tbf rate 1000kbit burst 16384b latency 50ms",
+		"tc filter replace dev romana-abc ingress pref 49153 matchall action police rate 512kbit burst 16384b conform-exceed drop",
+	} {
+		if !strings.Contains(*exec.Commands, expect) {
+			t.Fatalf("expected %q in commands\n%s", expect, *exec.Commands)
+		}
+	}
+
+	// unchanged limits and status must not touch anything.
+	exec.Commands = nil
+	if updated := shaper.Sync(updated, nil, nil); len(updated) != 0 || exec.Commands != nil {
+		t.Fatalf("expected no changes, got %+v, commands %v", updated, exec.Commands)
+	}
+
+	endpoint.Bandwidth.EgressKbps = 0
+	endpoint.Status = updated[0].Status
+	updated = shaper.Sync([]api.EndpointBandwidth{endpoint}, nil, nil)
+	if len(updated) != 1 || *exec.Commands != "tc filter del dev romana-abc ingress pref 49153" {
+		t.Fatalf("expected egress limit to be removed, got %+v, commands %v", updated, exec.Commands)
+	}
+}
+
+// tcExecutor returns output of tc commands by their arguments.
+type tcExecutor struct {
+	utilexec.FakeExecutor
+	outputs map[string]string
+}
+
+func (x *tcExecutor) Exec(cmd string, args []string) ([]byte, error) {
+	x.FakeExecutor.Exec(cmd, args)
+	return []byte(x.outputs[strings.Join(args, " ")]), nil
+}
+
+func TestNewShaperLoadsApplied(t *testing.T) {
+	exec := &tcExecutor{outputs: map[string]string{
+		"qdisc show": "qdisc noqueue 0: dev lo root refcnt 2\n" +
+			"qdisc tbf 8001: dev romana-abc root refcnt 2 rate 1Mbit burst 16Kb lat 50.0ms\n" +
+			"qdisc clsact ffff: dev romana-abc parent ffff:fff1\n" +
+			"qdisc clsact ffff: dev romana-def parent ffff:fff1\n",
+		"filter show dev romana-abc ingress pref 49153": "filter protocol all pref 49153 matchall chain 0\n" +
+			"filter protocol all pref 49153 matchall chain 0 handle 0x1\n" +
+			"  not_in_hw\n" +
+			"\taction order 1:  police 0x1 rate 512Kbit burst 16Kb mtu 2Kb action drop overhead 0b\n",
+	}}
+	shaper := NewShaper(exec)
+
+	expect := map[string]api.Bandwidth{"romana-abc": {IngressKbps: 1000, EgressKbps: 512}}
+	if !reflect.DeepEqual(shaper.applied, expect) {
+		t.Fatalf("expected applied limits %v, got %v", expect, shaper.applied)
+	}
+
+	// limits removed while agent wasn't running are deleted.
+	exec.Commands = nil
+	endpoint := api.EndpointBandwidth{Name: "pod1", Interface: "romana-abc", IP: net.ParseIP("10.0.0.1")}
+	shaper.Sync([]api.EndpointBandwidth{endpoint}, nil, nil)
+	for _, expect := range []string{
+		"tc qdisc del dev romana-abc root",
+		"tc filter del dev romana-abc ingress pref 49153",
+	} {
+		if exec.Commands == nil || !strings.Contains(*exec.Commands, expect) {
+			t.Fatalf("expected %q in commands\n%v", expect, exec.Commands)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"bufio"
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FlagFile sets command line flags from a file, so that agent
+// configuration can be changed and re-read without restart.
+// The file contains one flag per line in the form name=value,
+// leading dashes are optional, empty lines and lines starting
+// with # are ignored.
+type FlagFile struct {
+	Path string
+
+	fs *flag.FlagSet
+
+	// flags that were set on command line, these take
+	// precedence over values from the file.
+	cmdline map[string]bool
+
+	// flags that were set from the file by the previous Load.
+	fromFile map[string]bool
+}
+
+// NewFlagFile returns FlagFile for the flag set,
+// must be called after flags are parsed.
+func NewFlagFile(path string, fs *flag.FlagSet) *FlagFile {
+	f := &FlagFile{
+		Path:     path,
+		fs:       fs,
+		cmdline:  make(map[string]bool),
+		fromFile: make(map[string]bool),
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		f.cmdline[fl.Name] = true
+	})
+	return f
+}
+
+// Load reads the file and sets flags from it. Flags that were set
+// by previous Load but are no longer in the file are reset to their
+// default values. If allowed is not nil, changing a flag it returns
+// false for is an error. New values are parsed into a copy of the
+// flag set which is passed to validate if it's not nil, flags are
+// only changed when both succeed. Returns names of the flags which
+// values have changed.
+func (f *FlagFile) Load(allowed func(name string) bool, validate func(fs *flag.FlagSet) error) ([]string, error) {
+	values, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range f.fromFile {
+		if _, ok := values[name]; !ok {
+			values[name] = f.fs.Lookup(name).DefValue
+		}
+	}
+
+	var changed []string
+	for name, value := range values {
+		fl := f.fs.Lookup(name)
+		if fl == nil {
+			return nil, errors.Errorf("unknown flag %s in %s", name, f.Path)
+		}
+
+		if f.cmdline[name] {
+			continue
+		}
+
+		if fl.Value.String() == value {
+			continue
+		}
+
+		if allowed != nil && !allowed(name) {
+			return nil, errors.Errorf("flag %s can not be changed without restart", name)
+		}
+
+		changed = append(changed, name)
+	}
+
+	next, err := copyFlagSet(f.fs)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range changed {
+		if err := next.Set(name, values[name]); err != nil {
+			return nil, errors.Wrapf(err, "failed to set flag %s from %s", name, f.Path)
+		}
+	}
+	if validate != nil {
+		if err := validate(next); err != nil {
+			return nil, errors.Wrapf(err, "invalid configuration in %s", f.Path)
+		}
+	}
+
+	for _, name := range changed {
+		if err := f.fs.Set(name, values[name]); err != nil {
+			return nil, errors.Wrapf(err, "failed to set flag %s from %s", name, f.Path)
+		}
+	}
+
+	f.fromFile = make(map[string]bool)
+	for name := range values {
+		if !f.cmdline[name] && values[name] != f.fs.Lookup(name).DefValue {
+			f.fromFile[name] = true
+		}
+	}
+
+	return changed, nil
+}
+
+// copyFlagSet returns a flag set with the same flags and values
+// as fs, which doesn't share storage of the values with fs.
+// Values of the flags must be pointers, as all values of
+// the standard flag package are.
+func copyFlagSet(fs *flag.FlagSet) (*flag.FlagSet, error) {
+	result := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	var err error
+	fs.VisitAll(func(fl *flag.Flag) {
+		if err != nil {
+			return
+		}
+
+		t := reflect.TypeOf(fl.Value)
+		if t.Kind() != reflect.Ptr {
+			err = errors.Errorf("can not copy value of flag %s", fl.Name)
+			return
+		}
+		value, ok := reflect.New(t.Elem()).Interface().(flag.Value)
+		if !ok {
+			err = errors.Errorf("can not copy value of flag %s", fl.Name)
+			return
+		}
+		if err = value.Set(fl.Value.String()); err != nil {
+			err = errors.Wrapf(err, "failed to copy flag %s", fl.Name)
+			return
+		}
+		result.Var(value, fl.Name, fl.Usage)
+	})
+	return result, err
+}
+
+// read parses the file into a map of flag values.
+func (f *FlagFile) read() (map[string]string, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open config file %s", f.Path)
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("%s:%d expected name=value, got %q", f.Path, lineNum, line)
+		}
+
+		name := strings.TrimLeft(strings.TrimSpace(parts[0]), "-")
+		values[name] = strings.TrimSpace(parts[1])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read config file %s", f.Path)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFlagFileLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romana-agent-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agent.conf")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	endpoints := fs.String("endpoints", "", "")
+	hostname := fs.String("hostname", "", "")
+	multihop := fs.Bool("multihop-blocks", false, "")
+	refresh := fs.Int("policy-refresh", 10, "")
+	if err := fs.Parse([]string{"-hostname", "host1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloadable := func(name string) bool {
+		return name != "hostname"
+	}
+
+	write := func(lines ...string) {
+		if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flagFile := NewFlagFile(path, fs)
+
+	write("# agent config", "", "-endpoints=10.0.0.1:2379", "multihop-blocks = true", "hostname=host2")
+	changed, err := flagFile.Load(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(changed)
+	if strings.Join(changed, ",") != "endpoints,multihop-blocks" {
+		t.Fatalf("unexpected changed flags %v", changed)
+	}
+	if *endpoints != "10.0.0.1:2379" || !*multihop || *hostname != "host1" {
+		t.Fatalf("unexpected flag values endpoints=%s multihop=%t hostname=%s", *endpoints, *multihop, *hostname)
+	}
+
+	t.Run("removed flag is reset to default", func(t *testing.T) {
+		write("endpoints=10.0.0.1:2379", "policy-refresh=5")
+		changed, err := flagFile.Load(reloadable, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(changed)
+		if strings.Join(changed, ",") != "multihop-blocks,policy-refresh" || *multihop || *refresh != 5 {
+			t.Fatalf("unexpected result changed=%v multihop=%t refresh=%d", changed, *multihop, *refresh)
+		}
+	})
+
+	t.Run("unknown flag", func(t *testing.T) {
+		write("no-such-flag=1")
+		if _, err := flagFile.Load(reloadable, nil); err == nil {
+			t.Fatal("expected error for unknown flag")
+		}
+	})
+
+	t.Run("not reloadable", func(t *testing.T) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		hostname := fs.String("hostname", "host1", "")
+		flagFile := NewFlagFile(path, fs)
+		write("hostname=host2")
+		if _, err := flagFile.Load(reloadable, nil); err == nil || *hostname != "host1" {
+			t.Fatalf("expected error and no change, got err=%v hostname=%s", err, *hostname)
+		}
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		write("endpoints=10.0.0.2:2379", "policy-refresh=never")
+		if _, err := flagFile.Load(reloadable, nil); err == nil || *endpoints != "10.0.0.1:2379" {
+			t.Fatalf("expected error and no change, got err=%v endpoints=%s", err, *endpoints)
+		}
+	})
+
+	t.Run("validation fails", func(t *testing.T) {
+		write("endpoints=10.0.0.2:2379", "policy-refresh=0")
+		validate := func(fs *flag.FlagSet) error {
+			if fs.Lookup("endpoints").Value.String() != "10.0.0.2:2379" {
+				t.Errorf("expected new endpoints in validated flags")
+			}
+			if fs.Lookup("policy-refresh").Value.String() == "0" {
+				return errors.New("policy-refresh must be positive")
+			}
+			return nil
+		}
+		if _, err := flagFile.Load(reloadable, validate); err == nil || *endpoints != "10.0.0.1:2379" || *refresh != 5 {
+			t.Fatalf("expected error and no change, got err=%v endpoints=%s refresh=%d", err, *endpoints, *refresh)
+		}
+	})
+
+	t.Run("malformed line", func(t *testing.T) {
+		write("endpoints")
+		if _, err := flagFile.Load(reloadable, nil); err == nil {
+			t.Fatal("expected error for malformed line")
+		}
+	})
+}
@@ -0,0 +1,571 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/pkg/policytools"
+
+	log "github.com/romana/rlog"
+	"github.com/vishvananda/netlink"
+)
+
+// Experimental eBPF backend. BPFProvider attaches a TC classifier
+// to both directions of every endpoint veth, the classifier drops
+// IPv4 packets of flows that no policy allows. Allowed flows are
+// kept in two pinned LPM tries, their layout is described in the
+// classifier source bpf/romana_policy.c, which is built with
+// `make bpf`. The maps are updated incrementally, only entries
+// that changed since previous Program call are written.
+
+const (
+	// DefaultBPFObject is a location of compiled TC classifier.
+	DefaultBPFObject = "/usr/lib/romana/romana_policy.o"
+
+	// DefaultBPFMapDir is where classifier pins its maps.
+	DefaultBPFMapDir = "/sys/fs/bpf/tc/globals"
+
+	// bpfEndpointsMap maps local networks to rule sets.
+	bpfEndpointsMap = "romana_endpoints"
+
+	// bpfRulesMap maps rule set and peer network to port ranges.
+	bpfRulesMap = "romana_rules"
+
+	// bpfMaxRanges is a number of port ranges in the value of
+	// romana_rules, must match ROMANA_MAX_RANGES of the classifier.
+	bpfMaxRanges = 16
+
+	// endpointLinkPrefix is a prefix of host side of endpoint veths.
+	endpointLinkPrefix = "romana-"
+)
+
+var (
+	TcBin      = "tc"
+	BpftoolBin = "bpftool"
+)
+
+// BPFProvider implements FirewallProvider with TC eBPF classifier.
+type BPFProvider struct {
+	exec utilexec.Executable
+
+	// ObjectPath is a TC classifier object to attach.
+	ObjectPath string
+
+	// MapDir is a directory with pinned maps of the classifier.
+	MapDir string
+
+	// links returns names of endpoint interfaces.
+	links func() ([]string, error)
+
+	// interfaces with classifier attached.
+	attached map[string]bool
+
+	// map entries installed by previous Program call.
+	tables bpfTables
+
+	// IDs of rule sets by direction and local network.
+	sets    map[string]uint32
+	nextSet uint32
+}
+
+// NewBPFProvider returns BPFProvider using default locations
+// of classifier object and maps.
+func NewBPFProvider(exec utilexec.Executable) *BPFProvider {
+	return &BPFProvider{
+		exec:       exec,
+		ObjectPath: DefaultBPFObject,
+		MapDir:     DefaultBPFMapDir,
+		links:      listEndpointLinks,
+		attached:   make(map[string]bool),
+		tables:     newBPFTables(),
+		sets:       make(map[string]uint32),
+	}
+}
+
+// Program implements FirewallProvider.
+func (p *BPFProvider) Program(ctx context.Context, state DesiredState) error {
+	links, err := p.links()
+	if err != nil {
+		return errors.Wrap(err, "failed to list endpoint interfaces")
+	}
+
+	current := make(map[string]bool)
+	for _, link := range links {
+		current[link] = true
+		if p.attached[link] {
+			continue
+		}
+		if err := p.attach(link); err != nil {
+			return err
+		}
+		p.attached[link] = true
+	}
+	for link := range p.attached {
+		if !current[link] {
+			delete(p.attached, link)
+		}
+	}
+
+	// maps are created when classifier is attached first time.
+	if len(p.attached) == 0 {
+		log.Tracef(5, "BPF provider has no endpoint interfaces to program")
+		return nil
+	}
+
+	rules := compileBPFRules(state.Policies.List(), state.Blocks, state.Hostname)
+	desired := buildBPFTables(rules, p.setID)
+
+	// new rule sets are written before endpoints referring
+	// to them, and removed after endpoints stop referring.
+	if err := p.updateMap(bpfRulesMap, p.tables.rules, desired.rules); err != nil {
+		return err
+	}
+	if err := p.updateMap(bpfEndpointsMap, p.tables.endpoints, desired.endpoints); err != nil {
+		return err
+	}
+	if err := p.deleteFromMap(bpfEndpointsMap, p.tables.endpoints, desired.endpoints); err != nil {
+		return err
+	}
+	if err := p.deleteFromMap(bpfRulesMap, p.tables.rules, desired.rules); err != nil {
+		return err
+	}
+
+	for key, id := range p.sets {
+		if !desired.sets[id] {
+			delete(p.sets, key)
+		}
+	}
+
+	log.Tracef(5, "BPF provider programmed %d endpoint and %d rule entries on %d interfaces",
+		len(p.tables.endpoints), len(p.tables.rules), len(p.attached))
+	NumPolicyRules.Set(float64(len(p.tables.rules)))
+
+	return nil
+}
+
+// setID returns ID of the rule set, IDs are kept while
+// rule set is in use, so its entries are updated in place.
+func (p *BPFProvider) setID(name string) uint32 {
+	if id, ok := p.sets[name]; ok {
+		return id
+	}
+	p.nextSet++
+	p.sets[name] = p.nextSet
+	return p.nextSet
+}
+
+// updateMap writes entries of desired that differ from installed.
+func (p *BPFProvider) updateMap(name string, installed, desired map[string]string) error {
+	for key, value := range desired {
+		if old, ok := installed[key]; ok && old == value {
+			continue
+		}
+		args := append(p.mapArgs("update", name, key), "value", "hex")
+		args = append(args, strings.Fields(value)...)
+		if _, err := p.exec.Exec(BpftoolBin, args); err != nil {
+			return errors.Wrapf(err, "failed to update %s in bpf map %s", key, name)
+		}
+		installed[key] = value
+	}
+	return nil
+}
+
+// deleteFromMap deletes installed entries that are not in desired.
+func (p *BPFProvider) deleteFromMap(name string, installed, desired map[string]string) error {
+	for key := range installed {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		if _, err := p.exec.Exec(BpftoolBin, p.mapArgs("delete", name, key)); err != nil {
+			return errors.Wrapf(err, "failed to delete %s from bpf map %s", key, name)
+		}
+		delete(installed, key)
+	}
+	return nil
+}
+
+// attach installs classifier on both directions of the interface.
+func (p *BPFProvider) attach(link string) error {
+	if _, err := p.exec.Exec(TcBin, []string{"qdisc", "replace", "dev", link, "clsact"}); err != nil {
+		return errors.Wrapf(err, "failed to add clsact qdisc to %s", link)
+	}
+
+	// ingress of the host side of veth is traffic from endpoint.
+	for _, hook := range []struct{ direction, section string }{
+		{"ingress", "from_endpoint"},
+		{"egress", "to_endpoint"},
+	} {
+		args := []string{"filter", "replace", "dev", link, hook.direction, "bpf", "da", "obj", p.ObjectPath, "sec", hook.section}
+		if _, err := p.exec.Exec(TcBin, args); err != nil {
+			return errors.Wrapf(err, "failed to attach bpf classifier to %s %s", link, hook.direction)
+		}
+	}
+
+	return nil
+}
+
+func (p *BPFProvider) mapArgs(op, name, key string) []string {
+	args := []string{"map", op, "pinned", filepath.Join(p.MapDir, name), "key", "hex"}
+	return append(args, strings.Fields(key)...)
+}
+
+// Current implements FirewallProvider, returns dump of the policy maps.
+func (p *BPFProvider) Current() (string, error) {
+	var result []string
+	for _, name := range []string{bpfEndpointsMap, bpfRulesMap} {
+		out, err := p.exec.Exec(BpftoolBin, []string{"map", "dump", "pinned", filepath.Join(p.MapDir, name)})
+		if err != nil {
+			return "", err
+		}
+		result = append(result, name, string(out))
+	}
+	return strings.Join(result, "\n"), nil
+}
+
+// Cleanup implements FirewallProvider.
+func (p *BPFProvider) Cleanup(ctx context.Context) error {
+	links, err := p.links()
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		if _, err := p.exec.Exec(TcBin, []string{"qdisc", "del", "dev", link, "clsact"}); err != nil {
+			log.Errorf("Failed to remove bpf classifier from %s, %s", link, err)
+		}
+	}
+
+	empty := newBPFTables()
+	if err := p.deleteFromMap(bpfEndpointsMap, p.tables.endpoints, empty.endpoints); err != nil {
+		return err
+	}
+	if err := p.deleteFromMap(bpfRulesMap, p.tables.rules, empty.rules); err != nil {
+		return err
+	}
+
+	p.attached = make(map[string]bool)
+	p.sets = make(map[string]uint32)
+	return nil
+}
+
+// listEndpointLinks returns names of the host side of endpoint veths.
+func listEndpointLinks() ([]string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, link := range links {
+		name := link.Attrs().Name
+		if strings.HasPrefix(name, endpointLinkPrefix) && name != "romana-gw" {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// bpfPorts is a protocol and a range of destination ports,
+// zero protocol matches any.
+type bpfPorts struct {
+	Proto   uint8
+	PortMin uint16
+	PortMax uint16
+}
+
+// bpfRule allows traffic between local and peer networks
+// in the direction of the policy it comes from.
+type bpfRule struct {
+	Ingress bool
+	Local   net.IPNet
+	Peer    net.IPNet
+	Ports   bpfPorts
+}
+
+func (r bpfRule) String() string {
+	direction := "egress"
+	if r.Ingress {
+		direction = "ingress"
+	}
+	return fmt.Sprintf("%s local=%s peer=%s proto=%d ports=%d-%d",
+		direction, &r.Local, &r.Peer, r.Ports.Proto, r.Ports.PortMin, r.Ports.PortMax)
+}
+
+var protocolNumbers = map[string]uint8{
+	"":     0,
+	"any":  0,
+	"icmp": 1,
+	"tcp":  6,
+	"udp":  17,
+	"sctp": 132,
+}
+
+// compileBPFRules translates policies into rules of local networks.
+func compileBPFRules(policies []api.Policy, blocks []api.IPAMBlockResponse, hostname string) []bpfRule {
+	var result []bpfRule
+
+	for _, policy := range policies {
+		var targets []net.IPNet
+		for _, target := range policy.AppliedTo {
+			targets = append(targets, targetNets(target, blocks, hostname)...)
+		}
+
+		for _, ingress := range policy.Ingress {
+			var peers []net.IPNet
+			for _, peer := range ingress.Peers {
+				peers = append(peers, peerNets(peer, blocks)...)
+			}
+
+			rules := ingress.Rules
+			if len(rules) == 0 {
+				rules = []api.Rule{{}}
+			}
+
+			for _, rule := range rules {
+				proto, ok := protocolNumbers[strings.ToLower(rule.Protocol)]
+				if !ok {
+					log.Errorf("BPF provider skips rule with unsupported protocol %s in policy %s", rule.Protocol, policy.ID)
+					continue
+				}
+
+				for _, ports := range rulePortRanges(rule) {
+					if ports[0] > ports[1] || ports[1] > 65535 {
+						log.Errorf("BPF provider skips invalid port range %d-%d in policy %s", ports[0], ports[1], policy.ID)
+						continue
+					}
+					for _, target := range targets {
+						for _, peer := range peers {
+							if target.IP.To4() == nil || peer.IP.To4() == nil {
+								continue
+							}
+							result = append(result, bpfRule{
+								Ingress: policy.Direction != api.PolicyDirectionEgress,
+								Local:   target,
+								Peer:    peer,
+								Ports:   bpfPorts{Proto: proto, PortMin: uint16(ports[0]), PortMax: uint16(ports[1])},
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// peerNets returns networks selected by policy peer.
+func peerNets(peer api.Endpoint, blocks []api.IPAMBlockResponse) []net.IPNet {
+	switch policytools.DetectPolicyPeerType(peer) {
+	case policytools.PeerAny:
+		return []net.IPNet{{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}}
+	case policytools.PeerCIDR:
+		_, ipnet, err := net.ParseCIDR(peer.Cidr)
+		if err != nil {
+			log.Errorf("BPF provider skips peer with invalid cidr %s", peer.Cidr)
+			return nil
+		}
+		return []net.IPNet{*ipnet}
+	case policytools.PeerTenant, policytools.PeerTenantSegment:
+		return targetNets(api.Endpoint{TenantID: peer.TenantID, SegmentID: peer.SegmentID}, blocks, "")
+	}
+
+	log.Tracef(5, "BPF provider doesn't support peer %s", peer)
+	return nil
+}
+
+// rulePortRanges returns port ranges of the rule, rule without
+// ports allows all of them.
+func rulePortRanges(rule api.Rule) []api.PortRange {
+	var result []api.PortRange
+	for _, port := range rule.Ports {
+		result = append(result, api.PortRange{port, port})
+	}
+	result = append(result, rule.PortRanges...)
+	if len(result) == 0 {
+		result = append(result, api.PortRange{0, 65535})
+	}
+	return result
+}
+
+// bpfTables holds entries of the classifier maps,
+// keys and values are hex bytes as bpftool takes them.
+type bpfTables struct {
+	endpoints map[string]string
+	rules     map[string]string
+
+	// IDs of rule sets in use.
+	sets map[uint32]bool
+}
+
+func newBPFTables() bpfTables {
+	return bpfTables{
+		endpoints: make(map[string]string),
+		rules:     make(map[string]string),
+		sets:      make(map[uint32]bool),
+	}
+}
+
+// buildBPFTables makes entries of the classifier maps. Entries of
+// a network include rules of all networks containing it, since
+// the classifier only looks up the longest match.
+func buildBPFTables(rules []bpfRule, setID func(name string) uint32) bpfTables {
+	tables := newBPFTables()
+
+	locals := make(map[string]net.IPNet)
+	for _, rule := range rules {
+		locals[rule.Local.String()] = rule.Local
+	}
+
+	for name, local := range locals {
+		var ids [2]uint32
+		for i, ingress := range []bool{true, false} {
+			var matched []bpfRule
+			for _, rule := range rules {
+				if rule.Ingress == ingress && netContains(rule.Local, local) {
+					matched = append(matched, rule)
+				}
+			}
+			if len(matched) == 0 {
+				continue
+			}
+
+			direction := "egress"
+			if ingress {
+				direction = "ingress"
+			}
+			ids[i] = setID(direction + " " + name)
+			tables.sets[ids[i]] = true
+
+			peers := make(map[string]net.IPNet)
+			for _, rule := range matched {
+				peers[rule.Peer.String()] = rule.Peer
+			}
+			for _, peer := range peers {
+				var ports []bpfPorts
+				for _, rule := range matched {
+					if netContains(rule.Peer, peer) {
+						ports = append(ports, rule.Ports)
+					}
+				}
+				tables.rules[bpfRuleKey(ids[i], peer)] = bpfRuleValue(ports)
+			}
+		}
+		tables.endpoints[bpfEndpointKey(local)] = bpfEndpointValue(ids[0], ids[1])
+	}
+
+	return tables
+}
+
+// netContains returns true if network a contains network b.
+func netContains(a, b net.IPNet) bool {
+	aLen, _ := a.Mask.Size()
+	bLen, _ := b.Mask.Size()
+	return aLen <= bLen && a.Contains(b.IP)
+}
+
+// bpfEndpointKey is struct endpoint_key of the classifier.
+func bpfEndpointKey(local net.IPNet) string {
+	ones, _ := local.Mask.Size()
+	b := make([]byte, 8)
+	nativeEndian.PutUint32(b[0:4], uint32(ones))
+	copy(b[4:8], local.IP.To4().Mask(local.Mask))
+	return hexBytes(b)
+}
+
+// bpfEndpointValue is struct endpoint_value of the classifier.
+func bpfEndpointValue(ingress, egress uint32) string {
+	b := make([]byte, 8)
+	nativeEndian.PutUint32(b[0:4], ingress)
+	nativeEndian.PutUint32(b[4:8], egress)
+	return hexBytes(b)
+}
+
+// bpfRuleKey is struct rule_key of the classifier.
+func bpfRuleKey(set uint32, peer net.IPNet) string {
+	ones, _ := peer.Mask.Size()
+	b := make([]byte, 12)
+	nativeEndian.PutUint32(b[0:4], uint32(32+ones))
+	nativeEndian.PutUint32(b[4:8], set)
+	copy(b[8:12], peer.IP.To4().Mask(peer.Mask))
+	return hexBytes(b)
+}
+
+// bpfRuleValue is struct rule_value of the classifier, port
+// ranges that don't fit into it are dropped.
+func bpfRuleValue(ports []bpfPorts) string {
+	seen := make(map[bpfPorts]bool)
+	var unique []bpfPorts
+	for _, p := range ports {
+		if !seen[p] {
+			seen[p] = true
+			unique = append(unique, p)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		a, b := unique[i], unique[j]
+		if a.Proto != b.Proto {
+			return a.Proto < b.Proto
+		}
+		if a.PortMin != b.PortMin {
+			return a.PortMin < b.PortMin
+		}
+		return a.PortMax < b.PortMax
+	})
+	if len(unique) > bpfMaxRanges {
+		log.Errorf("BPF provider drops %d port ranges over the limit of %d", len(unique)-bpfMaxRanges, bpfMaxRanges)
+		unique = unique[:bpfMaxRanges]
+	}
+
+	b := make([]byte, 4+6*bpfMaxRanges)
+	nativeEndian.PutUint32(b[0:4], uint32(len(unique)))
+	for i, p := range unique {
+		offset := 4 + 6*i
+		b[offset] = p.Proto
+		nativeEndian.PutUint16(b[offset+2:offset+4], p.PortMin)
+		nativeEndian.PutUint16(b[offset+4:offset+6], p.PortMax)
+	}
+	return hexBytes(b)
+}
+
+func hexBytes(b []byte) string {
+	var result []string
+	for _, c := range b {
+		result = append(result, fmt.Sprintf("%02x", c))
+	}
+	return strings.Join(result, " ")
+}
+
+// nativeEndian is a byte order of the host, numbers in BPF maps
+// other than addresses use it.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
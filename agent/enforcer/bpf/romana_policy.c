@@ -0,0 +1,236 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// TC classifier of the bpf firewall provider of romana agent,
+// built with `make bpf`. It's attached to both directions of
+// endpoint veths and drops IPv4 packets of flows that no policy
+// allows, other packets are passed.
+//
+// Policies are kept in two LPM tries filled by the agent:
+//
+// romana_endpoints maps local networks to the IDs of rule sets
+// of policies applied to them, one for ingress and one for egress.
+//
+// romana_rules maps a rule set ID and a peer network to the list
+// of protocols and port ranges allowed between them. Ports are
+// those of the destination of the flow: the local port for ingress
+// and the peer port for egress.
+//
+// The agent merges rules of all networks containing a network
+// into its own entries, so the longest match is the only lookup.
+//
+// The classifier is stateless, a packet is allowed when either
+// direction of its flow is, so replies to allowed flows pass.
+
+#include <linux/bpf.h>
+#include <linux/if_ether.h>
+#include <linux/in.h>
+#include <linux/ip.h>
+#include <linux/pkt_cls.h>
+#include <linux/types.h>
+
+#define SEC(name) __attribute__((section(name), used))
+#define ALWAYS_INLINE inline __attribute__((always_inline))
+
+#if __BYTE_ORDER__ == __ORDER_LITTLE_ENDIAN__
+#define romana_ntohs(x) __builtin_bswap16(x)
+#define romana_htons(x) __builtin_bswap16(x)
+#else
+#define romana_ntohs(x) (x)
+#define romana_htons(x) (x)
+#endif
+
+// must be kept in sync with bpfMaxRanges in bpf.go.
+#define ROMANA_MAX_RANGES 16
+
+#define IP_OFFSET 0x1fff
+
+// PIN_GLOBAL_NS pins maps in /sys/fs/bpf/tc/globals.
+#define PIN_GLOBAL_NS 2
+
+static void *(*bpf_map_lookup_elem)(void *map, const void *key) =
+	(void *)BPF_FUNC_map_lookup_elem;
+
+// bpf_elf_map is a map definition read by the tc object loader.
+struct bpf_elf_map {
+	__u32 type;
+	__u32 size_key;
+	__u32 size_value;
+	__u32 max_elem;
+	__u32 flags;
+	__u32 id;
+	__u32 pinning;
+	__u32 inner_id;
+	__u32 inner_idx;
+};
+
+struct endpoint_key {
+	__u32 prefixlen;
+	__u32 addr;
+};
+
+// rule set IDs, 0 means no rules.
+struct endpoint_value {
+	__u32 ingress;
+	__u32 egress;
+};
+
+// prefixlen covers the whole set and the peer prefix.
+struct rule_key {
+	__u32 prefixlen;
+	__u32 set;
+	__u32 peer;
+};
+
+// proto 0 matches any protocol, ports are in host byte order
+// and are 0 for protocols without ports.
+struct port_range {
+	__u8 proto;
+	__u8 pad;
+	__u16 min;
+	__u16 max;
+};
+
+struct rule_value {
+	__u32 count;
+	struct port_range ranges[ROMANA_MAX_RANGES];
+};
+
+struct bpf_elf_map SEC("maps") romana_endpoints = {
+	.type = BPF_MAP_TYPE_LPM_TRIE,
+	.size_key = sizeof(struct endpoint_key),
+	.size_value = sizeof(struct endpoint_value),
+	.max_elem = 4096,
+	.flags = BPF_F_NO_PREALLOC,
+	.pinning = PIN_GLOBAL_NS,
+};
+
+struct bpf_elf_map SEC("maps") romana_rules = {
+	.type = BPF_MAP_TYPE_LPM_TRIE,
+	.size_key = sizeof(struct rule_key),
+	.size_value = sizeof(struct rule_value),
+	.max_elem = 65536,
+	.flags = BPF_F_NO_PREALLOC,
+	.pinning = PIN_GLOBAL_NS,
+};
+
+static ALWAYS_INLINE int allowed(__u32 set, __u32 peer, __u8 proto, __u16 port)
+{
+	struct rule_key key = {
+		.prefixlen = 64,
+		.set = set,
+		.peer = peer,
+	};
+	struct rule_value *value;
+	int i;
+
+	if (set == 0)
+		return 0;
+
+	value = bpf_map_lookup_elem(&romana_rules, &key);
+	if (!value)
+		return 0;
+
+#pragma unroll
+	for (i = 0; i < ROMANA_MAX_RANGES; i++) {
+		struct port_range *r = &value->ranges[i];
+
+		if (i >= value->count)
+			break;
+		if (r->proto && r->proto != proto)
+			continue;
+		if (port >= r->min && port <= r->max)
+			return 1;
+	}
+
+	return 0;
+}
+
+static ALWAYS_INLINE int classify(struct __sk_buff *skb, int from_endpoint)
+{
+	void *data = (void *)(long)skb->data;
+	void *data_end = (void *)(long)skb->data_end;
+	struct ethhdr *eth = data;
+	struct iphdr *ip;
+	struct endpoint_key key = { .prefixlen = 32 };
+	struct endpoint_value *sets;
+	__u16 sport = 0, dport = 0;
+	__u16 local_port, peer_port;
+	__u32 peer;
+
+	if ((void *)(eth + 1) > data_end)
+		return TC_ACT_OK;
+	if (eth->h_proto != romana_htons(ETH_P_IP))
+		return TC_ACT_OK;
+
+	ip = (void *)(eth + 1);
+	if ((void *)(ip + 1) > data_end || ip->ihl < 5)
+		return TC_ACT_SHOT;
+
+	switch (ip->protocol) {
+	case IPPROTO_TCP:
+	case IPPROTO_UDP:
+	case IPPROTO_SCTP: {
+		__u16 *ports = (void *)ip + ip->ihl * 4;
+
+		// later fragments carry no ports and are matched as port 0.
+		if (ip->frag_off & romana_htons(IP_OFFSET))
+			break;
+		if ((void *)(ports + 2) > data_end)
+			return TC_ACT_SHOT;
+		sport = romana_ntohs(ports[0]);
+		dport = romana_ntohs(ports[1]);
+		break;
+	}
+	}
+
+	if (from_endpoint) {
+		key.addr = ip->saddr;
+		peer = ip->daddr;
+		local_port = sport;
+		peer_port = dport;
+	} else {
+		key.addr = ip->daddr;
+		peer = ip->saddr;
+		local_port = dport;
+		peer_port = sport;
+	}
+
+	sets = bpf_map_lookup_elem(&romana_endpoints, &key);
+	if (!sets)
+		return TC_ACT_SHOT;
+
+	if (allowed(sets->ingress, peer, ip->protocol, local_port) ||
+	    allowed(sets->egress, peer, ip->protocol, peer_port))
+		return TC_ACT_OK;
+
+	return TC_ACT_SHOT;
+}
+
+// tc ingress of the host side of a veth sees packets sent by endpoint.
+SEC("from_endpoint")
+int romana_from_endpoint(struct __sk_buff *skb)
+{
+	return classify(skb, 1);
+}
+
+// tc egress of the host side of a veth sees packets sent to endpoint.
+SEC("to_endpoint")
+int romana_to_endpoint(struct __sk_buff *skb)
+{
+	return classify(skb, 0);
+}
+
+char _license[] SEC("license") = "Apache-2.0";
@@ -0,0 +1,207 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/agent/policycache"
+	"github.com/romana/core/common/api"
+)
+
+func TestBPFEncoding(t *testing.T) {
+	native := func(n uint32) string {
+		b := make([]byte, 4)
+		nativeEndian.PutUint32(b, n)
+		return hexBytes(b)
+	}
+
+	_, local, _ := net.ParseCIDR("10.0.0.5/28")
+	if got, expect := bpfEndpointKey(*local), native(28)+" 0a 00 00 00"; got != expect {
+		t.Errorf("expected endpoint key %s, got %s", expect, got)
+	}
+
+	_, peer, _ := net.ParseCIDR("10.1.0.0/16")
+	if got, expect := bpfRuleKey(3, *peer), native(48)+" "+native(3)+" 0a 01 00 00"; got != expect {
+		t.Errorf("expected rule key %s, got %s", expect, got)
+	}
+
+	value := strings.Fields(bpfRuleValue([]bpfPorts{{6, 443, 443}, {6, 80, 80}, {6, 443, 443}}))
+	if len(value) != 4+6*bpfMaxRanges {
+		t.Fatalf("expected rule value of %d bytes, got %d", 4+6*bpfMaxRanges, len(value))
+	}
+	// duplicates are dropped and ranges are sorted.
+	if got, expect := strings.Join(value[:4], " "), native(2); got != expect {
+		t.Errorf("expected count %s, got %s", expect, got)
+	}
+	if value[4] != "06" || value[10] != "06" || value[16] != "00" {
+		t.Errorf("unexpected port ranges %v", value[4:16])
+	}
+}
+
+func TestCompileBPFRules(t *testing.T) {
+	_, block, _ := net.ParseCIDR("10.0.0.0/28")
+	blocks := []api.IPAMBlockResponse{
+		{Tenant: "T1", Segment: "S1", CIDR: api.IPNet{IPNet: *block}, Host: "host1"},
+	}
+
+	policies := []api.Policy{
+		{
+			ID:        "ingress",
+			Direction: api.PolicyDirectionIngress,
+			AppliedTo: []api.Endpoint{{TenantID: "T1"}},
+			Ingress: []api.RomanaIngress{{
+				Peers: []api.Endpoint{{Cidr: "192.168.0.0/24"}},
+				Rules: []api.Rule{{Protocol: "TCP", Ports: []uint{22}, PortRanges: []api.PortRange{{8000, 8080}}}},
+			}},
+		},
+		{
+			ID:        "egress",
+			Direction: api.PolicyDirectionEgress,
+			AppliedTo: []api.Endpoint{{TenantID: "T1", SegmentID: "S1"}},
+			Ingress:   []api.RomanaIngress{{Peers: []api.Endpoint{{Peer: "any"}}}},
+		},
+	}
+
+	var got []string
+	for _, rule := range compileBPFRules(policies, blocks, "host1") {
+		got = append(got, rule.String())
+	}
+
+	expect := []string{
+		"ingress local=10.0.0.0/28 peer=192.168.0.0/24 proto=6 ports=22-22",
+		"ingress local=10.0.0.0/28 peer=192.168.0.0/24 proto=6 ports=8000-8080",
+		"egress local=10.0.0.0/28 peer=0.0.0.0/0 proto=0 ports=0-65535",
+	}
+	if strings.Join(got, "\n") != strings.Join(expect, "\n") {
+		t.Fatalf("expected rules\n%s\ngot\n%s", strings.Join(expect, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestBuildBPFTables(t *testing.T) {
+	parse := func(cidr string) net.IPNet {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		return *ipnet
+	}
+	http := bpfPorts{Proto: 6, PortMin: 80, PortMax: 80}
+	ssh := bpfPorts{Proto: 6, PortMin: 22, PortMax: 22}
+	rules := []bpfRule{
+		{Ingress: true, Local: parse("10.0.0.0/24"), Peer: parse("0.0.0.0/0"), Ports: http},
+		{Ingress: true, Local: parse("10.0.0.0/28"), Peer: parse("192.168.0.0/24"), Ports: ssh},
+	}
+
+	sets := make(map[string]uint32)
+	setID := func(name string) uint32 {
+		if _, ok := sets[name]; !ok {
+			sets[name] = uint32(len(sets) + 1)
+		}
+		return sets[name]
+	}
+	tables := buildBPFTables(rules, setID)
+
+	wide, narrow := sets["ingress 10.0.0.0/24"], sets["ingress 10.0.0.0/28"]
+	if len(sets) != 2 || wide == 0 || narrow == 0 {
+		t.Fatalf("expected ingress rule sets of both networks, got %v", sets)
+	}
+
+	expectEndpoints := map[string]string{
+		bpfEndpointKey(parse("10.0.0.0/24")): bpfEndpointValue(wide, 0),
+		bpfEndpointKey(parse("10.0.0.0/28")): bpfEndpointValue(narrow, 0),
+	}
+	if !reflect.DeepEqual(tables.endpoints, expectEndpoints) {
+		t.Errorf("expected endpoints\n%v\ngot\n%v", expectEndpoints, tables.endpoints)
+	}
+
+	// rules of the wider network and peer are merged into
+	// entries of the narrower ones, which the lookup finds.
+	expectRules := map[string]string{
+		bpfRuleKey(wide, parse("0.0.0.0/0")):        bpfRuleValue([]bpfPorts{http}),
+		bpfRuleKey(narrow, parse("0.0.0.0/0")):      bpfRuleValue([]bpfPorts{http}),
+		bpfRuleKey(narrow, parse("192.168.0.0/24")): bpfRuleValue([]bpfPorts{http, ssh}),
+	}
+	if !reflect.DeepEqual(tables.rules, expectRules) {
+		t.Errorf("expected rules\n%v\ngot\n%v", expectRules, tables.rules)
+	}
+}
+
+func TestBPFProviderProgram(t *testing.T) {
+	exec := &utilexec.FakeExecutor{}
+	provider := NewBPFProvider(exec)
+	provider.links = func() ([]string, error) {
+		return []string{"romana-abc"}, nil
+	}
+
+	_, block, _ := net.ParseCIDR("10.0.0.0/28")
+	blocks := []api.IPAMBlockResponse{
+		{Tenant: "T1", CIDR: api.IPNet{IPNet: *block}, Host: "host1"},
+	}
+	policy := api.Policy{
+		ID:        "ingress",
+		Direction: api.PolicyDirectionIngress,
+		AppliedTo: []api.Endpoint{{TenantID: "T1"}},
+		Ingress:   []api.RomanaIngress{{Peers: []api.Endpoint{{Peer: "any"}}}},
+	}
+
+	cache := policycache.New()
+	cache.Put(policy.ID, policy)
+	state := DesiredState{Policies: cache, Blocks: blocks, Hostname: "host1"}
+
+	if err := provider.Program(context.Background(), state); err != nil {
+		t.Fatal(err)
+	}
+	commands := *exec.Commands
+	for _, expect := range []string{
+		"tc qdisc replace dev romana-abc clsact",
+		"tc filter replace dev romana-abc ingress bpf da obj " + DefaultBPFObject + " sec from_endpoint",
+		"tc filter replace dev romana-abc egress bpf da obj " + DefaultBPFObject + " sec to_endpoint",
+		"bpftool map update pinned " + DefaultBPFMapDir + "/romana_rules",
+		"bpftool map update pinned " + DefaultBPFMapDir + "/romana_endpoints",
+	} {
+		if !strings.Contains(commands, expect) {
+			t.Fatalf("expected %q in commands\n%s", expect, commands)
+		}
+	}
+
+	// second run without changes must not touch anything.
+	exec.Commands = nil
+	if err := provider.Program(context.Background(), state); err != nil {
+		t.Fatal(err)
+	}
+	if exec.Commands != nil {
+		t.Fatalf("expected no commands for unchanged state, got\n%s", *exec.Commands)
+	}
+
+	cache.Delete(policy.ID)
+	if err := provider.Program(context.Background(), state); err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{
+		"bpftool map delete pinned " + DefaultBPFMapDir + "/romana_endpoints",
+		"bpftool map delete pinned " + DefaultBPFMapDir + "/romana_rules",
+	} {
+		if exec.Commands == nil || !strings.Contains(*exec.Commands, expect) {
+			t.Fatalf("expected %q in commands", expect)
+		}
+	}
+	if len(provider.sets) != 0 {
+		t.Fatalf("expected unused rule sets to be released, got %v", provider.sets)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"context"
+	"strings"
+
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/agent/iptsave"
+	log "github.com/romana/rlog"
+)
+
+// Cleanup removes all romana chains from the filter table together
+// with rules in other chains that jump into them, and destroys ipsets
+// used by policies. It is meant to be called on agent exit when
+// rules should not outlive the agent.
+func Cleanup(ctx context.Context, exec utilexec.Executable) error {
+	currentIPtables, err := LoadIPtables(exec)
+	if err != nil {
+		return err
+	}
+
+	cleanup := makeCleanupIPtables(currentIPtables)
+	if cleanup != nil {
+		log.Tracef(6, "Removing romana iptables rules\n%s", cleanup.Render())
+		if err := ApplyIPtables(cleanup, exec); err != nil {
+			return err
+		}
+	}
+
+	return attemptIpsetCleanup(ctx, nil)
+}
+
+// makeCleanupIPtables returns iptables that, when applied,
+// delete romana chains found in the current iptables.
+// Returns nil if there is nothing to delete.
+func makeCleanupIPtables(current *iptsave.IPtables) *iptsave.IPtables {
+	currentFilter := current.TableByName("filter")
+	if currentFilter == nil {
+		return nil
+	}
+
+	filter := &iptsave.IPtable{Name: "filter"}
+	var romanaChains []*iptsave.IPchain
+
+	for _, chain := range currentFilter.Chains {
+		if strings.HasPrefix(chain.Name, "ROMANA-") {
+			romanaChains = append(romanaChains, &iptsave.IPchain{
+				Name:        chain.Name,
+				Policy:      "-",
+				RenderState: iptsave.RenderDeleteRule,
+			})
+			continue
+		}
+
+		// Jumps into romana chains must go first,
+		// chains can't be deleted while referenced.
+		var jumps []*iptsave.IPrule
+		for _, rule := range chain.Rules {
+			if strings.HasPrefix(rule.Action.Body, "ROMANA-") {
+				jump := *rule
+				jump.RenderState = iptsave.RenderDeleteRule
+				jumps = append(jumps, &jump)
+			}
+		}
+		if len(jumps) > 0 {
+			filter.Chains = append(filter.Chains, &iptsave.IPchain{
+				Name:   chain.Name,
+				Policy: "-",
+				Rules:  jumps,
+			})
+		}
+	}
+
+	if len(filter.Chains) == 0 && len(romanaChains) == 0 {
+		return nil
+	}
+
+	filter.Chains = append(filter.Chains, romanaChains...)
+	return &iptsave.IPtables{Tables: []*iptsave.IPtable{filter}}
+}
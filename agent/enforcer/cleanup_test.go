@@ -0,0 +1,64 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/romana/core/agent/iptsave"
+)
+
+func TestMakeCleanupIPtables(t *testing.T) {
+	current := &iptsave.IPtables{}
+	current.Parse(strings.NewReader(`*filter
+:INPUT ACCEPT [0:0]
+:FORWARD ACCEPT [0:0]
+:ROMANA-INPUT - [0:0]
+:ROMANA-FORWARD-IN - [0:0]
+-A INPUT -i romana-abc -j ROMANA-INPUT
+-A INPUT -i eth0 -j ACCEPT
+-A FORWARD -o romana-abc -j ROMANA-FORWARD-IN
+-A ROMANA-INPUT -j ACCEPT
+-A ROMANA-FORWARD-IN -j DROP
+COMMIT
+`))
+
+	rendered := makeCleanupIPtables(current).Render()
+	expect := []string{
+		"-D INPUT -i romana-abc -j ROMANA-INPUT",
+		"-D FORWARD -o romana-abc -j ROMANA-FORWARD-IN",
+		"-X ROMANA-INPUT",
+		"-X ROMANA-FORWARD-IN",
+	}
+	for _, e := range expect {
+		if !strings.Contains(rendered, e) {
+			t.Fatalf("expected %q in\n%s", e, rendered)
+		}
+	}
+	if strings.Contains(rendered, "eth0") {
+		t.Fatalf("unexpected non romana rule in\n%s", rendered)
+	}
+	if strings.Index(rendered, "-D INPUT") > strings.Index(rendered, "-X ROMANA-INPUT") {
+		t.Fatalf("expected jumps to be deleted before chains in\n%s", rendered)
+	}
+
+	empty := &iptsave.IPtables{}
+	empty.Parse(strings.NewReader("*filter\n:INPUT ACCEPT [0:0]\nCOMMIT\n"))
+	if makeCleanupIPtables(empty) != nil {
+		t.Fatalf("expected nothing to clean up")
+	}
+}
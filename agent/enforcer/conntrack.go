@@ -0,0 +1,147 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"net"
+
+	"github.com/romana/core/agent/policyhasher"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/pkg/policytools"
+
+	log "github.com/romana/rlog"
+	"github.com/vishvananda/netlink"
+)
+
+// Romana policies only ever allow traffic, so a flow becomes denied
+// when a policy that allowed it is deleted or modified. Established
+// flows are accepted by conntrack state before romana chains are
+// consulted, so such flows would stay alive until they time out.
+// Functions below find endpoints affected by these transitions and
+// delete their conntrack entries so that the next packet of the flow
+// is evaluated against current rules.
+
+// nlConntrackHandle is a subset of netlink.Handle used to delete
+// conntrack entries.
+type nlConntrackHandle interface {
+	ConntrackDeleteFilter(netlink.ConntrackTableType, netlink.InetFamily, netlink.CustomConntrackFilter) (uint, error)
+}
+
+// conntrackFilter matches conntrack flows originated from (Src)
+// or destined to (Dst) any of the networks.
+type conntrackFilter struct {
+	Src []net.IPNet
+	Dst []net.IPNet
+}
+
+// IsEmpty returns true if filter matches nothing.
+func (f conntrackFilter) IsEmpty() bool {
+	return len(f.Src) == 0 && len(f.Dst) == 0
+}
+
+// MatchConntrackFlow implements netlink.CustomConntrackFilter.
+func (f conntrackFilter) MatchConntrackFlow(flow *netlink.ConntrackFlow) bool {
+	for _, n := range f.Src {
+		if n.Contains(flow.Forward.SrcIP) {
+			return true
+		}
+	}
+	for _, n := range f.Dst {
+		if n.Contains(flow.Forward.DstIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexPolicies returns policies keyed by policy ID.
+func indexPolicies(policies []api.Policy) map[string]api.Policy {
+	result := make(map[string]api.Policy, len(policies))
+	for _, policy := range policies {
+		result[policy.ID] = policy
+	}
+	return result
+}
+
+// makeRevokedFilter returns a filter that matches flows of endpoints
+// selected by policies which were deleted or modified since last update.
+// Flows that are still allowed by remaining policies will be matched as
+// well, these are re-evaluated and re-established on the next packet.
+func makeRevokedFilter(previous, current map[string]api.Policy, blocks []api.IPAMBlockResponse, hostname string) conntrackFilter {
+	var filter conntrackFilter
+
+	for id, policy := range previous {
+		if p, ok := current[id]; ok && policyhasher.HashRomanaPolicy(p) == policyhasher.HashRomanaPolicy(policy) {
+			continue
+		}
+		log.Tracef(5, "Policy %s revoked or changed, flushing conntrack for its targets", id)
+
+		for _, target := range policy.AppliedTo {
+			nets := targetNets(target, blocks, hostname)
+			switch policy.Direction {
+			case api.PolicyDirectionIngress:
+				filter.Dst = append(filter.Dst, nets...)
+			case api.PolicyDirectionEgress:
+				filter.Src = append(filter.Src, nets...)
+			}
+		}
+	}
+
+	return filter
+}
+
+// targetNets returns networks selected by policy target.
+func targetNets(target api.Endpoint, blocks []api.IPAMBlockResponse, hostname string) []net.IPNet {
+	var result []net.IPNet
+
+	switch policytools.DetectPolicyTargetType(target) {
+	case policytools.TargetLocal:
+		for _, block := range blocks {
+			if block.Host == hostname {
+				result = append(result, block.CIDR.IPNet)
+			}
+		}
+	case policytools.TargetTenant, policytools.TargetTenantSegment:
+		for _, block := range blocks {
+			if block.Tenant != target.TenantID {
+				continue
+			}
+			if target.SegmentID != "" && block.Segment != target.SegmentID {
+				continue
+			}
+			result = append(result, block.CIDR.IPNet)
+		}
+	}
+
+	return result
+}
+
+// flushConntrack deletes conntrack entries matched by the filter.
+func flushConntrack(filter conntrackFilter, nl nlConntrackHandle) {
+	if filter.IsEmpty() {
+		return
+	}
+
+	n, err := nl.ConntrackDeleteFilter(netlink.ConntrackTable, netlink.FAMILY_V4, filter)
+	if err != nil {
+		log.Errorf("Failed to delete conntrack entries, %s", err)
+		ErrConntrackFlush.Inc()
+		return
+	}
+
+	log.Tracef(4, "Deleted %d conntrack entries after policy update", n)
+	NumConntrackFlushed.Add(float64(n))
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"net"
+	"testing"
+
+	"github.com/romana/core/common/api"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestMakeRevokedFilter(t *testing.T) {
+	makeCIDR := func(s string) api.IPNet {
+		_, ipnet, _ := net.ParseCIDR(s)
+		return api.IPNet{IPNet: *ipnet}
+	}
+
+	blocks := []api.IPAMBlockResponse{
+		{Tenant: "T1", Segment: "S1", CIDR: makeCIDR("10.0.0.0/28"), Host: "host1"},
+		{Tenant: "T1", Segment: "S2", CIDR: makeCIDR("10.0.0.16/28"), Host: "host2"},
+		{Tenant: "T2", Segment: "S1", CIDR: makeCIDR("10.0.1.0/28"), Host: "host1"},
+	}
+
+	ingressT1 := api.Policy{
+		ID:        "ingress-t1",
+		Direction: api.PolicyDirectionIngress,
+		AppliedTo: []api.Endpoint{{TenantID: "T1"}},
+		Ingress:   []api.RomanaIngress{{Peers: []api.Endpoint{{Peer: "any"}}}},
+	}
+	ingressT1Changed := ingressT1
+	ingressT1Changed.Ingress = []api.RomanaIngress{{Peers: []api.Endpoint{{Cidr: "10.10.0.0/16"}}}}
+	egressT2S1 := api.Policy{
+		ID:        "egress-t2s1",
+		Direction: api.PolicyDirectionEgress,
+		AppliedTo: []api.Endpoint{{TenantID: "T2", SegmentID: "S1"}},
+		Ingress:   []api.RomanaIngress{{Peers: []api.Endpoint{{Peer: "any"}}}},
+	}
+
+	flow := func(src, dst string) *netlink.ConntrackFlow {
+		f := &netlink.ConntrackFlow{}
+		f.Forward.SrcIP = net.ParseIP(src)
+		f.Forward.DstIP = net.ParseIP(dst)
+		return f
+	}
+
+	cases := []struct {
+		name     string
+		previous []api.Policy
+		current  []api.Policy
+		match    []*netlink.ConntrackFlow
+		noMatch  []*netlink.ConntrackFlow
+		empty    bool
+	}{
+		{
+			name:     "unchanged",
+			previous: []api.Policy{ingressT1, egressT2S1},
+			current:  []api.Policy{ingressT1, egressT2S1},
+			empty:    true,
+		},
+		{
+			name:     "policy added",
+			previous: []api.Policy{ingressT1},
+			current:  []api.Policy{ingressT1, egressT2S1},
+			empty:    true,
+		},
+		{
+			name:     "ingress policy removed",
+			previous: []api.Policy{ingressT1, egressT2S1},
+			current:  []api.Policy{egressT2S1},
+			match:    []*netlink.ConntrackFlow{flow("192.168.0.1", "10.0.0.5"), flow("192.168.0.1", "10.0.0.20")},
+			noMatch:  []*netlink.ConntrackFlow{flow("10.0.0.5", "192.168.0.1"), flow("192.168.0.1", "10.0.1.5")},
+		},
+		{
+			name:     "ingress policy changed",
+			previous: []api.Policy{ingressT1},
+			current:  []api.Policy{ingressT1Changed},
+			match:    []*netlink.ConntrackFlow{flow("192.168.0.1", "10.0.0.5")},
+		},
+		{
+			name:     "egress policy removed",
+			previous: []api.Policy{egressT2S1},
+			match:    []*netlink.ConntrackFlow{flow("10.0.1.5", "192.168.0.1")},
+			noMatch:  []*netlink.ConntrackFlow{flow("192.168.0.1", "10.0.1.5"), flow("10.0.0.5", "192.168.0.1")},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter := makeRevokedFilter(indexPolicies(tc.previous), indexPolicies(tc.current), blocks, "host1")
+			if filter.IsEmpty() != tc.empty {
+				t.Fatalf("expected empty filter to be %t, got %+v", tc.empty, filter)
+			}
+			for _, f := range tc.match {
+				if !filter.MatchConntrackFlow(f) {
+					t.Errorf("expected flow %s -> %s to match", f.Forward.SrcIP, f.Forward.DstIP)
+				}
+			}
+			for _, f := range tc.noMatch {
+				if filter.MatchConntrackFlow(f) {
+					t.Errorf("expected flow %s -> %s not to match", f.Forward.SrcIP, f.Forward.DstIP)
+				}
+			}
+		})
+	}
+}
@@ -18,7 +18,6 @@ package enforcer
 
 import (
 	"context"
-	"os/exec"
 	"strings"
 	"time"
 
@@ -32,12 +31,21 @@ import (
 
 	"github.com/romana/ipset"
 	log "github.com/romana/rlog"
+	"github.com/vishvananda/netlink"
 )
 
+// policyDebounce is how long enforcer waits after a policy update
+// before programming it, so that a burst of updates is programmed once.
+const policyDebounce = 100 * time.Millisecond
+
 // Interface defines policy enforcer behavior.
 type Interface interface {
 	// Run starts internal loop that handles updates from policies.
 	Run(context.Context)
+
+	// Done returns a channel that is closed once the internal
+	// loop exits after the context passed to Run is canceled.
+	Done() <-chan struct{}
 }
 
 // Endpoint implements Interface.
@@ -47,7 +55,7 @@ type Enforcer struct {
 	policyCache policycache.Interface
 
 	// provides updates about romana policies.
-	policies <-chan api.Policy
+	policies <-chan policycache.Update
 
 	// updates about romana blocksChannel
 	blocksChannel <-chan api.IPAMBlocksResponse
@@ -67,31 +75,36 @@ type Enforcer struct {
 	// Delay between main loop runs.
 	ticker *time.Ticker
 
-	// exec used to apply iptables policies.
-	exec utilexec.Executable
+	// provider used to program policies.
+	provider FirewallProvider
 
-	// attempt to refresh policies every refreshSeconds.
+	// attempt to program block updates and retry failed
+	// attempts every refreshSeconds.
 	refreshSeconds int
+
+	// closed when main loop exits.
+	done chan struct{}
+
+	// delete conntrack entries of flows denied by policy updates.
+	flushConntrack bool
+
+	// policies applied on previous update, used to detect
+	// which policies were removed or modified.
+	appliedPolicies map[string]api.Policy
+
+	// netlink handle used to delete conntrack entries.
+	nlHandle nlConntrackHandle
 }
 
 // New returns new policy enforcer.
 func New(policy policycache.Interface,
-	policies <-chan api.Policy,
+	policies <-chan policycache.Update,
 	blocks api.IPAMBlocksResponse,
 	blocksChannel <-chan api.IPAMBlocksResponse,
 	hostname string,
-	utilexec utilexec.Executable,
-	refreshSeconds int) (Interface, error) {
-
-	var err error
-
-	if IptablesSaveBin, err = exec.LookPath("iptables-save"); err != nil {
-		return nil, err
-	}
-
-	if IptablesRestoreBin, err = exec.LookPath("iptables-restore"); err != nil {
-		return nil, err
-	}
+	provider FirewallProvider,
+	refreshSeconds int,
+	flushConntrack bool) (Interface, error) {
 
 	return &Enforcer{
 		policyCache:    policy,
@@ -99,71 +112,44 @@ func New(policy policycache.Interface,
 		blocks:         blocks,
 		blocksChannel:  blocksChannel,
 		hostname:       hostname,
-		exec:           utilexec,
+		provider:       provider,
 		refreshSeconds: refreshSeconds,
+		done:           make(chan struct{}),
+		flushConntrack: flushConntrack,
+		nlHandle:       &netlink.Handle{},
 	}, nil
 }
 
+// Done implements Interface.
+func (a *Enforcer) Done() <-chan struct{} {
+	return a.done
+}
+
 // Run implements Interface.  It reads notifications
 // from the policy cache and from the block cache,
-// when either cache chagned re-renders all iptables rules.
+// policy updates are programmed right away, block updates
+// and failed attempts on the next tick.
 func (a *Enforcer) Run(ctx context.Context) {
 	log.Trace(trace.Public, "Policy enforcer Run()")
 
 	var romanaBlocks []api.IPAMBlockResponse
 	romanaBlocks = a.blocks.Blocks
 
-	iptables := &iptsave.IPtables{}
 	a.ticker = time.NewTicker(time.Duration(a.refreshSeconds) * time.Second)
 
 	go func() {
+		// fires policyDebounce after the first policy update
+		// not programmed yet, nil otherwise.
+		var debounce <-chan time.Time
+
 		for {
 			select {
 			case <-a.ticker.C:
-				if !a.policyUpdate && !a.blocksUpdate {
-					log.Tracef(5, "Policy enforcer tick skipped due no updates, block update=%t and policy update=%t", a.blocksUpdate, a.policyUpdate)
-					continue
-				}
-
-				if len(romanaBlocks) == 0 {
-					log.Trace(5, "no blocks, skipping")
-					continue
-				}
-				NumEnforcerTick.Inc()
-
-				sets, err := makeBlockSets(romanaBlocks, a.policyCache, a.hostname)
-				if err != nil {
-					log.Errorf("Failed to update ipsets, can't apply Romana policies, %s", err)
-					ErrMakeSets.Inc()
-					continue
-				}
-
-				err = updateIpsets(ctx, sets)
-				if err != nil {
-					log.Errorf("Failed to update ipsets, can't apply Romana policies, %s", err)
-					ErrApplySets.Inc()
-					continue
-				}
-				NumBlockUpdates.Inc()
-				NumManagedSets.Set(float64(len(sets.Sets)))
-
-				iptables = renderIPtables(a.policyCache, a.hostname, romanaBlocks)
-				cleanupUnusedChains(iptables, a.exec)
-				if ValidateIPtables(iptables, a.exec) {
-					if err := ApplyIPtables(iptables, a.exec); err != nil {
-						log.Errorf("iptables-restore call failed %s", err)
-						ErrApplyIptables.Inc()
-					}
-					log.Tracef(6, "Applied iptables rules\n%s", iptables.Render())
-
-				} else {
-					ErrValidateIptables.Inc()
-					log.Tracef(6, "Failed to validate iptables\n%s%n", iptables.Render())
-				}
-				NumPolicyUpdates.Inc()
+				a.program(ctx, romanaBlocks)
 
-				a.policyUpdate = false
-				a.blocksUpdate = false
+			case <-debounce:
+				debounce = nil
+				a.program(ctx, romanaBlocks)
 
 			case blocksList := <-a.blocksChannel:
 				log.Trace(4, "Policy enforcer receives update from cache blocks revision=%d",
@@ -171,21 +157,73 @@ func (a *Enforcer) Run(ctx context.Context) {
 				romanaBlocks = blocksList.Blocks
 				a.blocksUpdate = true
 
-			case <-a.policies:
-				log.Trace(4, "Policy enforcer receives update from policy cache")
+			case update := <-a.policies:
+				log.Tracef(4, "Policy enforcer receives update from policy cache digest=%s changed=%v",
+					update.Digest, update.Changed)
 				a.policyUpdate = true
+				if debounce == nil {
+					debounce = time.After(policyDebounce)
+				}
 
 			case <-ctx.Done():
 				log.Infof("Policy enforcer stopping")
 				a.ticker.Stop()
+				close(a.done)
 				return
 			}
 		}
 	}()
 }
 
+// program programs policies and blocks into the datapath
+// if either changed since last successful attempt.
+func (a *Enforcer) program(ctx context.Context, romanaBlocks []api.IPAMBlockResponse) {
+	if !a.policyUpdate && !a.blocksUpdate {
+		log.Tracef(5, "Policy enforcer tick skipped due no updates, block update=%t and policy update=%t", a.blocksUpdate, a.policyUpdate)
+		return
+	}
+
+	if len(romanaBlocks) == 0 {
+		log.Trace(5, "no blocks, skipping")
+		return
+	}
+	NumEnforcerTick.Inc()
+
+	// programmed rules and flushed flows
+	// are made from the same policies.
+	policies := a.policyCache.Snapshot()
+	state := DesiredState{
+		Policies: policies,
+		Blocks:   romanaBlocks,
+		Hostname: a.hostname,
+	}
+	if err := a.provider.Program(ctx, state); err != nil {
+		log.Errorf("Failed to apply Romana policies, %s", err)
+		return
+	}
+
+	if a.flushConntrack {
+		a.flushRevokedFlows(policies, romanaBlocks)
+	}
+	NumPolicyUpdates.Inc()
+
+	a.policyUpdate = false
+	a.blocksUpdate = false
+}
+
+// flushRevokedFlows deletes conntrack entries for flows that could've
+// been allowed by policies removed or modified since last call.
+func (a *Enforcer) flushRevokedFlows(snapshot *policycache.Snapshot, blocks []api.IPAMBlockResponse) {
+	policies := snapshot.ByID()
+	if a.appliedPolicies != nil {
+		filter := makeRevokedFilter(a.appliedPolicies, policies, blocks, a.hostname)
+		flushConntrack(filter, a.nlHandle)
+	}
+	a.appliedPolicies = policies
+}
+
 // makeBlockSets creates ipset configuration for policies and blocks.
-func makeBlockSets(blocks []api.IPAMBlockResponse, policyCache policycache.Interface, hostname string) (*ipset.Ipset, error) {
+func makeBlockSets(blocks []api.IPAMBlockResponse, policyCache policycache.Reader, hostname string) (*ipset.Ipset, error) {
 	policies := policyCache.List()
 	sets := ipset.NewIpset()
 
@@ -324,7 +362,7 @@ type validateFunc func(target api.Endpoint) bool
 
 // renderIPtables creates iptables rules for all romana policies in policy cache
 // except the ones which depends on non-existend tenant/segment.
-func renderIPtables(policyCache policycache.Interface, hostname string, blocks []api.IPAMBlockResponse) *iptsave.IPtables {
+func renderIPtables(policyCache policycache.Reader, hostname string, blocks []api.IPAMBlockResponse) *iptsave.IPtables {
 	log.Trace(trace.Private, "Policy enforcer in renderIPtables()")
 
 	// Make empty iptables object.
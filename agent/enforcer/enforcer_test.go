@@ -505,3 +505,33 @@ func TestTargetValid(t *testing.T) {
 		})
 	}
 }
+
+// BenchmarkRenderIPtables measures generation of iptables rules
+// for the number of policies applied to tenants with blocks on
+// the host.
+func BenchmarkRenderIPtables(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("policies=%d", n), func(b *testing.B) {
+			cache := policycache.New()
+			var blocks []api.IPAMBlockResponse
+			for i := 0; i < n; i++ {
+				tenant := fmt.Sprintf("T%d", i)
+				blocks = append(blocks, api.IPAMBlockResponse{Tenant: tenant, Host: "host1"})
+				cache.Put(fmt.Sprintf("p%d", i), api.Policy{
+					ID:        fmt.Sprintf("p%d", i),
+					Direction: api.PolicyDirectionIngress,
+					AppliedTo: []api.Endpoint{{TenantID: tenant}},
+					Ingress: []api.RomanaIngress{{
+						Peers: []api.Endpoint{{Cidr: "10.0.0.0/8"}},
+						Rules: []api.Rule{{Protocol: "TCP", Ports: []uint{80, 443}}},
+					}},
+				})
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				renderIPtables(cache, "host1", blocks).Render()
+			}
+		})
+	}
+}
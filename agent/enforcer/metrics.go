@@ -76,6 +76,18 @@ var (
 			Help: "Number of Romana policy rules applied to the host.",
 		},
 	)
+	NumConntrackFlushed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_conntrack_flushed_total",
+			Help: "Number of conntrack entries deleted after policy updates.",
+		},
+	)
+	ErrConntrackFlush = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_err_conntrack_flush_total",
+			Help: "Number of errors attempting to delete conntrack entries.",
+		},
+	)
 )
 
 // MetricsRegister registers package global metrics into registry provided,
@@ -95,6 +107,8 @@ func MetricsRegister(registry *prometheus.Registry) error {
 		NumEnforcerTick,
 		NumManagedSets,
 		NumPolicyRules,
+		NumConntrackFlushed,
+		ErrConntrackFlush,
 	} {
 		err := registry.Register(counter)
 		if err != nil {
@@ -0,0 +1,178 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/agent/iptsave"
+	"github.com/romana/core/agent/policycache"
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+
+	log "github.com/romana/rlog"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// FirewallProvider programs romana policies into the datapath.
+type FirewallProvider interface {
+	// Program brings datapath in line with desired state.
+	Program(context.Context, DesiredState) error
+
+	// Current returns human readable representation
+	// of the rules currently installed by provider.
+	Current() (string, error)
+
+	// Cleanup removes everything installed by provider.
+	Cleanup(context.Context) error
+}
+
+// DesiredState is what policy enforcer wants to be programmed.
+type DesiredState struct {
+	Policies policycache.Reader
+	Blocks   []api.IPAMBlockResponse
+	Hostname string
+}
+
+const (
+	// ProviderIPtables implements policies with ipsets and iptables.
+	ProviderIPtables = "iptables"
+
+	// ProviderBPF implements policies with TC eBPF classifier
+	// attached to endpoint interfaces, experimental.
+	ProviderBPF = "bpf"
+
+	// ProviderNone doesn't implement policies, it is
+	// meant for routed-only deployments.
+	ProviderNone = "none"
+)
+
+// NewFirewallProvider returns firewall provider by name.
+func NewFirewallProvider(name string, exec utilexec.Executable) (FirewallProvider, error) {
+	switch name {
+	case ProviderIPtables:
+		return NewIPtablesProvider(exec)
+	case ProviderBPF:
+		return NewBPFProvider(exec), nil
+	case ProviderNone:
+		return NoopProvider{}, nil
+	}
+
+	return nil, errors.Errorf("unknown firewall provider %s", name)
+}
+
+// IPtablesProvider implements FirewallProvider using
+// iptables-save/iptables-restore and ipset.
+type IPtablesProvider struct {
+	exec utilexec.Executable
+}
+
+// NewIPtablesProvider returns IPtablesProvider, fails if
+// required binaries are not installed.
+func NewIPtablesProvider(utilexec utilexec.Executable) (*IPtablesProvider, error) {
+	var err error
+
+	if IptablesSaveBin, err = exec.LookPath("iptables-save"); err != nil {
+		return nil, err
+	}
+
+	if IptablesRestoreBin, err = exec.LookPath("iptables-restore"); err != nil {
+		return nil, err
+	}
+
+	return &IPtablesProvider{exec: utilexec}, nil
+}
+
+// Program implements FirewallProvider.
+func (p *IPtablesProvider) Program(ctx context.Context, state DesiredState) (err error) {
+	ctx, span := common.StartSpan(ctx, "agent.iptables",
+		attribute.Int("romana.blocks", len(state.Blocks)))
+	defer func() { common.EndSpan(span, err) }()
+
+	sets, err := makeBlockSets(state.Blocks, state.Policies, state.Hostname)
+	if err != nil {
+		ErrMakeSets.Inc()
+		return errors.Wrap(err, "failed to make ipsets")
+	}
+
+	err = updateIpsets(ctx, sets)
+	if err != nil {
+		ErrApplySets.Inc()
+		return errors.Wrap(err, "failed to update ipsets")
+	}
+	NumBlockUpdates.Inc()
+	NumManagedSets.Set(float64(len(sets.Sets)))
+
+	iptables := renderIPtables(state.Policies, state.Hostname, state.Blocks)
+	cleanupUnusedChains(iptables, p.exec)
+	if !ValidateIPtables(iptables, p.exec) {
+		ErrValidateIptables.Inc()
+		log.Tracef(6, "Failed to validate iptables\n%s", iptables.Render())
+		return errors.New("failed to validate iptables")
+	}
+
+	if err := ApplyIPtables(iptables, p.exec); err != nil {
+		ErrApplyIptables.Inc()
+		return errors.Wrap(err, "iptables-restore call failed")
+	}
+	log.Tracef(6, "Applied iptables rules\n%s", iptables.Render())
+
+	return nil
+}
+
+// Current implements FirewallProvider, returns romana chains
+// of the filter table in iptables-save format.
+func (p *IPtablesProvider) Current() (string, error) {
+	current, err := LoadIPtables(p.exec)
+	if err != nil {
+		return "", err
+	}
+
+	romana := &iptsave.IPtable{Name: "filter"}
+	if filter := current.TableByName("filter"); filter != nil {
+		for _, chain := range filter.Chains {
+			if strings.HasPrefix(chain.Name, "ROMANA-") {
+				romana.Chains = append(romana.Chains, chain)
+			}
+		}
+	}
+
+	return (&iptsave.IPtables{Tables: []*iptsave.IPtable{romana}}).Render(), nil
+}
+
+// Cleanup implements FirewallProvider.
+func (p *IPtablesProvider) Cleanup(ctx context.Context) error {
+	return Cleanup(ctx, p.exec)
+}
+
+// NoopProvider implements FirewallProvider and does nothing.
+type NoopProvider struct{}
+
+// Program implements FirewallProvider.
+func (NoopProvider) Program(context.Context, DesiredState) error {
+	log.Tracef(5, "Firewall provider %s skips programming policies", ProviderNone)
+	return nil
+}
+
+// Current implements FirewallProvider.
+func (NoopProvider) Current() (string, error) { return "", nil }
+
+// Cleanup implements FirewallProvider.
+func (NoopProvider) Cleanup(context.Context) error { return nil }
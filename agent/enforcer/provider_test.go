@@ -0,0 +1,47 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package enforcer
+
+import (
+	"context"
+	"testing"
+
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/agent/policycache"
+)
+
+func TestNewFirewallProvider(t *testing.T) {
+	exec := &utilexec.FakeExecutor{}
+
+	provider, err := NewFirewallProvider(ProviderNone, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = provider.Program(context.Background(), DesiredState{Policies: policycache.New(), Hostname: "host1"})
+	if err != nil {
+		t.Fatalf("expected noop provider to succeed, got %s", err)
+	}
+
+	current, err := provider.Current()
+	if err != nil || current != "" {
+		t.Fatalf("expected noop provider to have no rules, got %q, %v", current, err)
+	}
+
+	if _, err := NewFirewallProvider("nftables", exec); err == nil {
+		t.Fatal("expected error for unknown provider")
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/romana/core/common/api"
+	log "github.com/romana/rlog"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// LinkSelector describes which host interface carries romana traffic.
+// An interface can be selected by its name, by a CIDR which one of
+// the interface addresses belongs to, or by a label, which is matched
+// against the interface alias (see `ip link set dev X alias Y`).
+type LinkSelector struct {
+	Name  string
+	CIDR  *net.IPNet
+	Label string
+}
+
+// ParseLinkSelector parses selector in the form of "name=eth1",
+// "cidr=192.168.0.0/24" or "label=data". A value without a key is
+// treated as a CIDR if it parses as one and as an interface name
+// otherwise.
+func ParseLinkSelector(s string) (LinkSelector, error) {
+	var sel LinkSelector
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return sel, nil
+	}
+
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) == 1 {
+		if _, cidr, err := net.ParseCIDR(s); err == nil {
+			sel.CIDR = cidr
+		} else {
+			sel.Name = s
+		}
+		return sel, nil
+	}
+
+	key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+	if value == "" {
+		return sel, fmt.Errorf("empty value in link selector %q", s)
+	}
+	switch key {
+	case "name":
+		sel.Name = value
+	case "label":
+		sel.Label = value
+	case "cidr":
+		_, cidr, err := net.ParseCIDR(value)
+		if err != nil {
+			return sel, fmt.Errorf("invalid cidr in link selector %q: %s", s, err)
+		}
+		sel.CIDR = cidr
+	default:
+		return sel, fmt.Errorf("unknown key %q in link selector %q, expected one of name, cidr, label", key, s)
+	}
+	return sel, nil
+}
+
+// IsEmpty returns true if selector doesn't select anything.
+func (s LinkSelector) IsEmpty() bool {
+	return s.Name == "" && s.CIDR == nil && s.Label == ""
+}
+
+func (s LinkSelector) String() string {
+	var parts []string
+	if s.Name != "" {
+		parts = append(parts, "name="+s.Name)
+	}
+	if s.CIDR != nil {
+		parts = append(parts, "cidr="+s.CIDR.String())
+	}
+	if s.Label != "" {
+		parts = append(parts, "label="+s.Label)
+	}
+	return strings.Join(parts, ",")
+}
+
+type nlLinkHandle interface {
+	LinkList() ([]netlink.Link, error)
+	AddrList(netlink.Link, int) ([]netlink.Addr, error)
+}
+
+// FindLink returns first link that matches all the criteria
+// of the selector.
+func FindLink(sel LinkSelector, nlHandle nlLinkHandle) (netlink.Link, error) {
+	if sel.IsEmpty() {
+		return nil, fmt.Errorf("empty link selector")
+	}
+
+	links, err := nlHandle.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("error listing links: %s", err)
+	}
+
+	for _, link := range links {
+		attrs := link.Attrs()
+		if sel.Name != "" && attrs.Name != sel.Name {
+			continue
+		}
+		if sel.Label != "" && attrs.Alias != sel.Label {
+			continue
+		}
+		if sel.CIDR != nil {
+			addrs, err := nlHandle.AddrList(link, unix.AF_INET)
+			if err != nil {
+				return nil, fmt.Errorf("error listing addresses for link %s: %s", attrs.Name, err)
+			}
+			found := false
+			for _, addr := range addrs {
+				if sel.CIDR.Contains(addr.IP) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		return link, nil
+	}
+
+	return nil, fmt.Errorf("no link matches selector %s", sel)
+}
+
+// ResolveBlockLinks finds links for interface selectors of the networks
+// that blocks belong to. Result is keyed by selector as it is specified
+// in the topology, selectors that can't be resolved are logged and
+// omitted, routes for them are installed without an explicit link.
+func ResolveBlockLinks(blocks []api.IPAMBlockResponse, nlHandle nlLinkHandle) map[string]netlink.Link {
+	links := make(map[string]netlink.Link)
+	for _, block := range blocks {
+		if block.Interface == "" {
+			continue
+		}
+		if _, ok := links[block.Interface]; ok {
+			continue
+		}
+
+		sel, err := ParseLinkSelector(block.Interface)
+		if err != nil {
+			log.Errorf("Network %s has invalid interface selector: %s", block.Network, err)
+			links[block.Interface] = nil
+			continue
+		}
+
+		link, err := FindLink(sel, nlHandle)
+		if err != nil {
+			log.Errorf("Failed to find interface for network %s: %s", block.Network, err)
+			links[block.Interface] = nil
+			continue
+		}
+		links[block.Interface] = link
+	}
+	return links
+}
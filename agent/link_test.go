@@ -0,0 +1,149 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"net"
+	"testing"
+
+	"github.com/romana/core/common/api"
+	"github.com/vishvananda/netlink"
+)
+
+type testLinkHandle struct {
+	links []netlink.Link
+	addrs map[string][]netlink.Addr
+}
+
+func (h testLinkHandle) LinkList() ([]netlink.Link, error) {
+	return h.links, nil
+}
+
+func (h testLinkHandle) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	return h.addrs[link.Attrs().Name], nil
+}
+
+func makeTestLinkHandle() testLinkHandle {
+	mkAddr := func(s string) netlink.Addr {
+		addr, _ := netlink.ParseAddr(s)
+		return *addr
+	}
+	return testLinkHandle{
+		links: []netlink.Link{
+			&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}},
+			&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth1", Index: 3, Alias: "storage"}},
+			&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth2", Index: 4, Alias: "data"}},
+		},
+		addrs: map[string][]netlink.Addr{
+			"eth0": {mkAddr("10.0.0.5/24")},
+			"eth1": {mkAddr("192.168.10.5/24")},
+			"eth2": {mkAddr("192.168.20.5/24")},
+		},
+	}
+}
+
+func TestParseLinkSelector(t *testing.T) {
+	cases := []struct {
+		in, expect string
+		fail       bool
+	}{
+		{in: "", expect: ""},
+		{in: "eth1", expect: "name=eth1"},
+		{in: "192.168.10.0/24", expect: "cidr=192.168.10.0/24"},
+		{in: "name=eth1", expect: "name=eth1"},
+		{in: "cidr=192.168.10.1/24", expect: "cidr=192.168.10.0/24"},
+		{in: "label=data", expect: "label=data"},
+		{in: "cidr=foo", fail: true},
+		{in: "label=", fail: true},
+		{in: "color=blue", fail: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			sel, err := ParseLinkSelector(tc.in)
+			if tc.fail {
+				if err == nil {
+					t.Fatalf("expected error parsing %q, got %s", tc.in, sel)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error parsing %q: %s", tc.in, err)
+			}
+			if sel.String() != tc.expect {
+				t.Fatalf("expected %q, got %q", tc.expect, sel.String())
+			}
+		})
+	}
+}
+
+func TestFindLink(t *testing.T) {
+	_, storageNet, _ := net.ParseCIDR("192.168.10.0/24")
+	_, unknownNet, _ := net.ParseCIDR("172.16.0.0/16")
+
+	cases := []struct {
+		name     string
+		selector LinkSelector
+		expect   string
+	}{
+		{name: "by name", selector: LinkSelector{Name: "eth2"}, expect: "eth2"},
+		{name: "by cidr", selector: LinkSelector{CIDR: storageNet}, expect: "eth1"},
+		{name: "by label", selector: LinkSelector{Label: "data"}, expect: "eth2"},
+		{name: "by name and label", selector: LinkSelector{Name: "eth1", Label: "storage"}, expect: "eth1"},
+		{name: "conflicting name and label", selector: LinkSelector{Name: "eth0", Label: "data"}},
+		{name: "unknown cidr", selector: LinkSelector{CIDR: unknownNet}},
+		{name: "empty selector", selector: LinkSelector{}},
+	}
+
+	nlHandle := makeTestLinkHandle()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			link, err := FindLink(tc.selector, nlHandle)
+			if tc.expect == "" {
+				if err == nil {
+					t.Fatalf("expected error, got link %s", link.Attrs().Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if link.Attrs().Name != tc.expect {
+				t.Fatalf("expected link %s, got %s", tc.expect, link.Attrs().Name)
+			}
+		})
+	}
+}
+
+func TestResolveBlockLinks(t *testing.T) {
+	blocks := []api.IPAMBlockResponse{
+		{Network: "net1"},
+		{Network: "net2", Interface: "label=storage"},
+		{Network: "net2", Interface: "label=storage"},
+		{Network: "net3", Interface: "eth9"},
+	}
+
+	links := ResolveBlockLinks(blocks, makeTestLinkHandle())
+	if len(links) != 2 {
+		t.Fatalf("expected 2 selectors resolved, got %d", len(links))
+	}
+	if link := links["label=storage"]; link == nil || link.Attrs().Index != 3 {
+		t.Fatalf("expected label=storage to resolve into eth1, got %v", link)
+	}
+	if link := links["eth9"]; link != nil {
+		t.Fatalf("expected eth9 not to be resolved, got %v", link)
+	}
+}
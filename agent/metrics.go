@@ -22,6 +22,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/romana/core/agent/enforcer"
+	"github.com/romana/core/agent/policycontroller"
+	"github.com/romana/core/common/client"
 	log "github.com/romana/rlog"
 )
 
@@ -45,6 +47,16 @@ func MetricStart(port int) error {
 		return err
 	}
 
+	err = policycontroller.MetricsRegister(registry)
+	if err != nil {
+		return err
+	}
+
+	err = client.MetricsRegister(registry)
+	if err != nil {
+		return err
+	}
+
 	err = registry.Register(NumManagedRoutes)
 	if err != nil {
 		return err
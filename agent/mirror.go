@@ -0,0 +1,361 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/pkg/errors"
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/common"
+	log "github.com/romana/rlog"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// DefaultMirrorDuration is used when request doesn't specify one.
+	DefaultMirrorDuration = 5 * time.Minute
+
+	// MaxMirrorDuration limits how long traffic can be mirrored.
+	MaxMirrorDuration = time.Hour
+
+	// DefaultMirrorVNI is VXLAN id used to send traffic to a collector.
+	DefaultMirrorVNI = 4242
+
+	// mirrorFilterPref is a priority of tc filters installed for
+	// mirroring, used to delete exactly these filters.
+	mirrorFilterPref = "49152"
+
+	// mirrorLinkPrefix is a prefix of VXLAN interfaces created
+	// to send traffic to collectors.
+	mirrorLinkPrefix = "rmirror"
+)
+
+var (
+	TcBin = "tc"
+	IpBin = "ip"
+)
+
+// MirrorRequest describes endpoint traffic to mirror and where to.
+// Either Interface or Collector must be set.
+type MirrorRequest struct {
+	// Endpoint is a name of the endpoint interface or endpoint IP address.
+	Endpoint string `json:"endpoint"`
+
+	// Interface to mirror traffic to, e.g. a dummy interface
+	// where tcpdump is running.
+	Interface string `json:"interface,omitempty"`
+
+	// Collector receives mirrored traffic encapsulated in VXLAN.
+	Collector net.IP `json:"collector,omitempty"`
+	VNI       int    `json:"vni,omitempty"`
+
+	// Duration of mirroring in seconds.
+	DurationSeconds int `json:"duration_seconds,omitempty"`
+}
+
+// MirrorSession is a mirroring in progress.
+type MirrorSession struct {
+	ID string `json:"id"`
+	MirrorRequest
+	EndpointLink string    `json:"endpoint_link"`
+	TargetLink   string    `json:"target_link"`
+	Expires      time.Time `json:"expires"`
+
+	timer *time.Timer
+}
+
+// Mirrors manages traffic mirroring sessions, each session is stopped
+// when its duration expires.
+type Mirrors struct {
+	mu       sync.Mutex
+	exec     utilexec.Executable
+	sessions map[string]*MirrorSession
+	lastID   int
+
+	// resolve returns name of the endpoint interface.
+	resolve func(endpoint string) (string, error)
+}
+
+// NewMirrors returns Mirrors that programs tc and links using exec.
+func NewMirrors(exec utilexec.Executable) *Mirrors {
+	return &Mirrors{
+		exec:     exec,
+		sessions: make(map[string]*MirrorSession),
+		resolve:  resolveEndpointLink,
+	}
+}
+
+// Start starts mirroring traffic of the endpoint.
+func (m *Mirrors) Start(req MirrorRequest) (*MirrorSession, error) {
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	if duration == 0 {
+		duration = DefaultMirrorDuration
+	}
+	if duration < 0 || duration > MaxMirrorDuration {
+		return nil, errors.Errorf("duration must be positive and not longer than %s", MaxMirrorDuration)
+	}
+
+	if (req.Interface == "") == (req.Collector == nil) {
+		return nil, errors.New("exactly one of interface or collector must be specified")
+	}
+
+	endpointLink, err := m.resolve(req.Endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find interface of endpoint %s", req.Endpoint)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.EndpointLink == endpointLink {
+			return nil, errors.Errorf("traffic of %s is already mirrored by session %s", endpointLink, s.ID)
+		}
+	}
+
+	m.lastID++
+	session := &MirrorSession{
+		ID:            strconv.Itoa(m.lastID),
+		MirrorRequest: req,
+		EndpointLink:  endpointLink,
+		TargetLink:    req.Interface,
+		Expires:       time.Now().Add(duration),
+	}
+
+	if req.Collector != nil {
+		if session.VNI == 0 {
+			session.VNI = DefaultMirrorVNI
+		}
+		session.TargetLink = fmt.Sprintf("%s%s", mirrorLinkPrefix, session.ID)
+		if err := m.run(IpBin, "link", "add", session.TargetLink, "type", "vxlan",
+			"id", strconv.Itoa(session.VNI), "remote", req.Collector.String(), "dstport", "4789"); err != nil {
+			return nil, err
+		}
+		if err := m.run(IpBin, "link", "set", session.TargetLink, "up"); err != nil {
+			m.teardown(session)
+			return nil, err
+		}
+	}
+
+	if err := m.run(TcBin, "qdisc", "replace", "dev", endpointLink, "clsact"); err != nil {
+		m.teardown(session)
+		return nil, err
+	}
+	for _, direction := range []string{"ingress", "egress"} {
+		if err := m.run(TcBin, "filter", "add", "dev", endpointLink, direction, "pref", mirrorFilterPref,
+			"matchall", "action", "mirred", "egress", "mirror", "dev", session.TargetLink); err != nil {
+			m.teardown(session)
+			return nil, err
+		}
+	}
+
+	id := session.ID
+	session.timer = time.AfterFunc(duration, func() {
+		log.Infof("Mirroring session %s expired", id)
+		if err := m.Stop(id); err != nil {
+			log.Errorf("Failed to stop mirroring session %s, %s", id, err)
+		}
+	})
+	m.sessions[id] = session
+
+	log.Infof("Started mirroring traffic of %s to %s until %s", endpointLink, session.TargetLink, session.Expires)
+	return session, nil
+}
+
+// Stop stops mirroring session.
+func (m *Mirrors) Stop(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return errors.Errorf("no mirroring session %s", id)
+	}
+
+	session.timer.Stop()
+	delete(m.sessions, id)
+	return m.teardown(session)
+}
+
+// StopAll stops all mirroring sessions.
+func (m *Mirrors) StopAll() {
+	for _, s := range m.List() {
+		if err := m.Stop(s.ID); err != nil {
+			log.Errorf("Failed to stop mirroring session %s, %s", s.ID, err)
+		}
+	}
+}
+
+// List returns mirroring sessions in progress.
+func (m *Mirrors) List() []MirrorSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []MirrorSession
+	for _, s := range m.sessions {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, _ := strconv.Atoi(result[i].ID)
+		b, _ := strconv.Atoi(result[j].ID)
+		return a < b
+	})
+	return result
+}
+
+// teardown removes filters and links of the session, attempts
+// every step even if previous ones failed.
+func (m *Mirrors) teardown(session *MirrorSession) error {
+	var failed []string
+	for _, direction := range []string{"ingress", "egress"} {
+		if err := m.run(TcBin, "filter", "del", "dev", session.EndpointLink, direction, "pref", mirrorFilterPref); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if strings.HasPrefix(session.TargetLink, mirrorLinkPrefix) {
+		if err := m.run(IpBin, "link", "del", session.TargetLink); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (m *Mirrors) run(cmd string, args ...string) error {
+	out, err := m.exec.Exec(cmd, args)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s failed, out=%s", cmd, strings.Join(args, " "), out)
+	}
+	return nil
+}
+
+// Handler returns http handler for the mirroring API:
+// GET lists sessions, POST starts a session from MirrorRequest,
+// DELETE /<id> stops a session. Requests are authenticated by
+// auth the same way as requests to romanad, starting and stopping
+// sessions requires admin or service role.
+func (m *Mirrors) Handler(prefix string, auth common.AuthMiddleware) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer context.Clear(r)
+		auth.ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) {
+			m.serve(prefix, w, r)
+		})
+	})
+}
+
+func (m *Mirrors) serve(prefix string, w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		writeJSON(w, http.StatusOK, m.List())
+	case r.Method == http.MethodPost && id == "":
+		var req MirrorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		session, err := m.Start(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, session)
+	case r.Method == http.MethodDelete && id != "":
+		if err := m.Stop(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// MirrorStart starts http server for the mirroring API on address
+// and port, port <= 0 disables the API. The API is only started
+// when auth is enabled, as it exposes traffic of endpoints.
+func MirrorStart(address string, port int, auth common.APIAuth, mirrors *Mirrors) error {
+	if port <= 0 {
+		return nil
+	}
+	if !auth.IsEnabled() {
+		return errors.New("mirroring API requires authentication with -auth-public-key")
+	}
+	am, err := common.NewAuthMiddleware(auth)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mirrors", mirrors.Handler("/mirrors", am))
+	mux.Handle("/mirrors/", mirrors.Handler("/mirrors", am))
+
+	listenAddr := net.JoinHostPort(address, strconv.Itoa(port))
+	go func() {
+		log.Errorf("Mirroring API stopped due to %s", http.ListenAndServe(listenAddr, mux))
+	}()
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Failed to write response, %s", err)
+	}
+}
+
+// resolveEndpointLink returns endpoint interface name, endpoint
+// is either interface name or IP address of the endpoint.
+func resolveEndpointLink(endpoint string) (string, error) {
+	ip := net.ParseIP(endpoint)
+	if ip == nil {
+		link, err := netlink.LinkByName(endpoint)
+		if err != nil {
+			return "", err
+		}
+		return link.Attrs().Name, nil
+	}
+
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return "", err
+	}
+	if len(routes) == 0 {
+		return "", errors.Errorf("no route to %s", ip)
+	}
+
+	link, err := netlink.LinkByIndex(routes[0].LinkIndex)
+	if err != nil {
+		return "", err
+	}
+	return link.Attrs().Name, nil
+}
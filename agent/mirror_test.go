@@ -0,0 +1,133 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	utilexec "github.com/romana/core/agent/exec"
+	"github.com/romana/core/common"
+)
+
+func TestMirrors(t *testing.T) {
+	exec := &utilexec.FakeExecutor{}
+	mirrors := NewMirrors(exec)
+	mirrors.resolve = func(endpoint string) (string, error) {
+		return "romana-abc", nil
+	}
+
+	cases := []struct {
+		name string
+		req  MirrorRequest
+	}{
+		{name: "no target", req: MirrorRequest{Endpoint: "10.0.0.5"}},
+		{name: "both targets", req: MirrorRequest{Endpoint: "10.0.0.5", Interface: "dummy0", Collector: net.ParseIP("192.168.0.10")}},
+		{name: "too long", req: MirrorRequest{Endpoint: "10.0.0.5", Interface: "dummy0", DurationSeconds: 7200}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := mirrors.Start(tc.req); err == nil {
+				t.Fatal("expected request to be rejected")
+			}
+		})
+	}
+
+	session, err := mirrors.Start(MirrorRequest{Endpoint: "10.0.0.5", Collector: net.ParseIP("192.168.0.10")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{
+		"ip link add rmirror1 type vxlan id 4242 remote 192.168.0.10",
+		"tc filter add dev romana-abc ingress pref 49152 matchall action mirred egress mirror dev rmirror1",
+		"tc filter add dev romana-abc egress pref 49152 matchall action mirred egress mirror dev rmirror1",
+	} {
+		if !strings.Contains(*exec.Commands, expect) {
+			t.Fatalf("expected %q in commands\n%s", expect, *exec.Commands)
+		}
+	}
+
+	if _, err := mirrors.Start(MirrorRequest{Endpoint: "romana-abc", Interface: "dummy0"}); err == nil {
+		t.Fatal("expected second session for the same endpoint to be rejected")
+	}
+
+	exec.Commands = nil
+	if err := mirrors.Stop(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(*exec.Commands, "ip link del rmirror1") || len(mirrors.List()) != 0 {
+		t.Fatalf("expected session to be torn down, commands\n%s", *exec.Commands)
+	}
+}
+
+func TestMirrorsHandlerAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(role string) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"sub":   "user",
+			"aud":   "test",
+			"roles": []string{role},
+		}).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	auth := common.AuthMiddleware{PublicKey: &key.PublicKey, Audience: "test"}
+	handler := NewMirrors(&utilexec.FakeExecutor{}).Handler("/mirrors", auth)
+
+	for i, tc := range []struct {
+		method string
+		token  string
+		expect int
+	}{
+		{http.MethodGet, "", http.StatusUnauthorized},
+		{http.MethodGet, "garbage", http.StatusUnauthorized},
+		{http.MethodGet, token("viewer"), http.StatusOK},
+		{http.MethodDelete, token("viewer"), http.StatusForbidden},
+		{http.MethodDelete, token(common.RoleAdmin), http.StatusNotFound},
+	} {
+		path := "/mirrors"
+		if tc.method == http.MethodDelete {
+			path += "/1"
+		}
+		req := httptest.NewRequest(tc.method, path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.expect {
+			t.Errorf("%d: expected status %d for %s, got %d", i, tc.expect, tc.method, rec.Code)
+		}
+	}
+}
+
+func TestMirrorStartRequiresAuth(t *testing.T) {
+	if err := MirrorStart("127.0.0.1", 9608, common.APIAuth{}, NewMirrors(&utilexec.FakeExecutor{})); err == nil {
+		t.Fatal("expected mirroring API not to start without authentication")
+	}
+}
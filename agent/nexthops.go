@@ -0,0 +1,146 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/romana/core/common/api"
+	log "github.com/romana/rlog"
+	"github.com/vishvananda/netlink"
+)
+
+// NextHops tracks liveness of next hops of groups, blocks of hosts
+// behind next hops are routed via those that are alive, see
+// createRouteToBlock.
+type NextHops struct {
+	mu   sync.Mutex
+	dead map[string]bool
+
+	// probe makes the kernel resolve neighbor entry of a next hop.
+	probe func(net.IP) error
+}
+
+// NewNextHops returns NextHops considering all next hops alive
+// until Check finds them dead.
+func NewNextHops() *NextHops {
+	return &NextHops{dead: make(map[string]bool), probe: probeNextHop}
+}
+
+// Live returns next hops of the block in its address family that
+// are not known to be dead, nil NextHops considers all of them alive.
+func (n *NextHops) Live(block api.IPAMBlockResponse) []net.IP {
+	ipv4 := block.CIDR.IP.To4() != nil
+	var live []net.IP
+	for _, ip := range block.NextHops {
+		if (ip.To4() != nil) != ipv4 {
+			continue
+		}
+		if n != nil && n.isDead(ip) {
+			log.Debugf("Next hop %s of block %s is dead, skipping it", ip, block.CIDR)
+			continue
+		}
+		live = append(live, ip)
+	}
+	return live
+}
+
+func (n *NextHops) isDead(ip net.IP) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.dead[ip.String()]
+}
+
+type nlHandleNeigh interface {
+	NeighList(linkIndex, family int) ([]netlink.Neigh, error)
+}
+
+// Check updates liveness of next hops of the blocks from their
+// neighbor entries and probes them, so that the kernel keeps
+// resolving them for the next check: a next hop is dead once its
+// neighbor entry fails to resolve, and alive again when it resolves.
+// Returns true if any next hop died or came back, routes have to be
+// rebuilt then.
+func (n *NextHops) Check(blocks []api.IPAMBlockResponse, nlHandle nlHandleNeigh) bool {
+	nextHops := make(map[string]net.IP)
+	for _, block := range blocks {
+		for _, ip := range block.NextHops {
+			nextHops[ip.String()] = ip
+		}
+	}
+	if len(nextHops) == 0 {
+		return false
+	}
+
+	states := make(map[string]int)
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		neighs, err := nlHandle.NeighList(0, family)
+		if err != nil {
+			log.Errorf("failed to list neighbors to check next hops, %s", err)
+			return false
+		}
+		for _, neigh := range neighs {
+			states[neigh.IP.String()] = neigh.State
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	changed := false
+	for key, ip := range nextHops {
+		dead := n.dead[key]
+		if state, ok := states[key]; ok && state&netlink.NUD_INCOMPLETE == 0 {
+			// Entries still being resolved keep last known liveness.
+			dead = state&netlink.NUD_FAILED != 0
+		}
+		if dead != n.dead[key] {
+			changed = true
+			if dead {
+				log.Infof("Next hop %s is dead, withdrawing it from routes", ip)
+				n.dead[key] = true
+			} else {
+				log.Infof("Next hop %s is alive, restoring it in routes", ip)
+				delete(n.dead, key)
+			}
+		}
+		if err := n.probe(ip); err != nil {
+			log.Debugf("failed to probe next hop %s, %s", ip, err)
+		}
+	}
+
+	// Forget next hops no longer used by any group.
+	for key := range n.dead {
+		if _, ok := nextHops[key]; !ok {
+			delete(n.dead, key)
+		}
+	}
+	return changed
+}
+
+// probeNextHop sends an empty datagram to the discard port
+// of the next hop, which makes the kernel resolve it.
+func probeNextHop(ip net.IP) error {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(ip.String(), "9"), time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(nil)
+	return err
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"net"
+	"testing"
+
+	"github.com/romana/core/common/api"
+	"github.com/vishvananda/netlink"
+)
+
+type neighHandle struct {
+	neighs []netlink.Neigh
+}
+
+func (h *neighHandle) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	var neighs []netlink.Neigh
+	for _, neigh := range h.neighs {
+		if (neigh.IP.To4() != nil) == (family == netlink.FAMILY_V4) {
+			neighs = append(neighs, neigh)
+		}
+	}
+	return neighs, nil
+}
+
+func TestNextHopsCheck(t *testing.T) {
+	hop1 := net.ParseIP("192.168.1.1")
+	hop2 := net.ParseIP("192.168.1.2")
+	_, ipnet, _ := net.ParseCIDR("10.0.0.0/28")
+	blocks := []api.IPAMBlockResponse{
+		{CIDR: api.IPNet{IPNet: *ipnet}, NextHops: []net.IP{hop1, hop2}},
+	}
+
+	var probed []string
+	nextHops := NewNextHops()
+	nextHops.probe = func(ip net.IP) error {
+		probed = append(probed, ip.String())
+		return nil
+	}
+
+	steps := []struct {
+		name    string
+		state   int
+		changed bool
+		live    int
+	}{
+		{"reachable", netlink.NUD_REACHABLE, false, 2},
+		{"failed", netlink.NUD_FAILED, true, 1},
+		{"still failed", netlink.NUD_FAILED, false, 1},
+		{"resolving", netlink.NUD_INCOMPLETE, false, 1},
+		{"resolved", netlink.NUD_STALE, true, 2},
+	}
+	for _, step := range steps {
+		probed = nil
+		handle := &neighHandle{neighs: []netlink.Neigh{
+			{IP: hop1, State: netlink.NUD_REACHABLE},
+			{IP: hop2, State: step.state},
+		}}
+		if changed := nextHops.Check(blocks, handle); changed != step.changed {
+			t.Errorf("%s: expected changed %t, got %t", step.name, step.changed, changed)
+		}
+		if live := nextHops.Live(blocks[0]); len(live) != step.live {
+			t.Errorf("%s: expected %d live next hops, got %v", step.name, step.live, live)
+		}
+		if len(probed) != 2 {
+			t.Errorf("%s: expected both next hops probed, got %v", step.name, probed)
+		}
+	}
+
+	// Next hops no longer used are forgotten.
+	nextHops.dead[hop2.String()] = true
+	nextHops.Check([]api.IPAMBlockResponse{{CIDR: api.IPNet{IPNet: *ipnet}, NextHops: []net.IP{hop1}}}, &neighHandle{})
+	if len(nextHops.dead) != 0 {
+		t.Errorf("expected unused next hops to be forgotten, got %v", nextHops.dead)
+	}
+}
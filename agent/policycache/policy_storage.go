@@ -16,61 +16,327 @@
 package policycache
 
 import (
-	"github.com/romana/core/agent/cache"
+	"crypto/sha1"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/romana/core/agent/policyhasher"
 	"github.com/romana/core/common/api"
 )
 
-type Interface interface {
-	Put(string, api.Policy)
+// Reader gives read access to policies. Slices and maps returned
+// by readers are shared and must not be modified.
+type Reader interface {
 	Get(string) (api.Policy, bool)
-	Delete(string)
 	List() []api.Policy
 	Keys() []string
+
+	// ByID returns policies indexed by policy ID.
+	ByID() map[string]api.Policy
+
+	// ForTenant returns policies applied to the tenant.
+	ForTenant(string) []api.Policy
+}
+
+type Interface interface {
+	Reader
+
+	Put(string, api.Policy) Update
+	Delete(string) Update
+
+	// Replace replaces all policies in the storage at once.
+	Replace(map[string]api.Policy) Update
+
+	// Snapshot returns current content of the storage,
+	// it doesn't change with later updates.
+	Snapshot() *Snapshot
 }
 
+// Update describes a change of the storage.
+type Update struct {
+	// Digest of all policies after the change.
+	Digest string
+
+	// Changed holds sorted IDs of policies that were added,
+	// modified or deleted, empty when nothing changed.
+	Changed []string
+}
+
+// Merge returns update combining u with a later update.
+func (u Update) Merge(later Update) Update {
+	if len(later.Changed) == 0 {
+		return u
+	}
+	return Update{
+		Digest:  later.Digest,
+		Changed: sortedIDs(append(u.Changed[:len(u.Changed):len(u.Changed)], later.Changed...)),
+	}
+}
+
+// PolicyStorage keeps policies in an immutable snapshot which
+// is swapped atomically on every update, so readers never wait
+// for writers, and writers copy the snapshot under a lock.
 type PolicyStorage struct {
-	store cache.Interface
+	mu      sync.Mutex
+	current atomic.Value
 }
 
 func New() Interface {
-	return &PolicyStorage{cache.New()}
+	p := &PolicyStorage{}
+	p.current.Store(newSnapshot(nil, digest{}))
+	return p
 }
 
-func (p *PolicyStorage) Put(key string, policy api.Policy) {
-	p.store.Put(key, policy)
+func (p *PolicyStorage) Snapshot() *Snapshot {
+	return p.current.Load().(*Snapshot)
 }
 
-func (p *PolicyStorage) Get(key string) (api.Policy, bool) {
-	item, ok := p.store.Get(key)
-	if !ok {
-		return api.Policy{}, ok
+// Put stores the policy, only the policy is hashed and the
+// digest of the storage is updated with its hash. Hash covers
+// fields that are enforced, policy that differs in other fields
+// e.g. bandwidth is stored but not reported as changed.
+func (p *PolicyStorage) Put(key string, policy api.Policy) Update {
+	hash := policyhasher.HashRomanaPolicy(policy)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := p.Snapshot()
+	oldHash, exists := old.hashes[key]
+	if exists && oldHash == hash && reflect.DeepEqual(old.policies[key], policy) {
+		return Update{Digest: old.Digest()}
+	}
+
+	entries := old.copyEntries()
+	entries[key] = entry{policy: policy, hash: hash}
+	if exists && oldHash == hash {
+		p.current.Store(newSnapshot(entries, old.digest))
+		return Update{Digest: old.Digest()}
 	}
+	d := old.digest
+	if exists {
+		d.toggle(key, oldHash)
+	}
+	d.toggle(key, hash)
+
+	changed := []string{policy.ID}
+	if exists && old.policies[key].ID != policy.ID {
+		changed = append(changed, old.policies[key].ID)
+	}
+
+	snapshot := newSnapshot(entries, d)
+	p.current.Store(snapshot)
+	return Update{Digest: snapshot.Digest(), Changed: sortedIDs(changed)}
+}
+
+func (p *PolicyStorage) Delete(key string) Update {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	policy, ok := item.(api.Policy)
+	old := p.Snapshot()
+	policy, ok := old.policies[key]
 	if !ok {
-		return api.Policy{}, ok
+		return Update{Digest: old.Digest()}
 	}
 
-	return policy, ok
+	entries := old.copyEntries()
+	delete(entries, key)
+	d := old.digest
+	d.toggle(key, old.hashes[key])
+
+	snapshot := newSnapshot(entries, d)
+	p.current.Store(snapshot)
+	return Update{Digest: snapshot.Digest(), Changed: []string{policy.ID}}
+}
+
+// Replace hashes every policy, policies with the same hash
+// as before are not reported as changed. Policies are always
+// stored, as they may differ in fields that are not hashed.
+func (p *PolicyStorage) Replace(policies map[string]api.Policy) Update {
+	entries := make(map[string]entry, len(policies))
+	var d digest
+	for key, policy := range policies {
+		hash := policyhasher.HashRomanaPolicy(policy)
+		entries[key] = entry{policy: policy, hash: hash}
+		d.toggle(key, hash)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := p.Snapshot()
+	var changed []string
+	for key, e := range entries {
+		if old.hashes[key] != e.hash {
+			changed = append(changed, e.policy.ID)
+		}
+	}
+	for key, policy := range old.policies {
+		if _, ok := entries[key]; !ok || entries[key].policy.ID != policy.ID {
+			changed = append(changed, policy.ID)
+		}
+	}
+	snapshot := newSnapshot(entries, d)
+	p.current.Store(snapshot)
+	if d == old.digest {
+		return Update{Digest: snapshot.Digest()}
+	}
+	return Update{Digest: snapshot.Digest(), Changed: sortedIDs(changed)}
+}
+
+func (p *PolicyStorage) Get(key string) (api.Policy, bool) {
+	return p.Snapshot().Get(key)
 }
 
 func (p *PolicyStorage) List() []api.Policy {
-	var result []api.Policy
-	items := p.store.List()
-	for _, item := range items {
-		policy, ok := item.(api.Policy)
-		if !ok {
-			continue
+	return p.Snapshot().List()
+}
+
+func (p *PolicyStorage) Keys() []string {
+	return p.Snapshot().Keys()
+}
+
+func (p *PolicyStorage) ByID() map[string]api.Policy {
+	return p.Snapshot().ByID()
+}
+
+func (p *PolicyStorage) ForTenant(tenant string) []api.Policy {
+	return p.Snapshot().ForTenant(tenant)
+}
+
+// Digest returns combined hash of all policies in the storage.
+func (p *PolicyStorage) Digest() string {
+	return p.Snapshot().Digest()
+}
+
+// entry is a policy with its hash.
+type entry struct {
+	policy api.Policy
+	hash   string
+}
+
+// digest combines hashes of policies by key, a policy is added
+// to or removed from it by toggling its hash, so it doesn't
+// depend on the order of policies and is never recomputed.
+type digest [sha1.Size]byte
+
+func (d *digest) toggle(key, hash string) {
+	sum := sha1.Sum([]byte(key + "\x00" + hash))
+	for i := range d {
+		d[i] ^= sum[i]
+	}
+}
+
+func sortedIDs(ids []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
 		}
-		result = append(result, policy)
 	}
+	sort.Strings(result)
 	return result
 }
 
-func (p *PolicyStorage) Keys() []string {
-	return p.store.Keys()
+// Snapshot is an immutable set of policies indexed
+// at the time it was made.
+type Snapshot struct {
+	digest   digest
+	hashes   map[string]string
+	policies map[string]api.Policy
+	keys     []string
+	list     []api.Policy
+	byID     map[string]api.Policy
+	byTenant map[string][]api.Policy
+}
+
+// newSnapshot indexes policies with the digest of their hashes.
+func newSnapshot(entries map[string]entry, d digest) *Snapshot {
+	s := &Snapshot{
+		digest:   d,
+		hashes:   make(map[string]string, len(entries)),
+		policies: make(map[string]api.Policy, len(entries)),
+		keys:     make([]string, 0, len(entries)),
+		list:     make([]api.Policy, 0, len(entries)),
+		byID:     make(map[string]api.Policy, len(entries)),
+		byTenant: make(map[string][]api.Policy),
+	}
+
+	for key, e := range entries {
+		s.keys = append(s.keys, key)
+		s.hashes[key] = e.hash
+		s.policies[key] = e.policy
+	}
+	sort.Strings(s.keys)
+
+	for _, key := range s.keys {
+		policy := s.policies[key]
+		s.list = append(s.list, policy)
+		s.byID[policy.ID] = policy
+
+		seen := make(map[string]bool)
+		for _, target := range policy.AppliedTo {
+			if target.TenantID == "" || seen[target.TenantID] {
+				continue
+			}
+			seen[target.TenantID] = true
+			s.byTenant[target.TenantID] = append(s.byTenant[target.TenantID], policy)
+		}
+	}
+
+	return s
+}
+
+func (s *Snapshot) copyEntries() map[string]entry {
+	result := make(map[string]entry, len(s.policies)+1)
+	for key, policy := range s.policies {
+		result[key] = entry{policy: policy, hash: s.hashes[key]}
+	}
+	return result
+}
+
+func (s *Snapshot) Get(key string) (api.Policy, bool) {
+	policy, ok := s.policies[key]
+	return policy, ok
+}
+
+// List returns policies ordered by key.
+func (s *Snapshot) List() []api.Policy {
+	return s.list[:len(s.list):len(s.list)]
+}
+
+// Keys returns sorted keys of policies.
+func (s *Snapshot) Keys() []string {
+	return s.keys[:len(s.keys):len(s.keys)]
+}
+
+func (s *Snapshot) ByID() map[string]api.Policy {
+	return s.byID
+}
+
+func (s *Snapshot) ForTenant(tenant string) []api.Policy {
+	list := s.byTenant[tenant]
+	return list[:len(list):len(list)]
+}
+
+// Hash returns hash of the policy by key.
+func (s *Snapshot) Hash(key string) (string, bool) {
+	hash, ok := s.hashes[key]
+	return hash, ok
+}
+
+// Digest returns combined hash of policies in the snapshot,
+// it is the same for snapshots with the same policies.
+func (s *Snapshot) Digest() string {
+	return hex.EncodeToString(s.digest[:])
 }
 
-func (p *PolicyStorage) Delete(key string) {
-	p.store.Delete(key)
+// Len returns number of policies in the snapshot.
+func (s *Snapshot) Len() int {
+	return len(s.policies)
 }
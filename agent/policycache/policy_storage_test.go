@@ -0,0 +1,216 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package policycache
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/romana/core/common/api"
+)
+
+func makePolicy(id string, tenants ...string) api.Policy {
+	policy := api.Policy{ID: id, Direction: api.PolicyDirectionIngress}
+	for _, tenant := range tenants {
+		policy.AppliedTo = append(policy.AppliedTo, api.Endpoint{TenantID: tenant, SegmentID: "s1"})
+		policy.AppliedTo = append(policy.AppliedTo, api.Endpoint{TenantID: tenant, SegmentID: "s2"})
+	}
+	return policy
+}
+
+func ids(policies []api.Policy) []string {
+	var result []string
+	for _, policy := range policies {
+		result = append(result, policy.ID)
+	}
+	return result
+}
+
+func TestPolicyStorage(t *testing.T) {
+	storage := New()
+	storage.Put("/policies/b", makePolicy("b", "t1", "t2"))
+	storage.Put("/policies/a", makePolicy("a", "t1"))
+	storage.Put("/policies/c", makePolicy("c"))
+
+	before := storage.Snapshot()
+	storage.Delete("/policies/a")
+	storage.Put("/policies/d", makePolicy("d", "t2"))
+
+	if got, want := ids(before.List()), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected snapshot to keep %v, got %v", want, got)
+	}
+	if got, want := ids(storage.List()), []string{"b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got, want := storage.Keys(), []string{"/policies/b", "/policies/c", "/policies/d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected keys %v, got %v", want, got)
+	}
+
+	if got, want := ids(before.ForTenant("t1")), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected policies %v for t1 in snapshot, got %v", want, got)
+	}
+	if got, want := ids(storage.ForTenant("t1")), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected policies %v for t1, got %v", want, got)
+	}
+	if got, want := ids(storage.ForTenant("t2")), []string{"b", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected policies %v for t2, got %v", want, got)
+	}
+	if got := storage.ForTenant("t3"); len(got) != 0 {
+		t.Errorf("expected no policies for t3, got %v", ids(got))
+	}
+
+	if _, ok := storage.ByID()["a"]; ok {
+		t.Errorf("expected deleted policy a to be missing")
+	}
+	if _, ok := before.ByID()["a"]; !ok {
+		t.Errorf("expected policy a in snapshot")
+	}
+	if policy, ok := storage.Get("/policies/d"); !ok || policy.ID != "d" {
+		t.Errorf("expected policy d, got %v, %t", policy, ok)
+	}
+
+	storage.Replace(map[string]api.Policy{"/policies/e": makePolicy("e", "t1")})
+	if got, want := ids(storage.List()), []string{"e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after replace, got %v", want, got)
+	}
+	if got, want := ids(storage.ForTenant("t1")), []string{"e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected policies %v for t1 after replace, got %v", want, got)
+	}
+}
+
+// TestPolicyStorageConcurrent is meant to run with -race,
+// readers must always see a complete snapshot.
+func TestPolicyStorageConcurrent(t *testing.T) {
+	const count = 100
+
+	storage := New()
+	policies := make(map[string]api.Policy)
+	for i := 0; i < count; i++ {
+		policies[fmt.Sprintf("/policies/p%d", i)] = makePolicy(fmt.Sprintf("p%d", i), "t1")
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				snapshot := storage.Snapshot()
+				if n := len(snapshot.List()); n != snapshot.Len() || len(snapshot.ForTenant("t1")) != n {
+					t.Errorf("inconsistent snapshot of %d policies", snapshot.Len())
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		storage.Replace(policies)
+		storage.Put("/policies/extra", makePolicy("extra", "t1"))
+		storage.Delete("/policies/p0")
+		storage.Replace(nil)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestPolicyStorageDigest(t *testing.T) {
+	storage := New()
+	empty := storage.Snapshot().Digest()
+
+	update := storage.Put("/policies/a", makePolicy("a", "t1"))
+	if got, want := update.Changed, []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v to change, got %v", want, got)
+	}
+	update = storage.Put("/policies/b", makePolicy("b", "t2"))
+	if got, want := update.Changed, []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v to change, got %v", want, got)
+	}
+	digest := update.Digest
+
+	// same policy again doesn't change anything.
+	update = storage.Put("/policies/a", makePolicy("a", "t1"))
+	if len(update.Changed) != 0 || update.Digest != digest {
+		t.Errorf("expected no change, got %v", update)
+	}
+
+	// digest doesn't depend on order of updates.
+	other := New()
+	other.Put("/policies/b", makePolicy("b", "t2"))
+	other.Put("/policies/a", makePolicy("a", "t3"))
+	other.Put("/policies/a", makePolicy("a", "t1"))
+	if other.Snapshot().Digest() != digest {
+		t.Errorf("expected digest %s, got %s", digest, other.Snapshot().Digest())
+	}
+
+	update = storage.Replace(map[string]api.Policy{
+		"/policies/a": makePolicy("a", "t1"),
+		"/policies/b": makePolicy("b", "t2"),
+	})
+	if len(update.Changed) != 0 || update.Digest != digest {
+		t.Errorf("expected no change after replace with same policies, got %v", update)
+	}
+
+	update = storage.Replace(map[string]api.Policy{
+		"/policies/a": makePolicy("a", "t2"),
+		"/policies/c": makePolicy("c"),
+	})
+	if got, want := update.Changed, []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v to change after replace, got %v", want, got)
+	}
+
+	update = storage.Delete("/policies/a").Merge(storage.Delete("/policies/c"))
+	if got, want := update.Changed, []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v to change, got %v", want, got)
+	}
+	if update.Digest != empty {
+		t.Errorf("expected digest of empty storage %s, got %s", empty, update.Digest)
+	}
+	if update = storage.Delete("/policies/a"); len(update.Changed) != 0 {
+		t.Errorf("expected no change deleting missing policy, got %v", update)
+	}
+}
+
+func TestPolicyStorageNotEnforcedChange(t *testing.T) {
+	storage := New()
+	policy := makePolicy("a", "t1")
+	storage.Put("/policies/a", policy)
+
+	policy.Bandwidth = &api.Bandwidth{IngressKbps: 1000}
+	if update := storage.Put("/policies/a", policy); len(update.Changed) != 0 {
+		t.Errorf("expected bandwidth not to be reported as changed, got %v", update)
+	}
+	if got, _ := storage.Get("/policies/a"); !reflect.DeepEqual(got, policy) {
+		t.Errorf("expected policy %v to be stored, got %v", policy, got)
+	}
+
+	policy.Bandwidth = &api.Bandwidth{IngressKbps: 2000}
+	if update := storage.Replace(map[string]api.Policy{"/policies/a": policy}); len(update.Changed) != 0 {
+		t.Errorf("expected bandwidth not to be reported as changed after replace, got %v", update)
+	}
+	if got, _ := storage.Get("/policies/a"); !reflect.DeepEqual(got, policy) {
+		t.Errorf("expected policy %v to be stored after replace, got %v", policy, got)
+	}
+}
@@ -17,7 +17,6 @@ package policycontroller
 
 import (
 	"context"
-	"encoding/json"
 	"time"
 
 	"github.com/romana/core/agent/policycache"
@@ -31,86 +30,346 @@ import (
 
 const (
 	defaultWatcherReconnectTime = 5 * time.Second
+
+	// watcherReconnectInitialTime and watcherReconnectMaxTime
+	// bound delays between attempts to re-establish policy watch.
+	watcherReconnectInitialTime = 500 * time.Millisecond
+	watcherReconnectMaxTime     = 30 * time.Second
+
+	// maxResumeFailures is how many times in a row watch may fail
+	// to resume from the last seen index before policies are
+	// listed again from scratch.
+	maxResumeFailures = 3
 )
 
-func Run(ctx context.Context, key string, client *client.Client, storage policycache.Interface) (<-chan api.Policy, error) {
-	policies, err := client.Store.GetExt(key, store.GetOptions{Recursive: true})
+// etcdSource is a part of the store used to watch policies in etcd.
+type etcdSource interface {
+	// list returns policies by etcd key and etcd index of the listing.
+	list() (map[string]api.Policy, uint64, error)
+
+	// watch returns changes made after the index.
+	watch(afterIndex uint64, stopCh <-chan struct{}) (<-chan *store.KVPairExt, error)
+
+	// decrypt returns plain value of the key received from watch.
+	decrypt(key string, value []byte) ([]byte, error)
+}
+
+// storeSource implements etcdSource with romana store.
+type storeSource struct {
+	key   string
+	store *client.Store
+}
+
+func (s storeSource) list() (map[string]api.Policy, uint64, error) {
+	policies, err := s.store.GetExt(s.key, store.GetOptions{Recursive: true})
 	if err != nil {
-		return nil, errors.Wrap(err, "controller init fail")
+		return nil, 0, err
 	}
 
-	for _, val := range policies.GetResponse().Node.Nodes {
+	resp := policies.GetResponse()
+	result := make(map[string]api.Policy)
+	for _, val := range resp.Node.Nodes {
+		value, err := s.decrypt(val.Key, []byte(val.Value))
+		if err != nil {
+			return nil, 0, err
+		}
+
 		var policy api.Policy
-		err := json.Unmarshal([]byte(val.Value), &policy)
+		err = client.DecodeObject(client.KindPolicy, value, &policy)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to unmarshal policy")
+			return nil, 0, errors.Wrap(err, "failed to unmarshal policy")
 		}
+		result[val.Key] = policy
+	}
 
-		storage.Put(val.Key, policy)
+	return result, resp.Index, nil
+}
+
+func (s storeSource) decrypt(key string, value []byte) ([]byte, error) {
+	return s.store.Decrypt(key, value)
+}
+
+func (s storeSource) watch(afterIndex uint64, stopCh <-chan struct{}) (<-chan *store.KVPairExt, error) {
+	return s.store.WatchExt(
+		s.key,
+		store.WatcherOptions{Recursive: true,
+			NoList:     true,
+			AfterIndex: afterIndex,
+		},
+		stopCh)
+}
+
+// Run loads policies under the key into storage and keeps storage
+// in sync with the store, every change of policies is sent to the
+// returned channel, changes that don't alter the digest of the storage
+// are not sent.
+// With etcd, the watch resumes from the last seen index after it drops,
+// and policies are listed again if etcd can't resume it anymore, or
+// every resync interval in case the watch missed a change, 0 disables
+// periodic resync.
+func Run(ctx context.Context, key string, client *client.Client, storage policycache.Interface, resync time.Duration) (<-chan policycache.Update, error) {
+	if !client.Store.IsEtcd() {
+		return runListingWatch(ctx, key, client, storage)
 	}
 
-	respCh, err := client.Store.WatchExt(
-		key, store.WatcherOptions{Recursive: true, NoList: true}, ctx.Done())
+	return runEtcdWatch(ctx, storeSource{key: key, store: client.Store}, storage, resync)
+}
+
+func runEtcdWatch(ctx context.Context, source etcdSource, storage policycache.Interface, resync time.Duration) (<-chan policycache.Update, error) {
+	lastIndex, _, err := loadPolicies(source, storage)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to start watching")
+		return nil, errors.Wrap(err, "controller init fail")
 	}
 
-	updateStorage := func(action, key string, policy api.Policy) {
-		switch action {
-		case "set", "update", "create", "compareAndSwap":
-			storage.Put(key, policy)
-		case "delete":
-			storage.Delete(key)
-		}
+	policyOut := make(chan policycache.Update)
+	go watchPolicies(ctx, source, storage, lastIndex, resync, policyOut)
+
+	return policyOut, nil
+}
+
+// loadPolicies replaces content of the storage with policies
+// listed from the source, returns index of the listing.
+func loadPolicies(source etcdSource, storage policycache.Interface) (uint64, policycache.Update, error) {
+	policies, index, err := source.list()
+	if err != nil {
+		return 0, policycache.Update{}, err
 	}
 
-	policyOut := make(chan api.Policy)
-	var LastIndex uint64
-	go func() {
-		var err error
-		for {
+	return index, storage.Replace(policies), nil
+}
+
+// watchPolicies applies changes made after lastIndex to the storage
+// until ctx is done, re-establishing the watch with backoff.
+func watchPolicies(ctx context.Context, source etcdSource, storage policycache.Interface, lastIndex uint64, resync time.Duration, policyOut chan<- policycache.Update) {
+	defer WatchUp.Set(0)
+
+	var resyncCh <-chan time.Time
+	if resync > 0 {
+		ticker := time.NewTicker(resync)
+		defer ticker.Stop()
+		resyncCh = ticker.C
+	}
+
+	backoff := client.Backoff{Initial: watcherReconnectInitialTime, Max: watcherReconnectMaxTime}
+	var failures int
+	var relist bool
+
+	for {
+		if relist {
+			index, update, err := loadPolicies(source, storage)
+			if err == nil {
+				log.Infof("Listed policies again at index %d, %d policies changed", index, len(update.Changed))
+				WatchRelists.Inc()
+				lastIndex, relist, failures = index, false, 0
+
+				// policies could change while watch was down.
+				if !sendUpdate(ctx, policyOut, update) {
+					return
+				}
+			} else {
+				log.Errorf("Failed to list policies, %s", err)
+				WatchErrors.Inc()
+			}
+		}
+
+		if !relist {
+			respCh, err := source.watch(lastIndex, ctx.Done())
 			if err != nil {
 				log.Errorf("policy watcher store error: %s", err)
-				// if we can't connect to the kvstore, wait for
-				// few seconds and try reconnecting.
-				time.Sleep(defaultWatcherReconnectTime)
-				respCh, _ = client.Store.WatchExt(
-					key,
-					store.WatcherOptions{Recursive: true,
-						NoList:     true,
-						AfterIndex: LastIndex,
-					},
-					ctx.Done())
+				WatchErrors.Inc()
+				failures++
+				relist = client.IsCompacted(err)
+			} else {
+				var received int
+				WatchUp.Set(1)
+				lastIndex, received = applyEvents(ctx, source, respCh, lastIndex, storage, resyncCh, policyOut)
+				WatchUp.Set(0)
+				if received > 0 {
+					backoff.Reset()
+					failures = 0
+				} else {
+					failures++
+				}
 			}
 
-			select {
-			case <-ctx.Done():
-				log.Printf("Stopping policy watcher module.")
-				return
+			if failures >= maxResumeFailures {
+				log.Infof("Failed to resume policy watch after index %d %d times, listing policies again", lastIndex, failures)
+				relist = true
+			}
+		}
 
-			case resp, ok := <-respCh:
-				if !ok || resp == nil {
-					err = errors.New("kvstore policy events channel closed")
-					continue
-				}
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping policy watcher module.")
+			return
+		case <-time.After(backoff.Next()):
+		}
+		WatchReconnects.Inc()
+	}
+}
 
-				LastIndex = resp.LastIndex
-				var p api.Policy
+// applyEvents applies changes received from the watch to the storage
+// until the watch drops, returns last seen index and number of changes.
+// Policies are listed again on every tick of resyncCh, events the
+// listing already includes are skipped.
+func applyEvents(ctx context.Context, source etcdSource, respCh <-chan *store.KVPairExt, lastIndex uint64, storage policycache.Interface, resyncCh <-chan time.Time, policyOut chan<- policycache.Update) (uint64, int) {
+	var received int
+	for {
+		select {
+		case <-ctx.Done():
+			return lastIndex, received
 
-				value := resp.Value
-				if resp.Action == "delete" {
-					value = resp.PrevValue
-				}
+		case <-resyncCh:
+			index, update, err := loadPolicies(source, storage)
+			if err != nil {
+				log.Errorf("Failed to resync policies, %s", err)
+				WatchErrors.Inc()
+				continue
+			}
+			WatchResyncs.Inc()
+			if len(update.Changed) > 0 {
+				log.Infof("Resync of policies at index %d found changes missed by watch in %v", index, update.Changed)
+			}
+			if index > lastIndex {
+				lastIndex = index
+			}
+			if !sendUpdate(ctx, policyOut, update) {
+				return lastIndex, received
+			}
 
-				if errp := json.Unmarshal([]byte(value), &p); errp != nil {
-					log.Printf("failed to unmarshal policy %v, err=%s", value, errp)
-					continue
-				}
+		case resp, ok := <-respCh:
+			if !ok || resp == nil {
+				log.Errorf("kvstore policy events channel closed after index %d", lastIndex)
+				return lastIndex, received
+			}
 
-				updateStorage(resp.Action, resp.Key, p)
-				policyOut <- p
+			received++
+			if resp.LastIndex <= lastIndex {
+				continue
 			}
+			lastIndex = resp.LastIndex
+			var p api.Policy
 
+			value := resp.Value
+			if resp.Action == "delete" {
+				value = resp.PrevValue
+			}
+
+			plain, errp := source.decrypt(resp.Key, []byte(value))
+			if errp != nil {
+				log.Errorf("failed to decrypt policy %s, err=%s", resp.Key, errp)
+				continue
+			}
+
+			if errp := client.DecodeObject(client.KindPolicy, plain, &p); errp != nil {
+				log.Errorf("failed to unmarshal policy %v, err=%s", value, errp)
+				continue
+			}
+
+			var update policycache.Update
+			switch resp.Action {
+			case "set", "update", "create", "compareAndSwap":
+				update = storage.Put(resp.Key, p)
+			case "delete":
+				update = storage.Delete(resp.Key)
+			}
+
+			if !sendUpdate(ctx, policyOut, update) {
+				return lastIndex, received
+			}
+		}
+	}
+}
+
+// sendUpdate sends the update unless nothing has changed.
+func sendUpdate(ctx context.Context, policyOut chan<- policycache.Update, update policycache.Update) bool {
+	if len(update.Changed) == 0 {
+		return true
+	}
+
+	select {
+	case policyOut <- update:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// runListingWatch keeps storage in sync with policies under the key
+// for backends without extended watches, e.g. consul.
+func runListingWatch(ctx context.Context, key string, romanaClient *client.Client, storage policycache.Interface) (<-chan policycache.Update, error) {
+	changesCh, err := romanaClient.Store.WatchTreeChanges(key, ctx.Done())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start watching")
+	}
+
+	// first batch holds policies that exist already.
+	var initial []client.KVChange
+	select {
+	case initial = <-changesCh:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(defaultWatcherReconnectTime):
+		// no policies yet
+	}
+
+	decode := func(change client.KVChange) (api.Policy, bool) {
+		value := change.Value
+		if value == nil {
+			value = change.PrevValue
+		}
+
+		var p api.Policy
+		if err := client.DecodeObject(client.KindPolicy, value, &p); err != nil {
+			log.Errorf("failed to unmarshal policy %s, err=%s", value, err)
+			return p, false
+		}
+		return p, true
+	}
+
+	// apply returns a single update for the batch of changes.
+	apply := func(changes []client.KVChange) policycache.Update {
+		var result policycache.Update
+		for _, change := range changes {
+			p, ok := decode(change)
+			if !ok {
+				continue
+			}
+
+			if change.Value == nil {
+				result = result.Merge(storage.Delete(change.Key))
+			} else {
+				result = result.Merge(storage.Put(change.Key, p))
+			}
+		}
+		return result
+	}
+
+	// existing policies are stored at once
+	// rather than one snapshot per policy.
+	policies := make(map[string]api.Policy, len(initial))
+	for _, change := range initial {
+		if p, ok := decode(change); ok && change.Value != nil {
+			policies[change.Key] = p
+		}
+	}
+	storage.Replace(policies)
+
+	policyOut := make(chan policycache.Update)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info("Stopping policy watcher module.")
+				return
+			case changes, ok := <-changesCh:
+				if !ok {
+					return
+				}
+				if !sendUpdate(ctx, policyOut, apply(changes)) {
+					return
+				}
+			}
 		}
 	}()
 
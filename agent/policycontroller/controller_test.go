@@ -0,0 +1,249 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package policycontroller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/romana/core/agent/policycache"
+	"github.com/romana/core/common/api"
+)
+
+// fakeSource implements etcdSource, every watch call takes
+// next prepared channel or error.
+type fakeSource struct {
+	mu       sync.Mutex
+	policies map[string]api.Policy
+	index    uint64
+	lists    int
+	watches  []fakeWatch
+	resumed  []uint64
+}
+
+type fakeWatch struct {
+	ch  chan *store.KVPairExt
+	err error
+}
+
+func (f *fakeSource) list() (map[string]api.Policy, uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lists++
+	result := make(map[string]api.Policy)
+	for k, v := range f.policies {
+		result[k] = v
+	}
+	return result, f.index, nil
+}
+
+func (f *fakeSource) decrypt(key string, value []byte) ([]byte, error) {
+	return value, nil
+}
+
+func (f *fakeSource) watch(afterIndex uint64, stopCh <-chan struct{}) (<-chan *store.KVPairExt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resumed = append(f.resumed, afterIndex)
+	if len(f.watches) == 0 {
+		// block forever
+		return make(chan *store.KVPairExt), nil
+	}
+	w := f.watches[0]
+	f.watches = f.watches[1:]
+	return w.ch, w.err
+}
+
+func policyEvent(t *testing.T, action, key string, index uint64, policy api.Policy) *store.KVPairExt {
+	b, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &store.KVPairExt{Action: action, Key: key, LastIndex: index}
+	if action == "delete" {
+		event.PrevValue = string(b)
+	} else {
+		event.Value = string(b)
+	}
+	return event
+}
+
+func expectUpdate(t *testing.T, ch <-chan policycache.Update, ids ...string) {
+	select {
+	case update := <-ch:
+		if !reflect.DeepEqual(update.Changed, ids) {
+			t.Fatalf("expected policies %q to change, got %q", ids, update.Changed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for policies %q", ids)
+	}
+}
+
+func TestEtcdWatchResume(t *testing.T) {
+	first := make(chan *store.KVPairExt, 1)
+	second := make(chan *store.KVPairExt, 1)
+	source := &fakeSource{
+		policies: map[string]api.Policy{"/policies/p1": {ID: "p1"}},
+		index:    10,
+		watches:  []fakeWatch{{ch: first}, {ch: second}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := policycache.New()
+	policies, err := runEtcdWatch(ctx, source, storage, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := storage.Get("/policies/p1"); !ok {
+		t.Fatal("expected initial policies to be loaded")
+	}
+
+	first <- policyEvent(t, "set", "/policies/p2", 12, api.Policy{ID: "p2"})
+	expectUpdate(t, policies, "p2")
+
+	// policy didn't change, no update is sent.
+	first <- policyEvent(t, "set", "/policies/p2", 12, api.Policy{ID: "p2"})
+
+	// watch drops and resumes after the last seen index.
+	close(first)
+	second <- policyEvent(t, "delete", "/policies/p1", 13, api.Policy{ID: "p1"})
+	expectUpdate(t, policies, "p1")
+
+	if _, ok := storage.Get("/policies/p1"); ok {
+		t.Fatal("expected policy p1 to be deleted")
+	}
+
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	if len(source.resumed) != 2 || source.resumed[0] != 10 || source.resumed[1] != 12 {
+		t.Fatalf("expected watches after index 10 and 12, got %v", source.resumed)
+	}
+}
+
+func TestEtcdWatchRelistOnCompaction(t *testing.T) {
+	source := &fakeSource{
+		policies: map[string]api.Policy{"/policies/p1": {ID: "p1"}},
+		index:    10,
+		watches: []fakeWatch{
+			{err: errors.New("401: The event in requested index is outdated and cleared")},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := policycache.New()
+	policies, err := runEtcdWatch(ctx, source, storage, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// policies changed while watch was down.
+	source.mu.Lock()
+	source.policies = map[string]api.Policy{"/policies/p3": {ID: "p3"}}
+	source.index = 20
+	source.mu.Unlock()
+
+	expectUpdate(t, policies, "p1", "p3")
+
+	if _, ok := storage.Get("/policies/p1"); ok {
+		t.Fatal("expected stale policy p1 to be removed")
+	}
+	if _, ok := storage.Get("/policies/p3"); !ok {
+		t.Fatal("expected policy p3 to be loaded")
+	}
+}
+
+func TestEtcdWatchResync(t *testing.T) {
+	events := make(chan *store.KVPairExt, 1)
+	source := &fakeSource{
+		policies: map[string]api.Policy{"/policies/p1": {ID: "p1"}},
+		index:    10,
+		watches:  []fakeWatch{{ch: events}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := policycache.New()
+	policies, err := runEtcdWatch(ctx, source, storage, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// watch missed replacement of p1 with p2.
+	source.mu.Lock()
+	source.policies = map[string]api.Policy{"/policies/p2": {ID: "p2"}}
+	source.index = 20
+	source.mu.Unlock()
+
+	expectUpdate(t, policies, "p1", "p2")
+	if _, ok := storage.Get("/policies/p2"); !ok {
+		t.Fatal("expected policy p2 to be loaded")
+	}
+
+	// event included in the resync is skipped, later one
+	// is applied unless next resync gets it first.
+	source.mu.Lock()
+	source.policies["/policies/p3"] = api.Policy{ID: "p3"}
+	source.index = 21
+	source.mu.Unlock()
+
+	events <- policyEvent(t, "delete", "/policies/p2", 15, api.Policy{ID: "p2"})
+	events <- policyEvent(t, "set", "/policies/p3", 21, api.Policy{ID: "p3"})
+	expectUpdate(t, policies, "p3")
+	if _, ok := storage.Get("/policies/p2"); !ok {
+		t.Fatal("expected policy p2 to be kept")
+	}
+
+	// resync without changes sends nothing.
+	select {
+	case update := <-policies:
+		t.Fatalf("expected no updates, got %v", update)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// BenchmarkLoadPolicies measures refresh of the policy cache
+// with the number of policies listed from the store.
+func BenchmarkLoadPolicies(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("policies=%d", n), func(b *testing.B) {
+			source := &fakeSource{policies: make(map[string]api.Policy)}
+			for i := 0; i < n; i++ {
+				id := fmt.Sprintf("p%d", i)
+				source.policies["/policies/"+id] = api.Policy{ID: id}
+			}
+			storage := policycache.New()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := loadPolicies(source, storage); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
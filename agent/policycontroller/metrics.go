@@ -0,0 +1,78 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package policycontroller
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	WatchUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "romana_policy_watch_up",
+			Help: "1 when watch on policies is established, 0 otherwise.",
+		},
+	)
+	WatchReconnects = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_policy_watch_reconnects_total",
+			Help: "Number of attempts to re-establish watch on policies.",
+		},
+	)
+	WatchRelists = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_policy_watch_relists_total",
+			Help: "Number of times policies were listed again because watch couldn't resume.",
+		},
+	)
+	WatchResyncs = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_policy_watch_resyncs_total",
+			Help: "Number of periodic listings of policies made in case watch missed a change.",
+		},
+	)
+	WatchErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "romana_err_policy_watch_total",
+			Help: "Number of errors attempting to watch or list policies.",
+		},
+	)
+)
+
+// MetricsRegister registers package global metrics into registry provided,
+// for later exposure.
+func MetricsRegister(registry *prometheus.Registry) error {
+	if registry == nil {
+		return fmt.Errorf("registry must not be nil")
+	}
+
+	for _, collector := range []prometheus.Collector{
+		WatchUp,
+		WatchReconnects,
+		WatchRelists,
+		WatchResyncs,
+		WatchErrors,
+	} {
+		err := registry.Register(collector)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -95,7 +95,6 @@ func GetIPs(link netlink.Link) ([]string, error) {
 func linkAddDeleteIP(kvpair *kvstore.KVPairExt, toAdd bool,
 	defaultLink netlink.Link, defaultLinkAddressList []string) error {
 	var value string
-	var IPAddressOnThisNode bool
 
 	if kvpair == nil || (kvpair.Value == "" && kvpair.PrevValue == "") {
 		return fmt.Errorf("error retrieving value from the event notification")
@@ -107,6 +106,15 @@ func linkAddDeleteIP(kvpair *kvstore.KVPairExt, toAdd bool,
 		value = kvpair.PrevValue
 	}
 
+	return linkAddDeleteVIP(value, toAdd, defaultLink, defaultLinkAddressList)
+}
+
+// linkAddDeleteVIP adds or removes romana VIP described
+// by value to the link if VIP belongs to this node.
+func linkAddDeleteVIP(value string, toAdd bool,
+	defaultLink netlink.Link, defaultLinkAddressList []string) error {
+	var IPAddressOnThisNode bool
+
 	exposedIP := api.ExposedIPSpec{}
 	if err := json.Unmarshal([]byte(value), &exposedIP); err != nil {
 		return fmt.Errorf("error retrieving value from the event notification: %s", err)
@@ -159,6 +167,15 @@ func StartRomanaVIPSync(ctx context.Context, store *client.Store,
 		return fmt.Errorf("failed to get default link's IP address")
 	}
 
+	if !store.IsEtcd() {
+		changes, err := store.WatchTreeChanges(store.Key(client.RomanaVIPPrefix), ctx.Done())
+		if err != nil {
+			return fmt.Errorf("failed to watch romana VIPs: %s", err)
+		}
+		go romanaVIPListingWatcher(ctx, changes, defaultLink, defaultLinkAddressList)
+		return nil
+	}
+
 	go romanaVIPWatcher(ctx, store, defaultLink, defaultLinkAddressList)
 
 	return nil
@@ -170,7 +187,7 @@ func romanaVIPWatcher(ctx context.Context, store *client.Store,
 	var events <-chan *kvstore.KVPairExt
 
 	// Initial kvstore connection, ignore error since it is always nil.
-	events, _ = store.WatchTreeExt(client.DefaultEtcdPrefix+client.RomanaVIPPrefix, ctx.Done())
+	events, _ = store.WatchTreeExt(store.Key(client.RomanaVIPPrefix), ctx.Done())
 
 	for {
 		if storeError != nil {
@@ -179,7 +196,7 @@ func romanaVIPWatcher(ctx context.Context, store *client.Store,
 			// few seconds and try reconnecting.
 			time.Sleep(defaultWatcherReconnectTime)
 			events, _ = store.WatchTreeExt(
-				client.DefaultEtcdPrefix+client.RomanaVIPPrefix,
+				store.Key(client.RomanaVIPPrefix),
 				ctx.Done())
 		}
 
@@ -219,7 +236,33 @@ func romanaVIPWatcher(ctx context.Context, store *client.Store,
 			}
 
 		case <-ctx.Done():
-			log.Printf("Stopping romana VIP watcher module.")
+			log.Info("Stopping romana VIP watcher module.")
+			return
+		}
+	}
+}
+
+// romanaVIPListingWatcher syncs romana VIPs for backends
+// without extended watches, e.g. consul.
+func romanaVIPListingWatcher(ctx context.Context, changes <-chan []client.KVChange,
+	defaultLink netlink.Link, defaultLinkAddressList []string) {
+	for {
+		select {
+		case batch, ok := <-changes:
+			if !ok {
+				return
+			}
+			for _, change := range batch {
+				toAdd, value := true, change.Value
+				if change.Value == nil {
+					toAdd, value = false, change.PrevValue
+				}
+				if err := linkAddDeleteVIP(string(value), toAdd, defaultLink, defaultLinkAddressList); err != nil {
+					log.Errorf("error syncing romana VIP %s to the link: %s", change.Key, err)
+				}
+			}
+		case <-ctx.Done():
+			log.Info("Stopping romana VIP watcher module.")
 			return
 		}
 	}
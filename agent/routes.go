@@ -20,17 +20,26 @@ import (
 	"net"
 
 	"github.com/pkg/errors"
+	"github.com/romana/core/agent/rtable"
 	"github.com/romana/core/common/api"
 	log "github.com/romana/rlog"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 // CreateRouteToBlocks loops over list of blocks and creates routes when needed.
+// Links map interface selectors of the networks to the host interfaces
+// (see ResolveBlockLinks), blocks of the networks without an interface
+// are routed via whatever interface the kernel picks for the host.
+// Blocks of hosts behind next hops of their groups are routed via
+// next hops that nextHops doesn't consider dead.
 func CreateRouteToBlocks(blocks []api.IPAMBlockResponse,
 	hosts IpamHosts,
 	romanaRouteTableId int,
 	hostname string,
 	multihop bool,
+	links map[string]netlink.Link,
+	nextHops *NextHops,
 	nlHandle nlHandleRoute) {
 
 	var managedRoutes int
@@ -46,7 +55,12 @@ func CreateRouteToBlocks(blocks []api.IPAMBlockResponse,
 			continue
 		}
 
-		if err := createRouteToBlock(block, host, romanaRouteTableId, multihop, nlHandle); err != nil {
+		var link netlink.Link
+		if block.Interface != "" {
+			link = links[block.Interface]
+		}
+
+		if err := createRouteToBlock(block, host, romanaRouteTableId, multihop, link, nextHops, nlHandle); err != nil {
 			_, ok := err.(RouteAdjacencyError)
 			if ok {
 				// Lower severity for expected error
@@ -68,9 +82,12 @@ type nlHandleRoute interface {
 	RouteAdd(*netlink.Route) error
 }
 
-// createRouteToBlock creates ip route for given block->host pair in Romana routing table,
-// the function will fail if requested block is not directly adjacent and multihop false.
-func createRouteToBlock(block api.IPAMBlockResponse, host *api.Host, romanaRouteTableId int, multihop bool, nlHandle nlHandleRoute) error {
+// createRouteToBlock creates ip route for given block->host pair in Romana routing table
+// or in the dedicated table of the block's network,
+// the function will fail if requested block is not directly adjacent and multihop false,
+// unless the block's group has next hops, then a multipath route via the live ones is created.
+// If link is not nil the route is pinned to that link.
+func createRouteToBlock(block api.IPAMBlockResponse, host *api.Host, romanaRouteTableId int, multihop bool, link netlink.Link, nextHops *NextHops, nlHandle nlHandleRoute) error {
 	testRoutes, err := nlHandle.RouteGet(host.IP)
 	if err != nil {
 		return errors.Wrapf(err, "couldn't test host %s adjacency", host.IP)
@@ -84,20 +101,110 @@ func createRouteToBlock(block api.IPAMBlockResponse, host *api.Host, romanaRoute
 		return errors.New(fmt.Sprintf("no way to reach %s, no default gateway?", host.IP))
 	}
 
-	if testRoutes[0].Gw != nil && multihop == false {
-		return RouteAdjacencyError{}
-	}
-
 	route := netlink.Route{
 		Dst:   &block.CIDR.IPNet,
-		Gw:    host.IP,
 		Table: romanaRouteTableId,
 	}
+	if block.RouteTable != 0 {
+		route.Table = block.RouteTable
+		route.Protocol = rtable.RouteProtocol
+	}
+
+	if testRoutes[0].Gw != nil && len(block.NextHops) > 0 {
+		// Host is behind next hops of its group, e.g. a pair of
+		// routers, traffic to the block is balanced between them.
+		live := nextHops.Live(block)
+		if len(live) == 0 {
+			return errors.New(fmt.Sprintf("no live next hop to route block %s of host %s", block.CIDR, block.Host))
+		}
+		for _, ip := range live {
+			hop := &netlink.NexthopInfo{Gw: ip}
+			if link != nil {
+				hop.LinkIndex = link.Attrs().Index
+			}
+			route.MultiPath = append(route.MultiPath, hop)
+		}
+	} else {
+		if testRoutes[0].Gw != nil && multihop == false {
+			return RouteAdjacencyError{}
+		}
+		route.Gw = host.IP
+		if link != nil {
+			route.LinkIndex = link.Attrs().Index
+		}
+	}
 
 	log.Debugf("About to create route %v", route)
 	return nlHandle.RouteAdd(&route)
 }
 
+// BlockRouteTables returns dedicated route tables of the blocks' networks.
+func BlockRouteTables(blocks []api.IPAMBlockResponse) map[int]bool {
+	tables := make(map[int]bool)
+	for _, block := range blocks {
+		if block.RouteTable != 0 {
+			tables[block.RouteTable] = true
+		}
+	}
+	return tables
+}
+
+// SourceRouteRules returns local blocks of the networks with dedicated
+// route tables mapped to these tables, traffic from these blocks must be
+// looked up in the tables, see rtable.EnsureSourceRouteRules.
+func SourceRouteRules(blocks []api.IPAMBlockResponse, hostname string) map[string]int {
+	rules := make(map[string]int)
+	for _, block := range blocks {
+		if block.RouteTable != 0 && block.Host == hostname {
+			rules[block.CIDR.String()] = block.RouteTable
+		}
+	}
+	return rules
+}
+
+// Kinds of routes installed for blacked out CIDRs, see CreateBlackOutRoutes.
+const (
+	BlackOutRouteNone        = "none"
+	BlackOutRouteBlackhole   = "blackhole"
+	BlackOutRouteUnreachable = "unreachable"
+)
+
+// ParseBlackOutRouteType converts kind of route for blacked out CIDRs
+// into netlink route type, returns 0 for BlackOutRouteNone.
+func ParseBlackOutRouteType(s string) (int, error) {
+	switch s {
+	case BlackOutRouteNone, "":
+		return 0, nil
+	case BlackOutRouteBlackhole:
+		return unix.RTN_BLACKHOLE, nil
+	case BlackOutRouteUnreachable:
+		return unix.RTN_UNREACHABLE, nil
+	}
+	return 0, errors.Errorf("unknown route type %s for blacked out cidrs, expected one of %s, %s, %s",
+		s, BlackOutRouteNone, BlackOutRouteBlackhole, BlackOutRouteUnreachable)
+}
+
+// CreateBlackOutRoutes installs routes of routeType (unix.RTN_BLACKHOLE
+// or unix.RTN_UNREACHABLE) for blacked out CIDRs in Romana routing table,
+// so that traffic to reserved address space fails fast instead of being
+// forwarded upstream. Routes are removed when romana table is flushed,
+// so un-blacked out CIDRs disappear on next rebuild of the table.
+func CreateBlackOutRoutes(cidrs []api.IPNet, romanaRouteTableId int, routeType int, nlHandle nlHandleRoute) {
+	for _, cidr := range cidrs {
+		dst := cidr.IPNet
+		route := netlink.Route{
+			Dst:   &dst,
+			Table: romanaRouteTableId,
+			Type:  routeType,
+		}
+
+		log.Debugf("About to create route %v for blacked out cidr", route)
+		if err := nlHandle.RouteAdd(&route); err != nil {
+			log.Errorf("failed to create route for blacked out cidr %s, %s", cidr, err)
+		}
+	}
+}
+
 type RouteAdjacencyError struct{}
 
 func (RouteAdjacencyError) Error() string {
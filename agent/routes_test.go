@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/pkg/errors"
+	"github.com/romana/core/agent/rtable"
 	"github.com/romana/core/common/api"
 	"github.com/vishvananda/netlink"
 )
@@ -109,10 +110,117 @@ func TestCreateRouteToBlock(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			err := createRouteToBlock(tc.block, tc.host, 10, tc.multihop, tc.testHandle)
+			err := createRouteToBlock(tc.block, tc.host, 10, tc.multihop, nil, nil, tc.testHandle)
 			if !tc.expect(err) {
 				t.Fatalf("Result: %s, message: %s", err, tc.message)
 			}
 		})
 	}
 }
+
+type recordingHandle struct {
+	testHandle
+	added []netlink.Route
+}
+
+func (h *recordingHandle) RouteAdd(r *netlink.Route) error {
+	h.added = append(h.added, *r)
+	return nil
+}
+
+func TestCreateBlackOutRoutes(t *testing.T) {
+	var cidrs []api.IPNet
+	for _, s := range []string{"10.0.0.0/24", "10.0.8.0/21"} {
+		_, ipnet, _ := net.ParseCIDR(s)
+		cidrs = append(cidrs, api.IPNet{IPNet: *ipnet})
+	}
+
+	routeType, err := ParseBlackOutRouteType(BlackOutRouteUnreachable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handle := &recordingHandle{}
+	CreateBlackOutRoutes(cidrs, 10, routeType, handle)
+
+	if len(handle.added) != len(cidrs) {
+		t.Fatalf("expected %d routes, got %d", len(cidrs), len(handle.added))
+	}
+	for i, route := range handle.added {
+		if route.Dst.String() != cidrs[i].String() || route.Table != 10 || route.Type != routeType {
+			t.Errorf("unexpected route %v for blacked out cidr %s", route, cidrs[i])
+		}
+	}
+
+	if _, err := ParseBlackOutRouteType("prohibit"); err == nil {
+		t.Error("expected error for unknown route type")
+	}
+}
+
+func TestDedicatedRouteTable(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("10.0.0.0/28")
+	_, local, _ := net.ParseCIDR("10.0.0.16/28")
+	blocks := []api.IPAMBlockResponse{
+		{CIDR: api.IPNet{IPNet: *ipnet}, Host: "host2", RouteTable: 100},
+		{CIDR: api.IPNet{IPNet: *local}, Host: "host1", RouteTable: 100},
+	}
+	host := &api.Host{Name: "host2", IP: net.ParseIP("192.168.0.2")}
+
+	handle := &recordingHandle{testHandle: testHandle{rg: []netlink.Route{{}}}}
+	if err := createRouteToBlock(blocks[0], host, 10, false, nil, nil, handle); err != nil {
+		t.Fatal(err)
+	}
+	if len(handle.added) != 1 || handle.added[0].Table != 100 || handle.added[0].Protocol != rtable.RouteProtocol {
+		t.Fatalf("expected route in dedicated table, got %+v", handle.added)
+	}
+
+	rules := SourceRouteRules(blocks, "host1")
+	if len(rules) != 1 || rules["10.0.0.16/28"] != 100 {
+		t.Fatalf("expected source rule for local block only, got %v", rules)
+	}
+}
+
+func TestMultipathRouteToBlock(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("10.0.0.0/28")
+	block := api.IPAMBlockResponse{
+		CIDR:     api.IPNet{IPNet: *ipnet},
+		Host:     "host2",
+		NextHops: []net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("192.168.1.2"), net.ParseIP("fd00::1")},
+	}
+	host := &api.Host{Name: "host2", IP: net.ParseIP("192.168.0.2")}
+	notAdjacent := testHandle{rg: []netlink.Route{{Gw: net.ParseIP("192.168.99.1")}}}
+
+	handle := &recordingHandle{testHandle: notAdjacent}
+	if err := createRouteToBlock(block, host, 10, false, nil, nil, handle); err != nil {
+		t.Fatal(err)
+	}
+	if len(handle.added) != 1 || handle.added[0].Gw != nil || len(handle.added[0].MultiPath) != 2 {
+		t.Fatalf("expected route via both IPv4 next hops, got %+v", handle.added)
+	}
+
+	// Dead next hop is withdrawn, block is not routed
+	// once none is left.
+	nextHops := NewNextHops()
+	nextHops.dead["192.168.1.1"] = true
+	handle = &recordingHandle{testHandle: notAdjacent}
+	if err := createRouteToBlock(block, host, 10, false, nil, nextHops, handle); err != nil {
+		t.Fatal(err)
+	}
+	if len(handle.added) != 1 || len(handle.added[0].MultiPath) != 1 ||
+		!handle.added[0].MultiPath[0].Gw.Equal(net.ParseIP("192.168.1.2")) {
+		t.Fatalf("expected route via live next hop, got %+v", handle.added)
+	}
+	nextHops.dead["192.168.1.2"] = true
+	if err := createRouteToBlock(block, host, 10, false, nil, nextHops, handle); err == nil {
+		t.Fatal("expected error without live next hops")
+	}
+
+	// Adjacent hosts are routed directly.
+	handle = &recordingHandle{testHandle: testHandle{rg: []netlink.Route{{}}}}
+	if err := createRouteToBlock(block, host, 10, false, nil, nextHops, handle); err != nil {
+		t.Fatal(err)
+	}
+	if len(handle.added) != 1 || !handle.added[0].Gw.Equal(host.IP) || handle.added[0].MultiPath != nil {
+		t.Fatalf("expected direct route to adjacent host, got %+v", handle.added)
+	}
+}
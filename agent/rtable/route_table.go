@@ -18,8 +18,10 @@ package rtable
 import (
 	"bufio"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"strconv"
 
 	"github.com/pkg/errors"
 	"github.com/romana/rlog"
@@ -29,6 +31,21 @@ import (
 
 const (
 	RT_TABLES_FILE = "/etc/iproute2/rt_tables"
+
+	// RouteProtocol marks routes installed by romana in dedicated
+	// network tables, so that they can be flushed without touching
+	// routes added by operator, e.g. default route via egress gateway.
+	RouteProtocol = 99
+
+	// Priorities of the rules installed for networks with dedicated
+	// route tables. The first one looks up main table ignoring default
+	// route, so that local endpoints and connected networks stay
+	// reachable, the second one sends traffic from the network's local
+	// blocks into its table.
+	SuppressDefaultRulePriority = 30000
+	SourceRulePriority          = 30001
+
+	mainTableId = 254
 )
 
 // EnsureRouteTableExist verifies that romana route table with appropriate index
@@ -105,6 +122,34 @@ func EnsureRomanaRouteRule(romanaRouteTableId int, nl nlRuleHandle) error {
 	return nil
 }
 
+// nlRuleDelHandle subset of netlink.Handle methods isolated for mocking.
+type nlRuleDelHandle interface {
+	RuleList(family int) ([]netlink.Rule, error)
+	RuleDel(*netlink.Rule) error
+}
+
+// RemoveRomanaRouteRule deletes rules pointing to romana routing table.
+func RemoveRomanaRouteRule(romanaRouteTableId int, nl nlRuleDelHandle) error {
+	rules, err := nl.RuleList(unix.AF_INET)
+	if err != nil {
+		return err
+	}
+
+	for i, rule := range rules {
+		if rule.Table != romanaRouteTableId {
+			continue
+		}
+
+		rlog.Infof("Deleting routing rule %v", rule)
+		err = nl.RuleDel(&rules[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // FlushRomanaTable attempts to delete all routes from table called romana.
 func FlushRomanaTable() error {
 	command := exec.Command("ip", "ro", "flush", "table", "romana")
@@ -117,3 +162,94 @@ func FlushRomanaTable() error {
 	return nil
 
 }
+
+// nlRuleFullHandle subset of netlink.Handle methods isolated for mocking.
+type nlRuleFullHandle interface {
+	RuleList(family int) ([]netlink.Rule, error)
+	RuleAdd(*netlink.Rule) error
+	RuleDel(*netlink.Rule) error
+}
+
+// EnsureSourceRouteRules installs rules that direct traffic from source
+// cidrs to their route tables and removes rules of this kind for cidrs
+// that are no longer requested. Pass empty map to remove all of them.
+func EnsureSourceRouteRules(sources map[string]int, nl nlRuleFullHandle) error {
+	rules, err := nl.RuleList(unix.AF_INET)
+	if err != nil {
+		return err
+	}
+
+	installed := make(map[string]bool)
+	var suppressInstalled bool
+	for i, rule := range rules {
+		switch rule.Priority {
+		case SuppressDefaultRulePriority:
+			if len(sources) > 0 {
+				suppressInstalled = true
+				continue
+			}
+		case SourceRulePriority:
+			if rule.Src != nil && sources[rule.Src.String()] == rule.Table {
+				installed[rule.Src.String()] = true
+				continue
+			}
+		default:
+			continue
+		}
+
+		rlog.Infof("Deleting routing rule %v", rule)
+		if err := nl.RuleDel(&rules[i]); err != nil {
+			return err
+		}
+	}
+
+	if len(sources) > 0 && !suppressInstalled {
+		rule := netlink.NewRule()
+		rule.Priority = SuppressDefaultRulePriority
+		rule.Table = mainTableId
+		rule.SuppressPrefixlen = 0
+		rule.Family = unix.AF_INET
+
+		rlog.Infof("Adding routing rule %v", rule)
+		if err := nl.RuleAdd(rule); err != nil {
+			return err
+		}
+	}
+
+	for cidr, table := range sources {
+		if installed[cidr] {
+			continue
+		}
+
+		_, src, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+
+		rule := netlink.NewRule()
+		rule.Priority = SourceRulePriority
+		rule.Table = table
+		rule.Src = src
+		rule.Family = unix.AF_INET
+
+		rlog.Infof("Adding routing rule %v", rule)
+		if err := nl.RuleAdd(rule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FlushRouteTable deletes routes installed by romana
+// (see RouteProtocol) from the table.
+func FlushRouteTable(tableId int) error {
+	command := exec.Command("ip", "-4", "ro", "flush", "table", strconv.Itoa(tableId), "proto", strconv.Itoa(RouteProtocol))
+
+	out, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to flush route table %d out=%s, err=%s", tableId, string(out), err)
+	}
+
+	return nil
+}
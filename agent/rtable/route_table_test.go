@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"flag"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
@@ -108,8 +109,10 @@ func TestEnsureRouteTableName(t *testing.T) {
 }
 
 type mockRuleHandle struct {
-	ruleList  []netlink.Rule
-	addedRule *netlink.Rule
+	ruleList     []netlink.Rule
+	addedRule    *netlink.Rule
+	addedRules   []*netlink.Rule
+	deletedRules []*netlink.Rule
 }
 
 func (m *mockRuleHandle) RuleList(family int) ([]netlink.Rule, error) {
@@ -122,6 +125,12 @@ func (*mockRuleHandle) NewRule() *netlink.Rule {
 
 func (m *mockRuleHandle) RuleAdd(rule *netlink.Rule) error {
 	m.addedRule = rule
+	m.addedRules = append(m.addedRules, rule)
+	return nil
+}
+
+func (m *mockRuleHandle) RuleDel(rule *netlink.Rule) error {
+	m.deletedRules = append(m.deletedRules, rule)
 	return nil
 }
 
@@ -166,3 +175,67 @@ func TestEnsureRomanaRouteRule(t *testing.T) {
 	}
 
 }
+
+func TestRemoveRomanaRouteRule(t *testing.T) {
+	m := mockRuleHandle{ruleList: []netlink.Rule{
+		netlink.Rule{Table: 0},
+		netlink.Rule{Table: 10, Priority: 100},
+		netlink.Rule{Table: 400},
+		netlink.Rule{Table: 10, Priority: 200},
+	}}
+
+	err := RemoveRomanaRouteRule(10, &m)
+	if err != nil {
+		t.Fatalf("failed to run RemoveRomanaRouteRule, err=%s", err)
+	}
+
+	if len(m.deletedRules) != 2 {
+		t.Fatalf("expected 2 rules deleted, got %d", len(m.deletedRules))
+	}
+	for _, r := range m.deletedRules {
+		if r.Table != 10 {
+			t.Fatalf("unexpected rule deleted %+v", r)
+		}
+	}
+}
+
+func TestEnsureSourceRouteRules(t *testing.T) {
+	_, stale, _ := net.ParseCIDR("10.1.0.0/28")
+	_, kept, _ := net.ParseCIDR("10.2.0.0/28")
+	m := mockRuleHandle{ruleList: []netlink.Rule{
+		netlink.Rule{Table: 10},
+		netlink.Rule{Table: 100, Priority: SourceRulePriority, Src: stale},
+		netlink.Rule{Table: 200, Priority: SourceRulePriority, Src: kept},
+	}}
+
+	err := EnsureSourceRouteRules(map[string]int{"10.2.0.0/28": 200, "10.3.0.0/28": 300}, &m)
+	if err != nil {
+		t.Fatalf("failed to run EnsureSourceRouteRules, err=%s", err)
+	}
+
+	if len(m.deletedRules) != 1 || m.deletedRules[0].Src != stale {
+		t.Fatalf("expected rule for %s to be deleted, got %+v", stale, m.deletedRules)
+	}
+
+	if len(m.addedRules) != 2 {
+		t.Fatalf("expected 2 rules added, got %+v", m.addedRules)
+	}
+	if r := m.addedRules[0]; r.Priority != SuppressDefaultRulePriority || r.Table != mainTableId || r.SuppressPrefixlen != 0 {
+		t.Fatalf("unexpected suppress default rule %+v", r)
+	}
+	if r := m.addedRules[1]; r.Priority != SourceRulePriority || r.Table != 300 || r.Src.String() != "10.3.0.0/28" {
+		t.Fatalf("unexpected source rule %+v", r)
+	}
+
+	// no sources, everything goes away.
+	m = mockRuleHandle{ruleList: []netlink.Rule{
+		netlink.Rule{Table: mainTableId, Priority: SuppressDefaultRulePriority},
+		netlink.Rule{Table: 200, Priority: SourceRulePriority, Src: kept},
+	}}
+	if err := EnsureSourceRouteRules(nil, &m); err != nil {
+		t.Fatalf("failed to run EnsureSourceRouteRules, err=%s", err)
+	}
+	if len(m.deletedRules) != 2 || len(m.addedRules) != 0 {
+		t.Fatalf("expected all rules to be deleted, deleted %+v, added %+v", m.deletedRules, m.addedRules)
+	}
+}
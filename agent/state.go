@@ -0,0 +1,85 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// State describes what agent has programmed on the host,
+// it is saved on exit so the next run knows what it inherits.
+type State struct {
+	Hostname       string    `json:"hostname"`
+	BlocksRevision int       `json:"blocks_revision"`
+	RouteTableId   int       `json:"route_table_id"`
+	Policy         bool      `json:"policy"`
+	CleanedUp      bool      `json:"cleaned_up"`
+	SavedAt        time.Time `json:"saved_at"`
+}
+
+// SaveState atomically writes state into the file.
+func SaveState(path string, state State) error {
+	state.SavedAt = time.Now()
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory for state file %s", path)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create state file %s", path)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "failed to write state file %s", path)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write state file %s", path)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadState reads state saved by SaveState, returns nil
+// and no error if the file does not exist.
+func LoadState(path string) (*State, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse state file %s", path)
+	}
+
+	return &state, nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLoadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romana-agent-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "agent", "state.json")
+
+	state, err := LoadState(path)
+	if err != nil || state != nil {
+		t.Fatalf("expected no state and no error for missing file, got %v, %v", state, err)
+	}
+
+	err = SaveState(path, State{Hostname: "host1", BlocksRevision: 7, RouteTableId: 10, CleanedUp: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Hostname != "host1" || state.BlocksRevision != 7 || !state.CleanedUp || state.SavedAt.IsZero() {
+		t.Fatalf("unexpected state loaded %+v", state)
+	}
+}
@@ -18,9 +18,9 @@ package commands
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"os"
-	"text/tabwriter"
+	"io"
+	"net/url"
+	"sort"
 
 	"github.com/romana/core/common/api"
 
@@ -31,7 +31,7 @@ import (
 
 // blockCmd represents the block commands
 var blockCmd = &cli.Command{
-	Use:   "block [add|show|list|remove]",
+	Use:   "block [add|show|list|stats|remove]",
 	Short: "Add, Remove or Show blocks for romana services.",
 	Long: `Add, Remove or Show blocks for romana services.
 
@@ -45,9 +45,21 @@ func init() {
 	blockCmd.AddCommand(blockAddCmd)
 	blockCmd.AddCommand(blockShowCmd)
 	blockCmd.AddCommand(blockListCmd)
+	blockCmd.AddCommand(blockStatsCmd)
 	blockCmd.AddCommand(blockRemoveCmd)
+
+	for _, cmd := range []*cli.Command{blockListCmd, blockStatsCmd} {
+		cmd.Flags().StringVarP(&blockHost, "host", "", "", "Only blocks of the host")
+		cmd.Flags().StringVarP(&blockNetwork, "network", "", "", "Only blocks of the network")
+		completeFlag(cmd, "host", completeHosts)
+	}
 }
 
+var (
+	blockHost    string
+	blockNetwork string
+)
+
 var blockAddCmd = &cli.Command{
 	Use:          "add [block CIDR][block host]",
 	Short:        "Add a new block.",
@@ -72,6 +84,17 @@ var blockListCmd = &cli.Command{
 	SilenceUsage: true,
 }
 
+var blockStatsCmd = &cli.Command{
+	Use:   "stats",
+	Short: "Show block statistics per host and network.",
+	Long: `Show block statistics per host and network.
+
+Shows number of blocks, addresses allocated in them
+and their total size for every host in every network.`,
+	RunE:         blockStats,
+	SilenceUsage: true,
+}
+
 var blockRemoveCmd = &cli.Command{
 	Use:          "remove [block name]",
 	Short:        "Remove a block.",
@@ -91,52 +114,133 @@ func blockShow(cmd *cli.Command, args []string) error {
 }
 
 func blockList(cmd *cli.Command, args []string) error {
-	rootURL := config.GetString("RootURL")
-	resp, err := resty.R().Get(rootURL + "/blocks")
+	blocks, err := getBlocks(blockHost, blockNetwork)
 	if err != nil {
 		return err
 	}
 
-	if config.GetString("Format") == "json" {
-		JSONFormat(resp.Body(), os.Stdout)
-	} else {
-		w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
-
-		if resp.StatusCode() == http.StatusOK {
-			var blocks api.IPAMBlocksResponse
-			err := json.Unmarshal(resp.Body(), &blocks)
-			if err == nil {
-				fmt.Println("Block List")
-				fmt.Fprintf(w,
-					"Block CIDR\tBlock Host\tRevision\t"+
-						"Block Tenant\tBlock Segment\tBlock Allocated IP Count\n",
-				)
-				for _, block := range blocks.Blocks {
-					fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%d\n",
-						block.CIDR.String(),
-						block.Host,
-						block.Revision,
-						block.Tenant,
-						block.Segment,
-						block.AllocatedIPCount,
-					)
-				}
-			} else {
-				fmt.Printf("Error: %s \n", err)
+	return printObject(blocks, func(w io.Writer, wide bool) {
+		printTitle(w, "Block List")
+		fmt.Fprint(w, "Block CIDR\tBlock Host\tBlock Group\tRevision\t"+
+			"Block Tenant\tBlock Segment\tBlock Allocated IP Count")
+		if wide {
+			fmt.Fprint(w, "\tNetwork\tInterface\tRoute Table")
+		}
+		fmt.Fprint(w, "\n")
+		for _, block := range blocks.Blocks {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%d",
+				block.CIDR.String(),
+				block.Host,
+				block.Group,
+				block.Revision,
+				block.Tenant,
+				block.Segment,
+				block.AllocatedIPCount,
+			)
+			if wide {
+				fmt.Fprintf(w, "\t%s\t%s\t%d", block.Network, block.Interface, block.RouteTable)
 			}
-		} else {
-			var e Error
-			json.Unmarshal(resp.Body(), &e)
-
-			fmt.Println("Host Error")
-			fmt.Fprintf(w, "Fields\t%s\n", e.Fields)
-			fmt.Fprintf(w, "Message\t%s\n", e.Message)
-			fmt.Fprintf(w, "Status\t%d\n", resp.StatusCode())
+			fmt.Fprint(w, "\n")
 		}
-		w.Flush()
+	})
+}
+
+// blockStat is aggregated statistics of blocks of a host in a network.
+type blockStat struct {
+	Host             string `json:"host"`
+	Network          string `json:"network"`
+	Blocks           int    `json:"blocks"`
+	AllocatedIPCount int    `json:"allocated_ip_count"`
+	Size             int    `json:"size"`
+	Revision         int    `json:"revision"`
+}
+
+func blockStats(cmd *cli.Command, args []string) error {
+	blocks, err := getBlocks(blockHost, blockNetwork)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	stats := aggregateBlocks(blocks.Blocks)
+	return printObject(stats, func(w io.Writer, wide bool) {
+		fmt.Fprintf(w, "Block Statistics (revision %d)\n", blocks.Revision)
+		fmt.Fprintln(w, "Host\tNetwork\tBlocks\tAllocated IP Count\tSize\tUsage\tRevision")
+		for _, stat := range stats {
+			usage := 0.0
+			if stat.Size > 0 {
+				usage = 100 * float64(stat.AllocatedIPCount) / float64(stat.Size)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%.1f%%\t%d\n",
+				stat.Host,
+				stat.Network,
+				stat.Blocks,
+				stat.AllocatedIPCount,
+				stat.Size,
+				usage,
+				stat.Revision,
+			)
+		}
+	})
+}
+
+// aggregateBlocks returns statistics of blocks per host and network,
+// revision of a statistic is the latest revision of its blocks.
+func aggregateBlocks(blocks []api.IPAMBlockResponse) []blockStat {
+	byKey := make(map[[2]string]*blockStat)
+	for _, block := range blocks {
+		key := [2]string{block.Host, block.Network}
+		stat, ok := byKey[key]
+		if !ok {
+			stat = &blockStat{Host: block.Host, Network: block.Network}
+			byKey[key] = stat
+		}
+		stat.Blocks++
+		stat.AllocatedIPCount += block.AllocatedIPCount
+		ones, bits := block.CIDR.Mask.Size()
+		stat.Size += 1 << uint(bits-ones)
+		if block.Revision > stat.Revision {
+			stat.Revision = block.Revision
+		}
+	}
+
+	stats := make([]blockStat, 0, len(byKey))
+	for _, stat := range byKey {
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Host != stats[j].Host {
+			return stats[i].Host < stats[j].Host
+		}
+		return stats[i].Network < stats[j].Network
+	})
+	return stats
+}
+
+// getBlocks returns blocks of the host in the network,
+// empty host or network matches any.
+func getBlocks(host string, network string) (*api.IPAMBlocksResponse, error) {
+	query := url.Values{}
+	if host != "" {
+		query.Set("host", host)
+	}
+	if network != "" {
+		query.Set("network", network)
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/blocks?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+
+	var blocks api.IPAMBlocksResponse
+	if err := json.Unmarshal(resp.Body(), &blocks); err != nil {
+		return nil, err
+	}
+	return &blocks, nil
 }
 
 func blockRemove(cmd *cli.Command, args []string) error {
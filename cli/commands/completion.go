@@ -0,0 +1,185 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/romana/core/cli/util"
+
+	cli "github.com/spf13/cobra"
+)
+
+// completionCmd generates shell completion scripts.
+var completionCmd = &cli.Command{
+	Use:   "completion [bash|zsh|fish]",
+	Short: "Generate shell completion script.",
+	Long: `Generate shell completion script.
+
+Besides commands and flags, names of hosts, policies and tenants
+are completed, they are fetched from romana services.
+
+To load completions in the current bash shell:
+  source <(romana completion bash)
+
+To load them for every new zsh shell:
+  romana completion zsh > "${fpath[1]}/_romana"
+
+To load them for every new fish shell:
+  romana completion fish > ~/.config/fish/completions/romana.fish
+`,
+	ValidArgs:    []string{"bash", "zsh", "fish"},
+	RunE:         completion,
+	SilenceUsage: true,
+}
+
+// completion writes completion script for the shell to stdout.
+func completion(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "Shell name expected, one of bash, zsh or fish.")
+	}
+
+	switch args[0] {
+	case "bash":
+		return RootCmd.GenBashCompletion(os.Stdout)
+	case "zsh":
+		return RootCmd.GenZshCompletion(os.Stdout)
+	case "fish":
+		return RootCmd.GenFishCompletion(os.Stdout, true)
+	}
+	return util.UsageError(cmd, "Unsupported shell %s, supported are bash, zsh and fish.", args[0])
+}
+
+// completeFlag registers completion of the flag values.
+func completeFlag(cmd *cli.Command, flag string, complete func(*cli.Command, []string, string) ([]string, cli.ShellCompDirective)) {
+	if err := cmd.RegisterFlagCompletionFunc(flag, complete); err != nil {
+		panic(err)
+	}
+}
+
+// completeHosts completes names of hosts.
+func completeHosts(cmd *cli.Command, args []string, toComplete string) ([]string, cli.ShellCompDirective) {
+	hosts, err := getHosts()
+	if err != nil {
+		return nil, cli.ShellCompDirectiveError
+	}
+
+	var names []string
+	for _, host := range hosts {
+		names = append(names, host.Name)
+	}
+	return completions(names, args, toComplete), cli.ShellCompDirectiveNoFileComp
+}
+
+// completePolicies completes IDs of policies,
+// descriptions of policies are shown along.
+func completePolicies(cmd *cli.Command, args []string, toComplete string) ([]string, cli.ShellCompDirective) {
+	policies, err := getPolicies()
+	if err != nil {
+		return nil, cli.ShellCompDirectiveError
+	}
+
+	var ids []string
+	descriptions := make(map[string]string)
+	for _, p := range policies {
+		ids = append(ids, p.ID)
+		descriptions[p.ID] = p.Description
+	}
+
+	result := completions(ids, args, toComplete)
+	for i, id := range result {
+		if descriptions[id] != "" {
+			result[i] = id + "\t" + descriptions[id]
+		}
+	}
+	return result, cli.ShellCompDirectiveNoFileComp
+}
+
+// completeTenants completes names of tenants, which don't have
+// to be created, so they are known from policies, allocated
+// addresses and networks of the topology as well.
+func completeTenants(cmd *cli.Command, args []string, toComplete string) ([]string, cli.ShellCompDirective) {
+	var tenants []string
+	if defined, err := getTenants(); err == nil {
+		for _, t := range defined {
+			tenants = append(tenants, t.Name)
+		}
+	}
+	if policies, err := getPolicies(); err == nil {
+		for _, p := range policies {
+			for _, e := range p.AppliedTo {
+				tenants = append(tenants, e.TenantID)
+			}
+		}
+	}
+	if addresses, err := getAddresses(); err == nil {
+		for _, addr := range addresses {
+			tenants = append(tenants, addr.Tenant)
+		}
+	}
+	if topology, err := getTopology(); err == nil {
+		for _, n := range topology.Networks {
+			tenants = append(tenants, n.Tenants...)
+		}
+	}
+	return completions(tenants, args, toComplete), cli.ShellCompDirectiveNoFileComp
+}
+
+// completeSegmentArgs completes tenant name as the first argument
+// and names of its segments as the second one.
+func completeSegmentArgs(cmd *cli.Command, args []string, toComplete string) ([]string, cli.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return completeTenants(cmd, args, toComplete)
+	case 1:
+		segments, err := getSegments(args[0])
+		if err != nil {
+			return nil, cli.ShellCompDirectiveError
+		}
+		var names []string
+		for _, s := range segments {
+			names = append(names, s.Name)
+		}
+		return completions(names, nil, toComplete), cli.ShellCompDirectiveNoFileComp
+	}
+	return nil, cli.ShellCompDirectiveNoFileComp
+}
+
+// completeArchiveSections completes sections of export archives.
+func completeArchiveSections(cmd *cli.Command, args []string, toComplete string) ([]string, cli.ShellCompDirective) {
+	return completions(archiveSections, nil, toComplete), cli.ShellCompDirectiveNoFileComp
+}
+
+// completions returns sorted unique values starting with toComplete,
+// except for empty ones and those already given in args.
+func completions(values []string, args []string, toComplete string) []string {
+	skip := map[string]bool{"": true}
+	for _, arg := range args {
+		skip[arg] = true
+	}
+
+	var result []string
+	for _, value := range values {
+		if !skip[value] && strings.HasPrefix(value, toComplete) {
+			skip[value] = true
+			result = append(result, value)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
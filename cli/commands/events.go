@@ -0,0 +1,193 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+
+	"github.com/go-resty/resty"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+)
+
+// Filters of events, see eventsCmd.
+var (
+	eventsType      string
+	eventsReason    string
+	eventsComponent string
+	eventsHost      string
+	eventsKind      string
+	eventsName      string
+	eventsWatch     bool
+)
+
+func init() {
+	eventsCmd.Flags().StringVar(&eventsType, "type", "", "only events of the type, Normal or Warning")
+	eventsCmd.Flags().StringVar(&eventsReason, "reason", "", "only events of the reason, e.g. AllocationFailed")
+	eventsCmd.Flags().StringVar(&eventsComponent, "component", "", "only events recorded by the component")
+	eventsCmd.Flags().StringVar(&eventsHost, "host", "", "only events recorded on the host")
+	eventsCmd.Flags().StringVar(&eventsKind, "kind", "", "only events of objects of the kind, e.g. address or host")
+	eventsCmd.Flags().StringVar(&eventsName, "name", "", "only events of the object with the name")
+	eventsCmd.Flags().BoolVarP(&eventsWatch, "watch", "w", false, "after listing events, print new ones as they are recorded")
+}
+
+var eventsCmd = &cli.Command{
+	Use:   "events",
+	Short: "List events of the cluster.",
+	Long: `List events of the cluster.
+
+Events are recorded by romanad, agents and the CNI plugin when
+addresses are allocated and freed, topology, hosts and policies
+change, and when something fails, e.g. routes can't be programmed.
+Repeats of an event are counted by the event instead of recording
+new ones. Only the most recent events are kept, see -max-events
+of romanad.
+
+With --watch, events are printed as they are recorded until
+interrupted.
+`,
+	RunE:         eventsRun,
+	SilenceUsage: true,
+}
+
+func eventsRun(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return util.UsageError(cmd, "events takes no arguments.")
+	}
+	if eventsWatch {
+		return eventsWatchRun()
+	}
+
+	rootURL := config.GetString("RootURL")
+	req := resty.R()
+	for field, value := range eventFilters() {
+		req.SetQueryParam(field, value)
+	}
+	resp, err := req.Get(rootURL + "/events")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	var events []api.Event
+	if err := json.Unmarshal(resp.Body(), &events); err != nil {
+		return err
+	}
+
+	return printObject(events, func(w io.Writer, wide bool) {
+		printTitle(w, "Events")
+		fmt.Fprint(w, "Last Seen\tType\tReason\tObject\tCount\tMessage")
+		if wide {
+			fmt.Fprint(w, "\tComponent\tHost\tFirst Seen")
+		}
+		fmt.Fprint(w, "\n")
+		for _, e := range events {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s", sinceString(e.LastTime), e.Type, e.Reason, e.Object, e.Count, e.Message)
+			if wide {
+				fmt.Fprintf(w, "\t%s\t%s\t%s", e.Component, e.Host, sinceString(e.Time))
+			}
+			fmt.Fprint(w, "\n")
+		}
+	})
+}
+
+// eventFilters returns filters of events given by flags,
+// by fields of the events list endpoint.
+func eventFilters() map[string]string {
+	filters := make(map[string]string)
+	for field, value := range map[string]string{
+		"type":      eventsType,
+		"reason":    eventsReason,
+		"component": eventsComponent,
+		"host":      eventsHost,
+		"kind":      eventsKind,
+		"name":      eventsName,
+	} {
+		if value != "" {
+			filters[field] = value
+		}
+	}
+	return filters
+}
+
+// eventMatches returns true if the event matches filters
+// of eventFilters, which the watch endpoint doesn't apply.
+func eventMatches(e api.Event) bool {
+	return (eventsType == "" || e.Type == eventsType) &&
+		(eventsReason == "" || e.Reason == eventsReason) &&
+		(eventsComponent == "" || e.Component == eventsComponent) &&
+		(eventsHost == "" || e.Host == eventsHost) &&
+		(eventsKind == "" || e.Object.Kind == eventsKind) &&
+		(eventsName == "" || e.Object.Name == eventsName)
+}
+
+// eventsWatchRun prints events streamed by romanad as server-sent
+// events, one per line, as JSON in structured output formats.
+func eventsWatchRun() error {
+	req, err := http.NewRequest(http.MethodGet, config.GetString("RootURL")+"/watch/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(common.HeaderRequestID, requestID)
+	req.Header.Set("Accept", common.ContentTypeEventStream)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return common.ParseHttpError(resp.StatusCode, body)
+	}
+
+	if !isStructuredOutput() && !quiet {
+		fmt.Println("Last Seen\tType\tReason\tObject\tCount\tMessage")
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			// ids, names and keep-alive comments
+			continue
+		}
+		var e api.Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+			return err
+		}
+		if !eventMatches(e) {
+			continue
+		}
+		if isStructuredOutput() {
+			fmt.Println(strings.TrimPrefix(line, "data: "))
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%d\t%s\n", e.LastTime.Format(time.RFC3339), e.Type, e.Reason, e.Object, e.Count, e.Message)
+	}
+	return scanner.Err()
+}
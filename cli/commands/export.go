@@ -0,0 +1,418 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common/api"
+
+	"github.com/go-resty/resty"
+	"github.com/pkg/errors"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+)
+
+// archiveVersion is the version of archives written by export,
+// import refuses archives of newer versions.
+const archiveVersion = 1
+
+// Sections of the archive, in the order they are imported.
+const (
+	sectionTopology  = "topology"
+	sectionHosts     = "hosts"
+	sectionTenants   = "tenants"
+	sectionPolicies  = "policies"
+	sectionAddresses = "addresses"
+)
+
+var archiveSections = []string{sectionTopology, sectionHosts, sectionTenants, sectionPolicies, sectionAddresses}
+
+// clusterArchive is the format of state exported by `romana export`.
+type clusterArchive struct {
+	Version  int       `json:"version"`
+	Exported time.Time `json:"exported"`
+	Sections []string  `json:"sections"`
+
+	Topology  *api.TopologyUpdateRequest `json:"topology,omitempty"`
+	Hosts     []api.Host                 `json:"hosts,omitempty"`
+	Tenants   []api.TenantDefinition     `json:"tenants,omitempty"`
+	Segments  []api.SegmentDefinition    `json:"segments,omitempty"`
+	Policies  []api.Policy               `json:"policies,omitempty"`
+	Addresses []api.IPAMAddress          `json:"addresses,omitempty"`
+}
+
+// archiveSummary is the number of objects imported
+// from a section of the archive.
+type archiveSummary struct {
+	Section  string `json:"section"`
+	Imported int    `json:"imported"`
+	Skipped  int    `json:"skipped"`
+}
+
+// Variables used for export and import flags.
+var (
+	archiveInclude []string
+	archiveExclude []string
+)
+
+var exportCmd = &cli.Command{
+	Use:   "export [file name]",
+	Short: "Export state of the cluster to an archive.",
+	Long: `Export state of the cluster to an archive.
+
+Topology, hosts, created tenants and segments, policies and allocated
+addresses are written to the file or to standard output as a single
+versioned JSON archive, which can be imported to another cluster with
+'romana import'. Sections of the archive are one of
+[` + strings.Join(archiveSections, "|") + `], all are exported unless
+selected by --include or --exclude.
+
+Sections are read with separate requests, so the archive is not
+a snapshot of a single revision, and changes made while exporting
+may be partially included. For a consistent archive, export while
+nothing changes the cluster.
+`,
+	RunE:         exportRun,
+	SilenceUsage: true,
+}
+
+var importCmd = &cli.Command{
+	Use:   "import [file name][STDIN]",
+	Short: "Import state of the cluster from an archive.",
+	Long: `Import state of the cluster from an archive.
+
+Sections of the archive written by 'romana export' are imported in
+the order [` + strings.Join(archiveSections, "|") + `], all that the
+archive has unless selected by --include or --exclude. Hosts, tenants
+and segments that exist already are skipped, policies are applied
+and addresses that are allocated at the same IPs already are skipped,
+so import can be retried.
+`,
+	RunE:         importRun,
+	SilenceUsage: true,
+}
+
+func init() {
+	for _, cmd := range []*cli.Command{exportCmd, importCmd} {
+		cmd.Flags().StringSliceVar(&archiveInclude, "include", nil,
+			"sections of the archive to "+cmd.Name()+", e.g. topology,addresses")
+		cmd.Flags().StringSliceVar(&archiveExclude, "exclude", nil,
+			"sections of the archive not to "+cmd.Name())
+		completeFlag(cmd, "include", completeArchiveSections)
+		completeFlag(cmd, "exclude", completeArchiveSections)
+	}
+}
+
+func exportRun(cmd *cli.Command, args []string) error {
+	if len(args) > 1 {
+		return util.UsageError(cmd, "At most one FILE NAME expected.")
+	}
+	sections, err := selectedSections(cmd, archiveSections)
+	if err != nil {
+		return err
+	}
+
+	// romanad has no request returning all sections at once,
+	// so they may be read at different revisions.
+	archive := clusterArchive{Version: archiveVersion, Exported: time.Now().UTC(), Sections: sections}
+	for _, section := range sections {
+		switch section {
+		case sectionTopology:
+			archive.Topology, err = getTopology()
+		case sectionHosts:
+			archive.Hosts, err = getHosts()
+		case sectionTenants:
+			archive.Tenants, archive.Segments, err = getTenantDefinitions()
+		case sectionPolicies:
+			archive.Policies, err = getPolicies()
+		case sectionAddresses:
+			archive.Addresses, err = getAddresses()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to export %s", section)
+		}
+	}
+
+	body, err := json.MarshalIndent(archive, "", "\t")
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fmt.Println(string(body))
+		return nil
+	}
+	// archive has policies and addresses of all tenants.
+	if err := ioutil.WriteFile(args[0], append(body, '\n'), 0600); err != nil {
+		return err
+	}
+
+	printMessage("Exported %s to %s.", strings.Join(sections, ", "), args[0])
+	return nil
+}
+
+func importRun(cmd *cli.Command, args []string) error {
+	var buf []byte
+	var err error
+	switch len(args) {
+	case 0:
+		buf, err = ioutil.ReadAll(os.Stdin)
+	case 1:
+		buf, err = ioutil.ReadFile(args[0])
+	default:
+		return util.UsageError(cmd,
+			"ARCHIVE FILE name or piped input from 'STDIN' expected.")
+	}
+	if err != nil {
+		return err
+	}
+
+	var archive clusterArchive
+	if err := json.Unmarshal(buf, &archive); err != nil {
+		return err
+	}
+	if archive.Version == 0 {
+		return fmt.Errorf("not an archive written by 'romana export'")
+	}
+	if archive.Version > archiveVersion {
+		return fmt.Errorf("archive version %d is newer than supported version %d, "+
+			"use newer romana CLI to import it", archive.Version, archiveVersion)
+	}
+
+	sections, err := selectedSections(cmd, archive.Sections)
+	if err != nil {
+		return err
+	}
+
+	var summary []archiveSummary
+	for _, section := range sections {
+		s := archiveSummary{Section: section}
+		switch section {
+		case sectionTopology:
+			err = importTopology(archive.Topology, &s)
+		case sectionHosts:
+			err = importHosts(archive.Hosts, &s)
+		case sectionTenants:
+			err = importTenants(archive.Tenants, archive.Segments, &s)
+		case sectionPolicies:
+			err = importPolicies(archive.Policies, &s)
+		case sectionAddresses:
+			err = importAddresses(archive.Addresses, &s)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to import %s", section)
+		}
+		summary = append(summary, s)
+	}
+
+	return printObject(summary, func(w io.Writer, wide bool) {
+		printTitle(w, "Import Summary")
+		fmt.Fprint(w, "Section\tImported\tSkipped\n")
+		for _, s := range summary {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", s.Section, s.Imported, s.Skipped)
+		}
+	})
+}
+
+// selectedSections returns sections of available ones selected
+// by --include and --exclude, in the order of archiveSections.
+func selectedSections(cmd *cli.Command, available []string) ([]string, error) {
+	known := make(map[string]bool)
+	for _, section := range archiveSections {
+		known[section] = true
+	}
+	for _, section := range append(archiveInclude, archiveExclude...) {
+		if !known[section] {
+			return nil, util.UsageError(cmd, "Unknown section %s, expected one of [%s].",
+				section, strings.Join(archiveSections, "|"))
+		}
+	}
+
+	selected := make(map[string]bool)
+	for _, section := range available {
+		selected[section] = len(archiveInclude) == 0
+	}
+	for _, section := range archiveInclude {
+		if _, ok := selected[section]; !ok {
+			return nil, fmt.Errorf("archive has no section %s", section)
+		}
+		selected[section] = true
+	}
+	for _, section := range archiveExclude {
+		delete(selected, section)
+	}
+
+	var sections []string
+	for _, section := range archiveSections {
+		if selected[section] {
+			sections = append(sections, section)
+		}
+	}
+	return sections, nil
+}
+
+// getTenantDefinitions returns created tenants and their created segments.
+func getTenantDefinitions() ([]api.TenantDefinition, []api.SegmentDefinition, error) {
+	all, err := getTenants()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tenants []api.TenantDefinition
+	var segments []api.SegmentDefinition
+	for _, tenant := range all {
+		if tenant.Defined {
+			tenants = append(tenants, tenant.TenantDefinition)
+		}
+		tenantSegments, err := getSegments(tenant.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, segment := range tenantSegments {
+			if segment.Defined {
+				segments = append(segments, segment.SegmentDefinition)
+			}
+		}
+	}
+	return tenants, segments, nil
+}
+
+func importTopology(topology *api.TopologyUpdateRequest, s *archiveSummary) error {
+	if topology == nil {
+		return nil
+	}
+
+	// revision of the exported cluster means nothing here.
+	topology.Revision = 0
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(topology).Post(rootURL + "/topology")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+	s.Imported = 1
+	return nil
+}
+
+func importHosts(hosts []api.Host, s *archiveSummary) error {
+	rootURL := config.GetString("RootURL")
+	for _, host := range hosts {
+		if err := postSkippingExisting(rootURL+"/hosts", host, s); err != nil {
+			return errors.Wrapf(err, "failed to add host %s", host.Name)
+		}
+	}
+	return nil
+}
+
+func importTenants(tenants []api.TenantDefinition, segments []api.SegmentDefinition, s *archiveSummary) error {
+	rootURL := config.GetString("RootURL")
+	for _, tenant := range tenants {
+		if err := postSkippingExisting(rootURL+"/tenants", tenant, s); err != nil {
+			return errors.Wrapf(err, "failed to create tenant %s", tenant.Name)
+		}
+	}
+	for _, segment := range segments {
+		segmentsURL := rootURL + "/tenants/" + url.PathEscape(segment.Tenant) + "/segments"
+		if err := postSkippingExisting(segmentsURL, segment, s); err != nil {
+			return errors.Wrapf(err, "failed to create segment %s of tenant %s", segment.Name, segment.Tenant)
+		}
+	}
+	return nil
+}
+
+// postSkippingExisting posts the object, objects that
+// exist already are counted as skipped.
+func postSkippingExisting(target string, object interface{}, s *archiveSummary) error {
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(object).Post(target)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() == http.StatusConflict {
+		s.Skipped++
+		return nil
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+	s.Imported++
+	return nil
+}
+
+func importPolicies(policies []api.Policy, s *archiveSummary) error {
+	if len(policies) == 0 {
+		return nil
+	}
+
+	results, err := applyPolicies(policies)
+	if err != nil {
+		for _, r := range results {
+			if r.Error != "" {
+				err = errors.Wrapf(err, "policy %s: %s", r.ID, r.Error)
+				break
+			}
+		}
+		return err
+	}
+	for _, r := range results {
+		if r.Result == api.PolicyUnchanged {
+			s.Skipped++
+		} else {
+			s.Imported++
+		}
+	}
+	return nil
+}
+
+func importAddresses(addresses []api.IPAMAddress, s *archiveSummary) error {
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(addresses).Post(rootURL + "/addresses")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	// older romanad doesn't tell how many addresses it skipped.
+	if len(resp.Body()) == 0 || string(resp.Body()) == "null" {
+		s.Imported = len(addresses)
+		return nil
+	}
+	var imported api.IPAMImportResponse
+	if err := json.Unmarshal(resp.Body(), &imported); err != nil {
+		return err
+	}
+	s.Imported, s.Skipped = imported.Imported, imported.Skipped
+	return nil
+}
@@ -0,0 +1,319 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common/api"
+
+	"github.com/go-resty/resty"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+)
+
+// Variables used for federation flags.
+var (
+	federationSuperBlockMask uint
+	federationCluster        string
+	federationForce          bool
+)
+
+// federationCmd represents the federation commands
+var federationCmd = &cli.Command{
+	Use:   "federation [pool-create|pool-list|pool-delete|delegate|delegation-list|release]",
+	Short: "Manage address space shared by federated clusters.",
+	Long: `Manage address space shared by federated clusters.
+
+The parent romanad delegates super-blocks of pools to clusters,
+which use them as networks of their topologies, so that addresses
+of pods never overlap across clusters. federation commands are
+run against the parent romanad.
+
+federation requires a subcommand, e.g. ` + "`romana federation pool-list`." + `
+
+For more information, please check http://romana.io
+`,
+}
+
+func init() {
+	federationPoolCreateCmd.Flags().UintVar(&federationSuperBlockMask, "super-block-mask", 0,
+		"mask of super-blocks delegated to clusters")
+	federationPoolDeleteCmd.Flags().BoolVar(&federationForce, "force", false,
+		"delete the pool even if it has super-blocks delegated, implies --yes")
+	federationDelegationListCmd.Flags().StringVar(&federationCluster, "cluster", "",
+		"only list super-blocks delegated to the cluster")
+	federationReleaseCmd.Flags().BoolVar(&federationForce, "force", false,
+		"release the super-block even if the cluster has addresses allocated in it, implies --yes")
+
+	federationCmd.AddCommand(federationPoolCreateCmd)
+	federationCmd.AddCommand(federationPoolListCmd)
+	federationCmd.AddCommand(federationPoolDeleteCmd)
+	federationCmd.AddCommand(federationDelegateCmd)
+	federationCmd.AddCommand(federationDelegationListCmd)
+	federationCmd.AddCommand(federationReleaseCmd)
+}
+
+var federationPoolCreateCmd = &cli.Command{
+	Use:          "pool-create [pool name] [cidr]",
+	Short:        "Create a pool shared by federated clusters.",
+	Long:         `Create a pool shared by federated clusters, e.g. pool-create pool1 10.0.0.0/8 --super-block-mask 16.`,
+	RunE:         federationPoolCreate,
+	SilenceUsage: true,
+}
+
+var federationPoolListCmd = &cli.Command{
+	Use:          "pool-list",
+	Short:        "List pools shared by federated clusters.",
+	Long:         `List pools shared by federated clusters, with number of addresses clusters reported allocated.`,
+	RunE:         federationPoolList,
+	SilenceUsage: true,
+}
+
+var federationPoolDeleteCmd = &cli.Command{
+	Use:   "pool-delete [pool name]",
+	Short: "Delete a pool.",
+	Long: `Delete a pool.
+
+Pool that has super-blocks delegated is only deleted with
+--force, along with its delegations.`,
+	RunE:         federationPoolDelete,
+	SilenceUsage: true,
+}
+
+var federationDelegateCmd = &cli.Command{
+	Use:          "delegate [pool name] [cluster name]",
+	Short:        "Delegate a super-block of the pool to the cluster.",
+	Long:         `Delegate the first free super-block of the pool to the cluster.`,
+	RunE:         federationDelegate,
+	SilenceUsage: true,
+}
+
+var federationDelegationListCmd = &cli.Command{
+	Use:          "delegation-list",
+	Short:        "List super-blocks delegated to clusters.",
+	Long:         `List super-blocks delegated to clusters, with utilization the clusters reported last.`,
+	RunE:         federationDelegationList,
+	SilenceUsage: true,
+}
+
+var federationReleaseCmd = &cli.Command{
+	Use:   "release [cidr]",
+	Short: "Return a super-block to its pool.",
+	Long: `Return a super-block to its pool.
+
+Super-block the cluster reports addresses allocated in
+is only released with --force.`,
+	RunE:         federationRelease,
+	SilenceUsage: true,
+}
+
+func federationPoolCreate(cmd *cli.Command, args []string) error {
+	if len(args) != 2 {
+		return util.UsageError(cmd, "POOL NAME and CIDR expected.")
+	}
+
+	pool := api.FederationPool{
+		Name:           args[0],
+		CIDR:           args[1],
+		SuperBlockMask: federationSuperBlockMask,
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(pool).Post(rootURL + "/federation/pools")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Pool %s created successfully.", pool.Name))
+}
+
+func federationPoolList(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return util.UsageError(cmd, "Pool listing takes no arguments.")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/federation/pools")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	var pools []api.FederationPoolResponse
+	if err := json.Unmarshal(resp.Body(), &pools); err != nil {
+		return err
+	}
+
+	return printObject(pools, func(w io.Writer, wide bool) {
+		printTitle(w, "Pool List")
+		fmt.Fprint(w, "Pool Name\tCIDR\tSuper-block Mask\tDelegated\tAllocated")
+		if wide {
+			fmt.Fprint(w, "\tSize")
+		}
+		fmt.Fprint(w, "\n")
+		for _, pool := range pools {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d/%d\t%d",
+				pool.Name,
+				pool.CIDR,
+				pool.SuperBlockMask,
+				pool.Delegated,
+				pool.SuperBlocks,
+				pool.Allocated,
+			)
+			if wide {
+				fmt.Fprintf(w, "\t%d", pool.Size)
+			}
+			fmt.Fprint(w, "\n")
+		}
+	})
+}
+
+func federationPoolDelete(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "POOL NAME expected.")
+	}
+	if !federationForce {
+		if err := confirm("Delete pool %s?", args[0]); err != nil {
+			return err
+		}
+	}
+
+	rootURL := config.GetString("RootURL")
+	deleteURL := rootURL + "/federation/pools/" + url.PathEscape(args[0])
+	if federationForce {
+		deleteURL += "?force=true"
+	}
+	resp, err := resty.R().Delete(deleteURL)
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Pool %s deleted successfully.", args[0]))
+}
+
+func federationDelegate(cmd *cli.Command, args []string) error {
+	if len(args) != 2 {
+		return util.UsageError(cmd, "POOL NAME and CLUSTER NAME expected.")
+	}
+
+	req := api.DelegationRequest{Pool: args[0], Cluster: args[1]}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(req).Post(rootURL + "/federation/delegations")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	var delegation api.Delegation
+	if err := json.Unmarshal(resp.Body(), &delegation); err != nil {
+		return err
+	}
+	return printResponse(resp, fmt.Sprintf("Super-block %s of pool %s delegated to cluster %s.",
+		delegation.CIDR, delegation.Pool, delegation.Cluster))
+}
+
+func federationDelegationList(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return util.UsageError(cmd, "Delegation listing takes no arguments.")
+	}
+
+	rootURL := config.GetString("RootURL")
+	req := resty.R()
+	if federationCluster != "" {
+		req.SetQueryParam("cluster", federationCluster)
+	}
+	resp, err := req.Get(rootURL + "/federation/delegations")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	var delegations []api.Delegation
+	if err := json.Unmarshal(resp.Body(), &delegations); err != nil {
+		return err
+	}
+
+	return printObject(delegations, func(w io.Writer, wide bool) {
+		printTitle(w, "Delegation List")
+		fmt.Fprint(w, "CIDR\tPool Name\tCluster Name\tAllocated")
+		if wide {
+			fmt.Fprint(w, "\tNetworks\tReported")
+		}
+		fmt.Fprint(w, "\n")
+		for _, d := range delegations {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d",
+				d.CIDR,
+				d.Pool,
+				d.Cluster,
+				d.Allocated,
+			)
+			if wide {
+				reported := "never"
+				if !d.Reported.IsZero() {
+					reported = d.Reported.Format("2006-01-02 15:04:05")
+				}
+				fmt.Fprintf(w, "\t%v\t%s", d.Networks, reported)
+			}
+			fmt.Fprint(w, "\n")
+		}
+	})
+}
+
+func federationRelease(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "CIDR expected.")
+	}
+	if !federationForce {
+		if err := confirm("Release super-block %s?", args[0]); err != nil {
+			return err
+		}
+	}
+
+	rootURL := config.GetString("RootURL")
+	req := resty.R().SetQueryParam("cidr", args[0])
+	if federationForce {
+		req.SetQueryParam("force", "true")
+	}
+	resp, err := req.Delete(rootURL + "/federation/delegations")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Super-block %s released successfully.", args[0]))
+}
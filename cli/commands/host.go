@@ -18,10 +18,13 @@ package commands
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"os"
-	"text/tabwriter"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
 
+	"github.com/romana/core/cli/util"
 	"github.com/romana/core/common/api"
 
 	"github.com/go-resty/resty"
@@ -29,9 +32,17 @@ import (
 	config "github.com/spf13/viper"
 )
 
+// Variables used for host flags.
+var (
+	hostAgentPort uint
+	hostCapacity  int
+	hostTags      []string
+	hostSelector  string
+)
+
 // hostCmd represents the host commands
 var hostCmd = &cli.Command{
-	Use:   "host [add|show|list|remove]",
+	Use:   "host [add|show|list|remove|cordon|uncordon|drain]",
 	Short: "Add, Remove or Show hosts for romana services.",
 	Long: `Add, Remove or Show hosts for romana services.
 
@@ -42,14 +53,27 @@ For more information, please check http://romana.io
 }
 
 func init() {
+	hostAddCmd.Flags().UintVar(&hostAgentPort, "agent-port", 0,
+		"port of romana agent on the host (default 9604)")
+	hostAddCmd.Flags().IntVar(&hostCapacity, "capacity", 0,
+		"maximum number of addresses allocated on the host, 0 means no limit")
+	hostAddCmd.Flags().StringSliceVar(&hostTags, "tag", nil,
+		"tag of the host as key=value, used to assign it to a group of the topology")
+
+	hostListCmd.Flags().StringVarP(&hostSelector, "selector", "l", "",
+		"list hosts with tags matching selector, e.g. rack=r1,zone!=us-west-1a")
+
 	hostCmd.AddCommand(hostAddCmd)
 	hostCmd.AddCommand(hostShowCmd)
 	hostCmd.AddCommand(hostListCmd)
 	hostCmd.AddCommand(hostRemoveCmd)
+	hostCmd.AddCommand(hostCordonCmd)
+	hostCmd.AddCommand(hostUncordonCmd)
+	hostCmd.AddCommand(hostDrainCmd)
 }
 
 var hostAddCmd = &cli.Command{
-	Use:          "add [hostip][(optional)romana cidr][(optional)agent port]",
+	Use:          "add [host name][host IP]",
 	Short:        "Add a new host.",
 	Long:         `Add a new host.`,
 	RunE:         hostAdd,
@@ -57,82 +81,338 @@ var hostAddCmd = &cli.Command{
 }
 
 var hostShowCmd = &cli.Command{
-	Use:          "show [hostip1][hostip2]...",
-	Short:        "Show details for a specific host.",
-	Long:         `Show details for a specific host.`,
-	RunE:         hostShow,
-	SilenceUsage: true,
+	Use:               "show [host name|IP 1][host name|IP 2]...",
+	Short:             "Show details for a specific host.",
+	Long:              `Show details for a specific host.`,
+	RunE:              hostShow,
+	ValidArgsFunction: completeHosts,
+	SilenceUsage:      true,
 }
 
 var hostListCmd = &cli.Command{
 	Use:          "list",
 	Short:        "List all hosts.",
-	Long:         `List all hosts.`,
+	Long:         `List all hosts, optionally only those with tags matching --selector.`,
 	RunE:         hostList,
 	SilenceUsage: true,
 }
 
 var hostRemoveCmd = &cli.Command{
-	Use:          "remove [hostip]",
-	Short:        "Remove a host.",
-	Long:         `Remove a host.`,
-	RunE:         hostRemove,
-	SilenceUsage: true,
+	Use:               "remove [host name|IP]",
+	Short:             "Remove a host.",
+	Long:              `Remove a host.`,
+	RunE:              hostRemove,
+	ValidArgsFunction: completeHosts,
+	SilenceUsage:      true,
+}
+
+var hostCordonCmd = &cli.Command{
+	Use:               "cordon [host name|IP]",
+	Short:             "Stop allocating addresses on a host.",
+	Long:              `Stop allocating new addresses on a host, addresses allocated on it are kept.`,
+	RunE:              hostCordon,
+	ValidArgsFunction: completeHosts,
+	SilenceUsage:      true,
+}
+
+var hostUncordonCmd = &cli.Command{
+	Use:               "uncordon [host name|IP]",
+	Short:             "Resume allocating addresses on a host.",
+	Long:              `Resume allocating new addresses on a cordoned host.`,
+	RunE:              hostUncordon,
+	ValidArgsFunction: completeHosts,
+	SilenceUsage:      true,
+}
+
+var hostDrainCmd = &cli.Command{
+	Use:   "drain [host name|IP]",
+	Short: "Cordon a host and list addresses still allocated on it.",
+	Long: `Cordon a host and list addresses still allocated on it.
+
+Addresses are not moved, they are released as endpoints using them
+are deleted, e.g. pods are evicted from the node. Run drain again
+to check whether the host can be removed.`,
+	RunE:              hostDrain,
+	ValidArgsFunction: completeHosts,
+	SilenceUsage:      true,
 }
 
 func hostAdd(cmd *cli.Command, args []string) error {
-	fmt.Println("Unimplemented: Add host/s.")
-	return nil
+	if len(args) != 2 {
+		return util.UsageError(cmd, "HOST NAME and HOST IP expected.")
+	}
+
+	host := api.Host{
+		Name:      args[0],
+		IP:        net.ParseIP(args[1]),
+		AgentPort: hostAgentPort,
+		Capacity:  hostCapacity,
+	}
+	if host.IP == nil {
+		return util.UsageError(cmd, "Invalid HOST IP %s.", args[1])
+	}
+	for _, tag := range hostTags {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return util.UsageError(cmd, "Invalid tag %s, expected key=value.", tag)
+		}
+		if host.Tags == nil {
+			host.Tags = make(map[string]string)
+		}
+		host.Tags[kv[0]] = kv[1]
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(host).Post(rootURL + "/hosts")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Host %s (%s) added successfully.", host.Name, host.IP))
 }
 
 func hostShow(cmd *cli.Command, args []string) error {
-	fmt.Println("Unimplemented: Show host details.")
-	return nil
+	if len(args) == 0 {
+		return util.UsageError(cmd, "At least one HOST NAME or IP expected.")
+	}
+
+	hosts, err := getHosts()
+	if err != nil {
+		return err
+	}
+
+	shown := []api.Host{}
+	for _, arg := range args {
+		found := false
+		for _, host := range hosts {
+			if host.Name == arg || host.IP.String() == arg {
+				shown = append(shown, host)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("host %s not found", arg)
+		}
+	}
+
+	return printObject(shown, func(w io.Writer, wide bool) {
+		printTitle(w, "Host Details")
+		for _, host := range shown {
+			fmt.Fprintf(w, "Host Name:\t%s\n", host.Name)
+			fmt.Fprintf(w, "Host IP:\t%s\n", host.IP)
+			fmt.Fprintf(w, "Agent Port:\t%d\n", host.AgentPort)
+			fmt.Fprintf(w, "Capacity:\t%s\n", hostCapacityString(host))
+			fmt.Fprintf(w, "Cordoned:\t%t\n", host.Cordoned)
+			if len(host.Tags) > 0 {
+				fmt.Fprintln(w, "Tags:")
+				for _, key := range sortedTagKeys(host.Tags) {
+					fmt.Fprintf(w, "\t%s:\t%s\n", key, host.Tags[key])
+				}
+			}
+			fmt.Fprint(w, "\n")
+		}
+	})
 }
 
 func hostList(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return util.UsageError(cmd, "Host listing takes no arguments.")
+	}
+
+	selector, err := util.ParseSelector(hostSelector)
+	if err != nil {
+		return util.UsageError(cmd, "%s", err)
+	}
+
+	hosts, err := getHosts()
+	if err != nil {
+		return err
+	}
+
+	listed := []api.Host{}
+	for _, host := range hosts {
+		labels := make(map[string][]string)
+		for key, value := range host.Tags {
+			labels[key] = []string{value}
+		}
+		if selector.Matches(labels) {
+			listed = append(listed, host)
+		}
+	}
+
+	return printObject(listed, func(w io.Writer, wide bool) {
+		printTitle(w, "Host List")
+		fmt.Fprint(w, "Host IP\tHost Name\tAgent Port\tCapacity\tCordoned\tTags\n")
+		for _, host := range listed {
+			var tags []string
+			for _, key := range sortedTagKeys(host.Tags) {
+				tags = append(tags, key+"="+host.Tags[key])
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%t\t%s\n",
+				host.IP.String(),
+				host.Name,
+				host.AgentPort,
+				hostCapacityString(host),
+				host.Cordoned,
+				strings.Join(tags, ","),
+			)
+		}
+	})
+}
+
+func hostRemove(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "HOST NAME or IP expected.")
+	}
+	if err := confirm("Remove host %s?", args[0]); err != nil {
+		return err
+	}
+
 	rootURL := config.GetString("RootURL")
-	resp, err := resty.R().Get(rootURL + "/hosts")
+	resp, err := resty.R().Delete(rootURL + "/hosts/" + url.PathEscape(args[0]))
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Host %s removed successfully.", args[0]))
+}
+
+func hostCordon(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "HOST NAME or IP expected.")
+	}
+
+	resp, err := setHostCordon(args[0], true)
+	if err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Host %s cordoned successfully.", args[0]))
+}
+
+func hostUncordon(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "HOST NAME or IP expected.")
+	}
+
+	resp, err := setHostCordon(args[0], false)
+	if err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Host %s uncordoned successfully.", args[0]))
+}
+
+func hostDrain(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "HOST NAME or IP expected.")
+	}
+
+	if _, err := setHostCordon(args[0], true); err != nil {
+		return err
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/hosts/" + url.PathEscape(args[0]) + "/addresses")
 	if err != nil {
 		return err
 	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+	var addresses []api.IPAMAddress
+	if err := json.Unmarshal(resp.Body(), &addresses); err != nil {
+		return err
+	}
+
+	return printObject(addresses, func(w io.Writer, wide bool) {
+		if len(addresses) == 0 {
+			printTitle(w, fmt.Sprintf("Host %s is cordoned and has no addresses allocated.", args[0]))
+			return
+		}
+		printTitle(w, fmt.Sprintf("Host %s is cordoned, %d addresses still allocated:", args[0], len(addresses)))
+		fmt.Fprintf(w, "Name\tIP\tNetwork\tTenant\tSegment\n")
+		for _, addr := range addresses {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				addr.Name,
+				addr.IP,
+				addr.Network,
+				addr.Tenant,
+				addr.Segment,
+			)
+		}
+	})
+}
 
-	if config.GetString("Format") == "json" {
-		JSONFormat(resp.Body(), os.Stdout)
+// setHostCordon cordons or uncordons the host given by its name or IP.
+func setHostCordon(host string, cordoned bool) (*resty.Response, error) {
+	rootURL := config.GetString("RootURL")
+	req := resty.R()
+	var resp *resty.Response
+	var err error
+	if cordoned {
+		resp, err = req.Put(rootURL + "/hosts/" + url.PathEscape(host) + "/cordon")
 	} else {
-		w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
-
-		if resp.StatusCode() == http.StatusOK {
-			var hosts api.HostList
-			err := json.Unmarshal(resp.Body(), &hosts)
-			if err == nil {
-				fmt.Println("Host List")
-				fmt.Fprintf(w, "Host IP\tHost Name\n")
-				for _, host := range hosts.Hosts {
-					fmt.Fprintf(w, "%s\t%s\n",
-						host.IP.String(),
-						host.Name,
-					)
-				}
-			} else {
-				fmt.Printf("Error: %s \n", err)
-			}
-		} else {
-			var e Error
-			json.Unmarshal(resp.Body(), &e)
-
-			fmt.Println("Host Error")
-			fmt.Fprintf(w, "Fields\t%s\n", e.Fields)
-			fmt.Fprintf(w, "Message\t%s\n", e.Message)
-			fmt.Fprintf(w, "Status\t%d\n", resp.StatusCode())
+		resp, err = req.Delete(rootURL + "/hosts/" + url.PathEscape(host) + "/cordon")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// getHosts returns hosts sorted by name. Hosts are listed by
+// romanad once for every network, duplicates are dropped.
+func getHosts() ([]api.Host, error) {
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/hosts")
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+
+	var list api.HostList
+	if err := json.Unmarshal(resp.Body(), &list); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var hosts []api.Host
+	for _, host := range list.Hosts {
+		if !seen[host.Name] {
+			seen[host.Name] = true
+			hosts = append(hosts, host)
 		}
-		w.Flush()
 	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return hosts, nil
+}
 
-	return nil
+func hostCapacityString(host api.Host) string {
+	if host.Capacity == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d", host.Capacity)
 }
 
-func hostRemove(cmd *cli.Command, args []string) error {
-	fmt.Println("Unimplemented: Remove a host.")
-	return nil
+func sortedTagKeys(tags map[string]string) []string {
+	var keys []string
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
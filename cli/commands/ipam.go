@@ -0,0 +1,454 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common/api"
+
+	"github.com/go-resty/resty"
+	"github.com/pkg/errors"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+)
+
+// Variables used for ipam flags.
+var (
+	ipamNetwork       string
+	ipamHost          string
+	ipamTenant        string
+	ipamSegment       string
+	ipamZone          string
+	ipamRequireZone   bool
+	ipamAddressesOnly bool
+)
+
+// ipamExport is the format of IPAM exported by `romana ipam export`.
+type ipamExport struct {
+	Topology  api.TopologyUpdateRequest `json:"topology"`
+	Addresses []api.IPAMAddress         `json:"addresses"`
+}
+
+// ipamCmd represents the ipam commands
+var ipamCmd = &cli.Command{
+	Use:   "ipam [show|list|allocate|free|blackout|unblackout|export|import]",
+	Short: "Show and manage IP address allocations of romana services.",
+	Long: `Show and manage IP address allocations of romana services.
+
+ipam requires a subcommand, e.g. ` + "`romana ipam show`." + `
+
+For more information, please check http://romana.io
+`,
+}
+
+func init() {
+	ipamListCmd.Flags().StringVar(&ipamNetwork, "network", "", "list addresses of the network")
+	ipamListCmd.Flags().StringVar(&ipamHost, "host", "", "list addresses of the host")
+	ipamListCmd.Flags().StringVar(&ipamTenant, "tenant", "", "list addresses of the tenant")
+	ipamListCmd.Flags().StringVar(&ipamSegment, "segment", "", "list addresses of the segment")
+	completeFlag(ipamListCmd, "host", completeHosts)
+	completeFlag(ipamListCmd, "tenant", completeTenants)
+
+	ipamAllocateCmd.Flags().StringVar(&ipamHost, "host", "", "host to allocate address on (required)")
+	ipamAllocateCmd.Flags().StringVar(&ipamTenant, "tenant", "", "tenant of the address")
+	ipamAllocateCmd.Flags().StringVar(&ipamSegment, "segment", "", "segment of the address")
+	ipamAllocateCmd.Flags().StringVar(&ipamZone, "zone", "", "prefer networks where the host is in a group of the zone")
+	ipamAllocateCmd.Flags().BoolVar(&ipamRequireZone, "require-zone", false, "fail unless the address can be allocated in --zone")
+	completeFlag(ipamAllocateCmd, "host", completeHosts)
+	completeFlag(ipamAllocateCmd, "tenant", completeTenants)
+
+	ipamImportCmd.Flags().BoolVar(&ipamAddressesOnly, "addresses-only", false,
+		"only import addresses, topology must be set up already")
+
+	ipamCmd.AddCommand(ipamShowCmd)
+	ipamCmd.AddCommand(ipamListCmd)
+	ipamCmd.AddCommand(ipamAllocateCmd)
+	ipamCmd.AddCommand(ipamFreeCmd)
+	ipamCmd.AddCommand(ipamBlackOutCmd)
+	ipamCmd.AddCommand(ipamUnBlackOutCmd)
+	ipamCmd.AddCommand(ipamExportCmd)
+	ipamCmd.AddCommand(ipamImportCmd)
+}
+
+var ipamShowCmd = &cli.Command{
+	Use:          "show",
+	Short:        "Show utilization of networks.",
+	Long:         `Show utilization of networks.`,
+	RunE:         ipamShow,
+	SilenceUsage: true,
+}
+
+var ipamListCmd = &cli.Command{
+	Use:          "list",
+	Short:        "List allocated addresses.",
+	Long:         `List allocated addresses, optionally filtered by network, host, tenant and segment.`,
+	RunE:         ipamList,
+	SilenceUsage: true,
+}
+
+var ipamAllocateCmd = &cli.Command{
+	Use:   "allocate [address name]",
+	Short: "Allocate an address.",
+	Long: `Allocate an address with the name on the host.
+
+With --zone, the address is allocated in a network where the host
+is in a group of the zone, if there is one, so that it is routed
+within the zone. With --require-zone other networks are not tried.`,
+	RunE:         ipamAllocate,
+	SilenceUsage: true,
+}
+
+var ipamFreeCmd = &cli.Command{
+	Use:          "free [address name|IP]",
+	Short:        "Free an allocated address.",
+	Long:         `Free an allocated address given by its name or IP.`,
+	RunE:         ipamFree,
+	SilenceUsage: true,
+}
+
+var ipamBlackOutCmd = &cli.Command{
+	Use:          "blackout [CIDR]",
+	Short:        "Black out a CIDR.",
+	Long:         `Black out a CIDR, so that no addresses are allocated from it.`,
+	RunE:         ipamBlackOut,
+	SilenceUsage: true,
+}
+
+var ipamUnBlackOutCmd = &cli.Command{
+	Use:          "unblackout [CIDR]",
+	Short:        "Return a blacked out CIDR to the pool.",
+	Long:         `Return a blacked out CIDR to the pool.`,
+	RunE:         ipamUnBlackOut,
+	SilenceUsage: true,
+}
+
+var ipamExportCmd = &cli.Command{
+	Use:   "export [file name]",
+	Short: "Export topology and allocated addresses.",
+	Long: `Export topology and allocated addresses.
+
+IPAM is written to the file or to standard output as JSON,
+which can be imported to another cluster with 'romana ipam import'.
+`,
+	RunE:         ipamExportRun,
+	SilenceUsage: true,
+}
+
+var ipamImportCmd = &cli.Command{
+	Use:   "import [file name][STDIN]",
+	Short: "Import topology and allocated addresses.",
+	Long: `Import topology and allocated addresses.
+
+Topology exported by 'romana ipam export' is applied first, then
+addresses are allocated at their exported IPs. Addresses that are
+allocated at the same IPs already are skipped, so import can be
+retried.
+`,
+	RunE:         ipamImport,
+	SilenceUsage: true,
+}
+
+func ipamShow(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return util.UsageError(cmd, "ipam show takes no arguments.")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/networks")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	var networks []api.IPAMNetworkResponse
+	if err := json.Unmarshal(resp.Body(), &networks); err != nil {
+		return err
+	}
+
+	return printObject(networks, func(w io.Writer, wide bool) {
+		printTitle(w, "Network Utilization")
+		fmt.Fprint(w, "Network\tCIDR\tSize\tAllocated\tBlacked Out\tFree\tUsed\tBlocks")
+		if wide {
+			fmt.Fprint(w, "\tRevision")
+		}
+		fmt.Fprint(w, "\n")
+		for _, n := range networks {
+			var free uint64
+			used := 0.0
+			if available := n.Size - n.BlackedOut; n.Size > n.BlackedOut {
+				if available > uint64(n.Allocated) {
+					free = available - uint64(n.Allocated)
+				}
+				used = 100 * float64(n.Allocated) / float64(available)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%.1f%%\t%d",
+				n.Name,
+				n.CIDR.String(),
+				n.Size,
+				n.Allocated,
+				n.BlackedOut,
+				free,
+				used,
+				n.Blocks,
+			)
+			if wide {
+				fmt.Fprintf(w, "\t%d", n.Revision)
+			}
+			fmt.Fprint(w, "\n")
+		}
+	})
+}
+
+func ipamList(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return util.UsageError(cmd, "ipam list takes no arguments.")
+	}
+
+	addresses, err := getAddresses()
+	if err != nil {
+		return err
+	}
+
+	filtered := []api.IPAMAddress{}
+	for _, addr := range addresses {
+		if (ipamNetwork == "" || addr.Network == ipamNetwork) &&
+			(ipamHost == "" || addr.Host == ipamHost) &&
+			(ipamTenant == "" || addr.Tenant == ipamTenant) &&
+			(ipamSegment == "" || addr.Segment == ipamSegment) {
+			filtered = append(filtered, addr)
+		}
+	}
+
+	return printObject(filtered, func(w io.Writer, wide bool) {
+		printTitle(w, "Address List")
+		fmt.Fprintf(w, "Name\tIP\tNetwork\tHost\tTenant\tSegment\n")
+		for _, addr := range filtered {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				addr.Name,
+				addr.IP,
+				addr.Network,
+				addr.Host,
+				addr.Tenant,
+				addr.Segment,
+			)
+		}
+	})
+}
+
+func ipamAllocate(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "ADDRESS NAME expected.")
+	}
+	if ipamHost == "" {
+		return util.UsageError(cmd, "--host is required.")
+	}
+	if ipamRequireZone && ipamZone == "" {
+		return util.UsageError(cmd, "--require-zone requires --zone.")
+	}
+
+	req := api.IPAMAddressRequest{
+		Name:        args[0],
+		Host:        ipamHost,
+		Tenant:      ipamTenant,
+		Segment:     ipamSegment,
+		Zone:        ipamZone,
+		RequireZone: ipamRequireZone,
+	}
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(req).Post(rootURL + "/address")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	var ip net.IP
+	if err := json.Unmarshal(resp.Body(), &ip); err != nil {
+		return err
+	}
+
+	return printObject(api.IPAMAddressResponse{Name: req.Name, IP: ip}, func(w io.Writer, wide bool) {
+		fmt.Fprintf(w, "Allocated %s for %s.\n", ip, req.Name)
+	})
+}
+
+func ipamFree(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "ADDRESS NAME or IP expected.")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Delete(rootURL + "/address?addressName=" + url.QueryEscape(args[0]))
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Freed %s.", args[0]))
+}
+
+func ipamBlackOut(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "CIDR expected.")
+	}
+	if err := confirm("Black out %s? No addresses will be allocated from it.", args[0]); err != nil {
+		return err
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(api.IPAMBlackOutRequest{CIDR: args[0]}).Post(rootURL + "/blackout")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Blacked out %s.", args[0]))
+}
+
+func ipamUnBlackOut(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "CIDR expected.")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Delete(rootURL + "/blackout?cidr=" + url.QueryEscape(args[0]))
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Returned %s to the pool.", args[0]))
+}
+
+func ipamExportRun(cmd *cli.Command, args []string) error {
+	if len(args) > 1 {
+		return util.UsageError(cmd, "At most one FILE NAME expected.")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/topology")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	var export ipamExport
+	if err := json.Unmarshal(resp.Body(), &export.Topology); err != nil {
+		return err
+	}
+	if export.Addresses, err = getAddresses(); err != nil {
+		return err
+	}
+
+	body, err := json.MarshalIndent(export, "", "\t")
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fmt.Println(string(body))
+		return nil
+	}
+	if err := ioutil.WriteFile(args[0], append(body, '\n'), 0644); err != nil {
+		return err
+	}
+	printMessage("Exported %d addresses to %s.", len(export.Addresses), args[0])
+	return nil
+}
+
+func ipamImport(cmd *cli.Command, args []string) error {
+	var buf []byte
+	var err error
+	switch len(args) {
+	case 0:
+		buf, err = ioutil.ReadAll(os.Stdin)
+	case 1:
+		buf, err = ioutil.ReadFile(args[0])
+	default:
+		return util.UsageError(cmd,
+			"IPAM FILE name or piped input from 'STDIN' expected.")
+	}
+	if err != nil {
+		return err
+	}
+
+	var export ipamExport
+	if err := json.Unmarshal(buf, &export); err != nil {
+		return err
+	}
+
+	rootURL := config.GetString("RootURL")
+	if !ipamAddressesOnly {
+		// revision of the exported cluster means nothing here.
+		export.Topology.Revision = 0
+		resp, err := resty.R().SetHeader("Content-Type", "application/json").
+			SetBody(export.Topology).Post(rootURL + "/topology")
+		if err != nil {
+			return err
+		}
+		if err := responseError(resp); err != nil {
+			return errors.Wrap(err, "failed to import topology")
+		}
+	}
+
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(export.Addresses).Post(rootURL + "/addresses")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return errors.Wrap(err, "failed to import addresses")
+	}
+
+	printMessage("Imported %d addresses.", len(export.Addresses))
+	return nil
+}
+
+// getAddresses returns allocated addresses.
+func getAddresses() ([]api.IPAMAddress, error) {
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/addresses")
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+
+	var addresses []api.IPAMAddress
+	err = json.Unmarshal(resp.Body(), &addresses)
+	return addresses, err
+}
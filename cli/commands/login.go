@@ -0,0 +1,313 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common"
+
+	"github.com/go-resty/resty"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+)
+
+var loginCmd = &cli.Command{
+	Use:   "login",
+	Short: "Get a token authenticating requests to romana services.",
+	Long: `Get a token authenticating requests to romana services.
+
+Token is issued by romanad for the user given by --username, or
+ROMANA_USERNAME and ROMANA_PASSWORD, password is asked for unless
+given. Token is written to standard output, so that it can be used
+by later commands, e.g.
+
+  export ROMANA_TOKEN=$(romana login -u admin)
+
+Token is also kept in the token cache of the romanad it was issued
+by, readable only by the user, and later commands use it unless
+--token or ROMANA_TOKEN is given.
+
+Tokens expire, after an hour unless romanad is configured otherwise.
+Cached token is refreshed when it expires or romanad rejects it, if
+ROMANA_USERNAME and ROMANA_PASSWORD are given, otherwise login has
+to be repeated.
+`,
+	RunE:         login,
+	SilenceUsage: true,
+}
+
+// loginCredential is given by --username and --password.
+var loginCredential *common.Credential
+
+func init() {
+	loginCredential = common.NewCredentialCobra(loginCmd)
+}
+
+func login(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return util.UsageError(cmd, "Login takes no arguments.")
+	}
+	if err := loginCredential.Initialize(); err != nil {
+		return err
+	}
+	if loginCredential.Type != common.CredentialUsernamePassword {
+		return util.UsageError(cmd, "--username or ROMANA_USERNAME expected.")
+	}
+
+	issued, err := issueToken(loginCredential.Username, loginCredential.Password)
+	if err != nil {
+		return err
+	}
+	// token is printed even if it can't be cached, e.g. to be
+	// kept in ROMANA_TOKEN.
+	if err := writeTokenCache(issued); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: token is not cached: %s.\n", err)
+	}
+
+	msg := common.AuthTokenMessage{Token: issued.Token, PublicKey: issued.publicKey}
+	return printObject(msg, func(w io.Writer, wide bool) {
+		fmt.Fprintln(w, msg.Token)
+	})
+}
+
+// tokenExpiryMargin is how long before expiry cached
+// tokens are refreshed, so that they don't expire
+// while the command runs.
+const tokenExpiryMargin = 10 * time.Second
+
+// cachedToken is a token kept in the token cache, one file
+// per context, i.e. root URL of romanad that issued the token.
+type cachedToken struct {
+	RootURL  string    `json:"root_url"`
+	Username string    `json:"username"`
+	Token    string    `json:"token"`
+	Expires  time.Time `json:"expires"`
+
+	publicKey []byte
+}
+
+// expired returns true if the token expires soon, tokens
+// whose expiry is unknown are used until romanad rejects them.
+func (t cachedToken) expired() bool {
+	return !t.Expires.IsZero() && time.Now().Add(tokenExpiryMargin).After(t.Expires)
+}
+
+var (
+	// tokenCache is the cached token used by the command,
+	// nil unless token is read from the token cache.
+	tokenCache *cachedToken
+	// tokenRefreshed is set once the command refreshed
+	// the cached token, it is refreshed once at most.
+	tokenRefreshed bool
+)
+
+// tokenContext returns context of tokens, tokens are issued
+// at the same path by every version of romana API.
+func tokenContext() string {
+	return strings.TrimSuffix(config.GetString("RootURL"), "/"+common.APIVersion)
+}
+
+// tokenCachePath returns path of the token cache of the context,
+// in TokenCacheDir of config, $HOME/.romana/tokens by default.
+func tokenCachePath(context string) (string, error) {
+	dir := config.GetString("TokenCacheDir")
+	if dir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return "", fmt.Errorf("token cache needs $HOME or TokenCacheDir in config")
+		}
+		dir = filepath.Join(home, ".romana", "tokens")
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, context)
+	return filepath.Join(dir, name), nil
+}
+
+// readTokenCache returns token cached for the context, nil if
+// there is none. Token cache must not be accessible by other
+// users, since the token authenticates its user.
+func readTokenCache(context string) (*cachedToken, error) {
+	path, err := tokenCachePath(context)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("token cache %s is accessible by other users, its permissions must be 0600", path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cached cachedToken
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, fmt.Errorf("token cache %s: %s", path, err)
+	}
+	return &cached, nil
+}
+
+// writeTokenCache writes token to the token cache of its context,
+// permissions of the token cache are 0600 even if it existed.
+func writeTokenCache(t cachedToken) error {
+	path, err := tokenCachePath(t.RootURL)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(t); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// issueToken gets a token for the user from romanad.
+func issueToken(username, password string) (cachedToken, error) {
+	context := tokenContext()
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(common.AuthRequest{
+			Username: username,
+			Password: password,
+		}).Post(context + common.AuthPath)
+	if err != nil {
+		return cachedToken{}, err
+	}
+	if err := responseError(resp); err != nil {
+		return cachedToken{}, err
+	}
+
+	var msg common.AuthTokenMessage
+	if err := json.Unmarshal(resp.Body(), &msg); err != nil {
+		return cachedToken{}, err
+	}
+	return cachedToken{
+		RootURL:   context,
+		Username:  username,
+		Token:     msg.Token,
+		Expires:   tokenExpiry(msg.Token),
+		publicKey: msg.PublicKey,
+	}, nil
+}
+
+// tokenExpiry returns expiry of the token given by its exp claim,
+// zero time if it is unknown. Token isn't verified, romanad does.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.ExpiresAt, 0)
+}
+
+// loadCachedToken returns token cached for romanad the command
+// talks to, refreshed if it expired, empty if there is none.
+func loadCachedToken() (string, error) {
+	cached, err := readTokenCache(tokenContext())
+	if err != nil || cached == nil {
+		return "", err
+	}
+	tokenCache = cached
+	if cached.expired() {
+		if err := refreshToken(); err != nil {
+			return "", err
+		}
+	}
+	return tokenCache.Token, nil
+}
+
+// refreshToken replaces the cached token with a token issued for
+// ROMANA_USERNAME, or the user of the cached token, and
+// ROMANA_PASSWORD.
+func refreshToken() error {
+	tokenRefreshed = true
+	username := config.GetString(common.UsernameKey)
+	if username == "" {
+		username = tokenCache.Username
+	}
+	password := config.GetString(common.PasswordKey)
+	if username == "" || password == "" {
+		return fmt.Errorf("token of %s expired, repeat 'romana login' or give %s to refresh it",
+			tokenCache.RootURL, common.PasswordKey)
+	}
+
+	refreshed, err := issueToken(username, password)
+	if err != nil {
+		return fmt.Errorf("failed to refresh token of %s: %s", tokenCache.RootURL, err)
+	}
+	if err := writeTokenCache(refreshed); err != nil {
+		return err
+	}
+	tokenCache = &refreshed
+	resty.SetAuthToken(refreshed.Token)
+	return nil
+}
+
+// refreshOnUnauthorized is a retry condition refreshing the cached
+// token once romanad rejects it, e.g. after romanad was restarted
+// with another key, so that the request is retried with a new token.
+func refreshOnUnauthorized(resp *resty.Response) (bool, error) {
+	if resp == nil || resp.StatusCode() != http.StatusUnauthorized ||
+		tokenCache == nil || tokenRefreshed {
+		return false, nil
+	}
+	if err := refreshToken(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s.\n", err)
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/romana/core/common"
+
+	"github.com/go-resty/resty"
+	config "github.com/spf13/viper"
+)
+
+// testToken returns an unsigned token expiring at exp.
+func testToken(id int, exp time.Time) string {
+	claims, _ := json.Marshal(map[string]interface{}{"sub": "admin", "jti": id, "exp": exp.Unix()})
+	return "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+}
+
+// authServer issues a new token on every login, and accepts
+// only the latest token issued.
+type authServer struct {
+	*httptest.Server
+	logins int
+	token  string
+}
+
+func newAuthServer() *authServer {
+	s := &authServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == common.AuthPath {
+			var req common.AuthRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Username != "admin" || req.Password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			s.logins++
+			s.token = testToken(s.logins, time.Now().Add(time.Hour))
+			json.NewEncoder(w).Encode(common.AuthTokenMessage{Token: s.token})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+s.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "[]")
+	}))
+	return s
+}
+
+// setupTokenCache points config to the server and a temporary
+// token cache, the returned function cleans them up.
+func setupTokenCache(t *testing.T, s *authServer) func() {
+	dir, err := ioutil.TempDir("", "romana-tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Set("RootURL", s.URL+"/"+common.APIVersion)
+	config.Set("TokenCacheDir", dir)
+	config.Set(common.UsernameKey, "")
+	config.Set(common.PasswordKey, "")
+	tokenCache = nil
+	tokenRefreshed = false
+	return func() {
+		s.Close()
+		os.RemoveAll(dir)
+		resty.SetAuthToken("")
+	}
+}
+
+func TestTokenCachePermissions(t *testing.T) {
+	s := newAuthServer()
+	defer setupTokenCache(t, s)()
+
+	path, err := tokenCachePath(tokenContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// permissions of an existing token cache are fixed.
+	os.MkdirAll(config.GetString("TokenCacheDir"), 0700)
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTokenCache(cachedToken{RootURL: tokenContext(), Token: "t1"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected token cache with permissions 0600, got %o", info.Mode().Perm())
+	}
+	cached, err := readTokenCache(tokenContext())
+	if err != nil || cached == nil || cached.Token != "t1" {
+		t.Fatalf("unexpected cached token %v, error %v", cached, err)
+	}
+
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readTokenCache(tokenContext()); err == nil {
+		t.Fatal("expected error reading token cache accessible by other users")
+	}
+}
+
+func TestRefreshExpiredToken(t *testing.T) {
+	s := newAuthServer()
+	defer setupTokenCache(t, s)()
+
+	expired := cachedToken{
+		RootURL:  tokenContext(),
+		Username: "admin",
+		Token:    testToken(0, time.Now().Add(-time.Minute)),
+	}
+	expired.Expires = tokenExpiry(expired.Token)
+	if err := writeTokenCache(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadCachedToken(); err == nil {
+		t.Fatal("expected error refreshing token without password")
+	}
+
+	tokenRefreshed = false
+	config.Set(common.PasswordKey, "secret")
+	token, err := loadCachedToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.logins != 1 || token != s.token {
+		t.Fatalf("expected token of the new login, got %s after %d logins", token, s.logins)
+	}
+	cached, err := readTokenCache(tokenContext())
+	if err != nil || cached.Token != s.token || cached.expired() {
+		t.Fatalf("expected refreshed token to be cached, got %v, error %v", cached, err)
+	}
+
+	// token that didn't expire is used as is.
+	if token, err := loadCachedToken(); err != nil || token != s.token || s.logins != 1 {
+		t.Fatalf("expected cached token, got %s after %d logins, error %v", token, s.logins, err)
+	}
+}
+
+func TestRefreshUnauthorizedToken(t *testing.T) {
+	s := newAuthServer()
+	defer setupTokenCache(t, s)()
+
+	// e.g. romanad was restarted with another key.
+	rejected := cachedToken{
+		RootURL:  tokenContext(),
+		Username: "admin",
+		Token:    testToken(0, time.Now().Add(time.Hour)),
+	}
+	rejected.Expires = tokenExpiry(rejected.Token)
+	if err := writeTokenCache(rejected); err != nil {
+		t.Fatal(err)
+	}
+	config.Set(common.PasswordKey, "secret")
+
+	var err error
+	if token, err = loadCachedToken(); err != nil {
+		t.Fatal(err)
+	}
+	if s.logins != 0 {
+		t.Fatalf("expected token that didn't expire to be used, got %d logins", s.logins)
+	}
+	retries = defaultRetries
+	setClientOptions()
+
+	resp, err := resty.R().Get(config.GetString("RootURL") + "/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusOK || s.logins != 1 {
+		t.Fatalf("expected request to succeed after refresh, got %s after %d logins", resp.Status(), s.logins)
+	}
+	cached, err := readTokenCache(tokenContext())
+	if err != nil || cached.Token != s.token {
+		t.Fatalf("expected refreshed token to be cached, got %v, error %v", cached, err)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/client"
+	"github.com/romana/core/common/migrate"
+
+	cli "github.com/spf13/cobra"
+)
+
+var (
+	migrateBackend    string
+	migrateFromPrefix string
+	migrateToPrefix   string
+	migrateTransforms []string
+	migrateDryRun     bool
+	migrateVerify     bool
+	migrateOverwrite  bool
+	migrateReencrypt  bool
+	migrateEtcdTLS    common.EtcdTLS
+	migrateEtcdAuth   common.EtcdAuth
+	migrateEncryption common.Encryption
+)
+
+// migrateCmd represents the store migration command
+var migrateCmd = &cli.Command{
+	Use:   "migrate",
+	Short: "Migrate romana keys between store prefixes or schema versions.",
+	Long: `Migrate romana keys between store prefixes or schema versions.
+
+Keys under --from-prefix are copied to --to-prefix, transformed by
+--transform in the given order. Locks, leaders and host liveness
+are not migrated. Keys that exist under --to-prefix with different
+values are only replaced with --overwrite.
+
+Use --dry-run to only show the changes and --verify to check that
+the destination matches the source after migration. After rotation
+of encryption keys, --reencrypt encrypts values under --from-prefix
+with the current key, so that old keys can be removed, e.g.
+
+  romana migrate --from-prefix /romana --to-prefix /romana-v2 --dry-run
+
+Stored policies and IPAM are upgraded to the current schema version
+when they are read, transform upgrade-schema rewrites them in place:
+
+  romana migrate --from-prefix /romana --to-prefix /romana -t upgrade-schema --overwrite
+
+migrate talks to the store directly, romana services should be
+stopped while keys are migrated.
+
+For more information, please check http://romana.io
+`,
+	RunE:         migrateRun,
+	SilenceUsage: true,
+}
+
+func init() {
+	migrateCmd.Flags().StringVarP(&migrateBackend, "store-backend", "", client.BackendEtcd,
+		"kv store holding romana data, etcd or consul")
+	migrateCmd.Flags().StringVarP(&migrateFromPrefix, "from-prefix", "", client.DefaultEtcdPrefix,
+		"prefix to migrate keys from")
+	migrateCmd.Flags().StringVarP(&migrateToPrefix, "to-prefix", "", "",
+		"prefix to migrate keys to (default same as --from-prefix)")
+	migrateCmd.Flags().StringSliceVarP(&migrateTransforms, "transform", "t", nil,
+		"transforms applied to keys, e.g. schema upgrades")
+	migrateCmd.Flags().BoolVarP(&migrateDryRun, "dry-run", "", false,
+		"only show changes migration would make")
+	migrateCmd.Flags().BoolVarP(&migrateVerify, "verify", "", false,
+		"only verify that keys were migrated")
+	migrateCmd.Flags().BoolVarP(&migrateOverwrite, "overwrite", "", false,
+		"replace keys that exist in the destination with different values")
+	migrateCmd.Flags().BoolVarP(&migrateReencrypt, "reencrypt", "", false,
+		"only encrypt values with the current encryption key")
+
+	fs := flag.NewFlagSet("etcd", flag.ContinueOnError)
+	migrateEtcdTLS.RegisterFlags(fs)
+	migrateEtcdAuth.RegisterFlags(fs)
+	migrateEncryption.RegisterFlags(fs)
+	migrateCmd.Flags().AddGoFlagSet(fs)
+}
+
+func migrateRun(cmd *cli.Command, args []string) error {
+	if len(args) != 0 {
+		return util.UsageError(cmd, "migrate takes no arguments.")
+	}
+	if migrateDryRun && migrateVerify {
+		return util.UsageError(cmd, "--dry-run and --verify are mutually exclusive.")
+	}
+
+	if migrateReencrypt {
+		store, err := migrateStore(migrateFromPrefix)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		count, err := store.Reencrypt()
+		if err != nil {
+			return err
+		}
+		printMessage("Re-encrypted %d values.", count)
+		return nil
+	}
+
+	toPrefix := migrateToPrefix
+	if toPrefix == "" {
+		toPrefix = migrateFromPrefix
+	}
+	if err := client.ValidatePrefix(toPrefix); err != nil {
+		return err
+	}
+
+	source, err := migrateStore(migrateFromPrefix)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	dest, err := migrateStore(toPrefix)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	migrator := &migrate.Migrator{Source: source, Dest: dest, Overwrite: migrateOverwrite}
+	for _, name := range migrateTransforms {
+		transform, err := migrate.Lookup(name)
+		if err != nil {
+			return err
+		}
+		migrator.Transforms = append(migrator.Transforms, transform)
+	}
+
+	if migrateVerify {
+		mismatched, err := migrator.Verify()
+		if err != nil {
+			return err
+		}
+		if len(mismatched) > 0 {
+			return fmt.Errorf("%d keys don't match the source: %s",
+				len(mismatched), strings.Join(mismatched, ", "))
+		}
+		printMessage("All keys match the source.")
+		return nil
+	}
+
+	plan, err := migrator.Plan()
+	if err != nil {
+		return err
+	}
+	if err := showMigrationPlan(plan); err != nil {
+		return err
+	}
+	if migrateDryRun {
+		return nil
+	}
+
+	if err := migrator.Apply(plan); err != nil {
+		return err
+	}
+	printMessage("Migrated %d keys from %s to %s.",
+		len(plan.Changes)-plan.Count(migrate.Unchanged), migrateFromPrefix, toPrefix)
+	return nil
+}
+
+func migrateStore(prefix string) (*client.Store, error) {
+	return client.NewStore(&common.Config{
+		Backend:       migrateBackend,
+		EtcdEndpoints: strings.Split(etcdEndpoints, ","),
+		EtcdPrefix:    prefix,
+		EtcdTLS:       migrateEtcdTLS,
+		EtcdAuth:      migrateEtcdAuth,
+		Encryption:    migrateEncryption,
+	})
+}
+
+func showMigrationPlan(plan *migrate.Plan) error {
+	return printObject(plan, func(w io.Writer, wide bool) {
+		printTitle(w, "Migration Plan")
+		fmt.Fprintf(w, "Key\tSource\tAction\n")
+		for _, change := range plan.Changes {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", change.Key, change.Source, change.Action)
+		}
+		fmt.Fprintf(w, "%d to create, %d to update, %d unchanged\n",
+			plan.Count(migrate.Create), plan.Count(migrate.Update), plan.Count(migrate.Unchanged))
+	})
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/romana/core/cli/util"
+
+	"github.com/go-resty/resty"
+	cli "github.com/spf13/cobra"
+)
+
+// MirrorRequest is a request to start mirroring accepted by agent.
+type MirrorRequest struct {
+	Endpoint        string `json:"endpoint"`
+	Interface       string `json:"interface,omitempty"`
+	Collector       net.IP `json:"collector,omitempty"`
+	VNI             int    `json:"vni,omitempty"`
+	DurationSeconds int    `json:"duration_seconds,omitempty"`
+}
+
+// MirrorSession is a mirroring session reported by agent.
+type MirrorSession struct {
+	ID string `json:"id"`
+	MirrorRequest
+	EndpointLink string    `json:"endpoint_link"`
+	TargetLink   string    `json:"target_link"`
+	Expires      time.Time `json:"expires"`
+}
+
+var (
+	mirrorInterface string
+	mirrorCollector string
+	mirrorVNI       int
+	mirrorDuration  time.Duration
+)
+
+// mirrorCmd represents the traffic mirroring commands
+var mirrorCmd = &cli.Command{
+	Use:   "mirror [start|list|stop]",
+	Short: "Temporarily mirror traffic of an endpoint for debugging.",
+	Long: `Temporarily mirror traffic of an endpoint for debugging.
+
+Mirroring is done by romana agent on the host of the endpoint,
+agent must be started with -mirror-port and -auth-public-key
+of romanad, requests are authenticated with romanad tokens
+given by --token, see 'romana login'.
+Traffic is mirrored either to a local interface (--interface) or
+to a collector in VXLAN encapsulation (--collector).
+
+mirror requires a subcommand, e.g. ` + "`romana mirror list`." + `
+
+For more information, please check http://romana.io
+`,
+}
+
+func init() {
+	mirrorCmd.AddCommand(mirrorStartCmd)
+	mirrorCmd.AddCommand(mirrorListCmd)
+	mirrorCmd.AddCommand(mirrorStopCmd)
+
+	mirrorStartCmd.Flags().StringVarP(&mirrorInterface, "interface", "i", "",
+		"interface on the agent host to mirror traffic to")
+	mirrorStartCmd.Flags().StringVarP(&mirrorCollector, "collector", "", "",
+		"ip address of a collector to send VXLAN encapsulated traffic to")
+	mirrorStartCmd.Flags().IntVarP(&mirrorVNI, "vni", "", 4242,
+		"VXLAN id used for traffic sent to collector")
+	mirrorStartCmd.Flags().DurationVarP(&mirrorDuration, "duration", "d", 5*time.Minute,
+		"how long to mirror traffic")
+}
+
+var mirrorStartCmd = &cli.Command{
+	Use:          "start [agent url][endpoint ip or interface]",
+	Short:        "Start mirroring traffic of an endpoint.",
+	Long:         `Start mirroring traffic of an endpoint, e.g. romana mirror start http://192.168.0.10:9608 10.0.0.5 --interface dummy0`,
+	RunE:         mirrorStart,
+	SilenceUsage: true,
+}
+
+var mirrorListCmd = &cli.Command{
+	Use:          "list [agent url]",
+	Short:        "List mirroring sessions of an agent.",
+	Long:         `List mirroring sessions of an agent.`,
+	RunE:         mirrorList,
+	SilenceUsage: true,
+}
+
+var mirrorStopCmd = &cli.Command{
+	Use:          "stop [agent url][session id]",
+	Short:        "Stop a mirroring session.",
+	Long:         `Stop a mirroring session.`,
+	RunE:         mirrorStop,
+	SilenceUsage: true,
+}
+
+func mirrorStart(cmd *cli.Command, args []string) error {
+	if len(args) != 2 {
+		return util.UsageError(cmd,
+			"AGENT URL and ENDPOINT expected.")
+	}
+
+	req := MirrorRequest{
+		Endpoint:        args[1],
+		Interface:       mirrorInterface,
+		VNI:             mirrorVNI,
+		DurationSeconds: int(mirrorDuration / time.Second),
+	}
+	if mirrorCollector != "" {
+		req.Collector = net.ParseIP(mirrorCollector)
+		if req.Collector == nil {
+			return fmt.Errorf("invalid collector address %s", mirrorCollector)
+		}
+	}
+
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(req).Post(mirrorURL(args[0]))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusCreated {
+		return mirrorError(resp)
+	}
+
+	var session MirrorSession
+	if err := json.Unmarshal(resp.Body(), &session); err != nil {
+		return err
+	}
+
+	return printObject(session, func(w io.Writer, wide bool) {
+		fmt.Fprintf(w, "Mirroring session %s started, traffic of %s is mirrored to %s until %s\n",
+			session.ID, session.EndpointLink, session.TargetLink, session.Expires.Format(time.RFC3339))
+	})
+}
+
+func mirrorList(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd,
+			"AGENT URL expected.")
+	}
+
+	resp, err := resty.R().Get(mirrorURL(args[0]))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return mirrorError(resp)
+	}
+
+	var sessions []MirrorSession
+	if err := json.Unmarshal(resp.Body(), &sessions); err != nil {
+		return err
+	}
+
+	return printObject(sessions, func(w io.Writer, wide bool) {
+		printTitle(w, "Mirroring Sessions")
+		fmt.Fprintf(w, "ID\tEndpoint\tInterface\tMirrored To\tExpires\n")
+		for _, s := range sessions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				s.ID,
+				s.Endpoint,
+				s.EndpointLink,
+				s.TargetLink,
+				s.Expires.Format(time.RFC3339),
+			)
+		}
+	})
+}
+
+func mirrorStop(cmd *cli.Command, args []string) error {
+	if len(args) != 2 {
+		return util.UsageError(cmd,
+			"AGENT URL and SESSION ID expected.")
+	}
+
+	resp, err := resty.R().Delete(mirrorURL(args[0]) + "/" + args[1])
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return mirrorError(resp)
+	}
+
+	printMessage("Mirroring session %s stopped", args[1])
+	return nil
+}
+
+func mirrorURL(agentURL string) string {
+	return strings.TrimSuffix(agentURL, "/") + "/mirrors"
+}
+
+func mirrorError(resp *resty.Response) error {
+	return fmt.Errorf("agent returned %s: %s", resp.Status(), strings.TrimSpace(string(resp.Body())))
+}
@@ -18,9 +18,7 @@ package commands
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"os"
-	"text/tabwriter"
+	"io"
 
 	"github.com/romana/core/common/api"
 
@@ -96,41 +94,34 @@ func networkList(cmd *cli.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
 
-	if config.GetString("Format") == "json" {
-		JSONFormat(resp.Body(), os.Stdout)
-	} else {
-		w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
-
-		if resp.StatusCode() == http.StatusOK {
-			var networks []api.IPAMNetworkResponse
-			err := json.Unmarshal(resp.Body(), &networks)
-			if err == nil {
-				fmt.Println("Network List")
-				fmt.Fprintf(w, "Network Name\tNetwork CIDR\tRevision\n")
-				for _, net := range networks {
-					fmt.Fprintf(w, "%s\t%s\t%d\n",
-						net.Name,
-						net.CIDR.String(),
-						net.Revision,
-					)
-				}
-			} else {
-				fmt.Printf("Error: %s \n", err)
-			}
-		} else {
-			var e Error
-			json.Unmarshal(resp.Body(), &e)
-
-			fmt.Println("Host Error")
-			fmt.Fprintf(w, "Fields\t%s\n", e.Fields)
-			fmt.Fprintf(w, "Message\t%s\n", e.Message)
-			fmt.Fprintf(w, "Status\t%d\n", resp.StatusCode())
-		}
-		w.Flush()
+	var networks []api.IPAMNetworkResponse
+	if err := json.Unmarshal(resp.Body(), &networks); err != nil {
+		return err
 	}
 
-	return nil
+	return printObject(networks, func(w io.Writer, wide bool) {
+		printTitle(w, "Network List")
+		fmt.Fprint(w, "Network Name\tNetwork CIDR\tRevision")
+		if wide {
+			fmt.Fprint(w, "\tSize\tAllocated\tBlacked Out\tBlocks")
+		}
+		fmt.Fprint(w, "\n")
+		for _, net := range networks {
+			fmt.Fprintf(w, "%s\t%s\t%d",
+				net.Name,
+				net.CIDR.String(),
+				net.Revision,
+			)
+			if wide {
+				fmt.Fprintf(w, "\t%d\t%d\t%d\t%d", net.Size, net.Allocated, net.BlackedOut, net.Blocks)
+			}
+			fmt.Fprint(w, "\n")
+		}
+	})
 }
 
 func networkRemove(cmd *cli.Command, args []string) error {
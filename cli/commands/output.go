@@ -0,0 +1,172 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common"
+
+	"github.com/go-resty/resty"
+	config "github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+	"k8s.io/client-go/pkg/util/jsonpath"
+)
+
+// Output formats, see --output.
+const (
+	outputTable    = "table"
+	outputWide     = "wide"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputJSONPath = "jsonpath"
+)
+
+// outputFormat returns output format given by --output, or by
+// --format and Format of config, and template of jsonpath format.
+func outputFormat() (string, string) {
+	format := config.GetString("Format")
+	if format == "" {
+		return outputTable, ""
+	}
+	if i := strings.Index(format, "="); i >= 0 {
+		return format[:i], format[i+1:]
+	}
+	return format, ""
+}
+
+// isStructuredOutput returns true if output format is not
+// a table, i.e. output is meant to be read by programs.
+func isStructuredOutput() bool {
+	format, _ := outputFormat()
+	return format != outputTable && format != outputWide
+}
+
+// printObject writes obj to stdout in the output format, table
+// writes it in table and wide formats, wide is true for the latter.
+// Objects are written as their JSON encoding is, so that field
+// names are the same in all structured formats.
+func printObject(obj interface{}, table func(w io.Writer, wide bool)) error {
+	format, template := outputFormat()
+	switch format {
+	case outputTable, outputWide:
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+		table(w, format == outputWide)
+		return w.Flush()
+	case outputJSON:
+		body, err := json.MarshalIndent(obj, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(body))
+		return nil
+	case outputYAML:
+		data, err := jsonValue(obj)
+		if err != nil {
+			return err
+		}
+		body, err := yaml.Marshal(data)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(body))
+		return nil
+	case outputJSONPath:
+		if template == "" {
+			return fmt.Errorf("jsonpath template expected, e.g. -o jsonpath={.name}")
+		}
+		data, err := jsonValue(obj)
+		if err != nil {
+			return err
+		}
+		jp := jsonpath.New("output")
+		if err := jp.Parse(template); err != nil {
+			return fmt.Errorf("invalid jsonpath template %s: %s", template, err)
+		}
+		if err := jp.Execute(os.Stdout, data); err != nil {
+			return err
+		}
+		fmt.Println()
+		return nil
+	}
+	return fmt.Errorf("unknown output format %s, supported are "+
+		"table, wide, json, yaml and jsonpath=TEMPLATE", format)
+}
+
+// printResponse writes JSON body of successful response in the
+// output format, status of the response is written if the body
+// is empty. Table formats write message instead.
+func printResponse(resp *resty.Response, message string) error {
+	var obj interface{}
+	body := strings.TrimSpace(string(resp.Body()))
+	if body == "" || body == "null" {
+		obj = common.HttpError{StatusCode: resp.StatusCode(), Details: resp.Status()}
+	} else if err := json.Unmarshal(resp.Body(), &obj); err != nil {
+		return err
+	}
+
+	return printObject(obj, func(w io.Writer, wide bool) {
+		printTitle(w, message)
+	})
+}
+
+// printTitle writes title of table output unless --quiet was given.
+func printTitle(w io.Writer, title string) {
+	if !quiet {
+		fmt.Fprintln(w, title)
+	}
+}
+
+// printMessage writes message about completed operation to stdout,
+// unless --quiet was given or output is structured.
+func printMessage(format string, args ...interface{}) {
+	if !quiet && !isStructuredOutput() {
+		fmt.Printf(format+"\n", args...)
+	}
+}
+
+// printError writes machine-readable form of the error returned by
+// a command to stderr as JSON, see util.ErrorInfo.
+func printError(err error) {
+	info := util.DescribeError(err)
+	if info.Status != 0 && info.RequestID == "" {
+		info.RequestID = requestID
+	}
+	body, jsonErr := json.MarshalIndent(info, "", "\t")
+	if jsonErr != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, string(body))
+}
+
+// jsonValue returns obj as decoded from its JSON encoding,
+// i.e. built of maps, slices and values.
+func jsonValue(obj interface{}) (interface{}, error) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	err = json.Unmarshal(body, &data)
+	return data, err
+}
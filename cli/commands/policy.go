@@ -16,22 +16,28 @@
 package commands
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"text/tabwriter"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/romana/core/cli/util"
 	"github.com/romana/core/common"
 	"github.com/romana/core/common/api"
 
 	"github.com/go-resty/resty"
-	ms "github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
 	log "github.com/romana/rlog"
 	cli "github.com/spf13/cobra"
 	config "github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
 )
 
 // Policies structure is used to keep track of
@@ -44,7 +50,7 @@ type Policies struct {
 
 // policyCmd represents the policy commands
 var policyCmd = &cli.Command{
-	Use:   "policy [add|show|list|remove]",
+	Use:   "policy [add|apply|show|list|remove]",
 	Short: "Add, Remove or Show policies for romana services.",
 	Long: `Add, Remove or Show policies for romana services.
 
@@ -52,8 +58,38 @@ For more information, please check http://romana.io
 `,
 }
 
+// Variables used for policy list flags.
+var (
+	policyTenant     string
+	policyAllTenants bool
+	policySelector   string
+)
+
+// Variables used for policy apply flags.
+var (
+	policyFiles     []string
+	policyRecursive bool
+)
+
+// policyLabels are keys policies can be selected by.
+var policyLabels = []string{"id", "direction", "tenant", "segment", "peer", "cidr", "dest"}
+
 func init() {
+	policyListCmd.Flags().StringVarP(&policyTenant, "tenant", "t", "",
+		"list policies applied to the tenant (default Tenant of config)")
+	policyListCmd.Flags().BoolVarP(&policyAllTenants, "all-tenants", "A", false,
+		"list policies of all tenants")
+	policyListCmd.Flags().StringVarP(&policySelector, "selector", "l", "",
+		"list policies matching selector, e.g. segment=frontend,direction!=egress")
+	completeFlag(policyListCmd, "tenant", completeTenants)
+
+	policyApplyCmd.Flags().StringSliceVarP(&policyFiles, "filename", "f", nil,
+		"policy file, directory of policy files, or - for STDIN")
+	policyApplyCmd.Flags().BoolVarP(&policyRecursive, "recursive", "R", false,
+		"apply policy files in subdirectories of directories too")
+
 	policyCmd.AddCommand(policyAddCmd)
+	policyCmd.AddCommand(policyApplyCmd)
 	policyCmd.AddCommand(policyRemoveCmd)
 	policyCmd.AddCommand(policyListCmd)
 	policyCmd.AddCommand(policyShowCmd)
@@ -79,28 +115,56 @@ The features supported are:
 	SilenceUsage: true,
 }
 
-var policyRemoveCmd = &cli.Command{
-	Use:          "remove [policyID]",
-	Short:        "Remove a specific policy.",
-	Long:         `Remove a specific policy.`,
-	RunE:         policyRemove,
+var policyApplyCmd = &cli.Command{
+	Use:   "apply -f [policyFile|directory|-]",
+	Short: "Apply policies from files, directories or STDIN.",
+	Long: `Apply policies from files, directories or STDIN.
+
+Policies are read from files given by --filename, from JSON and
+YAML files in directories given by it, and from STDIN for -. A
+file holds a single policy or a list of them, YAML files can hold
+several documents. All policies are validated before any of them
+is applied, and they are applied in a single request, unless
+romanad doesn't support it, in which case they are added one by one.
+Applying is not atomic, if it fails some policies may be applied,
+and it can be safely repeated.
+`,
+	RunE:         policyApply,
 	SilenceUsage: true,
 }
 
+var policyRemoveCmd = &cli.Command{
+	Use:               "remove [policyID]",
+	Short:             "Remove a specific policy.",
+	Long:              `Remove a specific policy.`,
+	RunE:              policyRemove,
+	ValidArgsFunction: completePolicies,
+	SilenceUsage:      true,
+}
+
 var policyListCmd = &cli.Command{
-	Use:          "list",
-	Short:        "List all policies.",
-	Long:         `List all policies.`,
+	Use:   "list",
+	Short: "List policies.",
+	Long: `List policies.
+
+Policies applied to the tenant given by --tenant, or by Tenant
+of the config, are listed, all policies are listed if neither
+is set or --all-tenants is given. Policies can be filtered by
+--selector, which is a comma-separated list of key=value and
+key!=value requirements, keys are id, direction, and tenant,
+segment, peer, cidr and dest of endpoints policy is applied to.
+`,
 	RunE:         policyList,
 	SilenceUsage: true,
 }
 
 var policyShowCmd = &cli.Command{
-	Use:          "show [PolicyID]",
-	Short:        "Show details about a specific policy using policyID.",
-	Long:         `Show details about a specific policy using policyID.`,
-	RunE:         policyShow,
-	SilenceUsage: true,
+	Use:               "show [PolicyID]",
+	Short:             "Show details about a specific policy using policyID.",
+	Long:              `Show details about a specific policy using policyID.`,
+	RunE:              policyShow,
+	ValidArgsFunction: completePolicies,
+	SilenceUsage:      true,
 }
 
 // policyAdd adds romana policy for a specific tenant
@@ -118,7 +182,6 @@ func policyAdd(cmd *cli.Command, args []string) error {
 	var policyFile string
 	var err error
 	isFile := true
-	isJSON := config.GetString("Format") == "json"
 
 	if len(args) == 0 {
 		isFile = false
@@ -164,21 +227,19 @@ func policyAdd(cmd *cli.Command, args []string) error {
 		}
 	}
 
-	result := make([]map[string]interface{}, len(reqPolicies.SecurityPolicies))
+	result := make([]common.HttpError, len(reqPolicies.SecurityPolicies))
 	reqPolicies.AppliedSuccessfully = make([]bool, len(reqPolicies.SecurityPolicies))
 	for i, pol := range reqPolicies.SecurityPolicies {
 		reqPolicies.AppliedSuccessfully[i] = false
 		r, err := resty.R().SetHeader("Content-Type", "application/json").
 			SetBody(pol).Post(rootURL + "/policies")
-		m := make(map[string]interface{})
-		m["details"] = r.Status()
-		m["status_code"] = r.StatusCode()
-		result[i] = m
 		if err != nil {
+			result[i] = common.HttpError{Details: err.Error()}
 			log.Printf("Error applying policy (%s:%s): %v\n",
 				pol.ID, pol.Description, err)
 			continue
 		}
+		result[i] = common.HttpError{StatusCode: r.StatusCode(), Details: r.Status()}
 		if r.StatusCode() != http.StatusOK {
 			log.Printf("Error applying policy (%s:%s): %s\n",
 				pol.ID, pol.Description, r.Status())
@@ -187,35 +248,316 @@ func policyAdd(cmd *cli.Command, args []string) error {
 		reqPolicies.AppliedSuccessfully[i] = true
 	}
 
-	if isJSON {
-		for i := range reqPolicies.SecurityPolicies {
-			var h common.HttpError
-			dc := &ms.DecoderConfig{TagName: "json", Result: &h}
-			decoder, err := ms.NewDecoder(dc)
-			if err != nil {
-				continue
-			}
-			err = decoder.Decode(result[i])
-			if err != nil {
-				continue
-			}
-			status, _ := json.MarshalIndent(h, "", "\t")
-			fmt.Println(string(status))
-		}
-	} else {
-		w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
-		fmt.Println("New Policies Processed:")
+	return printObject(result, func(w io.Writer, wide bool) {
+		fmt.Fprintln(w, "New Policies Processed:")
 		fmt.Fprintf(w, "Id\tDirection\tSuccessful Applied?\n")
 		for i, p := range reqPolicies.SecurityPolicies {
 			fmt.Fprintf(w, "%s \t %s \t %t \n", p.ID,
 				p.Direction, reqPolicies.AppliedSuccessfully[i])
 		}
-		w.Flush()
+	})
+}
+
+// policyFailed is result of policy romanad failed to add.
+const policyFailed = "failed"
+
+// policySource is a policy read from a file.
+type policySource struct {
+	Source string
+	Policy api.Policy
+}
+
+// policyApplyResult is result of applying a policy read from a file.
+type policyApplyResult struct {
+	Source string `json:"source"`
+	api.PolicyResult
+}
+
+// yamlSeparator separates documents in YAML files.
+var yamlSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// policyApply applies policies from files, directories and STDIN
+// given by --filename, see policyApplyCmd.
+func policyApply(cmd *cli.Command, args []string) error {
+	if len(policyFiles) == 0 || len(args) > 0 {
+		return util.UsageError(cmd,
+			"POLICY FILE, directory or - for 'STDIN' expected in --filename.")
+	}
+
+	sources, err := readPolicySources(policyFiles, policyRecursive)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no policies found in %s", strings.Join(policyFiles, ", "))
+	}
+	if problems := checkPolicySources(sources); len(problems) > 0 {
+		return fmt.Errorf("no policies were applied, %d problems found:\n%s",
+			len(problems), strings.Join(problems, "\n"))
+	}
+
+	policies := make([]api.Policy, len(sources))
+	for i := range sources {
+		policies[i] = sources[i].Policy
+	}
+	results, err := applyPolicies(policies)
+	if err != nil && results == nil {
+		return err
+	}
+
+	failed := 0
+	output := make([]policyApplyResult, len(results))
+	for i := range results {
+		output[i] = policyApplyResult{Source: sources[i].Source, PolicyResult: results[i]}
+		if results[i].Error != "" {
+			failed++
+		}
+	}
+	if perr := printObject(output, func(w io.Writer, wide bool) {
+		printTitle(w, "Policies Applied")
+		fmt.Fprintln(w, "Source\tId\tDirection\tResult\tError")
+		for i, r := range output {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				r.Source, r.ID, sources[i].Policy.Direction, r.Result, r.Error)
+		}
+	}); perr != nil {
+		return perr
 	}
 
+	if err != nil {
+		return errors.Wrapf(err, "%d of %d policies are invalid, none was applied", failed, len(results))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d policies were not applied", failed, len(results))
+	}
 	return nil
 }
 
+// applyPolicies applies policies in a single request, or one by
+// one if romanad doesn't support it. If romanad finds some policies
+// invalid, results tell which and error is returned too.
+func applyPolicies(policies []api.Policy) ([]api.PolicyResult, error) {
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(policies).Put(rootURL + "/policies")
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		log.Printf("romanad doesn't apply policies in a single request, adding them one by one")
+		return addPolicies(policies)
+	case common.StatusUnprocessableEntity:
+		var invalid struct {
+			Details []api.PolicyResult `json:"details"`
+		}
+		err := json.Unmarshal(resp.Body(), &invalid)
+		if err == nil && len(invalid.Details) == len(policies) {
+			return invalid.Details, common.HttpError{StatusCode: resp.StatusCode()}
+		}
+	}
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+
+	var results []api.PolicyResult
+	if err := json.Unmarshal(resp.Body(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// addPolicies adds policies one by one.
+func addPolicies(policies []api.Policy) ([]api.PolicyResult, error) {
+	existing, err := getPolicies()
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool)
+	for _, p := range existing {
+		exists[p.ID] = true
+	}
+
+	rootURL := config.GetString("RootURL")
+	results := make([]api.PolicyResult, len(policies))
+	for i, policy := range policies {
+		results[i] = api.PolicyResult{ID: policy.ID, Result: api.PolicyCreated}
+		if exists[policy.ID] {
+			results[i].Result = api.PolicyUpdated
+		}
+
+		resp, err := resty.R().SetHeader("Content-Type", "application/json").
+			SetBody(policy).Post(rootURL + "/policies")
+		if err == nil {
+			err = responseError(resp)
+		}
+		if err != nil {
+			results[i].Result = policyFailed
+			results[i].Error = err.Error()
+		}
+	}
+	return results, nil
+}
+
+// readPolicySources reads policies from files, directories and
+// STDIN given by paths, subdirectories are read if recursive.
+func readPolicySources(paths []string, recursive bool) ([]policySource, error) {
+	var sources []policySource
+	for _, path := range paths {
+		if path == "-" {
+			buf, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read 'STDIN': %s", err)
+			}
+			policies, err := decodePolicies(buf, "")
+			if err != nil {
+				return nil, fmt.Errorf("STDIN: %s", err)
+			}
+			for _, p := range policies {
+				sources = append(sources, policySource{Source: "STDIN", Policy: p})
+			}
+			continue
+		}
+
+		files, err := policyFilesIn(path, recursive)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			buf, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("file error: %s", err)
+			}
+			policies, err := decodePolicies(buf, filepath.Ext(file))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+			for _, p := range policies {
+				sources = append(sources, policySource{Source: file, Policy: p})
+			}
+		}
+	}
+	return sources, nil
+}
+
+// policyFilesIn returns path if it is a file, or JSON and YAML files
+// in it if it is a directory, including subdirectories if recursive.
+func policyFilesIn(path string, recursive bool) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("file error: %s", err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if file != path && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(file) {
+		case ".json", ".yaml", ".yml":
+			files = append(files, file)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// decodePolicies decodes a policy or a list of policies in JSON or
+// YAML, YAML may hold several documents. Format is given by file
+// extension ext, or detected if ext is empty.
+func decodePolicies(buf []byte, ext string) ([]api.Policy, error) {
+	trimmed := bytes.TrimSpace(buf)
+	isJSON := ext == ".json" ||
+		(ext == "" && len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{'))
+	if isJSON {
+		return decodeJSONPolicies(trimmed)
+	}
+
+	var policies []api.Policy
+	for _, doc := range yamlSeparator.Split(string(buf), -1) {
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(doc), &value); err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		b, err := json.Marshal(jsonCompatible(value))
+		if err != nil {
+			return nil, err
+		}
+		docPolicies, err := decodeJSONPolicies(b)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, docPolicies...)
+	}
+	return policies, nil
+}
+
+// decodeJSONPolicies decodes a JSON policy or a list of them.
+func decodeJSONPolicies(buf []byte) ([]api.Policy, error) {
+	var policies []api.Policy
+	if len(buf) > 0 && buf[0] == '[' {
+		err := json.Unmarshal(buf, &policies)
+		return policies, err
+	}
+
+	var policy api.Policy
+	if err := json.Unmarshal(buf, &policy); err != nil {
+		return nil, err
+	}
+	return []api.Policy{policy}, nil
+}
+
+// jsonCompatible converts maps decoded from YAML, which have
+// interface{} keys, to maps JSON can encode.
+func jsonCompatible(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			m[fmt.Sprint(k)] = jsonCompatible(v)
+		}
+		return m
+	case []interface{}:
+		for i, v := range value {
+			value[i] = jsonCompatible(v)
+		}
+	}
+	return value
+}
+
+// checkPolicySources returns problems found in policies which
+// romanad would reject, i.e. missing and duplicate IDs.
+func checkPolicySources(sources []policySource) []string {
+	var problems []string
+	seen := make(map[string]string)
+	for _, s := range sources {
+		id := s.Policy.ID
+		if id == "" {
+			problems = append(problems, fmt.Sprintf("%s: policy without ID", s.Source))
+			continue
+		}
+		if source, ok := seen[id]; ok {
+			problems = append(problems, fmt.Sprintf("%s: policy %s is in %s too", s.Source, id, source))
+			continue
+		}
+		seen[id] = s.Source
+	}
+	return problems
+}
+
 // policyRemove removes policy using the policy name provided
 // as argument through args. It returns error if policy is not
 // found, or returns a list of policy ID's if multiple policies
@@ -228,6 +570,9 @@ func policyRemove(cmd *cli.Command, args []string) error {
 
 	var policy api.Policy
 	policy.ID = args[0]
+	if err := confirm("Remove policy %s?", policy.ID); err != nil {
+		return err
+	}
 
 	rootURL := config.GetString("RootURL")
 	resp, err := resty.R().
@@ -237,36 +582,11 @@ func policyRemove(cmd *cli.Command, args []string) error {
 		return err
 	}
 
-	if config.GetString("Format") == "json" {
-		if string(resp.Body()) == "" || string(resp.Body()) == "null" {
-			var h common.HttpError
-			dc := &ms.DecoderConfig{TagName: "json", Result: &h}
-			decoder, err := ms.NewDecoder(dc)
-			if err != nil {
-				return err
-			}
-			m := make(map[string]interface{})
-			m["details"] = resp.Status()
-			m["status_code"] = resp.StatusCode()
-			err = decoder.Decode(m)
-			if err != nil {
-				return err
-			}
-			status, _ := json.MarshalIndent(h, "", "\t")
-			fmt.Println(string(status))
-		} else {
-			JSONFormat(resp.Body(), os.Stdout)
-		}
-	} else {
-		if resp.StatusCode() == http.StatusOK {
-			fmt.Printf("Policy (ID: %s) deleted successfully.\n", policy.ID)
-		} else {
-			fmt.Printf("Error deleting policy (ID: %s): %s\n",
-				policy.ID, resp.Status())
-		}
+	if err := responseError(resp); err != nil {
+		return errors.Wrapf(err, "error deleting policy (ID: %s)", policy.ID)
 	}
 
-	return nil
+	return printResponse(resp, fmt.Sprintf("Policy (ID: %s) deleted successfully.", policy.ID))
 }
 
 // policyList lists policies in tabular or json format.
@@ -275,41 +595,110 @@ func policyList(cmd *cli.Command, args []string) error {
 		return util.UsageError(cmd,
 			"Policy listing takes no arguments.")
 	}
-	return policyListShow(true, nil)
+
+	selector, err := util.ParseSelector(policySelector)
+	if err != nil {
+		return util.UsageError(cmd, "%s", err)
+	}
+	if unknown := selector.Unknown(policyLabels...); len(unknown) > 0 {
+		return util.UsageError(cmd, "Unknown selector keys %s, known are %s.",
+			strings.Join(unknown, ", "), strings.Join(policyLabels, ", "))
+	}
+
+	tenant := policyTenant
+	if tenant == "" {
+		tenant = config.GetString("Tenant")
+	}
+	if policyAllTenants {
+		if policyTenant != "" {
+			return util.UsageError(cmd,
+				"Only one of --tenant and --all-tenants may be given.")
+		}
+		tenant = ""
+	}
+	if tenant != "" {
+		selector = append(selector, util.Requirement{Key: "tenant", Value: tenant})
+	}
+
+	return policyListShow(true, nil, selector)
 }
 
 // policyShow displays details about a specific policy
 // in tabular or json format.
 func policyShow(cmd *cli.Command, args []string) error {
-	return policyListShow(false, args)
+	return policyListShow(false, args, nil)
 }
 
-// policyListShow lists/shows policies in tabular or json format.
-func policyListShow(listOnly bool, args []string) error {
-	specificPolicies := false
-	if len(args) > 0 {
-		specificPolicies = true
+// policyLabelsOf returns values of policy labels, see policyLabels.
+func policyLabelsOf(p api.Policy) map[string][]string {
+	labels := map[string][]string{
+		"id":        {p.ID},
+		"direction": {p.Direction},
+	}
+	for _, e := range p.AppliedTo {
+		labels["tenant"] = append(labels["tenant"], e.TenantID)
+		labels["segment"] = append(labels["segment"], e.SegmentID)
+		labels["peer"] = append(labels["peer"], e.Peer)
+		labels["cidr"] = append(labels["cidr"], e.Cidr)
+		labels["dest"] = append(labels["dest"], e.Dest)
 	}
+	return labels
+}
 
-	if !listOnly && !specificPolicies {
-		return fmt.Errorf("policy show takes at-least one argument i.e policy id/s")
+// policyTenants returns tenants policy is applied to.
+func policyTenants(p api.Policy) string {
+	seen := make(map[string]bool)
+	var tenants []string
+	for _, e := range p.AppliedTo {
+		if e.TenantID != "" && !seen[e.TenantID] {
+			seen[e.TenantID] = true
+			tenants = append(tenants, e.TenantID)
+		}
 	}
+	sort.Strings(tenants)
+	return strings.Join(tenants, ",")
+}
 
+// getPolicies returns all policies.
+func getPolicies() ([]api.Policy, error) {
 	rootURL := config.GetString("RootURL")
 	resp, err := resty.R().Get(rootURL + "/policies")
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+
+	var policies []api.Policy
+	err = json.Unmarshal(resp.Body(), &policies)
+	return policies, err
+}
+
+// policyListShow lists/shows policies in tabular or json format,
+// policies listed are filtered by the selector.
+func policyListShow(listOnly bool, args []string, selector util.Selector) error {
+	specificPolicies := false
+	if len(args) > 0 {
+		specificPolicies = true
 	}
 
-	var allPolicies []api.Policy
-	err = json.Unmarshal(resp.Body(), &allPolicies)
+	if !listOnly && !specificPolicies {
+		return fmt.Errorf("policy show takes at-least one argument i.e policy id/s")
+	}
+
+	allPolicies, err := getPolicies()
 	if err != nil {
 		return err
 	}
 
-	var policies []api.Policy
+	policies := []api.Policy{}
 	if listOnly {
-		policies = allPolicies
+		for _, p := range allPolicies {
+			if selector.Matches(policyLabelsOf(p)) {
+				policies = append(policies, p)
+			}
+		}
 	} else {
 		if specificPolicies {
 			for _, a := range args {
@@ -322,18 +711,14 @@ func policyListShow(listOnly bool, args []string) error {
 		}
 	}
 
-	if config.GetString("Format") == "json" {
-		body, _ := json.MarshalIndent(policies, "", "\t")
-		fmt.Println(string(body))
-	} else {
-		w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	return printObject(policies, func(w io.Writer, wide bool) {
 		if listOnly {
-			fmt.Println("Policy List")
+			printTitle(w, "Policy List")
 			fmt.Fprintf(w,
-				"Policy Id\tDirection\tApplied to\tNo of Peers\tNo of Rules\tDescription\n",
+				"Policy Id\tDirection\tTenants\tApplied to\tNo of Peers\tNo of Rules\tDescription\n",
 			)
 		} else {
-			fmt.Println("Policy Details")
+			printTitle(w, "Policy Details")
 		}
 		for _, p := range policies {
 			if listOnly {
@@ -344,9 +729,10 @@ func policyListShow(listOnly bool, args []string) error {
 					noOfRules += len(p.Ingress[i].Rules)
 				}
 
-				fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n",
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n",
 					p.ID,
 					p.Direction,
+					policyTenants(p),
 					len(p.AppliedTo),
 					noOfPeers,
 					noOfRules,
@@ -395,8 +781,5 @@ func policyListShow(listOnly bool, args []string) error {
 				fmt.Fprint(w, "\n")
 			}
 		}
-		w.Flush()
-	}
-
-	return nil
+	})
 }
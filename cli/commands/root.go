@@ -16,14 +16,20 @@
 package commands
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/romana/core/cli/util"
 	"github.com/romana/core/common"
+	"github.com/romana/core/common/client"
 
 	"github.com/go-resty/resty"
 	log "github.com/romana/rlog"
@@ -38,7 +44,38 @@ var (
 	version  bool
 	verbose  bool
 	format   string
+	output   string
 	platform string
+
+	// assumeYes confirms destructive operations.
+	assumeYes bool
+	// quiet suppresses titles and messages, leaving only data.
+	quiet bool
+
+	// timeout and retries apply to requests to romana services.
+	timeout time.Duration
+	retries int
+	// requestID identifies requests of the command in logs
+	// of romana services, generated unless provided.
+	requestID string
+	// etcdEndpoints are used by commands that talk to the store directly.
+	etcdEndpoints string
+	// token authenticates requests to romana services and agents,
+	// see `romana login`.
+	token string
+	// deprecationWarned is set once the user is warned that
+	// the service deprecated a route the command uses.
+	deprecationWarned bool
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	defaultRetries = 2
+
+	// retryWaitTime and retryMaxWaitTime bound delays between retries,
+	// delay grows exponentially between them.
+	retryWaitTime    = 500 * time.Millisecond
+	retryMaxWaitTime = 5 * time.Second
 )
 
 // type Error contains information for
@@ -71,7 +108,10 @@ For more information, please check http://romana.io
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
 		log.Println(err)
-		os.Exit(-1)
+		if isStructuredOutput() {
+			printError(err)
+		}
+		os.Exit(util.ExitCode(err))
 	}
 }
 
@@ -79,10 +119,24 @@ func init() {
 	cli.OnInitialize(initConfig)
 
 	RootCmd.AddCommand(hostCmd)
+	RootCmd.AddCommand(tenantCmd)
+	RootCmd.AddCommand(segmentCmd)
 	RootCmd.AddCommand(policyCmd)
 	RootCmd.AddCommand(networkCmd)
 	RootCmd.AddCommand(blockCmd)
 	RootCmd.AddCommand(topologyCmd)
+	RootCmd.AddCommand(mirrorCmd)
+	RootCmd.AddCommand(migrateCmd)
+	RootCmd.AddCommand(ipamCmd)
+	RootCmd.AddCommand(federationCmd)
+	RootCmd.AddCommand(exportCmd)
+	RootCmd.AddCommand(importCmd)
+	RootCmd.AddCommand(validateCmd)
+	RootCmd.AddCommand(statsCmd)
+	RootCmd.AddCommand(eventsCmd)
+	RootCmd.AddCommand(completionCmd)
+	RootCmd.AddCommand(loginCmd)
+	RootCmd.AddCommand(versionCmd)
 
 	RootCmd.Flags().BoolVarP(&version, "version", "",
 		false, "Build and Versioning Information.")
@@ -91,12 +145,36 @@ func init() {
 		"c", "", "config file (default $HOME/.romana.yaml | /etc/romana/cli.yaml)")
 	RootCmd.PersistentFlags().StringVarP(&rootURL, "rootURL",
 		"r", "", "root service url, e.g. http://192.168.0.1:9600")
+	RootCmd.PersistentFlags().StringVarP(&rootURL, "api-url",
+		"", "", "same as --rootURL.")
+	RootCmd.PersistentFlags().StringVarP(&etcdEndpoints, "etcd-endpoints",
+		"", "", "comma-separated list of etcd endpoints (default "+client.DefaultEtcdEndpoints+")")
+	RootCmd.PersistentFlags().DurationVarP(&timeout, "timeout",
+		"", defaultTimeout, "timeout of a request to romana services.")
+	RootCmd.PersistentFlags().IntVarP(&retries, "retries",
+		"", defaultRetries, "how many times failed requests to romana services are retried.")
+	RootCmd.PersistentFlags().StringVarP(&requestID, "request-id",
+		"", "", "ID of requests to romana services in their logs (default random).")
+	RootCmd.PersistentFlags().StringVarP(&token, "token",
+		"", "", "token authenticating requests, e.g. issued by 'romana login' (default $ROMANA_TOKEN).")
 	RootCmd.PersistentFlags().StringVarP(&format, "format",
-		"f", "", "enable formatting options like [json|table], etc.")
+		"", "", "same as --output, kept for compatibility.")
+	RootCmd.PersistentFlags().StringVarP(&output, "output",
+		"o", "", "output format, one of [table|wide|json|yaml|jsonpath=TEMPLATE]")
 	RootCmd.PersistentFlags().StringVarP(&platform, "platform",
 		"P", "", "Use platforms like [openstack|kubernetes], etc.")
 	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose",
 		"v", false, "Verbose output.")
+	RootCmd.PersistentFlags().BoolVarP(&assumeYes, "yes",
+		"y", false, "Don't ask to confirm destructive operations.")
+	RootCmd.PersistentFlags().BoolVarP(&assumeYes, "force",
+		"", false, "Same as --yes.")
+	RootCmd.PersistentFlags().BoolVarP(&quiet, "quiet",
+		"q", false, "Suppress titles and messages, output only data.")
+
+	RootCmd.SetFlagErrorFunc(func(cmd *cli.Command, err error) error {
+		return util.UsageError(cmd, "%s", err)
+	})
 
 	RootCmd.PersistentPreRun = preConfig
 	RootCmd.Run = versionInfo
@@ -104,8 +182,12 @@ func init() {
 
 // preConfig sanitizes URLs and sets up config with URLs.
 func preConfig(cmd *cli.Command, args []string) {
-	// if nothing is given on command line try
-	// fetching it from config
+	// if nothing is given on command line try fetching it
+	// from environment and then config, ROOTURL of environment
+	// is read by config as well.
+	if rootURL == "" {
+		rootURL = os.Getenv("ROMANA_API_URL")
+	}
 	if rootURL == "" {
 		rootURL = config.GetString("RootURL")
 	}
@@ -114,10 +196,15 @@ func preConfig(cmd *cli.Command, args []string) {
 	if rootURL == "" {
 		rootURL = "http://127.0.0.1:9600"
 	}
-	config.Set("RootURL", rootURL)
+	// requests are made to the current version of romana API.
+	config.Set("RootURL", versionedURL(rootURL))
 
 	// Give command line options higher priority then
-	// the corresponding config options.
+	// the corresponding config options, --output takes
+	// precedence over its older form --format.
+	if output != "" {
+		format = output
+	}
 	if format == "" {
 		format = config.GetString("Format")
 	}
@@ -126,6 +213,12 @@ func preConfig(cmd *cli.Command, args []string) {
 		format = "table"
 	}
 	config.Set("Format", format)
+	// errors are written by Execute in structured output,
+	// in place of the message and usage written by cobra.
+	if isStructuredOutput() {
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+	}
 
 	if platform == "" {
 		platform = config.GetString("Platform")
@@ -134,6 +227,107 @@ func preConfig(cmd *cli.Command, args []string) {
 		platform = "kubernetes"
 	}
 	config.Set("Platform", platform)
+
+	// flags given on command line take precedence over
+	// environment and config, which take precedence
+	// over defaults of the flags.
+	if !cmd.Flags().Changed("timeout") && config.IsSet("Timeout") {
+		timeout = config.GetDuration("Timeout")
+	}
+	if !cmd.Flags().Changed("retries") && config.IsSet("Retries") {
+		retries = config.GetInt("Retries")
+	}
+	config.Set("Timeout", timeout)
+	config.Set("Retries", retries)
+
+	if etcdEndpoints == "" {
+		etcdEndpoints = config.GetString("EtcdEndpoints")
+	}
+	if etcdEndpoints == "" {
+		etcdEndpoints = client.DefaultEtcdEndpoints
+	}
+	config.Set("EtcdEndpoints", etcdEndpoints)
+
+	if token == "" {
+		token = config.GetString("Token")
+	}
+	// login gets a new token, other commands use the token
+	// cached by login unless a token is given.
+	if token == "" && cmd != loginCmd {
+		var err error
+		if token, err = loadCachedToken(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s.\n", err)
+		}
+	}
+
+	setClientOptions()
+}
+
+// setClientOptions applies timeout and retries to requests
+// to romana services. Requests are retried when the service
+// can't be reached or is temporarily unavailable. All requests
+// of the command share the request ID and the token, and each
+// POST request has an idempotency key, so that its retries don't
+// make changes twice. Requests rejected by the service are
+// retried once the cached token is refreshed.
+func setClientOptions() {
+	if requestID == "" {
+		requestID = common.NewRequestID()
+	}
+	resty.SetHeader(common.HeaderRequestID, requestID)
+	if token != "" {
+		resty.SetAuthToken(token)
+	}
+	resty.SetTimeout(timeout)
+	resty.SetRetryCount(retries)
+	resty.SetRetryWaitTime(retryWaitTime)
+	resty.SetRetryMaxWaitTime(retryMaxWaitTime)
+	resty.AddRetryCondition(func(resp *resty.Response) (bool, error) {
+		switch resp.StatusCode() {
+		case http.StatusBadGateway, http.StatusServiceUnavailable:
+			return true, nil
+		}
+		return false, nil
+	})
+	resty.AddRetryCondition(refreshOnUnauthorized)
+	resty.OnBeforeRequest(setIdempotencyKey)
+	resty.OnAfterResponse(warnDeprecated)
+}
+
+// setIdempotencyKey sets idempotency key of POST requests, the
+// key is kept by retries of the request.
+func setIdempotencyKey(c *resty.Client, r *resty.Request) error {
+	if r.Method == http.MethodPost && r.Header.Get(common.HeaderIdempotencyKey) == "" {
+		r.SetHeader(common.HeaderIdempotencyKey, common.NewRequestID())
+	}
+	return nil
+}
+
+// versionedURL returns URL of the current version of romana API
+// at the root URL, unless the root URL has the version already.
+func versionedURL(rootURL string) string {
+	rootURL = strings.TrimSuffix(rootURL, "/")
+	if strings.HasSuffix(rootURL, "/"+common.APIVersion) {
+		return rootURL
+	}
+	return rootURL + "/" + common.APIVersion
+}
+
+// warnDeprecated warns once per command that the service
+// deprecated a route the command uses, e.g. after upgrade
+// of romanad that CLI wasn't upgraded along with.
+func warnDeprecated(c *resty.Client, resp *resty.Response) error {
+	if deprecationWarned || resp.Header().Get(common.HeaderDeprecation) == "" {
+		return nil
+	}
+	deprecationWarned = true
+
+	msg := fmt.Sprintf("Warning: %s %s is deprecated", resp.Request.Method, resp.Request.URL)
+	if sunset := resp.Header().Get(common.HeaderSunset); sunset != "" {
+		msg += " and may be removed after " + sunset
+	}
+	fmt.Fprintf(os.Stderr, "%s, please upgrade romana CLI.\n", msg)
+	return nil
 }
 
 // versionInfo displays the build and versioning information.
@@ -159,6 +353,12 @@ func initConfig() {
 
 	config.AutomaticEnv() // read in environment variables that match
 
+	// environment variables for options also given by flags.
+	config.BindEnv("EtcdEndpoints", "ROMANA_ETCD_ENDPOINTS")
+	config.BindEnv("Timeout", "ROMANA_TIMEOUT")
+	config.BindEnv("Retries", "ROMANA_RETRIES")
+	config.BindEnv("Token", "ROMANA_TOKEN")
+
 	// If a config file is found, read it in.
 	err := config.ReadInConfig()
 	if err != nil {
@@ -225,3 +425,40 @@ func JSONFormat(b []byte, w io.Writer) {
 	out.Write([]byte("\n"))
 	out.WriteTo(w)
 }
+
+// responseError returns error of unsuccessful response,
+// nil if response is successful.
+func responseError(resp *resty.Response) error {
+	if resp.StatusCode() >= 200 && resp.StatusCode() < 300 {
+		return nil
+	}
+
+	return common.ParseHttpError(resp.StatusCode(), resp.Body())
+}
+
+// confirm asks user to confirm destructive operation described by
+// format unless --yes was given, util.ErrAborted is returned if the
+// operation is not confirmed. When standard input is not a terminal,
+// operations are only confirmed by --yes.
+func confirm(format string, args ...interface{}) error {
+	if assumeYes {
+		return nil
+	}
+
+	question := fmt.Sprintf(format, args...)
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		fmt.Fprintf(os.Stderr, "%s Use --yes to confirm.\n", question)
+		return util.ErrAborted
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return util.ErrAborted
+}
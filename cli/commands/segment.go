@@ -0,0 +1,196 @@
+// Copyright (c) 2016 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common/api"
+
+	"github.com/go-resty/resty"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+)
+
+// segmentForce is used for segment delete flag.
+var segmentForce bool
+
+// segmentCmd represents the segment commands
+var segmentCmd = &cli.Command{
+	Use:   "segment [create|list|delete]",
+	Short: "Create, Delete or List segments of a tenant.",
+	Long: `Create, Delete or List segments of a tenant.
+
+Segments don't have to be created to get addresses allocated,
+segments that have blocks are listed as well.
+
+segment requires a subcommand, e.g. ` + "`romana segment create`." + `
+
+For more information, please check http://romana.io
+`,
+}
+
+func init() {
+	segmentDeleteCmd.Flags().BoolVar(&segmentForce, "force", false,
+		"delete the segment and deallocate its addresses, implies --yes")
+
+	segmentCmd.AddCommand(segmentCreateCmd)
+	segmentCmd.AddCommand(segmentListCmd)
+	segmentCmd.AddCommand(segmentDeleteCmd)
+}
+
+var segmentCreateCmd = &cli.Command{
+	Use:               "create [tenant name][segment name]",
+	Aliases:           []string{"add"},
+	Short:             "Create a new segment of a tenant.",
+	Long:              `Create a new segment of a tenant.`,
+	RunE:              segmentCreate,
+	ValidArgsFunction: completeSegmentArgs,
+	SilenceUsage:      true,
+}
+
+var segmentListCmd = &cli.Command{
+	Use:   "list [tenant name 1][tenant name 2]...",
+	Short: "List segments of tenants.",
+	Long: `List segments of tenants, along with blocks and policies
+that reference them.`,
+	RunE:              segmentList,
+	ValidArgsFunction: completeTenants,
+	SilenceUsage:      true,
+}
+
+var segmentDeleteCmd = &cli.Command{
+	Use:     "delete [tenant name][segment name]",
+	Aliases: []string{"remove"},
+	Short:   "Delete a segment of a tenant.",
+	Long: `Delete a segment of a tenant.
+
+Segment that has addresses allocated is only deleted with
+--force, its addresses are deallocated and its blocks released.`,
+	RunE:              segmentDelete,
+	ValidArgsFunction: completeSegmentArgs,
+	SilenceUsage:      true,
+}
+
+func segmentCreate(cmd *cli.Command, args []string) error {
+	if len(args) != 2 {
+		return util.UsageError(cmd, "TENANT NAME and SEGMENT NAME expected.")
+	}
+
+	segment := api.SegmentDefinition{Tenant: args[0], Name: args[1]}
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(segment).Post(segmentsURL(rootURL, segment.Tenant))
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Segment %s of tenant %s created successfully.",
+		segment.Name, segment.Tenant))
+}
+
+func segmentList(cmd *cli.Command, args []string) error {
+	if len(args) == 0 {
+		return util.UsageError(cmd, "At least one TENANT NAME expected.")
+	}
+
+	listed := []api.SegmentResponse{}
+	for _, tenant := range args {
+		segments, err := getSegments(tenant)
+		if err != nil {
+			return err
+		}
+		listed = append(listed, segments...)
+	}
+
+	return printObject(listed, func(w io.Writer, wide bool) {
+		printTitle(w, "Segment List")
+		fmt.Fprint(w, "Tenant\tSegment\tCreated\tAddresses\tPolicies")
+		if wide {
+			fmt.Fprint(w, "\tBlocks")
+		}
+		fmt.Fprint(w, "\n")
+		for _, segment := range listed {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%d\t%s",
+				segment.Tenant,
+				segment.Name,
+				segment.Defined,
+				segment.Addresses,
+				strings.Join(segment.Policies, ","),
+			)
+			if wide {
+				fmt.Fprintf(w, "\t%s", strings.Join(segment.Blocks, ","))
+			}
+			fmt.Fprint(w, "\n")
+		}
+	})
+}
+
+func segmentDelete(cmd *cli.Command, args []string) error {
+	if len(args) != 2 {
+		return util.UsageError(cmd, "TENANT NAME and SEGMENT NAME expected.")
+	}
+	if !segmentForce {
+		if err := confirm("Delete segment %s of tenant %s?", args[1], args[0]); err != nil {
+			return err
+		}
+	}
+
+	rootURL := config.GetString("RootURL")
+	deleteURL := segmentsURL(rootURL, args[0]) + "/" + url.PathEscape(args[1])
+	if segmentForce {
+		deleteURL += "?force=true"
+	}
+	resp, err := resty.R().Delete(deleteURL)
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Segment %s of tenant %s deleted successfully.", args[1], args[0]))
+}
+
+// getSegments returns segments of the tenant sorted by name.
+func getSegments(tenant string) ([]api.SegmentResponse, error) {
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(segmentsURL(rootURL, tenant))
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+
+	var segments []api.SegmentResponse
+	if err := json.Unmarshal(resp.Body(), &segments); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+func segmentsURL(rootURL string, tenant string) string {
+	return rootURL + "/tenants/" + url.PathEscape(tenant) + "/segments"
+}
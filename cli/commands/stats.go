@@ -0,0 +1,110 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common/api"
+
+	"github.com/go-resty/resty"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+)
+
+var statsCmd = &cli.Command{
+	Use:   "stats",
+	Short: "Show summary of the cluster.",
+	Long: `Show summary of the cluster.
+
+Summary has numbers of hosts, endpoints and policies, utilization
+of networks, endpoints and policies of tenants, and convergence of
+agents, i.e. how many revisions of blocks each live agent is behind
+in programming routes and when it last caught up. Use -o json to
+feed dashboards.
+`,
+	RunE:         statsRun,
+	SilenceUsage: true,
+}
+
+func statsRun(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return util.UsageError(cmd, "stats takes no arguments.")
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/stats")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	var stats api.ClusterStats
+	if err := json.Unmarshal(resp.Body(), &stats); err != nil {
+		return err
+	}
+
+	return printObject(stats, func(w io.Writer, wide bool) {
+		printTitle(w, "Cluster Summary")
+		fmt.Fprintf(w, "Hosts:\t%d (%d live)\n", stats.Hosts, stats.LiveHosts)
+		fmt.Fprintf(w, "Endpoints:\t%d\n", stats.Endpoints)
+		fmt.Fprintf(w, "Policies:\t%d\n", stats.Policies)
+		fmt.Fprintf(w, "Blocks Revision:\t%d\n", stats.BlocksRevision)
+		fmt.Fprint(w, "\n")
+
+		printTitle(w, "Networks")
+		fmt.Fprint(w, "Name\tCIDR\tBlocks\tAllocated\tUtilization\n")
+		for _, n := range stats.Networks {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.2f%%\n", n.Name, n.CIDR, n.Blocks, n.Allocated, n.Utilization)
+		}
+		fmt.Fprint(w, "\n")
+
+		printTitle(w, "Tenants")
+		fmt.Fprint(w, "Name\tEndpoints\tPolicies\n")
+		for _, t := range stats.Tenants {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", t.Name, t.Endpoints, t.Policies)
+		}
+		fmt.Fprint(w, "\n")
+
+		printTitle(w, "Agents")
+		fmt.Fprint(w, "Host\tBlocks Revision\tLag\tConverged")
+		if wide {
+			fmt.Fprint(w, "\tVersion\tRenewed")
+		}
+		fmt.Fprint(w, "\n")
+		for _, a := range stats.Agents {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s", a.Host, a.BlocksRevision, a.Lag, sinceString(a.Converged))
+			if wide {
+				fmt.Fprintf(w, "\t%s\t%s", a.Version, sinceString(a.Renewed))
+			}
+			fmt.Fprint(w, "\n")
+		}
+	})
+}
+
+// sinceString returns how long ago t was, "never" for zero t.
+func sinceString(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
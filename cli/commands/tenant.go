@@ -0,0 +1,257 @@
+// Copyright (c) 2016 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common/api"
+
+	"github.com/go-resty/resty"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+)
+
+// Variables used for tenant flags.
+var (
+	tenantExternalID   string
+	tenantMaxAddresses int
+	tenantDefaultDeny  bool
+	tenantForce        bool
+)
+
+// tenantCmd represents the tenant commands
+var tenantCmd = &cli.Command{
+	Use:   "tenant [create|show|list|delete]",
+	Short: "Create, Delete, Show or List Tenant Details.",
+	Long: `Create, Delete, Show or List Tenant Details.
+
+Tenants don't have to be created to get addresses allocated,
+but address quota and default-deny only apply to created tenants.
+
+tenant requires a subcommand, e.g. ` + "`romana tenant create`." + `
+
+For more information, please check http://romana.io
+`,
+}
+
+func init() {
+	tenantCreateCmd.Flags().StringVar(&tenantExternalID, "external-id", "",
+		"ID of the tenant in the platform, e.g. OpenStack project ID")
+	tenantCreateCmd.Flags().IntVar(&tenantMaxAddresses, "max-addresses", 0,
+		"maximum number of addresses allocated to the tenant, 0 means no limit")
+	tenantCreateCmd.Flags().BoolVar(&tenantDefaultDeny, "default-deny", false,
+		"deny traffic to endpoints of the tenant unless allowed by policies")
+
+	tenantDeleteCmd.Flags().BoolVar(&tenantForce, "force", false,
+		"delete the tenant even if it has addresses allocated, implies --yes")
+
+	tenantCmd.AddCommand(tenantCreateCmd)
+	tenantCmd.AddCommand(tenantShowCmd)
+	tenantCmd.AddCommand(tenantListCmd)
+	tenantCmd.AddCommand(tenantDeleteCmd)
+}
+
+var tenantCreateCmd = &cli.Command{
+	Use:          "create [tenant name]",
+	Short:        "Create a new tenant.",
+	Long:         `Create a new tenant.`,
+	RunE:         tenantCreate,
+	SilenceUsage: true,
+}
+
+var tenantShowCmd = &cli.Command{
+	Use:               "show [tenant name 1][tenant name 2]...",
+	Short:             "Show details for a specific tenant.",
+	Long:              `Show details for a specific tenant.`,
+	RunE:              tenantShow,
+	ValidArgsFunction: completeTenants,
+	SilenceUsage:      true,
+}
+
+var tenantListCmd = &cli.Command{
+	Use:          "list",
+	Short:        "List all tenants.",
+	Long:         `List all tenants, including those that were not created but have addresses allocated.`,
+	RunE:         tenantList,
+	SilenceUsage: true,
+}
+
+var tenantDeleteCmd = &cli.Command{
+	Use:   "delete [tenant name]",
+	Short: "Delete a tenant.",
+	Long: `Delete a tenant.
+
+Tenant that has addresses allocated is only deleted with
+--force, its addresses stay allocated.`,
+	RunE:              tenantDelete,
+	ValidArgsFunction: completeTenants,
+	SilenceUsage:      true,
+}
+
+func tenantCreate(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "TENANT NAME expected.")
+	}
+	if tenantMaxAddresses < 0 {
+		return util.UsageError(cmd, "Invalid --max-addresses %d.", tenantMaxAddresses)
+	}
+
+	tenant := api.TenantDefinition{
+		Name:         args[0],
+		ExternalID:   tenantExternalID,
+		DefaultDeny:  tenantDefaultDeny,
+		MaxAddresses: tenantMaxAddresses,
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(tenant).Post(rootURL + "/tenants")
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Tenant %s created successfully.", tenant.Name))
+}
+
+func tenantShow(cmd *cli.Command, args []string) error {
+	if len(args) == 0 {
+		return util.UsageError(cmd, "At least one TENANT NAME expected.")
+	}
+
+	rootURL := config.GetString("RootURL")
+	shown := []api.TenantResponse{}
+	for _, name := range args {
+		resp, err := resty.R().Get(rootURL + "/tenants/" + url.PathEscape(name))
+		if err != nil {
+			return err
+		}
+		if err := responseError(resp); err != nil {
+			return err
+		}
+
+		var tenant api.TenantResponse
+		if err := json.Unmarshal(resp.Body(), &tenant); err != nil {
+			return err
+		}
+		shown = append(shown, tenant)
+	}
+
+	return printObject(shown, func(w io.Writer, wide bool) {
+		printTitle(w, "Tenant Details")
+		for _, tenant := range shown {
+			fmt.Fprintf(w, "Tenant Name:\t%s\n", tenant.Name)
+			fmt.Fprintf(w, "External ID:\t%s\n", tenant.ExternalID)
+			fmt.Fprintf(w, "Created:\t%t\n", tenant.Defined)
+			fmt.Fprintf(w, "Default Deny:\t%t\n", tenant.DefaultDeny)
+			fmt.Fprintf(w, "Addresses:\t%s\n", tenantAddressesString(tenant))
+			fmt.Fprintf(w, "Blocks:\t%d\n", tenant.Blocks)
+			fmt.Fprint(w, "\n")
+		}
+	})
+}
+
+func tenantList(cmd *cli.Command, args []string) error {
+	if len(args) > 0 {
+		return util.UsageError(cmd, "Tenant listing takes no arguments.")
+	}
+
+	tenants, err := getTenants()
+	if err != nil {
+		return err
+	}
+
+	return printObject(tenants, func(w io.Writer, wide bool) {
+		printTitle(w, "Tenant List")
+		fmt.Fprint(w, "Tenant Name\tCreated\tDefault Deny\tAddresses")
+		if wide {
+			fmt.Fprint(w, "\tBlocks\tExternal ID")
+		}
+		fmt.Fprint(w, "\n")
+		for _, tenant := range tenants {
+			fmt.Fprintf(w, "%s\t%t\t%t\t%s",
+				tenant.Name,
+				tenant.Defined,
+				tenant.DefaultDeny,
+				tenantAddressesString(tenant),
+			)
+			if wide {
+				fmt.Fprintf(w, "\t%d\t%s", tenant.Blocks, tenant.ExternalID)
+			}
+			fmt.Fprint(w, "\n")
+		}
+	})
+}
+
+func tenantDelete(cmd *cli.Command, args []string) error {
+	if len(args) != 1 {
+		return util.UsageError(cmd, "TENANT NAME expected.")
+	}
+	if !tenantForce {
+		if err := confirm("Delete tenant %s?", args[0]); err != nil {
+			return err
+		}
+	}
+
+	rootURL := config.GetString("RootURL")
+	deleteURL := rootURL + "/tenants/" + url.PathEscape(args[0])
+	if tenantForce {
+		deleteURL += "?force=true"
+	}
+	resp, err := resty.R().Delete(deleteURL)
+	if err != nil {
+		return err
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printResponse(resp, fmt.Sprintf("Tenant %s deleted successfully.", args[0]))
+}
+
+// getTenants returns tenants sorted by name.
+func getTenants() ([]api.TenantResponse, error) {
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/tenants")
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		return nil, err
+	}
+
+	var tenants []api.TenantResponse
+	if err := json.Unmarshal(resp.Body(), &tenants); err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+// tenantAddressesString returns addresses allocated to the tenant
+// along with its quota.
+func tenantAddressesString(tenant api.TenantResponse) string {
+	if tenant.MaxAddresses == 0 {
+		return fmt.Sprintf("%d", tenant.Addresses)
+	}
+	return fmt.Sprintf("%d/%d", tenant.Addresses, tenant.MaxAddresses)
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+
+	config "github.com/spf13/viper"
+)
+
+// captureStdout returns what run writes to stdout.
+func captureStdout(t *testing.T, run func() error) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = run()
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestTenantDefaultDeny(t *testing.T) {
+	tenants := map[string]api.TenantDefinition{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/"+common.APIVersion+"/tenants":
+			var tenant api.TenantDefinition
+			if err := json.NewDecoder(r.Body).Decode(&tenant); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			tenants[tenant.Name] = tenant
+			json.NewEncoder(w).Encode(tenant)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/"+common.APIVersion+"/tenants/"):
+			tenant, ok := tenants[strings.TrimPrefix(r.URL.Path, "/"+common.APIVersion+"/tenants/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(api.TenantResponse{TenantDefinition: tenant, Defined: true})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	config.Set("RootURL", srv.URL+"/"+common.APIVersion)
+	config.Set("Format", outputTable)
+
+	if err := tenantCreateCmd.ParseFlags([]string{"--default-deny", "--max-addresses", "10"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		tenantDefaultDeny = false
+		tenantMaxAddresses = 0
+	}()
+	captureStdout(t, func() error {
+		return tenantCreate(tenantCreateCmd, []string{"ten1"})
+	})
+	expect := api.TenantDefinition{Name: "ten1", DefaultDeny: true, MaxAddresses: 10}
+	if tenants["ten1"] != expect {
+		t.Fatalf("expected tenant %+v to be created, got %+v", expect, tenants["ten1"])
+	}
+
+	out := captureStdout(t, func() error {
+		return tenantShow(tenantShowCmd, []string{"ten1"})
+	})
+	if !regexp.MustCompile(`Default Deny:\s+true\n`).MatchString(out) {
+		t.Fatalf("expected tenant to be shown default deny, got\n%s", out)
+	}
+
+	config.Set("Format", outputJSON)
+	defer config.Set("Format", outputTable)
+	out = captureStdout(t, func() error {
+		return tenantShow(tenantShowCmd, []string{"ten1"})
+	})
+	var shown []api.TenantResponse
+	if err := json.Unmarshal([]byte(out), &shown); err != nil {
+		t.Fatal(err)
+	}
+	if len(shown) != 1 || !shown[0].DefaultDeny {
+		t.Fatalf("expected tenant to be shown default deny, got %+v", shown)
+	}
+}
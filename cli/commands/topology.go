@@ -18,39 +18,81 @@ package commands
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"text/tabwriter"
+	"strings"
 
 	"github.com/romana/core/cli/util"
-	"github.com/romana/core/common"
 	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/client"
 
 	"github.com/go-resty/resty"
-	ms "github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
 	cli "github.com/spf13/cobra"
 	config "github.com/spf13/viper"
 )
 
 // topologyCmd represents the topology commands
 var topologyCmd = &cli.Command{
-	Use:   "topology [update|list]",
-	Short: "Update or List topology for romana services.",
-	Long: `Update or List topology for romana services.
+	Use:   "topology [show|list|diff|apply|update]",
+	Short: "Show, diff, apply or List topology for romana services.",
+	Long: `Show, diff, apply or List topology for romana services.
 
-topology requires a subcommand, e.g. ` + "`romana topology list`." + `
+topology requires a subcommand, e.g. ` + "`romana topology show`." + `
 
 For more information, please check http://docs.romana.io
 `,
 }
 
 func init() {
+	topologyCmd.AddCommand(topologyShowCmd)
 	topologyCmd.AddCommand(topologyListCmd)
+	topologyCmd.AddCommand(topologyDiffCmd)
+	topologyCmd.AddCommand(topologyApplyCmd)
 	topologyCmd.AddCommand(topologyUpdateCmd)
 }
 
+var topologyShowCmd = &cli.Command{
+	Use:   "show",
+	Short: "Show effective romana topology.",
+	Long: `Show effective romana topology.
+
+Networks are shown with their group trees, including the CIDRs
+generated for the groups and the hosts assigned to them.`,
+	RunE:         topologyShow,
+	SilenceUsage: true,
+}
+
+var topologyDiffCmd = &cli.Command{
+	Use:   "diff [file name]",
+	Short: "Show changes topology update would make.",
+	Long: `Show changes topology update would make.
+
+Topology is validated and the changes to networks and hosts,
+including the CIDRs generated for hosts' groups, are shown
+without applying them. Allocated addresses that don't fit the
+topology are shown as impacted, update fails unless they are
+deallocated first. Use -o wide to also show the groups hosts
+are placed in.`,
+	RunE:         topologyDiff,
+	SilenceUsage: true,
+}
+
+var topologyApplyCmd = &cli.Command{
+	Use:   "apply [file name]",
+	Short: "Validate and apply romana topology.",
+	Long: `Validate and apply romana topology.
+
+Topology is validated before it is sent to romana services, and
+is not applied if allocated addresses don't fit it, use
+'romana topology diff' to see the changes it makes.`,
+	RunE:         topologyApply,
+	SilenceUsage: true,
+}
+
 var topologyListCmd = &cli.Command{
 	Use:          "list",
 	Short:        "List romana topology.",
@@ -68,59 +110,35 @@ var topologyUpdateCmd = &cli.Command{
 }
 
 func topologyList(cmd *cli.Command, args []string) error {
-	rootURL := config.GetString("RootURL")
-	resp, err := resty.R().Get(rootURL + "/topology")
+	topology, err := getTopology()
 	if err != nil {
 		return err
 	}
 
-	if config.GetString("Format") == "json" {
-		JSONFormat(resp.Body(), os.Stdout)
-	} else {
-		w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
-
-		if resp.StatusCode() == http.StatusOK {
-			var topology api.TopologyUpdateRequest
-			err := json.Unmarshal(resp.Body(), &topology)
-			if err == nil {
-				fmt.Println("Networks")
-				fmt.Fprint(w, "Name\tCIDR\tTenants\n")
-				for _, n := range topology.Networks {
-					fmt.Fprintf(w, "%s\t%s\t%v\n",
-						n.Name,
-						n.CIDR,
-						n.Tenants,
-					)
+	return printObject(topology, func(w io.Writer, wide bool) {
+		printTitle(w, "Networks")
+		fmt.Fprint(w, "Name\tCIDR\tTenants\n")
+		for _, n := range topology.Networks {
+			fmt.Fprintf(w, "%s\t%s\t%v\n",
+				n.Name,
+				n.CIDR,
+				n.Tenants,
+			)
+		}
+		fmt.Fprint(w, "\n")
+		for _, t := range topology.Topologies {
+			fmt.Fprintf(w, "Topology for Network/s: %s\n", t.Networks)
+			fmt.Fprint(w, "Name\tCIDR\tNodes\n")
+			for _, m := range t.Map {
+				fmt.Fprintf(w, "%s\t%s\t", m.Name, m.CIDR)
+				for _, n := range m.Groups {
+					fmt.Fprintf(w, "%s(%s), ", n.Name, n.IP)
 				}
 				fmt.Fprint(w, "\n")
-				for _, t := range topology.Topologies {
-					fmt.Printf("Topology for Network/s: %s\n", t.Networks)
-					fmt.Fprint(w, "Name\tCIDR\tNodes\n")
-					for _, m := range t.Map {
-						fmt.Fprintf(w, "%s\t%s\t", m.Name, m.CIDR)
-						for _, n := range m.Groups {
-							fmt.Fprintf(w, "%s(%s), ", n.Name, n.IP)
-						}
-						fmt.Fprint(w, "\n")
-					}
-					fmt.Fprint(w, "\n")
-				}
-			} else {
-				fmt.Printf("Error: %s \n", err)
 			}
-		} else {
-			var e Error
-			json.Unmarshal(resp.Body(), &e)
-
-			fmt.Println("Host Error")
-			fmt.Fprintf(w, "Fields\t%s\n", e.Fields)
-			fmt.Fprintf(w, "Message\t%s\n", e.Message)
-			fmt.Fprintf(w, "Status\t%d\n", resp.StatusCode())
+			fmt.Fprint(w, "\n")
 		}
-		w.Flush()
-	}
-
-	return nil
+	})
 }
 
 // topologyUpdate updates romana topology.
@@ -170,33 +188,267 @@ func topologyUpdate(cmd *cli.Command, args []string) error {
 		return err
 	}
 
-	if config.GetString("Format") == "json" {
-		if string(resp.Body()) == "" || string(resp.Body()) == "null" {
-			var h common.HttpError
-			dc := &ms.DecoderConfig{TagName: "json", Result: &h}
-			decoder, err := ms.NewDecoder(dc)
-			if err != nil {
-				return err
+	if resp.StatusCode() == http.StatusConflict {
+		return errors.Wrapf(util.ErrConflict, "topology was updated since revision %d, "+
+			"get it again with 'romana topology list' and retry", topology.Revision)
+	}
+
+	if err := responseError(resp); err != nil {
+		return errors.Wrap(err, "error updating topology")
+	}
+
+	return printResponse(resp, "Topology updated successfully.")
+}
+
+// topologyDiffResult is what 'romana topology diff' shows.
+type topologyDiffResult struct {
+	Changes []api.TopologyChange `json:"changes"`
+	// Conflicts are allocated addresses impacted by the update.
+	Conflicts []api.AddressConflict `json:"conflicts,omitempty"`
+	// Hosts are groups hosts are placed in by the update.
+	Hosts []api.HostPlacement `json:"hosts,omitempty"`
+}
+
+// topologyShow shows networks of the topology and their group
+// trees with CIDRs generated for groups.
+func topologyShow(cmd *cli.Command, args []string) error {
+	topology, err := getTopology()
+	if err != nil {
+		return err
+	}
+
+	return printObject(topology, func(w io.Writer, wide bool) {
+		fmt.Fprintf(w, "Revision: %d\n\n", topology.Revision)
+		fmt.Fprint(w, "Network\tCIDR\tBlock Mask\tTenants")
+		if wide {
+			fmt.Fprint(w, "\tInterface\tMTU\tRoute Table")
+		}
+		fmt.Fprint(w, "\n")
+		for _, n := range topology.Networks {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s", n.Name, n.CIDR, n.BlockMask, strings.Join(n.Tenants, ","))
+			if wide {
+				fmt.Fprintf(w, "\t%s\t%d\t%d", n.Interface, n.MTU, n.RouteTable)
+			}
+			fmt.Fprint(w, "\n")
+		}
+		for _, t := range topology.Topologies {
+			fmt.Fprintf(w, "\nTopology for Network/s: %s\n", strings.Join(uniqueStrings(t.Networks), ","))
+			fmt.Fprint(w, "Group or Host\tCIDR\tIP\tAssignment\n")
+			showGroups(w, t.Map, 0)
+		}
+	})
+}
+
+// showGroups writes group tree, indenting subgroups and hosts.
+func showGroups(w io.Writer, groups []api.GroupOrHost, depth int) {
+	for _, g := range groups {
+		name := g.Name
+		if name == "" {
+			name = "-"
+		}
+		var ip string
+		if g.IP != nil {
+			ip = g.IP.String()
+		}
+		// hosts show their tags in place of assignment.
+		tags := g.Assignment
+		if g.IP != nil {
+			tags = g.Tags
+		}
+		var assignment []string
+		for _, key := range sortedTagKeys(tags) {
+			assignment = append(assignment, key+"="+tags[key])
+		}
+		fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\n",
+			strings.Repeat("  ", depth), name, g.CIDR, ip, strings.Join(assignment, ","))
+		showGroups(w, g.Groups, depth+1)
+	}
+}
+
+// topologyDiff shows changes topology update would make
+// and addresses impacted by it.
+func topologyDiff(cmd *cli.Command, args []string) error {
+	req, err := readTopologyRequest(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetQueryParam("dry_run", "true").SetBody(req).Post(rootURL + "/topology")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() == http.StatusConflict {
+		return errors.Wrapf(util.ErrConflict, "topology was updated since revision %d, "+
+			"get it again with 'romana topology show' and retry", req.Revision)
+	}
+	if err := responseError(resp); err != nil {
+		return errors.Wrap(err, "error planning topology update")
+	}
+
+	var plan api.TopologyPlan
+	err = json.Unmarshal(resp.Body(), &plan)
+	if err != nil {
+		return err
+	}
+	diff := topologyDiffResult{
+		Changes:   plan.Changes,
+		Conflicts: plan.Conflicts,
+		Hosts:     plan.Hosts,
+	}
+	if diff.Changes == nil {
+		diff.Changes = []api.TopologyChange{}
+	}
+
+	return printObject(diff, func(w io.Writer, wide bool) {
+		if len(diff.Changes) == 0 && len(diff.Conflicts) == 0 {
+			fmt.Fprintln(w, "No changes.")
+			return
+		}
+		if len(diff.Changes) > 0 {
+			printTitle(w, "Changes")
+			fmt.Fprint(w, "Change\tNetwork\tHost\tField\tOld\tNew\n")
+			for _, c := range diff.Changes {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					c.Change, c.Network, c.Host, c.Field, c.Old, c.New)
 			}
-			m := make(map[string]interface{})
-			m["details"] = resp.Status()
-			m["status_code"] = resp.StatusCode()
-			err = decoder.Decode(m)
-			if err != nil {
-				return err
+		}
+		if len(diff.Conflicts) > 0 {
+			if len(diff.Changes) > 0 {
+				fmt.Fprintln(w)
 			}
-			status, _ := json.MarshalIndent(h, "", "\t")
-			fmt.Println(string(status))
-		} else {
-			JSONFormat(resp.Body(), os.Stdout)
+			showConflicts(w, diff.Conflicts)
 		}
-	} else {
-		if resp.StatusCode() == http.StatusOK {
-			fmt.Println("Topology updated successfully.")
-		} else {
-			fmt.Printf("Error upadting topology: %s\n", resp.Status())
+		if wide && len(diff.Hosts) > 0 {
+			fmt.Fprintln(w)
+			printTitle(w, "Host Groups")
+			fmt.Fprint(w, "Network\tHost\tIP\tGroup\tGroup CIDR\n")
+			for _, h := range diff.Hosts {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", h.Network, h.Host, h.IP, h.Group, h.GroupCIDR)
+			}
+		}
+	})
+}
+
+// topologyApply validates topology and updates it
+// unless allocated addresses don't fit it.
+func topologyApply(cmd *cli.Command, args []string) error {
+	req, err := readTopologyRequest(cmd, args)
+	if err != nil {
+		return err
+	}
+	plan, err := planTopology(req)
+	if err != nil {
+		return err
+	}
+	if len(plan.Conflicts) > 0 {
+		err := printObject(plan.Conflicts, func(w io.Writer, wide bool) {
+			showConflicts(w, plan.Conflicts)
+		})
+		if err != nil {
+			return err
 		}
+		return fmt.Errorf("%d allocated addresses don't fit the topology, "+
+			"deallocate them or change the topology", len(plan.Conflicts))
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().SetHeader("Content-Type", "application/json").
+		SetBody(req).Post(rootURL + "/topology")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() == http.StatusConflict {
+		return errors.Wrapf(util.ErrConflict, "topology was updated since revision %d, "+
+			"get it again with 'romana topology show' and retry", req.Revision)
+	}
+	if err := responseError(resp); err != nil {
+		return err
+	}
+
+	return printObject(plan.Topology, func(w io.Writer, wide bool) {
+		fmt.Fprintln(w, "Topology applied successfully.")
+	})
+}
+
+// showConflicts writes table of addresses impacted by topology update.
+func showConflicts(w io.Writer, conflicts []api.AddressConflict) {
+	printTitle(w, "Impacted Allocations")
+	fmt.Fprint(w, "Name\tIP\tHost\tTenant\tSegment\tError\n")
+	for _, c := range conflicts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			c.Address.Name,
+			c.Address.IP,
+			c.Address.Host,
+			c.Address.Tenant,
+			c.Address.Segment,
+			c.Error,
+		)
+	}
+}
+
+// readTopologyRequest reads topology from the file named by args,
+// or from STDIN if there is none.
+func readTopologyRequest(cmd *cli.Command, args []string) (api.TopologyUpdateRequest, error) {
+	var topology api.TopologyUpdateRequest
+	var buf []byte
+	var err error
+	switch len(args) {
+	case 0:
+		buf, err = ioutil.ReadAll(os.Stdin)
+	case 1:
+		buf, err = ioutil.ReadFile(args[0])
+	default:
+		return topology, util.UsageError(cmd,
+			"TOPOLOGY FILE name or piped input from 'STDIN' expected.")
+	}
+	if err != nil {
+		return topology, err
+	}
+
+	err = json.Unmarshal(buf, &topology)
+	return topology, err
+}
+
+// getTopology returns current topology.
+func getTopology() (*api.TopologyUpdateRequest, error) {
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/topology")
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		return nil, err
 	}
 
-	return nil
+	var topology api.TopologyUpdateRequest
+	err = json.Unmarshal(resp.Body(), &topology)
+	return &topology, err
+}
+
+// planTopology validates topology and computes its groups
+// and conflicts with currently allocated addresses.
+func planTopology(req api.TopologyUpdateRequest) (*api.TopologyPlan, error) {
+	addresses, err := getAddresses()
+	if err != nil {
+		return nil, err
+	}
+	plan, err := client.PlanTopology(req, addresses)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topology: %s", err)
+	}
+	return plan, nil
+}
+
+func uniqueStrings(values []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
 }
@@ -0,0 +1,235 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/romana/core/cli/util"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/client"
+	"github.com/romana/core/pkg/policytools"
+
+	cli "github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// Kinds of files validated.
+const (
+	validateKindTopology = "topology"
+	validateKindPolicy   = "policy"
+)
+
+// validateResult is result of validating a topology
+// or a policy read from a file.
+type validateResult struct {
+	Source string `json:"source"`
+	Kind   string `json:"kind"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Variables used for validate flags.
+var (
+	validateFiles     []string
+	validateRecursive bool
+)
+
+var validateCmd = &cli.Command{
+	Use:   "validate -f [file|directory|-]",
+	Short: "Validate topology and policy files without romana services.",
+	Long: `Validate topology and policy files without romana services.
+
+Files are validated the way romanad validates topology and
+policies it's given, so that changes can be checked e.g. in CI
+before they are applied. Topology is checked for invalid and
+overlapping CIDRs and for groups that can't get address prefixes,
+policies are checked for invalid combinations of targets, peers
+and rules, invalid CIDRs and segments without tenants, and for
+missing and duplicate IDs.
+
+Files that have "networks" or "topologies" are topologies, others
+are policies in the format of 'romana policy apply'. Command fails
+if any problem is found.
+`,
+	RunE:         validateRun,
+	SilenceUsage: true,
+}
+
+func init() {
+	validateCmd.Flags().StringSliceVarP(&validateFiles, "filename", "f", nil,
+		"file, directory or - for STDIN to validate, can be repeated")
+	validateCmd.Flags().BoolVarP(&validateRecursive, "recursive", "R", false,
+		"validate files in subdirectories of directories given by --filename")
+}
+
+func validateRun(cmd *cli.Command, args []string) error {
+	if len(validateFiles) == 0 || len(args) > 0 {
+		return util.UsageError(cmd,
+			"FILE, directory or - for 'STDIN' expected in --filename.")
+	}
+
+	var results []validateResult
+	var policies []policySource
+	for _, path := range validateFiles {
+		if path == "-" {
+			buf, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("cannot read 'STDIN': %s", err)
+			}
+			r, p := validateFile("STDIN", buf, "")
+			results = append(results, r...)
+			policies = append(policies, p...)
+			continue
+		}
+
+		files, err := policyFilesIn(path, validateRecursive)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			buf, err := ioutil.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("file error: %s", err)
+			}
+			r, p := validateFile(file, buf, filepath.Ext(file))
+			results = append(results, r...)
+			policies = append(policies, p...)
+		}
+	}
+
+	for _, problem := range checkPolicySources(policies) {
+		results = append(results, validateResult{Kind: validateKindPolicy, Error: problem})
+	}
+
+	var problems int
+	for _, r := range results {
+		if r.Error != "" {
+			problems++
+		}
+	}
+
+	err := printObject(results, func(w io.Writer, wide bool) {
+		printTitle(w, "Validation Results")
+		fmt.Fprint(w, "Source\tKind\tID\tResult\n")
+		for _, r := range results {
+			result := "valid"
+			if r.Error != "" {
+				result = r.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Source, r.Kind, r.ID, result)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+	return nil
+}
+
+// validateFile validates topology or policies in the file, policies
+// are returned to check IDs across files.
+func validateFile(source string, buf []byte, ext string) ([]validateResult, []policySource) {
+	invalid := func(kind string, err error) []validateResult {
+		return []validateResult{{Source: source, Kind: kind, Error: err.Error()}}
+	}
+
+	// YAML is a superset of JSON, so both are decoded as YAML
+	// first to tell topology from policies.
+	var value interface{}
+	if err := yaml.Unmarshal(buf, &value); err == nil {
+		if m, ok := jsonCompatible(value).(map[string]interface{}); ok {
+			_, hasNetworks := m["networks"]
+			_, hasTopologies := m["topologies"]
+			if hasNetworks || hasTopologies {
+				b, err := json.Marshal(m)
+				if err == nil {
+					err = validateTopology(b)
+				}
+				if err != nil {
+					return invalid(validateKindTopology, err), nil
+				}
+				return []validateResult{{Source: source, Kind: validateKindTopology}}, nil
+			}
+		}
+	}
+
+	decoded, err := decodePolicies(buf, ext)
+	if err != nil {
+		return invalid(validateKindPolicy, err), nil
+	}
+
+	var results []validateResult
+	var policies []policySource
+	for _, p := range decoded {
+		r := validateResult{Source: source, Kind: validateKindPolicy, ID: p.ID}
+		if err := validatePolicy(p); err != nil {
+			r.Error = err.Error()
+		}
+		results = append(results, r)
+		policies = append(policies, policySource{Source: source, Policy: p})
+	}
+	return results, policies
+}
+
+// validateTopology validates topology the way romanad does when
+// it's applied, as if no addresses were allocated.
+func validateTopology(buf []byte) error {
+	var req api.TopologyUpdateRequest
+	if err := json.Unmarshal(buf, &req); err != nil {
+		return err
+	}
+	_, err := client.PlanTopology(req, nil)
+	return err
+}
+
+// validatePolicy validates policy the way romanad does, and checks
+// endpoints of the policy that romanad would accept but that can't
+// select anything.
+func validatePolicy(policy api.Policy) error {
+	if err := policytools.ValidatePolicy(policy); err != nil {
+		return err
+	}
+
+	var endpoints []api.Endpoint
+	endpoints = append(endpoints, policy.AppliedTo...)
+	for _, ingress := range policy.Ingress {
+		endpoints = append(endpoints, ingress.Peers...)
+	}
+	for _, e := range endpoints {
+		if e.Cidr != "" {
+			if _, _, err := net.ParseCIDR(e.Cidr); err != nil {
+				return fmt.Errorf("invalid cidr %s", e.Cidr)
+			}
+		}
+		if e.SegmentID != "" && e.TenantID == "" {
+			return fmt.Errorf("segment %s without tenant", e.SegmentID)
+		}
+		if strings.Contains(e.TenantID, ":") {
+			return fmt.Errorf("invalid tenant %s", e.TenantID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+
+	"github.com/go-resty/resty"
+	"github.com/pkg/errors"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+)
+
+// versionCmd shows versions of the CLI and of romana services.
+var versionCmd = &cli.Command{
+	Use:   "version",
+	Short: "Show versions of romana CLI and services.",
+	Long: `Show versions of romana CLI and services.
+
+Besides the build information of the CLI, versions of romanad
+and of agents that are alive are shown, and components whose
+version differs from romanad are reported as version skew.
+
+Use --short to show only version of the CLI.`,
+	RunE:         versionShow,
+	SilenceUsage: true,
+}
+
+var versionShort bool
+
+func init() {
+	versionCmd.Flags().BoolVarP(&versionShort, "short", "s", false, "Show only version of the CLI")
+}
+
+// versionResult is output of the version command.
+type versionResult struct {
+	Client common.VersionInfo   `json:"client"`
+	Server *api.VersionResponse `json:"server,omitempty"`
+	Skew   []string             `json:"skew,omitempty"`
+}
+
+func versionShow(cmd *cli.Command, args []string) error {
+	result := versionResult{Client: common.GetVersionInfo()}
+	if versionShort {
+		return printObject(result, func(w io.Writer, wide bool) {
+			fmt.Fprintln(w, result.Client.Version)
+		})
+	}
+
+	rootURL := config.GetString("RootURL")
+	resp, err := resty.R().Get(rootURL + "/version")
+	if err == nil {
+		err = responseError(resp)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "CLI version %s, failed to get version of romanad", result.Client.Version)
+	}
+
+	result.Server = &api.VersionResponse{}
+	if err := json.Unmarshal(resp.Body(), result.Server); err != nil {
+		return err
+	}
+	result.Skew = versionSkew(result.Client.Version, *result.Server)
+
+	return printObject(result, func(w io.Writer, wide bool) {
+		fmt.Fprintf(w, "Client Version:\t%s\n", result.Client.Version)
+		if wide {
+			fmt.Fprintf(w, "Client Git Commit:\t%s\n", result.Client.GitCommit)
+			fmt.Fprintf(w, "Client Build Time:\t%s\n", result.Client.BuildTime)
+			fmt.Fprintf(w, "Client Go Version:\t%s\n", result.Client.GoVersion)
+		}
+		fmt.Fprintf(w, "Romanad Version:\t%s\n", result.Server.Romanad.Version)
+		if wide {
+			fmt.Fprintf(w, "Romanad Git Commit:\t%s\n", result.Server.Romanad.GitCommit)
+			fmt.Fprintf(w, "Romanad Build Time:\t%s\n", result.Server.Romanad.BuildTime)
+			fmt.Fprintf(w, "Romanad Go Version:\t%s\n", result.Server.Romanad.GoVersion)
+		}
+
+		if len(result.Server.Agents) > 0 {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "Agent Host\tAgent Version")
+			for _, agent := range result.Server.Agents {
+				fmt.Fprintf(w, "%s\t%s\n", agent.Host, agent.Version)
+			}
+		}
+
+		if len(result.Skew) > 0 {
+			fmt.Fprintln(w)
+			for _, skew := range result.Skew {
+				fmt.Fprintf(w, "Version skew: %s\n", skew)
+			}
+		}
+	})
+}
+
+// versionSkew returns descriptions of components whose version
+// differs from romanad. Agents that don't report their version
+// are not considered skewed.
+func versionSkew(client string, server api.VersionResponse) []string {
+	var skew []string
+	romanad := server.Romanad.Version
+	if client != romanad {
+		skew = append(skew, fmt.Sprintf("CLI version %s, romanad version %s", client, romanad))
+	}
+	for _, agent := range server.Agents {
+		if agent.Version != "" && agent.Version != romanad {
+			skew = append(skew, fmt.Sprintf("agent on %s version %s, romanad version %s", agent.Host, agent.Version, romanad))
+		}
+	}
+	return skew
+}
@@ -17,12 +17,170 @@
 package util
 
 import (
-	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/romana/core/common"
+)
+
+// Exit codes of romana command line tools, scripts
+// can branch on them and they will not change.
+const (
+	ExitOK    = 0
+	ExitError = 1
+	// ExitUsage means command line was invalid.
+	ExitUsage = 2
+	// ExitNotFound means object given to command doesn't exist.
+	ExitNotFound = 3
+	// ExitConflict means object exists already or was
+	// modified concurrently.
+	ExitConflict = 4
+	// ExitUnavailable means romana services could not be
+	// reached or failed to serve the request.
+	ExitUnavailable = 5
+	// ExitAborted means destructive operation was not confirmed.
+	ExitAborted = 6
+	// ExitDenied means request was not authorized.
+	ExitDenied = 7
+)
+
+// Error codes of structured errors, one for each exit code
+// but ExitOK, scripts can branch on them and they will not change.
+const (
+	CodeError       = "error"
+	CodeUsage       = "usage"
+	CodeNotFound    = "not_found"
+	CodeConflict    = "conflict"
+	CodeUnavailable = "unavailable"
+	CodeAborted     = "aborted"
+	CodeDenied      = "denied"
 )
 
+var exitCodeNames = map[int]string{
+	ExitError:       CodeError,
+	ExitUsage:       CodeUsage,
+	ExitNotFound:    CodeNotFound,
+	ExitConflict:    CodeConflict,
+	ExitUnavailable: CodeUnavailable,
+	ExitAborted:     CodeAborted,
+	ExitDenied:      CodeDenied,
+}
+
 var (
 	ErrTenantNotFound        = errors.New("tenant not found")
 	ErrUnimplementedFeature  = errors.New("unimplemented feature")
 	ErrInvalidPlatform       = errors.New("invalid platform")
 	ErrUnimplementedPlatform = errors.New("unimplemented platform")
+
+	// ErrConflict means object was modified by someone else since
+	// it was read, command can be retried after reading it again.
+	ErrConflict = errors.New("object was modified concurrently")
+
+	// ErrAborted means user didn't confirm destructive operation.
+	ErrAborted = errors.New("operation aborted")
 )
+
+// usageError is an error in command line.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+// ErrorInfo is the machine-readable form of an error returned
+// by a command, written instead of the error message when output
+// is structured.
+type ErrorInfo struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	ExitCode int    `json:"exit_code"`
+	// Status is HTTP status of romana service response, if
+	// the error was returned by romana services.
+	Status int `json:"status,omitempty"`
+	// Reason is the code of the error romana services
+	// responded with, e.g. quota_exceeded.
+	Reason  string      `json:"reason,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+	// RequestID identifies the failed request in logs
+	// of romana services.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// DescribeError returns machine-readable form of the error returned
+// by a command, details of errors returned by romana services
+// are taken from their response.
+func DescribeError(err error) ErrorInfo {
+	code := ExitCode(err)
+	info := ErrorInfo{Code: exitCodeNames[code], ExitCode: code}
+	if err == nil {
+		return info
+	}
+	info.Message = err.Error()
+
+	var h *common.HttpError
+	switch cause := errors.Cause(err).(type) {
+	case common.HttpError:
+		h = &cause
+	case *common.HttpError:
+		h = cause
+	}
+	if h != nil {
+		info.Status = h.StatusCode
+		info.Reason = h.Code
+		info.Details = h.Details
+		info.RequestID = h.RequestID
+	}
+	return info
+}
+
+// ExitCode returns exit code for the error returned by a command,
+// errors wrapped by errors.Wrap get the exit code of their cause.
+func ExitCode(err error) int {
+	err = errors.Cause(err)
+	switch err := err.(type) {
+	case nil:
+		return ExitOK
+	case usageError:
+		return ExitUsage
+	case common.HttpError:
+		return statusExitCode(err.StatusCode)
+	case *common.HttpError:
+		return statusExitCode(err.StatusCode)
+	case net.Error:
+		return ExitUnavailable
+	}
+
+	switch err {
+	case ErrTenantNotFound:
+		return ExitNotFound
+	case ErrConflict:
+		return ExitConflict
+	case ErrAborted:
+		return ExitAborted
+	}
+
+	// cobra reports unknown commands as plain errors.
+	if strings.HasPrefix(err.Error(), "unknown command") {
+		return ExitUsage
+	}
+	return ExitError
+}
+
+// statusExitCode returns exit code for HTTP status of romana service response.
+func statusExitCode(status int) int {
+	switch {
+	case status == http.StatusNotFound:
+		return ExitNotFound
+	case status == http.StatusConflict:
+		return ExitConflict
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ExitDenied
+	case status >= http.StatusInternalServerError || status == http.StatusTooManyRequests:
+		return ExitUnavailable
+	}
+	return ExitError
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/romana/core/common"
+	cli "github.com/spf13/cobra"
+)
+
+func TestExitCode(t *testing.T) {
+	netErr := &url.Error{Op: "Get", URL: "http://127.0.0.1:9600/hosts", Err: &net.OpError{Op: "dial"}}
+
+	for i, tc := range []struct {
+		err    error
+		expect int
+	}{
+		{nil, ExitOK},
+		{fmt.Errorf("failed"), ExitError},
+		{UsageError(&cli.Command{Use: "romana"}, "HOST NAME expected."), ExitUsage},
+		{fmt.Errorf(`unknown command "hots" for "romana"`), ExitUsage},
+		{common.HttpError{StatusCode: 404}, ExitNotFound},
+		{common.HttpError{StatusCode: 409}, ExitConflict},
+		{common.HttpError{StatusCode: 403}, ExitDenied},
+		{common.HttpError{StatusCode: 503}, ExitUnavailable},
+		{common.HttpError{StatusCode: 429}, ExitUnavailable},
+		{common.HttpError{StatusCode: 400}, ExitError},
+		{errors.Wrap(common.HttpError{StatusCode: 404}, "error deleting policy"), ExitNotFound},
+		{errors.Wrapf(ErrConflict, "topology was updated since revision %d", 1), ExitConflict},
+		{ErrAborted, ExitAborted},
+		{netErr, ExitUnavailable},
+	} {
+		if code := ExitCode(tc.err); code != tc.expect {
+			t.Errorf("%d: expected exit code %d for %v, got %d", i, tc.expect, tc.err, code)
+		}
+	}
+}
+
+func TestDescribeError(t *testing.T) {
+	err := errors.Wrap(common.HttpError{StatusCode: 409, Details: "host exists"}, "failed to add host")
+	info := DescribeError(err)
+	if info.Code != CodeConflict || info.ExitCode != ExitConflict {
+		t.Errorf("expected code %s and exit code %d, got %s and %d", CodeConflict, ExitConflict, info.Code, info.ExitCode)
+	}
+	if info.Status != 409 || info.Details != "host exists" {
+		t.Errorf("expected status and details of the response, got %d and %v", info.Status, info.Details)
+	}
+	if info.Message != err.Error() {
+		t.Errorf("expected message %q, got %q", err.Error(), info.Message)
+	}
+
+	err = common.HttpError{StatusCode: 403, Code: common.ErrorCodeQuotaExceeded, RequestID: "0123456789abcdef"}
+	info = DescribeError(err)
+	if info.Code != CodeDenied || info.Reason != common.ErrorCodeQuotaExceeded || info.RequestID != "0123456789abcdef" {
+		t.Errorf("expected reason and request ID of the response, got %+v", info)
+	}
+
+	info = DescribeError(ErrAborted)
+	if info.Code != CodeAborted || info.Status != 0 || info.Details != nil {
+		t.Errorf("unexpected description of %v: %+v", ErrAborted, info)
+	}
+}
@@ -28,9 +28,9 @@ import (
 // to few or more arguments being passed to the commands or
 // sub-commands of romana command line tools.
 func UsageError(cmd *cli.Command, format string, args ...interface{}) error {
-	return fmt.Errorf("%s\nCheck '%s -h' for help",
+	return usageError{fmt.Sprintf("%s\nCheck '%s -h' for help",
 		fmt.Sprintf(format, args...),
-		cmd.CommandPath())
+		cmd.CommandPath())}
 }
 
 // JSONIndent indents the JSON input string, return input string if it fails.
@@ -0,0 +1,104 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selector filters objects listed by commands, it is parsed from
+// comma-separated requirements of form key=value or key!=value,
+// e.g. "tenant=t1,direction!=egress".
+type Selector []Requirement
+
+// Requirement is a single requirement of the selector.
+type Requirement struct {
+	Key    string
+	Value  string
+	Negate bool
+}
+
+// ParseSelector parses selector, empty selector matches everything.
+func ParseSelector(s string) (Selector, error) {
+	var selector Selector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var r Requirement
+		var kv []string
+		switch {
+		case strings.Contains(part, "!="):
+			r.Negate = true
+			kv = strings.SplitN(part, "!=", 2)
+		case strings.Contains(part, "=="):
+			kv = strings.SplitN(part, "==", 2)
+		case strings.Contains(part, "="):
+			kv = strings.SplitN(part, "=", 2)
+		default:
+			return nil, fmt.Errorf("invalid selector requirement %q, expected key=value or key!=value", part)
+		}
+
+		r.Key = strings.TrimSpace(kv[0])
+		r.Value = strings.TrimSpace(kv[1])
+		if r.Key == "" {
+			return nil, fmt.Errorf("invalid selector requirement %q, key is empty", part)
+		}
+		selector = append(selector, r)
+	}
+	return selector, nil
+}
+
+// Matches returns true if labels meet every requirement. Labels may
+// have several values, e.g. tenants a policy is applied to, key=value
+// is met if any of values is equal and key!=value if none is.
+func (s Selector) Matches(labels map[string][]string) bool {
+	for _, r := range s {
+		found := false
+		for _, value := range labels[r.Key] {
+			if value == r.Value {
+				found = true
+				break
+			}
+		}
+		if found == r.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Unknown returns keys of requirements that are not in known,
+// so that commands can reject misspelled keys.
+func (s Selector) Unknown(known ...string) []string {
+	var unknown []string
+	for _, r := range s {
+		ok := false
+		for _, k := range known {
+			if r.Key == k {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			unknown = append(unknown, r.Key)
+		}
+	}
+	return unknown
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package util
+
+import (
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+	labels := map[string][]string{
+		"tenant":    {"t1", "t2"},
+		"direction": {"ingress"},
+	}
+
+	for s, expect := range map[string]bool{
+		"":                             true,
+		"tenant=t1":                    true,
+		"tenant==t2":                   true,
+		"tenant=t3":                    false,
+		"tenant!=t3":                   true,
+		"tenant!=t1":                   false,
+		"tenant=t1, direction=ingress": true,
+		"tenant=t1,direction!=ingress": false,
+		"segment!=s1":                  true,
+		"segment=s1":                   false,
+	} {
+		selector, err := ParseSelector(s)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %s", s, err)
+		}
+		if selector.Matches(labels) != expect {
+			t.Errorf("expected %q to match %t", s, expect)
+		}
+	}
+
+	for _, s := range []string{"tenant", "=t1", "tenant=t1,segment"} {
+		if _, err := ParseSelector(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+
+	selector, _ := ParseSelector("tenant=t1,tennant=t2")
+	if unknown := selector.Unknown("tenant", "segment"); len(unknown) != 1 || unknown[0] != "tennant" {
+		t.Fatalf("unexpected unknown keys %v", unknown)
+	}
+}
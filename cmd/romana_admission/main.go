@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Command for running the validating admission webhook.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/pkg/admission"
+	log "github.com/romana/rlog"
+)
+
+func main() {
+	host := flag.String("host", "0.0.0.0", "Host to listen on.")
+	port := flag.Int("port", 9604, "Port to listen on.")
+	segmentLabelName := flag.String("segment-label-name", admission.DefaultSegmentLabelName, "Label, or annotation, of pods with their segment.")
+	var serverTLS common.ServerTLS
+	serverTLS.RegisterFlags(flag.CommandLine)
+	var requestLimits common.RequestLimits
+	requestLimits.RegisterFlags(flag.CommandLine)
+	var logging common.Logging
+	logging.RegisterFlags(flag.CommandLine)
+	var tracing common.Tracing
+	tracing.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	if err := common.ConfigureLogging(logging, "romana_admission", ""); err != nil {
+		log.Errorf("Failed to configure logging, %s", err)
+		os.Exit(2)
+	}
+	// The service runs until killed, spans are
+	// exported in batches while it runs.
+	if _, err := common.ConfigureTracing(tracing, "romana_admission"); err != nil {
+		log.Errorf("Failed to configure tracing, %s", err)
+		os.Exit(2)
+	}
+	log.Info(common.BuildInfo())
+
+	if !serverTLS.IsEnabled() {
+		// kubernetes only calls webhooks over TLS.
+		log.Warn("No TLS certificate given, kubernetes API server can't call the webhook over plain HTTP")
+	}
+
+	webhook := &admission.Webhook{
+		Addr:             fmt.Sprintf("%s:%d", *host, *port),
+		SegmentLabelName: *segmentLabelName,
+	}
+	config := common.Config{
+		ServerTLS:     serverTLS,
+		RequestLimits: requestLimits,
+	}
+	svcInfo, err := common.InitializeService(webhook, config)
+	if err != nil {
+		log.Error(err)
+		os.Exit(2)
+	}
+	if svcInfo != nil {
+		for {
+			msg := <-svcInfo.Channel
+			log.Info(msg)
+		}
+	}
+}
@@ -19,9 +19,12 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/romana/core/agent"
@@ -37,11 +40,16 @@ import (
 
 	log "github.com/romana/rlog"
 	"github.com/vishvananda/netlink"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
 	DefaultRouteTableId = 10
 	DefaultGwIP         = "172.142.0.1"
+
+	// shutdownTimeout limits how long agent waits
+	// for its components to stop on exit.
+	shutdownTimeout = 10 * time.Second
 )
 
 var (
@@ -55,30 +63,113 @@ var (
 func main() {
 	var err error
 
+	configFile := flag.String("config", "", "file with agent flags, one name=value per line, re-read on SIGHUP")
 	etcdEndpoints := flag.String("endpoints", "", "csv list of etcd endpoints to romana storage")
 	etcdPrefix := flag.String("prefix", "", "string that prefixes all romana keys in etcd")
+	storeBackend := flag.String("store-backend", client.BackendEtcd, "kv store holding romana data, etcd or consul")
+	slowOpThreshold := flag.Duration("store-slow-threshold", client.DefaultSlowOpThreshold, "log store operations slower than this, negative means disable")
+	var etcdTLS common.EtcdTLS
+	etcdTLS.RegisterFlags(flag.CommandLine)
+	var etcdAuth common.EtcdAuth
+	etcdAuth.RegisterFlags(flag.CommandLine)
+	var encryption common.Encryption
+	encryption.RegisterFlags(flag.CommandLine)
+	var storeFaults common.StoreFaults
+	storeFaults.RegisterFlags(flag.CommandLine)
 	hostname := flag.String("hostname", "", "name of the host in romana database")
 	defaultLinkName := flag.String("link-name", "", "name of the host's primary network interface")
+	defaultLinkCIDR := flag.String("link-cidr", "", "select the host's primary network interface by cidr of its address")
+	defaultLinkLabel := flag.String("link-label", "", "select the host's primary network interface by its alias")
 	provisionIface := flag.Bool("provision-iface", false, "create romana-gw interface and ip")
 	provisionIfaceGwIp := flag.String("provision-iface-gw-ip", DefaultGwIP, "specifies ip address for gateway interface")
 	provisionSysctls := flag.Bool("provision-sysctls", false, "configure routing sysctls")
 	romanaRouteTableId := flag.Int("route-table-id", DefaultRouteTableId,
 		"id that romana route table should have in /etc/iproute2/rt_tables")
 	multihop := flag.Bool("multihop-blocks", false, "allows multihop blocks")
+	nextHopCheck := flag.Duration("next-hop-check-interval", 5*time.Second, "interval of liveness checks of next hops of groups, dead ones are withdrawn from multipath routes, 0 means disable")
+	aggregateRoutes := flag.Bool("aggregate-routes", false, "route blocks of a group that are all on one host via the group cidr, and collapse sibling blocks of a host")
+	blackOutRoutes := flag.String("blacked-out-routes", agent.BlackOutRouteNone,
+		"route installed for blacked out cidrs, one of none, blackhole, unreachable")
 	policyEnforcer := flag.Bool("policy", false, "enable romana policies")
+	firewall := flag.String("firewall", enforcer.ProviderIPtables, "firewall provider used to enforce policies, one of iptables, bpf (experimental), none")
+	policyRefresh := flag.Int("policy-refresh", 10, "seconds between policy enforcer runs programming block changes and retrying failures, policy changes are programmed right away")
+	policyResync := flag.Duration("policy-resync", 10*time.Minute, "interval of listing all policies in case the watch on policies missed a change, 0 means disable")
+	flushConntrack := flag.Bool("policy-flush-conntrack", false, "delete conntrack entries of flows denied by policy updates")
+	bandwidth := flag.Bool("bandwidth", false, "apply bandwidth limits of endpoints and policies with tc")
+	livenessTTL := flag.Duration("liveness-ttl", client.DefaultLivenessTTL, "host is considered down when agent didn't renew its liveness for this long")
+	readCache := flag.Bool("read-cache", false, "serve reads of policies, hosts and ipam from memory kept up to date by watches")
 	metricsPort := flag.Int("metrics", 9607, "tcp port to expose prometheus metrics, -1 means disable")
+	mirrorPort := flag.Int("mirror-port", -1, "tcp port to expose traffic mirroring API, -1 means disable")
+	mirrorAddress := flag.String("mirror-address", "127.0.0.1", "address to bind traffic mirroring API to, e.g. address of the host")
+	var mirrorAuth common.APIAuth
+	flag.StringVar(&mirrorAuth.PublicKeyFile, "auth-public-key", "", "PEM file with RSA public key of romanad verifying tokens of traffic mirroring API requests")
+	flag.StringVar(&mirrorAuth.Audience, "auth-audience", common.DefaultAuthAudience, "audience of romanad tokens, tokens for other audiences are rejected")
+	cleanupOnExit := flag.Bool("cleanup-on-exit", false, "remove romana routes and iptables rules when agent stops")
+	stateFile := flag.String("state-file", "", "file to persist agent state on exit, empty means disabled")
+	var logging common.Logging
+	logging.RegisterFlags(flag.CommandLine)
+	var tracing common.Tracing
+	tracing.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	fmt.Println(common.BuildInfo())
+	var flagFile *agent.FlagFile
+	if *configFile != "" {
+		flagFile = agent.NewFlagFile(*configFile, flag.CommandLine)
+		if _, err := flagFile.Load(nil, validateConfig); err != nil {
+			log.Errorf("Failed to load agent configuration, %s", err)
+			os.Exit(2)
+		}
+	}
+
+	// sessionConfig returns current values of the flags
+	// that can be changed by reloading configuration.
+	sessionConfig := func() agentConfig {
+		return agentConfig{
+			EtcdEndpoints:   *etcdEndpoints,
+			EtcdPrefix:      *etcdPrefix,
+			EtcdTLS:         etcdTLS,
+			Backend:         *storeBackend,
+			EtcdAuth:        etcdAuth,
+			Encryption:      encryption,
+			SlowOpThreshold: *slowOpThreshold,
+			StoreFaults:     storeFaults,
+			LinkName:        *defaultLinkName,
+			LinkCIDR:        *defaultLinkCIDR,
+			LinkLabel:       *defaultLinkLabel,
+			Policy:          *policyEnforcer,
+			Firewall:        *firewall,
+			PolicyRefresh:   *policyRefresh,
+			PolicyResync:    *policyResync,
+			FlushConntrack:  *flushConntrack,
+			Bandwidth:       *bandwidth,
+			ReadCache:       *readCache,
+			LivenessTTL:     *livenessTTL,
+		}
+	}
+
+	if err := common.ConfigureLogging(logging, "romana_agent", *hostname); err != nil {
+		log.Errorf("Failed to configure logging, %s", err)
+		os.Exit(2)
+	}
+	shutdownTracing, err := common.ConfigureTracing(tracing, "romana_agent")
+	if err != nil {
+		log.Errorf("Failed to configure tracing, %s", err)
+		os.Exit(2)
+	}
+	defer shutdownTracing()
+	log.Info(common.BuildInfo())
 
 	if err := agent.MetricStart(*metricsPort); err != nil {
 		log.Errorf("Failed to start metrics collector")
 		os.Exit(2)
 	}
 
-	romanaConfig := common.Config{
-		EtcdEndpoints: strings.Split(*etcdEndpoints, ","),
-		EtcdPrefix:    *etcdPrefix,
+	mirrors := agent.NewMirrors(new(utilexec.DefaultExecutor))
+	if *mirrorPort > 0 {
+		if err := agent.MirrorStart(*mirrorAddress, *mirrorPort, mirrorAuth, mirrors); err != nil {
+			log.Errorf("Failed to start mirroring API, %s", err)
+			os.Exit(2)
+		}
 	}
 
 	if *hostname == "" {
@@ -88,12 +179,6 @@ func main() {
 		}
 	}
 
-	romanaClient, err := client.NewClient(&romanaConfig)
-	if err != nil {
-		log.Errorf("Failed to initialize romana client: %v", err)
-		os.Exit(2)
-	}
-
 	if *provisionIface {
 		err := agent.CreateRomanaGW()
 		if err != nil {
@@ -146,54 +231,351 @@ func main() {
 		os.Exit(2)
 	}
 
-	var defaultLink netlink.Link
-	if *defaultLinkName != "" {
-		l, err := nlHandle.LinkByName(*defaultLinkName)
+	if *policyEnforcer && *firewall == enforcer.ProviderIPtables {
+		// ipset is needed by enforcer, so fail here
+		// instead of later during run time.
+		_, err := exec.LookPath("ipset")
 		if err != nil {
-			log.Errorf("failed to get default link %s: %v", *defaultLinkName, err)
+			log.Errorf("failed to find ipset, %s", err)
 			os.Exit(2)
 		}
-		defaultLink = l
-	} else {
-		l, err := agent.GetDefaultLink()
+	}
+
+	if *stateFile != "" {
+		prevState, err := agent.LoadState(*stateFile)
 		if err != nil {
-			log.Errorf("failed to get default link: %s\n", err)
-			os.Exit(3)
+			log.Errorf("Failed to load agent state from %s, %s", *stateFile, err)
+		} else if prevState != nil {
+			log.Infof("Previous agent run saved state at %s: blocks revision=%d, cleaned up=%t",
+				prevState.SavedAt, prevState.BlocksRevision, prevState.CleanedUp)
 		}
-		defaultLink = l
+	}
+
+	// Handle signals in the main loop below, so that route table
+	// rebuild in progress is finished before exit or reload.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	if _, err := agent.ParseBlackOutRouteType(*blackOutRoutes); err != nil {
+		log.Errorf("%s", err)
+		os.Exit(2)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	conf := sessionConfig()
+	sess, err := startSession(ctx, conf, *hostname, nlHandle)
+	if err != nil {
+		log.Errorf("Failed to start romana agent, %s", err)
+		os.Exit(2)
+	}
+
+	// wait for the first list of hosts to be received
+	initialHosts := <-sess.hosts
+	hosts := agent.IpamHosts(initialHosts.Hosts)
+
+	state := agent.State{
+		Hostname:     *hostname,
+		RouteTableId: *romanaRouteTableId,
+		Policy:       *policyEnforcer,
+	}
+
+	// last blocks received, used to rebuild routes
+	// when configuration changes.
+	var lastBlocks *api.IPAMBlocksResponse
+
+	// dedicated route tables of the networks seen so far, these are
+	// flushed together with romana table, including tables that
+	// networks no longer use.
+	routeTables := make(map[int]bool)
+
+	// next hops of groups are checked periodically,
+	// routes are rebuilt when one dies or comes back.
+	nextHops := agent.NewNextHops()
+	var nextHopChecks <-chan time.Time
+	if *nextHopCheck > 0 {
+		ticker := time.NewTicker(*nextHopCheck)
+		defer ticker.Stop()
+		nextHopChecks = ticker.C
+	}
+
+	// routesFailed logs failure to program routes and
+	// records it as event of the host.
+	routesFailed := func(format string, args ...interface{}) {
+		log.Errorf(format, args...)
+		sess.events.Eventf(api.EventWarning, "RoutesFailed",
+			api.EventObject{Kind: api.EventKindHost, Name: *hostname}, format, args...)
+	}
+
+	updateRoutes := func(blocks api.IPAMBlocksResponse) {
+		_, span := common.StartSpan(context.Background(), "agent.routes",
+			attribute.Int("romana.blocks_revision", blocks.Revision),
+			attribute.Int("romana.blocks", len(blocks.Blocks)))
+		defer span.End()
+		startTime := time.Now()
+		err := rtable.FlushRomanaTable()
+		if err != nil {
+			routesFailed("failed to flush romana route table err=(%s)", err)
+			return
+		}
+		for table := range agent.BlockRouteTables(blocks.Blocks) {
+			routeTables[table] = true
+		}
+		for table := range routeTables {
+			if err := rtable.FlushRouteTable(table); err != nil {
+				routesFailed("failed to flush route table %d err=(%s)", table, err)
+				return
+			}
+		}
+
+		links := agent.ResolveBlockLinks(blocks.Blocks, nlHandle)
+		routeBlocks := blocks.Blocks
+		if *aggregateRoutes {
+			routeBlocks = client.AggregateBlocks(routeBlocks)
+		}
+		agent.CreateRouteToBlocks(routeBlocks, hosts, *romanaRouteTableId, *hostname, *multihop, links, nextHops, nlHandle)
+		if routeType, err := agent.ParseBlackOutRouteType(*blackOutRoutes); err != nil {
+			log.Errorf("%s", err)
+		} else if routeType != 0 {
+			agent.CreateBlackOutRoutes(blocks.BlackedOut, *romanaRouteTableId, routeType, nlHandle)
+		}
+		if err := rtable.EnsureSourceRouteRules(agent.SourceRouteRules(blocks.Blocks, *hostname), nlHandle); err != nil {
+			routesFailed("failed to install routing rules for dedicated route tables err=(%s)", err)
+		}
+		state.BlocksRevision = blocks.Revision
+		if err := sess.client.SetBlocksRevision(blocks.Revision); err != nil {
+			log.Errorf("failed to report blocks revision %d err=(%s)", blocks.Revision, err)
+		}
+		runTime := time.Now().Sub(startTime)
+		log.Tracef(4, "Time between route table flush and route table rebuild %s", runTime)
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if flagFile == nil {
+					log.Infof("Received %s, no -config file to reload", sig)
+					continue
+				}
+
+				log.Infof("Received %s, reloading configuration from %s", sig, flagFile.Path)
+				changed, err := flagFile.Load(reloadableFlag, validateConfig)
+				if err != nil {
+					log.Errorf("Failed to reload configuration, %s", err)
+					continue
+				}
+				if len(changed) == 0 {
+					log.Infof("Configuration unchanged")
+					continue
+				}
+				log.Infof("Configuration changed for %s", strings.Join(changed, ", "))
+
+				if newConf := sessionConfig(); newConf != conf {
+					// Routes and iptables rules stay in place while
+					// session restarts, they are rebuilt once the new
+					// session receives blocks.
+					sess.stop()
+					newSess, err := startSession(ctx, newConf, *hostname, nlHandle)
+					if err != nil {
+						log.Errorf("Failed to apply new configuration, restoring previous one, %s", err)
+						newSess, err = startSession(ctx, conf, *hostname, nlHandle)
+						if err != nil {
+							log.Errorf("Failed to restore previous configuration, %s", err)
+							os.Exit(2)
+						}
+					} else {
+						conf = newConf
+					}
+					sess = newSess
+					newHosts := <-sess.hosts
+					hosts = agent.IpamHosts(newHosts.Hosts)
+				} else if lastBlocks != nil {
+					updateRoutes(*lastBlocks)
+				}
+				continue
+			}
+
+			log.Infof("Received %s, shutting down", sig)
+			cancel()
+			sess.stop()
+			mirrors.StopAll()
+
+			if *cleanupOnExit {
+				state.CleanedUp = cleanup(*romanaRouteTableId, *policyEnforcer, *firewall, nlHandle)
+			}
+
+			if *stateFile != "" {
+				if err := agent.SaveState(*stateFile, state); err != nil {
+					log.Errorf("Failed to save agent state to %s, %s", *stateFile, err)
+				}
+			}
+			return
+
+		case blocks := <-sess.blocks:
+			lastBlocks = &blocks
+			updateRoutes(blocks)
+
+		case <-nextHopChecks:
+			if lastBlocks != nil && nextHops.Check(lastBlocks.Blocks, nlHandle) {
+				sess.events.Eventf(api.EventNormal, "NextHopsChanged",
+					api.EventObject{Kind: api.EventKindHost, Name: *hostname},
+					"Next hops of groups changed, rebuilding routes")
+				updateRoutes(*lastBlocks)
+			}
+
+		case newHosts := <-sess.hosts:
+			// TODO need mutex for this.
+			hosts = agent.IpamHosts(newHosts.Hosts)
+		}
+	}
+}
+
+// reloadableFlag returns true for flags that can be
+// changed without restarting the agent.
+func reloadableFlag(name string) bool {
+	switch name {
+	case "endpoints", "prefix", "store-backend", "store-slow-threshold",
+		"etcd-cafile", "etcd-certfile", "etcd-keyfile",
+		"etcd-server-name", "etcd-insecure-skip-verify",
+		"etcd-username", "etcd-password-file",
+		"encryption-key-file", "encrypted-prefixes",
+		"link-name", "link-cidr", "link-label",
+		"multihop-blocks", "aggregate-routes", "blacked-out-routes",
+		"policy-refresh", "policy-resync", "policy-flush-conntrack", "bandwidth", "read-cache", "liveness-ttl":
+		return true
+	}
+	return false
+}
+
+// validateConfig checks values of the flags that
+// could be broken by a change of the config file.
+func validateConfig(fs *flag.FlagSet) error {
+	get := func(name string) interface{} {
+		return fs.Lookup(name).Value.(flag.Getter).Get()
+	}
+
+	switch backend := get("store-backend").(string); backend {
+	case client.BackendEtcd, client.BackendConsul:
+	default:
+		return fmt.Errorf("unknown -store-backend %s", backend)
+	}
+	if linkCIDR := get("link-cidr").(string); linkCIDR != "" {
+		if _, _, err := net.ParseCIDR(linkCIDR); err != nil {
+			return fmt.Errorf("failed to parse -link-cidr %s: %v", linkCIDR, err)
+		}
+	}
+	if refresh := get("policy-refresh").(int); refresh <= 0 {
+		return fmt.Errorf("-policy-refresh must be positive, got %d", refresh)
+	}
+	if resync := get("policy-resync").(time.Duration); resync < 0 {
+		return fmt.Errorf("-policy-resync can not be negative, got %s", resync)
+	}
+	if ttl := get("liveness-ttl").(time.Duration); ttl <= 0 {
+		return fmt.Errorf("-liveness-ttl must be positive, got %s", ttl)
+	}
+	return nil
+}
+
+// agentConfig holds agent configuration that
+// requires restarting the session when changed.
+type agentConfig struct {
+	EtcdEndpoints   string
+	EtcdPrefix      string
+	Backend         string
+	EtcdTLS         common.EtcdTLS
+	EtcdAuth        common.EtcdAuth
+	Encryption      common.Encryption
+	SlowOpThreshold time.Duration
+	StoreFaults     common.StoreFaults
+	LinkName        string
+	LinkCIDR        string
+	LinkLabel       string
+	Policy          bool
+	Firewall        string
+	PolicyRefresh   int
+	PolicyResync    time.Duration
+	FlushConntrack  bool
+	Bandwidth       bool
+	ReadCache       bool
+	LivenessTTL     time.Duration
+}
+
+// session holds agent components that depend on romana client
+// and the primary link, these are restarted when configuration
+// is reloaded.
+type session struct {
+	cancel   context.CancelFunc
+	client   *client.Client
+	blocks   <-chan api.IPAMBlocksResponse
+	hosts    <-chan api.HostList
+	enforcer enforcer.Interface
+	events   *client.EventRecorder
+}
+
+// startSession connects to romana storage and starts watching
+// for blocks and hosts, romanaVIP sync, policy enforcer
+// and bandwidth shaping.
+func startSession(ctx context.Context, conf agentConfig, hostname string, nlHandle *netlink.Handle) (*session, error) {
+	defaultLink, err := findDefaultLink(conf, nlHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	romanaClient, err := client.NewClient(&common.Config{
+		EtcdEndpoints:   strings.Split(conf.EtcdEndpoints, ","),
+		EtcdPrefix:      conf.EtcdPrefix,
+		Backend:         conf.Backend,
+		EtcdTLS:         conf.EtcdTLS,
+		EtcdAuth:        conf.EtcdAuth,
+		Encryption:      conf.Encryption,
+		SlowOpThreshold: conf.SlowOpThreshold,
+		StoreFaults:     conf.StoreFaults,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize romana client: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	sess := &session{cancel: cancel, client: romanaClient}
+	sess.events = romanaClient.RecordEvents("romana_agent", hostname)
+
+	if conf.ReadCache {
+		romanaClient.EnableReadCache(ctx.Done())
+	}
+
+	err = romanaClient.KeepHostAlive(ctx, hostname, conf.LivenessTTL)
+	if err != nil {
+		sess.stop()
+		return nil, fmt.Errorf("failed to register liveness of host %s, %s", hostname, err)
+	}
+
 	err = agent.StartRomanaVIPSync(ctx, romanaClient.Store, defaultLink)
 	if err != nil {
-		log.Errorf("failed to start romanaVIP syncing mechanism: %s\n", err)
-		os.Exit(4)
+		sess.stop()
+		return nil, fmt.Errorf("failed to start romanaVIP syncing mechanism: %s", err)
 	}
 
-	blocksChannel, err := romanaClient.WatchBlocks(ctx.Done())
+	sess.blocks, err = romanaClient.WatchBlocks(ctx.Done())
 	if err != nil {
-		log.Errorf("Failed to subscribe to Romana blocks updates, %s", err)
-		os.Exit(2)
+		sess.stop()
+		return nil, fmt.Errorf("failed to subscribe to Romana blocks updates, %s", err)
 	}
 
-	if *policyEnforcer {
-		// ipset is needed by enforcer below, so fail here
-		// instead of later during run time.
-		_, err := exec.LookPath("ipset")
+	if conf.Policy {
+		policyCache := policycache.New()
+		policyEtcdKey := romanaClient.Store.Key(client.PoliciesPrefix)
+		policies, err := policycontroller.Run(ctx, policyEtcdKey, romanaClient, policyCache, conf.PolicyResync)
 		if err != nil {
-			log.Errorf("failed to find ipset, %s", err)
-			os.Exit(2)
+			sess.stop()
+			return nil, fmt.Errorf("failed to start policy controller, %s", err)
 		}
 
-		ctx := context.Background()
-		policyCache := policycache.New()
-		var policyEtcdKey = "/romana/policies"
-		policies, err := policycontroller.Run(ctx, policyEtcdKey, romanaClient, policyCache)
+		provider, err := enforcer.NewFirewallProvider(conf.Firewall, new(utilexec.DefaultExecutor))
 		if err != nil {
-			log.Errorf("Failed to start policy controller, %s", err)
-			os.Exit(2)
+			sess.stop()
+			return nil, fmt.Errorf("failed to create firewall provider, %s", err)
 		}
 
 		blocksList := romanaClient.IPAM.ListAllBlocks()
@@ -202,47 +584,69 @@ func main() {
 		// this duplicates blocks channel into the 2 new channels, one
 		// used here for policies and another one passed down for routes.
 		var extraBlocksChannel <-chan api.IPAMBlocksResponse
-		blocksChannel, extraBlocksChannel = fanOut(ctx, blocksChannel)
+		sess.blocks, extraBlocksChannel = fanOut(ctx, sess.blocks)
 
-		enforcer, err := enforcer.New(policyCache, policies, *blocksList, extraBlocksChannel, *hostname, new(utilexec.DefaultExecutor), 10)
+		sess.enforcer, err = enforcer.New(policyCache, policies, *blocksList, extraBlocksChannel, hostname, provider, conf.PolicyRefresh, conf.FlushConntrack)
 		if err != nil {
-			log.Errorf("Failed to create policy enforcer, %s", err)
-			os.Exit(2)
+			sess.stop()
+			return nil, fmt.Errorf("failed to create policy enforcer, %s", err)
 		}
 
-		enforcer.Run(ctx)
+		sess.enforcer.Run(ctx)
+	}
 
+	if conf.Bandwidth {
+		shaper := agent.NewShaper(new(utilexec.DefaultExecutor))
+		go agent.BandwidthSync(ctx, romanaClient, hostname, shaper, time.Duration(conf.PolicyRefresh)*time.Second)
 	}
 
-	hostsChannel, err := romanaClient.WatchHosts(ctx.Done())
+	sess.hosts, err = romanaClient.WatchHosts(ctx.Done())
 	if err != nil {
-		log.Errorf("Failed to start watching for blocks, %s", err)
-		os.Exit(2)
+		sess.stop()
+		return nil, fmt.Errorf("failed to start watching for hosts, %s", err)
 	}
 
-	// wait for the first list of hosts to be received
-	initialHosts := <-hostsChannel
-	hosts := agent.IpamHosts(initialHosts.Hosts)
+	return sess, nil
+}
 
-	for {
+// stop stops session components and waits for policy enforcer to exit.
+func (s *session) stop() {
+	s.cancel()
+	if s.enforcer != nil {
 		select {
-		case blocks := <-blocksChannel:
-			startTime := time.Now()
-			err := rtable.FlushRomanaTable()
-			if err != nil {
-				log.Errorf("failed to flush romana route table err=(%s)", err)
-				continue
-			}
+		case <-s.enforcer.Done():
+		case <-time.After(shutdownTimeout):
+			log.Errorf("Policy enforcer didn't stop in %s", shutdownTimeout)
+		}
+	}
+	s.client.Store.Close()
+}
 
-			agent.CreateRouteToBlocks(blocks.Blocks, hosts, *romanaRouteTableId, *hostname, *multihop, nlHandle)
-			runTime := time.Now().Sub(startTime)
-			log.Tracef(4, "Time between route table flush and route table rebuild %s", runTime)
+// findDefaultLink returns the host's primary network interface
+// selected by configuration or the one with default route.
+func findDefaultLink(conf agentConfig, nlHandle *netlink.Handle) (netlink.Link, error) {
+	linkSelector := agent.LinkSelector{Name: conf.LinkName, Label: conf.LinkLabel}
+	if conf.LinkCIDR != "" {
+		var err error
+		_, linkSelector.CIDR, err = net.ParseCIDR(conf.LinkCIDR)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse -link-cidr %s: %v", conf.LinkCIDR, err)
+		}
+	}
 
-		case newHosts := <-hostsChannel:
-			// TODO need mutex for this.
-			hosts = agent.IpamHosts(newHosts.Hosts)
+	if linkSelector.IsEmpty() {
+		l, err := agent.GetDefaultLink()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get default link: %s", err)
 		}
+		return l, nil
 	}
+
+	l, err := agent.FindLink(linkSelector, nlHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default link %s: %v", linkSelector, err)
+	}
+	return l, nil
 }
 
 // fanOut duplicates data from one channel into 2 identical channels.
@@ -270,6 +674,42 @@ func fanOut(ctx context.Context, in <-chan api.IPAMBlocksResponse) (<-chan api.I
 	return out1, out2
 }
 
+// cleanup removes routes, routing rule and iptables rules
+// installed by the agent, returns true if everything was removed.
+func cleanup(romanaRouteTableId int, policy bool, firewall string, nlHandle *netlink.Handle) bool {
+	ok := true
+
+	if err := rtable.FlushRomanaTable(); err != nil {
+		log.Errorf("Failed to flush romana route table, %s", err)
+		ok = false
+	}
+
+	if err := rtable.RemoveRomanaRouteRule(romanaRouteTableId, nlHandle); err != nil {
+		log.Errorf("Failed to remove routing rule for romana route table, %s", err)
+		ok = false
+	}
+
+	if err := rtable.EnsureSourceRouteRules(nil, nlHandle); err != nil {
+		log.Errorf("Failed to remove routing rules for dedicated route tables, %s", err)
+		ok = false
+	}
+
+	if policy {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		provider, err := enforcer.NewFirewallProvider(firewall, new(utilexec.DefaultExecutor))
+		if err == nil {
+			err = provider.Cleanup(ctx)
+		}
+		if err != nil {
+			log.Errorf("Failed to remove romana firewall rules, %s", err)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
 // checkSysctls checks that esseantial sysctl options are set.
 func checkSysctls() (ok bool, err error) {
 	for _, path := range kernelParameter {
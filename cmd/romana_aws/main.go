@@ -19,6 +19,7 @@ package main
 
 import (
 	// stdlib imports
+	"flag"
 	"log"
 	"os"
 	"os/signal"
@@ -36,11 +37,15 @@ import (
 	"k8s.io/client-go/pkg/fields"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
 
 	"golang.org/x/sys/unix"
 )
 
 func main() {
+	kubeconfig := flag.String("kubeconfig", "", "Kubeconfig file to connect to kubernetes with, service account of the pod is used when empty.")
+	flag.Parse()
+
 	// aws api client
 	awsSession, err := session.NewSession()
 	if err != nil {
@@ -48,15 +53,21 @@ func main() {
 		return
 	}
 
-	// kubernetes api client
-	cc, err := rest.InClusterConfig()
+	// kubernetes api client, authenticated with the service
+	// account token of the pod unless kubeconfig is given.
+	var cc *rest.Config
+	if *kubeconfig != "" {
+		cc, err = clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	} else {
+		cc, err = rest.InClusterConfig()
+	}
 	if err != nil {
-		log.Printf("error in creating in-cluster config: %s", err)
+		log.Printf("error in creating kubernetes client config: %s", err)
 		return
 	}
 	client, err := kubernetes.NewForConfig(cc)
 	if err != nil {
-		log.Printf("error in creating in-cluster client: %s", err)
+		log.Printf("error in creating kubernetes client: %s", err)
 		return
 	}
 
@@ -0,0 +1,179 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// romana_dns publishes DNS records of addresses allocated by
+// romana IPAM, either into etcd backing CoreDNS or by RFC2136
+// dynamic updates, and removes them once addresses are released.
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/client"
+	"github.com/romana/core/dnspublisher"
+
+	log "github.com/romana/rlog"
+)
+
+// readSecret returns trimmed content of file, or empty string
+// if file name is empty.
+func readSecret(file string) (string, error) {
+	if file == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func main() {
+	etcdEndpoints := flag.String("endpoints", "", "csv list of etcd endpoints to romana storage")
+	etcdPrefix := flag.String("prefix", "", "string that prefixes all romana keys in etcd")
+	storeBackend := flag.String("store-backend", client.BackendEtcd, "kv store holding romana data, etcd or consul")
+	var etcdTLS common.EtcdTLS
+	etcdTLS.RegisterFlags(flag.CommandLine)
+	var etcdAuth common.EtcdAuth
+	etcdAuth.RegisterFlags(flag.CommandLine)
+	var encryption common.Encryption
+	encryption.RegisterFlags(flag.CommandLine)
+	var logging common.Logging
+	logging.RegisterFlags(flag.CommandLine)
+
+	zone := flag.String("zone", "", "zone under which records of addresses are published")
+	nameTemplate := flag.String("name-template", dnspublisher.DefaultNameTemplate, "template making record names of addresses, with .Name, .Host, .Tenant and .Segment")
+	publisherName := flag.String("publisher", "coredns", "where records are published, coredns or rfc2136")
+	ttl := flag.Uint("ttl", dnspublisher.DefaultRFC2136TTL, "ttl of published records")
+	resync := flag.Duration("resync", time.Minute, "interval of retrying records which failed to publish")
+
+	coreDNSEndpoints := flag.String("coredns-endpoints", "", "csv list of endpoints of etcd backing CoreDNS")
+	coreDNSPath := flag.String("coredns-path", dnspublisher.DefaultCoreDNSPath, "path of CoreDNS etcd plugin")
+	var coreDNSTLS common.EtcdTLS
+	flag.StringVar(&coreDNSTLS.CAFile, "coredns-cafile", "", "verify certificate of CoreDNS etcd using this CA bundle")
+	flag.StringVar(&coreDNSTLS.CertFile, "coredns-certfile", "", "client certificate for CoreDNS etcd")
+	flag.StringVar(&coreDNSTLS.KeyFile, "coredns-keyfile", "", "client certificate key for CoreDNS etcd")
+	coreDNSUser := flag.String("coredns-username", "", "user name of CoreDNS etcd")
+	coreDNSPasswordFile := flag.String("coredns-password-file", "", "file with the password of CoreDNS etcd user")
+
+	rfc2136Server := flag.String("rfc2136-server", "", "host:port of DNS server accepting updates")
+	rfc2136ReverseZones := flag.String("rfc2136-reverse-zones", "", "csv list of reverse zones receiving PTR records, none to skip them")
+	tsigKey := flag.String("rfc2136-tsig-key", "", "name of TSIG key signing updates")
+	tsigSecretFile := flag.String("rfc2136-tsig-secret-file", "", "file with base64 encoded TSIG secret")
+	tsigAlgorithm := flag.String("rfc2136-tsig-algorithm", "hmac-sha256", "TSIG algorithm")
+	flag.Parse()
+
+	if err := common.ConfigureLogging(logging, "romana_dns", ""); err != nil {
+		log.Errorf("Failed to configure logging, %s", err)
+		os.Exit(2)
+	}
+	log.Info(common.BuildInfo())
+
+	var publisher dnspublisher.Interface
+	switch *publisherName {
+	case "coredns":
+		password, err := readSecret(*coreDNSPasswordFile)
+		if err != nil {
+			log.Errorf("Failed to read CoreDNS etcd password: %s", err)
+			os.Exit(2)
+		}
+		coreDNS, err := dnspublisher.NewCoreDNS(dnspublisher.CoreDNSConfig{
+			Endpoints: strings.Split(*coreDNSEndpoints, ","),
+			TLS:       coreDNSTLS,
+			Username:  *coreDNSUser,
+			Password:  password,
+			Path:      *coreDNSPath,
+			TTL:       uint32(*ttl),
+		})
+		if err != nil {
+			log.Errorf("Failed to initialize CoreDNS publisher: %s", err)
+			os.Exit(2)
+		}
+		defer coreDNS.Close()
+		publisher = coreDNS
+	case "rfc2136":
+		secret, err := readSecret(*tsigSecretFile)
+		if err != nil {
+			log.Errorf("Failed to read TSIG secret: %s", err)
+			os.Exit(2)
+		}
+		var reverseZones []string
+		if *rfc2136ReverseZones != "" {
+			reverseZones = strings.Split(*rfc2136ReverseZones, ",")
+		}
+		publisher, err = dnspublisher.NewRFC2136(dnspublisher.RFC2136Config{
+			Server:        *rfc2136Server,
+			Zone:          *zone,
+			ReverseZones:  reverseZones,
+			TTL:           uint32(*ttl),
+			TSIGKey:       *tsigKey,
+			TSIGSecret:    secret,
+			TSIGAlgorithm: *tsigAlgorithm,
+		})
+		if err != nil {
+			log.Errorf("Failed to initialize RFC2136 publisher: %s", err)
+			os.Exit(2)
+		}
+	default:
+		log.Errorf("Unknown publisher %s, expected coredns or rfc2136", *publisherName)
+		os.Exit(2)
+	}
+
+	controller, err := dnspublisher.NewController(publisher, *zone, *nameTemplate)
+	if err != nil {
+		log.Errorf("Failed to initialize DNS controller: %s", err)
+		os.Exit(2)
+	}
+
+	romanaConfig := common.Config{
+		EtcdEndpoints: strings.Split(*etcdEndpoints, ","),
+		EtcdPrefix:    *etcdPrefix,
+		EtcdTLS:       etcdTLS,
+		Backend:       *storeBackend,
+		EtcdAuth:      etcdAuth,
+		Encryption:    encryption,
+	}
+	romanaClient, err := client.NewClient(&romanaConfig)
+	if err != nil {
+		log.Errorf("Failed to initialize romana client: %v", err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addresses, err := romanaClient.WatchAddresses(ctx.Done())
+	if err != nil {
+		log.Errorf("Failed to start watching for addresses, %s", err)
+		os.Exit(2)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	controller.Run(ctx, addresses, *resync)
+}
@@ -16,6 +16,8 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -23,15 +25,31 @@ import (
 
 	"github.com/romana/core/common"
 	"github.com/romana/core/doc/tools"
+	"github.com/romana/core/listener"
+	"github.com/romana/core/pkg/admission"
 	"github.com/romana/core/server"
 )
 
 func main() {
+	openAPIDir := flag.String("openapi", "",
+		"write OpenAPI documents of services, as they serve them at "+
+			common.APIDocsPath+", to <dir>/<service>/openapi.json")
+	flag.Parse()
 
-	if len(os.Args) != 2 {
-		log.Fatalf("%s <path>", os.Args[0])
+	services := []common.Service{&server.Romanad{}, &listener.KubeListener{}, &admission.Webhook{}}
+	if *openAPIDir != "" {
+		for _, service := range services {
+			if err := writeOpenAPIDoc(*openAPIDir, service); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	if flag.NArg() != 1 {
+		log.Fatalf("%s [-openapi <dir>] <path>", os.Args[0])
 	}
-	path := os.Args[1]
+	path := flag.Arg(0)
 	log.Printf("Analyzing %s", path)
 	a := tools.NewAnalyzer(path)
 	a.Analyze()
@@ -41,7 +59,6 @@ func main() {
 	//	implementors := a.FindImplementors(serviceInterfaceName)
 	//	log.Printf("The following implement the %s interface: %+v", serviceInterfaceName, implementors)
 
-	services := []common.Service{&server.Romanad{}}
 	for _, service := range services {
 		rd := tools.NewSwaggerer(a, service)
 		json, err := rd.Process()
@@ -62,3 +79,22 @@ func main() {
 		log.Printf("Wrote %s", fname)
 	}
 }
+
+// writeOpenAPIDoc writes OpenAPI document of the service
+// to dir/<service name>/openapi.json.
+func writeOpenAPIDoc(dir string, service common.Service) error {
+	body, err := json.MarshalIndent(common.NewOpenAPIDoc(service), "", "  ")
+	if err != nil {
+		return err
+	}
+	dir = fmt.Sprintf("%s/%s", dir, service.Name())
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	fname := fmt.Sprintf("%s/openapi.json", dir)
+	if err := ioutil.WriteFile(fname, append(body, '\n'), 0644); err != nil {
+		return err
+	}
+	log.Printf("Wrote %s", fname)
+	return nil
+}
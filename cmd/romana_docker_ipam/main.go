@@ -0,0 +1,106 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// romana_docker_ipam is a Docker libnetwork remote IPAM driver
+// allocating addresses of containers in romana IPAM.
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/client"
+	"github.com/romana/core/dockeripam"
+
+	log "github.com/romana/rlog"
+)
+
+func main() {
+	etcdEndpoints := flag.String("endpoints", "", "csv list of etcd endpoints to romana storage")
+	etcdPrefix := flag.String("prefix", "", "string that prefixes all romana keys in etcd")
+	storeBackend := flag.String("store-backend", client.BackendEtcd, "kv store holding romana data, etcd or consul")
+	hostname := flag.String("hostname", "", "name of the host in romana database")
+	socket := flag.String("socket", "/run/docker/plugins/romana.sock", "plugin socket, its name is the name of the driver")
+	var etcdTLS common.EtcdTLS
+	etcdTLS.RegisterFlags(flag.CommandLine)
+	var etcdAuth common.EtcdAuth
+	etcdAuth.RegisterFlags(flag.CommandLine)
+	var encryption common.Encryption
+	encryption.RegisterFlags(flag.CommandLine)
+	var logging common.Logging
+	logging.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	var err error
+	if *hostname == "" {
+		*hostname, err = os.Hostname()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if err := common.ConfigureLogging(logging, "romana_docker_ipam", *hostname); err != nil {
+		log.Errorf("Failed to configure logging, %s", err)
+		os.Exit(2)
+	}
+	log.Info(common.BuildInfo())
+
+	romanaConfig := common.Config{
+		EtcdEndpoints: strings.Split(*etcdEndpoints, ","),
+		EtcdPrefix:    *etcdPrefix,
+		EtcdTLS:       etcdTLS,
+		Backend:       *storeBackend,
+		EtcdAuth:      etcdAuth,
+		Encryption:    encryption,
+	}
+	romanaClient, err := client.NewClient(&romanaConfig)
+	if err != nil {
+		log.Errorf("Failed to initialize romana client: %v", err)
+		os.Exit(2)
+	}
+
+	// Socket of a previous run is left behind
+	// when it was killed.
+	if err := os.MkdirAll(filepath.Dir(*socket), 0755); err != nil {
+		log.Errorf("Failed to create directory of %s: %s", *socket, err)
+		os.Exit(2)
+	}
+	os.Remove(*socket)
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		log.Errorf("Failed to listen on %s: %s", *socket, err)
+		os.Exit(2)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		// Closing the listener removes the socket,
+		// so that docker doesn't use a stale driver.
+		<-signals
+		listener.Close()
+	}()
+
+	log.Infof("Serving IPAM driver on %s", *socket)
+	err = http.Serve(listener, dockeripam.NewDriver(romanaClient, *hostname).Handler())
+	log.Infof("Stopped serving IPAM driver: %s", err)
+}
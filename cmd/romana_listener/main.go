@@ -34,25 +34,82 @@ func main() {
 	host := flag.String("host", "localhost", "Host to listen on.")
 	port := flag.Int("port", 9602, "Port to listen on.")
 	prefix := flag.String("etcd-prefix", client.DefaultEtcdPrefix, "Prefix to use for etcd data.")
+	storeBackend := flag.String("store-backend", client.BackendEtcd, "kv store holding romana data, etcd or consul")
+	leaderElect := flag.Bool("leader-elect", false, "Elect a leader among listener replicas, only the leader watches kubernetes.")
+	metricsPort := flag.Int("metrics-port", 0, "Port to publish prometheus metrics on, 0 disables metrics.")
+	kubeconfig := flag.String("kubeconfig", "", "Kubeconfig file to connect to kubernetes with, service account of the pod is used when empty.")
+	bootstrapCIDR := flag.String("bootstrap-cidr", "", "CIDR of the network of initial topology generated from nodes, grouped by zone, when romana has no topology.")
+	bootstrapBlockMask := flag.Uint("bootstrap-block-mask", 0, "Block mask of the network of bootstrapped topology, 0 for default.")
+	var etcdTLS common.EtcdTLS
+	etcdTLS.RegisterFlags(flag.CommandLine)
+	var etcdAuth common.EtcdAuth
+	etcdAuth.RegisterFlags(flag.CommandLine)
+	var encryption common.Encryption
+	encryption.RegisterFlags(flag.CommandLine)
+	var apiAuth common.APIAuth
+	apiAuth.RegisterFlags(flag.CommandLine)
+	var serverTLS common.ServerTLS
+	serverTLS.RegisterFlags(flag.CommandLine)
+	var requestLimits common.RequestLimits
+	requestLimits.RegisterFlags(flag.CommandLine)
+	var webhooks common.Webhooks
+	webhooks.RegisterFlags(flag.CommandLine)
+	var audit common.Audit
+	audit.RegisterFlags(flag.CommandLine)
+	var logging common.Logging
+	logging.RegisterFlags(flag.CommandLine)
+	var tracing common.Tracing
+	tracing.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	fmt.Println(common.BuildInfo())
+	if err := common.ConfigureLogging(logging, "romana_listener", ""); err != nil {
+		log.Errorf("Failed to configure logging, %s", err)
+		os.Exit(2)
+	}
+	// The service runs until killed, spans are
+	// exported in batches while it runs.
+	if _, err := common.ConfigureTracing(tracing, "romana_listener"); err != nil {
+		log.Errorf("Failed to configure tracing, %s", err)
+		os.Exit(2)
+	}
+	log.Info(common.BuildInfo())
 
 	if endpointsStr == nil {
 		log.Errorf("No etcd endpoints specified")
 		os.Exit(1)
 	}
 	endpoints := strings.Split(*endpointsStr, ",")
-	listener := &listener.KubeListener{Addr: fmt.Sprintf("%s:%d", *host, *port)}
+	kubeListener := &listener.KubeListener{
+		Addr:        fmt.Sprintf("%s:%d", *host, *port),
+		LeaderElect: *leaderElect,
+		Kubeconfig:  *kubeconfig,
+
+		BootstrapCIDR:      *bootstrapCIDR,
+		BootstrapBlockMask: *bootstrapBlockMask,
+	}
+
+	if err := listener.MetricStart(*metricsPort); err != nil {
+		log.Errorf("Failed to start metrics, %s", err)
+		os.Exit(1)
+	}
 
 	pr := *prefix
 	if !strings.HasPrefix(pr, "/") {
 		pr = "/" + pr
 	}
 	config := common.Config{EtcdEndpoints: endpoints,
-		EtcdPrefix: pr,
+		EtcdPrefix:    pr,
+		EtcdTLS:       etcdTLS,
+		Backend:       *storeBackend,
+		EtcdAuth:      etcdAuth,
+		Encryption:    encryption,
+		APIAuth:       apiAuth,
+		ServerTLS:     serverTLS,
+		RequestLimits: requestLimits,
+		Webhooks:      webhooks,
+		Audit:         audit,
 	}
-	svcInfo, err := common.InitializeService(listener, config)
+	svcInfo, err := common.InitializeService(kubeListener, config)
 	if err != nil {
 		log.Error(err)
 		os.Exit(2)
@@ -17,12 +17,13 @@ package main
 
 import (
 	"flag"
-	"fmt"
+	"net"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
 	"github.com/romana/core/common/client"
 	"github.com/romana/core/routepublisher/bird"
 	"github.com/romana/core/routepublisher/publisher"
@@ -92,9 +93,22 @@ func main() {
 	flagBirdPidFile := flag.String("pid", "/var/run/bird.pid", "location of bird pid file")
 	flagDebug := flag.String("debug", "", "set to yes or true to enable debug output")
 	flagLocalAS := flag.String("as", "65534", "local as number")
+	storeBackend := flag.String("store-backend", client.BackendEtcd, "kv store holding romana data, etcd or consul")
+	aggregateRoutes := flag.Bool("aggregate-routes", false, "advertise group cidr instead of blocks of a group that are all on this host, and collapse sibling blocks")
+	advertiseVIPs := flag.Bool("advertise-vips", false, "advertise /32 routes of romana VIPs of services bound to this host")
+	var etcdTLS common.EtcdTLS
+	etcdTLS.RegisterFlags(flag.CommandLine)
+	var etcdAuth common.EtcdAuth
+	etcdAuth.RegisterFlags(flag.CommandLine)
+	var logging common.Logging
+	logging.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	fmt.Println(common.BuildInfo())
+	if err := common.ConfigureLogging(logging, "romana_route_publisher", *hostname); err != nil {
+		log.Errorf("Failed to configure logging, %s", err)
+		os.Exit(2)
+	}
+	log.Info(common.BuildInfo())
 
 	config := make(map[string]string)
 	config["templateFileName"] = *flagTemplateFile
@@ -111,6 +125,9 @@ func main() {
 	romanaConfig := common.Config{
 		EtcdEndpoints: strings.Split(*etcdEndpoints, ","),
 		EtcdPrefix:    *etcdPrefix,
+		EtcdTLS:       etcdTLS,
+		Backend:       *storeBackend,
+		EtcdAuth:      etcdAuth,
 	}
 
 	if *hostname == "" {
@@ -136,22 +153,64 @@ func main() {
 		os.Exit(2)
 	}
 
+	// VIPs are watched only when advertised, nil
+	// channel is never selected otherwise.
+	var vipsChannel <-chan map[string]api.ExposedIPSpec
+	if *advertiseVIPs {
+		vipsChannel, err = romanaClient.WatchRomanaVIPs(stopCh)
+		if err != nil {
+			log.Errorf("Failed to start watching for romana VIPs, %s", err)
+			os.Exit(2)
+		}
+	}
+
+	var blocks []api.IPAMBlockResponse
+	var blocksReceived bool
+	var vips []net.IPNet
 	for {
 		select {
-		case blocks := <-blocksChannel:
-			startTime := time.Now()
+		case blocksResponse := <-blocksChannel:
+			blocks = blocksResponse.Blocks
+			blocksReceived = true
+		case exposedIPs, ok := <-vipsChannel:
+			if !ok {
+				vipsChannel = nil
+				continue
+			}
+			vips = nil
+			host, err := romanaClient.GetHost(*hostname)
+			if err != nil {
+				log.Errorf("Failed to get host %s: %s", *hostname, err)
+			} else {
+				vips = client.HostVIPs(exposedIPs, host)
+			}
+			log.Infof("Advertising %d romana VIPs bound to %s", len(vips), *hostname)
+		}
 
-			hostGroups := GetGroupByHost(romanaClient.IPAM, *hostname)
-			args := make(map[string]interface{})
+		// Routes to blocks would be withdrawn if config was
+		// rendered with VIPs before blocks are known.
+		if !blocksReceived {
+			continue
+		}
 
-			if len(hostGroups) > 0 {
-				args["HostGroups"] = hostGroups
-			}
+		startTime := time.Now()
 
-			createRouteToBlocks(blocks.Blocks, args, *hostname, bird)
-			runTime := time.Now().Sub(startTime)
-			log.Tracef(4, "Time between route table flush and route table rebuild %s", runTime)
+		hostGroups := GetGroupByHost(romanaClient.IPAM, *hostname)
+		args := make(map[string]interface{})
+
+		if len(hostGroups) > 0 {
+			args["HostGroups"] = hostGroups
+		}
+		if len(vips) > 0 {
+			args["VIPs"] = vips
+		}
 
+		routeBlocks := blocks
+		if *aggregateRoutes {
+			routeBlocks = client.AggregateBlocks(routeBlocks)
 		}
+		createRouteToBlocks(routeBlocks, vips, args, *hostname, bird)
+		runTime := time.Now().Sub(startTime)
+		log.Tracef(4, "Time between route table flush and route table rebuild %s", runTime)
 	}
 }
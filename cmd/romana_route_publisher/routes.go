@@ -24,8 +24,9 @@ import (
 	log "github.com/romana/rlog"
 )
 
-// createRouteToBlocks loops over list of blocks and creates routes when needed.
-func createRouteToBlocks(blocks []api.IPAMBlockResponse, args map[string]interface{}, hostname string, bird publisher.Interface) {
+// createRouteToBlocks loops over list of blocks and creates routes when needed,
+// along with /32 routes of romana VIPs bound to the host.
+func createRouteToBlocks(blocks []api.IPAMBlockResponse, vips []net.IPNet, args map[string]interface{}, hostname string, bird publisher.Interface) {
 	var networks []net.IPNet
 
 	for _, block := range blocks {
@@ -37,6 +38,8 @@ func createRouteToBlocks(blocks []api.IPAMBlockResponse, args map[string]interfa
 		networks = append(networks, block.CIDR.IPNet)
 	}
 
+	networks = append(networks, vips...)
+
 	err := bird.Update(networks, args)
 	if err != nil {
 		log.Error(err)
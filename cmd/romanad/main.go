@@ -35,16 +35,54 @@ func main() {
 	port := flag.Int("port", 9600, "Port to listen on.")
 	prefix := flag.String("etcd-prefix", client.DefaultEtcdPrefix, "Prefix to use for etcd data.")
 	topologyFile := flag.String("initial-topology-file", "", "Initial topology")
+	storeBackend := flag.String("store-backend", client.BackendEtcd, "kv store holding romana data, etcd, consul or memory (for development, data is lost on exit)")
+	slowOpThreshold := flag.Duration("store-slow-threshold", client.DefaultSlowOpThreshold, "log store operations slower than this, negative means disable")
+	idempotencyTTL := flag.Duration("idempotency-ttl", common.DefaultIdempotencyTTL, "how long responses to POST requests with Idempotency-Key are replayed to retries, negative means disable")
+	maxEvents := flag.Int("max-events", client.DefaultMaxEvents, "number of most recent events of the cluster kept")
+	var etcdTLS common.EtcdTLS
+	etcdTLS.RegisterFlags(flag.CommandLine)
+	var etcdAuth common.EtcdAuth
+	etcdAuth.RegisterFlags(flag.CommandLine)
+	var encryption common.Encryption
+	encryption.RegisterFlags(flag.CommandLine)
+	var storeFaults common.StoreFaults
+	storeFaults.RegisterFlags(flag.CommandLine)
+	var apiAuth common.APIAuth
+	apiAuth.RegisterFlags(flag.CommandLine)
+	var serverTLS common.ServerTLS
+	serverTLS.RegisterFlags(flag.CommandLine)
+	var requestLimits common.RequestLimits
+	requestLimits.RegisterFlags(flag.CommandLine)
+	var webhooks common.Webhooks
+	webhooks.RegisterFlags(flag.CommandLine)
+	var audit common.Audit
+	audit.RegisterFlags(flag.CommandLine)
+	var federation common.Federation
+	federation.RegisterFlags(flag.CommandLine)
+	var logging common.Logging
+	logging.RegisterFlags(flag.CommandLine)
+	var tracing common.Tracing
+	tracing.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	fmt.Println(common.BuildInfo())
+	if err := common.ConfigureLogging(logging, "romanad", ""); err != nil {
+		log.Errorf("Failed to configure logging, %s", err)
+		os.Exit(2)
+	}
+	// The service runs until killed, spans are
+	// exported in batches while it runs.
+	if _, err := common.ConfigureTracing(tracing, "romanad"); err != nil {
+		log.Errorf("Failed to configure tracing, %s", err)
+		os.Exit(2)
+	}
+	log.Info(common.BuildInfo())
 
 	if endpointsStr == nil {
 		log.Errorf("No etcd endpoints specified")
 		os.Exit(1)
 	}
 	endpoints := strings.Split(*endpointsStr, ",")
-	romanad := &server.Romanad{Addr: fmt.Sprintf("%s:%d", *host, *port)}
+	romanad := &server.Romanad{Addr: fmt.Sprintf("%s:%d", *host, *port), MaxEvents: *maxEvents}
 
 	pr := *prefix
 	if !strings.HasPrefix(pr, "/") {
@@ -53,7 +91,20 @@ func main() {
 
 	config := common.Config{EtcdEndpoints: endpoints,
 		EtcdPrefix:          pr,
+		EtcdTLS:             etcdTLS,
+		Backend:             *storeBackend,
+		EtcdAuth:            etcdAuth,
+		Encryption:          encryption,
+		APIAuth:             apiAuth,
+		ServerTLS:           serverTLS,
+		RequestLimits:       requestLimits,
+		Webhooks:            webhooks,
+		Audit:               audit,
+		Federation:          federation,
 		InitialTopologyFile: topologyFile,
+		SlowOpThreshold:     *slowOpThreshold,
+		IdempotencyTTL:      *idempotencyTTL,
+		StoreFaults:         storeFaults,
 	}
 	svcInfo, err := common.InitializeService(romanad, config)
 	if err != nil {
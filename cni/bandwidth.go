@@ -0,0 +1,97 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cni
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/client"
+)
+
+const (
+	// IngressBandwidthAnnotation limits traffic towards the pod.
+	IngressBandwidthAnnotation = "romana.io/ingress-bandwidth"
+
+	// EgressBandwidthAnnotation limits traffic sent by the pod.
+	EgressBandwidthAnnotation = "romana.io/egress-bandwidth"
+)
+
+// PodBandwidth returns bandwidth requested by pod annotations.
+func PodBandwidth(annotations map[string]string) (api.Bandwidth, error) {
+	var result api.Bandwidth
+	var err error
+
+	if s, ok := annotations[IngressBandwidthAnnotation]; ok {
+		if result.IngressKbps, err = ParseBandwidth(s); err != nil {
+			return result, fmt.Errorf("invalid %s annotation, %s", IngressBandwidthAnnotation, err)
+		}
+	}
+
+	if s, ok := annotations[EgressBandwidthAnnotation]; ok {
+		if result.EgressKbps, err = ParseBandwidth(s); err != nil {
+			return result, fmt.Errorf("invalid %s annotation, %s", EgressBandwidthAnnotation, err)
+		}
+	}
+
+	return result, nil
+}
+
+// ParseBandwidth parses rate in bits per second with optional
+// k, M or G suffix, e.g. 10M, and returns it in kilobits per second.
+func ParseBandwidth(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := uint64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			multiplier = 1000
+		case 'm', 'M':
+			multiplier = 1000 * 1000
+		case 'g', 'G':
+			multiplier = 1000 * 1000 * 1000
+		}
+		if multiplier != 1 {
+			s = s[:n-1]
+		}
+	}
+
+	value, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse bandwidth %q", s)
+	}
+
+	kbps := value * multiplier / 1000
+	if value != 0 && kbps == 0 {
+		return 0, fmt.Errorf("bandwidth %d bit/s is too low", value)
+	}
+	return kbps, nil
+}
+
+// registerEndpointBandwidth makes the pod known to agent shaping
+// endpoint traffic, together with limits requested by the pod.
+func registerEndpointBandwidth(romanaClient *client.Client, netConf *NetConf, podName string, iface string, ip net.IP, bandwidth api.Bandwidth) error {
+	return romanaClient.SetEndpointBandwidth(api.EndpointBandwidth{
+		Name:      podName,
+		Host:      netConf.RomanaHostName,
+		Interface: iface,
+		IP:        ip,
+		Bandwidth: bandwidth,
+	})
+}
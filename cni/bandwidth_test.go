@@ -0,0 +1,62 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cni
+
+import (
+	"testing"
+
+	"github.com/romana/core/common/api"
+)
+
+func TestPodBandwidth(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expect      api.Bandwidth
+		err         bool
+	}{
+		{name: "none"},
+		{
+			name:        "both",
+			annotations: map[string]string{IngressBandwidthAnnotation: "10M", EgressBandwidthAnnotation: "512k"},
+			expect:      api.Bandwidth{IngressKbps: 10000, EgressKbps: 512},
+		},
+		{
+			name:        "plain bits",
+			annotations: map[string]string{EgressBandwidthAnnotation: "2000000"},
+			expect:      api.Bandwidth{EgressKbps: 2000},
+		},
+		{
+			name:        "gigabit",
+			annotations: map[string]string{IngressBandwidthAnnotation: "1G"},
+			expect:      api.Bandwidth{IngressKbps: 1000000},
+		},
+		{name: "garbage", annotations: map[string]string{IngressBandwidthAnnotation: "fast"}, err: true},
+		{name: "too low", annotations: map[string]string{IngressBandwidthAnnotation: "100"}, err: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			bandwidth, err := PodBandwidth(tc.annotations)
+			if (err != nil) != tc.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !tc.err && bandwidth != tc.expect {
+				t.Fatalf("expected %+v, got %+v", tc.expect, bandwidth)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@
 package cni
 
 import (
+	"context"
 	"fmt"
 	"net"
 
@@ -34,7 +35,7 @@ const DefaultSegmentID = "default"
 // RomanaAddressManager describes functions that allow allocating and deallocating
 // IP addresses from Romana.
 type RomanaAddressManager interface {
-	Allocate(NetConf, *client.Client, RomanaAllocatorPodDescription) (*net.IPNet, error)
+	Allocate(context.Context, NetConf, *client.Client, RomanaAllocatorPodDescription) (*net.IPNet, error)
 	Deallocate(NetConf, *client.Client, string) error
 }
 
@@ -68,6 +69,21 @@ type NetConf struct {
 	types.NetConf
 	MTU int `json:"mtu"`
 
+	// If MTU isn't set either here or in romana network,
+	// detect it from the host's default interface reduced
+	// by EncapOverhead.
+	AutoMTU       bool `json:"auto_mtu"`
+	EncapOverhead int  `json:"encap_overhead"`
+
+	// Clamp TCP MSS to path MTU on the pod interfaces.
+	ClampMSS bool `json:"clamp_mss"`
+
+	// Register pods for bandwidth shaping done by agent,
+	// see IngressBandwidthAnnotation and EgressBandwidthAnnotation.
+	ShapeBandwidth bool `json:"shape_bandwidth"`
+
+	RuntimeConfig RuntimeConfig `json:"runtimeConfig,omitempty"`
+
 	KubernetesConfig string `json:"kubernetes_config"`
 
 	RomanaClientConfig common.Config `json:"romana_client_config"`
@@ -80,11 +96,19 @@ type NetConf struct {
 	UseAnnotations   bool   `json:"use_annotations"`
 	LogFile          string `json:"log_file"`
 	Policy           bool   `json:"use_policy"`
+
+	// Label (or annotation) of pods with their preferred zone,
+	// see IPAMAddressRequest.Zone. Zones are not used if empty.
+	ZoneLabelName string `json:"zone_label_name"`
+
+	// Export of traces of pod setup, which continue in
+	// romanad and agent, see common.Tracing.
+	Tracing common.Tracing `json:"tracing"`
 }
 
 type DefaultAddressManager struct{}
 
-func (DefaultAddressManager) Allocate(config NetConf, client *client.Client, pod RomanaAllocatorPodDescription) (*net.IPNet, error) {
+func (DefaultAddressManager) Allocate(ctx context.Context, config NetConf, client *client.Client, pod RomanaAllocatorPodDescription) (*net.IPNet, error) {
 	// Discover pod segment.
 	var segmentID string
 	var ok bool
@@ -99,7 +123,16 @@ func (DefaultAddressManager) Allocate(config NetConf, client *client.Client, pod
 	}
 	tenantID := listener.GetTenantIDFromNamespaceName(pod.Namespace)
 
-	ip, err := client.IPAM.AllocateIP(pod.Name, config.RomanaHostName, tenantID, segmentID)
+	var zone string
+	if config.ZoneLabelName != "" {
+		if config.UseAnnotations {
+			zone = pod.Annotations[config.ZoneLabelName]
+		} else {
+			zone = pod.Labels[config.ZoneLabelName]
+		}
+	}
+
+	ip, err := client.IPAM.AllocateIPInZoneContext(ctx, pod.Name, config.RomanaHostName, tenantID, segmentID, zone, false)
 	log.Infof("Allocated IP address %s", ip)
 
 	if err != nil {
@@ -0,0 +1,249 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package ipam implements romana IPAM as a standalone CNI IPAM plugin
+// (type "romana-ipam"), so that romana topology aware address allocation
+// can be used with other main plugins, e.g. bridge or macvlan:
+//
+//	"ipam": {
+//		"type": "romana-ipam",
+//		"romana_client_config": {"EtcdEndpoints": ["10.0.0.1:2379"]},
+//		"mask_bits": 24,
+//		"gateway": "10.112.0.1"
+//	}
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/romana/core/cni"
+	"github.com/romana/core/common"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	log "github.com/romana/rlog"
+)
+
+const (
+	PluginType = "romana-ipam"
+
+	// DefaultTenant is used when the plugin runs outside
+	// of kubernetes and no tenant is configured.
+	DefaultTenant = "default"
+)
+
+// Config represents "ipam" section of the network configuration.
+type Config struct {
+	Type string `json:"type"`
+
+	RomanaClientConfig common.Config `json:"romana_client_config"`
+
+	// Name of a current host in romana.
+	// If omitted, current hostname will be used.
+	RomanaHostName string `json:"romana_host_name"`
+
+	// Kubernetes specific, used when plugin is invoked with K8S_ args.
+	KubernetesConfig string `json:"kubernetes_config"`
+	SegmentLabelName string `json:"segment_label_name"`
+	UseAnnotations   bool   `json:"use_annotations"`
+
+	// Used when plugin is invoked outside of kubernetes.
+	Tenant  string `json:"tenant"`
+	Segment string `json:"segment"`
+
+	// Romana allocates /32 addresses, main plugins that expect
+	// a subnet (e.g. bridge) can be given a wider mask.
+	MaskBits int            `json:"mask_bits"`
+	Gateway  net.IP         `json:"gateway"`
+	Routes   []*types.Route `json:"routes"`
+}
+
+// NetConf is a network configuration as it's passed to the IPAM plugin.
+type NetConf struct {
+	types.NetConf
+	IPAM *Config `json:"ipam"`
+}
+
+// LoadConfig parses network configuration and returns its ipam section.
+func LoadConfig(bytes []byte) (*Config, string, error) {
+	n := NetConf{}
+	if err := json.Unmarshal(bytes, &n); err != nil {
+		return nil, "", fmt.Errorf("failed to load netconf: %s", err)
+	}
+
+	if n.IPAM == nil {
+		return nil, "", fmt.Errorf("ipam section missing in netconf")
+	}
+
+	if n.IPAM.Type != PluginType {
+		return nil, "", fmt.Errorf("unexpected ipam type %s, expected %s", n.IPAM.Type, PluginType)
+	}
+
+	if n.IPAM.MaskBits < 0 || n.IPAM.MaskBits > 32 {
+		return nil, "", fmt.Errorf("invalid mask_bits %d", n.IPAM.MaskBits)
+	}
+
+	if n.IPAM.RomanaHostName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load netconf: %s", err)
+		}
+		n.IPAM.RomanaHostName = hostname
+	}
+
+	return n.IPAM, n.CNIVersion, nil
+}
+
+// cniNetConf converts ipam config into config
+// understood by the romana address manager.
+func (c Config) cniNetConf() cni.NetConf {
+	return cni.NetConf{
+		RomanaClientConfig: c.RomanaClientConfig,
+		RomanaHostName:     c.RomanaHostName,
+		KubernetesConfig:   c.KubernetesConfig,
+		SegmentLabelName:   c.segmentLabelName(),
+		UseAnnotations:     c.UseAnnotations,
+	}
+}
+
+// describeEndpoint returns description of an endpoint an address is
+// allocated for. Kubernetes pods are described by their name and
+// labels, anything else by container ID.
+func describeEndpoint(conf Config, args *skel.CmdArgs, lookupPod bool) (cni.RomanaAllocatorPodDescription, error) {
+	k8sargs := cni.K8sArgs{}
+	// Ignore unknown args since the plugin may run outside of kubernetes.
+	if err := types.LoadArgs(args.Args, &k8sargs); err == nil && k8sargs.K8S_POD_NAME != "" {
+		desc := cni.RomanaAllocatorPodDescription{
+			Name:      k8sargs.MakePodName(),
+			Hostname:  conf.RomanaHostName,
+			Namespace: string(k8sargs.K8S_POD_NAMESPACE),
+		}
+		if !lookupPod {
+			return desc, nil
+		}
+		pod, err := cni.GetPodDescription(k8sargs, conf.KubernetesConfig)
+		if err != nil {
+			return desc, err
+		}
+		desc.Labels = pod.Labels
+		desc.Annotations = pod.Annotations
+		return desc, nil
+	}
+
+	tenant := conf.Tenant
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	desc := cni.RomanaAllocatorPodDescription{
+		Name:      fmt.Sprintf("%s.%s", args.ContainerID, args.IfName),
+		Hostname:  conf.RomanaHostName,
+		Namespace: tenant,
+	}
+	if conf.Segment != "" {
+		segment := map[string]string{conf.segmentLabelName(): conf.Segment}
+		desc.Labels = segment
+		desc.Annotations = segment
+	}
+	return desc, nil
+}
+
+func (c Config) segmentLabelName() string {
+	if c.SegmentLabelName != "" {
+		return c.SegmentLabelName
+	}
+	return "segment"
+}
+
+// CmdAdd allocates an address in romana IPAM.
+func CmdAdd(args *skel.CmdArgs) error {
+	conf, cniVersion, err := LoadConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	netConf := conf.cniNetConf()
+
+	desc, err := describeEndpoint(*conf, args, true)
+	if err != nil {
+		return err
+	}
+
+	romanaClient, err := cni.MakeRomanaClient(&netConf)
+	if err != nil {
+		return err
+	}
+
+	allocator, err := cni.NewRomanaAddressManager(cni.DefaultProvider)
+	if err != nil {
+		return err
+	}
+
+	address, err := allocator.Allocate(context.Background(), netConf, romanaClient, desc)
+	if err != nil {
+		return err
+	}
+	log.Infof("Allocated %s for %s", address, desc.Name)
+
+	return types.PrintResult(makeResult(*conf, *address), cniVersion)
+}
+
+// CmdDel releases an address allocated by CmdAdd.
+func CmdDel(args *skel.CmdArgs) error {
+	conf, _, err := LoadConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	netConf := conf.cniNetConf()
+
+	desc, err := describeEndpoint(*conf, args, false)
+	if err != nil {
+		return err
+	}
+
+	romanaClient, err := cni.MakeRomanaClient(&netConf)
+	if err != nil {
+		return err
+	}
+
+	deallocator, err := cni.NewRomanaAddressManager(cni.DefaultProvider)
+	if err != nil {
+		return err
+	}
+
+	return deallocator.Deallocate(netConf, romanaClient, desc.Name)
+}
+
+// makeResult builds ipam result for the address, interfaces are
+// left for the main plugin to fill in.
+func makeResult(conf Config, address net.IPNet) *current.Result {
+	if conf.MaskBits > 0 {
+		address.Mask = net.CIDRMask(conf.MaskBits, 32)
+	}
+
+	return &current.Result{
+		IPs: []*current.IPConfig{
+			&current.IPConfig{
+				Version: "4",
+				Address: address,
+				Gateway: conf.Gateway,
+			},
+		},
+		Routes: conf.Routes,
+	}
+}
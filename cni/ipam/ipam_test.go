@@ -0,0 +1,101 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package ipam
+
+import (
+	"net"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+func TestLoadConfig(t *testing.T) {
+	cases := []struct {
+		name, conf string
+		valid      bool
+	}{
+		{
+			name:  "valid",
+			conf:  `{"cniVersion": "0.3.1", "type": "bridge", "ipam": {"type": "romana-ipam", "romana_host_name": "host1", "mask_bits": 24}}`,
+			valid: true,
+		},
+		{
+			name: "no ipam section",
+			conf: `{"cniVersion": "0.3.1", "type": "bridge"}`,
+		},
+		{
+			name: "wrong ipam type",
+			conf: `{"cniVersion": "0.3.1", "type": "bridge", "ipam": {"type": "host-local"}}`,
+		},
+		{
+			name: "bad mask",
+			conf: `{"cniVersion": "0.3.1", "type": "bridge", "ipam": {"type": "romana-ipam", "mask_bits": 33}}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf, version, err := LoadConfig([]byte(tc.conf))
+			if !tc.valid {
+				if err == nil {
+					t.Fatalf("expected error loading %s", tc.conf)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if version != "0.3.1" || conf.RomanaHostName != "host1" || conf.MaskBits != 24 {
+				t.Fatalf("unexpected config %+v, version %s", conf, version)
+			}
+		})
+	}
+}
+
+func TestDescribeEndpoint(t *testing.T) {
+	conf := Config{RomanaHostName: "host1", Segment: "backend"}
+
+	desc, err := describeEndpoint(conf, &skel.CmdArgs{ContainerID: "abcdef", IfName: "eth0"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Name != "abcdef.eth0" || desc.Namespace != DefaultTenant || desc.Labels["segment"] != "backend" {
+		t.Fatalf("unexpected description for a container %+v", desc)
+	}
+
+	k8sArgs := "K8S_POD_NAME=nginx;K8S_POD_NAMESPACE=web;K8S_POD_INFRA_CONTAINER_ID=0123456789"
+	desc, err = describeEndpoint(conf, &skel.CmdArgs{ContainerID: "abcdef", IfName: "eth0", Args: k8sArgs}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Name != "nginx.web.01234567" || desc.Namespace != "web" {
+		t.Fatalf("unexpected description for a pod %+v", desc)
+	}
+}
+
+func TestMakeResult(t *testing.T) {
+	address := net.IPNet{IP: net.ParseIP("10.112.0.5"), Mask: net.CIDRMask(32, 32)}
+
+	result := makeResult(Config{MaskBits: 24, Gateway: net.ParseIP("10.112.0.1")}, address)
+	if result.IPs[0].Address.String() != "10.112.0.5/24" {
+		t.Fatalf("expected address 10.112.0.5/24, got %s", result.IPs[0].Address.String())
+	}
+
+	result = makeResult(Config{}, address)
+	if result.IPs[0].Address.String() != "10.112.0.5/32" {
+		t.Fatalf("expected address 10.112.0.5/32, got %s", result.IPs[0].Address.String())
+	}
+}
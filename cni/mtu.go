@@ -0,0 +1,107 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cni
+
+import (
+	"fmt"
+
+	"github.com/romana/core/agent"
+	"github.com/romana/core/agent/iptsave"
+	log "github.com/romana/rlog"
+)
+
+const DefaultMTU = 1500
+
+// DetectMTU returns MTU of the host's default interface
+// reduced by encapsulation overhead.
+func DetectMTU(overhead int) (int, error) {
+	link, err := agent.GetDefaultLink()
+	if err != nil {
+		return 0, err
+	}
+
+	mtu := link.Attrs().MTU - overhead
+	if mtu <= 0 {
+		return 0, fmt.Errorf("mtu of the link %s (%d) is too small for encapsulation overhead %d",
+			link.Attrs().Name, link.Attrs().MTU, overhead)
+	}
+
+	return mtu, nil
+}
+
+// ChooseMTU decides which MTU to use for endpoint interfaces.
+// MTU from CNI config wins, then MTU of the network endpoint belongs to,
+// then MTU auto-detected from the uplink if enabled, and DefaultMTU otherwise.
+func ChooseMTU(netConf NetConf, networkMTU int, detect func(int) (int, error)) int {
+	if netConf.MTU > 0 {
+		return netConf.MTU
+	}
+
+	if networkMTU > 0 {
+		return networkMTU
+	}
+
+	if netConf.AutoMTU && detect != nil {
+		mtu, err := detect(netConf.EncapOverhead)
+		if err == nil {
+			return mtu
+		}
+		log.Errorf("Failed to detect mtu, falling back to %d, err=(%s)", DefaultMTU, err)
+	}
+
+	return DefaultMTU
+}
+
+func enableMSSClamping(ifaceName string) error {
+	return manageRules("mangle", MakeMSSClampRules(ifaceName, iptsave.RenderAppendRule))
+}
+
+func disableMSSClamping(ifaceName string) error {
+	return manageRules("mangle", MakeMSSClampRules(ifaceName, iptsave.RenderDeleteRule))
+}
+
+// MakeMSSClampRules returns rules that clamp TCP MSS to the path MTU
+// for connections forwarded to and from the endpoint interface.
+func MakeMSSClampRules(ifaceName string, op iptsave.RenderState) []*iptsave.IPchain {
+	makeRule := func(match string) *iptsave.IPrule {
+		return &iptsave.IPrule{
+			RenderState: op,
+			Match: []*iptsave.Match{
+				&iptsave.Match{
+					Body: match,
+				},
+				&iptsave.Match{
+					Body: "-p tcp --tcp-flags SYN,RST SYN",
+				},
+			},
+			Action: iptsave.IPtablesAction{
+				Type: iptsave.ActionDefault,
+				Body: "TCPMSS --clamp-mss-to-pmtu",
+			},
+		}
+	}
+
+	return []*iptsave.IPchain{
+		&iptsave.IPchain{
+			Name:   "FORWARD",
+			Policy: "-",
+			Rules: []*iptsave.IPrule{
+				makeRule("-i " + ifaceName),
+				makeRule("-o " + ifaceName),
+			},
+		},
+	}
+}
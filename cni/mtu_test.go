@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cni
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/romana/core/agent/iptsave"
+)
+
+func TestChooseMTU(t *testing.T) {
+	detect := func(overhead int) (int, error) {
+		return 9001 - overhead, nil
+	}
+	failDetect := func(int) (int, error) {
+		return 0, fmt.Errorf("no default link")
+	}
+
+	cases := []struct {
+		name       string
+		netConf    NetConf
+		networkMTU int
+		detect     func(int) (int, error)
+		expect     int
+	}{
+		{name: "default", expect: DefaultMTU},
+		{name: "cni config wins", netConf: NetConf{MTU: 1400, AutoMTU: true}, networkMTU: 1450, detect: detect, expect: 1400},
+		{name: "network mtu", netConf: NetConf{AutoMTU: true}, networkMTU: 1450, detect: detect, expect: 1450},
+		{name: "auto detect", netConf: NetConf{AutoMTU: true, EncapOverhead: 50}, detect: detect, expect: 8951},
+		{name: "auto detect disabled", netConf: NetConf{EncapOverhead: 50}, detect: detect, expect: DefaultMTU},
+		{name: "auto detect failed", netConf: NetConf{AutoMTU: true}, detect: failDetect, expect: DefaultMTU},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mtu := ChooseMTU(tc.netConf, tc.networkMTU, tc.detect)
+			if mtu != tc.expect {
+				t.Fatalf("expected mtu %d, got %d", tc.expect, mtu)
+			}
+		})
+	}
+}
+
+func TestMakeMSSClampRules(t *testing.T) {
+	var rules string
+	for _, chain := range MakeMSSClampRules("romana-abc", iptsave.RenderAppendRule) {
+		rules += chain.RenderFooter()
+	}
+
+	expect := []string{
+		"-A FORWARD -i romana-abc -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --clamp-mss-to-pmtu",
+		"-A FORWARD -o romana-abc -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --clamp-mss-to-pmtu",
+	}
+	for _, e := range expect {
+		if !strings.Contains(rules, e) {
+			t.Fatalf("expected rule %q in\n%s", e, rules)
+		}
+	}
+}
@@ -17,6 +17,7 @@
 package cni
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -31,8 +32,11 @@ import (
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
 	log "github.com/romana/rlog"
 	"github.com/vishvananda/netlink"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sys/unix"
 )
 
@@ -50,8 +54,7 @@ const (
 
 // cmdAdd is a callback functions that gets called by skel.PluginMain
 // in response to ADD method.
-func CmdAdd(args *skel.CmdArgs) error {
-	var err error
+func CmdAdd(args *skel.CmdArgs) (err error) {
 	// netConf stores Romana related config
 	// that comes form stdin.
 	netConf, err := loadConf(args.StdinData)
@@ -61,6 +64,25 @@ func CmdAdd(args *skel.CmdArgs) error {
 	cniVersion := netConf.CNIVersion
 	log.Debugf("Loaded netConf %v", netConf)
 
+	// Spans are exported before the plugin exits, failure
+	// to export them doesn't fail the pod.
+	if shutdownTracing, err := common.ConfigureTracing(netConf.Tracing, "romana-cni"); err != nil {
+		log.Errorf("Failed to configure tracing, %s", err)
+	} else {
+		defer shutdownTracing()
+	}
+	ctx, span := common.StartSpan(context.Background(), "cni.add",
+		attribute.String("cni.container_id", args.ContainerID),
+		attribute.String("cni.netns", args.Netns))
+	defer func() { common.EndSpan(span, err) }()
+
+	if err := netConf.RuntimeConfig.Validate(); err != nil {
+		return fmt.Errorf("Invalid runtime config, err=(%s)", err)
+	}
+	if len(netConf.RuntimeConfig.PortMappings) > 0 || netConf.RuntimeConfig.Bandwidth != nil {
+		log.Debugf("Runtime config %+v is expected to be handled by chained plugins", netConf.RuntimeConfig)
+	}
+
 	// LoadArgs parses kubernetes related parameters from CNI
 	// environment variables.
 	k8sargs := K8sArgs{}
@@ -76,11 +98,28 @@ func CmdAdd(args *skel.CmdArgs) error {
 		return err
 	}
 
+	var bandwidth api.Bandwidth
+	if netConf.ShapeBandwidth {
+		bandwidth, err = PodBandwidth(pod.Annotations)
+		if err != nil {
+			return err
+		}
+	}
+
 	var podAddress *net.IPNet
 	romanaClient, err := MakeRomanaClient(netConf)
 	if err != nil {
 		return err
 	}
+	events := romanaClient.RecordEvents("romana-cni", netConf.RomanaHostName)
+	// events are written before the plugin exits.
+	defer events.Flush()
+	defer func() {
+		if err != nil {
+			events.Eventf(api.EventWarning, "PodSetupFailed", api.EventObject{Kind: api.EventKindPod, Name: pod.Name},
+				"Failed to set up pod %s on %s: %s", pod.Name, netConf.RomanaHostName, err)
+		}
+	}()
 	startTime := time.Now()
 	log.Tracef(4, "Process %d started IPAM transaction at %s", os.Getpid(), startTime)
 	defer func() {
@@ -109,7 +148,8 @@ func CmdAdd(args *skel.CmdArgs) error {
 	if err != nil {
 		return err
 	}
-	podAddress, err = allocator.Allocate(*netConf, romanaClient, RomanaAllocatorPodDescription{
+	span.SetAttributes(attribute.String("k8s.pod.name", pod.Name), attribute.String("k8s.namespace.name", pod.Namespace))
+	podAddress, err = allocator.Allocate(ctx, *netConf, romanaClient, RomanaAllocatorPodDescription{
 		Name:        pod.Name,
 		Hostname:    netConf.RomanaHostName,
 		Namespace:   pod.Namespace,
@@ -133,15 +173,18 @@ func CmdAdd(args *skel.CmdArgs) error {
 	contIface := &current.Interface{}
 	hostIface := &current.Interface{}
 	ifName := "eth0"
-	mtu := 1500
-	if netConf.MTU > 0 {
-		mtu = netConf.MTU
+	var networkMTU int
+	if network := romanaClient.IPAM.GetNetworkForIP(podAddress.IP); network != nil {
+		networkMTU = network.MTU
 	}
+	mtu := ChooseMTU(*netConf, networkMTU, DetectMTU)
+	log.Debugf("Using mtu %d for pod %s", mtu, k8sargs.MakePodName())
 	_, defaultNet, _ := net.ParseCIDR("0.0.0.0/0")
 
 	// And this is a callback inside the callback, it sets up networking
 	// withing a pod namespace, nice thing it save us from shellouts
 	// but still, callback within a callback.
+	_, netnsSpan := common.StartSpan(ctx, "cni.netns")
 	err = netns.Do(func(hostNS ns.NetNS) error {
 		// Creates veth interfacces.
 		hostVeth, containerVeth, err := SetupVeth(ifName, k8sargs.MakeVethName(), mtu, hostNS)
@@ -191,12 +234,25 @@ func CmdAdd(args *skel.CmdArgs) error {
 		contIface.Mac = containerVeth.HardwareAddr.String()
 		contIface.Sandbox = netns.Path()
 		hostIface.Name = hostVeth.Name
+		hostIface.Mac = hostVeth.HardwareAddr.String()
 		return nil
 	})
+	common.EndSpan(netnsSpan, err)
 	if err != nil {
 		return fmt.Errorf("Failed to create veth interfaces in namespace %v, err=(%s)", netns, err)
 	}
 
+	// Interfaces and rules set up from here on are removed
+	// on any return unless flag set to false.
+	var teardownOnExit = true
+	var policyRules, mssRules bool
+	defer func() {
+		if teardownOnExit {
+			log.Errorf("Removing interfaces of pod %s on exit, something went wrong", k8sargs.MakePodName())
+			teardownEndpoint(hostIface.Name, k8sargs.MakeVethName(), policyRules, mssRules)
+		}
+	}()
+
 	// set proxy_delay to zero
 	err = ioutil.WriteFile(fmt.Sprintf("/proc/sys/net/ipv4/neigh/%s/proxy_delay", hostIface.Name), []byte("0"), 0)
 	if err != nil {
@@ -205,26 +261,21 @@ func CmdAdd(args *skel.CmdArgs) error {
 	}
 
 	// Return route.
+	_, routeSpan := common.StartSpan(ctx, "cni.route")
 	err = AddEndpointRoute(hostIface.Name, podAddress, nil)
+	common.EndSpan(routeSpan, err)
 	if err != nil {
 		log.Debug(err)
 		return err
 	}
 
-	result := &current.Result{
-		IPs: []*current.IPConfig{
-			&current.IPConfig{
-				Version:   "4",
-				Address:   *podAddress,
-				Interface: 0,
-			},
-		},
-	}
-
-	result.Interfaces = []*current.Interface{hostIface}
+	result := MakeResult(hostIface, contIface, *podAddress, gwAddr.IP, netConf.DNS)
 
 	if netConf.Policy {
+		_, policySpan := common.StartSpan(ctx, "cni.policy")
+		policyRules = true
 		err := enablePodPolicy(k8sargs.MakeVethName())
+		common.EndSpan(policySpan, err)
 		if err != nil {
 			log.Errorf("Failed to hook pod %s to Romana policy, err=%s", k8sargs.MakePodName(), err)
 			return err
@@ -232,10 +283,56 @@ func CmdAdd(args *skel.CmdArgs) error {
 		log.Debugf("Pod rules created")
 	}
 
+	if netConf.ClampMSS {
+		mssRules = true
+		err := enableMSSClamping(k8sargs.MakeVethName())
+		if err != nil {
+			log.Errorf("Failed to install mss clamping rules for pod %s, err=%s", k8sargs.MakePodName(), err)
+			return err
+		}
+	}
+
+	if netConf.ShapeBandwidth {
+		err := registerEndpointBandwidth(romanaClient, netConf, k8sargs.MakePodName(), hostIface.Name, podAddress.IP, bandwidth)
+		if err != nil {
+			log.Errorf("Failed to register pod %s for bandwidth shaping, err=%s", k8sargs.MakePodName(), err)
+			return err
+		}
+	}
+
 	deallocateOnExit = false
+	teardownOnExit = false
 	return types.PrintResult(result, cniVersion)
 }
 
+// teardownEndpoint removes rules and interfaces of an endpoint
+// which failed to set up, rules may be installed partially.
+// Deleting host side of the veth removes container side and
+// the endpoint route with it. Errors are only logged as the
+// pod has failed already.
+func teardownEndpoint(hostIfaceName, vethName string, policyRules, mssRules bool) {
+	if policyRules {
+		if err := disablePodPolicy(vethName); err != nil {
+			log.Errorf("Failed to cleanup policy rules for %s, err=%s", vethName, err)
+		}
+	}
+
+	if mssRules {
+		if err := disableMSSClamping(vethName); err != nil {
+			log.Errorf("Failed to cleanup mss clamping rules for %s, err=%s", vethName, err)
+		}
+	}
+
+	link, err := netlink.LinkByName(hostIfaceName)
+	if err != nil {
+		log.Errorf("Failed to find interface %s, err=%s", hostIfaceName, err)
+		return
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		log.Errorf("Failed to delete interface %s, err=%s", hostIfaceName, err)
+	}
+}
+
 // cmdDel is a callback functions that gets called by skel.PluginMain
 // in response to DEL method.
 func CmdDel(args *skel.CmdArgs) error {
@@ -288,6 +385,22 @@ func CmdDel(args *skel.CmdArgs) error {
 		log.Debugf("Deleted pod rules")
 	}
 
+	if netConf.ClampMSS {
+		err := disableMSSClamping(k8sargs.MakeVethName())
+		if err != nil {
+			log.Errorf("Failed to cleanup mss clamping rules for pod %s, err=%s", k8sargs.MakePodName(), err)
+			return nil
+		}
+	}
+
+	if netConf.ShapeBandwidth {
+		err := romanaClient.DeleteEndpointBandwidth(netConf.RomanaHostName, k8sargs.MakePodName())
+		if err != nil {
+			log.Errorf("Failed to unregister pod %s from bandwidth shaping, err=%s", k8sargs.MakePodName(), err)
+			return nil
+		}
+	}
+
 	return nil
 }
 
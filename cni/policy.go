@@ -25,14 +25,15 @@ import (
 )
 
 func enablePodPolicy(ifaceName string) error {
-	return manageDivertRules(MakeDivertRules(ifaceName, iptsave.RenderAppendRule))
+	return manageRules("filter", MakeDivertRules(ifaceName, iptsave.RenderAppendRule))
 }
 
 func disablePodPolicy(ifaceName string) error {
-	return manageDivertRules(MakeDivertRules(ifaceName, iptsave.RenderDeleteRule))
+	return manageRules("filter", MakeDivertRules(ifaceName, iptsave.RenderDeleteRule))
 }
 
-func manageDivertRules(divertRules []*iptsave.IPchain) error {
+// manageRules applies rules from provided chains to the iptables table.
+func manageRules(table string, divertRules []*iptsave.IPchain) error {
 	IptablesBin, err := exec.LookPath("iptables")
 	if err != nil {
 		return err
@@ -53,8 +54,8 @@ func manageDivertRules(divertRules []*iptsave.IPchain) error {
 		if rule == "" {
 			continue
 		}
-		rlog.Debugf("EXEC %s", makeArgs(strings.Split(rule, " ")), IptablesBin, "-t", "filter")
-		data, err := exec.Command(IptablesBin, makeArgs(strings.Split(rule, " "), "-t", "filter")...).CombinedOutput()
+		rlog.Debugf("EXEC %s", makeArgs(strings.Split(rule, " ")), IptablesBin, "-t", table)
+		data, err := exec.Command(IptablesBin, makeArgs(strings.Split(rule, " "), "-t", table)...).CombinedOutput()
 		if err != nil {
 			return fmt.Errorf("%s, err=%s", data, err)
 		}
@@ -0,0 +1,113 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cni
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+// RuntimeConfig is populated by the container runtime for the
+// capabilities declared in the network configuration list, e.g.
+// "capabilities": {"portMappings": true, "bandwidth": true}.
+// Romana doesn't program port mappings and the bandwidth capability
+// itself, these are handled by the standard portmap and bandwidth plugins
+// chained after romana (see NetConf.ShapeBandwidth for shaping done by
+// romana agent), but the values are validated here so that broken requests
+// fail early, before an address is allocated.
+type RuntimeConfig struct {
+	PortMappings []PortMapEntry  `json:"portMappings,omitempty"`
+	Bandwidth    *BandwidthEntry `json:"bandwidth,omitempty"`
+}
+
+// PortMapEntry corresponds to a single entry of the portMappings capability.
+type PortMapEntry struct {
+	HostPort      int    `json:"hostPort"`
+	ContainerPort int    `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+	HostIP        string `json:"hostIP,omitempty"`
+}
+
+// BandwidthEntry corresponds to the bandwidth capability,
+// rates are in bits per second and bursts in bits.
+type BandwidthEntry struct {
+	IngressRate  int `json:"ingressRate"`
+	IngressBurst int `json:"ingressBurst"`
+	EgressRate   int `json:"egressRate"`
+	EgressBurst  int `json:"egressBurst"`
+}
+
+// Validate checks that runtime config values are sane.
+func (rc RuntimeConfig) Validate() error {
+	for _, pm := range rc.PortMappings {
+		if pm.HostPort <= 0 || pm.HostPort > 65535 {
+			return fmt.Errorf("invalid host port %d in port mapping %+v", pm.HostPort, pm)
+		}
+		if pm.ContainerPort <= 0 || pm.ContainerPort > 65535 {
+			return fmt.Errorf("invalid container port %d in port mapping %+v", pm.ContainerPort, pm)
+		}
+		switch strings.ToLower(pm.Protocol) {
+		case "tcp", "udp", "sctp", "":
+		default:
+			return fmt.Errorf("invalid protocol %q in port mapping %+v", pm.Protocol, pm)
+		}
+		if pm.HostIP != "" && net.ParseIP(pm.HostIP) == nil {
+			return fmt.Errorf("invalid host ip %q in port mapping %+v", pm.HostIP, pm)
+		}
+	}
+
+	if bw := rc.Bandwidth; bw != nil {
+		if bw.IngressRate < 0 || bw.IngressBurst < 0 || bw.EgressRate < 0 || bw.EgressBurst < 0 {
+			return fmt.Errorf("negative values are not allowed in bandwidth %+v", *bw)
+		}
+		if bw.IngressRate > 0 && bw.IngressBurst == 0 || bw.EgressRate > 0 && bw.EgressBurst == 0 {
+			return fmt.Errorf("burst must be set together with rate in bandwidth %+v", *bw)
+		}
+	}
+
+	return nil
+}
+
+// MakeResult assembles CNI result for the pod. Interfaces are listed as
+// host side veth first and container side second, pod address refers to
+// the container side as required by the spec, which allows chained plugins
+// (e.g. bandwidth) to find both ends of the veth pair.
+func MakeResult(hostIface, contIface *current.Interface, podAddress net.IPNet, gw net.IP, dns types.DNS) *current.Result {
+	_, defaultNet, _ := net.ParseCIDR("0.0.0.0/0")
+
+	return &current.Result{
+		Interfaces: []*current.Interface{hostIface, contIface},
+		IPs: []*current.IPConfig{
+			&current.IPConfig{
+				Version:   "4",
+				Address:   podAddress,
+				Gateway:   gw,
+				Interface: 1,
+			},
+		},
+		Routes: []*types.Route{
+			&types.Route{
+				Dst: *defaultNet,
+				GW:  gw,
+			},
+		},
+		DNS: dns,
+	}
+}
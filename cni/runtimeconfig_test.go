@@ -0,0 +1,95 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cni
+
+import (
+	"net"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+func TestRuntimeConfigValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		rc    RuntimeConfig
+		valid bool
+	}{
+		{name: "empty", valid: true},
+		{
+			name:  "valid port mapping",
+			rc:    RuntimeConfig{PortMappings: []PortMapEntry{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}}},
+			valid: true,
+		},
+		{
+			name: "bad host port",
+			rc:   RuntimeConfig{PortMappings: []PortMapEntry{{HostPort: 70000, ContainerPort: 80, Protocol: "tcp"}}},
+		},
+		{
+			name: "bad protocol",
+			rc:   RuntimeConfig{PortMappings: []PortMapEntry{{HostPort: 8080, ContainerPort: 80, Protocol: "icmp"}}},
+		},
+		{
+			name: "bad host ip",
+			rc:   RuntimeConfig{PortMappings: []PortMapEntry{{HostPort: 8080, ContainerPort: 80, HostIP: "foo"}}},
+		},
+		{
+			name:  "valid bandwidth",
+			rc:    RuntimeConfig{Bandwidth: &BandwidthEntry{IngressRate: 1000000, IngressBurst: 100000}},
+			valid: true,
+		},
+		{
+			name: "rate without burst",
+			rc:   RuntimeConfig{Bandwidth: &BandwidthEntry{EgressRate: 1000000}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rc.Validate()
+			if tc.valid && err != nil {
+				t.Fatalf("expected config to be valid, got %s", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatalf("expected config to be invalid")
+			}
+		})
+	}
+}
+
+func TestMakeResult(t *testing.T) {
+	hostIface := &current.Interface{Name: "romana-abc"}
+	contIface := &current.Interface{Name: "eth0", Sandbox: "/proc/1/ns/net"}
+	podAddress := net.IPNet{IP: net.ParseIP("10.0.0.5"), Mask: net.CIDRMask(32, 32)}
+	gw := net.ParseIP("172.142.0.1")
+	dns := types.DNS{Nameservers: []string{"10.96.0.10"}}
+
+	result := MakeResult(hostIface, contIface, podAddress, gw, dns)
+
+	if len(result.Interfaces) != 2 {
+		t.Fatalf("expected 2 interfaces, got %d", len(result.Interfaces))
+	}
+	if len(result.IPs) != 1 || result.Interfaces[result.IPs[0].Interface] != contIface {
+		t.Fatalf("expected pod address to refer to container interface, got %v", result.IPs)
+	}
+	if len(result.Routes) != 1 || !result.Routes[0].GW.Equal(gw) {
+		t.Fatalf("expected default route via %s, got %v", gw, result.Routes)
+	}
+	if len(result.DNS.Nameservers) != 1 {
+		t.Fatalf("expected dns to be passed through, got %v", result.DNS)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"net"
+	"time"
+)
+
+// Bandwidth limits traffic of an endpoint, rates are in kilobits
+// per second and zero means unlimited. Ingress is traffic towards
+// the endpoint, egress is traffic sent by the endpoint.
+type Bandwidth struct {
+	IngressKbps uint64 `json:"ingress_kbps,omitempty"`
+	EgressKbps  uint64 `json:"egress_kbps,omitempty"`
+}
+
+// IsZero returns true if bandwidth doesn't limit anything.
+func (b Bandwidth) IsZero() bool {
+	return b.IngressKbps == 0 && b.EgressKbps == 0
+}
+
+// EndpointBandwidth is registered by CNI plugin for every endpoint
+// of the host, Bandwidth holds limits requested for the endpoint
+// itself, e.g. by pod annotations. Status is maintained by agent.
+type EndpointBandwidth struct {
+	Name      string           `json:"name"`
+	Host      string           `json:"host"`
+	Interface string           `json:"interface"`
+	IP        net.IP           `json:"ip"`
+	Bandwidth Bandwidth        `json:"bandwidth"`
+	Status    *BandwidthStatus `json:"status,omitempty"`
+}
+
+// BandwidthStatus describes limits applied to the endpoint.
+type BandwidthStatus struct {
+	Applied Bandwidth `json:"applied"`
+
+	// Source of the limits, either "endpoint" or "policy:<id>"
+	// for each direction limited by policy.
+	Source    string    `json:"source,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
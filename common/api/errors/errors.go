@@ -96,3 +96,54 @@ func (ree RomanaExistsError) Error() string {
 		return ree.Message
 	}
 }
+
+// RomanaConflictError represents an error when an entity was
+// modified by someone else since it was read, so that an update
+// based on what was read would overwrite their changes. Clients
+// may retry by reading the entity again.
+type RomanaConflictError struct {
+	Type string
+	Key  string
+
+	// Expected is the revision update was based on,
+	// Actual is the current revision, 0 if entity doesn't exist.
+	Expected uint64
+	Actual   uint64
+}
+
+// NewRomanaConflictError creates a RomanaConflictError.
+func NewRomanaConflictError(t string, key string, expected uint64, actual uint64) RomanaConflictError {
+	return RomanaConflictError{Type: t, Key: key, Expected: expected, Actual: actual}
+}
+
+func (rce RomanaConflictError) Error() string {
+	return fmt.Sprintf("%s %s was modified concurrently: expected revision %d, current revision %d",
+		rce.Type, rce.Key, rce.Expected, rce.Actual)
+}
+
+// IsConflict returns true if err is a RomanaConflictError.
+func IsConflict(err error) bool {
+	_, ok := err.(RomanaConflictError)
+	return ok
+}
+
+// RomanaQuotaExceededError represents an error when an entity
+// would get more of something, e.g. addresses, than its quota
+// or capacity allows.
+type RomanaQuotaExceededError struct {
+	Type    string
+	Name    string
+	Message string
+}
+
+// NewRomanaQuotaExceededError creates a RomanaQuotaExceededError.
+func NewRomanaQuotaExceededError(message string, t string, name string) RomanaQuotaExceededError {
+	return RomanaQuotaExceededError{Type: t, Name: name, Message: message}
+}
+
+func (rqe RomanaQuotaExceededError) Error() string {
+	if rqe.Message == "" {
+		return fmt.Sprintf("Quota of %s %s exceeded", rqe.Type, rqe.Name)
+	}
+	return rqe.Message
+}
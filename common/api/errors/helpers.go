@@ -35,8 +35,11 @@ func RomanaErrorToHTTPError(err error) error {
 	case RomanaNotFoundError:
 		return common.NewError404(err.Type, fmt.Sprintf("%v", err.Attributes))
 	case RomanaExistsError:
-		common.NewErrorConflict(err)
-
+		return common.NewErrorExists(err.Error())
+	case RomanaConflictError:
+		return common.NewErrorConflict(err.Error())
+	case RomanaQuotaExceededError:
+		return common.NewErrorQuotaExceeded(err.Error())
 	}
 	return err
 }
@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"time"
+)
+
+// Types of events.
+const (
+	// EventNormal is an event of things working as expected.
+	EventNormal = "Normal"
+
+	// EventWarning is an event of something that failed,
+	// or may need attention.
+	EventWarning = "Warning"
+)
+
+// Kinds of objects events are about, see EventObject.
+const (
+	EventKindAddress  = "address"
+	EventKindHost     = "host"
+	EventKindTopology = "topology"
+	EventKindPolicy   = "policy"
+	EventKindPod      = "pod"
+)
+
+// Event records something that happened to an object of the cluster,
+// e.g. an address was allocated or routes of a host failed, so that
+// it can be told why the cluster is the way it is without searching
+// logs of components. Repeats of an event by the same component are
+// kept as one event, Count of which is the number of times it
+// happened, first at Time and last at LastTime.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Reason    string      `json:"reason"`
+	Component string      `json:"component"`
+	Host      string      `json:"host,omitempty"`
+	Object    EventObject `json:"object"`
+	Message   string      `json:"message,omitempty"`
+	Time      time.Time   `json:"time"`
+	LastTime  time.Time   `json:"last_time"`
+	Count     int         `json:"count"`
+}
+
+// EventObject is the object an event is about, e.g. kind
+// EventKindAddress and name of the address.
+type EventObject struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// String returns kind and name of the object as kind/name,
+// or just the kind for objects without name, e.g. topology.
+func (o EventObject) String() string {
+	if o.Name == "" {
+		return o.Kind
+	}
+	return o.Kind + "/" + o.Name
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"time"
+)
+
+// FederationPool is an address space shared by federated clusters.
+// The parent romanad delegates it to clusters in super-blocks of
+// SuperBlockMask bits, which clusters use as CIDRs of networks of
+// their topologies and allocate addresses of with their own IPAM,
+// so that addresses of pods never overlap across clusters.
+type FederationPool struct {
+	Name           string `json:"name"`
+	CIDR           string `json:"cidr"`
+	SuperBlockMask uint   `json:"super_block_mask"`
+}
+
+// FederationPoolResponse is a pool as listed by the parent
+// romanad, with aggregate utilization of its super-blocks.
+type FederationPoolResponse struct {
+	FederationPool
+
+	// SuperBlocks is number of super-blocks in the pool,
+	// Delegated is number of them delegated to clusters.
+	SuperBlocks uint64 `json:"super_blocks"`
+	Delegated   int    `json:"delegated"`
+
+	// Size is number of addresses in delegated super-blocks,
+	// Allocated is number of them clusters reported allocated.
+	Size      uint64 `json:"size"`
+	Allocated int    `json:"allocated"`
+}
+
+// Delegation is a super-block of a pool delegated to a cluster,
+// with utilization the cluster reported last.
+type Delegation struct {
+	Pool    string `json:"pool"`
+	Cluster string `json:"cluster"`
+	CIDR    IPNet  `json:"cidr"`
+
+	// Networks are networks of the cluster's topology
+	// in the super-block.
+	Networks  []string  `json:"networks,omitempty"`
+	Allocated int       `json:"allocated"`
+	Reported  time.Time `json:"reported"`
+}
+
+// DelegationRequest asks the parent romanad to delegate
+// a super-block of the pool to the cluster.
+type DelegationRequest struct {
+	Pool    string `json:"pool"`
+	Cluster string `json:"cluster"`
+}
+
+// ClusterUsage is reported by romanad of a federated cluster to
+// the parent, with networks of its topology and their utilization.
+type ClusterUsage struct {
+	Networks []IPAMNetworkResponse `json:"networks"`
+}
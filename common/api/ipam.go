@@ -18,6 +18,9 @@ package api
 import (
 	"fmt"
 	"net"
+	"time"
+
+	"github.com/romana/core/common"
 )
 
 // TODO should this really be kept alongside BlocksResponse?
@@ -41,17 +44,150 @@ type IPAMAddressRequest struct {
 	Host    string `json:"host"`
 	Tenant  string `json:"tenant"`
 	Segment string `json:"segment"`
+	// Zone is preferred for the address: it is allocated in a network
+	// where the host is in a group of the zone, if there is one.
+	Zone string `json:"zone,omitempty"`
+	// RequireZone fails allocation if no network of the tenant
+	// has the host in a group of Zone.
+	RequireZone bool `json:"require_zone,omitempty"`
 }
 
 type IPAMNetworkResponse struct {
 	Revision int    `json:"revision"`
 	Name     string `json:"id"`
 	CIDR     IPNet  `json:"cidr"`
+
+	// Size is number of addresses in the network.
+	Size uint64 `json:"size,omitempty"`
+
+	// Allocated is number of addresses allocated in the network.
+	Allocated int `json:"allocated,omitempty"`
+
+	// BlackedOut is number of addresses that are blacked out.
+	BlackedOut uint64 `json:"blacked_out,omitempty"`
+
+	// Blocks is number of blocks allocated in the network.
+	Blocks int `json:"blocks,omitempty"`
+}
+
+// IPAMAddress is an allocated address, it is listed by and
+// imported to IPAM with the /addresses endpoints.
+type IPAMAddress struct {
+	Name    string `json:"name"`
+	IP      net.IP `json:"ip"`
+	Network string `json:"network,omitempty"`
+	Host    string `json:"host"`
+	Tenant  string `json:"tenant"`
+	Segment string `json:"segment"`
+}
+
+// IPAMImportResponse tells how many addresses were imported to IPAM
+// with the /addresses endpoint, and how many were skipped as they
+// were allocated at the same IPs already.
+type IPAMImportResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+	// Addresses are the addresses imported.
+	Addresses []IPAMAddress `json:"addresses,omitempty"`
+}
+
+// TenantDefinition is a tenant managed by romanad, tenants don't
+// have to be defined to get addresses allocated, but quotas only
+// apply to defined tenants.
+type TenantDefinition struct {
+	Name string `json:"name"`
+	// ExternalID is ID of the tenant in the platform,
+	// e.g. UUID of Kubernetes namespace or OpenStack project.
+	ExternalID string `json:"external_id,omitempty"`
+	// DefaultDeny tells that endpoints of the tenant are only
+	// reachable as allowed by policies.
+	DefaultDeny bool `json:"default_deny,omitempty"`
+	// MaxAddresses is how many addresses can be allocated
+	// to the tenant, 0 means unlimited.
+	MaxAddresses int `json:"max_addresses,omitempty"`
+}
+
+// TenantResponse is a tenant as listed by romanad, tenants that
+// have addresses allocated but are not defined are listed too.
+type TenantResponse struct {
+	TenantDefinition
+	Defined   bool `json:"defined"`
+	Addresses int  `json:"addresses"`
+	Blocks    int  `json:"blocks"`
+}
+
+// SegmentDefinition is a segment of the tenant managed by romanad,
+// like tenants, segments don't have to be defined to get addresses
+// allocated.
+type SegmentDefinition struct {
+	Tenant string `json:"tenant"`
+	Name   string `json:"name"`
+}
+
+// SegmentResponse is a segment as listed by romanad, along with
+// blocks and policies that reference it.
+type SegmentResponse struct {
+	SegmentDefinition
+	Defined   bool     `json:"defined"`
+	Addresses int      `json:"addresses"`
+	Blocks    []string `json:"blocks,omitempty"`
+	Policies  []string `json:"policies,omitempty"`
+}
+
+// IPAMBlackOutRequest blacks out CIDR, so that no addresses
+// are allocated from it.
+type IPAMBlackOutRequest struct {
+	CIDR string `json:"cidr"`
+}
+
+// TopologyPlan is the outcome of a topology update computed
+// without applying it.
+type TopologyPlan struct {
+	// Topology is the updated topology with CIDRs of its groups.
+	Topology TopologyUpdateRequest `json:"topology"`
+	// Conflicts are allocated addresses that don't fit the updated
+	// topology, the update fails unless they are deallocated first.
+	Conflicts []AddressConflict `json:"conflicts,omitempty"`
+	// Changes are changes of networks and hosts the update makes
+	// to the current topology, set by dry run of topology update.
+	Changes []TopologyChange `json:"changes,omitempty"`
+	// Hosts are groups hosts are placed in by the updated topology.
+	Hosts []HostPlacement `json:"hosts,omitempty"`
+}
+
+// TopologyChange is a change of network or host made by topology update.
+type TopologyChange struct {
+	// Change is one of "add", "remove" or "modify".
+	Change  string `json:"change"`
+	Network string `json:"network"`
+	// Host is empty for changes of the network itself.
+	Host  string `json:"host,omitempty"`
+	Field string `json:"field,omitempty"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// HostPlacement is the group of a network a host is placed in.
+type HostPlacement struct {
+	Host    string `json:"host"`
+	IP      net.IP `json:"ip"`
+	Network string `json:"network"`
+	// Group is the name of the group, or its CIDR if the group is unnamed.
+	Group     string `json:"group"`
+	GroupCIDR string `json:"group_cidr"`
+}
+
+// AddressConflict is an allocated address that can't be kept
+// by a topology update.
+type AddressConflict struct {
+	Address IPAMAddress `json:"address"`
+	Error   string      `json:"error"`
 }
 
 type IPAMBlocksResponse struct {
-	Revision int                 `json:"revision"`
-	Blocks   []IPAMBlockResponse `json:"blocks"`
+	Revision   int                 `json:"revision"`
+	Blocks     []IPAMBlockResponse `json:"blocks"`
+	BlackedOut []IPNet             `json:"blacked_out,omitempty"`
 }
 
 type IPAMBlockResponse struct {
@@ -61,11 +197,40 @@ type IPAMBlockResponse struct {
 	Segment          string `json:"segment"`
 	Host             string `json:"host"`
 	AllocatedIPCount int    `json:"allocated_ip_count"`
+	// Group is the name of the group owning the block,
+	// or its CIDR if the group is unnamed.
+	Group string `json:"group,omitempty"`
+	// GroupCIDR is the CIDR of the group owning the block,
+	// routes to blocks of a group are aggregated into it.
+	GroupCIDR string `json:"group_cidr,omitempty"`
+	Network   string `json:"network,omitempty"`
+	// Interface selector of the block's network, see NetworkDefinition.
+	Interface string `json:"interface,omitempty"`
+	// Route table of the block's network, see NetworkDefinition.
+	RouteTable int `json:"route_table,omitempty"`
+	// NextHops of the block's group, see GroupOrHost.
+	NextHops []net.IP `json:"next_hops,omitempty"`
 }
 
 type TopologyUpdateRequest struct {
 	Networks   []NetworkDefinition  `json:"networks"`
 	Topologies []TopologyDefinition `json:"topologies"`
+
+	// Revision is topology revision the update is based on, update
+	// fails with a conflict if topology was updated since. Zero
+	// means topology is replaced regardless of its revision.
+	Revision int `json:"revision,omitempty"`
+}
+
+// FieldError describes a problem with a field of a request,
+// Path is the field's JSON path, e.g. "networks[1].cidr".
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) String() string {
+	return e.Path + ": " + e.Message
 }
 
 type NetworkDefinition struct {
@@ -74,6 +239,17 @@ type NetworkDefinition struct {
 	BlockMask uint   `json:"block_mask"`
 	// List of allowed tenants.
 	Tenants []string `json:"tenants,omitempty"`
+	// Host interface which carries traffic for this network,
+	// e.g. "eth1", "cidr=192.168.10.0/24" or "label=data".
+	// If empty, agents use their default interface.
+	Interface string `json:"interface,omitempty"`
+	// MTU of the interfaces created for endpoints in this network,
+	// if 0, MTU is either auto-detected or defaults to 1500.
+	MTU int `json:"mtu,omitempty"`
+	// Kernel routing table dedicated to this network, agents install
+	// routes to the network's blocks there and look up traffic coming
+	// from its local blocks in it. If 0, romana table is used.
+	RouteTable int `json:"route_table,omitempty"`
 }
 
 type TopologyDefinition struct {
@@ -87,11 +263,27 @@ type GroupOrHost struct {
 	Assignment map[string]string `json:"assignment,omitempty"`
 	Routing    string            `json:"routing,omitempty"`
 	Groups     []GroupOrHost     `json:"groups,omitempty"`
+	// Zone is the failure domain of the group, e.g. availability
+	// zone of a cloud, subgroups are in the zone of their parent
+	// unless they have one of their own.
+	Zone string `json:"zone,omitempty"`
+	// NextHops are addresses hosts outside of the group route its
+	// blocks via, e.g. a pair of routers hosts of the group are
+	// behind, or addresses of a multi-homed host. Agents program
+	// multipath routes over next hops that are alive. Subgroups
+	// are routed via next hops of their parent unless they have
+	// their own.
+	NextHops []net.IP `json:"next_hops,omitempty"`
 
 	// If the below are specified, this GroupSpec really represents a host,
 	// therefore the above elements MUST NOT be specified.
 	Name string `json:"name"`
 	IP   net.IP `json:"ip,omitempty"`
+	// Tags, Capacity and Cordoned are as in Host, they are kept
+	// when topology is read and applied back.
+	Tags     map[string]string `json:"tags,omitempty"`
+	Capacity int               `json:"capacity,omitempty"`
+	Cordoned bool              `json:"cordoned,omitempty"`
 
 	// This is ignored on import.
 	CIDR string `json:"cidr,omitempty"`
@@ -108,6 +300,12 @@ type Host struct {
 	// TODO this is a placeholder for now so that agent builds
 	Tags    map[string]string      `json:"tags"`
 	K8SInfo map[string]interface{} `json:"k8s_info"`
+	// Capacity is the maximum number of addresses allocated
+	// on the host, 0 means no limit.
+	Capacity int `json:"capacity,omitempty"`
+	// Cordoned hosts keep addresses allocated on them, but
+	// no new ones are allocated, e.g. while they are replaced.
+	Cordoned bool `json:"cordoned,omitempty"`
 }
 
 func (h Host) String() string {
@@ -149,3 +347,61 @@ func (ip *IPNet) UnmarshalText(text []byte) error {
 	*ip = IPNet{*ipnet}
 	return nil
 }
+
+// VersionResponse is build information of romanad and
+// versions of agents that are alive.
+type VersionResponse struct {
+	Romanad common.VersionInfo `json:"romanad"`
+	Agents  []AgentVersion     `json:"agents"`
+}
+
+type AgentVersion struct {
+	Host    string `json:"host"`
+	Version string `json:"version"`
+}
+
+// ClusterStats summarizes state of the cluster for `romana stats`.
+type ClusterStats struct {
+	Hosts     int `json:"hosts"`
+	LiveHosts int `json:"live_hosts"`
+	Endpoints int `json:"endpoints"`
+	Policies  int `json:"policies"`
+	// BlocksRevision is the revision of blocks agents converge to.
+	BlocksRevision int            `json:"blocks_revision"`
+	Networks       []NetworkStats `json:"networks"`
+	Tenants        []TenantStats  `json:"tenants"`
+	Agents         []AgentStats   `json:"agents"`
+}
+
+// NetworkStats is utilization of a network.
+type NetworkStats struct {
+	Name      string `json:"name"`
+	CIDR      string `json:"cidr"`
+	Blocks    int    `json:"blocks"`
+	Allocated int    `json:"allocated"`
+	// Utilization is percentage of addresses
+	// of the network that are allocated.
+	Utilization float64 `json:"utilization"`
+}
+
+// TenantStats is number of endpoints of the tenant,
+// and number of policies applied to them.
+type TenantStats struct {
+	Name      string `json:"name"`
+	Endpoints int    `json:"endpoints"`
+	Policies  int    `json:"policies"`
+}
+
+// AgentStats tells how far a live agent is behind on
+// programming routes for blocks.
+type AgentStats struct {
+	Host    string    `json:"host"`
+	Version string    `json:"version"`
+	Renewed time.Time `json:"renewed"`
+	// BlocksRevision is the revision of blocks the
+	// agent has programmed routes for, at Converged.
+	BlocksRevision int       `json:"blocks_revision"`
+	Converged      time.Time `json:"converged,omitempty"`
+	// Lag is how many revisions of blocks agent is behind.
+	Lag int `json:"lag"`
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package api
+
+// NeutronSubnet is a subnet of OpenStack Neutron allocated by romana
+// IPAM driver of Neutron. The subnet is a romana network, the one with
+// its CIDR, or for requests of any subnet the first network of the
+// tenant with PrefixLen bits long mask, so that Neutron ports get
+// topology aware addresses from the same networks as pods.
+type NeutronSubnet struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenant_id"`
+
+	// CIDR of the subnet, PrefixLen is only used
+	// when subnet is requested without CIDR.
+	CIDR      string `json:"cidr,omitempty"`
+	PrefixLen int    `json:"prefixlen,omitempty"`
+
+	// GatewayIP is never allocated for any address requests.
+	GatewayIP string `json:"gateway_ip,omitempty"`
+
+	// AllocationPools are ranges of addresses allocated for any
+	// address requests, the whole subnet but its network, broadcast
+	// and gateway addresses if empty. Specific addresses may be
+	// allocated outside of allocation pools, as in Neutron.
+	AllocationPools []NeutronAllocationPool `json:"allocation_pools,omitempty"`
+
+	// Network is the romana network of the subnet.
+	Network string `json:"network,omitempty"`
+}
+
+// NeutronAllocationPool is a range of addresses from Start to End.
+type NeutronAllocationPool struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// NeutronAddressRequest requests an address of the port bound to the
+// host, a specific one if IPAddress is set and any one otherwise.
+type NeutronAddressRequest struct {
+	IPAddress string `json:"ip_address,omitempty"`
+	PortID    string `json:"port_id,omitempty"`
+	Host      string `json:"host"`
+	Segment   string `json:"segment,omitempty"`
+}
+
+// NeutronAddressResponse is the address allocated in the subnet,
+// Name is the name of the address in romana IPAM.
+type NeutronAddressResponse struct {
+	SubnetID  string `json:"subnet_id"`
+	IPAddress string `json:"ip_address"`
+	Name      string `json:"name"`
+}
@@ -100,6 +100,9 @@ type Policy struct {
 	// Datacenter describes a Romana deployment.
 	AppliedTo []Endpoint      `json:"applied_to,omitempty"`
 	Ingress   []RomanaIngress `json:"ingress,omitempty"`
+	// Bandwidth limits traffic of every endpoint the policy
+	// is applied to, unless endpoint requests its own limits.
+	Bandwidth *Bandwidth `json:"bandwidth,omitempty"`
 	//	Tags       []Tag      `json:"tags,omitempty"`
 }
 
@@ -111,3 +114,30 @@ type RomanaIngress struct {
 func (p Policy) String() string {
 	return common.String(p)
 }
+
+// Results of applying policies in bulk, see PolicyResult.
+const (
+	PolicyCreated   = "created"
+	PolicyUpdated   = "updated"
+	PolicyUnchanged = "unchanged"
+	PolicyInvalid   = "invalid"
+)
+
+// PolicyEvent is a change of a policy streamed by GET /watch/policies,
+// Type is one of PolicyCreated, PolicyUpdated and PolicyDeleted.
+type PolicyEvent struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Policy Policy `json:"policy"`
+}
+
+// PolicyDeleted is type of PolicyEvent of deleted policy,
+// which holds the policy as it was before deletion.
+const PolicyDeleted = "deleted"
+
+// PolicyResult is result of applying a policy by PUT /policies.
+type PolicyResult struct {
+	ID     string `json:"id"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,334 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to
+// auditing mutating API calls.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	log "github.com/romana/rlog"
+)
+
+const (
+	// DefaultAuditMaxSize is the size in megabytes
+	// the audit file is rotated at.
+	DefaultAuditMaxSize = 100
+
+	// DefaultAuditMaxBackups is the number of rotated
+	// audit files kept.
+	DefaultAuditMaxBackups = 10
+
+	// DefaultAuditMaxAge is how long audit records are kept.
+	DefaultAuditMaxAge = 90 * 24 * time.Hour
+
+	// auditBackupTime is the format of the time suffix of
+	// rotated audit files, which sorts in the order of time.
+	auditBackupTime = "20060102T150405.000000000"
+)
+
+// AuditRecord is a record of a mutating API call: who made it,
+// what it changed and what the result was.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Service   string    `json:"service"`
+	RequestID string    `json:"request_id"`
+
+	// User is the user of the token, empty for anonymous
+	// requests, and Client is the address they came from.
+	User   string `json:"user,omitempty"`
+	Client string `json:"client"`
+
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Route  string `json:"route"`
+
+	// Request is SHA-256 digest of the request body. Before and
+	// After are SHA-256 digests of the resource at the path, as
+	// the service returns it to GET, before and after the call,
+	// empty when it can't be read, e.g. it doesn't exist.
+	Request string `json:"request,omitempty"`
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+
+	Status int `json:"status"`
+}
+
+// AuditSink is where audit records are appended to.
+type AuditSink interface {
+	WriteAudit(record AuditRecord) error
+}
+
+// AuditStorer is implemented by services that can keep audit
+// records under a prefix of the store, records older than maxAge
+// are removed, zero means never.
+type AuditStorer interface {
+	AuditStore(prefix string, maxAge time.Duration) (AuditSink, error)
+}
+
+// auditMiddleware appends records of mutating API calls to audit
+// sinks. It follows the authentication middleware, so that the user
+// of the call is known.
+type auditMiddleware struct {
+	service string
+	router  *mux.Router
+	sinks   []AuditSink
+}
+
+func newAuditMiddleware(service Service, config Audit, router *mux.Router) (auditMiddleware, error) {
+	m := auditMiddleware{service: service.Name(), router: router}
+	if config.File != "" {
+		file, err := newAuditFile(config.File, config.MaxSize, config.MaxBackups, config.MaxAge)
+		if err != nil {
+			return m, err
+		}
+		m.sinks = append(m.sinks, file)
+	}
+	if config.EtcdPrefix != "" {
+		storer, ok := service.(AuditStorer)
+		if !ok {
+			return m, NewError("Service %s can't keep audit records in the store", service.Name())
+		}
+		store, err := storer.AuditStore(config.EtcdPrefix, config.MaxAge)
+		if err != nil {
+			return m, err
+		}
+		m.sinks = append(m.sinks, store)
+	}
+	return m, nil
+}
+
+func (m auditMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	switch request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		next(writer, request)
+		return
+	}
+
+	record := AuditRecord{
+		Service:   m.service,
+		RequestID: RequestID(request),
+		Client:    request.RemoteAddr,
+		Method:    request.Method,
+		Path:      request.URL.Path,
+		Route:     routeLabel(m.router, request),
+	}
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		record.Client = host
+	}
+	user, _ := context.Get(request, ContextKeyUser).(User)
+	record.User = user.Username
+	if body, ok := context.Get(request, ContextKeyOriginalBody).([]byte); ok {
+		record.Request = auditDigest(body)
+	}
+
+	record.Before = m.resourceDigest(request, user)
+	next(writer, request)
+	record.After = m.resourceDigest(request, user)
+
+	record.Status = http.StatusOK
+	if rw, ok := writer.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+		record.Status = rw.Status()
+	}
+	record.Time = time.Now().UTC()
+	for _, sink := range m.sinks {
+		if err := sink.WriteAudit(record); err != nil {
+			log.Errorf("Failed to write audit record of request %s: %s", record.RequestID, err)
+		}
+	}
+}
+
+// resourceDigest returns digest of the resource at the path of the
+// request, as the service returns it to GET by the user, or empty
+// string if the path can't be read.
+func (m auditMiddleware) resourceDigest(request *http.Request, user User) string {
+	get, err := http.NewRequest(http.MethodGet, request.URL.String(), nil)
+	if err != nil {
+		return ""
+	}
+	var match mux.RouteMatch
+	if !m.router.Match(get, &match) || match.MatchErr != nil {
+		return ""
+	}
+
+	context.Set(get, ContextKeyUser, user)
+	defer context.Clear(get)
+	recorder := &digestRecorder{header: http.Header{}, hash: sha256.New()}
+	recorder.header.Set(HeaderContentType, "application/json")
+	m.router.ServeHTTP(recorder, get)
+	if recorder.status != http.StatusOK {
+		return ""
+	}
+	return hex.EncodeToString(recorder.hash.Sum(nil))
+}
+
+// auditDigest returns hex encoded SHA-256 digest of buf.
+func auditDigest(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// digestRecorder is http.ResponseWriter that keeps only status
+// and digest of the response.
+type digestRecorder struct {
+	header http.Header
+	status int
+	hash   hash.Hash
+}
+
+func (r *digestRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *digestRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *digestRecorder) Write(buf []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.hash.Write(buf)
+}
+
+// auditFile is AuditSink appending records to a file as JSON lines.
+// File is rotated when it grows over maxSize bytes by renaming it
+// with time suffix, and rotated files over maxBackups or older than
+// maxAge are removed.
+type auditFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// newAuditFile opens audit file at path for appending, maxSize
+// is in megabytes.
+func newAuditFile(path string, maxSize int, maxBackups int, maxAge time.Duration) (*auditFile, error) {
+	f := &auditFile{
+		path:       path,
+		maxSize:    int64(maxSize) * 1024 * 1024,
+		maxBackups: maxBackups,
+		maxAge:     maxAge,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	f.prune(time.Now())
+	return f, nil
+}
+
+func (f *auditFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// WriteAudit implements AuditSink.
+func (f *auditFile) WriteAudit(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(line)) > f.maxSize {
+		if err := f.rotate(time.Now()); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+// rotate renames the file with time suffix and opens a new one.
+func (f *auditFile) rotate(now time.Time) error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	backup := f.path + "." + now.UTC().Format(auditBackupTime)
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune(now)
+	return nil
+}
+
+// prune removes rotated files over maxBackups or older than maxAge.
+func (f *auditFile) prune(now time.Time) {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		log.Errorf("Failed to list rotated audit files of %s: %s", f.path, err)
+		return
+	}
+
+	var backups []string
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, f.path+".")
+		if _, err := time.Parse(auditBackupTime, suffix); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+
+	for i, backup := range backups {
+		remove := f.maxBackups > 0 && i < len(backups)-f.maxBackups
+		if f.maxAge > 0 {
+			rotated, _ := time.Parse(auditBackupTime, strings.TrimPrefix(backup, f.path+"."))
+			remove = remove || now.Sub(rotated) > f.maxAge
+		}
+		if remove {
+			if err := os.Remove(backup); err != nil {
+				log.Errorf("Failed to remove rotated audit file %s: %s", backup, err)
+			}
+		}
+	}
+}
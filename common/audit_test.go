@@ -0,0 +1,192 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/gorilla/context"
+)
+
+// auditTestService keeps names of things added by POST /things.
+type auditTestService struct {
+	mutex  sync.Mutex
+	things []string
+}
+
+type auditTestThing struct {
+	Name string `json:"name"`
+}
+
+func (s *auditTestService) Routes() Routes {
+	return Routes{
+		Route{Method: "GET", Pattern: "/things", Handler: s.listThings},
+		Route{
+			Method:      "POST",
+			Pattern:     "/things",
+			Handler:     s.addThing,
+			MakeMessage: func() interface{} { return &auditTestThing{} },
+		},
+	}
+}
+
+func (s *auditTestService) listThings(input interface{}, ctx RestContext) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.things...), nil
+}
+
+func (s *auditTestService) addThing(input interface{}, ctx RestContext) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.things = append(s.things, input.(*auditTestThing).Name)
+	return nil, nil
+}
+
+// auditTestSink keeps records written to it.
+type auditTestSink struct {
+	records []AuditRecord
+}
+
+func (s *auditTestSink) WriteAudit(record AuditRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	service := &auditTestService{}
+	router := newRouter(service.Routes())
+	sink := &auditTestSink{}
+	n := negroni.New()
+	n.Use(newRequestIDMiddleware())
+	n.Use(NewNegotiator())
+	n.Use(NewUnmarshaller())
+	n.UseFunc(func(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+		context.Set(request, ContextKeyUser, User{Username: "alice"})
+		next(writer, request)
+	})
+	n.Use(auditMiddleware{service: "audittest", router: router, sinks: []AuditSink{sink}})
+	n.UseHandler(router)
+
+	get := func() string {
+		recorder := httptest.NewRecorder()
+		n.ServeHTTP(recorder, httptest.NewRequest("GET", "/v1/things", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status %d of GET, got %d", http.StatusOK, recorder.Code)
+		}
+		return auditDigest(recorder.Body.Bytes())
+	}
+
+	before := get()
+	body := `{"name":"thing1"}`
+	request := httptest.NewRequest("POST", "/v1/things", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.RemoteAddr = "192.0.2.1:1234"
+	recorder := httptest.NewRecorder()
+	n.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d of POST, got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+	}
+	after := get()
+
+	if len(sink.records) != 1 {
+		t.Fatalf("Expected 1 audit record of POST only, got %+v", sink.records)
+	}
+	record := sink.records[0]
+	if record.Service != "audittest" || record.User != "alice" || record.Client != "192.0.2.1" ||
+		record.Method != "POST" || record.Path != "/v1/things" || record.Route != "/v1/things" ||
+		record.Status != http.StatusOK || record.RequestID == "" || record.Time.IsZero() {
+		t.Errorf("Unexpected audit record %+v", record)
+	}
+	if record.Request != auditDigest([]byte(body)) {
+		t.Errorf("Expected digest of request body, got %s", record.Request)
+	}
+	if before == after || record.Before != before || record.After != after {
+		t.Errorf("Expected digests %s before and %s after, got %s and %s", before, after, record.Before, record.After)
+	}
+}
+
+func TestAuditFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	// backups older than maxAge are removed when the file is opened.
+	old := path + "." + time.Now().Add(-2*time.Hour).UTC().Format(auditBackupTime)
+	if err := ioutil.WriteFile(old, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	unrelated := path + ".bak"
+	if err := ioutil.WriteFile(unrelated, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := newAuditFile(path, 0, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", old, err)
+	}
+
+	// file is rotated when a record doesn't fit,
+	// one rotated file is kept.
+	f.maxSize = 250
+	for _, id := range []string{"r1", "r2", "r3"} {
+		if err := f.WriteAudit(AuditRecord{RequestID: id, Method: "DELETE", Path: "/v1/hosts/host1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, _ := filepath.Glob(path + ".2*")
+	if len(backups) != 1 {
+		t.Fatalf("Expected 1 rotated file, got %v", backups)
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("Expected %s to be kept, got %v", unrelated, err)
+	}
+	for file, expect := range map[string]string{backups[0]: "r2", path: "r3"} {
+		content, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []AuditRecord
+		scanner := bufio.NewScanner(content)
+		for scanner.Scan() {
+			var record AuditRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, record)
+		}
+		content.Close()
+		if len(records) != 1 || records[0].RequestID != expect {
+			t.Errorf("Expected record %s in %s, got %+v", expect, file, records)
+		}
+	}
+}
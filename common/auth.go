@@ -19,17 +19,25 @@ package common
 
 import (
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/context"
 	log "github.com/romana/rlog"
 	cli "github.com/spf13/cobra"
 	config "github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/ssh/terminal"
 	"golang.org/x/sys/unix"
+	yaml "gopkg.in/yaml.v2"
 )
 
 const (
@@ -135,11 +143,12 @@ func NewCredential(flagSet *flag.FlagSet) *Credential {
 	return cred
 }
 
-// GetPasswd gets password from stdin.
+// GetPasswd gets password from stdin, prompting for it on stderr
+// so that stdout only has output of the command.
 func GetPasswd() (string, error) {
-	fmt.Print("Password: ")
+	fmt.Fprint(os.Stderr, "Password: ")
 	bytePassword, err := terminal.ReadPassword(unix.Stdin)
-	fmt.Println()
+	fmt.Fprintln(os.Stderr)
 	if err != nil {
 		return "", err
 	}
@@ -196,76 +205,137 @@ func (c *Credential) Initialize() error {
 // by wrapHandler(), which will provide RestContext.
 type AuthZChecker func(ctx RestContext) bool
 
+const (
+	// DefaultAuthAudience is audience of tokens unless configured.
+	DefaultAuthAudience = "romana"
+	// DefaultAuthTokenTTL is how long tokens are valid unless configured.
+	DefaultAuthTokenTTL = time.Hour
+)
+
+// authClaims are claims of tokens issued at AuthPath,
+// subject of the token is the user name.
+type authClaims struct {
+	jwt.StandardClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
+// user returns user the token was issued to.
+func (c authClaims) user() User {
+	user := User{StandardClaims: c.StandardClaims, Username: c.Subject}
+	for _, role := range c.Roles {
+		user.Roles = append(user.Roles, Role{Name: role})
+	}
+	return user
+}
+
+// AuthRequest is the message posted to AuthPath to get a token.
+type AuthRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// authUser is a user of UsersFile, which looks like
+//
+//	users:
+//	- username: admin
+//	  password_hash: $2y$10$... (bcrypt, e.g. of htpasswd -nbB)
+//	  roles: [admin]
+type authUser struct {
+	Username     string   `yaml:"username"`
+	PasswordHash string   `yaml:"password_hash"`
+	Roles        []string `yaml:"roles"`
+}
+
+// readPublicKey reads RSA public key from PEM file.
+func readPublicKey(filename string) (*rsa.PublicKey, error) {
+	block, err := ReadKeyFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, NewError("Invalid public key in %s: %s", filename, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, NewError("Key in %s is not RSA public key", filename)
+	}
+	return rsaKey, nil
+}
+
+// readPrivateKey reads RSA private key from PEM file,
+// in PKCS #1 or PKCS #8 form.
+func readPrivateKey(filename string) (*rsa.PrivateKey, error) {
+	block, err := ReadKeyFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, NewError("Invalid private key in %s: %s", filename, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, NewError("Key in %s is not RSA private key", filename)
+	}
+	return rsaKey, nil
+}
+
 // AuthMiddleware wrapper for auth.
 type AuthMiddleware struct {
 	PublicKey   *rsa.PublicKey
 	AllowedURLs []string
+	Audience    string
+	// AnonymousReads lets GET requests through without a token.
+	AnonymousReads bool
 }
 
-// NewAuthMiddleware creates new AuthMiddleware to use.
-// Its behavior depends on whether it is for root (in which case
-// the public key is gotten from the config file) or another
-// service (in which case the public key is gotten from the root).
-func NewAuthMiddleware(service Service) (AuthMiddleware, error) {
-	authMiddleware := AuthMiddleware{}
+// NewAuthMiddleware creates new AuthMiddleware to use. Requests
+// are let through as if made by admin when authentication is
+// not enabled.
+func NewAuthMiddleware(auth APIAuth) (AuthMiddleware, error) {
+	authMiddleware := AuthMiddleware{
+		// These URLs are allowed to be accessed w/o authentication,
+		// health is checked by kubelet and load balancers, and
+		// metrics are scraped by Prometheus w/o tokens.
+		AllowedURLs:    []string{AuthPath, APIDocsPath, HealthzPath, ReadyzPath, MetricsPath},
+		Audience:       auth.Audience,
+		AnonymousReads: auth.AnonymousReads,
+	}
+	if !auth.IsEnabled() {
+		return authMiddleware, nil
+	}
+	if authMiddleware.Audience == "" {
+		authMiddleware.Audience = DefaultAuthAudience
+	}
+
+	var err error
+	authMiddleware.PublicKey, err = readPublicKey(auth.PublicKeyFile)
+	if err != nil {
+		return authMiddleware, err
+	}
 	return authMiddleware, nil
-	//	var err error
-	//
-	//	// If we are in the Root service...
-	//	if service.Name() == ServiceNameRoot {
-	//		// Really it would be most convenient to just use root.Root.publicKey but that
-	//		// would create a circular import dependency.
-	//		fullConfig := config.ServiceSpecific[FullConfigKey].(Config)
-	//		rootConfig := fullConfig.Services[ServiceNameRoot].ServiceSpecific
-	//		auth, err := ToBool(rootConfig["auth"])
-	//		if err != nil {
-	//			return authMiddleware, err
-	//		}
-	//		if auth {
-	//			// If authentication is on, get the public key from local file
-	//			// and parse and store it.
-	//			publicKeyLocation := config.Common.Api.AuthPublic
-	//			log.Debugf("Creating AuthMiddleware for Root: reading public key from %s", publicKeyLocation)
-	//			data, err := ioutil.ReadFile(publicKeyLocation)
-	//			if err != nil {
-	//				return authMiddleware, err
-	//			}
-	//			key, err := jwt.ParseRSAPublicKeyFromPEM(data)
-	//			if err != nil {
-	//				log.Errorf("Error parsing RSA public key from %s: %T: %s", publicKeyLocation, err, err)
-	//				return authMiddleware, err
-	//			}
-	//			authMiddleware.PublicKey = key
-	//			// These URLs for Root are allowed to be accessed w/o authentication
-	//			authMiddleware.AllowedURLs = []string{"/", "/auth", "/publicKey"}
-	//		} else {
-	//			// If the authentication is not turned on, just
-	//			// set this to nil
-	//			authMiddleware.PublicKey = nil
-	//		}
-	//		return authMiddleware, nil
-	//	}
-	//	// This is NOT root service - in this path
-	//	// we are constructing AuthMiddleware for some other service.
-	//	// So, first, get the public key to verify tokens with
-	//	// from Root:
-	//	authMiddleware.PublicKey, err = client.GetPublicKey()
-	//	if err != nil {
-	//		return authMiddleware, err
-	//	}
-	//	return authMiddleware, nil
 }
 
 // Keyfunc implements jwt.Keyfunc (https://godoc.org/github.com/dgrijalva/jwt-go#Keyfunc)
-// by returning the public key
-func (am AuthMiddleware) Keyfunc(*jwt.Token) (interface{}, error) {
+// by returning the public key, tokens signed otherwise than with RSA are refused.
+func (am AuthMiddleware) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, NewError("Unexpected signing method %v", token.Header["alg"])
+	}
 	return am.PublicKey, nil
 }
 
 // ServeHTTP implements the middleware contract as follows:
 //  1. If the path of request is one of the AllowedURLs, then this is a no-op.
-//  2 Otherwise, checks token from request. If the token is not valid,
-//  returns a 403 FORBIDDEN status.
+//  2. Otherwise, checks token from Authorization header of the request,
+//     with or without Bearer prefix. If the token is missing or not valid,
+//     returns a 401 UNAUTHORIZED status.
+//  3. Requests other than GET, HEAD and OPTIONS are only let through for
+//     admin and service roles, others get 403 FORBIDDEN status.
 func (am AuthMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
 	for _, url := range am.AllowedURLs {
 		if request.URL.Path == url {
@@ -279,35 +349,172 @@ func (am AuthMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Req
 		}
 	}
 
-	contentType := writer.Header().Get("Content-Type")
-	marshaller := ContentTypeMarshallers[contentType]
-
 	if am.PublicKey == nil {
 		// If PublicKey is nil, it means auth is not on. So for simplicity,
 		// say that any user is admin.
 		context.Set(request, ContextKeyUser, DefaultAdminUser)
-	} else {
-		headerToken := request.Header.Get("Authorization")
-		user := &User{}
-		token, err := jwt.ParseWithClaims(headerToken, user, am.Keyfunc)
-		log.Debugf("Token received from %s: %v", headerToken, token)
-
-		if err != nil {
-			writer.WriteHeader(http.StatusForbidden)
-			httpErr := NewHttpError(http.StatusForbidden, fmt.Sprintf("Error accessing %s: %s", request.URL.Path, err.Error()))
-			outData, _ := marshaller.Marshal(httpErr)
-			writer.Write(outData)
-			return
-		}
-		if !token.Valid {
-			writer.WriteHeader(http.StatusForbidden)
-			httpErr := NewHttpError(http.StatusForbidden, fmt.Sprintf("Invalid token in request to %s", request.URL.Path))
-			outData, _ := marshaller.Marshal(httpErr)
-			writer.Write(outData)
+		next(writer, request)
+		return
+	}
+
+	contentType := writer.Header().Get("Content-Type")
+	marshaller := ContentTypeMarshallers[contentType]
+	if marshaller == nil {
+		marshaller = ContentTypeMarshallers["application/json"]
+	}
+
+	readOnly := request.Method == http.MethodGet || request.Method == http.MethodHead ||
+		request.Method == http.MethodOptions
+	headerToken := request.Header.Get("Authorization")
+	headerToken = strings.TrimSpace(strings.TrimPrefix(headerToken, "Bearer "))
+	if headerToken == "" {
+		if readOnly && am.AnonymousReads {
+			context.Set(request, ContextKeyUser, User{})
+			next(writer, request)
 			return
 		}
-		log.Debugf("Token parsed: %+v vs %+v", token.Claims, user)
-		context.Set(request, ContextKeyUser, *user)
+		writeHttpError(writer, request, marshaller, http.StatusUnauthorized,
+			fmt.Sprintf("Token required to access %s", request.URL.Path))
+		return
+	}
+
+	claims := &authClaims{}
+	token, err := jwt.ParseWithClaims(headerToken, claims, am.Keyfunc)
+	if err != nil {
+		writeHttpError(writer, request, marshaller, http.StatusUnauthorized,
+			fmt.Sprintf("Error accessing %s: %s", request.URL.Path, err))
+		return
+	}
+	if !token.Valid || !claims.VerifyAudience(am.Audience, true) {
+		writeHttpError(writer, request, marshaller, http.StatusUnauthorized,
+			fmt.Sprintf("Invalid token in request to %s", request.URL.Path))
+		return
+	}
+
+	user := claims.user()
+	log.Debugf("Token of %s parsed: %+v", user.Username, claims)
+	if !readOnly && !user.hasRole(RoleAdmin, RoleService) {
+		writeHttpError(writer, request, marshaller, http.StatusForbidden,
+			fmt.Sprintf("User %s is not allowed to %s %s", user.Username, request.Method, request.URL.Path))
+		return
 	}
+	context.Set(request, ContextKeyUser, user)
 	next(writer, request)
 }
+
+// hasRole returns true if the user has any of the roles.
+func (u User) hasRole(roles ...string) bool {
+	for _, r := range u.Roles {
+		for _, name := range roles {
+			if r.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeHttpError writes out error with the status.
+func writeHttpError(writer http.ResponseWriter, request *http.Request, m Marshaller, status int, details string) {
+	writeError(writer, request, m, NewHttpError(status, details))
+}
+
+// tokenIssuer issues tokens to users of UsersFile.
+type tokenIssuer struct {
+	key      *rsa.PrivateKey
+	users    map[string]authUser
+	audience string
+	ttl      time.Duration
+}
+
+// newTokenIssuer reads keys and users of auth.
+func newTokenIssuer(auth APIAuth) (*tokenIssuer, error) {
+	key, err := readPrivateKey(auth.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(auth.UsersFile)
+	if err != nil {
+		return nil, err
+	}
+	var usersFile struct {
+		Users []authUser `yaml:"users"`
+	}
+	if err := yaml.Unmarshal(data, &usersFile); err != nil {
+		return nil, NewError("Invalid users file %s: %s", auth.UsersFile, err)
+	}
+
+	ti := &tokenIssuer{
+		key:      key,
+		users:    make(map[string]authUser),
+		audience: auth.Audience,
+		ttl:      auth.TokenTTL,
+	}
+	if ti.audience == "" {
+		ti.audience = DefaultAuthAudience
+	}
+	if ti.ttl <= 0 {
+		ti.ttl = DefaultAuthTokenTTL
+	}
+	for _, u := range usersFile.Users {
+		if u.Username == "" || u.PasswordHash == "" {
+			return nil, NewError("User without username or password_hash in %s", auth.UsersFile)
+		}
+		ti.users[u.Username] = u
+	}
+	return ti, nil
+}
+
+// issue returns token signed for the user.
+func (ti *tokenIssuer) issue(u authUser) (string, error) {
+	now := time.Now()
+	claims := authClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   u.Username,
+			Audience:  ti.audience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ti.ttl).Unix(),
+		},
+		Roles: u.Roles,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(ti.key)
+}
+
+// authenticate handles AuthPath, it returns token for the
+// user if password matches.
+func (ti *tokenIssuer) authenticate(input interface{}, ctx RestContext) (interface{}, error) {
+	req := input.(*AuthRequest)
+	u, ok := ti.users[req.Username]
+	if !ok || bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(req.Password)) != nil {
+		return nil, NewHttpError(http.StatusUnauthorized, "Invalid username or password")
+	}
+
+	token, err := ti.issue(u)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&ti.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return AuthTokenMessage{
+		Token:     token,
+		PublicKey: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}),
+	}, nil
+}
+
+// authRoute returns route issuing tokens at AuthPath.
+func authRoute(auth APIAuth) (Route, error) {
+	ti, err := newTokenIssuer(auth)
+	if err != nil {
+		return Route{}, err
+	}
+	return Route{
+		Method:      http.MethodPost,
+		Pattern:     AuthPath,
+		Handler:     ti.authenticate,
+		MakeMessage: func() interface{} { return &AuthRequest{} },
+		Unversioned: true,
+	}, nil
+}
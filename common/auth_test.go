@@ -0,0 +1,167 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeAuthFiles writes keys and users file for tests to dir.
+func writeAuthFiles(t *testing.T, dir string) APIAuth {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := APIAuth{
+		PublicKeyFile:  filepath.Join(dir, "public.pem"),
+		PrivateKeyFile: filepath.Join(dir, "private.pem"),
+		UsersFile:      filepath.Join(dir, "users.yaml"),
+		Audience:       "test",
+	}
+	files := map[string][]byte{
+		auth.PublicKeyFile: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}),
+		auth.PrivateKeyFile: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		auth.UsersFile: []byte(fmt.Sprintf("users:\n"+
+			"- username: admin\n  password_hash: %s\n  roles: [admin]\n"+
+			"- username: viewer\n  password_hash: %s\n", hash, hash)),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return auth
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romana-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	auth := writeAuthFiles(t, dir)
+
+	ti, err := newTokenIssuer(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := func(username, password string) string {
+		out, err := ti.authenticate(&AuthRequest{Username: username, Password: password}, RestContext{})
+		if err != nil {
+			t.Fatalf("failed to authenticate %s: %s", username, err)
+		}
+		return out.(AuthTokenMessage).Token
+	}
+	if _, err := ti.authenticate(&AuthRequest{Username: "admin", Password: "wrong"}, RestContext{}); err == nil {
+		t.Fatal("expected wrong password to be refused")
+	}
+	adminToken := token("admin", "secret")
+	viewerToken := token("viewer", "secret")
+
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodRS256, authClaims{
+		StandardClaims: jwt.StandardClaims{Subject: "admin", Audience: "test",
+			ExpiresAt: time.Now().Add(-time.Minute).Unix()},
+		Roles: []string{RoleAdmin},
+	}).SignedString(ti.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherAudience := ti.audience
+	ti.audience = "other"
+	otherToken := token("admin", "secret")
+	ti.audience = otherAudience
+
+	am, err := NewAuthMiddleware(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous := am
+	anonymous.AnonymousReads = true
+
+	for i, tc := range []struct {
+		am     AuthMiddleware
+		method string
+		path   string
+		token  string
+		expect int
+	}{
+		{am, "GET", "/hosts", "", http.StatusUnauthorized},
+		{anonymous, "GET", "/hosts", "", http.StatusOK},
+		{anonymous, "POST", "/hosts", "", http.StatusUnauthorized},
+		{am, "POST", AuthPath, "", http.StatusOK},
+		{am, "GET", APIDocsPath, "", http.StatusOK},
+		{am, "GET", HealthzPath, "", http.StatusOK},
+		{am, "GET", MetricsPath, "", http.StatusOK},
+		{am, "POST", "/hosts", "Bearer " + adminToken, http.StatusOK},
+		{am, "DELETE", "/hosts/h1", adminToken, http.StatusOK},
+		{am, "GET", "/hosts", "Bearer " + viewerToken, http.StatusOK},
+		{am, "POST", "/hosts", "Bearer " + viewerToken, http.StatusForbidden},
+		{am, "GET", "/hosts", "Bearer " + expired, http.StatusUnauthorized},
+		{am, "GET", "/hosts", "Bearer " + otherToken, http.StatusUnauthorized},
+		{am, "GET", "/hosts", "Bearer garbage", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", tc.token)
+		}
+		rec := httptest.NewRecorder()
+		tc.am.ServeHTTP(rec, req, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		if rec.Code != tc.expect {
+			t.Errorf("%d: expected %d for %s %s, got %d: %s", i, tc.expect, tc.method, tc.path, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestAuthMiddlewareDisabled(t *testing.T) {
+	am, err := NewAuthMiddleware(APIAuth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/hosts", nil)
+	rec := httptest.NewRecorder()
+	am.ServeHTTP(rec, req, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected requests to be let through, got %d", rec.Code)
+	}
+}
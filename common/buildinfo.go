@@ -19,6 +19,7 @@ package common
 
 import (
 	"fmt"
+	"runtime"
 )
 
 // Build Information and Timestamp.
@@ -33,13 +34,33 @@ import (
 //
 // go build -ldflags \
 // "-X github.com/romana/core/common.buildInfo=`git describe --always` \
+// -X github.com/romana/core/common.buildCommit=`git rev-parse HEAD` \
 // -X github.com/romana/core/common.buildTimeStamp=`date -u '+%Y-%m-%d_%I:%M:%S%p'`" \
 // main.go
 //
 var buildInfo = "No Build Information Provided"
+var buildCommit = ""
 var buildTimeStamp = "No Build Time Provided"
 
+// VersionInfo is build information of a romana component.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit,omitempty"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
 // BuildInfo return build revision and time string.
 func BuildInfo() string {
 	return fmt.Sprintf("Build Revision: %s\nBuild Time: %s", buildInfo, buildTimeStamp)
 }
+
+// GetVersionInfo returns build information of the executable.
+func GetVersionInfo() VersionInfo {
+	return VersionInfo{
+		Version:   buildInfo,
+		GitCommit: buildCommit,
+		BuildTime: buildTimeStamp,
+		GoVersion: runtime.Version(),
+	}
+}
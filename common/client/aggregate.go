@@ -0,0 +1,165 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"net"
+	"sort"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+)
+
+// AggregateBlocks returns blocks to be routed, with blocks of each
+// group aggregated into fewer prefixes, so that agents program and
+// route publishers advertise fewer routes:
+//
+//   - blocks of a group that are all on one host are replaced by
+//     a single block with the CIDR of the group,
+//   - otherwise sibling blocks on the same host are collapsed into
+//     their common prefix, as long as they both are in the group,
+//   - blocks outside of the CIDR of their group, e.g. after the
+//     topology changed, are returned as they are.
+//
+// Blocks of other groups within the CIDR of an aggregated group stay
+// more specific, so they are still routed to their hosts. IPv6 blocks
+// and blocks of groups without CIDR are returned as they are.
+func AggregateBlocks(blocks []api.IPAMBlockResponse) []api.IPAMBlockResponse {
+	type groupKey struct {
+		network string
+		cidr    string
+	}
+
+	result := make([]api.IPAMBlockResponse, 0, len(blocks))
+	groups := make(map[groupKey][]api.IPAMBlockResponse)
+	var keys []groupKey
+	for _, block := range blocks {
+		if block.GroupCIDR == "" || block.CIDR.IP.To4() == nil {
+			result = append(result, block)
+			continue
+		}
+		key := groupKey{network: block.Network, cidr: block.GroupCIDR}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], block)
+	}
+
+	for _, key := range keys {
+		groupCIDR, err := NewCIDR(key.cidr)
+		if err != nil {
+			result = append(result, groups[key]...)
+			continue
+		}
+		result = append(result, aggregateGroupBlocks(groupCIDR, groups[key])...)
+	}
+	return result
+}
+
+// aggregateGroupBlocks aggregates blocks of the group, see AggregateBlocks.
+func aggregateGroupBlocks(groupCIDR CIDR, blocks []api.IPAMBlockResponse) []api.IPAMBlockResponse {
+	var result, inside []api.IPAMBlockResponse
+	hosts := make(map[string]bool)
+	for _, block := range blocks {
+		if !groupCIDR.Contains(blockCIDR(block)) {
+			result = append(result, block)
+			continue
+		}
+		inside = append(inside, block)
+		hosts[block.Host] = true
+	}
+	if len(inside) == 0 {
+		return result
+	}
+
+	if len(hosts) == 1 {
+		aggregate := inside[0]
+		for _, block := range inside[1:] {
+			aggregate = mergeBlocks(aggregate, block)
+		}
+		aggregate.CIDR = api.IPNet{IPNet: *groupCIDR.IPNet}
+		return append(result, aggregate)
+	}
+	return append(result, collapseBlocks(inside)...)
+}
+
+// collapseBlocks merges sibling blocks on the same host into
+// their common prefix until there are none left to merge.
+func collapseBlocks(blocks []api.IPAMBlockResponse) []api.IPAMBlockResponse {
+	sort.Slice(blocks, func(i, j int) bool {
+		return common.IPv4ToInt(blocks[i].CIDR.IP) < common.IPv4ToInt(blocks[j].CIDR.IP)
+	})
+
+	merged := true
+	for merged {
+		merged = false
+		for i := 0; i+1 < len(blocks); i++ {
+			parent, ok := siblingsParent(blocks[i], blocks[i+1])
+			if !ok {
+				continue
+			}
+			block := mergeBlocks(blocks[i], blocks[i+1])
+			block.CIDR = parent
+			blocks = append(blocks[:i], append([]api.IPAMBlockResponse{block}, blocks[i+2:]...)...)
+			merged = true
+		}
+	}
+	return blocks
+}
+
+// siblingsParent returns the prefix that blocks on the same host
+// split in halves, or false if they are not such siblings.
+func siblingsParent(block api.IPAMBlockResponse, next api.IPAMBlockResponse) (api.IPNet, bool) {
+	if block.Host != next.Host {
+		return api.IPNet{}, false
+	}
+	ones, bits := block.CIDR.Mask.Size()
+	nextOnes, _ := next.CIDR.Mask.Size()
+	if ones == 0 || ones != nextOnes {
+		return api.IPNet{}, false
+	}
+
+	size := uint64(1) << uint(bits-ones)
+	start := common.IPv4ToInt(block.CIDR.IP)
+	if start&size != 0 || common.IPv4ToInt(next.CIDR.IP) != start+size {
+		return api.IPNet{}, false
+	}
+	mask := net.CIDRMask(ones-1, bits)
+	return api.IPNet{IPNet: net.IPNet{IP: block.CIDR.IP.Mask(mask), Mask: mask}}, true
+}
+
+// mergeBlocks returns block routed as both blocks, the number of
+// addresses allocated in them is summed, and tenant and segment
+// are only kept when blocks have the same ones.
+func mergeBlocks(block api.IPAMBlockResponse, other api.IPAMBlockResponse) api.IPAMBlockResponse {
+	block.AllocatedIPCount += other.AllocatedIPCount
+	if other.Revision > block.Revision {
+		block.Revision = other.Revision
+	}
+	if block.Tenant != other.Tenant {
+		block.Tenant = ""
+		block.Segment = ""
+	} else if block.Segment != other.Segment {
+		block.Segment = ""
+	}
+	return block
+}
+
+// blockCIDR returns CIDR of the block.
+func blockCIDR(block api.IPAMBlockResponse) CIDR {
+	cidr, _ := NewCIDR(block.CIDR.String())
+	return cidr
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/romana/core/common/api"
+)
+
+func TestAggregateBlocks(t *testing.T) {
+	block := func(cidr string, host string, groupCIDR string) api.IPAMBlockResponse {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return api.IPAMBlockResponse{
+			CIDR:             api.IPNet{IPNet: *ipNet},
+			Host:             host,
+			AllocatedIPCount: 1,
+			Network:          "net1",
+			GroupCIDR:        groupCIDR,
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		blocks   []api.IPAMBlockResponse
+		expected []string
+	}{
+		{
+			name: "group on one host",
+			blocks: []api.IPAMBlockResponse{
+				block("10.0.0.0/28", "host1", "10.0.0.0/24"),
+				block("10.0.0.48/28", "host1", "10.0.0.0/24"),
+			},
+			expected: []string{"10.0.0.0/24 host1 2"},
+		},
+		{
+			name: "group on two hosts",
+			blocks: []api.IPAMBlockResponse{
+				block("10.0.0.0/28", "host1", "10.0.0.0/24"),
+				block("10.0.0.16/28", "host1", "10.0.0.0/24"),
+				block("10.0.0.32/28", "host1", "10.0.0.0/24"),
+				block("10.0.0.48/28", "host1", "10.0.0.0/24"),
+				block("10.0.0.64/28", "host1", "10.0.0.0/24"),
+				block("10.0.0.80/28", "host2", "10.0.0.0/24"),
+				block("10.0.0.96/28", "host2", "10.0.0.0/24"),
+				block("10.0.0.112/28", "host2", "10.0.0.0/24"),
+			},
+			expected: []string{
+				"10.0.0.0/26 host1 4",
+				"10.0.0.64/28 host1 1",
+				"10.0.0.80/28 host2 1",
+				"10.0.0.96/27 host2 2",
+			},
+		},
+		{
+			name: "block outside of its group",
+			blocks: []api.IPAMBlockResponse{
+				block("10.0.0.0/28", "host1", "10.0.0.0/24"),
+				block("10.0.1.0/28", "host1", "10.0.0.0/24"),
+			},
+			expected: []string{"10.0.0.0/24 host1 1", "10.0.1.0/28 host1 1"},
+		},
+		{
+			name: "groups of different hosts",
+			blocks: []api.IPAMBlockResponse{
+				block("10.0.0.0/28", "host1", "10.0.0.0/24"),
+				block("10.0.1.0/28", "host2", "10.0.1.0/24"),
+				block("10.0.2.0/28", "host3", ""),
+			},
+			expected: []string{"10.0.0.0/24 host1 1", "10.0.1.0/24 host2 1", "10.0.2.0/28 host3 1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var routes []string
+			for _, b := range AggregateBlocks(tc.blocks) {
+				routes = append(routes, b.CIDR.String()+" "+b.Host+" "+strconv.Itoa(b.AllocatedIPCount))
+			}
+			sort.Strings(routes)
+			if !reflect.DeepEqual(routes, tc.expected) {
+				t.Fatalf("Expected %v, got %v", tc.expected, routes)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/romana/core/common"
+
+	libkvStore "github.com/docker/libkv/store"
+)
+
+// auditKeyTime is the format of time in keys of audit
+// records, which sorts in the order of time.
+const auditKeyTime = "20060102T150405.000000000Z"
+
+// AuditStore is common.AuditSink keeping audit records under
+// a prefix of the store, one key per record.
+type AuditStore struct {
+	store  *Store
+	prefix string
+	maxAge time.Duration
+}
+
+// NewAuditStore returns AuditStore keeping records under the prefix,
+// records expire after maxAge, zero means they never do.
+func NewAuditStore(store *Store, prefix string, maxAge time.Duration) *AuditStore {
+	return &AuditStore{store: store, prefix: prefix, maxAge: maxAge}
+}
+
+// WriteAudit implements common.AuditSink. Records are keyed by
+// time and request ID, so that they are never overwritten.
+func (a *AuditStore) WriteAudit(record common.AuditRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	key := a.prefix + "/" + record.Time.UTC().Format(auditKeyTime) + "-" + record.RequestID
+	if a.maxAge > 0 {
+		return a.store.PutObjectWithTTL(key, value, a.maxAge)
+	}
+	return a.store.PutObject(key, value)
+}
+
+// AuditRecords returns audit records kept under the prefix,
+// oldest first.
+func (a *AuditStore) AuditRecords() ([]common.AuditRecord, error) {
+	pairs, err := a.store.ListObjects(a.prefix)
+	if err == libkvStore.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	records := make([]common.AuditRecord, 0, len(pairs))
+	for _, pair := range pairs {
+		var record common.AuditRecord
+		if err := json.Unmarshal(pair.Value, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/romana/core/common"
+)
+
+func TestAuditStore(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	records, err := NewAuditStore(store, "/audit", 0).AuditRecords()
+	if err != nil || len(records) != 0 {
+		t.Fatalf("expected no records, got %v, %v", records, err)
+	}
+
+	now := time.Now().UTC()
+	audit := NewAuditStore(store, "/audit", 0)
+	for i, id := range []string{"r2", "r1"} {
+		record := common.AuditRecord{Time: now.Add(time.Duration(-i) * time.Second), RequestID: id, Method: "POST"}
+		if err := audit.WriteAudit(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err = audit.AuditRecords()
+	if err != nil || len(records) != 2 || records[0].RequestID != "r1" || records[1].RequestID != "r2" {
+		t.Fatalf("expected records r1 and r2, got %v, %v", records, err)
+	}
+
+	// records expire after maxAge.
+	expiring := NewAuditStore(store, "/expiring", 50*time.Millisecond)
+	if err := expiring.WriteAudit(common.AuditRecord{Time: now, RequestID: "r3"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	records, err = expiring.AuditRecords()
+	if err != nil || len(records) != 0 {
+		t.Fatalf("expected expired records, got %v, %v", records, err)
+	}
+}
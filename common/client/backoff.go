@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Backoff computes delays between attempts to re-establish
+// a watch or connection. Delay doubles with every attempt up
+// to Max, and is randomized so that agents on many hosts don't
+// reconnect to etcd all at once after leader election.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+
+	attempt uint
+}
+
+// Next returns delay before the next attempt, which is
+// a random value between half and full of the current delay.
+func (b *Backoff) Next() time.Duration {
+	delay := b.Initial
+	for i := uint(0); i < b.attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	b.attempt++
+
+	if delay <= 1 {
+		return delay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)))
+}
+
+// Reset makes next delay Initial again, it should be called
+// once attempt succeeded.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// IsCompacted returns true if etcd can't resume a watch because
+// history up to requested revision was compacted, in which case
+// watcher must list keys again.
+func IsCompacted(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"outdated and cleared", "has been compacted", "compacted revision"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+
+	for i, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		delay := b.Next()
+		if delay < max/2 || delay > max {
+			t.Fatalf("attempt %d: expected delay between %s and %s, got %s", i, max/2, max, delay)
+		}
+	}
+
+	b.Reset()
+	if delay := b.Next(); delay > 100*time.Millisecond {
+		t.Fatalf("expected initial delay after reset, got %s", delay)
+	}
+}
+
+func TestIsCompacted(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		expect bool
+	}{
+		{nil, false},
+		{errors.New("401: The event in requested index is outdated and cleared (the requested history has been cleared [1008/7]) [2008]"), true},
+		{errors.New("etcdserver: mvcc: required revision has been compacted"), true},
+		{errors.New("client: etcd cluster is unavailable or misconfigured"), false},
+	} {
+		if got := IsCompacted(tc.err); got != tc.expect {
+			t.Errorf("IsCompacted(%v) = %t, expected %t", tc.err, got, tc.expect)
+		}
+	}
+}
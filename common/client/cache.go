@@ -0,0 +1,253 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+	log "github.com/romana/rlog"
+)
+
+// Read cache keeps copies of cached directories in memory, each
+// directory is kept up to date by a watch on it. Get of a key that
+// is a direct child of a cached directory, and ListObjects of
+// a cached directory are served from the copy while the watch is
+// established. After a write through the Store, the directory is
+// read from the store until the watch delivers the written value,
+// so callers always read their own writes.
+
+// pendingWriteTimeout is how long cached directory waits for the
+// watch to deliver a write before it's considered overwritten.
+const pendingWriteTimeout = 5 * time.Second
+
+// CachedPrefixes are directories cached by Client.EnableReadCache.
+var CachedPrefixes = []string{ipamKey, PoliciesPrefix, HostsPrefix, RomanaVIPPrefix}
+
+type readCache struct {
+	mu   sync.RWMutex
+	dirs map[string]*cachedDir
+}
+
+type cachedDir struct {
+	// name is unprefixed directory, used as metric label.
+	name string
+
+	// ready is true when watch is established
+	// and no writes are pending.
+	ready bool
+	pairs map[string]*libkvStore.KVPair
+
+	pending map[string]pendingWrite
+}
+
+type pendingWrite struct {
+	value   []byte
+	deleted bool
+	at      time.Time
+}
+
+// EnableReadCache starts caching directories until stopCh is closed.
+func (s *Store) EnableReadCache(stopCh <-chan struct{}, dirs ...string) {
+	cache := &readCache{dirs: make(map[string]*cachedDir)}
+	for _, dir := range dirs {
+		cache.dirs[s.getKey(dir)] = &cachedDir{name: normalize(dir)}
+	}
+
+	s.mu.Lock()
+	s.cache = cache
+	s.mu.Unlock()
+
+	for dir := range cache.dirs {
+		go s.watchCachedDir(cache, dir, stopCh)
+	}
+}
+
+// EnableReadCache serves reads of policies, IPAM and VIPs from
+// memory until stopCh is closed. IPAM is still loaded from the
+// store before it's modified, see Store.GetLatest.
+func (c *Client) EnableReadCache(stopCh <-chan struct{}) {
+	c.Store.EnableReadCache(stopCh, CachedPrefixes...)
+}
+
+func (s *Store) readCache() *readCache {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cache
+}
+
+// watchCachedDir keeps copy of the directory up to date.
+func (s *Store) watchCachedDir(cache *readCache, dir string, stopCh <-chan struct{}) {
+	backoff := Backoff{Initial: watchReconnectInitialDelay, Max: watchReconnectMaxDelay}
+	for {
+		listings, err := s.kv().WatchTree(dir, stopCh)
+		if err != nil {
+			log.Errorf("Read cache failed to watch %s: %s", dir, err)
+		} else {
+			for pairs := range listings {
+				backoff.Reset()
+				cache.update(dir, pairs)
+			}
+			cache.invalidate(dir)
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(backoff.Next()):
+		}
+	}
+}
+
+// update replaces copy of the directory with the listing.
+func (c *readCache) update(dir string, pairs []*libkvStore.KVPair) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.dirs[dir]
+	d.pairs = make(map[string]*libkvStore.KVPair)
+	for _, pair := range pairs {
+		if pair == nil || normalize(pair.Key) == dir {
+			continue
+		}
+		d.pairs[normalize(pair.Key)] = pair
+	}
+
+	for key, w := range d.pending {
+		pair, ok := d.pairs[key]
+		delivered := (w.deleted && !ok) || (!w.deleted && ok && bytes.Equal(pair.Value, w.value))
+		if delivered || time.Since(w.at) > pendingWriteTimeout {
+			delete(d.pending, key)
+		}
+	}
+	d.ready = len(d.pending) == 0
+}
+
+// discard forgets write to the key that failed.
+func (c *readCache) discard(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, ok := c.dirs[parentDir(key)]
+	if !ok {
+		return
+	}
+	delete(d.pending, key)
+	d.ready = d.pairs != nil && len(d.pending) == 0
+}
+
+func (c *readCache) invalidate(dir string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.dirs[dir]
+	d.ready = false
+	d.pairs = nil
+}
+
+// written makes directory of the key read from the store until
+// the watch delivers the write.
+func (c *readCache) written(key string, value []byte, deleted bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, ok := c.dirs[parentDir(key)]
+	if !ok {
+		return
+	}
+	if d.pending == nil {
+		d.pending = make(map[string]pendingWrite)
+	}
+	d.pending[key] = pendingWrite{value: value, deleted: deleted, at: time.Now()}
+	d.ready = false
+}
+
+// get returns cached key, ok is false if the key isn't cached.
+func (c *readCache) get(key string) (kvp *libkvStore.KVPair, err error, ok bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	d, cached := c.dirs[parentDir(key)]
+	if !cached || !d.ready {
+		c.miss(d)
+		return nil, nil, false
+	}
+
+	CacheHits.WithLabelValues(d.name).Inc()
+	pair, found := d.pairs[key]
+	if !found {
+		return nil, libkvStore.ErrKeyNotFound, true
+	}
+	return copyKVPair(pair), nil, true
+}
+
+// list returns cached directory, ok is false if it isn't cached.
+func (c *readCache) list(dir string) (kvps []*libkvStore.KVPair, err error, ok bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	d, cached := c.dirs[dir]
+	if !cached || !d.ready {
+		c.miss(d)
+		return nil, nil, false
+	}
+
+	CacheHits.WithLabelValues(d.name).Inc()
+	if len(d.pairs) == 0 {
+		return nil, libkvStore.ErrKeyNotFound, true
+	}
+	for _, pair := range d.pairs {
+		kvps = append(kvps, copyKVPair(pair))
+	}
+	sort.Slice(kvps, func(i, j int) bool { return kvps[i].Key < kvps[j].Key })
+	return kvps, nil, true
+}
+
+// miss counts reads of cached directory that went to the store.
+func (c *readCache) miss(d *cachedDir) {
+	if d != nil {
+		CacheMisses.WithLabelValues(d.name).Inc()
+	}
+}
+
+// parentDir returns directory of the normalized key.
+func parentDir(key string) string {
+	for i := len(key) - 1; i > 0; i-- {
+		if key[i] == '/' {
+			return key[:i]
+		}
+	}
+	return "/"
+}
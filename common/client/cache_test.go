@@ -0,0 +1,134 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/romana/core/common"
+)
+
+func TestReadCache(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	stopCh := make(chan struct{})
+	store.EnableReadCache(stopCh, PoliciesPrefix)
+
+	waitCached := func(key string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, _, ok := store.readCache().get(store.getKey(key)); ok {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %s to be cached", key)
+	}
+
+	if err := store.PutObject("/policies/a", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	// writes are visible before the watch delivers them.
+	if v, _ := store.GetString("/policies/a", ""); v != "a" {
+		t.Fatalf("expected to read own write, got %q", v)
+	}
+
+	waitCached("/policies/a")
+	kvps, err := store.ListObjects(PoliciesPrefix)
+	if err != nil || len(kvps) != 1 || string(kvps[0].Value) != "a" {
+		t.Fatalf("unexpected cached listing %v, %v", kvps, err)
+	}
+	if _, err := store.Get("/policies/missing"); err != libkvStore.ErrKeyNotFound {
+		t.Fatalf("expected cached key not found, got %v", err)
+	}
+
+	// keys outside of cached directories are not cached.
+	if _, _, ok := store.readCache().get(store.getKey("/ipam/data")); ok {
+		t.Fatal("expected /ipam/data not to be cached")
+	}
+
+	if _, err := store.Delete("/policies/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("/policies/a"); err != libkvStore.ErrKeyNotFound {
+		t.Fatalf("expected deleted key to be gone, got %v", err)
+	}
+
+	close(stopCh)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, _, ok := store.readCache().get(store.getKey("/policies/a")); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected cache to be invalidated after watch stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRevisionReadsBypassCache(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	store.EnableReadCache(stopCh, PoliciesPrefix)
+
+	revision, err := store.UpdateIfRevision("/policies/a", map[string]string{"id": "a"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cache lags behind the store, e.g. the watch didn't
+	// deliver a write of another client yet.
+	cache := store.readCache()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cache.mu.Lock()
+		d := cache.dirs[store.getKey(PoliciesPrefix)]
+		if d.ready {
+			d.pairs[store.getKey("/policies/a")] = &libkvStore.KVPair{
+				Key: store.getKey("/policies/a"), Value: []byte(`{"id":"stale"}`), LastIndex: revision - 1,
+			}
+			cache.mu.Unlock()
+			break
+		}
+		cache.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for policies to be cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var obj map[string]string
+	latest, err := store.GetWithRevision("/policies/a", &obj)
+	if err != nil || latest != revision || obj["id"] != "a" {
+		t.Fatalf("expected revision %d of a, got %d of %v, %v", revision, latest, obj, err)
+	}
+	if _, err := store.UpdateIfRevision("/policies/a", map[string]string{"id": "b"}, latest); err != nil {
+		t.Fatalf("expected update at latest revision to succeed, got %s", err)
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package client
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/romana/core/common"
+	log "github.com/romana/rlog"
+)
+
+// ErrChaos is the error of operations failed by ChaosKV and ChaosSaver.
+var ErrChaos = errors.New("injected store fault")
+
+// chaos decides which operations fail and how long they take
+// according to common.StoreFaults.
+type chaos struct {
+	mu     sync.Mutex
+	faults common.StoreFaults
+	rand   *rand.Rand
+}
+
+func newChaos(faults common.StoreFaults) *chaos {
+	seed := faults.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &chaos{faults: faults, rand: rand.New(rand.NewSource(seed))}
+}
+
+// SetFaults replaces faults injected from now on, e.g. to make
+// the store flaky once a test set up its data.
+func (c *chaos) SetFaults(faults common.StoreFaults) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = faults
+}
+
+// roll returns true with probability of the rate.
+func (c *chaos) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64() < rate
+}
+
+// delay returns latency of an operation.
+func (c *chaos) delay() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.faults.Latency
+	if c.faults.LatencyJitter > 0 {
+		d += time.Duration(c.rand.Int63n(int64(c.faults.LatencyJitter)))
+	}
+	return d
+}
+
+func (c *chaos) getFaults() common.StoreFaults {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.faults
+}
+
+// before delays the operation named op on the key, and returns
+// ErrChaos if it must fail without being done.
+func (c *chaos) before(op string, key string) error {
+	if d := c.delay(); d > 0 {
+		time.Sleep(d)
+	}
+	if c.roll(c.getFaults().ErrorRate) {
+		log.Debugf("Injected failure of %s %s", op, key)
+		return ErrChaos
+	}
+	return nil
+}
+
+// after returns ErrChaos instead of nil error of the write
+// named op on the key if it must fail after it was done.
+func (c *chaos) after(op string, key string, err error) error {
+	if err == nil && c.roll(c.getFaults().PartialFailureRate) {
+		log.Debugf("Injected failure of %s %s after it was done", op, key)
+		return ErrChaos
+	}
+	return err
+}
+
+// ChaosKV implements KV by passing operations to another KV,
+// failing them and adding latency to them according to faults.
+// Faults of the config of the store are injected this way,
+// see common.StoreFaults, extended etcd methods the store
+// calls on libkv store directly, e.g. GetExt, are not affected.
+type ChaosKV struct {
+	KV
+	*chaos
+}
+
+// NewChaosKV returns KV injecting faults into operations of kv.
+func NewChaosKV(kv KV, faults common.StoreFaults) *ChaosKV {
+	return &ChaosKV{KV: kv, chaos: newChaos(faults)}
+}
+
+// wrapKV returns kv injecting faults of the config, if any.
+func wrapKV(kv KV, config *common.Config) KV {
+	if !config.StoreFaults.IsEnabled() {
+		return kv
+	}
+	log.Warnf("Injecting store faults %+v", config.StoreFaults)
+	return NewChaosKV(kv, config.StoreFaults)
+}
+
+// Put implements KV.
+func (c *ChaosKV) Put(key string, value []byte, options *libkvStore.WriteOptions) error {
+	if err := c.before("put", key); err != nil {
+		return err
+	}
+	return c.after("put", key, c.KV.Put(key, value, options))
+}
+
+// Get implements KV.
+func (c *ChaosKV) Get(key string) (*libkvStore.KVPair, error) {
+	if err := c.before("get", key); err != nil {
+		return nil, err
+	}
+	return c.KV.Get(key)
+}
+
+// Delete implements KV.
+func (c *ChaosKV) Delete(key string) error {
+	if err := c.before("delete", key); err != nil {
+		return err
+	}
+	return c.after("delete", key, c.KV.Delete(key))
+}
+
+// Exists implements KV.
+func (c *ChaosKV) Exists(key string) (bool, error) {
+	if err := c.before("exists", key); err != nil {
+		return false, err
+	}
+	return c.KV.Exists(key)
+}
+
+// List implements KV.
+func (c *ChaosKV) List(directory string) ([]*libkvStore.KVPair, error) {
+	if err := c.before("list", directory); err != nil {
+		return nil, err
+	}
+	return c.KV.List(directory)
+}
+
+// AtomicPut implements KV.
+func (c *ChaosKV) AtomicPut(key string, value []byte, previous *libkvStore.KVPair, options *libkvStore.WriteOptions) (bool, *libkvStore.KVPair, error) {
+	if err := c.before("atomic put", key); err != nil {
+		return false, nil, err
+	}
+	ok, kvp, err := c.KV.AtomicPut(key, value, previous, options)
+	if err = c.after("atomic put", key, err); err != nil {
+		return false, nil, err
+	}
+	return ok, kvp, nil
+}
+
+// AtomicDelete implements KV.
+func (c *ChaosKV) AtomicDelete(key string, previous *libkvStore.KVPair) (bool, error) {
+	if err := c.before("atomic delete", key); err != nil {
+		return false, err
+	}
+	ok, err := c.KV.AtomicDelete(key, previous)
+	if err = c.after("atomic delete", key, err); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// NewLock implements KV, taking the lock fails
+// the same way as other operations.
+func (c *ChaosKV) NewLock(key string, options *libkvStore.LockOptions) (libkvStore.Locker, error) {
+	if err := c.before("new lock", key); err != nil {
+		return nil, err
+	}
+	locker, err := c.KV.NewLock(key, options)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosLocker{Locker: locker, key: key, chaos: c.chaos}, nil
+}
+
+// Watch implements KV.
+func (c *ChaosKV) Watch(key string, stopCh <-chan struct{}) (<-chan *libkvStore.KVPair, error) {
+	if err := c.before("watch", key); err != nil {
+		return nil, err
+	}
+	innerStop := make(chan struct{})
+	in, err := c.KV.Watch(key, innerStop)
+	if err != nil {
+		close(innerStop)
+		return nil, err
+	}
+	out := make(chan *libkvStore.KVPair)
+	go func() {
+		defer close(out)
+		defer close(innerStop)
+		for {
+			select {
+			case kvp, ok := <-in:
+				if !ok || c.dropWatch(key) {
+					return
+				}
+				select {
+				case out <- kvp:
+				case <-stopCh:
+					return
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchTree implements KV.
+func (c *ChaosKV) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*libkvStore.KVPair, error) {
+	if err := c.before("watch tree", directory); err != nil {
+		return nil, err
+	}
+	innerStop := make(chan struct{})
+	in, err := c.KV.WatchTree(directory, innerStop)
+	if err != nil {
+		close(innerStop)
+		return nil, err
+	}
+	out := make(chan []*libkvStore.KVPair)
+	go func() {
+		defer close(out)
+		defer close(innerStop)
+		for {
+			select {
+			case kvps, ok := <-in:
+				if !ok || c.dropWatch(directory) {
+					return
+				}
+				select {
+				case out <- kvps:
+				case <-stopCh:
+					return
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// dropWatch returns true if the watch of the key
+// must be closed instead of delivering a change.
+func (c *chaos) dropWatch(key string) bool {
+	if c.roll(c.getFaults().WatchDropRate) {
+		log.Debugf("Injected drop of watch of %s", key)
+		return true
+	}
+	return false
+}
+
+// chaosLocker fails to take the lock the same
+// way ChaosKV fails operations.
+type chaosLocker struct {
+	libkvStore.Locker
+	key string
+	*chaos
+}
+
+// Lock implements libkv store.Locker.
+func (l *chaosLocker) Lock(stopChan chan struct{}) (<-chan struct{}, error) {
+	if err := l.before("lock", l.key); err != nil {
+		return nil, err
+	}
+	return l.Locker.Lock(stopChan)
+}
+
+// ChaosSaver returns Saver injecting faults into saves of saver,
+// for tests of IPAM with savers not backed by the store: a save
+// fails without saving at ErrorRate of faults, and fails after
+// saving at PartialFailureRate.
+func ChaosSaver(saver Saver, faults common.StoreFaults) Saver {
+	c := newChaos(faults)
+	return func(ipam *IPAM, ch <-chan struct{}) error {
+		if err := c.before("save", ipamDataKey); err != nil {
+			return err
+		}
+		return c.after("save", ipamDataKey, saver(ipam, ch))
+	}
+}
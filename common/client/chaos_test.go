@@ -0,0 +1,155 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+)
+
+func TestChaosKV(t *testing.T) {
+	memory := NewMemoryKV()
+	defer memory.Close()
+	kv := NewChaosKV(memory, common.StoreFaults{ErrorRate: 1})
+
+	if err := kv.Put("/a", []byte("1"), nil); err != ErrChaos {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if ok, _ := memory.Exists("/a"); ok {
+		t.Fatal("expected failed put not to be done")
+	}
+
+	kv.SetFaults(common.StoreFaults{PartialFailureRate: 1})
+	if err := kv.Put("/a", []byte("1"), nil); err != ErrChaos {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if ok, _ := memory.Exists("/a"); !ok {
+		t.Fatal("expected partially failed put to be done")
+	}
+	if _, err := kv.Get("/a"); err != nil {
+		t.Fatalf("expected reads not to fail partially, got %v", err)
+	}
+
+	kv.SetFaults(common.StoreFaults{Latency: 20 * time.Millisecond})
+	start := time.Now()
+	if _, err := kv.Get("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("expected latency of 20ms, got %s", d)
+	}
+}
+
+func TestChaosKVWatchDrop(t *testing.T) {
+	memory := NewMemoryKV()
+	defer memory.Close()
+	kv := NewChaosKV(memory, common.StoreFaults{})
+
+	memory.Put("/a", []byte("1"), nil)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	values, err := kv.Watch("/a", stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case kvp := <-values:
+		if string(kvp.Value) != "1" {
+			t.Fatalf("expected current value, got %s", kvp.Value)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for current value")
+	}
+
+	kv.SetFaults(common.StoreFaults{WatchDropRate: 1})
+	memory.Put("/a", []byte("2"), nil)
+	select {
+	case kvp, ok := <-values:
+		if ok {
+			t.Fatalf("expected watch to be dropped, got %s", kvp.Value)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for watch to be dropped")
+	}
+}
+
+// TestIPAMChaosSaver tests that IPAM saved by a flaky saver
+// keeps its invariants, and keeps addresses which were
+// allocated unless they were deallocated. Outcome of an
+// operation failed by the saver after saving is unknown
+// until IPAM is loaded again.
+func TestIPAMChaosSaver(t *testing.T) {
+	saver := &TestSaver{}
+	ipam, err := NewIPAM(saver.save, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam.load = saver.load
+	var req api.TopologyUpdateRequest
+	if err := json.Unmarshal([]byte(ipamFuzzTopologies[0]), &req); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.UpdateTopology(req, true); err != nil {
+		t.Fatal(err)
+	}
+	ipam.save = ChaosSaver(saver.save, common.StoreFaults{ErrorRate: 0.2, PartialFailureRate: 0.2, Seed: 1})
+
+	addresses := make(map[string]net.IP)
+	for i := 0; i < 500; i++ {
+		name := fmt.Sprintf("a%d", i%16)
+		if _, ok := addresses[name]; ok {
+			err = ipam.DeallocateIP(name)
+			if err == nil {
+				delete(addresses, name)
+			}
+		} else {
+			var ip net.IP
+			ip, err = ipam.AllocateIP(name, fmt.Sprintf("host%d", i%2+1), "t0", "s0")
+			if err == nil {
+				addresses[name] = ip
+			}
+		}
+		if err != nil && err != ErrChaos {
+			t.Fatalf("unexpected error of %s: %s", name, err)
+		}
+
+		reloaded := &IPAM{save: ipam.save, load: ipam.load}
+		if err := saver.load(reloaded, nil); err != nil {
+			t.Fatal(err)
+		}
+		ipam = reloaded
+		if err == ErrChaos {
+			if ip, ok := ipam.AddressNameToIP[name]; ok {
+				addresses[name] = ip
+			} else {
+				delete(addresses, name)
+			}
+		}
+		if err := ipam.CheckInvariants(); err != nil {
+			t.Fatalf("after %d operations: %s", i+1, err)
+		}
+		if len(ipam.AddressNameToIP) != len(addresses) ||
+			(len(addresses) > 0 && !reflect.DeepEqual(ipam.AddressNameToIP, addresses)) {
+			t.Fatalf("after %d operations expected addresses %v, got %v", i+1, addresses, ipam.AddressNameToIP)
+		}
+	}
+}
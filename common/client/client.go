@@ -16,14 +16,17 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/romana/core/common"
 	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/api/errors"
 	"github.com/romana/core/common/log/trace"
 
 	libkvStore "github.com/docker/libkv/store"
@@ -31,12 +34,19 @@ import (
 )
 
 const (
+	BackendEtcd           = "etcd"
+	BackendConsul         = "consul"
+	BackendMemory         = "memory"
 	DefaultEtcdPrefix     = "/romana"
 	DefaultEtcdEndpoints  = "localhost:2379"
 	ipamKey               = "/ipam"
 	ipamDataKey           = ipamKey + "/data"
 	PoliciesPrefix        = "/policies"
 	RomanaVIPPrefix       = "/romanavip"
+	BandwidthPrefix       = "/bandwidth"
+	HostsPrefix           = "/hosts"
+	LivenessPrefix        = "/liveness"
+	EventsPrefix          = "/events"
 	defaultTopologyLevels = 20
 )
 
@@ -46,6 +56,14 @@ type Client struct {
 	Store       *Store
 	ipamLocker  Locker
 	IPAM        *IPAM
+
+	// liveness is the record kept alive by KeepHostAlive.
+	livenessMu  sync.Mutex
+	liveness    *HostLiveness
+	livenessTTL time.Duration
+
+	// events records events of IPAM, see RecordEvents.
+	events *EventRecorder
 }
 
 // NewClient creates a new Client object based on provided config
@@ -53,18 +71,27 @@ func NewClient(config *common.Config) (*Client, error) {
 	if config.EtcdPrefix == "" {
 		config.EtcdPrefix = DefaultEtcdPrefix
 	}
-	store, err := NewStore(config.EtcdEndpoints, config.EtcdPrefix)
+	if err := ValidatePrefix(config.EtcdPrefix); err != nil {
+		return nil, err
+	}
+	store, err := NewStore(config)
 	if err != nil {
 		return nil, err
 	}
+	return NewClientWithStore(config, store)
+}
 
+// NewClientWithStore creates a new Client using the store, e.g.
+// one sharing in-memory data with clients of other components
+// in integration tests, see package clienttest.
+func NewClientWithStore(config *common.Config, store *Store) (*Client, error) {
 	c := &Client{
 		config:      config,
 		Store:       store,
 		savingMutex: &sync.RWMutex{},
 	}
 
-	err = c.initIPAM(config.InitialTopologyFile)
+	err := c.initIPAM(config.InitialTopologyFile)
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +190,11 @@ func (c *Client) WatchBlocks(stopCh <-chan struct{}) (<-chan api.IPAMBlocksRespo
 				} else {
 					lastBlockListRevision = blocks.Revision
 					log.Tracef(trace.Inside, "WatchBlocks: sending block list revision %d to out channel", blocks.Revision)
-					outCh <- *blocks
+					select {
+					case outCh <- *blocks:
+					case <-stopCh:
+						return
+					}
 				}
 			}
 		}
@@ -206,7 +237,75 @@ func (c *Client) WatchHosts(stopCh <-chan struct{}) (<-chan api.HostList, error)
 				} else {
 					lastHostListRevision = hostList.Revision
 					log.Tracef(trace.Inside, "WatchHosts: sending host list revision %d to out channel", hostList.Revision)
-					outCh <- hostList
+					select {
+					case outCh <- hostList:
+					case <-stopCh:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return outCh, nil
+}
+
+// WatchIPAM sends IPAM whenever it changes, starting with the
+// current one. IPAM that can't be parsed is logged and skipped.
+func (c *Client) WatchIPAM(stopCh <-chan struct{}) (<-chan *IPAM, error) {
+	ch, err := c.Store.ReconnectingWatch(ipamDataKey, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	outCh := make(chan *IPAM)
+	go func() {
+		for {
+			select {
+			case <-stopCh:
+				return
+			case kv := <-ch:
+				ipam, err := parseIPAM(string(kv.Value))
+				if err != nil {
+					log.Errorf("WatchIPAM: Error parsing IPAM: %s", err)
+					continue
+				}
+				select {
+				case outCh <- ipam:
+				case <-stopCh:
+					return
+				}
+			}
+		}
+	}()
+	return outCh, nil
+}
+
+// WatchAddresses is similar to WatchBlocks, but reports
+// allocated addresses whenever they change.
+func (c *Client) WatchAddresses(stopCh <-chan struct{}) (<-chan []api.IPAMAddress, error) {
+	log.Tracef(trace.Public, "Entering WatchAddresses.")
+	ipams, err := c.WatchIPAM(stopCh)
+	if err != nil {
+		return nil, err
+	}
+	outCh := make(chan []api.IPAMAddress)
+	// addresses are allocated and deallocated along with
+	// increment of AllocationRevision.
+	lastAllocationRevision := -1
+
+	go func() {
+		for {
+			select {
+			case <-stopCh:
+				return
+			case ipam := <-ipams:
+				if ipam.AllocationRevision <= lastAllocationRevision {
+					continue
+				}
+				lastAllocationRevision = ipam.AllocationRevision
+				select {
+				case outCh <- ipam.ListAddresses():
+				case <-stopCh:
+					return
 				}
 			}
 		}
@@ -223,7 +322,7 @@ func (c *Client) ListPolicies() ([]api.Policy, error) {
 	errors := []error{}
 	for i, v := range kvps {
 		p := api.Policy{}
-		err := json.Unmarshal(v.Value, &p)
+		err := DecodeObject(KindPolicy, v.Value, &p)
 		if err != nil {
 			errors = append(errors, fmt.Errorf("error decoding policy %d: %v: %v", i+1, v.Value, err))
 			continue
@@ -286,7 +385,7 @@ func (c *Client) ListTenants() []api.Tenant {
 // AddPolicy adds a policy (or modifies it if policy with such ID already
 // exists)
 func (c *Client) AddPolicy(policy api.Policy) error {
-	b, err := json.Marshal(policy)
+	b, err := EncodeObject(KindPolicy, policy)
 	if err != nil {
 		return err
 	}
@@ -299,6 +398,30 @@ func (c *Client) DeletePolicy(id string) (bool, error) {
 	return c.Store.Delete(PoliciesPrefix + "/" + id)
 }
 
+// GetPolicyWithRevision returns the policy and its revision,
+// which UpdatePolicy uses to detect concurrent updates.
+func (c *Client) GetPolicyWithRevision(id string) (api.Policy, uint64, error) {
+	var p api.Policy
+	var raw json.RawMessage
+	revision, err := c.Store.GetWithRevision(PoliciesPrefix+"/"+id, &raw)
+	if err != nil {
+		return p, 0, err
+	}
+	err = DecodeObject(KindPolicy, raw, &p)
+	return p, revision, err
+}
+
+// UpdatePolicy stores the policy if it is still at the revision,
+// zero revision means policy must not exist yet. RomanaConflictError
+// is returned if policy was modified since, new revision otherwise.
+func (c *Client) UpdatePolicy(policy api.Policy, revision uint64) (uint64, error) {
+	b, err := EncodeObject(KindPolicy, policy)
+	if err != nil {
+		return 0, err
+	}
+	return c.Store.UpdateIfRevision(PoliciesPrefix+"/"+policy.ID, json.RawMessage(b), revision)
+}
+
 // GetPolicy attempts to retrieve a policy.
 func (c *Client) GetPolicy(id string) (api.Policy, error) {
 	p := api.Policy{}
@@ -306,7 +429,7 @@ func (c *Client) GetPolicy(id string) (api.Policy, error) {
 	if err != nil {
 		return p, err
 	}
-	err = json.Unmarshal(v.Value, &p)
+	err = DecodeObject(KindPolicy, v.Value, &p)
 	return p, err
 }
 
@@ -350,7 +473,7 @@ func (c *Client) initIPAM(initialTopologyFile *string) error {
 			ipamExists = false
 		} else {
 			ipam := &IPAM{}
-			err := json.Unmarshal([]byte(ipamData), ipam)
+			err := DecodeObject(KindIPAM, []byte(ipamData), ipam)
 			if err != nil {
 				log.Errorf("Error while un-marshalling ipam data: %s", err)
 				return err
@@ -370,7 +493,7 @@ func (c *Client) initIPAM(initialTopologyFile *string) error {
 		}
 		// Load if exists
 		log.Infof("Loading IPAM data from %s", c.Store.getKey(ipamDataKey))
-		kv, err := c.Store.Get(ipamDataKey)
+		kv, err := c.Store.GetLatest(ipamDataKey)
 		if err != nil {
 			return err
 		}
@@ -416,8 +539,10 @@ func (c *Client) initIPAM(initialTopologyFile *string) error {
 	return nil
 }
 
+// load reloads IPAM from the store bypassing the read cache,
+// since IPAM is saved only if its revision didn't change.
 func (c *Client) load(ipam *IPAM, ch <-chan struct{}) error {
-	kv, err := c.Store.Get(ipamDataKey)
+	kv, err := c.Store.GetLatest(ipamDataKey)
 	if err != nil {
 		return err
 	}
@@ -451,16 +576,85 @@ func (c *Client) save(ipam *IPAM, ch <-chan struct{}) error {
 		log.Warn(fmt.Sprintf("Lost lock while saving in %d: %p", getGID(), &msg))
 		return nil
 	default:
-		err = c.Store.AtomicPut(ipamDataKey, ipam)
+		var stm *STM
+		stm, err = c.Store.Transaction(func(stm *STM) error {
+			return saveIPAMTxn(stm, ipam)
+		})
+		if err == ErrTxnConflict {
+			// IPAM was modified by another client since it was loaded.
+			var expected uint64
+			if prev := ipam.GetPrevKVPair(); prev != nil {
+				expected = prev.LastIndex
+			}
+			err = errors.NewRomanaConflictError("IPAM", ipamDataKey, expected, c.Store.revision(ipamDataKey))
+		}
 		if err != nil {
 			log.Errorf("Error saving IPAM: %s: %d", err, getGID())
 			return err
 		}
+		ipam.SetPrevKVPair(stm.Committed(ipamDataKey))
 		log.Debugf("%d: Saved IPAM (Alloc rev: %d, Topo rev: %d): IPAM rev %d", getGID(), ipam.AllocationRevision, ipam.TopologyRevision, c.IPAM.GetPrevKVPair().LastIndex)
 		return nil
 	}
 }
 
+// saveIPAMTxn writes IPAM and records of its hosts under HostsPrefix
+// in the transaction, so that they never disagree after a crash.
+// IPAM must not have been modified in the store since it was loaded.
+func saveIPAMTxn(stm *STM, ipam *IPAM) error {
+	b, err := EncodeObject(KindIPAM, ipam)
+	if err != nil {
+		return err
+	}
+	stm.Expect(ipamDataKey, ipam.GetPrevKVPair())
+	stm.Put(ipamDataKey, b)
+
+	current, err := stm.List(HostsPrefix)
+	if err != nil {
+		return err
+	}
+
+	hosts := make(map[string]bool)
+	for _, host := range ipam.ListHosts().Hosts {
+		key := normalize(HostsPrefix + "/" + host.Name)
+		hosts[key] = true
+
+		b, err := json.Marshal(host)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current[key], b) {
+			stm.Put(key, b)
+		}
+	}
+
+	for key := range current {
+		if !hosts[key] {
+			stm.Delete(key)
+		}
+	}
+
+	return nil
+}
+
+// GetHost returns the host by name from records saved under
+// HostsPrefix together with IPAM, which are cheaper to read than
+// IPAM by clients interested in a single host.
+func (c *Client) GetHost(name string) (api.Host, error) {
+	var host api.Host
+	kvp, err := c.Store.Get(HostsPrefix + "/" + name)
+	if err == libkvStore.ErrKeyNotFound {
+		return host, errors.NewRomanaNotFoundError(fmt.Sprintf("Host %s not found", name), "host", "name="+name)
+	}
+	if err != nil {
+		return host, err
+	}
+	if err := json.Unmarshal(kvp.Value, &host); err != nil {
+		return host, err
+	}
+	return host, nil
+}
+
 // watchIPAM watches the backing store, and if a new IPAM is detected, it will
 // reinitialize itself with the new value.
 func (c *Client) watchIPAM() error {
@@ -497,6 +691,7 @@ func (c *Client) watchIPAM() error {
 					}
 					c.IPAM.save = c.save
 					c.IPAM.load = c.load
+					c.IPAM.events = c.events
 					c.IPAM.SetPrevKVPair(kv)
 					log.Debugf("Loaded IPAM with revision %d", kv.LastIndex)
 				}
@@ -556,6 +751,85 @@ func (c *Client) ListRomanaVIPs() (map[string]api.ExposedIPSpec, error) {
 	return exposedIPs, nil
 }
 
+// topLevelKeys are keys romana creates under its prefix.
+var topLevelKeys = []string{ipamKey, PoliciesPrefix, RomanaVIPPrefix, BandwidthPrefix, HostsPrefix, LivenessPrefix, txnPrefix, "/lock", "/leader"}
+
+// ValidatePrefix checks that prefix can be used to namespace keys
+// of a romana installation. Prefix must not be the root and must not
+// be nested into keys of another installation, e.g. /romana/policies/b,
+// since recursive watches of that installation would see its keys.
+func ValidatePrefix(prefix string) error {
+	if strings.ContainsAny(prefix, " \t\n") {
+		return fmt.Errorf("invalid etcd prefix %q, whitespace is not allowed", prefix)
+	}
+
+	normalized := normalize(prefix)
+	if normalized == "/" {
+		return fmt.Errorf("invalid etcd prefix %q, romana keys can't be stored at the root", prefix)
+	}
+
+	for _, elt := range strings.Split(normalized, "/")[1:] {
+		if elt == "." || elt == ".." {
+			return fmt.Errorf("invalid etcd prefix %q, relative elements are not allowed", prefix)
+		}
+		for _, key := range topLevelKeys {
+			if "/"+elt == key {
+				return fmt.Errorf("invalid etcd prefix %q, %s is reserved for romana keys", prefix, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetEndpointBandwidth stores bandwidth record of the endpoint.
+func (c *Client) SetEndpointBandwidth(e api.EndpointBandwidth) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return c.Store.PutObject(endpointBandwidthKey(e.Host, e.Name), b)
+}
+
+// DeleteEndpointBandwidth deletes bandwidth record of the endpoint.
+func (c *Client) DeleteEndpointBandwidth(host string, name string) error {
+	_, err := c.Store.Delete(endpointBandwidthKey(host, name))
+	if err == libkvStore.ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// ListEndpointBandwidth lists bandwidth records of endpoints of the host.
+func (c *Client) ListEndpointBandwidth(host string) ([]api.EndpointBandwidth, error) {
+	kvpairs, err := c.Store.ListObjects(BandwidthPrefix + "/" + host)
+	if err == libkvStore.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result []api.EndpointBandwidth
+	for i := range kvpairs {
+		if kvpairs[i] == nil {
+			continue
+		}
+
+		var e api.EndpointBandwidth
+		if err := json.Unmarshal(kvpairs[i].Value, &e); err != nil {
+			return nil, fmt.Errorf("error while unmarshalling endpoint bandwidth %s: %s", kvpairs[i].Key, err)
+		}
+		result = append(result, e)
+	}
+
+	return result, nil
+}
+
+func endpointBandwidthKey(host string, name string) string {
+	return BandwidthPrefix + "/" + host + "/" + name
+}
+
 // GetTopology returns the representation of latest topology in store.
 func (c *Client) GetTopology() (interface{}, error) {
 	ch, err := c.ipamLocker.Lock()
@@ -576,7 +850,7 @@ func (c *Client) GetTopology() (interface{}, error) {
 	}
 
 	ipamState := &IPAM{}
-	err = json.Unmarshal(kv.Value, ipamState)
+	err = DecodeObject(KindIPAM, kv.Value, ipamState)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal ipam information: %s", err)
 	}
@@ -595,7 +869,7 @@ func getTopologyFromIPAMState(ipamState *IPAM) interface{} {
 		return nil
 	}
 
-	topology := api.TopologyUpdateRequest{}
+	topology := api.TopologyUpdateRequest{Revision: ipamState.TopologyRevision}
 
 	for _, network := range ipamState.Networks {
 		var tenants []string
@@ -611,10 +885,13 @@ func getTopologyFromIPAMState(ipamState *IPAM) interface{} {
 		}
 
 		topology.Networks = append(topology.Networks, api.NetworkDefinition{
-			Name:      network.Name,
-			CIDR:      network.CIDR.String(),
-			BlockMask: network.BlockMask,
-			Tenants:   tenants,
+			Name:       network.Name,
+			CIDR:       network.CIDR.String(),
+			BlockMask:  network.BlockMask,
+			Tenants:    tenants,
+			Interface:  network.Interface,
+			MTU:        network.MTU,
+			RouteTable: network.RouteTable,
 		})
 
 		var maps []api.GroupOrHost
@@ -629,6 +906,19 @@ func getTopologyFromIPAMState(ipamState *IPAM) interface{} {
 					maps = append(maps, group)
 				}
 			}
+			// Map of a single group is parsed into the top level
+			// group itself rather than its only subgroup, it is
+			// returned as such, so that it is parsed back the same.
+			if network.Group.Name != "/" && len(maps) > 0 {
+				maps = []api.GroupOrHost{{
+					Name:       network.Group.Name,
+					Routing:    network.Group.Routing,
+					Assignment: network.Group.Assignment,
+					Zone:       network.Group.Zone,
+					NextHops:   network.Group.NextHops,
+					Groups:     maps,
+				}}
+			}
 		}
 
 		topology.Topologies = append(topology.Topologies, api.TopologyDefinition{
@@ -647,9 +937,11 @@ func addHosts(hosts []*Host) []api.GroupOrHost {
 	for _, host := range hosts {
 		if host != nil {
 			rHosts = append(rHosts, api.GroupOrHost{
-				Name:       host.Name,
-				IP:         host.IP,
-				Assignment: host.Tags,
+				Name:     host.Name,
+				IP:       host.IP,
+				Tags:     host.Tags,
+				Capacity: host.Capacity,
+				Cordoned: host.Cordoned,
 			})
 		}
 	}
@@ -695,6 +987,8 @@ func addGroups(groups []*Group, level uint) []api.GroupOrHost {
 				Routing:    group.Routing,
 				CIDR:       cidr,
 				Assignment: group.Assignment,
+				Zone:       group.Zone,
+				NextHops:   group.NextHops,
 				Groups:     subgroups,
 			})
 		}
@@ -430,3 +430,21 @@ func Test_getTopologyFromIPAMState(t *testing.T) {
 		})
 	}
 }
+
+func TestValidatePrefix(t *testing.T) {
+	for prefix, valid := range map[string]bool{
+		"/romana":            true,
+		"romana-b":           true,
+		"/clusters/a/romana": true,
+		"/":                  false,
+		"":                   false,
+		"/romana/policies/b": false,
+		"/romana/ipam":       false,
+		"/romana/../b":       false,
+		"/romana b":          false,
+	} {
+		if err := ValidatePrefix(prefix); (err == nil) != valid {
+			t.Errorf("expected prefix %q valid=%t, got %v", prefix, valid, err)
+		}
+	}
+}
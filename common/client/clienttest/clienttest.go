@@ -0,0 +1,163 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package clienttest provides romana store for integration tests
+// of components using client.Client, e.g. policy cache, IPAM saver
+// or listener, so that they run without external services.
+//
+// Clients of a Store share its data the way clients of romana
+// services running on different hosts share etcd: changes made by
+// one client are seen by watches of the others. Data is kept in
+// memory, unless EnvEtcdEndpoints is set, in which case tests run
+// against that etcd under a prefix unique to the Store.
+package clienttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/client"
+)
+
+// EnvEtcdEndpoints is the environment variable with comma-separated
+// endpoints of etcd to run tests against instead of memory.
+const EnvEtcdEndpoints = "ROMANA_TEST_ETCD_ENDPOINTS"
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// Store is romana store shared by clients of a test.
+type Store struct {
+	t      testing.TB
+	config common.Config
+
+	// kv is the data shared by clients, nil with etcd,
+	// clients use it through chaos to be failed by SetFaults.
+	kv    *client.MemoryKV
+	chaos *client.ChaosKV
+
+	mu      sync.Mutex
+	clients []*client.Client
+}
+
+// NewStore returns empty store, test fails if etcd
+// given by EnvEtcdEndpoints can't be reached.
+func NewStore(t testing.TB) *Store {
+	s := &Store{
+		t: t,
+		config: common.Config{
+			Backend:    client.BackendMemory,
+			EtcdPrefix: fmt.Sprintf("/romanaTest%d", rand.Int63n(1000000)),
+		},
+	}
+	if endpoints := os.Getenv(EnvEtcdEndpoints); endpoints != "" {
+		s.config.Backend = client.BackendEtcd
+		s.config.EtcdEndpoints = strings.Split(endpoints, ",")
+	} else {
+		s.kv = client.NewMemoryKV()
+		s.chaos = client.NewChaosKV(s.kv, common.StoreFaults{})
+	}
+	return s
+}
+
+// Config returns config of clients of the store, for components
+// that create their client themselves, e.g. listener. With memory
+// backend such client doesn't share data of the store.
+func (s *Store) Config() common.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config
+}
+
+// NewClient returns a new client of the store, test fails
+// if the client can't be created.
+func (s *Store) NewClient() *client.Client {
+	config := s.Config()
+	var c *client.Client
+	var err error
+	if s.kv == nil {
+		c, err = client.NewClient(&config)
+	} else {
+		var store *client.Store
+		store, err = client.NewStoreWithKV(&config, s.chaos)
+		if err == nil {
+			c, err = client.NewClientWithStore(&config, store)
+		}
+	}
+	if err != nil {
+		s.t.Fatalf("Error creating client of %s: %s", config.EtcdPrefix, err)
+	}
+
+	s.mu.Lock()
+	s.clients = append(s.clients, c)
+	s.mu.Unlock()
+	return c
+}
+
+// SetFaults makes operations of clients of the store fail or
+// take longer according to faults, e.g. to test how a component
+// copes with flaky etcd once a test set up its data. Faults are
+// injected into operations of all clients of the store, with
+// etcd only into those of clients created after SetFaults.
+func (s *Store) SetFaults(faults common.StoreFaults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.chaos != nil {
+		s.chaos.SetFaults(faults)
+		return
+	}
+	s.config.StoreFaults = faults
+}
+
+// SetTopology applies topology given as JSON of
+// api.TopologyUpdateRequest through a new client.
+func (s *Store) SetTopology(topology string) {
+	topoReq := api.TopologyUpdateRequest{}
+	if err := json.Unmarshal([]byte(topology), &topoReq); err != nil {
+		s.t.Fatalf("Cannot parse topology %s: %s", topology, err)
+	}
+	if err := s.NewClient().IPAM.UpdateTopology(topoReq, true); err != nil {
+		s.t.Fatalf("Error updating topology: %s", err)
+	}
+}
+
+// Close stops watches of clients of the store, and deletes
+// data of the store from etcd.
+func (s *Store) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.kv != nil {
+		s.kv.Close()
+		return
+	}
+	for i, c := range s.clients {
+		if i == 0 {
+			if err := c.Store.DeleteTree(s.config.EtcdPrefix); err != nil {
+				s.t.Errorf("Error deleting %s: %s", s.config.EtcdPrefix, err)
+			}
+		}
+		c.Store.Close()
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package clienttest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/client"
+)
+
+const topology = `{
+	"networks": [{"name": "net1", "cidr": "10.0.0.0/16", "block_mask": 28}],
+	"topologies": [{"networks": ["net1"], "map": [{"groups": [
+		{"name": "host1", "ip": "192.168.0.1"},
+		{"name": "host2", "ip": "192.168.0.2"}
+	]}]}]
+}`
+
+// TestStore tests that clients of the store see changes
+// made by each other.
+func TestStore(t *testing.T) {
+	store := NewStore(t)
+	defer store.Close()
+	store.SetTopology(topology)
+
+	romanad := store.NewClient()
+	agent := store.NewClient()
+
+	ip, err := romanad.IPAM.AllocateIP("pod1", "host1", "tenant1", "segment1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		addresses := agent.IPAM.ListAddresses()
+		if len(addresses) == 1 && addresses[0].IP.Equal(ip) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s allocated by another client, got %+v", ip, addresses)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	events, err := agent.WatchEvents(stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	romanad.RecordEvents("romanad", "").Eventf(api.EventNormal, "PolicyCreated",
+		api.EventObject{Kind: api.EventKindPolicy, Name: "policy1"}, "Policy policy1 created")
+	select {
+	case event := <-events:
+		if event.Reason != "PolicyCreated" || event.Component != "romanad" {
+			t.Errorf("Expected PolicyCreated of romanad, got %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for event recorded by another client")
+	}
+}
+
+// TestHostsIndex tests that records of hosts are
+// saved together with IPAM.
+func TestHostsIndex(t *testing.T) {
+	store := NewStore(t)
+	defer store.Close()
+	store.SetTopology(topology)
+
+	romanad := store.NewClient()
+	host, err := romanad.GetHost("host2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.IP.String() != "192.168.0.2" {
+		t.Errorf("Expected host2 at 192.168.0.2, got %+v", host)
+	}
+
+	if err := romanad.IPAM.RemoveHost(api.Host{Name: "host2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := romanad.GetHost("host2"); err == nil {
+		t.Error("Expected removed host2 not to be found")
+	}
+	if _, err := romanad.GetHost("host1"); err != nil {
+		t.Errorf("Expected host1 to be kept, got %s", err)
+	}
+}
+
+// TestStoreFaults tests that clients fail while faults are
+// injected and recover once they are not.
+func TestStoreFaults(t *testing.T) {
+	store := NewStore(t)
+	defer store.Close()
+	store.SetTopology(topology)
+
+	romanad := store.NewClient()
+	store.SetFaults(common.StoreFaults{ErrorRate: 1})
+	if store.Config().Backend == client.BackendMemory {
+		_, err := romanad.IPAM.AllocateIP("pod1", "host1", "tenant1", "segment1")
+		if err == nil || !strings.Contains(err.Error(), client.ErrChaos.Error()) {
+			t.Fatalf("Expected injected error, got %v", err)
+		}
+	}
+
+	store.SetFaults(common.StoreFaults{})
+	if _, err := store.NewClient().IPAM.AllocateIP("pod1", "host1", "tenant1", "segment1"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"sort"
+
+	"github.com/romana/core/common/api"
+)
+
+// GenerateTopology returns initial topology of the networks for
+// discovered hosts, e.g. nodes of a kubernetes cluster: hosts are
+// grouped by value of their zoneTag tag, each group is in that zone
+// and gets hosts with the tag added later assigned to it. Hosts
+// without the tag are in a group without zone and assignment.
+func GenerateTopology(networks []api.NetworkDefinition, hosts []api.Host, zoneTag string) api.TopologyUpdateRequest {
+	hostsByZone := make(map[string][]api.GroupOrHost)
+	for _, host := range hosts {
+		zone := host.Tags[zoneTag]
+		hostsByZone[zone] = append(hostsByZone[zone], api.GroupOrHost{
+			Name: host.Name,
+			IP:   host.IP,
+		})
+	}
+
+	zones := make([]string, 0, len(hostsByZone))
+	for zone := range hostsByZone {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	groups := make([]api.GroupOrHost, 0, len(zones))
+	for _, zone := range zones {
+		zoneHosts := hostsByZone[zone]
+		sort.Slice(zoneHosts, func(i, j int) bool { return zoneHosts[i].Name < zoneHosts[j].Name })
+		group := api.GroupOrHost{Groups: zoneHosts}
+		if zone != "" {
+			group.Name = zone
+			group.Zone = zone
+			group.Assignment = map[string]string{zoneTag: zone}
+		}
+		groups = append(groups, group)
+	}
+	if len(groups) == 0 {
+		// Hosts are assigned to the group as they are added.
+		groups = append(groups, api.GroupOrHost{Groups: []api.GroupOrHost{}})
+	}
+
+	topology := api.TopologyUpdateRequest{
+		Networks:   networks,
+		Topologies: []api.TopologyDefinition{{Map: groups}},
+	}
+	for _, network := range networks {
+		topology.Topologies[0].Networks = append(topology.Topologies[0].Networks, network.Name)
+	}
+	return topology
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/romana/core/common/api"
+)
+
+func TestGenerateTopology(t *testing.T) {
+	networks := []api.NetworkDefinition{{Name: "net1", CIDR: "10.0.0.0/16", BlockMask: 28}}
+	host := func(name string, ip string, zone string) api.Host {
+		h := api.Host{Name: name, IP: net.ParseIP(ip)}
+		if zone != "" {
+			h.Tags = map[string]string{"zone": zone}
+		}
+		return h
+	}
+	hosts := []api.Host{
+		host("node3", "192.168.0.3", "us-east-1b"),
+		host("node2", "192.168.0.2", "us-east-1a"),
+		host("node1", "192.168.0.1", "us-east-1a"),
+		host("node4", "192.168.0.4", ""),
+	}
+
+	topology := GenerateTopology(networks, hosts, "zone")
+	if len(topology.Topologies) != 1 || len(topology.Topologies[0].Networks) != 1 ||
+		topology.Topologies[0].Networks[0] != "net1" {
+		t.Fatalf("Expected one topology of net1, got %+v", topology.Topologies)
+	}
+	groups := topology.Topologies[0].Map
+	expected := []struct {
+		zone  string
+		hosts []string
+	}{
+		{"", []string{"node4"}},
+		{"us-east-1a", []string{"node1", "node2"}},
+		{"us-east-1b", []string{"node3"}},
+	}
+	if len(groups) != len(expected) {
+		t.Fatalf("Expected %d groups, got %+v", len(expected), groups)
+	}
+	for i, e := range expected {
+		if groups[i].Zone != e.zone || groups[i].Assignment["zone"] != e.zone {
+			t.Errorf("Expected group %d in zone %q, got %+v", i, e.zone, groups[i])
+		}
+		var names []string
+		for _, h := range groups[i].Groups {
+			names = append(names, h.Name)
+		}
+		if !reflect.DeepEqual(names, e.hosts) {
+			t.Errorf("Expected hosts %v in group %d, got %v", e.hosts, i, names)
+		}
+	}
+
+	// Generated topology is valid and hosts get blocks in it.
+	conf, err := json.Marshal(topology)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam = initIpam(t, string(conf))
+	if _, err := ipam.AllocateIPInZone("a", "node3", "ten1", "seg1", "us-east-1b", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without hosts, topology has a group hosts are added to.
+	conf, err = json.Marshal(GenerateTopology(networks, nil, "zone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam = initIpam(t, string(conf))
+	if err := ipam.AddHost(host("node1", "192.168.0.1", "us-east-1a")); err != nil {
+		t.Fatal(err)
+	}
+}
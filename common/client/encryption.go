@@ -0,0 +1,387 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/romana/core/common"
+)
+
+// Values under encrypted prefixes are stored in an envelope: the
+// value is sealed with a random data key using AES-GCM, and the data
+// key is sealed with a key encryption key identified by its id, so
+// that rotating the key encryption key doesn't require re-encrypting
+// values at once. Sealed value is bound to its key, a value copied
+// under another key fails to decrypt. Values stored in plain text
+// before encryption was enabled are read as is and encrypted when
+// written next time, see Store.Reencrypt.
+
+// envelopeMagic starts encrypted values.
+var envelopeMagic = []byte("romana-enc:v1:")
+
+type envelope struct {
+	KeyID string `json:"kid"`
+
+	// DEK is data key sealed with key encryption key.
+	DEK []byte `json:"dek"`
+
+	// Data is value sealed with data key.
+	Data []byte `json:"data"`
+}
+
+// KeyProvider provides key encryption keys, it is implemented
+// by key files and may be implemented by KMS clients.
+type KeyProvider interface {
+	// CurrentKey returns id and key used to encrypt new values.
+	CurrentKey() (string, []byte, error)
+
+	// Key returns key with the id, used to decrypt values.
+	Key(id string) ([]byte, error)
+}
+
+// keyFile implements KeyProvider with keys loaded from a file.
+type keyFile struct {
+	current string
+	keys    map[string][]byte
+}
+
+// LoadKeyFile reads key encryption keys from file, see common.Encryption.
+func LoadKeyFile(path string) (KeyProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open encryption key file: %s", err)
+	}
+	defer f.Close()
+	return parseKeys(f)
+}
+
+func parseKeys(r io.Reader) (*keyFile, error) {
+	kf := &keyFile{keys: make(map[string][]byte)}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid encryption key line %q, expected <key id> <key>", line)
+		}
+		id := fields[0]
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s: %s", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid encryption key %s: expected 32 bytes, got %d", id, len(key))
+		}
+		if _, ok := kf.keys[id]; ok {
+			return nil, fmt.Errorf("encryption key %s is defined twice", id)
+		}
+
+		if kf.current == "" {
+			kf.current = id
+		}
+		kf.keys[id] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if kf.current == "" {
+		return nil, fmt.Errorf("no encryption keys found")
+	}
+	return kf, nil
+}
+
+// CurrentKey implements KeyProvider.
+func (kf *keyFile) CurrentKey() (string, []byte, error) {
+	return kf.current, kf.keys[kf.current], nil
+}
+
+// Key implements KeyProvider.
+func (kf *keyFile) Key(id string) ([]byte, error) {
+	key, ok := kf.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %s", id)
+	}
+	return key, nil
+}
+
+// encryptor encrypts values of keys under prefixes.
+type encryptor struct {
+	keys     KeyProvider
+	prefixes []string
+}
+
+// newEncryptor returns nil if encryption isn't enabled.
+func newEncryptor(conf common.Encryption) (*encryptor, error) {
+	if !conf.IsEnabled() {
+		return nil, nil
+	}
+
+	keys, err := LoadKeyFile(conf.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &encryptor{keys: keys}
+	for _, prefix := range strings.Split(conf.Prefixes, ",") {
+		if strings.TrimSpace(prefix) != "" {
+			e.prefixes = append(e.prefixes, normalize(prefix))
+		}
+	}
+	return e, nil
+}
+
+// encrypts returns true if values of unprefixed key are encrypted,
+// transaction records are encrypted as they may hold any values.
+func (e *encryptor) encrypts(key string) bool {
+	if e == nil {
+		return false
+	}
+	if isUnder(key, txnPrefix) {
+		return true
+	}
+	for _, prefix := range e.prefixes {
+		if key == prefix || isUnder(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// seal encrypts value of unprefixed key.
+func (e *encryptor) seal(key string, value []byte) ([]byte, error) {
+	id, kek, err := e.keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+
+	env := envelope{KeyID: id}
+	if env.DEK, err = sealGCM(kek, dek, []byte(id)); err != nil {
+		return nil, err
+	}
+	if env.Data, err = sealGCM(dek, value, []byte(key)); err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), envelopeMagic...), b...), nil
+}
+
+// open decrypts value of unprefixed key, values that
+// are not encrypted are returned as is.
+func (e *encryptor) open(key string, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, envelopeMagic) {
+		return value, nil
+	}
+	if e == nil {
+		return nil, fmt.Errorf("value of %s is encrypted, but no encryption keys are configured", key)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(value[len(envelopeMagic):], &env); err != nil {
+		return nil, fmt.Errorf("invalid encrypted value of %s: %s", key, err)
+	}
+	kek, err := e.keys.Key(env.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %s", key, err)
+	}
+	dek, err := openGCM(kek, env.DEK, []byte(env.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key of %s: %s", key, err)
+	}
+	plain, err := openGCM(dek, env.Data, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %s", key, err)
+	}
+	return plain, nil
+}
+
+// stale returns true if value of unprefixed key should be
+// re-encrypted, because it's in plain text or was encrypted
+// with a key other than the current one.
+func (e *encryptor) stale(value []byte) bool {
+	if !bytes.HasPrefix(value, envelopeMagic) {
+		return true
+	}
+	var env envelope
+	if err := json.Unmarshal(value[len(envelopeMagic):], &env); err != nil {
+		return false
+	}
+	id, _, err := e.keys.CurrentKey()
+	return err == nil && env.KeyID != id
+}
+
+// sealGCM encrypts plaintext with AES-GCM, nonce is prepended to ciphertext.
+func sealGCM(key []byte, plaintext []byte, ad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func openGCM(key []byte, ciphertext []byte, ad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[aead.NonceSize():], ad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns value to store under the key with prefix.
+func (s *Store) encrypt(key string, value []byte) ([]byte, error) {
+	unprefixed := s.unprefixed(key)
+	if !s.encryptor.encrypts(unprefixed) {
+		return value, nil
+	}
+	return s.encryptor.seal(unprefixed, value)
+}
+
+// Decrypt returns plain value of the key with prefix, it is only
+// needed for values read from the store bypassing Store methods,
+// e.g. with extended etcd watches.
+func (s *Store) Decrypt(key string, value []byte) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return s.encryptor.open(s.unprefixed(key), value)
+}
+
+// decryptPair returns the pair with plain value, pairs that are
+// not encrypted are returned as is and others are copied, since
+// pairs may be shared, e.g. with watches of in-memory backend.
+func (s *Store) decryptPair(kvp *libkvStore.KVPair) (*libkvStore.KVPair, error) {
+	if kvp == nil || !bytes.HasPrefix(kvp.Value, envelopeMagic) {
+		return kvp, nil
+	}
+	value, err := s.Decrypt(kvp.Key, kvp.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &libkvStore.KVPair{Key: kvp.Key, Value: value, LastIndex: kvp.LastIndex}, nil
+}
+
+// decryptPairs returns pairs with plain values, see decryptPair.
+func (s *Store) decryptPairs(kvps []*libkvStore.KVPair) ([]*libkvStore.KVPair, error) {
+	result := make([]*libkvStore.KVPair, len(kvps))
+	for i, kvp := range kvps {
+		var err error
+		result[i], err = s.decryptPair(kvp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Reencrypt writes again values under encrypted prefixes which are
+// in plain text or encrypted with a key that is no longer current,
+// so that old keys can be removed from the key file after rotation.
+// Returns number of values written.
+func (s *Store) Reencrypt() (int, error) {
+	if s.encryptor == nil {
+		return 0, fmt.Errorf("encryption is not configured")
+	}
+
+	var count int
+	for _, prefix := range s.encryptor.prefixes {
+		var kvps []*libkvStore.KVPair
+		err := s.withAuth("list", s.getKey(prefix), func(kv KV) error {
+			var err error
+			kvps, err = kv.List(s.getKey(prefix))
+			return err
+		})
+		if err == libkvStore.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+
+		for _, kvp := range kvps {
+			if !s.encryptor.stale(kvp.Value) {
+				continue
+			}
+			plain, err := s.Decrypt(kvp.Key, kvp.Value)
+			if err != nil {
+				return count, err
+			}
+			sealed, err := s.encrypt(kvp.Key, plain)
+			if err != nil {
+				return count, err
+			}
+
+			// value written meanwhile is encrypted with current key already.
+			var ok bool
+			err = s.withAuth("atomic_put", kvp.Key, func(kv KV) error {
+				var err error
+				ok, _, err = kv.AtomicPut(kvp.Key, sealed, kvp, nil)
+				return err
+			})
+			if err == libkvStore.ErrKeyModified || err == libkvStore.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return count, err
+			}
+			if ok {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
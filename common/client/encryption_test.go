@@ -0,0 +1,190 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/romana/core/common"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestParseKeys(t *testing.T) {
+	kf, err := parseKeys(strings.NewReader("# rotated in March\nnew " + testKey(2) + "\n\nold " + testKey(1) + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, _ := kf.CurrentKey(); id != "new" {
+		t.Fatalf("expected first key to be current, got %s", id)
+	}
+	if _, err := kf.Key("old"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"# comment only",
+		"k1",
+		"k1 not-base64!",
+		"k1 " + base64.StdEncoding.EncodeToString([]byte("short")),
+		"k1 " + testKey(1) + "\nk1 " + testKey(2),
+	} {
+		if _, err := parseKeys(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error for key file %q", bad)
+		}
+	}
+}
+
+func TestEncryptor(t *testing.T) {
+	old, _ := parseKeys(strings.NewReader("old " + testKey(1)))
+	e := &encryptor{keys: old, prefixes: []string{"/policies"}}
+
+	if !e.encrypts("/policies/p1") || e.encrypts("/hosts/h1") {
+		t.Fatal("unexpected encrypted keys")
+	}
+
+	sealed, err := e.seal("/policies/p1", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sealed, envelopeMagic) || bytes.Contains(sealed, []byte("secret")) {
+		t.Fatalf("unexpected sealed value %s", sealed)
+	}
+	plain, err := e.open("/policies/p1", sealed)
+	if err != nil || string(plain) != "secret" {
+		t.Fatalf("unexpected plain value %s, %v", plain, err)
+	}
+
+	if _, err := e.open("/policies/p2", sealed); err == nil {
+		t.Fatal("expected value copied to another key to fail to decrypt")
+	}
+	if plain, err := e.open("/policies/p1", []byte("plain")); err != nil || string(plain) != "plain" {
+		t.Fatalf("expected plain value to be read as is, got %s, %v", plain, err)
+	}
+	if e.stale(sealed) || !e.stale([]byte("plain")) {
+		t.Fatal("unexpected stale values")
+	}
+
+	// after rotation, values sealed with the old key are still
+	// readable, but stale.
+	rotated, _ := parseKeys(strings.NewReader("new " + testKey(2) + "\nold " + testKey(1)))
+	e.keys = rotated
+	if plain, err := e.open("/policies/p1", sealed); err != nil || string(plain) != "secret" {
+		t.Fatalf("unexpected plain value after rotation %s, %v", plain, err)
+	}
+	if !e.stale(sealed) {
+		t.Fatal("expected value sealed with old key to be stale")
+	}
+
+	var nilEncryptor *encryptor
+	if _, err := nilEncryptor.open("/policies/p1", sealed); err == nil {
+		t.Fatal("expected encrypted value to fail without keys")
+	}
+}
+
+func TestStoreEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romana-encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "keys")
+	if err := ioutil.WriteFile(keyFile, []byte("old "+testKey(1)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(&common.Config{
+		Backend:    BackendMemory,
+		EtcdPrefix: "/romanaTest",
+		Encryption: common.Encryption{KeyFile: keyFile, Prefixes: "/policies"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	raw := func(key string) []byte {
+		kvp, err := store.kv().Get(store.getKey(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kvp.Value
+	}
+
+	if err := store.PutObject("/policies/p1", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutObject("/hosts/h1", []byte("host")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw("/policies/p1"), envelopeMagic) {
+		t.Fatalf("expected policy to be encrypted, got %s", raw("/policies/p1"))
+	}
+	if string(raw("/hosts/h1")) != "host" {
+		t.Fatalf("expected host to be in plain text, got %s", raw("/hosts/h1"))
+	}
+
+	kvp, err := store.Get("/policies/p1")
+	if err != nil || string(kvp.Value) != "secret" {
+		t.Fatalf("unexpected policy %v, %v", kvp, err)
+	}
+	kvps, err := store.ListObjects("/policies")
+	if err != nil || len(kvps) != 1 || string(kvps[0].Value) != "secret" {
+		t.Fatalf("unexpected policies %v, %v", kvps, err)
+	}
+
+	// value written before encryption was enabled.
+	if err := store.kv().Put(store.getKey("/policies/p2"), []byte("legacy"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if kvp, err := store.Get("/policies/p2"); err != nil || string(kvp.Value) != "legacy" {
+		t.Fatalf("unexpected legacy policy %v, %v", kvp, err)
+	}
+
+	// rotate keys, only the legacy value and the value sealed
+	// with the old key are written again.
+	if err := ioutil.WriteFile(keyFile, []byte("new "+testKey(2)+"\nold "+testKey(1)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if store.encryptor.keys, err = LoadKeyFile(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	count, err := store.Reencrypt()
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 values to be re-encrypted, got %d, %v", count, err)
+	}
+	if count, err := store.Reencrypt(); err != nil || count != 0 {
+		t.Fatalf("expected nothing to re-encrypt, got %d, %v", count, err)
+	}
+	for key, expect := range map[string]string{"/policies/p1": "secret", "/policies/p2": "legacy"} {
+		if !bytes.HasPrefix(raw(key), envelopeMagic) {
+			t.Fatalf("expected %s to be encrypted", key)
+		}
+		if kvp, err := store.Get(key); err != nil || string(kvp.Value) != expect {
+			t.Fatalf("unexpected value of %s %v, %v", key, kvp, err)
+		}
+	}
+}
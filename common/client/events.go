@@ -0,0 +1,293 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+	log "github.com/romana/rlog"
+)
+
+// Components record events of the cluster under EventsPrefix, one key
+// per event, keyed by time of the event so that keys sort in order of
+// events. Events expire after DefaultEventTTL, and romanad trims the
+// oldest of them beyond a maximum number, see TrimEvents, so that the
+// stream is bounded. Recorders compact repeats of an event into one
+// event counting them, so that a recurring failure doesn't push
+// everything else out of the stream. Events are written by recorders
+// asynchronously, so that operations events are about don't wait for
+// the store, and repeats recorded before an event is written are
+// written once.
+
+const (
+	// DefaultEventTTL is how long events are kept.
+	DefaultEventTTL = 24 * time.Hour
+
+	// DefaultMaxEvents is the number of events kept by TrimEvents.
+	DefaultMaxEvents = 1000
+
+	// eventCompactionWindow is how long after last repeat of an
+	// event the next repeat is counted by the same event.
+	eventCompactionWindow = 10 * time.Minute
+
+	// maxPendingEvents is the number of events waiting to be written
+	// by a recorder, above which new events are dropped.
+	maxPendingEvents = 256
+
+	// eventIDTime is the format of time in IDs of events,
+	// which sorts in the order of time.
+	eventIDTime = "20060102T150405.000000000Z"
+)
+
+// newEventID returns ID of an event that happened at t, IDs
+// of events sort in order of time they happened at.
+func newEventID(t time.Time) string {
+	return t.UTC().Format(eventIDTime) + "-" + common.NewRequestID()[:8]
+}
+
+// eventKey returns the key of the event with the ID.
+func eventKey(id string) string {
+	return EventsPrefix + "/" + id
+}
+
+// EventRecorder records events of a component running on a host.
+type EventRecorder struct {
+	store     *Store
+	component string
+	host      string
+	ttl       time.Duration
+
+	mu sync.Mutex
+	// recent are events repeats are counted by,
+	// by type, reason, object and message.
+	recent map[string]*api.Event
+	// pending are encoded events waiting to be written, by ID.
+	pending map[string][]byte
+	// writing is true while pending events are written,
+	// and written is signalled once there are none.
+	writing bool
+	written *sync.Cond
+}
+
+// NewEventRecorder returns recorder of events of the component
+// running on host, which is empty for cluster-wide components.
+func NewEventRecorder(store *Store, component string, host string) *EventRecorder {
+	r := &EventRecorder{
+		store:     store,
+		component: component,
+		host:      host,
+		ttl:       DefaultEventTTL,
+		recent:    make(map[string]*api.Event),
+		pending:   make(map[string][]byte),
+	}
+	r.written = sync.NewCond(&r.mu)
+	return r
+}
+
+// Eventf records an event of the object with message formatted
+// according to format. It doesn't wait for the event to be written,
+// failures to write are logged rather than returned, so that they
+// don't fail operations events are about, see Flush.
+// It does nothing if the recorder is nil.
+func (r *EventRecorder) Eventf(eventType string, reason string, object api.EventObject, format string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	key := strings.Join([]string{eventType, reason, object.Kind, object.Name, message}, "\x00")
+	now := time.Now().UTC()
+
+	r.mu.Lock()
+	for k, event := range r.recent {
+		if now.Sub(event.LastTime) > eventCompactionWindow {
+			delete(r.recent, k)
+		}
+	}
+	event, ok := r.recent[key]
+	if ok {
+		event.Count++
+		event.LastTime = now
+	} else {
+		event = &api.Event{
+			ID:        newEventID(now),
+			Type:      eventType,
+			Reason:    reason,
+			Component: r.component,
+			Host:      r.host,
+			Object:    object,
+			Message:   message,
+			Time:      now,
+			LastTime:  now,
+			Count:     1,
+		}
+		r.recent[key] = event
+	}
+	defer r.mu.Unlock()
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Failed to record event %s of %s: %s", reason, object, err)
+		return
+	}
+	if _, ok := r.pending[event.ID]; !ok && len(r.pending) >= maxPendingEvents {
+		log.Errorf("Dropped event %s of %s, %d events are waiting to be written", reason, object, len(r.pending))
+		return
+	}
+	r.pending[event.ID] = value
+	if !r.writing {
+		r.writing = true
+		go r.write()
+	}
+}
+
+// write writes pending events in batches until there are none,
+// an event repeated while it's pending is written once.
+func (r *EventRecorder) write() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.pending) > 0 {
+		batch := r.pending
+		r.pending = make(map[string][]byte)
+		r.mu.Unlock()
+
+		ids := make([]string, 0, len(batch))
+		for id := range batch {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if err := r.store.PutObjectWithTTL(eventKey(id), batch[id], r.ttl); err != nil {
+				log.Errorf("Failed to write event %s: %s", id, err)
+			}
+		}
+
+		r.mu.Lock()
+	}
+	r.writing = false
+	r.written.Broadcast()
+}
+
+// Flush waits for recorded events to be written, it should be called
+// before exit by short lived components such as the CNI plugin.
+// It does nothing if the recorder is nil.
+func (r *EventRecorder) Flush() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.writing {
+		r.written.Wait()
+	}
+}
+
+// RecordEvents returns recorder of events of the component running
+// on host, changes of IPAM made through the client are recorded as
+// events of the component too.
+func (c *Client) RecordEvents(component string, host string) *EventRecorder {
+	c.events = NewEventRecorder(c.Store, component, host)
+	if c.IPAM != nil {
+		c.IPAM.events = c.events
+	}
+	return c.events
+}
+
+// ListEvents returns events kept in the store, oldest first.
+func (c *Client) ListEvents() ([]api.Event, error) {
+	values, err := c.Store.ListTree(EventsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]api.Event, 0, len(values))
+	for key, value := range values {
+		var event api.Event
+		if err := json.Unmarshal(value, &event); err != nil {
+			log.Errorf("Failed to parse event %s: %s", key, err)
+			continue
+		}
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// TrimEvents deletes the oldest events in the store beyond
+// maxEvents, and returns the number of events deleted.
+func (c *Client) TrimEvents(maxEvents int) (int, error) {
+	values, err := c.Store.ListTree(EventsPrefix)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) <= maxEvents {
+		return 0, nil
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	deleted := 0
+	for _, key := range keys[:len(keys)-maxEvents] {
+		if _, err := c.Store.Delete(key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// WatchEvents sends events as they are recorded, and again whenever
+// they are repeated, until stopCh is closed. Events in the store
+// when watch starts are sent first.
+func (c *Client) WatchEvents(stopCh <-chan struct{}) (<-chan api.Event, error) {
+	changes, err := c.Store.WatchTreeChanges(c.Store.Key(EventsPrefix), stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan api.Event)
+	go func() {
+		defer close(out)
+		for batch := range changes {
+			for _, change := range batch {
+				if change.Value == nil {
+					// trimmed or expired
+					continue
+				}
+				var event api.Event
+				if err := json.Unmarshal(change.Value, &event); err != nil {
+					log.Errorf("Failed to parse event %s: %s", change.Key, err)
+					continue
+				}
+				select {
+				case out <- event:
+				case <-stopCh:
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+)
+
+func TestEvents(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	c := &Client{Store: store}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	watched, err := c.WatchEvents(stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWatched := func(reason string, count int) {
+		select {
+		case event := <-watched:
+			if event.Reason != reason || event.Count != count {
+				t.Fatalf("expected %s repeated %d times, got %+v", reason, count, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s", reason)
+		}
+	}
+
+	recorder := c.RecordEvents("romana_agent", "host1")
+	host := api.EventObject{Kind: api.EventKindHost, Name: "host1"}
+	recorder.Eventf(api.EventWarning, "RoutesFailed", host, "failed to flush %s", "table")
+	expectWatched("RoutesFailed", 1)
+
+	// repeats are counted by the same event.
+	recorder.Eventf(api.EventWarning, "RoutesFailed", host, "failed to flush %s", "table")
+	expectWatched("RoutesFailed", 2)
+	recorder.Eventf(api.EventNormal, "RoutesProgrammed", host, "programmed routes")
+	expectWatched("RoutesProgrammed", 1)
+
+	events, err := c.ListEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Reason != "RoutesFailed" || events[1].Reason != "RoutesProgrammed" {
+		t.Fatalf("expected RoutesFailed and RoutesProgrammed events, got %+v", events)
+	}
+	if e := events[0]; e.Count != 2 || e.Component != "romana_agent" || e.Host != "host1" ||
+		e.Message != "failed to flush table" || e.LastTime.Before(e.Time) {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	// oldest events are trimmed.
+	deleted, err := c.TrimEvents(1)
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 event trimmed, got %d, %v", deleted, err)
+	}
+	events, err = c.ListEvents()
+	if err != nil || len(events) != 1 || events[0].Reason != "RoutesProgrammed" {
+		t.Fatalf("expected RoutesProgrammed event to be kept, got %+v, %v", events, err)
+	}
+
+	// nil recorder records nothing.
+	var none *EventRecorder
+	none.Eventf(api.EventNormal, "Ignored", host, "ignored")
+	none.Flush()
+}
+
+func TestIPAMEvents(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	c := &Client{Store: store}
+
+	ipam = initIpam(t, `{"networks": [{"name": "net1", "cidr": "10.0.0.0/16", "block_mask": 28}],
+		"topologies": [{"networks": ["net1"], "map": [{"groups": [{"name": "host1", "ip": "192.168.0.1"}]}]}]}`)
+	ipam.events = NewEventRecorder(store, "romanad", "")
+	if _, err := ipam.AllocateIP("addr1", "host1", "ten1", "seg1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipam.AllocateIP("addr1", "host1", "ten1", "seg1"); err == nil {
+		t.Fatal("expected allocation of existing address to fail")
+	}
+	if err := ipam.DeallocateIP("addr1"); err != nil {
+		t.Fatal(err)
+	}
+	ipam.events.Flush()
+
+	events, err := c.ListEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reasons []string
+	for _, event := range events {
+		if event.Object.Name != "addr1" {
+			t.Errorf("expected event of addr1, got %+v", event)
+		}
+		reasons = append(reasons, event.Reason)
+	}
+	if len(reasons) != 3 || reasons[0] != "AddressAllocated" || reasons[1] != "AllocationFailed" || reasons[2] != "AddressDeallocated" {
+		t.Errorf("expected allocation, failure and deallocation events, got %v", reasons)
+	}
+}
+
+func TestEventsFlush(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	c := &Client{Store: store}
+
+	recorder := c.RecordEvents("romana-cni", "host1")
+	pod := api.EventObject{Kind: api.EventKindPod, Name: "pod1"}
+	for i := 0; i < 10; i++ {
+		recorder.Eventf(api.EventWarning, "PodSetupFailed", pod, "failed")
+	}
+	recorder.Flush()
+
+	events, err := c.ListEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Count != 10 {
+		t.Fatalf("expected one event repeated 10 times, got %+v", events)
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+// This file has functionality of the parent IPAM of a federation of
+// clusters, which delegates super-blocks of shared pools to clusters.
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/api/errors"
+)
+
+// parseFederationPool validates the pool and returns its CIDR.
+func parseFederationPool(pool *api.FederationPool) (CIDR, error) {
+	if pool.Name == "" {
+		return CIDR{}, common.NewError400("Pool name required")
+	}
+	_, ipNet, err := net.ParseCIDR(pool.CIDR)
+	if err != nil {
+		return CIDR{}, common.NewError400(fmt.Sprintf("Invalid CIDR %q of pool %s", pool.CIDR, pool.Name))
+	}
+	if ipNet.IP.To4() == nil {
+		return CIDR{}, common.NewError400(fmt.Sprintf("Invalid CIDR %q of pool %s, only IPv4 pools are supported", pool.CIDR, pool.Name))
+	}
+	pool.CIDR = ipNet.String()
+
+	ones, bits := ipNet.Mask.Size()
+	if pool.SuperBlockMask < uint(ones) || pool.SuperBlockMask > uint(bits) {
+		return CIDR{}, common.NewError400(fmt.Sprintf(
+			"invalid super_block_mask(%d) for pool(%s), must be %d <= super_block_mask <= %d",
+			pool.SuperBlockMask, pool.Name, ones, bits))
+	}
+	return NewCIDR(pool.CIDR)
+}
+
+// overlaps returns true if CIDRs have any address in common.
+func overlaps(c CIDR, c2 CIDR) bool {
+	return c.StartIPInt <= c2.EndIPInt && c2.StartIPInt <= c.EndIPInt
+}
+
+// nextSuperBlock returns the first super-block of the pool
+// that doesn't overlap delegated ones, or false if there's none.
+func nextSuperBlock(pool CIDR, mask uint, delegated []CIDR) (CIDR, bool) {
+	ones, bits := pool.Mask.Size()
+	size := uint64(1) << (uint(bits) - mask)
+	count := uint64(1) << (mask - uint(ones))
+
+	for i := uint64(0); i < count; i++ {
+		start := pool.StartIPInt + i*size
+		candidate := CIDR{StartIPInt: start, EndIPInt: start + size - 1}
+		free := true
+		for _, d := range delegated {
+			if overlaps(candidate, d) {
+				// skip super-blocks up to the end of the delegated one.
+				i = (d.EndIPInt - pool.StartIPInt) / size
+				free = false
+				break
+			}
+		}
+		if free {
+			cidr, err := NewCIDR(fmt.Sprintf("%s/%d", common.IntToIPv4(start), mask))
+			return cidr, err == nil
+		}
+	}
+	return CIDR{}, false
+}
+
+// delegationCIDR returns CIDR of the delegation.
+func delegationCIDR(d *api.Delegation) CIDR {
+	cidr, _ := NewCIDR(d.CIDR.String())
+	return cidr
+}
+
+// checkFederationPools returns an error if networks of the
+// topology overlap pools delegated to federated clusters.
+func (ipam *IPAM) checkFederationPools() error {
+	for _, pool := range ipam.FederationPools {
+		cidr, err := NewCIDR(pool.CIDR)
+		if err != nil {
+			return err
+		}
+		for _, network := range ipam.Networks {
+			if overlaps(cidr, network.CIDR) {
+				return common.NewErrorConflict(fmt.Sprintf("Network %s (%s) overlaps pool %s (%s)", network.Name, network.CIDR, pool.Name, pool.CIDR))
+			}
+		}
+	}
+	return nil
+}
+
+// AddFederationPool defines the pool, RomanaExistsError is returned
+// if it is defined already. Pools must not overlap each other, nor
+// networks of the topology of the parent itself.
+func (ipam *IPAM) AddFederationPool(pool api.FederationPool) error {
+	cidr, err := parseFederationPool(&pool)
+	if err != nil {
+		return err
+	}
+
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := latestIPAM.FederationPools[pool.Name]; ok {
+		return errors.NewRomanaExistsErrorWithMessage(
+			fmt.Sprintf("Pool %s already exists", pool.Name),
+			pool, "pool", "name="+pool.Name)
+	}
+	for _, other := range latestIPAM.FederationPools {
+		if otherCIDR, err := NewCIDR(other.CIDR); err == nil && overlaps(cidr, otherCIDR) {
+			return common.NewErrorConflict(fmt.Sprintf("Pool %s (%s) overlaps pool %s (%s)", pool.Name, pool.CIDR, other.Name, other.CIDR))
+		}
+	}
+	for _, network := range latestIPAM.Networks {
+		if overlaps(cidr, network.CIDR) {
+			return common.NewErrorConflict(fmt.Sprintf("Pool %s (%s) overlaps network %s (%s)", pool.Name, pool.CIDR, network.Name, network.CIDR))
+		}
+	}
+
+	if latestIPAM.FederationPools == nil {
+		latestIPAM.FederationPools = make(map[string]*api.FederationPool)
+	}
+	latestIPAM.FederationPools[pool.Name] = &pool
+	return ipam.save(latestIPAM, ch)
+}
+
+// DeleteFederationPool deletes the pool. It fails while super-blocks
+// of the pool are delegated, unless force is true, in which case
+// the delegations are deleted too.
+func (ipam *IPAM) DeleteFederationPool(name string, force bool) error {
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := latestIPAM.FederationPools[name]; !ok {
+		return errors.NewRomanaNotFoundError(fmt.Sprintf("Pool %s not found", name), "pool", "name="+name)
+	}
+	var kept []*api.Delegation
+	for _, d := range latestIPAM.Delegations {
+		if d.Pool != name {
+			kept = append(kept, d)
+		}
+	}
+	if delegated := len(latestIPAM.Delegations) - len(kept); delegated > 0 && !force {
+		return errors.NewRomanaExistsErrorWithMessage(
+			fmt.Sprintf("Pool %s has %d super-blocks delegated", name, delegated),
+			name, "delegation", "pool="+name)
+	}
+	delete(latestIPAM.FederationPools, name)
+	latestIPAM.Delegations = kept
+	return ipam.save(latestIPAM, ch)
+}
+
+// DelegateSuperBlock delegates the first free super-block of the
+// pool to the cluster. RomanaQuotaExceededError is returned if all
+// super-blocks of the pool are delegated already.
+func (ipam *IPAM) DelegateSuperBlock(req api.DelegationRequest) (api.Delegation, error) {
+	if req.Cluster == "" {
+		return api.Delegation{}, common.NewError400("Cluster name required")
+	}
+
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return api.Delegation{}, err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return api.Delegation{}, err
+	}
+
+	pool, ok := latestIPAM.FederationPools[req.Pool]
+	if !ok {
+		return api.Delegation{}, errors.NewRomanaNotFoundError(fmt.Sprintf("Pool %s not found", req.Pool), "pool", "name="+req.Pool)
+	}
+	poolCIDR, err := NewCIDR(pool.CIDR)
+	if err != nil {
+		return api.Delegation{}, err
+	}
+	var delegated []CIDR
+	for _, d := range latestIPAM.Delegations {
+		if d.Pool == pool.Name {
+			delegated = append(delegated, delegationCIDR(d))
+		}
+	}
+	superBlock, ok := nextSuperBlock(poolCIDR, pool.SuperBlockMask, delegated)
+	if !ok {
+		return api.Delegation{}, errors.NewRomanaQuotaExceededError(
+			fmt.Sprintf("All super-blocks of pool %s are delegated", pool.Name), "pool", pool.Name)
+	}
+
+	delegation := &api.Delegation{
+		Pool:    pool.Name,
+		Cluster: req.Cluster,
+		CIDR:    api.IPNet{IPNet: *superBlock.IPNet},
+	}
+	latestIPAM.Delegations = append(latestIPAM.Delegations, delegation)
+	if err := ipam.save(latestIPAM, ch); err != nil {
+		return api.Delegation{}, err
+	}
+	return *delegation, nil
+}
+
+// ReleaseDelegation returns the super-block to its pool. It fails
+// while the cluster reports addresses allocated in the super-block,
+// unless force is true.
+func (ipam *IPAM) ReleaseDelegation(cidrStr string, force bool) error {
+	_, ipNet, err := net.ParseCIDR(cidrStr)
+	if err != nil {
+		return common.NewError400(fmt.Sprintf("Invalid CIDR %q", cidrStr))
+	}
+
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	for i, d := range latestIPAM.Delegations {
+		if d.CIDR.String() != ipNet.String() {
+			continue
+		}
+		if d.Allocated > 0 && !force {
+			return errors.NewRomanaExistsErrorWithMessage(
+				fmt.Sprintf("Super-block %s of cluster %s has %d addresses allocated", d.CIDR, d.Cluster, d.Allocated),
+				d.CIDR.String(), "address", "cidr="+d.CIDR.String())
+		}
+		latestIPAM.Delegations = append(latestIPAM.Delegations[:i], latestIPAM.Delegations[i+1:]...)
+		return ipam.save(latestIPAM, ch)
+	}
+	return errors.NewRomanaNotFoundError(fmt.Sprintf("Super-block %s is not delegated", ipNet), "delegation", "cidr="+ipNet.String())
+}
+
+// ReportClusterUsage records networks of the cluster and addresses
+// allocated in them to super-blocks delegated to the cluster. Report
+// with networks outside of the super-blocks is rejected, since their
+// addresses may overlap addresses of other clusters.
+func (ipam *IPAM) ReportClusterUsage(cluster string, usage api.ClusterUsage) error {
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	var delegations []*api.Delegation
+	for _, d := range latestIPAM.Delegations {
+		if d.Cluster == cluster {
+			d.Networks = nil
+			d.Allocated = 0
+			d.Reported = time.Now().UTC()
+			delegations = append(delegations, d)
+		}
+	}
+	if len(delegations) == 0 {
+		return errors.NewRomanaNotFoundError(fmt.Sprintf("Cluster %s has no super-blocks delegated", cluster), "delegation", "cluster="+cluster)
+	}
+
+	var outside []string
+	for _, network := range usage.Networks {
+		networkCIDR, err := NewCIDR(network.CIDR.String())
+		if err != nil {
+			return err
+		}
+		var delegation *api.Delegation
+		for _, d := range delegations {
+			if delegationCIDR(d).Contains(networkCIDR) {
+				delegation = d
+				break
+			}
+		}
+		if delegation == nil {
+			outside = append(outside, fmt.Sprintf("%s (%s)", network.Name, network.CIDR))
+			continue
+		}
+		delegation.Networks = append(delegation.Networks, network.Name)
+		delegation.Allocated += network.Allocated
+	}
+	if len(outside) > 0 {
+		return common.NewUnprocessableEntityError(
+			fmt.Sprintf("Networks %v of cluster %s are not in super-blocks delegated to it", outside, cluster))
+	}
+	return ipam.save(latestIPAM, ch)
+}
+
+// ListFederationPools returns pools with utilization
+// of their super-blocks, sorted by name.
+func (ipam *IPAM) ListFederationPools() []api.FederationPoolResponse {
+	result := make([]api.FederationPoolResponse, 0, len(ipam.FederationPools))
+	for _, pool := range ipam.FederationPools {
+		p := api.FederationPoolResponse{FederationPool: *pool}
+		if cidr, err := NewCIDR(pool.CIDR); err == nil {
+			ones, _ := cidr.Mask.Size()
+			p.SuperBlocks = uint64(1) << (pool.SuperBlockMask - uint(ones))
+		}
+		for _, d := range ipam.Delegations {
+			if d.Pool != pool.Name {
+				continue
+			}
+			cidr := delegationCIDR(d)
+			p.Delegated++
+			p.Size += cidr.EndIPInt - cidr.StartIPInt + 1
+			p.Allocated += d.Allocated
+		}
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// ListDelegations returns delegated super-blocks sorted by CIDR.
+func (ipam *IPAM) ListDelegations() []api.Delegation {
+	result := make([]api.Delegation, 0, len(ipam.Delegations))
+	for _, d := range ipam.Delegations {
+		result = append(result, *d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return common.IPv4ToInt(result[i].CIDR.IP) < common.IPv4ToInt(result[j].CIDR.IP)
+	})
+	return result
+}
+
+// NetworksOutsideDelegations returns networks of the topology that
+// are not in any of the delegated super-blocks, a federated cluster
+// must not define them since their addresses may overlap addresses
+// of other clusters.
+func NetworksOutsideDelegations(networks []api.NetworkDefinition, delegations []api.Delegation) []string {
+	var outside []string
+	for _, network := range networks {
+		networkCIDR, err := NewCIDR(network.CIDR)
+		contained := false
+		for i := range delegations {
+			if err == nil && delegationCIDR(&delegations[i]).Contains(networkCIDR) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			outside = append(outside, fmt.Sprintf("%s (%s)", network.Name, network.CIDR))
+		}
+	}
+	return outside
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/api/errors"
+)
+
+func TestNextSuperBlock(t *testing.T) {
+	pool, _ := NewCIDR("10.0.0.0/8")
+	var delegated []CIDR
+	for _, expected := range []string{"10.0.0.0/10", "10.64.0.0/10", "10.128.0.0/10", "10.192.0.0/10"} {
+		superBlock, ok := nextSuperBlock(pool, 10, delegated)
+		if !ok || superBlock.String() != expected {
+			t.Fatalf("Expected %s, got %s, %t", expected, superBlock, ok)
+		}
+		delegated = append(delegated, superBlock)
+	}
+	if superBlock, ok := nextSuperBlock(pool, 10, delegated); ok {
+		t.Fatalf("Expected pool to be exhausted, got %s", superBlock)
+	}
+
+	// Delegation larger than a super-block is skipped as a whole.
+	larger, _ := NewCIDR("10.0.0.0/9")
+	superBlock, ok := nextSuperBlock(pool, 10, []CIDR{larger, delegated[3]})
+	if !ok || superBlock.String() != "10.128.0.0/10" {
+		t.Fatalf("Expected 10.128.0.0/10, got %s, %t", superBlock, ok)
+	}
+}
+
+func TestFederation(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipam = initIpam(t, string(conf))
+	if err := ipam.AddFederationPool(api.FederationPool{Name: "pool1", CIDR: "10.0.0.0/8", SuperBlockMask: 16}); err == nil {
+		t.Fatal("Expected pool overlapping net1 to fail")
+	}
+	if err := ipam.AddFederationPool(api.FederationPool{Name: "pool1", CIDR: "10.1.0.0/16", SuperBlockMask: 8}); err == nil {
+		t.Fatal("Expected pool with super_block_mask shorter than its mask to fail")
+	}
+	if err := ipam.AddFederationPool(api.FederationPool{Name: "pool1", CIDR: "10.1.2.3/16", SuperBlockMask: 17}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.AddFederationPool(api.FederationPool{Name: "pool1", CIDR: "10.2.0.0/16", SuperBlockMask: 17}); err == nil {
+		t.Fatal("Expected add of existing pool to fail")
+	}
+	if err := ipam.AddFederationPool(api.FederationPool{Name: "pool2", CIDR: "10.1.128.0/17", SuperBlockMask: 17}); err == nil {
+		t.Fatal("Expected pool overlapping pool1 to fail")
+	}
+
+	ipam.load(ipam, nil)
+	topoReq := api.TopologyUpdateRequest{}
+	if err := json.Unmarshal(bytes.Replace(conf, []byte("10.0.0.0/31"), []byte("10.1.0.0/24"), 1), &topoReq); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.UpdateTopology(topoReq, true); err == nil {
+		t.Fatal("Expected topology with network overlapping pool1 to fail")
+	}
+
+	for _, expected := range []string{"10.1.0.0/17", "10.1.128.0/17"} {
+		delegation, err := ipam.DelegateSuperBlock(api.DelegationRequest{Pool: "pool1", Cluster: "east"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delegation.CIDR.String() != expected {
+			t.Fatalf("Expected %s, got %s", expected, delegation.CIDR)
+		}
+	}
+	if _, err := ipam.DelegateSuperBlock(api.DelegationRequest{Pool: "pool1", Cluster: "west"}); err == nil {
+		t.Fatal("Expected delegation from exhausted pool to fail")
+	} else if _, ok := err.(errors.RomanaQuotaExceededError); !ok {
+		t.Fatalf("Expected RomanaQuotaExceededError, got %T: %s", err, err)
+	}
+
+	usage := api.ClusterUsage{Networks: []api.IPAMNetworkResponse{{Name: "net1", Allocated: 3}}}
+	usage.Networks[0].CIDR.IPNet = parseIPNet(t, "10.1.0.0/24")
+	if err := ipam.ReportClusterUsage("west", usage); err == nil {
+		t.Fatal("Expected report of cluster without delegations to fail")
+	}
+	if err := ipam.ReportClusterUsage("east", usage); err != nil {
+		t.Fatal(err)
+	}
+	outside := usage
+	outside.Networks = append(outside.Networks, api.IPAMNetworkResponse{Name: "net2"})
+	outside.Networks[1].CIDR.IPNet = parseIPNet(t, "10.2.0.0/24")
+	if err := ipam.ReportClusterUsage("east", outside); err == nil {
+		t.Fatal("Expected report of network outside of delegations to fail")
+	}
+
+	ipam.load(ipam, nil)
+	pools := ipam.ListFederationPools()
+	if len(pools) != 1 || pools[0].SuperBlocks != 2 || pools[0].Delegated != 2 || pools[0].Size != 65536 || pools[0].Allocated != 3 {
+		t.Fatalf("Unexpected pools %+v", pools)
+	}
+	delegations := ipam.ListDelegations()
+	if len(delegations) != 2 || len(delegations[0].Networks) != 1 || delegations[0].Allocated != 3 {
+		t.Fatalf("Unexpected delegations %+v", delegations)
+	}
+
+	if err := ipam.ReleaseDelegation("10.1.0.0/17", false); err == nil {
+		t.Fatal("Expected release of super-block with addresses allocated to fail")
+	}
+	if err := ipam.ReleaseDelegation("10.1.128.0/17", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.DeleteFederationPool("pool1", false); err == nil {
+		t.Fatal("Expected delete of pool with delegations to fail")
+	}
+	if err := ipam.DeleteFederationPool("pool1", true); err != nil {
+		t.Fatal(err)
+	}
+
+	ipam.load(ipam, nil)
+	if len(ipam.FederationPools) != 0 || len(ipam.Delegations) != 0 {
+		t.Fatalf("Expected no pools and delegations, got %+v, %+v", ipam.FederationPools, ipam.Delegations)
+	}
+}
+
+func TestNetworksOutsideDelegations(t *testing.T) {
+	delegations := []api.Delegation{{Pool: "pool1", Cluster: "east"}}
+	delegations[0].CIDR.IPNet = parseIPNet(t, "10.1.0.0/17")
+
+	networks := []api.NetworkDefinition{
+		{Name: "net1", CIDR: "10.1.0.0/24"},
+		{Name: "net2", CIDR: "10.1.0.0/16"},
+		{Name: "net3", CIDR: "10.2.0.0/24"},
+	}
+	outside := NetworksOutsideDelegations(networks, delegations)
+	if len(outside) != 2 || outside[0] != "net2 (10.1.0.0/16)" || outside[1] != "net3 (10.2.0.0/24)" {
+		t.Fatalf("Expected net2 and net3 outside, got %v", outside)
+	}
+}
+
+func parseIPNet(t *testing.T, s string) net.IPNet {
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *ipNet
+}
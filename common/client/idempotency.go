@@ -0,0 +1,65 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/romana/core/common"
+
+	libkvStore "github.com/docker/libkv/store"
+)
+
+// idempotencyPrefix is the prefix responses to requests
+// with idempotency keys are kept under.
+const idempotencyPrefix = "/idempotency"
+
+// IdempotencyStore is common.IdempotencyStore keeping
+// responses in the store, where they expire after their TTL.
+type IdempotencyStore struct {
+	store *Store
+}
+
+// NewIdempotencyStore returns IdempotencyStore keeping responses in store.
+func NewIdempotencyStore(store *Store) *IdempotencyStore {
+	return &IdempotencyStore{store: store}
+}
+
+// GetResponse implements common.IdempotencyStore.
+func (s *IdempotencyStore) GetResponse(key string) (*common.IdempotentResponse, error) {
+	kvp, err := s.store.Get(idempotencyPrefix + "/" + key)
+	if err == libkvStore.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var response common.IdempotentResponse
+	if err := json.Unmarshal(kvp.Value, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// PutResponse implements common.IdempotencyStore.
+func (s *IdempotencyStore) PutResponse(key string, response common.IdempotentResponse, ttl time.Duration) error {
+	value, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return s.store.PutObjectWithTTL(idempotencyPrefix+"/"+key, value, ttl)
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/romana/core/common"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := NewIdempotencyStore(store)
+
+	response, err := s.GetResponse("key1")
+	if err != nil || response != nil {
+		t.Fatalf("expected no response, got %v, %v", response, err)
+	}
+
+	put := common.IdempotentResponse{Request: "digest", Status: 200, ContentType: "application/json", Body: []byte(`{"ip":"10.0.0.1"}`)}
+	if err := s.PutResponse("key1", put, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	response, err = s.GetResponse("key1")
+	if err != nil || response == nil || response.Status != 200 || string(response.Body) != string(put.Body) {
+		t.Fatalf("expected response %+v, got %+v, %v", put, response, err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	response, err = s.GetResponse("key1")
+	if err != nil || response != nil {
+		t.Fatalf("expected expired response, got %v, %v", response, err)
+	}
+}
@@ -152,11 +152,11 @@ func (ir IDRing) Invert() *IDRing {
 	for _, r := range ir.Ranges {
 		if prevMin < r.Min {
 			ranges = append(ranges, Range{Min: prevMin, Max: r.Min - 1})
-			if r.Max == ir.OrigMax {
-				prevMin = r.Max
-			} else {
-				prevMin = r.Max + 1
-			}
+		}
+		// IDs up to the end of this range are available,
+		// also when the range starts at OrigMin.
+		if r.Max < ir.OrigMax {
+			prevMin = r.Max + 1
 		}
 	}
 	lastRange := ir.Ranges[len(ir.Ranges)-1]
@@ -179,7 +179,7 @@ func (ir IDRing) IsEmpty() bool {
 		ir.locker.Lock()
 		defer ir.locker.Unlock()
 	}
-	if len(ir.Ranges) > 1 {
+	if len(ir.Ranges) != 1 {
 		return false
 	}
 	r := ir.Ranges[0]
@@ -290,22 +290,16 @@ func (idRing *IDRing) ReclaimIDNoLock(id uint64) error {
 
 	done := false
 	for i, _ := range idRing.Ranges {
-		prevRanges := make([]Range, 0)
-		if i > 0 {
-			prevRanges = idRing.Ranges[0:i]
-		}
 		curRange := idRing.Ranges[i]
-		follRanges := make([]Range, 0)
-		if i+1 < len(idRing.Ranges) {
-			follRanges = idRing.Ranges[i : i+1]
-		}
-		//		log.Tracef(trace.Inside, "ReclaimID: prevRanges %s, curRange %s, follRanges %s", prevRanges, curRange, follRanges)
 		if id < curRange.Min {
 			// If id is smaller than the lowest bound of the first range, create an
 			// range of its own for it, and insert it prior to this current range.
-			newRange := Range{Min: id, Max: id}
-			newRanges := append(prevRanges, newRange, curRange)
-			newRanges = append(newRanges, follRanges...)
+			// Ranges are copied, appending to a slice of them would overwrite
+			// the ranges that follow.
+			newRanges := make([]Range, 0, len(idRing.Ranges)+1)
+			newRanges = append(newRanges, idRing.Ranges[:i]...)
+			newRanges = append(newRanges, Range{Min: id, Max: id})
+			newRanges = append(newRanges, idRing.Ranges[i:]...)
 			idRing.Ranges = newRanges
 			done = true
 			break
@@ -182,3 +182,47 @@ func TestAllocation(t *testing.T) {
 		t.Fatalf("Expected idRing.Ranges[0].Max to be MaxUint64, got %d", idRing.Ranges[0].Max)
 	}
 }
+
+// TestReclaimAndInvert tests that IDs reclaimed out of order are
+// given out again and that allocated IDs are inverted correctly
+// when available IDs start at the beginning of the ring.
+func TestReclaimAndInvert(t *testing.T) {
+	idRing := NewIDRing(8, 15, nil)
+	for i := 0; i < 8; i++ {
+		if _, err := idRing.GetID(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []uint64{14, 11, 9, 8} {
+		if err := idRing.ReclaimID(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(idRing.Ranges) != 3 {
+		t.Fatalf("Expected 3 ranges of available IDs, got %s", idRing)
+	}
+
+	allocated := idRing.Invert()
+	expected := []Range{{Min: 10, Max: 10}, {Min: 12, Max: 13}, {Min: 15, Max: 15}}
+	if len(allocated.Ranges) != len(expected) {
+		t.Fatalf("Expected allocated %v, got %s", expected, allocated)
+	}
+	for i := range expected {
+		if allocated.Ranges[i] != expected[i] {
+			t.Fatalf("Expected allocated %v, got %s", expected, allocated)
+		}
+	}
+
+	for _, expected := range []uint64{8, 9, 11, 14} {
+		id, err := idRing.GetID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != expected {
+			t.Fatalf("Expected %d, got %d", expected, id)
+		}
+	}
+	if _, err := idRing.GetID(); err != IDRingOverflowError {
+		t.Fatalf("Expected overflow, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"net"
+
+	"github.com/romana/core/common"
+)
+
+// CheckInvariants returns error describing the first violation of
+// invariants of allocations found in IPAM, nil if there is none:
+//   - every address has an IP no other address has,
+//   - blocks are within CIDR of their group and don't overlap,
+//   - every IP allocated in a block is an IP of an address and
+//     isn't blacked out, so numbers of both are the same,
+//   - blocks with allocated IPs have an owner and a host, owners
+//     of blocks agree with blocks of owners, and blocks kept
+//     for reuse are empty,
+//   - numbers of addresses of tenants are the ones counted.
+//
+// It is meant for randomized tests of IPAM, see ipam_fuzz_test.go,
+// and for checking IPAM restored from a backup.
+func (ipam *IPAM) CheckInvariants() error {
+	addresses := make(map[string]string)
+	for name, ip := range ipam.AddressNameToIP {
+		if other, ok := addresses[ip.String()]; ok {
+			return common.NewError("IP %s is allocated to both %s and %s", ip, other, name)
+		}
+		addresses[ip.String()] = name
+	}
+
+	allocated := 0
+	for _, network := range ipam.Networks {
+		if network.Group == nil {
+			continue
+		}
+		n, err := network.Group.checkInvariants(network, addresses)
+		if err != nil {
+			return common.NewError("network %s: %s", network.Name, err)
+		}
+		allocated += n
+	}
+	if allocated != len(addresses) {
+		return common.NewError("%d IPs are allocated in blocks, but there are %d addresses", allocated, len(addresses))
+	}
+	counted := ipam.countTenantAddresses()
+	if len(counted) != len(ipam.TenantAddresses) {
+		return common.NewError("addresses of %d tenants are counted, but %d tenants have addresses", len(ipam.TenantAddresses), len(counted))
+	}
+	for tenant, n := range counted {
+		if ipam.TenantAddresses[tenant] != n {
+			return common.NewError("tenant %s has %d addresses, but %d are counted", tenant, n, ipam.TenantAddresses[tenant])
+		}
+	}
+	return nil
+}
+
+// checkInvariants checks invariants of blocks of the group and its
+// subgroups, see IPAM.CheckInvariants, and returns the number of IPs
+// allocated in them.
+func (hg *Group) checkInvariants(network *Network, addresses map[string]string) (int, error) {
+	// top level group has no CIDR of its own.
+	cidr := hg.CIDR
+	if cidr.IPNet == nil {
+		cidr = network.CIDR
+	}
+
+	allocated := 0
+	for _, group := range hg.Groups {
+		if !cidr.Contains(group.CIDR) {
+			return 0, common.NewError("group %s is outside of %s", group.CIDR, cidr)
+		}
+		n, err := group.checkInvariants(network, addresses)
+		if err != nil {
+			return 0, err
+		}
+		allocated += n
+	}
+
+	reusable := make(map[int]bool)
+	for _, blockID := range hg.ReusableBlocks {
+		if blockID < 0 || blockID >= len(hg.Blocks) {
+			return 0, common.NewError("reusable block %d of %s does not exist", blockID, cidr)
+		}
+		if reusable[blockID] {
+			return 0, common.NewError("block %s is reusable twice", hg.Blocks[blockID].CIDR)
+		}
+		reusable[blockID] = true
+	}
+	for owner, blockIDs := range hg.OwnerToBlocks {
+		for _, blockID := range blockIDs {
+			if hg.BlockToOwner[blockID] != owner {
+				return 0, common.NewError("block %d of %s is owned by %q, not by %q", blockID, cidr, hg.BlockToOwner[blockID], owner)
+			}
+		}
+	}
+
+	for blockID, block := range hg.Blocks {
+		if !cidr.Contains(block.CIDR) {
+			return 0, common.NewError("block %s is outside of %s", block.CIDR, cidr)
+		}
+		for _, other := range hg.Blocks[:blockID] {
+			if block.CIDR.Contains(other.CIDR) || other.CIDR.Contains(block.CIDR) {
+				return 0, common.NewError("blocks %s and %s overlap", block.CIDR, other.CIDR)
+			}
+		}
+
+		ips := block.ListAllocatedAddresses()
+		if len(ips) == 0 {
+			continue
+		}
+		if reusable[blockID] {
+			return 0, common.NewError("block %s is reusable but has %d IPs allocated", block.CIDR, len(ips))
+		}
+		if hg.BlockToOwner[blockID] == "" || hg.BlockToHost[blockID] == "" {
+			return 0, common.NewError("block %s has IPs allocated but no owner or host", block.CIDR)
+		}
+		for _, ip := range ips {
+			if _, ok := addresses[ip]; !ok {
+				return 0, common.NewError("IP %s is allocated in block %s but is not an address", ip, block.CIDR)
+			}
+			if blackedOutBy := network.blackedOutBy(net.ParseIP(ip)); blackedOutBy != nil {
+				return 0, common.NewError("IP %s is allocated but blacked out by %s", ip, blackedOutBy)
+			}
+		}
+		allocated += len(ips)
+	}
+	return allocated, nil
+}
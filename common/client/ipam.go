@@ -16,6 +16,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -23,6 +24,7 @@ import (
 	"net"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 
 	libkvStore "github.com/docker/libkv/store"
@@ -33,6 +35,7 @@ import (
 	"github.com/romana/core/common/log/trace"
 
 	"github.com/mohae/deepcopy"
+	"go.opentelemetry.io/otel/attribute"
 
 	log "github.com/romana/rlog"
 )
@@ -47,6 +50,16 @@ const (
 	msgNoAvailableIP = "No available IP."
 	DefaultAgentPort = 9604
 	DefaultBlockMask = 29
+
+	// Bounds for network MTU, minimum is what RFC 791 requires
+	// every IPv4 host to handle.
+	MinMTU = 68
+	MaxMTU = 65535
+
+	// Bounds for network route table, tables above
+	// are reserved by the kernel (default, main and local).
+	MinRouteTable = 1
+	MaxRouteTable = 252
 )
 
 var (
@@ -181,6 +194,8 @@ type Host struct {
 	AgentPort uint                   `json:"agent_port"`
 	Tags      map[string]string      `json:"tags"`
 	K8SInfo   map[string]interface{} `json:"k8s_info"`
+	Capacity  int                    `json:"capacity,omitempty"`
+	Cordoned  bool                   `json:"cordoned,omitempty"`
 	group     *Group
 }
 
@@ -214,9 +229,16 @@ type Group struct {
 	ReusableBlocks []int             `json:"reusable_blocks"`
 	Assignment     map[string]string `json:"assignment"`
 	Routing        string            `json:"routing"`
-	network        *Network
+	// Zone of the group, inherited from the parent group
+	// if not set in topology.
+	Zone    string `json:"zone,omitempty"`
+	network *Network
 
 	Dummy bool `json:"dummy"`
+
+	// NextHops the group is routed via, inherited from the
+	// parent group if not set in topology.
+	NextHops []net.IP `json:"next_hops,omitempty"`
 }
 
 func (hg *Group) String() string {
@@ -380,7 +402,7 @@ func (hg *Group) allocateSpecificIP(ip net.IP, network *Network, hostName string
 		block := hg.Blocks[blockID]
 		if block.CIDR.ContainsIP(ip) {
 			err = block.allocateSpecificIP(ip, network)
-			if err != nil {
+			if err == nil {
 				hg.ReusableBlocks = deleteElementInt(hg.ReusableBlocks, blockIdx)
 				hg.OwnerToBlocks[owner] = append(hg.OwnerToBlocks[owner], blockID)
 				hg.BlockToOwner[blockID] = owner
@@ -593,6 +615,50 @@ func (hg *Group) deallocateIP(ip net.IP) error {
 	return common.NewError("Cannot find IP %s", ip)
 }
 
+// reclaimBlock clears the block of the group given by its index and
+// keeps it for reuse, addresses in it are deleted from addresses.
+// Returns the number of addresses deleted.
+func (hg *Group) reclaimBlock(blockID int, addresses map[string]net.IP) int {
+	block := hg.Blocks[blockID]
+	deleted := 0
+	for name, ip := range addresses {
+		if block.CIDR.ContainsIP(ip) {
+			delete(addresses, name)
+			deleted++
+		}
+	}
+	block.clear()
+
+	owner := hg.BlockToOwner[blockID]
+	for i, id := range hg.OwnerToBlocks[owner] {
+		if id == blockID {
+			hg.OwnerToBlocks[owner] = deleteElementInt(hg.OwnerToBlocks[owner], i)
+			break
+		}
+	}
+	delete(hg.BlockToOwner, blockID)
+	delete(hg.BlockToHost, blockID)
+	hg.ReusableBlocks = append(hg.ReusableBlocks, blockID)
+	return deleted
+}
+
+// reclaimOwnerBlocks reclaims all blocks of the owner in the group
+// and its subgroups, see reclaimBlock. It returns number of blocks
+// reclaimed and of addresses deleted with them.
+func (hg *Group) reclaimOwnerBlocks(owner string, addresses map[string]net.IP) (int, int) {
+	blocks, deleted := 0, 0
+	for _, group := range hg.Groups {
+		b, d := group.reclaimOwnerBlocks(owner, addresses)
+		blocks += b
+		deleted += d
+	}
+	for _, blockID := range append([]int(nil), hg.OwnerToBlocks[owner]...) {
+		deleted += hg.reclaimBlock(blockID, addresses)
+		blocks++
+	}
+	return blocks, deleted
+}
+
 // See ipam.injectParents.
 func (hg *Group) injectParents(network *Network) {
 	hg.network = network
@@ -648,6 +714,17 @@ func (hg *Group) GetBlocks() []api.IPAMBlockResponse {
 				Tenant:           tenant,
 				Segment:          segment,
 				AllocatedIPCount: count,
+				Group:            hg.Name,
+				GroupCIDR:        hg.CIDR.String(),
+				NextHops:         hg.NextHops,
+			}
+			if br.Group == "" {
+				br.Group = br.GroupCIDR
+			}
+			if hg.network != nil {
+				br.Network = hg.network.Name
+				br.Interface = hg.network.Interface
+				br.RouteTable = hg.network.RouteTable
 			}
 			retval = append(retval, br)
 		}
@@ -773,6 +850,8 @@ func (hg *Group) parseMap(groupOrHosts []api.GroupOrHost, cidr CIDR, network *Ne
 		hg.Assignment = groupOrHosts[0].Assignment
 		log.Tracef(trace.Inside, "Assignment for group %s: %s", hg.Name, hg.Assignment)
 		hg.Routing = groupOrHosts[0].Routing
+		hg.Zone = groupOrHosts[0].Zone
+		hg.NextHops = groupOrHosts[0].NextHops
 		hg.Dummy = groupOrHosts[0].Dummy
 		err = hg.parse(groupOrHosts[0].Groups, cidr, network)
 		if err != nil {
@@ -796,6 +875,8 @@ func (hg *Group) parseMap(groupOrHosts []api.GroupOrHost, cidr CIDR, network *Ne
 		hg.Groups[i].Name = elt.Name
 		hg.Groups[i].Assignment = elt.Assignment
 		hg.Groups[i].Routing = elt.Routing
+		hg.Groups[i].Zone = elt.Zone
+		hg.Groups[i].NextHops = elt.NextHops
 		log.Tracef(trace.Inside, "Assignment for group %s: %s", hg.Groups[i].Name, hg.Groups[i].Assignment)
 
 		hg.Groups[i].Dummy = elt.Dummy
@@ -865,7 +946,11 @@ func (hg *Group) parse(arr []api.GroupOrHost, cidr CIDR, network *Network) error
 				return common.NewError("Both name and IP are required for hosts: %+v (%T)", elt, elt)
 			}
 			// This is host, we inherit the CIDR
-			host := &Host{Name: elt.Name, IP: elt.IP}
+			host := &Host{Name: elt.Name, IP: elt.IP, Tags: elt.Tags, Capacity: elt.Capacity, Cordoned: elt.Cordoned}
+			if host.Tags == nil {
+				// topology used to return tags of hosts as assignment.
+				host.Tags = elt.Assignment
+			}
 			host.group = hg
 			hg.Hosts[i] = host
 		} else {
@@ -877,6 +962,14 @@ func (hg *Group) parse(arr []api.GroupOrHost, cidr CIDR, network *Network) error
 			hg.Groups[i] = &Group{}
 			hg.Groups[i].Assignment = elt.Assignment
 			hg.Groups[i].Routing = elt.Routing
+			hg.Groups[i].Zone = elt.Zone
+			if hg.Groups[i].Zone == "" {
+				hg.Groups[i].Zone = hg.Zone
+			}
+			hg.Groups[i].NextHops = elt.NextHops
+			if len(hg.Groups[i].NextHops) == 0 {
+				hg.Groups[i].NextHops = hg.NextHops
+			}
 			err = hg.Groups[i].parse(elt.Groups, elementCIDR, network)
 			if err != nil {
 				return err
@@ -1032,6 +1125,16 @@ type Network struct {
 
 	BlackedOut []CIDR `json:"blacked_out"`
 
+	// Selector of the host interface carrying traffic of this network,
+	// see api.NetworkDefinition.
+	Interface string `json:"interface,omitempty"`
+
+	// MTU for endpoint interfaces, 0 means not set.
+	MTU int `json:"mtu,omitempty"`
+
+	// Dedicated kernel routing table, 0 means not set.
+	RouteTable int `json:"route_table,omitempty"`
+
 	Group *Group `json:"host_groups"`
 
 	Revison int `json:"revision"`
@@ -1059,6 +1162,17 @@ func (network *Network) deallocateIP(ip net.IP) error {
 	return err
 }
 
+// GetNetworkForIP returns network which CIDR contains provided IP,
+// or nil if none does.
+func (ipam *IPAM) GetNetworkForIP(ip net.IP) *Network {
+	for _, network := range ipam.Networks {
+		if network.CIDR.IPNet != nil && network.CIDR.IPNet.Contains(ip) {
+			return network
+		}
+	}
+	return nil
+}
+
 func (network *Network) findIPInfo(ip net.IP) (hostName string, owner string) {
 
 	log.Tracef(trace.Inside, "network.findIPInfo(): Looking for %s in %s (%s)", ip, network.Name, network.CIDR)
@@ -1069,6 +1183,22 @@ func (network *Network) findIPInfo(ip net.IP) (hostName string, owner string) {
 	return network.Group.findIPInfo(ip)
 }
 
+// findHost returns the host of the network given by its IP,
+// if set, or name, or nil if there is no such host.
+func (network *Network) findHost(host api.Host) *Host {
+	if network.Group == nil {
+		return nil
+	}
+	if host.IP == nil {
+		return network.Group.findHostByName(host.Name)
+	}
+	found := network.Group.findHostByIP(host.IP.String())
+	if found != nil && host.Name != "" && found.Name != host.Name {
+		return nil
+	}
+	return found
+}
+
 func (network *Network) allocateSpecificIP(ip net.IP, hostName string, owner string) error {
 	if network.Group == nil {
 		return errors.NewRomanaNotFoundError("No groups found in network",
@@ -1107,6 +1237,15 @@ func (network *Network) allocateIP(hostName string, owner string) (net.IP, error
 	return ip, nil
 }
 
+// blackedOutNets returns blacked out CIDRs of the network.
+func (network *Network) blackedOutNets() []api.IPNet {
+	var result []api.IPNet
+	for _, cidr := range network.BlackedOut {
+		result = append(result, api.IPNet{IPNet: *cidr.IPNet})
+	}
+	return result
+}
+
 // blackedOutBy returns the CIDR that blacks out this IP,
 // nil if IP is not blocked.
 func (network *Network) blackedOutBy(ip net.IP) *CIDR {
@@ -1156,18 +1295,38 @@ func NewIPAM(saver Saver, locker Locker) (*IPAM, error) {
 // parseIPAM restores IPAM from JSON
 func parseIPAM(j string) (*IPAM, error) {
 	ipam := &IPAM{}
-	err := json.Unmarshal([]byte(j), ipam)
+	err := DecodeObject(KindIPAM, []byte(j), ipam)
 	if err != nil {
 		return nil, err
 	}
 	ipam.injectParents()
 	ipam.locker = newMutexLocker()
+	if ipam.TenantAddresses == nil && len(ipam.AddressNameToIP) > 0 {
+		// IPAM saved before addresses were counted.
+		ipam.TenantAddresses = ipam.countTenantAddresses()
+	}
 	return ipam, nil
 }
 
 type IPAM struct {
 	Networks map[string]*Network `json:"networks"`
 
+	// Tenants are definitions of tenants by name.
+	Tenants map[string]*api.TenantDefinition `json:"tenants,omitempty"`
+
+	// Segments are definitions of segments by owner, see makeOwner.
+	Segments map[string]*api.SegmentDefinition `json:"segments,omitempty"`
+
+	// FederationPools are pools the IPAM delegates super-blocks of
+	// to federated clusters by name, Delegations are the super-blocks
+	// delegated, see federation.go.
+	FederationPools map[string]*api.FederationPool `json:"federation_pools,omitempty"`
+	Delegations     []*api.Delegation              `json:"delegations,omitempty"`
+
+	// NeutronSubnets are subnets of OpenStack Neutron by ID,
+	// allocated on networks of the topology, see neutron.go.
+	NeutronSubnets map[string]*api.NeutronSubnet `json:"neutron_subnets,omitempty"`
+
 	// Revision of the state of allocations
 	AllocationRevision int
 	// Revision of topology information (only changes if hosts are added)
@@ -1175,10 +1334,19 @@ type IPAM struct {
 
 	// Map of address name to IP
 	AddressNameToIP map[string]net.IP `json:"address_name_to_ip"`
+
+	// TenantAddresses is number of addresses allocated by tenant,
+	// it is kept by addAddress and removeAddress, so that quotas
+	// are checked without counting addresses.
+	TenantAddresses map[string]int `json:"tenant_addresses,omitempty"`
 	load            Loader
 	save            Saver
 	locker          Locker
 
+	// events records changes made through the IPAM, nil
+	// unless the client records events, see RecordEvents.
+	events *EventRecorder
+
 	TenantToNetwork map[string][]string `json:"tenant_to_network"`
 
 	//	OwnerToIP map[string][]string
@@ -1209,9 +1377,35 @@ func (ipam *IPAM) injectParents() {
 func (ipam *IPAM) clearIPAM() {
 	ipam.Networks = make(map[string]*Network)
 	ipam.AddressNameToIP = make(map[string]net.IP)
+	ipam.TenantAddresses = make(map[string]int)
 	ipam.TenantToNetwork = make(map[string][]string)
 }
 
+// addAddress records the address allocated to the owner.
+func (ipam *IPAM) addAddress(name string, ip net.IP, owner string) {
+	ipam.AddressNameToIP[name] = ip
+	ipam.countAddresses(owner, 1)
+}
+
+// removeAddress forgets the address allocated to the owner.
+func (ipam *IPAM) removeAddress(name string, owner string) {
+	delete(ipam.AddressNameToIP, name)
+	ipam.countAddresses(owner, -1)
+}
+
+// countAddresses adds n to the number of addresses
+// of the tenant of the owner.
+func (ipam *IPAM) countAddresses(owner string, n int) {
+	tenant, _ := parseOwner(owner)
+	if ipam.TenantAddresses == nil {
+		ipam.TenantAddresses = make(map[string]int)
+	}
+	ipam.TenantAddresses[tenant] += n
+	if ipam.TenantAddresses[tenant] <= 0 {
+		delete(ipam.TenantAddresses, tenant)
+	}
+}
+
 func (ipam *IPAM) ListHosts() api.HostList {
 	list := make([]api.Host, 0)
 	for _, network := range ipam.Networks {
@@ -1223,6 +1417,9 @@ func (ipam *IPAM) ListHosts() api.HostList {
 				IP:        host.IP,
 				Name:      host.Name,
 				AgentPort: host.AgentPort,
+				Tags:      host.Tags,
+				Capacity:  host.Capacity,
+				Cordoned:  host.Cordoned,
 			})
 		}
 	}
@@ -1261,7 +1458,7 @@ func (ipam *IPAM) allocateSpecificIP(addressName string, ip net.IP, host string,
 			if err != nil {
 				return err
 			}
-			ipam.AddressNameToIP[addressName] = ip
+			ipam.addAddress(addressName, ip, owner)
 			return nil
 		}
 	}
@@ -1276,8 +1473,50 @@ func (ipam *IPAM) allocateSpecificIP(addressName string, ip net.IP, host string,
 // this tenant/segment pair. Will return nil as IP if the entire
 // network is exhausted.
 func (ipam *IPAM) AllocateIP(addressName string, host string, tenant string, segment string) (net.IP, error) {
+	return ipam.AllocateIPInZone(addressName, host, tenant, segment, "", false)
+}
+
+// AllocateIPInZone allocates an IP like AllocateIP, preferring networks
+// in which the host is in a group of the zone, so that the address
+// is routed within the zone. If requireZone is true, other networks
+// are not tried.
+func (ipam *IPAM) AllocateIPInZone(addressName string, host string, tenant string, segment string, zone string, requireZone bool) (net.IP, error) {
+	return ipam.AllocateIPInZoneContext(context.Background(), addressName, host, tenant, segment, zone, requireZone)
+}
+
+// AllocateIPInZoneContext allocates an IP like AllocateIPInZone,
+// recording spans of the allocation and of its store operations
+// in the trace of ctx.
+func (ipam *IPAM) AllocateIPInZoneContext(ctx context.Context, addressName string, host string, tenant string, segment string, zone string, requireZone bool) (net.IP, error) {
+	ctx, span := common.StartSpan(ctx, "ipam.allocate",
+		attribute.String("romana.address", addressName),
+		attribute.String("romana.host", host),
+		attribute.String("romana.tenant", tenant),
+		attribute.String("romana.segment", segment),
+		attribute.String("romana.zone", zone))
+	ip, err := ipam.allocateIPInZone(ctx, addressName, host, tenant, segment, zone, requireZone)
+	if ip != nil {
+		span.SetAttributes(attribute.String("romana.ip", ip.String()))
+	}
+	common.EndSpan(span, err)
+
+	object := api.EventObject{Kind: api.EventKindAddress, Name: addressName}
+	switch {
+	case err != nil:
+		ipam.events.Eventf(api.EventWarning, "AllocationFailed", object,
+			"Failed to allocate address on host %s for %s: %s", host, makeOwner(tenant, segment), err)
+	case ip != nil:
+		ipam.events.Eventf(api.EventNormal, "AddressAllocated", object,
+			"Allocated %s on host %s for %s", ip, host, makeOwner(tenant, segment))
+	}
+	return ip, err
+}
+
+func (ipam *IPAM) allocateIPInZone(ctx context.Context, addressName string, host string, tenant string, segment string, zone string, requireZone bool) (net.IP, error) {
 	log.Tracef(trace.Inside, "Entering IPAM.AllocateIP()")
+	_, span := common.StartSpan(ctx, "ipam.lock")
 	ch, err := ipam.locker.Lock()
+	common.EndSpan(span, err)
 	if err != nil {
 		log.Error("IPAM.AllocateIP: error acquiring a lock")
 		return nil, err
@@ -1286,7 +1525,9 @@ func (ipam *IPAM) AllocateIP(addressName string, host string, tenant string, seg
 	defer ipam.locker.Unlock()
 
 	latestIPAM := &IPAM{}
+	_, span = common.StartSpan(ctx, "ipam.load")
 	err = ipam.load(latestIPAM, ch)
+	common.EndSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
@@ -1303,11 +1544,28 @@ func (ipam *IPAM) AllocateIP(addressName string, host string, tenant string, seg
 
 	}
 
+	if err := latestIPAM.checkHostCordoned(host); err != nil {
+		return nil, err
+	}
+	if err := latestIPAM.checkHostCapacity(host); err != nil {
+		return nil, err
+	}
+	if err := latestIPAM.checkTenantQuota(tenant); err != nil {
+		return nil, err
+	}
+
 	// Find eligible networks for the specified tenant
 	networksForTenant, err := latestIPAM.getNetworksForTenant(tenant)
 	if err != nil {
 		return nil, err
 	}
+	if zone != "" {
+		networksForTenant = networksInZone(networksForTenant, host, zone, requireZone)
+		if len(networksForTenant) == 0 {
+			return nil, common.NewUnprocessableEntityError(fmt.Sprintf(
+				"Host %s is not in zone %s in any network of tenant %s", host, zone, tenant))
+		}
+	}
 
 	owner := makeOwner(tenant, segment)
 	for _, network := range networksForTenant {
@@ -1331,10 +1589,12 @@ func (ipam *IPAM) AllocateIP(addressName string, host string, tenant string, seg
 		}
 
 		if ip != nil {
-			latestIPAM.AddressNameToIP[addressName] = ip
+			latestIPAM.addAddress(addressName, ip, owner)
 			latestIPAM.AllocationRevision++
 			log.Tracef(trace.Inside, "Updated AllocationRevision to %d", latestIPAM.AllocationRevision)
+			_, span = common.StartSpan(ctx, "ipam.save")
 			err = ipam.save(latestIPAM, ch)
+			common.EndSpan(span, err)
 			if err != nil {
 				return nil, err
 			}
@@ -1344,6 +1604,25 @@ func (ipam *IPAM) AllocateIP(addressName string, host string, tenant string, seg
 	return nil, common.NewError(msgNoAvailableIP)
 }
 
+// networksInZone returns networks in which host is in a group
+// of the zone first, followed by the rest unless onlyZone is true.
+func networksInZone(networks []*Network, host string, zone string, onlyZone bool) []*Network {
+	var inZone, rest []*Network
+	for _, network := range networks {
+		if network.Group != nil {
+			if h := network.Group.findHostByName(host); h != nil && h.group != nil && h.group.Zone == zone {
+				inZone = append(inZone, network)
+				continue
+			}
+		}
+		rest = append(rest, network)
+	}
+	if onlyZone {
+		return inZone
+	}
+	return append(inZone, rest...)
+}
+
 // DeallocateIP will deallocate the provided IP (returning an
 // error if it never was allocated in the first place).
 func (ipam *IPAM) DeallocateIP(addressName string) error {
@@ -1365,14 +1644,17 @@ func (ipam *IPAM) DeallocateIP(addressName string) error {
 		for _, network := range latestIPAM.Networks {
 			if network.CIDR.IPNet.Contains(ip) {
 				log.Tracef(trace.Inside, "IPAM.DeallocateIP: IP %s belongs to network %s", ip, network.Name)
+				_, owner := network.findIPInfo(ip)
 				err := network.deallocateIP(ip)
 				if err == nil {
-					delete(latestIPAM.AddressNameToIP, addressName)
+					latestIPAM.removeAddress(addressName, owner)
 					latestIPAM.AllocationRevision++
 					err = ipam.save(latestIPAM, ch)
 					if err != nil {
 						return err
 					}
+					ipam.events.Eventf(api.EventNormal, "AddressDeallocated",
+						api.EventObject{Kind: api.EventKindAddress, Name: addressName}, "Deallocated %s", ip)
 				}
 				return err
 			}
@@ -1388,14 +1670,17 @@ func (ipam *IPAM) DeallocateIP(addressName string) error {
 					log.Tracef(trace.Inside,
 						"IPAM.DeallocateIP: IP %s belongs to network %s",
 						ip, network.Name)
+					_, owner := network.findIPInfo(ip)
 					err := network.deallocateIP(ip)
 					if err == nil {
-						delete(latestIPAM.AddressNameToIP, name)
+						latestIPAM.removeAddress(name, owner)
 						latestIPAM.AllocationRevision++
 						err = ipam.save(latestIPAM, ch)
 						if err != nil {
 							return err
 						}
+						ipam.events.Eventf(api.EventNormal, "AddressDeallocated",
+							api.EventObject{Kind: api.EventKindAddress, Name: name}, "Deallocated %s", ip)
 					}
 					return err
 				}
@@ -1436,6 +1721,28 @@ func (ipam *IPAM) getNetworksForTenant(tenant string) ([]*Network, error) {
 	return networks, nil
 }
 
+// NetworksForTenant returns names of networks in which addresses
+// of the tenant can be allocated on the host, in the order
+// AllocateIP tries them.
+func (ipam *IPAM) NetworksForTenant(tenant string, host string) ([]string, error) {
+	networks, err := ipam.getNetworksForTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, network := range networks {
+		if network.Group != nil && network.Group.findHostByName(host) != nil {
+			names = append(names, network.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.NewRomanaNotFoundError(
+			fmt.Sprintf("No network of tenant %s has host %s", tenant, host),
+			"host", fmt.Sprintf("name=%s", host))
+	}
+	return names, nil
+}
+
 // setTopology clears IPAM and sets existing topology in it.
 func (ipam *IPAM) setTopology(req api.TopologyUpdateRequest) error {
 	ipam.clearIPAM()
@@ -1473,7 +1780,7 @@ func (ipam *IPAM) setTopology(req api.TopologyUpdateRequest) error {
 			}
 		} else {
 			for _, tenantName := range netDef.Tenants {
-				if !tenantNameRegexp.MatchString(tenantName) {
+				if tenantName != "*" && !tenantNameRegexp.MatchString(tenantName) {
 					return common.NewError("Bad tenant name: %s", tenantName)
 				}
 				if _, ok := ipam.TenantToNetwork[tenantName]; !ok {
@@ -1483,6 +1790,17 @@ func (ipam *IPAM) setTopology(req api.TopologyUpdateRequest) error {
 			}
 		}
 		network := newNetwork(netDef.Name, netDefCIDR, netDef.BlockMask)
+		network.Interface = strings.TrimSpace(netDef.Interface)
+		if netDef.MTU != 0 && (netDef.MTU < MinMTU || netDef.MTU > MaxMTU) {
+			return common.NewError("invalid mtu(%d) for network(%s), must be %d <= mtu <= %d",
+				netDef.MTU, netDef.Name, MinMTU, MaxMTU)
+		}
+		network.MTU = netDef.MTU
+		if netDef.RouteTable != 0 && (netDef.RouteTable < MinRouteTable || netDef.RouteTable > MaxRouteTable) {
+			return common.NewError("invalid route_table(%d) for network(%s), must be %d <= route_table <= %d",
+				netDef.RouteTable, netDef.Name, MinRouteTable, MaxRouteTable)
+		}
+		network.RouteTable = netDef.RouteTable
 		network.ipam = ipam
 		log.Infof("Adding network %s: %v", netDef.Name, network)
 		ipam.Networks[netDef.Name] = network
@@ -1499,7 +1817,7 @@ func (ipam *IPAM) setTopology(req api.TopologyUpdateRequest) error {
 			if net1 == net2 {
 				continue
 			}
-			log.Printf("Checking %s %v vs %s %v", net1.Name, net1, net2.Name, net2)
+			log.Tracef(trace.Inside, "Checking %s %v vs %s %v", net1.Name, net1, net2.Name, net2)
 			if net2.CIDR.Contains(net1.CIDR) {
 				return common.NewError("CIDR %s of network %s already is contained in CIDR %s of network %s", net1.CIDR, net1.Name, net2.CIDR, net2.Name)
 			}
@@ -1567,6 +1885,15 @@ func (ipam *IPAM) UpdateTopology(req api.TopologyUpdateRequest, lockAndSave bool
 		defer ipam.locker.Unlock()
 	}
 
+	if req.Revision != 0 && req.Revision != ipam.TopologyRevision {
+		return errors.NewRomanaConflictError("topology", ipamDataKey, uint64(req.Revision), uint64(ipam.TopologyRevision))
+	}
+
+	err = validateTopology(req)
+	if err != nil {
+		return err
+	}
+
 	// The algorithm is as follows:
 	// - Back up IPAM
 	// - Set current IPAM's topology to the provided
@@ -1581,6 +1908,14 @@ func (ipam *IPAM) UpdateTopology(req api.TopologyUpdateRequest, lockAndSave bool
 	if err != nil {
 		return err
 	}
+	err = ipam.checkFederationPools()
+	if err != nil {
+		return err
+	}
+	err = ipam.checkNeutronSubnets()
+	if err != nil {
+		return err
+	}
 
 	var ipFound bool
 	for addressName, ip := range backupIPAM.AddressNameToIP {
@@ -1614,26 +1949,85 @@ func (ipam *IPAM) UpdateTopology(req api.TopologyUpdateRequest, lockAndSave bool
 			return err
 		}
 	}
+	ipam.events.Eventf(api.EventNormal, "TopologyUpdated", api.EventObject{Kind: api.EventKindTopology},
+		"Updated topology to revision %d with %d networks", ipam.TopologyRevision, len(ipam.Networks))
 	return nil
 }
 
+// PlanTopology computes outcome of updating topology to req without
+// a store: IPAM with the topology of req is built in memory and the
+// addresses, as listed by ListAddresses, are allocated in it. Error is
+// returned if req is not a valid topology, addresses that can't be
+// allocated are returned as conflicts of the plan.
+func PlanTopology(req api.TopologyUpdateRequest, addresses []api.IPAMAddress) (*api.TopologyPlan, error) {
+	ipam, err := NewIPAM(func(*IPAM, <-chan struct{}) error { return nil }, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Revision = 0
+	err = ipam.UpdateTopology(req, false)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &api.TopologyPlan{}
+	for _, addr := range addresses {
+		err = ipam.allocateSpecificIP(addr.Name, addr.IP, addr.Host, addr.Tenant, addr.Segment)
+		if err != nil {
+			plan.Conflicts = append(plan.Conflicts, api.AddressConflict{Address: addr, Error: err.Error()})
+		}
+	}
+
+	plan.Topology = *getTopologyFromIPAMState(ipam).(*api.TopologyUpdateRequest)
+	plan.Topology.Revision = 0
+	plan.Hosts = hostPlacements(ipam)
+	return plan, nil
+}
+
 func (ipam *IPAM) ListAllBlocks() *api.IPAMBlocksResponse {
 	blocks := make([]api.IPAMBlockResponse, 0)
+	var blackedOut []api.IPNet
 	for _, network := range ipam.Networks {
 		netBlocks := network.Group.GetBlocks()
 		blocks = append(blocks, netBlocks...)
+		blackedOut = append(blackedOut, network.blackedOutNets()...)
 	}
 	return &api.IPAMBlocksResponse{
-		Revision: ipam.AllocationRevision,
-		Blocks:   blocks,
+		Revision:   ipam.AllocationRevision,
+		Blocks:     blocks,
+		BlackedOut: blackedOut,
+	}
+}
+
+// ListBlocks returns blocks of the host in the network,
+// empty host or network matches any.
+func (ipam *IPAM) ListBlocks(host string, network string) *api.IPAMBlocksResponse {
+	resp := ipam.ListAllBlocks()
+	if host == "" && network == "" {
+		return resp
+	}
+
+	blocks := make([]api.IPAMBlockResponse, 0)
+	for _, block := range resp.Blocks {
+		if host != "" && block.Host != host {
+			continue
+		}
+		if network != "" && block.Network != network {
+			continue
+		}
+		blocks = append(blocks, block)
 	}
+	resp.Blocks = blocks
+	return resp
 }
 
 func (ipam *IPAM) ListNetworkBlocks(netName string) *api.IPAMBlocksResponse {
 	if network, ok := ipam.Networks[netName]; ok {
 		resp := &api.IPAMBlocksResponse{
-			Revision: network.Revison,
-			Blocks:   network.Group.GetBlocks(),
+			Revision:   network.Revison,
+			Blocks:     network.Group.GetBlocks(),
+			BlackedOut: network.blackedOutNets(),
 		}
 		return resp
 	}
@@ -1759,6 +2153,88 @@ func (ipam *IPAM) UpdateHostK8SInfo(host api.Host) error {
 	return nil
 }
 
+// CordonHost sets whether the host, given by its name or IP, is
+// cordoned: addresses allocated on a cordoned host are kept, but
+// no new ones are allocated on it.
+func (ipam *IPAM) CordonHost(host api.Host, cordoned bool) error {
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	if host.IP == nil && host.Name == "" {
+		return common.NewError("At least one of IP, Name must be specified to cordon a host")
+	}
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	updatedHost := false
+	var hostName string
+	for _, net := range latestIPAM.Networks {
+		hostToUpdate := net.findHost(host)
+		if hostToUpdate == nil {
+			continue
+		}
+		hostName = hostToUpdate.Name
+		if hostToUpdate.Cordoned != cordoned {
+			hostToUpdate.Cordoned = cordoned
+			updatedHost = true
+		}
+	}
+	if hostName == "" {
+		return errors.NewRomanaNotFoundError(
+			fmt.Sprintf("No host found with IP %s and/or name %s", host.IP, host.Name),
+			"host", fmt.Sprintf("name=%s", host.Name), fmt.Sprintf("IP=%s", host.IP))
+	}
+	if !updatedHost {
+		return nil
+	}
+	latestIPAM.TopologyRevision++
+	log.Infof("Host %s cordoned: %t", hostName, cordoned)
+	if err := ipam.save(latestIPAM, ch); err != nil {
+		return err
+	}
+	object := api.EventObject{Kind: api.EventKindHost, Name: hostName}
+	if cordoned {
+		ipam.events.Eventf(api.EventNormal, "HostCordoned", object, "Stopped allocating addresses on host")
+	} else {
+		ipam.events.Eventf(api.EventNormal, "HostUncordoned", object, "Resumed allocating addresses on host")
+	}
+	return nil
+}
+
+// ListHostAddresses returns addresses still allocated on the host,
+// given by its name or IP, e.g. to drain it before it is removed.
+func (ipam *IPAM) ListHostAddresses(host api.Host) ([]api.IPAMAddress, error) {
+	var hostName string
+	for _, net := range ipam.Networks {
+		if found := net.findHost(host); found != nil {
+			hostName = found.Name
+			break
+		}
+	}
+	if hostName == "" {
+		return nil, errors.NewRomanaNotFoundError(
+			fmt.Sprintf("No host found with IP %s and/or name %s", host.IP, host.Name),
+			"host", fmt.Sprintf("name=%s", host.Name), fmt.Sprintf("IP=%s", host.IP))
+	}
+	addresses := make([]api.IPAMAddress, 0)
+	for _, addr := range ipam.ListAddresses() {
+		if addr.Host == hostName {
+			addresses = append(addresses, addr)
+		}
+	}
+	return addresses, nil
+}
+
+// RemoveHost removes host given by its name or IP from the topology,
+// addresses allocated on the host are deallocated and its blocks are
+// reclaimed for reuse.
 func (ipam *IPAM) RemoveHost(host api.Host) error {
 	ch, err := ipam.locker.Lock()
 	if err != nil {
@@ -1770,6 +2246,8 @@ func (ipam *IPAM) RemoveHost(host api.Host) error {
 		return common.NewError("At least one of IP, Name must be specified to delete a host")
 	}
 	removedHost := false
+	removedAddresses := 0
+	var removedName string
 	var hostToRemove *Host
 	for _, net := range ipam.Networks {
 		log.Tracef(trace.Inside, "Looking for host %v (%s) to remove from net %s", host.IP, host.Name, net.Name)
@@ -1797,23 +2275,30 @@ func (ipam *IPAM) RemoveHost(host api.Host) error {
 				hostToRemove.group.Hosts = deleteElementHost(hostToRemove.group.Hosts, i)
 				log.Tracef(trace.Inside, "Net %s, group %s, after removal: %v", net.Name, hostToRemove.group.Name, hostToRemove.group.Hosts)
 				removedHost = true
+				removedName = curHost.Name
 				break
 			}
 		}
 		for k, v := range hostToRemove.group.BlockToHost {
 			if v == curHost.Name {
-				delete(hostToRemove.group.BlockToHost, k)
-				hostToRemove.group.Blocks[k].clear()
-				hostToRemove.group.ReusableBlocks = append(hostToRemove.group.ReusableBlocks, k)
+				owner := hostToRemove.group.BlockToOwner[k]
+				reclaimed := hostToRemove.group.reclaimBlock(k, ipam.AddressNameToIP)
+				ipam.countAddresses(owner, -reclaimed)
+				removedAddresses += reclaimed
 			}
 		}
 	}
 	if removedHost {
+		if removedAddresses > 0 {
+			ipam.AllocationRevision++
+		}
 		ipam.TopologyRevision++
 		err = ipam.save(ipam, ch)
 		if err != nil {
 			return err
 		}
+		ipam.events.Eventf(api.EventNormal, "HostRemoved", api.EventObject{Kind: api.EventKindHost, Name: removedName},
+			"Removed host")
 	} else {
 		return common.NewError("No host found with IP %s and/or name %s", host.IP, host.Name)
 	}
@@ -1842,8 +2327,11 @@ func (ipam *IPAM) AddHost(host api.Host) error {
 	}
 	for _, net := range ipam.Networks {
 		myHost := &Host{IP: host.IP,
-			Name: host.Name,
-			Tags: myTags,
+			Name:      host.Name,
+			Tags:      myTags,
+			AgentPort: host.AgentPort,
+			Capacity:  host.Capacity,
+			Cordoned:  host.Cordoned,
 		}
 		log.Tracef(trace.Inside, "Attempting to add host %s (%s) to network %s\n", host.Name, host.IP, net.Name)
 		if net.Group == nil {
@@ -1863,6 +2351,8 @@ func (ipam *IPAM) AddHost(host api.Host) error {
 		if err != nil {
 			return err
 		}
+		ipam.events.Eventf(api.EventNormal, "HostAdded", api.EventObject{Kind: api.EventKindHost, Name: host.Name},
+			"Added host with IP %s", host.IP)
 	} else {
 		return common.NewError("No suitable groups to add host %s to.", host)
 	}
@@ -1922,6 +2412,7 @@ func (ipam *IPAM) BlackOut(cidrStr string) error {
 			}
 			network.BlackedOut[i] = cidr
 			network.Revison++
+			ipam.AllocationRevision++
 			err := ipam.save(ipam, ch)
 			if err != nil {
 				return err
@@ -1944,6 +2435,8 @@ func (ipam *IPAM) BlackOut(cidrStr string) error {
 
 	network.BlackedOut = append(network.BlackedOut, cidr)
 	network.Revison++
+	// Let block watchers (agents) know about the change.
+	ipam.AllocationRevision++
 	err = ipam.save(ipam, ch)
 	if err != nil {
 		return err
@@ -1994,5 +2487,492 @@ func (ipam *IPAM) UnBlackOut(cidrStr string) error {
 	}
 	network.BlackedOut = deleteElementCIDR(network.BlackedOut, i)
 	network.Revison++
+	ipam.AllocationRevision++
 	return ipam.save(ipam, ch)
 }
+
+// ListNetworks returns networks with their utilization.
+func (ipam *IPAM) ListNetworks() []api.IPAMNetworkResponse {
+	allocated := make(map[string]int)
+	for _, ip := range ipam.AddressNameToIP {
+		if network := ipam.GetNetworkForIP(ip); network != nil {
+			allocated[network.Name]++
+		}
+	}
+
+	resp := make([]api.IPAMNetworkResponse, 0)
+	for _, network := range ipam.Networks {
+		n := api.IPAMNetworkResponse{
+			CIDR:      api.IPNet{IPNet: *network.CIDR.IPNet},
+			Name:      network.Name,
+			Revision:  network.Revison,
+			Size:      network.CIDR.EndIPInt - network.CIDR.StartIPInt + 1,
+			Allocated: allocated[network.Name],
+		}
+		for _, cidr := range network.BlackedOut {
+			n.BlackedOut += cidr.EndIPInt - cidr.StartIPInt + 1
+		}
+		if network.Group != nil {
+			n.Blocks = len(network.Group.GetBlocks())
+		}
+		resp = append(resp, n)
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
+	return resp
+}
+
+// ListAddresses returns allocated addresses sorted by name.
+func (ipam *IPAM) ListAddresses() []api.IPAMAddress {
+	addresses := make([]api.IPAMAddress, 0, len(ipam.AddressNameToIP))
+	for name, ip := range ipam.AddressNameToIP {
+		addr := api.IPAMAddress{Name: name, IP: ip}
+		if network := ipam.GetNetworkForIP(ip); network != nil {
+			addr.Network = network.Name
+			host, owner := network.findIPInfo(ip)
+			addr.Host = host
+			addr.Tenant, addr.Segment = parseOwner(owner)
+		}
+		addresses = append(addresses, addr)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].Name < addresses[j].Name })
+	return addresses
+}
+
+// ImportAddresses allocates addresses, e.g. exported by ListAddresses
+// from another cluster, at their IPs. Addresses that are allocated
+// at the same IP already are skipped, so import can be repeated.
+// Nothing is allocated if any of addresses can't be.
+func (ipam *IPAM) ImportAddresses(addresses []api.IPAMAddress) (api.IPAMImportResponse, error) {
+	var resp api.IPAMImportResponse
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return resp, err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return resp, err
+	}
+
+	for _, addr := range addresses {
+		if ip, ok := latestIPAM.AddressNameToIP[addr.Name]; ok {
+			if ip.Equal(addr.IP) {
+				resp.Skipped++
+				continue
+			}
+			return api.IPAMImportResponse{}, errors.NewRomanaExistsErrorWithMessage(
+				fmt.Sprintf("Address with name %s already allocated: %s", addr.Name, ip),
+				fmt.Sprintf("Address: %s", addr.Name),
+				"IP",
+				fmt.Sprintf("name=%s", addr.Name),
+				fmt.Sprintf("IP=%s", ip))
+		}
+		// imported addresses count against limits of hosts
+		// and tenants like allocated ones do.
+		if err := latestIPAM.checkHostCordoned(addr.Host); err != nil {
+			return api.IPAMImportResponse{}, err
+		}
+		if err := latestIPAM.checkHostCapacity(addr.Host); err != nil {
+			return api.IPAMImportResponse{}, err
+		}
+		if err := latestIPAM.checkTenantQuota(addr.Tenant); err != nil {
+			return api.IPAMImportResponse{}, err
+		}
+		err = latestIPAM.allocateSpecificIP(addr.Name, addr.IP, addr.Host, addr.Tenant, addr.Segment)
+		if err != nil {
+			return api.IPAMImportResponse{}, err
+		}
+		resp.Imported++
+		resp.Addresses = append(resp.Addresses, addr)
+	}
+	if resp.Imported == 0 {
+		return resp, nil
+	}
+
+	latestIPAM.AllocationRevision++
+	log.Infof("Imported %d addresses, skipped %d", resp.Imported, resp.Skipped)
+	if err := ipam.save(latestIPAM, ch); err != nil {
+		return api.IPAMImportResponse{}, err
+	}
+	return resp, nil
+}
+
+// checkHostCordoned returns an error if the host is cordoned
+// in any of the networks.
+func (ipam *IPAM) checkHostCordoned(hostName string) error {
+	for _, network := range ipam.Networks {
+		if network.Group == nil {
+			continue
+		}
+		if host := network.Group.findHostByName(hostName); host != nil && host.Cordoned {
+			return common.NewErrorConflict(fmt.Sprintf("Host %s is cordoned, no addresses are allocated on it", hostName))
+		}
+	}
+	return nil
+}
+
+// checkHostCapacity returns an error if the host has as many
+// addresses allocated as its capacity allows.
+func (ipam *IPAM) checkHostCapacity(hostName string) error {
+	capacity := 0
+	for _, network := range ipam.Networks {
+		if network.Group == nil {
+			continue
+		}
+		if host := network.Group.findHostByName(hostName); host != nil && host.Capacity > 0 {
+			capacity = host.Capacity
+			break
+		}
+	}
+	if capacity == 0 {
+		return nil
+	}
+
+	allocated := 0
+	for _, ip := range ipam.AddressNameToIP {
+		if network := ipam.GetNetworkForIP(ip); network != nil {
+			if name, _ := network.findIPInfo(ip); name == hostName {
+				allocated++
+			}
+		}
+	}
+	if allocated >= capacity {
+		return errors.NewRomanaQuotaExceededError(
+			fmt.Sprintf("Host %s has %d addresses allocated, which is its capacity", hostName, allocated),
+			"host", hostName)
+	}
+	return nil
+}
+
+// checkTenantQuota returns an error if the tenant has as many
+// addresses allocated as its definition allows.
+func (ipam *IPAM) checkTenantQuota(tenant string) error {
+	def, ok := ipam.Tenants[tenant]
+	if !ok || def.MaxAddresses == 0 {
+		return nil
+	}
+
+	allocated := ipam.TenantAddresses[tenant]
+	if allocated >= def.MaxAddresses {
+		return errors.NewRomanaQuotaExceededError(
+			fmt.Sprintf("Tenant %s has %d addresses allocated, which is its quota", tenant, allocated),
+			"tenant", tenant)
+	}
+	return nil
+}
+
+// countTenantAddresses counts addresses allocated by tenant,
+// see TenantAddresses.
+func (ipam *IPAM) countTenantAddresses() map[string]int {
+	counts := make(map[string]int)
+	for owner, count := range ipam.ownerAddresses() {
+		tenant, _ := parseOwner(owner)
+		counts[tenant] += count
+	}
+	return counts
+}
+
+// ownerAddresses returns number of addresses allocated by owner.
+func (ipam *IPAM) ownerAddresses() map[string]int {
+	counts := make(map[string]int)
+	for _, ip := range ipam.AddressNameToIP {
+		if network := ipam.GetNetworkForIP(ip); network != nil {
+			_, owner := network.findIPInfo(ip)
+			counts[owner]++
+		}
+	}
+	return counts
+}
+
+// CreateTenant defines the tenant, RomanaExistsError is returned
+// if it is defined already.
+func (ipam *IPAM) CreateTenant(tenant api.TenantDefinition) error {
+	if tenant.Name == "" || strings.Contains(tenant.Name, ":") {
+		return common.NewError("Invalid tenant name %q", tenant.Name)
+	}
+
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := latestIPAM.Tenants[tenant.Name]; ok {
+		return errors.NewRomanaExistsErrorWithMessage(
+			fmt.Sprintf("Tenant %s already exists", tenant.Name),
+			tenant, "tenant", "name="+tenant.Name)
+	}
+	if latestIPAM.Tenants == nil {
+		latestIPAM.Tenants = make(map[string]*api.TenantDefinition)
+	}
+	latestIPAM.Tenants[tenant.Name] = &tenant
+	return ipam.save(latestIPAM, ch)
+}
+
+// UpdateTenant replaces definition of the tenant, RomanaNotFoundError
+// is returned if it is not defined.
+func (ipam *IPAM) UpdateTenant(tenant api.TenantDefinition) error {
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := latestIPAM.Tenants[tenant.Name]; !ok {
+		return errors.NewRomanaNotFoundError(fmt.Sprintf("Tenant %s not found", tenant.Name), "tenant", "name="+tenant.Name)
+	}
+	latestIPAM.Tenants[tenant.Name] = &tenant
+	return ipam.save(latestIPAM, ch)
+}
+
+// DeleteTenant deletes definition of the tenant. It fails while
+// the tenant has addresses allocated, unless force is true.
+func (ipam *IPAM) DeleteTenant(name string, force bool) error {
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := latestIPAM.Tenants[name]; !ok {
+		return errors.NewRomanaNotFoundError(fmt.Sprintf("Tenant %s not found", name), "tenant", "name="+name)
+	}
+	if allocated := latestIPAM.TenantAddresses[name]; allocated > 0 && !force {
+		return errors.NewRomanaExistsErrorWithMessage(
+			fmt.Sprintf("Tenant %s has %d addresses allocated", name, allocated),
+			name, "address", "tenant="+name)
+	}
+	delete(latestIPAM.Tenants, name)
+	return ipam.save(latestIPAM, ch)
+}
+
+// ListTenantDefinitions returns defined tenants, and tenants that
+// have blocks but are not defined, sorted by name.
+func (ipam *IPAM) ListTenantDefinitions() []api.TenantResponse {
+	tenants := make(map[string]*api.TenantResponse)
+	for name, def := range ipam.Tenants {
+		tenants[name] = &api.TenantResponse{TenantDefinition: *def, Defined: true}
+	}
+	for _, block := range ipam.ListAllBlocks().Blocks {
+		t, ok := tenants[block.Tenant]
+		if !ok {
+			t = &api.TenantResponse{TenantDefinition: api.TenantDefinition{Name: block.Tenant}}
+			tenants[block.Tenant] = t
+		}
+		t.Blocks++
+	}
+	for name, count := range ipam.TenantAddresses {
+		if t, ok := tenants[name]; ok {
+			t.Addresses = count
+		}
+	}
+
+	result := make([]api.TenantResponse, 0, len(tenants))
+	for _, t := range tenants {
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// GetTenant returns the tenant, RomanaNotFoundError is returned
+// if it is neither defined nor has any blocks.
+func (ipam *IPAM) GetTenant(name string) (api.TenantResponse, error) {
+	for _, t := range ipam.ListTenantDefinitions() {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return api.TenantResponse{}, errors.NewRomanaNotFoundError(fmt.Sprintf("Tenant %s not found", name), "tenant", "name="+name)
+}
+
+// CreateSegment defines the segment, RomanaExistsError is returned
+// if it is defined already.
+func (ipam *IPAM) CreateSegment(segment api.SegmentDefinition) error {
+	if segment.Tenant == "" || strings.Contains(segment.Tenant, ":") {
+		return common.NewError("Invalid tenant name %q", segment.Tenant)
+	}
+	if segment.Name == "" {
+		return common.NewError("Invalid segment name %q", segment.Name)
+	}
+
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	owner := makeOwner(segment.Tenant, segment.Name)
+	if _, ok := latestIPAM.Segments[owner]; ok {
+		return errors.NewRomanaExistsErrorWithMessage(
+			fmt.Sprintf("Segment %s of tenant %s already exists", segment.Name, segment.Tenant),
+			segment, "segment", "tenant="+segment.Tenant, "name="+segment.Name)
+	}
+	if latestIPAM.Segments == nil {
+		latestIPAM.Segments = make(map[string]*api.SegmentDefinition)
+	}
+	latestIPAM.Segments[owner] = &segment
+	return ipam.save(latestIPAM, ch)
+}
+
+// DeleteSegment deletes definition of the segment, segments that
+// are not defined but have blocks can be deleted as well. It fails
+// while the segment has addresses allocated, unless force is true,
+// in which case its addresses are deallocated and its blocks are
+// reclaimed.
+func (ipam *IPAM) DeleteSegment(tenant string, name string, force bool) error {
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	owner := makeOwner(tenant, name)
+	_, defined := latestIPAM.Segments[owner]
+	hasBlocks := false
+	for _, block := range latestIPAM.ListAllBlocks().Blocks {
+		if block.Tenant == tenant && block.Segment == name {
+			hasBlocks = true
+			break
+		}
+	}
+	if !defined && !hasBlocks {
+		return errors.NewRomanaNotFoundError(
+			fmt.Sprintf("Segment %s of tenant %s not found", name, tenant),
+			"segment", "tenant="+tenant, "name="+name)
+	}
+	if allocated := latestIPAM.ownerAddresses()[owner]; allocated > 0 && !force {
+		return errors.NewRomanaExistsErrorWithMessage(
+			fmt.Sprintf("Segment %s of tenant %s has %d addresses allocated", name, tenant, allocated),
+			name, "address", "tenant="+tenant, "segment="+name)
+	}
+
+	if hasBlocks {
+		for _, network := range latestIPAM.Networks {
+			if network.Group == nil {
+				continue
+			}
+			blocks, deleted := network.Group.reclaimOwnerBlocks(owner, latestIPAM.AddressNameToIP)
+			latestIPAM.countAddresses(owner, -deleted)
+			if blocks > 0 {
+				network.Revison++
+			}
+		}
+		latestIPAM.AllocationRevision++
+	}
+	delete(latestIPAM.Segments, owner)
+	return ipam.save(latestIPAM, ch)
+}
+
+// ListSegments returns defined segments of the tenant, and its
+// segments that have blocks but are not defined, sorted by name.
+// Policies referencing segments are not known to IPAM and are
+// left empty.
+func (ipam *IPAM) ListSegments(tenant string) []api.SegmentResponse {
+	segments := make(map[string]*api.SegmentResponse)
+	for owner, def := range ipam.Segments {
+		if def.Tenant == tenant {
+			segments[owner] = &api.SegmentResponse{SegmentDefinition: *def, Defined: true}
+		}
+	}
+	for _, block := range ipam.ListAllBlocks().Blocks {
+		if block.Tenant != tenant {
+			continue
+		}
+		owner := makeOwner(block.Tenant, block.Segment)
+		s, ok := segments[owner]
+		if !ok {
+			s = &api.SegmentResponse{SegmentDefinition: api.SegmentDefinition{Tenant: block.Tenant, Name: block.Segment}}
+			segments[owner] = s
+		}
+		s.Blocks = append(s.Blocks, block.CIDR.String())
+	}
+	for owner, count := range ipam.ownerAddresses() {
+		if s, ok := segments[owner]; ok {
+			s.Addresses = count
+		}
+	}
+
+	result := make([]api.SegmentResponse, 0, len(segments))
+	for _, s := range segments {
+		sort.Strings(s.Blocks)
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Stats returns hosts, endpoints, utilization of networks and
+// endpoints of tenants. Live hosts, policies and agents are not
+// known to IPAM and are left empty.
+func (ipam *IPAM) Stats() api.ClusterStats {
+	blocks := ipam.ListAllBlocks()
+	stats := api.ClusterStats{
+		Endpoints:      len(ipam.AddressNameToIP),
+		BlocksRevision: blocks.Revision,
+		Networks:       make([]api.NetworkStats, 0, len(ipam.Networks)),
+		Tenants:        make([]api.TenantStats, 0),
+		Agents:         make([]api.AgentStats, 0),
+	}
+
+	hosts := make(map[string]bool)
+	for _, host := range ipam.ListHosts().Hosts {
+		hosts[host.Name] = true
+	}
+	stats.Hosts = len(hosts)
+
+	networks := make(map[string]*api.NetworkStats)
+	for name, network := range ipam.Networks {
+		networks[name] = &api.NetworkStats{Name: name, CIDR: network.CIDR.String()}
+	}
+	for _, block := range blocks.Blocks {
+		if n, ok := networks[block.Network]; ok {
+			n.Blocks++
+			n.Allocated += block.AllocatedIPCount
+		}
+	}
+	for name, n := range networks {
+		ones, bits := ipam.Networks[name].CIDR.Mask.Size()
+		n.Utilization = float64(n.Allocated) * 100 / math.Ldexp(1, bits-ones)
+		stats.Networks = append(stats.Networks, *n)
+	}
+	sort.Slice(stats.Networks, func(i, j int) bool { return stats.Networks[i].Name < stats.Networks[j].Name })
+
+	for name, count := range ipam.TenantAddresses {
+		stats.Tenants = append(stats.Tenants, api.TenantStats{Name: name, Endpoints: count})
+	}
+	sort.Slice(stats.Tenants, func(i, j int) bool { return stats.Tenants[i].Name < stats.Tenants[j].Name })
+	return stats
+}
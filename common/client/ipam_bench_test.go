@@ -0,0 +1,149 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package client
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/romana/core/common/api"
+)
+
+// ipamBenchSizes are numbers of hosts of benchmark topologies.
+var ipamBenchSizes = []int{4, 64, 1024}
+
+// memorySaver keeps the last saved IPAM in memory without
+// serializing it, so that benchmarks of IPAM operations
+// don't measure the cost of the store.
+type memorySaver struct {
+	ipam IPAM
+}
+
+func (s *memorySaver) save(ipam *IPAM, ch <-chan struct{}) error {
+	s.ipam = *ipam
+	return nil
+}
+
+func (s *memorySaver) load(ipam *IPAM, ch <-chan struct{}) error {
+	*ipam = s.ipam
+	return nil
+}
+
+// benchTopology returns topology of a 10.0.0.0/8 network with
+// blocks of 16 addresses and the number of hosts in one group.
+func benchTopology(hosts int) api.TopologyUpdateRequest {
+	group := api.GroupOrHost{Routing: "block-on-host"}
+	for i := 0; i < hosts; i++ {
+		group.Groups = append(group.Groups, api.GroupOrHost{
+			Name: fmt.Sprintf("host%d", i),
+			IP:   net.IPv4(192, 168, byte(i/256), byte(i%256)),
+		})
+	}
+	return api.TopologyUpdateRequest{
+		Networks: []api.NetworkDefinition{{Name: "net1", CIDR: "10.0.0.0/8", BlockMask: 28}},
+		Topologies: []api.TopologyDefinition{{
+			Networks: []string{"net1"},
+			Map:      []api.GroupOrHost{group},
+		}},
+	}
+}
+
+// newBenchIPAM returns IPAM with the topology of benchTopology
+// and the number of addresses allocated on every host.
+func newBenchIPAM(b *testing.B, hosts int, perHost int) *IPAM {
+	saver := &memorySaver{}
+	ipam, err := NewIPAM(saver.save, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ipam.load = saver.load
+	if err := ipam.UpdateTopology(benchTopology(hosts), true); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < hosts*perHost; i++ {
+		name := fmt.Sprintf("addr%d", i)
+		if _, err := ipam.AllocateIP(name, fmt.Sprintf("host%d", i%hosts), "", ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := saver.load(ipam, nil); err != nil {
+		b.Fatal(err)
+	}
+	return ipam
+}
+
+// BenchmarkAllocateIP measures allocation of an address on hosts
+// which have 4 addresses allocated each, the address is
+// deallocated with the timer stopped.
+func BenchmarkAllocateIP(b *testing.B) {
+	for _, hosts := range ipamBenchSizes {
+		b.Run(fmt.Sprintf("hosts=%d", hosts), func(b *testing.B) {
+			ipam := newBenchIPAM(b, hosts, 4)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := ipam.AllocateIP("bench", fmt.Sprintf("host%d", i%hosts), "", ""); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				if err := ipam.DeallocateIP("bench"); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// BenchmarkIPAMSave measures encoding of IPAM the way the
+// Saver of the client does it before writing it to the store.
+func BenchmarkIPAMSave(b *testing.B) {
+	for _, hosts := range ipamBenchSizes {
+		b.Run(fmt.Sprintf("hosts=%d", hosts), func(b *testing.B) {
+			ipam := newBenchIPAM(b, hosts, 4)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				data, err := EncodeObject(KindIPAM, ipam)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(int64(len(data)))
+			}
+		})
+	}
+}
+
+// BenchmarkIPAMLoad measures decoding of IPAM the way the
+// Loader of the client does it after reading it from the store.
+func BenchmarkIPAMLoad(b *testing.B) {
+	for _, hosts := range ipamBenchSizes {
+		b.Run(fmt.Sprintf("hosts=%d", hosts), func(b *testing.B) {
+			data, err := EncodeObject(KindIPAM, newBenchIPAM(b, hosts, 4))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := parseIPAM(string(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package client
+
+import (
+	"testing"
+)
+
+// FuzzIPAM applies operations chosen by fuzzer input to IPAM,
+// see runIPAMFuzzer, e.g. go test -fuzz FuzzIPAM.
+func FuzzIPAM(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 3, 0, 4, 5, 6, 7, 4, 1, 2})
+	f.Add([]byte{0, 3, 1, 0, 0, 0, 3, 1, 0, 0, 10, 1, 4, 3, 5, 11, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		runIPAMFuzzer(t, bytesChooser(data))
+	})
+}
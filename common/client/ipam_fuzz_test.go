@@ -0,0 +1,293 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+// Randomized tests of IPAM: operations are chosen at random, by
+// math/rand or by a fuzzer, applied one by one, and invariants of
+// IPAM are checked after each of them, see IPAM.CheckInvariants.
+// Failures report the seed and the steps so they can be replayed.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romana/core/common/api"
+)
+
+var (
+	ipamFuzzSeed  = flag.Int64("ipam.seed", 0, "seed of TestIPAMRandomOperations, random if 0")
+	ipamFuzzSteps = flag.Int("ipam.steps", 2000, "number of operations of TestIPAMRandomOperations per seed")
+)
+
+// ipamFuzzTopologies are topologies operations switch between.
+// Networks are small so that blocks get exhausted, reclaimed,
+// and reused, and hosts are added to groups with free slots.
+var ipamFuzzTopologies = []string{
+	`{
+		"networks": [{"name": "net1", "cidr": "10.0.0.0/26", "block_mask": 29}],
+		"topologies": [{"networks": ["net1"], "map": [
+			{"groups": [{"name": "host1", "ip": "192.168.0.1"}, {"name": "host2", "ip": "192.168.0.2"}]},
+			{"groups": []}
+		]}]
+	}`,
+	`{
+		"networks": [{"name": "net1", "cidr": "10.0.0.0/26", "block_mask": 30}],
+		"topologies": [{"networks": ["net1"], "map": [
+			{"groups": [{"name": "host1", "ip": "192.168.0.1"}, {"name": "host2", "ip": "192.168.0.2"}]},
+			{"groups": [{"name": "host3", "ip": "192.168.0.3"}]},
+			{"groups": []},
+			{"groups": []}
+		]}]
+	}`,
+	`{
+		"networks": [
+			{"name": "net1", "cidr": "10.0.0.0/26", "block_mask": 29, "tenants": ["t0"]},
+			{"name": "net2", "cidr": "10.0.1.0/27", "block_mask": 30}
+		],
+		"topologies": [{"networks": ["net1", "net2"], "map": [
+			{"groups": [{"name": "host1", "ip": "192.168.0.1"}]},
+			{"groups": [{"name": "host2", "ip": "192.168.0.2"}]}
+		]}]
+	}`,
+}
+
+// ipamFuzzChooser chooses a number in [0, n), returns false
+// when there are no more choices, which ends the run.
+type ipamFuzzChooser func(n int) (int, bool)
+
+// randomChooser makes choices with math/rand for the given
+// number of operations.
+func randomChooser(seed int64, steps int) ipamFuzzChooser {
+	r := rand.New(rand.NewSource(seed))
+	return func(n int) (int, bool) {
+		if steps <= 0 {
+			return 0, false
+		}
+		steps--
+		return r.Intn(n), true
+	}
+}
+
+// bytesChooser makes choices with bytes of fuzzer input.
+func bytesChooser(data []byte) ipamFuzzChooser {
+	return func(n int) (int, bool) {
+		if len(data) == 0 {
+			return 0, false
+		}
+		b := data[0]
+		data = data[1:]
+		return int(b) % n, true
+	}
+}
+
+// ipamFuzzer applies operations to IPAM, keeping a model of
+// addresses it must have.
+type ipamFuzzer struct {
+	saver  *TestSaver
+	ipam   *IPAM
+	choose ipamFuzzChooser
+
+	// addresses are IPs of addresses by name, and hosts
+	// are hosts of addresses by name.
+	addresses map[string]net.IP
+	hosts     map[string]string
+}
+
+// ipamFuzzOp applies an operation, returns its description and
+// the error it failed with. Allocation and deallocation are more
+// likely than other operations.
+type ipamFuzzOp func(f *ipamFuzzer) (string, error)
+
+var ipamFuzzOps = []ipamFuzzOp{
+	allocateFuzzOp, allocateFuzzOp, allocateFuzzOp, allocateFuzzOp,
+	deallocateFuzzOp, deallocateFuzzOp, deallocateFuzzOp,
+	blackOutFuzzOp, unBlackOutFuzzOp,
+	addHostFuzzOp, removeHostFuzzOp,
+	updateTopologyFuzzOp,
+}
+
+// pick returns a choice in [0, n), runs out of choices
+// return 0 so that the last operation completes.
+func (f *ipamFuzzer) pick(n int) int {
+	i, _ := f.choose(n)
+	return i
+}
+
+func allocateFuzzOp(f *ipamFuzzer) (string, error) {
+	name := fmt.Sprintf("a%d", f.pick(16))
+	host := fmt.Sprintf("host%d", f.pick(5)+1)
+	tenant := fmt.Sprintf("t%d", f.pick(2))
+	segment := fmt.Sprintf("s%d", f.pick(2))
+	desc := fmt.Sprintf("allocate %s on %s for %s/%s", name, host, tenant, segment)
+	ip, err := f.ipam.AllocateIP(name, host, tenant, segment)
+	if err == nil {
+		if _, ok := f.addresses[name]; ok {
+			return desc, fmt.Errorf("allocated %s to %s again", ip, name)
+		}
+		f.addresses[name] = ip
+		f.hosts[name] = host
+		desc += " = " + ip.String()
+	}
+	return desc, err
+}
+
+func deallocateFuzzOp(f *ipamFuzzer) (string, error) {
+	name := fmt.Sprintf("a%d", f.pick(16))
+	err := f.ipam.DeallocateIP(name)
+	if err == nil {
+		delete(f.addresses, name)
+		delete(f.hosts, name)
+	}
+	return "deallocate " + name, err
+}
+
+// fuzzCIDR returns one of /30 and /29 CIDRs of 10.0.0.0/26.
+func (f *ipamFuzzer) fuzzCIDR() string {
+	if f.pick(2) == 0 {
+		return fmt.Sprintf("10.0.0.%d/30", f.pick(16)*4)
+	}
+	return fmt.Sprintf("10.0.0.%d/29", f.pick(8)*8)
+}
+
+func blackOutFuzzOp(f *ipamFuzzer) (string, error) {
+	cidr := f.fuzzCIDR()
+	return "black out " + cidr, f.ipam.BlackOut(cidr)
+}
+
+func unBlackOutFuzzOp(f *ipamFuzzer) (string, error) {
+	cidr := f.fuzzCIDR()
+	return "unblack out " + cidr, f.ipam.UnBlackOut(cidr)
+}
+
+func addHostFuzzOp(f *ipamFuzzer) (string, error) {
+	i := f.pick(5) + 1
+	host := api.Host{Name: fmt.Sprintf("host%d", i), IP: net.ParseIP(fmt.Sprintf("192.168.0.%d", i))}
+	return "add host " + host.Name, f.ipam.AddHost(host)
+}
+
+func removeHostFuzzOp(f *ipamFuzzer) (string, error) {
+	name := fmt.Sprintf("host%d", f.pick(5)+1)
+	err := f.ipam.RemoveHost(api.Host{Name: name})
+	if err == nil {
+		// addresses of the host are gone with it.
+		for address, host := range f.hosts {
+			if host == name {
+				delete(f.addresses, address)
+				delete(f.hosts, address)
+			}
+		}
+	}
+	return "remove host " + name, err
+}
+
+func updateTopologyFuzzOp(f *ipamFuzzer) (string, error) {
+	i := f.pick(len(ipamFuzzTopologies))
+	return fmt.Sprintf("update topology to %d", i), f.updateTopology(i)
+}
+
+func (f *ipamFuzzer) updateTopology(i int) error {
+	var req api.TopologyUpdateRequest
+	if err := json.Unmarshal([]byte(ipamFuzzTopologies[i]), &req); err != nil {
+		return err
+	}
+	return f.ipam.UpdateTopology(req, true)
+}
+
+// reload replaces IPAM with the one last saved, which discards
+// changes failed operations left in memory.
+func (f *ipamFuzzer) reload() error {
+	ipam := &IPAM{save: f.saver.save, load: f.saver.load}
+	if err := f.saver.load(ipam, nil); err != nil {
+		return err
+	}
+	f.ipam = ipam
+	return nil
+}
+
+// check returns error if invariants of IPAM don't hold or
+// its addresses are not the addresses of the model.
+func (f *ipamFuzzer) check() error {
+	if err := f.ipam.CheckInvariants(); err != nil {
+		return err
+	}
+	if len(f.ipam.AddressNameToIP) != len(f.addresses) ||
+		(len(f.addresses) > 0 && !reflect.DeepEqual(f.ipam.AddressNameToIP, f.addresses)) {
+		return fmt.Errorf("expected addresses %v, got %v", f.addresses, f.ipam.AddressNameToIP)
+	}
+	return nil
+}
+
+// runIPAMFuzzer applies operations chosen by choose to IPAM
+// with the first of ipamFuzzTopologies, and fails the test
+// listing the steps if invariants don't hold after one.
+func runIPAMFuzzer(t testing.TB, choose ipamFuzzChooser) {
+	saver := &TestSaver{}
+	ipam, err := NewIPAM(saver.save, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam.load = saver.load
+	f := &ipamFuzzer{
+		saver:     saver,
+		ipam:      ipam,
+		choose:    choose,
+		addresses: make(map[string]net.IP),
+		hosts:     make(map[string]string),
+	}
+	if err := f.updateTopology(0); err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []string
+	for {
+		op, ok := choose(len(ipamFuzzOps))
+		if !ok {
+			return
+		}
+		desc, err := ipamFuzzOps[op](f)
+		if err != nil {
+			desc += ": " + err.Error()
+		}
+		steps = append(steps, desc)
+		if err := f.reload(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.check(); err != nil {
+			t.Fatalf("%s after %d steps:\n%s", err, len(steps), strings.Join(steps, "\n"))
+		}
+	}
+}
+
+func TestIPAMRandomOperations(t *testing.T) {
+	seeds := []int64{1, 2, 3, 4, 5}
+	if *ipamFuzzSeed != 0 {
+		seeds = []int64{*ipamFuzzSeed}
+	} else if !testing.Short() {
+		seeds = append(seeds, time.Now().UnixNano())
+	}
+	for _, seed := range seeds {
+		t.Run(fmt.Sprintf("seed%d", seed), func(t *testing.T) {
+			runIPAMFuzzer(t, randomChooser(seed, *ipamFuzzSteps))
+		})
+	}
+}
@@ -17,12 +17,16 @@ package client
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/romana/core/common"
 	"github.com/romana/core/common/api"
 	"github.com/romana/core/common/api/errors"
 )
@@ -30,6 +34,8 @@ import (
 var (
 	testSaver *TestSaver
 	ipam      *IPAM
+
+	updateGolden = flag.Bool("update", false, "write IPAM of topology tests to their golden files in testdata instead of comparing")
 )
 
 func loadTestData(t *testing.T) []byte {
@@ -43,6 +49,46 @@ func loadTestData(t *testing.T) []byte {
 	return b
 }
 
+// checkGolden compares IPAM last saved by the test with the one in
+// testdata/<test>.golden.json, or writes it there with -update, so
+// that changes of topology algorithms come with diffs of the IPAM
+// they build.
+func checkGolden(t *testing.T) {
+	fileName := fmt.Sprintf("testdata/%s.golden.json", t.Name())
+	got := testSaver.lastJson + "\n"
+	if *updateGolden {
+		if err := ioutil.WriteFile(fileName, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("Updated %s", fileName)
+		return
+	}
+
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("%s, run go test -run %s -args -update to create it", err, t.Name())
+	}
+	if got == string(b) {
+		return
+	}
+	want := strings.Split(string(b), "\n")
+	lines := strings.Split(got, "\n")
+	for i := 0; i < len(want) || i < len(lines); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(lines) {
+			g = lines[i]
+		}
+		if w != g {
+			t.Fatalf("IPAM differs from %s at line %d:\nwant: %s\ngot:  %s\n"+
+				"run go test -run %s -args -update and review the diff of %s if the change is expected",
+				fileName, i+1, w, g, t.Name(), fileName)
+		}
+	}
+}
+
 func initIpam(t *testing.T, conf string) *IPAM {
 	// If not specified, load from file named after this test
 	if conf == "" {
@@ -782,48 +828,70 @@ func TestListBlocks(t *testing.T) {
 		t.Errorf("Expected revision 2, got %d", br.Revision)
 	}
 	t.Logf("Have %d blocks, revision %d", len(br.Blocks), br.Revision)
+	for _, block := range br.Blocks {
+		if block.Group == "" {
+			t.Errorf("Expected group of block %s", block.CIDR)
+		}
+	}
+
+	if n := len(ipam.ListBlocks("h1", "net1").Blocks); n != 2 {
+		t.Errorf("Expected 2 blocks of h1 in net1, got %d", n)
+	}
+	if n := len(ipam.ListBlocks("h2", "").Blocks); n != 0 {
+		t.Errorf("Expected no blocks of h2, got %d", n)
+	}
+	if n := len(ipam.ListBlocks("", "net2").Blocks); n != 0 {
+		t.Errorf("Expected no blocks in net2, got %d", n)
+	}
 }
 
 func TestParseSimpleFlatNetworkA(t *testing.T) {
 	t.Log("Example 1: Simple, flat network, (a)")
 	initIpam(t, "")
 	t.Logf("Slide 12: Example 1: Simple, flat network JSON:\n%s\n", testSaver.lastJson)
+	checkGolden(t)
 }
 
 func TestParseSimpleFlatNetworkB(t *testing.T) {
 	t.Logf("Example 1: Simple, flat network, (b)")
 	initIpam(t, "")
 	t.Logf("Slide 13: Example 1: Simple, flat network:\n%s\n", testSaver.lastJson)
+	checkGolden(t)
 }
 
 func TestParseSimpleFlatNetworkC(t *testing.T) {
 	t.Logf("Example 1: Simple, flat network, (c)")
 	initIpam(t, "")
 	t.Logf("Slide 14: Example 1: Simple, flat network JSON:\n%s\n", testSaver.lastJson)
+	checkGolden(t)
 }
 
 func TestParsePrefixPerHostA(t *testing.T) {
 	t.Logf("Example 2: Prefix per host (a)")
 	initIpam(t, "")
 	t.Logf("Slide 15: Example 2: Prefix per host (a) JSON:\n%s\n", testSaver.lastJson)
+	checkGolden(t)
 }
 
 func TestParsePrefixPerHostB(t *testing.T) {
 	t.Logf("Example 2: Prefix per host (ab")
 	initIpam(t, "")
 	t.Logf("Slide 16: Example 2: Prefix per host (b) JSON:\n%s\n", testSaver.lastJson)
+	checkGolden(t)
 }
 
 func TestParseMultiHostGroupsWithPrefix(t *testing.T) {
 	t.Logf("Example 3: Multi-host groups + prefix")
 	initIpam(t, "")
 	t.Logf("Slide 17: Example 3: Multi-host groups + prefix JSON:\n%s\n", testSaver.lastJson)
+	checkGolden(t)
 }
 
 func TestParseVPCRoutingForTwoAZs(t *testing.T) {
 	t.Logf("Example 4: VPC routing for two AZs")
 	initIpam(t, "")
 	t.Logf("Slide 18: Example 4: VPC routing for two AZs JSON:\n%s\n", testSaver.lastJson)
+	checkGolden(t)
 }
 
 func TestMultiNetAllocate(t *testing.T) {
@@ -1309,3 +1377,546 @@ func TestLabelUpdate(t *testing.T) {
 	}
 	t.Logf("Got expected error %s", err)
 }
+
+func TestListAndImportAddresses(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipam = initIpam(t, string(conf))
+	for _, name := range []string{"a", "b"} {
+		if _, err := ipam.AllocateIP(name, "host1", "ten1", "seg1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ipam.load(ipam, nil)
+
+	addresses := ipam.ListAddresses()
+	if len(addresses) != 2 {
+		t.Fatalf("Expected 2 addresses, got %v", addresses)
+	}
+	a := addresses[0]
+	if a.Name != "a" || a.IP.String() != "10.0.0.0" || a.Network != "net1" || a.Host != "host1" || a.Tenant != "ten1" || a.Segment != "seg1" {
+		t.Fatalf("Unexpected address %+v", a)
+	}
+
+	networks := ipam.ListNetworks()
+	if len(networks) != 1 || networks[0].Size != 2 || networks[0].Allocated != 2 || networks[0].Blocks != 1 {
+		t.Fatalf("Unexpected networks %+v", networks)
+	}
+
+	// import into IPAM of another cluster with the same topology.
+	other := initIpam(t, string(conf))
+	if resp, err := other.ImportAddresses(addresses); err != nil {
+		t.Fatal(err)
+	} else if resp.Imported != 2 || resp.Skipped != 0 || len(resp.Addresses) != 2 {
+		t.Fatalf("Expected 2 addresses imported, got %+v", resp)
+	}
+	other.load(other, nil)
+	imported := other.ListAddresses()
+	if len(imported) != 2 || imported[1].Name != "b" || imported[1].IP.String() != "10.0.0.1" {
+		t.Fatalf("Unexpected imported addresses %v", imported)
+	}
+
+	// repeated import is a no-op, import of a name at another IP fails.
+	if resp, err := other.ImportAddresses(addresses); err != nil {
+		t.Fatal(err)
+	} else if resp.Imported != 0 || resp.Skipped != 2 || len(resp.Addresses) != 0 {
+		t.Fatalf("Expected 2 addresses skipped, got %+v", resp)
+	}
+	a.IP = addresses[1].IP
+	if _, err := other.ImportAddresses([]api.IPAMAddress{a}); err == nil {
+		t.Fatal("Expected import of allocated name at another IP to fail")
+	}
+}
+
+func TestImportAddressesLimits(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := api.IPAMAddress{Name: "a", IP: net.ParseIP("10.0.0.0"), Host: "host1", Tenant: "ten1", Segment: "seg1"}
+	b := api.IPAMAddress{Name: "b", IP: net.ParseIP("10.0.0.1"), Host: "host1", Tenant: "ten1", Segment: "seg1"}
+
+	ipam = initIpam(t, string(conf))
+	if err := ipam.CordonHost(api.Host{Name: "host1"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipam.ImportAddresses([]api.IPAMAddress{a}); err == nil {
+		t.Fatal("Expected import on cordoned host to fail")
+	}
+
+	ipam = initIpam(t, string(conf))
+	ipam.Networks["net1"].Group.findHostByName("host1").Capacity = 1
+	ipam.save(ipam, nil)
+	if _, err := ipam.ImportAddresses([]api.IPAMAddress{a, b}); err == nil {
+		t.Fatal("Expected import beyond capacity of host to fail")
+	}
+
+	ipam = initIpam(t, string(conf))
+	if err := ipam.CreateTenant(api.TenantDefinition{Name: "ten1", MaxAddresses: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipam.ImportAddresses([]api.IPAMAddress{a, b}); err == nil {
+		t.Fatal("Expected import beyond quota of tenant to fail")
+	}
+
+	// failed import doesn't import any of the addresses.
+	ipam.load(ipam, nil)
+	if addresses := ipam.ListAddresses(); len(addresses) != 0 {
+		t.Fatalf("Expected no addresses imported, got %v", addresses)
+	}
+}
+
+func TestHostCapacity(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipam = initIpam(t, string(conf))
+	ipam.Networks["net1"].Group.findHostByName("host1").Capacity = 1
+	ipam.save(ipam, nil)
+
+	if _, err := ipam.AllocateIP("a", "host1", "ten1", "seg1"); err != nil {
+		t.Fatal(err)
+	}
+	if ip, err := ipam.AllocateIP("b", "host1", "ten1", "seg1"); err == nil {
+		t.Fatalf("Expected host at capacity to fail allocation, got %s", ip)
+	}
+
+	ipam.load(ipam, nil)
+	if hosts := ipam.ListHosts(); len(hosts.Hosts) != 1 || hosts.Hosts[0].Capacity != 1 {
+		t.Fatalf("Expected host with capacity 1, got %v", hosts)
+	}
+}
+
+func TestCordonHost(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipam = initIpam(t, string(conf))
+	ip, err := ipam.AllocateIP("a", "host1", "ten1", "seg1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.CordonHost(api.Host{Name: "host1"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.CordonHost(api.Host{Name: "host2"}, true); err == nil {
+		t.Fatal("Expected cordon of unknown host to fail")
+	}
+
+	_, err = ipam.AllocateIP("b", "host1", "ten1", "seg1")
+	if httpErr, ok := err.(common.HttpError); !ok || httpErr.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected allocation on cordoned host to conflict, got %T: %v", err, err)
+	}
+	ipam.load(ipam, nil)
+	if hosts := ipam.ListHosts(); len(hosts.Hosts) != 1 || !hosts.Hosts[0].Cordoned {
+		t.Fatalf("Expected cordoned host, got %v", hosts)
+	}
+
+	// Addresses allocated before cordon are kept.
+	addresses, err := ipam.ListHostAddresses(api.Host{IP: net.ParseIP("192.168.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 1 || addresses[0].Name != "a" || !addresses[0].IP.Equal(ip) {
+		t.Fatalf("Expected address a (%s) on host1, got %v", ip, addresses)
+	}
+
+	if err := ipam.CordonHost(api.Host{Name: "host1"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipam.AllocateIP("b", "host1", "ten1", "seg1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCordonHostTopology tests that cordon and capacity of hosts
+// are kept when topology is read and applied back.
+func TestCordonHostTopology(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipam = initIpam(t, string(conf))
+	ipam.Networks["net1"].Group.findHostByName("host1").Capacity = 5
+	ipam.save(ipam, nil)
+	if err := ipam.CordonHost(api.Host{Name: "host1"}, true); err != nil {
+		t.Fatal(err)
+	}
+	ipam.load(ipam, nil)
+
+	topology := getTopologyFromIPAMState(ipam).(*api.TopologyUpdateRequest)
+	if err := ipam.UpdateTopology(*topology, false); err != nil {
+		t.Fatal(err)
+	}
+
+	host := ipam.Networks["net1"].Group.findHostByName("host1")
+	if host == nil || !host.Cordoned || host.Capacity != 5 {
+		t.Fatalf("Expected cordoned host1 with capacity 5, got %v", host)
+	}
+	if _, err := ipam.AllocateIP("a", "host1", "ten1", "seg1"); err == nil {
+		t.Fatal("Expected allocation on cordoned host to fail after topology update")
+	}
+}
+
+// TestHostTagsTopology tests that tags and capacity of hosts
+// are kept when topology is read and applied back.
+func TestHostTagsTopology(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipam = initIpam(t, string(conf))
+	host := ipam.Networks["net1"].Group.findHostByName("host1")
+	host.Tags = map[string]string{"rack": "r1"}
+	host.Capacity = 3
+
+	topology := getTopologyFromIPAMState(ipam).(*api.TopologyUpdateRequest)
+	b, err := json.Marshal(topology)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req api.TopologyUpdateRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.UpdateTopology(req, false); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := ipam.ListHosts().Hosts
+	if len(hosts) != 1 || hosts[0].Tags["rack"] != "r1" || hosts[0].Capacity != 3 {
+		t.Fatalf("Expected host1 with tag rack=r1 and capacity 3, got %v", hosts)
+	}
+}
+
+func TestTenantQuota(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// net1 of two blocks, so that ten2 gets a block of its own.
+	ipam = initIpam(t, strings.Replace(string(conf), "10.0.0.0/31", "10.0.0.0/30", 1))
+	if err := ipam.CreateTenant(api.TenantDefinition{Name: "ten1", MaxAddresses: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.CreateTenant(api.TenantDefinition{Name: "ten1"}); err == nil {
+		t.Fatal("Expected create of existing tenant to fail")
+	}
+
+	if _, err := ipam.AllocateIP("a", "host1", "ten1", "seg1"); err != nil {
+		t.Fatal(err)
+	}
+	if ip, err := ipam.AllocateIP("b", "host1", "ten1", "seg1"); err == nil {
+		t.Fatalf("Expected tenant at quota to fail allocation, got %s", ip)
+	} else if _, ok := err.(errors.RomanaQuotaExceededError); !ok {
+		t.Fatalf("Expected RomanaQuotaExceededError, got %T: %s", err, err)
+	}
+	if _, err := ipam.AllocateIP("c", "host1", "ten2", "seg1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ipam.load(ipam, nil)
+	tenants := ipam.ListTenantDefinitions()
+	if len(tenants) != 2 || !tenants[0].Defined || tenants[0].Addresses != 1 || tenants[1].Defined {
+		t.Fatalf("Expected defined ten1 and undefined ten2, got %+v", tenants)
+	}
+
+	if err := ipam.DeleteTenant("ten1", false); err == nil {
+		t.Fatal("Expected delete of tenant with addresses to fail")
+	}
+	if err := ipam.DeleteTenant("ten1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.DeleteTenant("ten1", true); err == nil {
+		t.Fatal("Expected delete of deleted tenant to fail")
+	}
+
+	ipam.load(ipam, nil)
+	if tenant, err := ipam.GetTenant("ten1"); err != nil || tenant.Defined || tenant.Addresses != 1 {
+		t.Fatalf("Expected undefined ten1 with 1 address, got %+v, %v", tenant, err)
+	}
+
+	// IPAM saved before addresses were counted.
+	ipam.TenantAddresses = nil
+	b, err := json.Marshal(ipam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseIPAM(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := map[string]int{"ten1": 1, "ten2": 1}; !reflect.DeepEqual(parsed.TenantAddresses, expect) {
+		t.Fatalf("Expected addresses %v, got %v", expect, parsed.TenantAddresses)
+	}
+
+	if err := ipam.DeallocateIP("a"); err != nil {
+		t.Fatal(err)
+	}
+	ipam.load(ipam, nil)
+	if expect := map[string]int{"ten2": 1}; !reflect.DeepEqual(ipam.TenantAddresses, expect) {
+		t.Fatalf("Expected addresses %v after deallocation, got %v", expect, ipam.TenantAddresses)
+	}
+}
+
+func TestUpdateTenant(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipam = initIpam(t, string(conf))
+	if err := ipam.UpdateTenant(api.TenantDefinition{Name: "ten1"}); err == nil {
+		t.Fatal("Expected update of undefined tenant to fail")
+	} else if _, ok := err.(errors.RomanaNotFoundError); !ok {
+		t.Fatalf("Expected RomanaNotFoundError, got %T: %s", err, err)
+	}
+
+	if err := ipam.CreateTenant(api.TenantDefinition{Name: "ten1", ExternalID: "uid1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.UpdateTenant(api.TenantDefinition{Name: "ten1", ExternalID: "uid1", DefaultDeny: true, MaxAddresses: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	ipam.load(ipam, nil)
+	if tenant, err := ipam.GetTenant("ten1"); err != nil || tenant.MaxAddresses != 10 || !tenant.DefaultDeny || tenant.ExternalID != "uid1" {
+		t.Fatalf("Expected updated ten1, got %+v, %v", tenant, err)
+	}
+}
+
+func TestSegmentDefinitions(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// net1 of two blocks, so that seg3 gets a block of its own.
+	ipam = initIpam(t, strings.Replace(string(conf), "10.0.0.0/31", "10.0.0.0/30", 1))
+	for _, name := range []string{"seg1", "seg2"} {
+		if err := ipam.CreateSegment(api.SegmentDefinition{Tenant: "ten1", Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ipam.CreateSegment(api.SegmentDefinition{Tenant: "ten1", Name: "seg1"}); err == nil {
+		t.Fatal("Expected create of existing segment to fail")
+	}
+	if _, err := ipam.AllocateIP("a", "host1", "ten1", "seg1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ipam.load(ipam, nil)
+	segments := ipam.ListSegments("ten1")
+	if len(segments) != 2 || segments[0].Name != "seg1" || segments[0].Addresses != 1 || len(segments[0].Blocks) != 1 {
+		t.Fatalf("Expected seg1 with an address and seg2, got %+v", segments)
+	}
+
+	if err := ipam.DeleteSegment("ten1", "seg2", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.DeleteSegment("ten1", "seg1", false); err == nil {
+		t.Fatal("Expected delete of segment with addresses to fail")
+	}
+	if err := ipam.DeleteSegment("ten1", "seg2", false); err == nil {
+		t.Fatal("Expected delete of deleted segment to fail")
+	}
+
+	// undefined segment with addresses.
+	if _, err := ipam.AllocateIP("b", "host1", "ten1", "seg3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.DeleteSegment("ten1", "seg3", false); err == nil {
+		t.Fatal("Expected delete of undefined segment with addresses to fail")
+	}
+	for _, name := range []string{"seg1", "seg3"} {
+		if err := ipam.DeleteSegment("ten1", name, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ipam.load(ipam, nil)
+	if segments = ipam.ListSegments("ten1"); len(segments) != 0 {
+		t.Fatalf("Expected no segments, got %+v", segments)
+	}
+	if len(ipam.AddressNameToIP) != 0 || len(ipam.TenantAddresses) != 0 {
+		t.Fatalf("Expected addresses of deleted segments to be deallocated, got %v", ipam.AddressNameToIP)
+	}
+	if err := ipam.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIPAMStats(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipam = initIpam(t, string(conf))
+	for _, name := range []string{"a", "b"} {
+		if _, err := ipam.AllocateIP(name, "host1", "ten1", "seg1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ipam.load(ipam, nil)
+	stats := ipam.Stats()
+	if stats.Hosts != 1 || stats.Endpoints != 2 || len(stats.Networks) != 1 || stats.Networks[0].Allocated != 2 {
+		t.Fatalf("Expected 2 endpoints on 1 host, got %+v", stats)
+	}
+	if stats.Networks[0].Blocks != 1 || stats.Networks[0].Utilization <= 0 {
+		t.Fatalf("Expected utilized network with a block, got %+v", stats.Networks[0])
+	}
+	if len(stats.Tenants) != 1 || stats.Tenants[0].Name != "ten1" || stats.Tenants[0].Endpoints != 2 {
+		t.Fatalf("Expected 2 endpoints of ten1, got %+v", stats.Tenants)
+	}
+}
+
+func TestPlanTopology(t *testing.T) {
+	conf, err := ioutil.ReadFile("testdata/TestIPReuse.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipam = initIpam(t, string(conf))
+	for _, name := range []string{"a", "b"} {
+		if _, err := ipam.AllocateIP(name, "host1", "ten1", "seg1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ipam.load(ipam, nil)
+	addresses := ipam.ListAddresses()
+
+	var req api.TopologyUpdateRequest
+	if err := json.Unmarshal(conf, &req); err != nil {
+		t.Fatal(err)
+	}
+	req.Revision = ipam.TopologyRevision
+
+	plan, err := PlanTopology(req, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Conflicts) != 0 {
+		t.Fatalf("Expected no conflicts, got %v", plan.Conflicts)
+	}
+	groups := plan.Topology.Topologies[0].Map
+	if len(groups) != 1 || groups[0].Routing != "foo" || len(groups[0].Groups) != 1 {
+		t.Fatalf("Unexpected planned topology %+v", plan.Topology)
+	}
+	if host := groups[0].Groups[0]; host.Name != "host1" || !host.IP.Equal(net.ParseIP("192.168.0.1")) {
+		t.Fatalf("Unexpected planned topology %+v", plan.Topology)
+	}
+
+	// addresses of a host removed from topology can't be kept.
+	req.Topologies[0].Map[0].Groups[0].Name = "host2"
+	plan, err = PlanTopology(req, addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Conflicts) != 2 || plan.Conflicts[0].Address.Name != "a" || plan.Conflicts[0].Error == "" {
+		t.Fatalf("Expected 2 conflicts, got %v", plan.Conflicts)
+	}
+
+	req.Networks[0].BlockMask = 8
+	if _, err := PlanTopology(req, addresses); err == nil {
+		t.Fatal("Expected plan of invalid topology to fail")
+	}
+
+	// planning doesn't change IPAM.
+	ipam.load(ipam, nil)
+	if len(ipam.ListAddresses()) != 2 || ipam.Networks["net1"].Group.findHostByName("host1") == nil {
+		t.Fatal("Expected IPAM to be unchanged by planning")
+	}
+}
+
+func TestAllocateIPInZone(t *testing.T) {
+	conf := `{"networks": [{"name": "net1", "cidr": "10.0.0.0/16"},
+		{"name": "net2", "cidr": "10.1.0.0/16"}],
+		"topologies": [
+			{"networks": ["net1"], "map": [{"zone": "a", "groups": [
+				{"groups": [{"name": "host1", "ip": "192.168.0.1"}]}]}]},
+			{"networks": ["net2"], "map": [{"zone": "b", "groups": [
+				{"groups": [{"name": "host1", "ip": "192.168.0.1"}]}]}]}]}`
+	ipam = initIpam(t, conf)
+
+	_, net1, _ := net.ParseCIDR("10.0.0.0/16")
+	_, net2, _ := net.ParseCIDR("10.1.0.0/16")
+	for i, zone := range []string{"a", "b", "a"} {
+		ip, err := ipam.AllocateIPInZone(fmt.Sprintf("x%d", i), "host1", "ten1", "seg1", zone, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := net1
+		if zone == "b" {
+			expected = net2
+		}
+		if !expected.Contains(ip) {
+			t.Errorf("Expected address in zone %s to be in %s, got %s", zone, expected, ip)
+		}
+	}
+
+	// Preferred zone that the host is not in falls back to any
+	// network, required one fails.
+	if _, err := ipam.AllocateIPInZone("y1", "host1", "ten1", "seg1", "c", false); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ipam.AllocateIPInZone("y2", "host1", "ten1", "seg1", "c", true)
+	if httpErr, ok := err.(common.HttpError); !ok || httpErr.StatusCode != common.StatusUnprocessableEntity {
+		t.Fatalf("Expected 422 for allocation in required zone c, got %v", err)
+	}
+}
+
+func TestGroupNextHops(t *testing.T) {
+	conf := `{"networks": [{"name": "net1", "cidr": "10.0.0.0/16", "block_mask": 28}],
+		"topologies": [{"networks": ["net1"], "map": [
+			{"next_hops": ["192.168.1.1", "192.168.1.2"], "groups": [
+				{"groups": [{"name": "host1", "ip": "192.168.0.1"}]},
+				{"next_hops": ["192.168.2.1"], "groups": [{"name": "host2", "ip": "192.168.0.2"}]}]},
+			{"groups": [{"name": "host3", "ip": "192.168.0.3"}]}]}]}`
+	ipam = initIpam(t, conf)
+
+	for _, host := range []string{"host1", "host2", "host3"} {
+		if _, err := ipam.AllocateIP("a-"+host, host, "ten1", "seg1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ipam.load(ipam, nil)
+
+	// Subgroups use next hops of their parent unless
+	// they have their own.
+	expected := map[string][]string{
+		"host1": {"192.168.1.1", "192.168.1.2"},
+		"host2": {"192.168.2.1"},
+		"host3": nil,
+	}
+	blocks := ipam.ListAllBlocks().Blocks
+	if len(blocks) != len(expected) {
+		t.Fatalf("Expected %d blocks, got %v", len(expected), blocks)
+	}
+	for _, block := range blocks {
+		var nextHops []string
+		for _, ip := range block.NextHops {
+			nextHops = append(nextHops, ip.String())
+		}
+		if !reflect.DeepEqual(nextHops, expected[block.Host]) {
+			t.Errorf("Expected next hops %v for block of %s, got %v", expected[block.Host], block.Host, nextHops)
+		}
+	}
+
+	topology, ok := getTopologyFromIPAMState(ipam).(*api.TopologyUpdateRequest)
+	if !ok || len(topology.Topologies[0].Map[0].NextHops) != 2 {
+		t.Fatalf("Expected next hops in topology, got %+v", topology)
+	}
+}
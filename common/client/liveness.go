@@ -0,0 +1,219 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/romana/core/common"
+	log "github.com/romana/rlog"
+)
+
+// Agents keep a liveness record of their host under LivenessPrefix,
+// the record is stored with a TTL (a lease in etcd) and is renewed
+// by the agent. When the agent dies, or the host with it, the record
+// expires and watchers of liveness get HostDown event, which lets
+// IPAM garbage collection and route withdrawal act on dead hosts.
+
+// DefaultLivenessTTL is how long host is considered alive
+// after its agent last renewed the liveness record.
+const DefaultLivenessTTL = 30 * time.Second
+
+// HostLiveness is a liveness record of the host.
+type HostLiveness struct {
+	Host  string    `json:"host"`
+	Since time.Time `json:"since"`
+
+	// Renewed is updated every third of TTL.
+	Renewed time.Time `json:"renewed"`
+
+	// Version is build version of the agent.
+	Version string `json:"version,omitempty"`
+
+	// BlocksRevision is revision of blocks the agent has
+	// programmed routes for, and Converged is when it did.
+	BlocksRevision int       `json:"blocks_revision,omitempty"`
+	Converged      time.Time `json:"converged,omitempty"`
+}
+
+// HostEventType is a type of HostEvent.
+type HostEventType string
+
+const (
+	// HostUp means host registered its liveness.
+	HostUp HostEventType = "HostUp"
+
+	// HostDown means liveness record of the host expired.
+	HostDown HostEventType = "HostDown"
+)
+
+// HostEvent is a change of host liveness.
+type HostEvent struct {
+	Type HostEventType
+	Host string
+
+	// Liveness is the last known liveness record of the host.
+	Liveness HostLiveness
+}
+
+// KeepHostAlive registers the host as alive and renews registration
+// every third of ttl until ctx is done, ttl <= 0 means DefaultLivenessTTL.
+// Registration is not removed when ctx is done, so that restart of
+// the agent doesn't look like the host went down, it expires after
+// ttl unless renewed again.
+func (c *Client) KeepHostAlive(ctx context.Context, host string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = DefaultLivenessTTL
+	}
+
+	liveness := &HostLiveness{Host: host, Since: time.Now(), Version: common.GetVersionInfo().Version}
+	c.livenessMu.Lock()
+	c.liveness = liveness
+	c.livenessTTL = ttl
+	c.livenessMu.Unlock()
+	if err := c.renewLiveness(liveness, ttl); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.renewLiveness(liveness, ttl); err != nil {
+					log.Errorf("Failed to renew liveness of host %s: %s", host, err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (c *Client) renewLiveness(liveness *HostLiveness, ttl time.Duration) error {
+	c.livenessMu.Lock()
+	liveness.Renewed = time.Now()
+	b, err := json.Marshal(liveness)
+	c.livenessMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.Store.PutObjectWithTTL(LivenessPrefix+"/"+liveness.Host, b, ttl)
+}
+
+// SetBlocksRevision records in the liveness record of the host that
+// its agent has programmed routes for the revision of blocks, so
+// that convergence of agents can be observed. It does nothing
+// unless KeepHostAlive was called.
+func (c *Client) SetBlocksRevision(revision int) error {
+	c.livenessMu.Lock()
+	liveness, ttl := c.liveness, c.livenessTTL
+	if liveness != nil {
+		liveness.BlocksRevision = revision
+		liveness.Converged = time.Now()
+	}
+	c.livenessMu.Unlock()
+
+	if liveness == nil {
+		return nil
+	}
+	return c.renewLiveness(liveness, ttl)
+}
+
+// LiveHosts returns liveness records of hosts that are alive by host name.
+func (c *Client) LiveHosts() (map[string]HostLiveness, error) {
+	hosts := make(map[string]HostLiveness)
+
+	kvps, err := c.Store.ListObjects(LivenessPrefix)
+	if err == libkvStore.ErrKeyNotFound {
+		return hosts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kvp := range kvps {
+		var liveness HostLiveness
+		if err := json.Unmarshal(kvp.Value, &liveness); err != nil {
+			log.Errorf("Failed to parse liveness record %s: %s", kvp.Key, err)
+			continue
+		}
+		hosts[liveness.Host] = liveness
+	}
+
+	return hosts, nil
+}
+
+// WatchHostLiveness reports hosts going up and down until stopCh
+// is closed, hosts that are alive when watch starts are reported
+// as HostUp first.
+func (c *Client) WatchHostLiveness(stopCh <-chan struct{}) (<-chan HostEvent, error) {
+	dir := c.Store.Key(LivenessPrefix)
+	changes, err := c.Store.WatchTreeChanges(dir, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan HostEvent)
+	go func() {
+		defer close(out)
+		for batch := range changes {
+			for _, change := range batch {
+				event, ok := hostEvent(dir, change)
+				if !ok {
+					continue
+				}
+				log.Infof("Host %s liveness changed: %s", event.Host, event.Type)
+				select {
+				case out <- event:
+				case <-stopCh:
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// hostEvent converts change of liveness record to HostEvent,
+// returns false for renewals of the record.
+func hostEvent(dir string, change KVChange) (HostEvent, bool) {
+	event := HostEvent{Host: strings.TrimPrefix(normalize(change.Key), normalize(dir)+"/")}
+
+	record := change.Value
+	switch {
+	case change.PrevValue == nil:
+		event.Type = HostUp
+	case change.Value == nil:
+		event.Type = HostDown
+		record = change.PrevValue
+	default:
+		return event, false
+	}
+
+	if err := json.Unmarshal(record, &event.Liveness); err != nil {
+		log.Errorf("Failed to parse liveness record %s: %s", change.Key, err)
+	}
+	return event, true
+}
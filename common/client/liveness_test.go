@@ -0,0 +1,81 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/romana/core/common"
+)
+
+func TestHostLiveness(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	c := &Client{Store: store}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	events, err := c.WatchHostLiveness(stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectEvent := func(expect HostEventType) {
+		select {
+		case event := <-events:
+			if event.Type != expect || event.Host != "host1" || event.Liveness.Host != "host1" {
+				t.Fatalf("expected %s of host1, got %+v", expect, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s", expect)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ttl := 150 * time.Millisecond
+	if err := c.KeepHostAlive(ctx, "host1", ttl); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(HostUp)
+
+	// renewals keep the host alive past its ttl.
+	time.Sleep(2 * ttl)
+	hosts, err := c.LiveHosts()
+	if err != nil || len(hosts) != 1 || hosts["host1"].Renewed.Equal(hosts["host1"].Since) {
+		t.Fatalf("expected renewed host1 to be alive, got %v, %v", hosts, err)
+	}
+
+	if err := c.SetBlocksRevision(7); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err = c.LiveHosts()
+	if err != nil || hosts["host1"].BlocksRevision != 7 || hosts["host1"].Converged.IsZero() {
+		t.Fatalf("expected host1 converged on revision 7, got %v, %v", hosts, err)
+	}
+
+	cancel()
+	expectEvent(HostDown)
+
+	hosts, err = c.LiveHosts()
+	if err != nil || len(hosts) != 0 {
+		t.Fatalf("expected no live hosts, got %v, %v", hosts, err)
+	}
+}
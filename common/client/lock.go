@@ -0,0 +1,119 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+)
+
+// DefaultLockTTL is TTL of store locks created without one.
+const DefaultLockTTL = 20 * time.Second
+
+// DistributedLock is a lock held in the store, it serializes
+// updates made by several replicas of a component, e.g. writers
+// of IPAM. The lock key has a TTL that the backend keeps renewing
+// while the lock is held, so the lock of a crashed holder expires
+// after the TTL. In-memory backend ignores the TTL.
+//
+// DistributedLock is safe for use by multiple goroutines, they
+// take the lock in the store one at a time.
+type DistributedLock struct {
+	key    string
+	locker libkvStore.Locker
+
+	// local is a semaphore that serializes goroutines
+	// of this process, a value in it means lock is held.
+	local chan struct{}
+}
+
+// NewDistributedLock returns lock named name, ttl <= 0 means DefaultLockTTL.
+func (s *Store) NewDistributedLock(name string, ttl time.Duration) (*DistributedLock, error) {
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+
+	key := s.getKey("/lock/" + name)
+	locker, err := s.kv().NewLock(key, &libkvStore.LockOptions{TTL: ttl})
+	if err != nil {
+		return nil, err
+	}
+
+	return &DistributedLock{key: key, locker: locker, local: make(chan struct{}, 1)}, nil
+}
+
+// Lock blocks until the lock is acquired or ctx is done.
+// Returned channel is closed if the lock is lost, e.g. when
+// its TTL could not be renewed in time.
+func (l *DistributedLock) Lock(ctx context.Context) (<-chan struct{}, error) {
+	select {
+	case l.local <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stopCh)
+		case <-done:
+		}
+	}()
+
+	lost, err := l.locker.Lock(stopCh)
+	if err != nil {
+		<-l.local
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to acquire lock %s: %s", l.key, err)
+	}
+
+	return lost, nil
+}
+
+// TryLock attempts to acquire the lock for up to timeout,
+// returns false if the lock is held by someone else.
+func (l *DistributedLock) TryLock(timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := l.Lock(ctx)
+	if err == context.DeadlineExceeded {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Unlock releases the lock.
+func (l *DistributedLock) Unlock() error {
+	if len(l.local) == 0 {
+		return fmt.Errorf("lock %s is not held", l.key)
+	}
+
+	err := l.locker.Unlock()
+	<-l.local
+	if err != nil {
+		return fmt.Errorf("failed to release lock %s: %s", l.key, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/romana/core/common"
+)
+
+func TestDistributedLock(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// two replicas locking the same name.
+	first, err := store.NewDistributedLock("ipam", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.NewDistributedLock("ipam", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := first.Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := second.TryLock(10 * time.Millisecond)
+	if ok || err != nil {
+		t.Fatalf("expected lock held by another replica to time out, got %t, %v", ok, err)
+	}
+
+	// goroutines of the same replica are serialized too.
+	ok, err = first.TryLock(10 * time.Millisecond)
+	if ok || err != nil {
+		t.Fatalf("expected lock held by this replica to time out, got %t, %v", ok, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := second.Lock(ctx); err != context.Canceled {
+		t.Fatalf("expected cancelled lock attempt, got %v", err)
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Unlock(); err == nil {
+		t.Fatal("expected error unlocking lock that isn't held")
+	}
+
+	ok, err = second.TryLock(time.Second)
+	if !ok || err != nil {
+		t.Fatalf("expected released lock to be acquired, got %t, %v", ok, err)
+	}
+	if err := second.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,411 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+)
+
+// MemoryKV implements KV in memory, it is meant for tests and
+// for running romana services in development without etcd.
+// Watches deliver current value first and then every change,
+// intermediate changes may be coalesced if receiver is slow.
+// Keys are normalized the same way Store normalizes them,
+// keys put with TTL expire unless put again within TTL,
+// values don't survive restart of the process.
+type MemoryKV struct {
+	mu        sync.Mutex
+	data      map[string]*libkvStore.KVPair
+	lastIndex uint64
+
+	// watchers are notified on every change.
+	watchers map[*memoryWatcher]bool
+
+	// locks are held locks by key, channel is closed on unlock.
+	locks map[string]chan struct{}
+
+	// done is closed by Close to stop watches.
+	done chan struct{}
+}
+
+// NewMemoryKV returns empty MemoryKV.
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{
+		data:     make(map[string]*libkvStore.KVPair),
+		watchers: make(map[*memoryWatcher]bool),
+		locks:    make(map[string]chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// memoryWatcher gets a signal when data changes,
+// buffer of one coalesces signals not yet received.
+type memoryWatcher struct {
+	changed chan struct{}
+}
+
+// Put implements KV.
+func (m *MemoryKV) Put(key string, value []byte, options *libkvStore.WriteOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key = normalize(key)
+	kvp := m.put(key, value)
+	if options != nil && options.TTL > 0 {
+		time.AfterFunc(options.TTL, func() { m.expire(key, kvp.LastIndex) })
+	}
+	return nil
+}
+
+// expire deletes the key put with TTL, unless it was put again since.
+func (m *MemoryKV) expire(key string, index uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if kvp, ok := m.data[key]; ok && kvp.LastIndex == index {
+		delete(m.data, key)
+		m.lastIndex++
+		m.notify()
+	}
+}
+
+// put stores a copy of the value, must be called with mu held.
+func (m *MemoryKV) put(key string, value []byte) *libkvStore.KVPair {
+	m.lastIndex++
+	kvp := &libkvStore.KVPair{
+		Key:       key,
+		Value:     append([]byte(nil), value...),
+		LastIndex: m.lastIndex,
+	}
+	m.data[key] = kvp
+	m.notify()
+	return copyKVPair(kvp)
+}
+
+// Get implements KV.
+func (m *MemoryKV) Get(key string) (*libkvStore.KVPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kvp, ok := m.data[normalize(key)]
+	if !ok {
+		return nil, libkvStore.ErrKeyNotFound
+	}
+	return copyKVPair(kvp), nil
+}
+
+// Delete implements KV.
+func (m *MemoryKV) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key = normalize(key)
+	if _, ok := m.data[key]; !ok {
+		return libkvStore.ErrKeyNotFound
+	}
+	delete(m.data, key)
+	m.lastIndex++
+	m.notify()
+	return nil
+}
+
+// Exists implements KV.
+func (m *MemoryKV) Exists(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.data[normalize(key)]
+	return ok, nil
+}
+
+// List implements KV, returns keys under the directory sorted by key.
+func (m *MemoryKV) List(directory string) ([]*libkvStore.KVPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kvps := m.list(normalize(directory))
+	if len(kvps) == 0 {
+		return nil, libkvStore.ErrKeyNotFound
+	}
+	return kvps, nil
+}
+
+// list must be called with mu held.
+func (m *MemoryKV) list(dir string) []*libkvStore.KVPair {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+
+	var result []*libkvStore.KVPair
+	for key, kvp := range m.data {
+		if strings.HasPrefix(key, prefix) {
+			result = append(result, copyKVPair(kvp))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// DeleteTree deletes all keys under the directory.
+func (m *MemoryKV) DeleteTree(directory string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, kvp := range m.list(normalize(directory)) {
+		delete(m.data, kvp.Key)
+	}
+	m.lastIndex++
+	m.notify()
+	return nil
+}
+
+// AtomicPut implements KV. Nil previous means the key must not
+// exist, otherwise LastIndex of previous must match current one.
+func (m *MemoryKV) AtomicPut(key string, value []byte, previous *libkvStore.KVPair, options *libkvStore.WriteOptions) (bool, *libkvStore.KVPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key = normalize(key)
+	current, ok := m.data[key]
+	switch {
+	case previous == nil && ok:
+		return false, nil, libkvStore.ErrKeyExists
+	case previous != nil && !ok:
+		return false, nil, libkvStore.ErrKeyNotFound
+	case previous != nil && previous.LastIndex != current.LastIndex:
+		return false, nil, libkvStore.ErrKeyModified
+	}
+
+	return true, m.put(key, value), nil
+}
+
+// AtomicDelete deletes the key if it wasn't modified since previous.
+func (m *MemoryKV) AtomicDelete(key string, previous *libkvStore.KVPair) (bool, error) {
+	if previous == nil {
+		return false, libkvStore.ErrPreviousNotSpecified
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key = normalize(key)
+	current, ok := m.data[key]
+	if !ok {
+		return false, libkvStore.ErrKeyNotFound
+	}
+	if previous.LastIndex != current.LastIndex {
+		return false, libkvStore.ErrKeyModified
+	}
+	delete(m.data, key)
+	m.lastIndex++
+	m.notify()
+	return true, nil
+}
+
+// Watch implements KV, channel receives current value of
+// the key and then its new values until stopCh is closed.
+// Deletion of the key is not reported.
+func (m *MemoryKV) Watch(key string, stopCh <-chan struct{}) (<-chan *libkvStore.KVPair, error) {
+	key = normalize(key)
+	if _, err := m.Get(key); err != nil {
+		return nil, err
+	}
+
+	out := make(chan *libkvStore.KVPair)
+	w := m.addWatcher()
+	go func() {
+		defer close(out)
+		defer m.removeWatcher(w)
+
+		var last uint64
+		for {
+			kvp, err := m.Get(key)
+			if err == nil && kvp.LastIndex != last {
+				last = kvp.LastIndex
+				select {
+				case out <- kvp:
+				case <-stopCh:
+					return
+				case <-m.done:
+					return
+				}
+			}
+
+			select {
+			case <-w.changed:
+			case <-stopCh:
+				return
+			case <-m.done:
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// WatchTree implements KV, channel receives current listing of
+// the directory and then a new listing on every change under it.
+func (m *MemoryKV) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*libkvStore.KVPair, error) {
+	dir := normalize(directory)
+
+	out := make(chan []*libkvStore.KVPair)
+	w := m.addWatcher()
+	go func() {
+		defer close(out)
+		defer m.removeWatcher(w)
+
+		var last []*libkvStore.KVPair
+		first := true
+		for {
+			m.mu.Lock()
+			kvps := m.list(dir)
+			m.mu.Unlock()
+
+			if first || !sameListing(last, kvps) {
+				first = false
+				last = kvps
+				select {
+				case out <- kvps:
+				case <-stopCh:
+					return
+				case <-m.done:
+					return
+				}
+			}
+
+			select {
+			case <-w.changed:
+			case <-stopCh:
+				return
+			case <-m.done:
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// NewLock implements KV, lock is only exclusive between
+// users of the same MemoryKV.
+func (m *MemoryKV) NewLock(key string, options *libkvStore.LockOptions) (libkvStore.Locker, error) {
+	return &memoryLock{kv: m, key: normalize(key)}, nil
+}
+
+// Close implements KV, stops all watches.
+func (m *MemoryKV) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-m.done:
+	default:
+		close(m.done)
+	}
+}
+
+func (m *MemoryKV) addWatcher() *memoryWatcher {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &memoryWatcher{changed: make(chan struct{}, 1)}
+	m.watchers[w] = true
+	return w
+}
+
+func (m *MemoryKV) removeWatcher(w *memoryWatcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.watchers, w)
+}
+
+// notify signals watchers about a change, must be called with mu held.
+func (m *MemoryKV) notify() {
+	for w := range m.watchers {
+		select {
+		case w.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func copyKVPair(kvp *libkvStore.KVPair) *libkvStore.KVPair {
+	return &libkvStore.KVPair{
+		Key:       kvp.Key,
+		Value:     append([]byte(nil), kvp.Value...),
+		LastIndex: kvp.LastIndex,
+	}
+}
+
+func sameListing(a, b []*libkvStore.KVPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || !bytes.Equal(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryLock implements libkv Locker for MemoryKV.
+type memoryLock struct {
+	kv   *MemoryKV
+	key  string
+	held chan struct{}
+}
+
+// Lock blocks until the lock is acquired or stopChan is closed.
+// Returned channel is closed when the lock is released.
+func (l *memoryLock) Lock(stopChan chan struct{}) (<-chan struct{}, error) {
+	for {
+		l.kv.mu.Lock()
+		current, locked := l.kv.locks[l.key]
+		if !locked {
+			l.held = make(chan struct{})
+			l.kv.locks[l.key] = l.held
+			l.kv.mu.Unlock()
+			return l.held, nil
+		}
+		l.kv.mu.Unlock()
+
+		select {
+		case <-current:
+		case <-stopChan:
+			return nil, libkvStore.ErrCannotLock
+		}
+	}
+}
+
+// Unlock releases the lock.
+func (l *memoryLock) Unlock() error {
+	l.kv.mu.Lock()
+	defer l.kv.mu.Unlock()
+
+	if l.held == nil || l.kv.locks[l.key] != l.held {
+		return libkvStore.ErrKeyNotFound
+	}
+	delete(l.kv.locks, l.key)
+	close(l.held)
+	l.held = nil
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+)
+
+func TestMemoryKV(t *testing.T) {
+	kv := NewMemoryKV()
+	defer kv.Close()
+
+	if err := kv.Put("/romana//dir/a", []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	kvp, err := kv.Get("romana/dir/a")
+	if err != nil || string(kvp.Value) != "1" || kvp.Key != "/romana/dir/a" {
+		t.Fatalf("unexpected get result %v, %v", kvp, err)
+	}
+	if _, err := kv.Get("/romana/dir/b"); err != libkvStore.ErrKeyNotFound {
+		t.Fatalf("expected key not found, got %v", err)
+	}
+
+	t.Run("atomic put", func(t *testing.T) {
+		if ok, _, err := kv.AtomicPut("/romana/dir/a", []byte("2"), nil, nil); ok || err != libkvStore.ErrKeyExists {
+			t.Fatalf("expected create of existing key to fail, got %t, %v", ok, err)
+		}
+		ok, updated, err := kv.AtomicPut("/romana/dir/a", []byte("2"), kvp, nil)
+		if !ok || err != nil || updated.LastIndex <= kvp.LastIndex {
+			t.Fatalf("expected update to succeed, got %t, %v, %v", ok, updated, err)
+		}
+		if ok, _, err := kv.AtomicPut("/romana/dir/a", []byte("3"), kvp, nil); ok || err != libkvStore.ErrKeyModified {
+			t.Fatalf("expected update with stale index to fail, got %t, %v", ok, err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		kv.Put("/romana/dir/b", []byte("b"), nil)
+		kv.Put("/romana/dirty", []byte("x"), nil)
+		kvps, err := kv.List("/romana/dir")
+		if err != nil || len(kvps) != 2 || kvps[0].Key != "/romana/dir/a" || kvps[1].Key != "/romana/dir/b" {
+			t.Fatalf("unexpected listing %v, %v", kvps, err)
+		}
+		if _, err := kv.List("/romana/none"); err != libkvStore.ErrKeyNotFound {
+			t.Fatalf("expected key not found, got %v", err)
+		}
+	})
+}
+
+func TestMemoryKVWatch(t *testing.T) {
+	kv := NewMemoryKV()
+	defer kv.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	if _, err := kv.Watch("/key", stopCh); err != libkvStore.ErrKeyNotFound {
+		t.Fatalf("expected watch of missing key to fail, got %v", err)
+	}
+
+	kv.Put("/key", []byte("1"), nil)
+	values, err := kv.Watch("/key", stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listings, err := kv.WatchTree("/dir", stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectValue := func(expect string) {
+		select {
+		case kvp := <-values:
+			if string(kvp.Value) != expect {
+				t.Fatalf("expected value %s, got %s", expect, kvp.Value)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for value %s", expect)
+		}
+	}
+	expectListing := func(expect int) {
+		select {
+		case kvps := <-listings:
+			if len(kvps) != expect {
+				t.Fatalf("expected %d keys, got %v", expect, kvps)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for listing of %d keys", expect)
+		}
+	}
+
+	expectValue("1")
+	expectListing(0)
+
+	kv.Put("/key", []byte("2"), nil)
+	expectValue("2")
+
+	kv.Put("/dir/a", []byte("a"), nil)
+	expectListing(1)
+	kv.Delete("/dir/a")
+	expectListing(0)
+}
+
+func TestMemoryKVLock(t *testing.T) {
+	kv := NewMemoryKV()
+	defer kv.Close()
+
+	first, _ := kv.NewLock("/lock/ipam", nil)
+	second, _ := kv.NewLock("/lock/ipam", nil)
+
+	if _, err := first.Lock(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stopCh := make(chan struct{})
+	close(stopCh)
+	if _, err := second.Lock(stopCh); err != libkvStore.ErrCannotLock {
+		t.Fatalf("expected lock to be held, got %v", err)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		second.Lock(nil)
+		close(locked)
+	}()
+
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for lock to be released")
+	}
+
+	if err := first.Unlock(); err == nil {
+		t.Fatal("expected unlock of a lock that isn't held to fail")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/romana/rlog"
+)
+
+// DefaultSlowOpThreshold is duration of store operation above which
+// it is logged, unless common.Config.SlowOpThreshold says otherwise.
+const DefaultSlowOpThreshold = time.Second
+
+// Outcomes of store operations, as labelled in StoreOpDuration.
+const (
+	outcomeOK       = "ok"
+	outcomeNotFound = "not_found"
+	outcomeConflict = "conflict"
+	outcomeCached   = "cached"
+	outcomeError    = "error"
+)
+
+var (
+	CacheHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "romana_store_cache_hits_total",
+			Help: "Number of store reads served from read cache.",
+		},
+		[]string{"prefix"},
+	)
+	CacheMisses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "romana_store_cache_misses_total",
+			Help: "Number of reads of cached prefixes that went to the store.",
+		},
+		[]string{"prefix"},
+	)
+	StoreOpDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "romana_store_operation_duration_seconds",
+			Help:    "Duration of store operations by operation, top level key and outcome.",
+			Buckets: []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"operation", "prefix", "outcome"},
+	)
+)
+
+// MetricsRegister registers package global metrics into registry provided,
+// for later exposure.
+func MetricsRegister(registry *prometheus.Registry) error {
+	if registry == nil {
+		return fmt.Errorf("registry must not be nil")
+	}
+
+	for _, collector := range []prometheus.Collector{
+		CacheHits,
+		CacheMisses,
+		StoreOpDuration,
+	} {
+		err := registry.Register(collector)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// observeOp records duration of operation op on the key started
+// at start, and logs the operation if it took longer than the
+// slow operation threshold. Reads served from the read cache are
+// recorded too, so that time spent in etcd can be told apart
+// from time spent in romana.
+func (s *Store) observeOp(op string, key string, start time.Time, outcome string) {
+	elapsed := time.Since(start)
+	prefix := keyPrefix(s.unprefixed(key))
+	StoreOpDuration.WithLabelValues(op, prefix, outcome).Observe(elapsed.Seconds())
+
+	threshold := s.config.SlowOpThreshold
+	if threshold == 0 {
+		threshold = DefaultSlowOpThreshold
+	}
+	if threshold > 0 && elapsed >= threshold {
+		log.Warnf("Slow store operation: op=%s key=%s prefix=%s outcome=%s duration=%s",
+			op, key, prefix, outcome, elapsed)
+	}
+}
+
+// opOutcome returns outcome label for error returned by store operation.
+func opOutcome(err error) string {
+	switch err {
+	case nil:
+		return outcomeOK
+	case libkvStore.ErrKeyNotFound:
+		return outcomeNotFound
+	case libkvStore.ErrKeyModified, libkvStore.ErrKeyExists, ErrTxnConflict:
+		return outcomeConflict
+	}
+	return outcomeError
+}
+
+// keyPrefix returns top level directory of unprefixed key,
+// which keeps the number of metric label values small.
+func keyPrefix(key string) string {
+	key = normalize(key)
+	if i := strings.Index(key[1:], "/"); i >= 0 {
+		return key[:i+1]
+	}
+	return key
+}
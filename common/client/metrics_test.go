@@ -0,0 +1,49 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"errors"
+	"testing"
+
+	libkvStore "github.com/docker/libkv/store"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	for key, expect := range map[string]string{
+		"/":                    "/",
+		"/ipam":                "/ipam",
+		"/policies/default/p1": "/policies",
+		"hosts//host1":         "/hosts",
+	} {
+		if prefix := keyPrefix(key); prefix != expect {
+			t.Errorf("expected prefix of %s to be %s, got %s", key, expect, prefix)
+		}
+	}
+}
+
+func TestOpOutcome(t *testing.T) {
+	for err, expect := range map[error]string{
+		nil:                       outcomeOK,
+		libkvStore.ErrKeyNotFound: outcomeNotFound,
+		libkvStore.ErrKeyModified: outcomeConflict,
+		errors.New("timeout"):     outcomeError,
+	} {
+		if outcome := opOutcome(err); outcome != expect {
+			t.Errorf("expected outcome of %v to be %s, got %s", err, expect, outcome)
+		}
+	}
+}
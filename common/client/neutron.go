@@ -0,0 +1,454 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+// This file has functionality of romana IPAM driver of OpenStack
+// Neutron, which allocates Neutron subnets on romana networks and
+// addresses of Neutron ports in them.
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/api/errors"
+)
+
+// neutronAddressPrefix prefixes names of addresses allocated in
+// Neutron subnets, it's followed by ID of the subnet and ID of the
+// port or, for ports not given, by the address itself.
+const neutronAddressPrefix = "neutron."
+
+func neutronAddressName(subnetID string, suffix string) string {
+	return neutronAddressPrefix + subnetID + "." + suffix
+}
+
+// addressRange is a range of addresses from start to end.
+type addressRange struct {
+	start uint64
+	end   uint64
+}
+
+// neutronRanges validates gateway and allocation pools of the subnet
+// on the network, and returns ranges addresses are allocated from for
+// any address requests.
+func neutronRanges(subnet api.NeutronSubnet, network *Network) ([]addressRange, error) {
+	if subnet.GatewayIP != "" {
+		gateway := net.ParseIP(subnet.GatewayIP)
+		if gateway == nil || gateway.To4() == nil || !network.CIDR.ContainsIP(gateway) {
+			return nil, common.NewError400(fmt.Sprintf("Gateway %s is not in subnet %s", subnet.GatewayIP, network.CIDR.IPNet))
+		}
+	}
+
+	if len(subnet.AllocationPools) == 0 {
+		r := addressRange{start: network.CIDR.StartIPInt, end: network.CIDR.EndIPInt}
+		// Network and broadcast addresses are only
+		// skipped in subnets which have them.
+		if r.end-r.start > 1 {
+			r.start++
+			r.end--
+		}
+		return []addressRange{r}, nil
+	}
+
+	ranges := make([]addressRange, 0, len(subnet.AllocationPools))
+	for _, pool := range subnet.AllocationPools {
+		start, end := net.ParseIP(pool.Start), net.ParseIP(pool.End)
+		if start == nil || end == nil || start.To4() == nil || end.To4() == nil {
+			return nil, common.NewError400(fmt.Sprintf("Invalid allocation pool %s-%s", pool.Start, pool.End))
+		}
+		r := addressRange{start: common.IPv4ToInt(start), end: common.IPv4ToInt(end)}
+		if r.start > r.end || !network.CIDR.ContainsIP(start) || !network.CIDR.ContainsIP(end) {
+			return nil, common.NewError400(fmt.Sprintf("Allocation pool %s-%s is not a range in subnet %s",
+				pool.Start, pool.End, network.CIDR.IPNet))
+		}
+		for _, other := range ranges {
+			if r.start <= other.end && other.start <= r.end {
+				return nil, common.NewError400(fmt.Sprintf("Allocation pool %s-%s overlaps another one", pool.Start, pool.End))
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// inNeutronRanges returns true if the address is in one of
+// the ranges and isn't the gateway of the subnet.
+func inNeutronRanges(ip net.IP, ranges []addressRange, subnet api.NeutronSubnet) bool {
+	if subnet.GatewayIP != "" && ip.Equal(net.ParseIP(subnet.GatewayIP)) {
+		return false
+	}
+	ipInt := common.IPv4ToInt(ip)
+	for _, r := range ranges {
+		if r.start <= ipInt && ipInt <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// neutronSubnet returns the subnet and its network.
+func (ipam *IPAM) neutronSubnet(id string) (*api.NeutronSubnet, *Network, error) {
+	subnet, ok := ipam.NeutronSubnets[id]
+	if !ok {
+		return nil, nil, errors.NewRomanaNotFoundError(fmt.Sprintf("Subnet %s not found", id), "subnet", "id="+id)
+	}
+	network, ok := ipam.Networks[subnet.Network]
+	if !ok {
+		return nil, nil, common.NewUnprocessableEntityError(fmt.Sprintf("Network %s of subnet %s not found", subnet.Network, id))
+	}
+	return subnet, network, nil
+}
+
+// checkNeutronSubnets returns an error if networks of Neutron
+// subnets are not in the topology with CIDRs of the subnets.
+func (ipam *IPAM) checkNeutronSubnets() error {
+	for _, subnet := range ipam.NeutronSubnets {
+		network, ok := ipam.Networks[subnet.Network]
+		if !ok || network.CIDR.IPNet.String() != subnet.CIDR {
+			return common.NewErrorConflict(fmt.Sprintf("Network %s (%s) of Neutron subnet %s is not in topology",
+				subnet.Network, subnet.CIDR, subnet.ID))
+		}
+	}
+	return nil
+}
+
+// ListNeutronSubnets returns Neutron subnets sorted by ID.
+func (ipam *IPAM) ListNeutronSubnets() []api.NeutronSubnet {
+	subnets := make([]api.NeutronSubnet, 0, len(ipam.NeutronSubnets))
+	for _, subnet := range ipam.NeutronSubnets {
+		subnets = append(subnets, *subnet)
+	}
+	sort.Slice(subnets, func(i, j int) bool { return subnets[i].ID < subnets[j].ID })
+	return subnets
+}
+
+// GetNeutronSubnet returns the Neutron subnet with the ID.
+func (ipam *IPAM) GetNeutronSubnet(id string) (api.NeutronSubnet, error) {
+	subnet, _, err := ipam.neutronSubnet(id)
+	if err != nil {
+		return api.NeutronSubnet{}, err
+	}
+	return *subnet, nil
+}
+
+// AllocateNeutronSubnet allocates the subnet on the network of its
+// tenant with its CIDR or, if CIDR is not given, on the first network
+// of the tenant with PrefixLen bits long mask that has no subnet yet.
+func (ipam *IPAM) AllocateNeutronSubnet(subnet api.NeutronSubnet) (api.NeutronSubnet, error) {
+	if subnet.ID == "" || subnet.TenantID == "" {
+		return api.NeutronSubnet{}, common.NewError400("Subnet ID and tenant ID required")
+	}
+	var requested *net.IPNet
+	if subnet.CIDR != "" {
+		_, ipNet, err := net.ParseCIDR(subnet.CIDR)
+		if err != nil || ipNet.IP.To4() == nil {
+			return api.NeutronSubnet{}, common.NewError400(fmt.Sprintf("Invalid CIDR %q of subnet %s", subnet.CIDR, subnet.ID))
+		}
+		requested = ipNet
+	}
+
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return api.NeutronSubnet{}, err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return api.NeutronSubnet{}, err
+	}
+
+	if _, ok := latestIPAM.NeutronSubnets[subnet.ID]; ok {
+		return api.NeutronSubnet{}, errors.NewRomanaExistsErrorWithMessage(
+			fmt.Sprintf("Subnet %s already exists", subnet.ID),
+			subnet, "subnet", "id="+subnet.ID)
+	}
+	bound := make(map[string]string)
+	for _, other := range latestIPAM.NeutronSubnets {
+		bound[other.Network] = other.ID
+	}
+	networks, err := latestIPAM.getNetworksForTenant(subnet.TenantID)
+	if err != nil {
+		return api.NeutronSubnet{}, common.NewUnprocessableEntityError(err.Error())
+	}
+
+	var network *Network
+	for _, n := range networks {
+		if requested != nil {
+			if n.CIDR.IPNet.String() != requested.String() {
+				continue
+			}
+			if id, ok := bound[n.Name]; ok {
+				return api.NeutronSubnet{}, common.NewErrorConflict(fmt.Sprintf(
+					"Network %s (%s) is allocated to subnet %s", n.Name, n.CIDR.IPNet, id))
+			}
+		} else {
+			ones, _ := n.CIDR.Mask.Size()
+			if _, ok := bound[n.Name]; ok || (subnet.PrefixLen != 0 && subnet.PrefixLen != ones) {
+				continue
+			}
+		}
+		network = n
+		break
+	}
+	if network == nil {
+		if requested != nil {
+			return api.NeutronSubnet{}, common.NewUnprocessableEntityError(fmt.Sprintf(
+				"CIDR %s is not a network of tenant %s", requested, subnet.TenantID))
+		}
+		return api.NeutronSubnet{}, common.NewUnprocessableEntityError(fmt.Sprintf(
+			"Tenant %s has no network with /%d mask available", subnet.TenantID, subnet.PrefixLen))
+	}
+
+	subnet.CIDR = network.CIDR.IPNet.String()
+	subnet.PrefixLen, _ = network.CIDR.Mask.Size()
+	subnet.Network = network.Name
+	if _, err := neutronRanges(subnet, network); err != nil {
+		return api.NeutronSubnet{}, err
+	}
+
+	if latestIPAM.NeutronSubnets == nil {
+		latestIPAM.NeutronSubnets = make(map[string]*api.NeutronSubnet)
+	}
+	latestIPAM.NeutronSubnets[subnet.ID] = &subnet
+	return subnet, ipam.save(latestIPAM, ch)
+}
+
+// UpdateNeutronSubnet updates gateway and allocation pools of the
+// subnet, addresses allocated already are kept.
+func (ipam *IPAM) UpdateNeutronSubnet(subnet api.NeutronSubnet) (api.NeutronSubnet, error) {
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return api.NeutronSubnet{}, err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return api.NeutronSubnet{}, err
+	}
+
+	existing, network, err := latestIPAM.neutronSubnet(subnet.ID)
+	if err != nil {
+		return api.NeutronSubnet{}, err
+	}
+	if subnet.CIDR != "" && subnet.CIDR != existing.CIDR {
+		return api.NeutronSubnet{}, common.NewError400(fmt.Sprintf("CIDR of subnet %s can't be changed", subnet.ID))
+	}
+	updated := *existing
+	updated.GatewayIP = subnet.GatewayIP
+	updated.AllocationPools = subnet.AllocationPools
+	if _, err := neutronRanges(updated, network); err != nil {
+		return api.NeutronSubnet{}, err
+	}
+
+	latestIPAM.NeutronSubnets[subnet.ID] = &updated
+	return updated, ipam.save(latestIPAM, ch)
+}
+
+// RemoveNeutronSubnet removes the subnet, addresses still
+// allocated in it are deallocated.
+func (ipam *IPAM) RemoveNeutronSubnet(id string) error {
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	_, network, err := latestIPAM.neutronSubnet(id)
+	if err != nil {
+		return err
+	}
+	prefix := neutronAddressName(id, "")
+	for name, ip := range latestIPAM.AddressNameToIP {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		_, owner := network.findIPInfo(ip)
+		if err := network.deallocateIP(ip); err != nil {
+			return err
+		}
+		latestIPAM.removeAddress(name, owner)
+		latestIPAM.AllocationRevision++
+	}
+	delete(latestIPAM.NeutronSubnets, id)
+	return ipam.save(latestIPAM, ch)
+}
+
+// AllocateNeutronAddress allocates the address requested in the subnet
+// on the host of the port. Any address is allocated in allocation pools
+// of the subnet from blocks of the host, as addresses of pods are.
+func (ipam *IPAM) AllocateNeutronAddress(subnetID string, req api.NeutronAddressRequest) (api.NeutronAddressResponse, error) {
+	if req.Host == "" {
+		return api.NeutronAddressResponse{}, common.NewError400("Host of the port required")
+	}
+	var ip net.IP
+	if req.IPAddress != "" {
+		ip = net.ParseIP(req.IPAddress)
+		if ip == nil || ip.To4() == nil {
+			return api.NeutronAddressResponse{}, common.NewError400(fmt.Sprintf("Invalid address %q", req.IPAddress))
+		}
+	} else if req.PortID == "" {
+		return api.NeutronAddressResponse{}, common.NewError400("Port ID required for any address request")
+	}
+
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return api.NeutronAddressResponse{}, err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return api.NeutronAddressResponse{}, err
+	}
+
+	subnet, network, err := latestIPAM.neutronSubnet(subnetID)
+	if err != nil {
+		return api.NeutronAddressResponse{}, err
+	}
+	name := neutronAddressName(subnetID, req.PortID)
+	if req.PortID == "" {
+		name = neutronAddressName(subnetID, ip.String())
+	}
+	if addr, ok := latestIPAM.AddressNameToIP[name]; ok {
+		return api.NeutronAddressResponse{}, errors.NewRomanaExistsErrorWithMessage(
+			fmt.Sprintf("Address with name %s already allocated: %s", name, addr),
+			fmt.Sprintf("Address: %s", name), "IP", "name="+name, "IP="+addr.String())
+	}
+	if err := latestIPAM.checkHostCordoned(req.Host); err != nil {
+		return api.NeutronAddressResponse{}, err
+	}
+	if err := latestIPAM.checkHostCapacity(req.Host); err != nil {
+		return api.NeutronAddressResponse{}, err
+	}
+	if err := latestIPAM.checkTenantQuota(subnet.TenantID); err != nil {
+		return api.NeutronAddressResponse{}, err
+	}
+
+	owner := makeOwner(subnet.TenantID, req.Segment)
+	if ip != nil {
+		if !network.CIDR.ContainsIP(ip) {
+			return api.NeutronAddressResponse{}, common.NewError400(fmt.Sprintf("Address %s is not in subnet %s", ip, subnet.CIDR))
+		}
+		err = network.allocateSpecificIP(ip, req.Host, owner)
+	} else {
+		ip, err = allocateInRanges(*subnet, network, req.Host, owner)
+	}
+	if err != nil {
+		return api.NeutronAddressResponse{}, err
+	}
+
+	latestIPAM.addAddress(name, ip, owner)
+	latestIPAM.AllocationRevision++
+	err = ipam.save(latestIPAM, ch)
+	if err != nil {
+		return api.NeutronAddressResponse{}, err
+	}
+	ipam.events.Eventf(api.EventNormal, "AddressAllocated", api.EventObject{Kind: api.EventKindAddress, Name: name},
+		"Allocated %s on host %s for %s", ip, req.Host, owner)
+	return api.NeutronAddressResponse{SubnetID: subnetID, IPAddress: ip.String(), Name: name}, nil
+}
+
+// allocateInRanges allocates an address on the host in the network
+// in allocation pools of the subnet. Addresses outside of the pools
+// the network allocates first are skipped, and deallocated once one
+// in the pools is found.
+func allocateInRanges(subnet api.NeutronSubnet, network *Network, host string, owner string) (net.IP, error) {
+	ranges, err := neutronRanges(subnet, network)
+	if err != nil {
+		return nil, err
+	}
+
+	var skipped []net.IP
+	defer func() {
+		for _, ip := range skipped {
+			network.deallocateIP(ip)
+		}
+	}()
+	for {
+		ip, err := network.allocateIP(host, owner)
+		if err != nil {
+			return nil, err
+		}
+		if ip == nil {
+			return nil, common.NewError(msgNoAvailableIP)
+		}
+		if inNeutronRanges(ip, ranges, subnet) {
+			return ip, nil
+		}
+		skipped = append(skipped, ip)
+	}
+}
+
+// DeallocateNeutronAddress deallocates the address of the subnet.
+func (ipam *IPAM) DeallocateNeutronAddress(subnetID string, address string) error {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return common.NewError400(fmt.Sprintf("Invalid address %q", address))
+	}
+
+	ch, err := ipam.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer ipam.locker.Unlock()
+
+	latestIPAM := &IPAM{}
+	err = ipam.load(latestIPAM, ch)
+	if err != nil {
+		return err
+	}
+
+	_, network, err := latestIPAM.neutronSubnet(subnetID)
+	if err != nil {
+		return err
+	}
+	prefix := neutronAddressName(subnetID, "")
+	for name, allocated := range latestIPAM.AddressNameToIP {
+		if !strings.HasPrefix(name, prefix) || !allocated.Equal(ip) {
+			continue
+		}
+		_, owner := network.findIPInfo(ip)
+		if err := network.deallocateIP(ip); err != nil {
+			return err
+		}
+		latestIPAM.removeAddress(name, owner)
+		latestIPAM.AllocationRevision++
+		err = ipam.save(latestIPAM, ch)
+		if err != nil {
+			return err
+		}
+		ipam.events.Eventf(api.EventNormal, "AddressDeallocated",
+			api.EventObject{Kind: api.EventKindAddress, Name: name}, "Deallocated %s", ip)
+		return nil
+	}
+	return errors.NewRomanaNotFoundError(fmt.Sprintf("Address %s not allocated in subnet %s", ip, subnetID),
+		"IP", fmt.Sprintf("IP=%s", ip))
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/romana/core/common/api"
+)
+
+func TestNeutron(t *testing.T) {
+	ipam = initIpam(t, "")
+
+	if _, err := ipam.AllocateNeutronSubnet(api.NeutronSubnet{TenantID: "t1"}); err == nil {
+		t.Fatal("Expected subnet without ID to fail")
+	}
+	s1 := api.NeutronSubnet{
+		ID:              "s1",
+		TenantID:        "t1",
+		CIDR:            "10.0.0.0/24",
+		GatewayIP:       "10.0.0.1",
+		AllocationPools: []api.NeutronAllocationPool{{Start: "10.0.0.10", End: "10.0.0.12"}},
+	}
+	subnet, err := ipam.AllocateNeutronSubnet(s1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subnet.Network != "net1" || subnet.PrefixLen != 24 {
+		t.Fatalf("Expected subnet on net1, got %+v", subnet)
+	}
+	if _, err := ipam.AllocateNeutronSubnet(api.NeutronSubnet{ID: "s2", TenantID: "t1", CIDR: "10.0.0.0/24"}); err == nil {
+		t.Fatal("Expected subnet on network of s1 to fail")
+	}
+	subnet, err = ipam.AllocateNeutronSubnet(api.NeutronSubnet{ID: "s2", TenantID: "t1", PrefixLen: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subnet.Network != "net2" || subnet.CIDR != "10.1.0.0/16" {
+		t.Fatalf("Expected subnet on net2, got %+v", subnet)
+	}
+	if _, err := ipam.AllocateNeutronSubnet(api.NeutronSubnet{ID: "s3", TenantID: "t1", PrefixLen: 24}); err == nil {
+		t.Fatal("Expected subnet without available network to fail")
+	}
+
+	// Any addresses are allocated in the pool, specific ones anywhere
+	// in the subnet, addresses skipped are not kept allocated.
+	for i, expected := range []string{"10.0.0.10", "10.0.0.11"} {
+		req := api.NeutronAddressRequest{PortID: fmt.Sprintf("p%d", i), Host: "host1"}
+		resp, err := ipam.AllocateNeutronAddress("s1", req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.IPAddress != expected {
+			t.Fatalf("Expected %s, got %s", expected, resp.IPAddress)
+		}
+	}
+	if _, err := ipam.AllocateNeutronAddress("s1", api.NeutronAddressRequest{IPAddress: "10.0.0.1", Host: "host1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipam.AllocateNeutronAddress("s1", api.NeutronAddressRequest{IPAddress: "10.1.0.1", Host: "host1"}); err == nil {
+		t.Fatal("Expected specific address outside of the subnet to fail")
+	}
+	// The pool is in the block of host1, so host2 can't get any.
+	if _, err := ipam.AllocateNeutronAddress("s1", api.NeutronAddressRequest{PortID: "p2", Host: "host2"}); err == nil {
+		t.Fatal("Expected allocation on host without block in the pool to fail")
+	}
+	if _, err := ipam.AllocateNeutronAddress("s1", api.NeutronAddressRequest{PortID: "p2", Host: "host1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipam.AllocateNeutronAddress("s1", api.NeutronAddressRequest{PortID: "p3", Host: "host1"}); err == nil {
+		t.Fatal("Expected allocation in exhausted pool to fail")
+	}
+	ipam.load(ipam, nil)
+	var names []string
+	for _, addr := range ipam.ListAddresses() {
+		names = append(names, addr.Name+"="+addr.IP.String())
+	}
+	expected := "neutron.s1.10.0.0.1=10.0.0.1 neutron.s1.p0=10.0.0.10 neutron.s1.p1=10.0.0.11 neutron.s1.p2=10.0.0.12"
+	if strings.Join(names, " ") != expected {
+		t.Fatalf("Expected addresses %s, got %s", expected, strings.Join(names, " "))
+	}
+
+	if err := ipam.DeallocateNeutronAddress("s1", "10.0.0.10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.DeallocateNeutronAddress("s1", "10.0.0.10"); err == nil {
+		t.Fatal("Expected deallocation of free address to fail")
+	}
+
+	s1.CIDR = "10.0.1.0/24"
+	if _, err := ipam.UpdateNeutronSubnet(s1); err == nil {
+		t.Fatal("Expected update of subnet CIDR to fail")
+	}
+	s1.CIDR = ""
+	s1.AllocationPools = []api.NeutronAllocationPool{{Start: "10.0.0.30", End: "10.0.0.40"}}
+	if _, err := ipam.UpdateNeutronSubnet(s1); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ipam.AllocateNeutronAddress("s1", api.NeutronAddressRequest{PortID: "p4", Host: "host1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.IPAddress != "10.0.0.30" {
+		t.Fatalf("Expected 10.0.0.30 in updated pool, got %s", resp.IPAddress)
+	}
+
+	ipam.load(ipam, nil)
+	topoReq := api.TopologyUpdateRequest{}
+	if err := json.Unmarshal(loadTestData(t), &topoReq); err != nil {
+		t.Fatal(err)
+	}
+	topoReq.Networks = topoReq.Networks[:1]
+	topoReq.Topologies[0].Networks = []string{"net1"}
+	if err := ipam.UpdateTopology(topoReq, true); err == nil {
+		t.Fatal("Expected topology without network of s2 to fail")
+	}
+
+	ipam.load(ipam, nil)
+	if err := ipam.RemoveNeutronSubnet("s1"); err != nil {
+		t.Fatal(err)
+	}
+	ipam.load(ipam, nil)
+	if addresses := ipam.ListAddresses(); len(addresses) != 0 {
+		t.Fatalf("Expected addresses of s1 deallocated, got %+v", addresses)
+	}
+	if _, err := ipam.GetNeutronSubnet("s1"); err == nil {
+		t.Fatal("Expected s1 removed")
+	}
+	if subnets := ipam.ListNeutronSubnets(); len(subnets) != 1 || subnets[0].ID != "s2" {
+		t.Fatalf("Expected s2 left, got %+v", subnets)
+	}
+}
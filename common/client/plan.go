@@ -0,0 +1,217 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/api/errors"
+)
+
+// PlanUpdateTopology is a dry run of UpdateTopology: it computes
+// the plan of updating current topology to req, including changes
+// to networks and hosts, without persisting anything.
+func (ipam *IPAM) PlanUpdateTopology(req api.TopologyUpdateRequest) (*api.TopologyPlan, error) {
+	if req.Revision != 0 && req.Revision != ipam.TopologyRevision {
+		return nil, errors.NewRomanaConflictError("topology", ipamDataKey, uint64(req.Revision), uint64(ipam.TopologyRevision))
+	}
+
+	plan, err := PlanTopology(req, ipam.ListAddresses())
+	if err != nil {
+		return nil, err
+	}
+	current := getTopologyFromIPAMState(ipam).(*api.TopologyUpdateRequest)
+	plan.Changes = DiffTopology(current, &plan.Topology)
+	return plan, nil
+}
+
+// hostPlacements returns groups hosts of networks are placed in.
+func hostPlacements(ipam *IPAM) []api.HostPlacement {
+	var placements []api.HostPlacement
+	for _, network := range ipam.Networks {
+		if network.Group != nil {
+			placements = appendHostPlacements(placements, network.Name, network.Group)
+		}
+	}
+	sort.Slice(placements, func(i, j int) bool {
+		if placements[i].Network != placements[j].Network {
+			return placements[i].Network < placements[j].Network
+		}
+		return placements[i].Host < placements[j].Host
+	})
+	return placements
+}
+
+func appendHostPlacements(placements []api.HostPlacement, network string, hg *Group) []api.HostPlacement {
+	for _, host := range hg.Hosts {
+		placement := api.HostPlacement{
+			Host:      host.Name,
+			IP:        host.IP,
+			Network:   network,
+			Group:     hg.Name,
+			GroupCIDR: hg.CIDR.String(),
+		}
+		if placement.Group == "" {
+			placement.Group = placement.GroupCIDR
+		}
+		placements = append(placements, placement)
+	}
+	for _, group := range hg.Groups {
+		placements = appendHostPlacements(placements, network, group)
+	}
+	return placements
+}
+
+// topologyFields are fields of networks or hosts by their names.
+type topologyFields map[string]map[string]string
+
+// networkFieldNames are fields of networks compared by diff.
+var networkFieldNames = []string{"cidr", "block_mask", "tenants", "interface", "mtu", "route_table"}
+
+// hostFieldNames are fields of hosts compared by diff, cidr is
+// the CIDR of the group host belongs to.
+var hostFieldNames = []string{"ip", "cidr"}
+
+// DiffTopology returns changes of networks and hosts between
+// topologies, both are expected to be as returned by romana
+// services so that block masks and group CIDRs are computed.
+func DiffTopology(current, planned *api.TopologyUpdateRequest) []api.TopologyChange {
+	oldNetworks, newNetworks := networkFields(current), networkFields(planned)
+	oldHosts, newHosts := hostFields(current), hostFields(planned)
+
+	var changes []api.TopologyChange
+	for _, network := range sortedFieldKeys(oldNetworks, newNetworks) {
+		change := api.TopologyChange{Network: network}
+		changes = append(changes, diffFields(change, oldNetworks[network], newNetworks[network], networkFieldNames)...)
+
+		for _, host := range sortedFieldKeys(oldHosts[network], newHosts[network]) {
+			change.Host = host
+			changes = append(changes, diffFields(change, oldHosts[network][host], newHosts[network][host], hostFieldNames)...)
+		}
+	}
+	return changes
+}
+
+// diffFields returns changes between fields of network or host,
+// nil fields mean it doesn't exist. Added or removed network is
+// shown with its CIDR, host with its IP.
+func diffFields(change api.TopologyChange, before, after map[string]string, names []string) []api.TopologyChange {
+	switch {
+	case before == nil:
+		change.Change = "add"
+		change.Field = names[0]
+		change.New = after[names[0]]
+		return []api.TopologyChange{change}
+	case after == nil:
+		change.Change = "remove"
+		change.Field = names[0]
+		change.Old = before[names[0]]
+		return []api.TopologyChange{change}
+	}
+
+	var changes []api.TopologyChange
+	for _, name := range names {
+		if before[name] != after[name] {
+			change.Change = "modify"
+			change.Field = name
+			change.Old = before[name]
+			change.New = after[name]
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// networkFields returns fields of networks by network name.
+func networkFields(topology *api.TopologyUpdateRequest) topologyFields {
+	networks := make(topologyFields)
+	for _, n := range topology.Networks {
+		tenants := append([]string{}, n.Tenants...)
+		sort.Strings(tenants)
+		networks[n.Name] = map[string]string{
+			"cidr":        n.CIDR,
+			"block_mask":  fmt.Sprintf("%d", n.BlockMask),
+			"tenants":     strings.Join(tenants, ","),
+			"interface":   n.Interface,
+			"mtu":         fmt.Sprintf("%d", n.MTU),
+			"route_table": fmt.Sprintf("%d", n.RouteTable),
+		}
+	}
+	return networks
+}
+
+// hostFields returns fields of hosts by network and host name.
+func hostFields(topology *api.TopologyUpdateRequest) map[string]topologyFields {
+	cidrs := make(map[string]string)
+	for _, n := range topology.Networks {
+		cidrs[n.Name] = n.CIDR
+	}
+
+	networks := make(map[string]topologyFields)
+	for _, t := range topology.Topologies {
+		for _, network := range uniqueStrings(t.Networks) {
+			hosts := make(topologyFields)
+			collectHostFields(hosts, t.Map, cidrs[network])
+			networks[network] = hosts
+		}
+	}
+	return networks
+}
+
+// collectHostFields adds hosts of the groups to hosts, hosts
+// that aren't in a group with CIDR get CIDR of their parent.
+func collectHostFields(hosts topologyFields, groups []api.GroupOrHost, cidr string) {
+	for _, g := range groups {
+		if g.IP != nil {
+			hosts[g.Name] = map[string]string{"ip": g.IP.String(), "cidr": cidr}
+			continue
+		}
+		groupCIDR := g.CIDR
+		if groupCIDR == "" {
+			groupCIDR = cidr
+		}
+		collectHostFields(hosts, g.Groups, groupCIDR)
+	}
+}
+
+func sortedFieldKeys(a, b topologyFields) []string {
+	var keys []string
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func uniqueStrings(values []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
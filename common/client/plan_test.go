@@ -0,0 +1,91 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/api/errors"
+)
+
+func TestPlanUpdateTopology(t *testing.T) {
+	conf := `{"networks": [{"name": "net1", "cidr": "10.0.0.0/16", "block_mask": 28}],
+		"topologies": [{"networks": ["net1"], "map": [
+			{"name": "g1", "groups": [{"name": "host1", "ip": "192.168.0.1"}]},
+			{"name": "g2", "groups": [{"name": "host2", "ip": "192.168.0.2"}]}]}]}`
+	ipam = initIpam(t, conf)
+	if _, err := ipam.AllocateIP("a", "host1", "ten1", "seg1"); err != nil {
+		t.Fatal(err)
+	}
+	ipam.load(ipam, nil)
+	revision := ipam.TopologyRevision
+
+	// host2 moves to g1, host3 is added to g2 and
+	// block mask of net1 changes.
+	var req api.TopologyUpdateRequest
+	err := json.Unmarshal([]byte(conf), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Networks[0].BlockMask = 29
+	req.Topologies[0].Map[0].Groups = append(req.Topologies[0].Map[0].Groups,
+		api.GroupOrHost{Name: "host2", IP: net.ParseIP("192.168.0.2")})
+	req.Topologies[0].Map[1].Groups = []api.GroupOrHost{{Name: "host3", IP: net.ParseIP("192.168.0.3")}}
+	req.Revision = revision
+
+	plan, err := ipam.PlanUpdateTopology(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Conflicts) != 0 {
+		t.Fatalf("Expected no conflicts, got %v", plan.Conflicts)
+	}
+
+	expectedChanges := []api.TopologyChange{
+		{Change: "modify", Network: "net1", Field: "block_mask", Old: "28", New: "29"},
+		{Change: "modify", Network: "net1", Host: "host2", Field: "cidr", Old: "10.0.128.0/17", New: "10.0.0.0/17"},
+		{Change: "add", Network: "net1", Host: "host3", Field: "ip", New: "192.168.0.3"},
+	}
+	if !reflect.DeepEqual(plan.Changes, expectedChanges) {
+		t.Errorf("Expected changes %v, got %v", expectedChanges, plan.Changes)
+	}
+
+	expectedHosts := []api.HostPlacement{
+		{Host: "host1", IP: net.ParseIP("192.168.0.1"), Network: "net1", Group: "g1", GroupCIDR: "10.0.0.0/17"},
+		{Host: "host2", IP: net.ParseIP("192.168.0.2"), Network: "net1", Group: "g1", GroupCIDR: "10.0.0.0/17"},
+		{Host: "host3", IP: net.ParseIP("192.168.0.3"), Network: "net1", Group: "g2", GroupCIDR: "10.0.128.0/17"},
+	}
+	if !reflect.DeepEqual(plan.Hosts, expectedHosts) {
+		t.Errorf("Expected hosts %v, got %v", expectedHosts, plan.Hosts)
+	}
+
+	// Nothing is persisted by planning.
+	ipam.load(ipam, nil)
+	if ipam.TopologyRevision != revision || ipam.Networks["net1"].BlockMask != 28 ||
+		ipam.Networks["net1"].Group.findHostByName("host3") != nil {
+		t.Fatal("Expected IPAM to be unchanged by planning")
+	}
+
+	req.Revision = revision + 1
+	_, err = ipam.PlanUpdateTopology(req)
+	if _, ok := err.(errors.RomanaConflictError); !ok {
+		t.Fatalf("Expected conflict for stale revision, got %T: %v", err, err)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"bytes"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/romana/core/common/api"
+)
+
+// ApplyPolicies stores policies one by one, policies are encoded
+// before any of them is stored. It is not atomic: if storing fails,
+// policies before the failed one stay stored, and agents may see
+// policies change one at a time. Applying the same policies again
+// is safe, results tell for every policy whether it was created,
+// updated or is unchanged.
+func (c *Client) ApplyPolicies(policies []api.Policy) ([]api.PolicyResult, error) {
+	encoded := make([][]byte, len(policies))
+	for i, policy := range policies {
+		b, err := EncodeObject(KindPolicy, policy)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = b
+	}
+
+	results := make([]api.PolicyResult, len(policies))
+	for i, policy := range policies {
+		key := PoliciesPrefix + "/" + policy.ID
+		results[i] = api.PolicyResult{ID: policy.ID, Result: api.PolicyUpdated}
+		current, err := c.Store.Get(key)
+		switch {
+		case err == libkvStore.ErrKeyNotFound:
+			results[i].Result = api.PolicyCreated
+		case err != nil:
+			return nil, err
+		case bytes.Equal(current.Value, encoded[i]):
+			results[i].Result = api.PolicyUnchanged
+			continue
+		}
+		if err := c.Store.PutObject(key, encoded[i]); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
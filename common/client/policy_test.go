@@ -0,0 +1,67 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+)
+
+func TestApplyPolicies(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	c := &Client{Store: store}
+
+	p1 := api.Policy{ID: "p1", Direction: api.PolicyDirectionIngress}
+	p2 := api.Policy{ID: "p2", Direction: api.PolicyDirectionIngress}
+	expectResults := func(results []api.PolicyResult, expect ...string) {
+		if len(results) != len(expect) {
+			t.Fatalf("expected %d results, got %v", len(expect), results)
+		}
+		for i := range expect {
+			if results[i].Result != expect[i] {
+				t.Errorf("expected policy %s %s, got %s", results[i].ID, expect[i], results[i].Result)
+			}
+		}
+	}
+
+	results, err := c.ApplyPolicies([]api.Policy{p1, p2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectResults(results, api.PolicyCreated, api.PolicyCreated)
+
+	p2.Description = "updated"
+	results, err = c.ApplyPolicies([]api.Policy{p1, p2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectResults(results, api.PolicyUnchanged, api.PolicyUpdated)
+
+	kvps, err := store.ListObjects(PoliciesPrefix)
+	if err != nil || len(kvps) != 2 {
+		t.Fatalf("expected 2 stored policies, got %v, %v", kvps, err)
+	}
+	var stored api.Policy
+	if err := DecodeObject(KindPolicy, kvps[1].Value, &stored); err != nil || stored.Description != "updated" {
+		t.Fatalf("expected updated policy p2, got %v, %v", stored, err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/romana/core/common/api/errors"
+)
+
+// Revision of a key is its LastIndex in the store, it changes on
+// every write. Objects are read together with their revision and
+// updated only if the revision didn't change, so that concurrent
+// updates, e.g. by CLI and a controller, don't silently overwrite
+// each other. Conflicts are reported as errors.RomanaConflictError.
+
+// GetWithRevision unmarshals JSON stored under the key into obj
+// and returns revision of the key, libkv ErrKeyNotFound is
+// returned if the key doesn't exist. The key is read bypassing
+// the read cache.
+func (s *Store) GetWithRevision(key string, obj interface{}) (uint64, error) {
+	kvp, err := s.GetLatest(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(kvp.Value, obj); err != nil {
+		return 0, err
+	}
+	return kvp.LastIndex, nil
+}
+
+// UpdateIfRevision stores obj as JSON under the key if the key is
+// still at the revision, zero revision means the key must not exist.
+// Returns new revision of the key.
+func (s *Store) UpdateIfRevision(key string, obj interface{}, revision uint64) (uint64, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return 0, err
+	}
+
+	kvp, err := s.putIfRevision(key, b, revision)
+	if err != nil {
+		if isRevisionConflict(err) {
+			return 0, errors.NewRomanaConflictError("object", key, revision, s.revision(key))
+		}
+		return 0, err
+	}
+	return kvp.LastIndex, nil
+}
+
+// putIfRevision stores value under the key if the key is still at
+// the revision, zero revision means the key must not exist. Returns
+// libkv errors on conflicts.
+func (s *Store) putIfRevision(key string, value []byte, revision uint64) (*libkvStore.KVPair, error) {
+	fullKey := s.getKey(key)
+	b, err := s.encrypt(fullKey, value)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *libkvStore.KVPair
+	if revision != 0 {
+		previous = &libkvStore.KVPair{Key: fullKey, LastIndex: revision}
+	}
+
+	s.readCache().written(fullKey, b, false)
+	var kvp *libkvStore.KVPair
+	err = s.withAuth("atomic_put", fullKey, func(kv KV) error {
+		var err error
+		_, kvp, err = kv.AtomicPut(fullKey, b, previous, nil)
+		return err
+	})
+	if err != nil {
+		s.readCache().discard(fullKey)
+		return nil, err
+	}
+	return kvp, nil
+}
+
+// DeleteIfRevision deletes the key if it is still at the revision.
+func (s *Store) DeleteIfRevision(key string, revision uint64) error {
+	err := s.deleteIfRevision(key, revision)
+	if isRevisionConflict(err) {
+		return errors.NewRomanaConflictError("object", key, revision, s.revision(key))
+	}
+	return err
+}
+
+// deleteIfRevision deletes the key if it is still at the revision.
+// Returns libkv errors on conflicts.
+func (s *Store) deleteIfRevision(key string, revision uint64) error {
+	fullKey := s.getKey(key)
+	previous := &libkvStore.KVPair{Key: fullKey, LastIndex: revision}
+
+	s.readCache().written(fullKey, nil, true)
+	err := s.withAuth("atomic_delete", fullKey, func(kv KV) error {
+		_, err := kv.AtomicDelete(fullKey, previous)
+		return err
+	})
+	if err != nil {
+		s.readCache().discard(fullKey)
+	}
+	return err
+}
+
+// revision returns current revision of the key, 0 if it doesn't
+// exist or can't be read, it is only used to report conflicts.
+func (s *Store) revision(key string) uint64 {
+	kvp, err := s.GetLatest(key)
+	if err != nil {
+		return 0
+	}
+	return kvp.LastIndex
+}
+
+// isRevisionConflict returns true for errors returned by
+// atomic operations when key is not at expected revision.
+func isRevisionConflict(err error) bool {
+	switch err {
+	case libkvStore.ErrKeyModified, libkvStore.ErrKeyExists, libkvStore.ErrKeyNotFound:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"testing"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api/errors"
+)
+
+func TestRevision(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	type object struct {
+		Name string
+	}
+
+	var obj object
+	if _, err := store.GetWithRevision("/objects/a", &obj); err != libkvStore.ErrKeyNotFound {
+		t.Fatalf("expected key not found, got %v", err)
+	}
+
+	first, err := store.UpdateIfRevision("/objects/a", object{Name: "first"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UpdateIfRevision("/objects/a", object{Name: "again"}, 0); !errors.IsConflict(err) {
+		t.Fatalf("expected create of existing object to conflict, got %v", err)
+	}
+
+	revision, err := store.GetWithRevision("/objects/a", &obj)
+	if err != nil || revision != first || obj.Name != "first" {
+		t.Fatalf("unexpected object %+v at revision %d, %v", obj, revision, err)
+	}
+
+	second, err := store.UpdateIfRevision("/objects/a", object{Name: "second"}, first)
+	if err != nil || second <= first {
+		t.Fatalf("expected update to succeed, got revision %d, %v", second, err)
+	}
+
+	// update based on the first revision would overwrite the second one.
+	_, err = store.UpdateIfRevision("/objects/a", object{Name: "stale"}, first)
+	conflict, ok := err.(errors.RomanaConflictError)
+	if !ok || conflict.Expected != first || conflict.Actual != second {
+		t.Fatalf("expected conflict with revision %d, got %v", second, err)
+	}
+
+	if err := store.DeleteIfRevision("/objects/a", first); !errors.IsConflict(err) {
+		t.Fatalf("expected delete at stale revision to conflict, got %v", err)
+	}
+	if err := store.DeleteIfRevision("/objects/a", second); err != nil {
+		t.Fatal(err)
+	}
+}
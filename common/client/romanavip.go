@@ -0,0 +1,92 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/romana/core/common/api"
+
+	log "github.com/romana/rlog"
+)
+
+// WatchRomanaVIPs reports romana VIPs of services by key, as listed
+// by ListRomanaVIPs, first when watch starts and then whenever any
+// of them is added, moved to another node or deleted.
+func (c *Client) WatchRomanaVIPs(stopCh <-chan struct{}) (<-chan map[string]api.ExposedIPSpec, error) {
+	dir := c.Store.Key(RomanaVIPPrefix)
+	changes, err := c.Store.WatchTreeChanges(dir, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan map[string]api.ExposedIPSpec)
+	go func() {
+		defer close(out)
+		vips := make(map[string]api.ExposedIPSpec)
+		for batch := range changes {
+			for _, change := range batch {
+				key := strings.TrimPrefix(normalize(change.Key), normalize(dir)+"/")
+				if change.Value == nil {
+					delete(vips, key)
+					continue
+				}
+				var eip api.ExposedIPSpec
+				if err := json.Unmarshal(change.Value, &eip); err != nil {
+					log.Errorf("Failed to parse romana VIP %s: %s", change.Key, err)
+					continue
+				}
+				vips[key] = eip
+			}
+
+			current := make(map[string]api.ExposedIPSpec, len(vips))
+			for key, eip := range vips {
+				current[key] = eip
+			}
+			select {
+			case out <- current:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// HostVIPs returns /32 networks of activated romana VIPs bound to
+// the host, i.e. with its IP as the node address, sorted.
+func HostVIPs(vips map[string]api.ExposedIPSpec, host api.Host) []net.IPNet {
+	var networks []net.IPNet
+	for key, eip := range vips {
+		nodeIP := net.ParseIP(eip.NodeIPAddress)
+		if !eip.Activated || nodeIP == nil || !nodeIP.Equal(host.IP) {
+			continue
+		}
+		ip := net.ParseIP(eip.RomanaVIP.IP).To4()
+		if ip == nil {
+			log.Errorf("Invalid romana VIP %s of %s", eip.RomanaVIP.IP, key)
+			continue
+		}
+		networks = append(networks, net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)})
+	}
+	sort.Slice(networks, func(i, j int) bool { return bytes.Compare(networks[i].IP, networks[j].IP) < 0 })
+	return networks
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+)
+
+func TestWatchRomanaVIPs(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	c := &Client{Store: store}
+
+	vip := func(ip string, node string) api.ExposedIPSpec {
+		return api.ExposedIPSpec{
+			RomanaVIP:     api.RomanaVIP{IP: ip},
+			NodeIPAddress: node,
+			Activated:     true,
+			Namespace:     "default",
+		}
+	}
+	if err := c.AddRomanaVIP("svc1.default", vip("10.99.0.1", "192.168.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	vips, err := c.WatchRomanaVIPs(stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host1 := api.Host{Name: "host1", IP: net.ParseIP("192.168.0.1")}
+	host2 := api.Host{Name: "host2", IP: net.ParseIP("192.168.0.2")}
+	format := func(networks []net.IPNet) string {
+		var s []string
+		for i := range networks {
+			s = append(s, networks[i].String())
+		}
+		return fmt.Sprint(s)
+	}
+	expectVIPs := func(expect1 string, expect2 string) {
+		select {
+		case current := <-vips:
+			got1 := format(HostVIPs(current, host1))
+			got2 := format(HostVIPs(current, host2))
+			if got1 != expect1 || got2 != expect2 {
+				t.Fatalf("expected VIPs %s of host1 and %s of host2, got %s and %s", expect1, expect2, got1, got2)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for VIPs %s and %s", expect1, expect2)
+		}
+	}
+	expectVIPs("[10.99.0.1/32]", "[]")
+
+	if err := c.AddRomanaVIP("svc2.default", vip("10.99.0.2", "192.168.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	expectVIPs("[10.99.0.1/32]", "[10.99.0.2/32]")
+
+	// Failover of svc1 to host2 withdraws it from host1.
+	if err := c.AddRomanaVIP("svc1.default", vip("10.99.0.1", "192.168.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	expectVIPs("[]", "[10.99.0.1/32 10.99.0.2/32]")
+
+	if err := c.DeleteRomanaVIP("svc2.default"); err != nil {
+		t.Fatal(err)
+	}
+	expectVIPs("[]", "[10.99.0.1/32]")
+}
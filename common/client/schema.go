@@ -0,0 +1,196 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/romana/core/common/log/trace"
+	log "github.com/romana/rlog"
+)
+
+// Objects are stored as JSON with apiVersion field holding version
+// of their schema, objects stored before versioning was introduced
+// have no apiVersion and are of version v1. Objects are upgraded to
+// the current version of their kind when they are read, by upgrades
+// registered with RegisterSchemaUpgrade, and stored with the current
+// version when they are written next time. Objects of a version newer
+// than known, e.g. written by upgraded replicas while others are not
+// upgraded yet, are read as is, so new schema versions should only
+// add fields until all replicas are upgraded.
+
+const (
+	// APIVersionField is the field of stored objects holding their version.
+	APIVersionField = "apiVersion"
+
+	// KindPolicy is kind of policies stored under PoliciesPrefix.
+	KindPolicy = "Policy"
+
+	// KindIPAM is kind of IPAM state, which includes topology.
+	KindIPAM = "IPAM"
+)
+
+// SchemaUpgrade upgrades fields of object from its version to the
+// next one, apiVersion field is updated by UpgradeObject.
+type SchemaUpgrade func(obj map[string]json.RawMessage) error
+
+var (
+	schemasMu sync.RWMutex
+
+	// schemas are upgrades by kind, upgrade at index i
+	// upgrades version i+1 to i+2.
+	schemas = map[string][]SchemaUpgrade{
+		KindPolicy: nil,
+		KindIPAM:   nil,
+	}
+
+	// newerWarned are kinds and versions already reported as newer.
+	newerWarned = make(map[string]bool)
+)
+
+// RegisterSchemaUpgrade registers upgrade of objects of the kind
+// from version from to the next one, which becomes current version
+// of the kind. Upgrades of a kind must be registered in order, it
+// panics otherwise.
+func RegisterSchemaUpgrade(kind string, from int, upgrade SchemaUpgrade) {
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+
+	if current := len(schemas[kind]) + 1; from != current {
+		panic(fmt.Sprintf("client: upgrade of %s from v%d registered, expected upgrade from v%d", kind, from, current))
+	}
+	schemas[kind] = append(schemas[kind], upgrade)
+}
+
+// SchemaVersion returns current version of objects of the kind.
+func SchemaVersion(kind string) int {
+	schemasMu.RLock()
+	defer schemasMu.RUnlock()
+	return len(schemas[kind]) + 1
+}
+
+// EncodeObject returns JSON of obj with apiVersion set to
+// current version of the kind.
+func EncodeObject(kind string, obj interface{}) ([]byte, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("%s is not stored as JSON object: %s", kind, err)
+	}
+	setVersion(fields, SchemaVersion(kind))
+	return json.Marshal(fields)
+}
+
+// DecodeObject upgrades JSON of object of the kind to the current
+// version and unmarshals it into obj.
+func DecodeObject(kind string, data []byte, obj interface{}) error {
+	upgraded, err := UpgradeObject(kind, data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(upgraded, obj)
+}
+
+// UpgradeObject returns JSON of object of the kind upgraded to the
+// current version with apiVersion set, objects of current or newer
+// version are returned as is.
+func UpgradeObject(kind string, data []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	version, err := objectVersion(fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", kind, err)
+	}
+
+	schemasMu.RLock()
+	upgrades := schemas[kind]
+	schemasMu.RUnlock()
+
+	current := len(upgrades) + 1
+	if version > current {
+		warnNewer(kind, version, current)
+		return data, nil
+	}
+	if _, ok := fields[APIVersionField]; ok && version == current {
+		return data, nil
+	}
+
+	for v := version; v < current; v++ {
+		if err := upgrades[v-1](fields); err != nil {
+			return nil, fmt.Errorf("failed to upgrade %s from v%d: %s", kind, v, err)
+		}
+		log.Tracef(trace.Inside, "Upgraded %s from v%d to v%d", kind, v, v+1)
+	}
+	setVersion(fields, current)
+	return json.Marshal(fields)
+}
+
+// KindOfKey returns kind of object stored under unprefixed key,
+// empty if objects under the key are not versioned.
+func KindOfKey(key string) string {
+	key = normalize(key)
+	switch {
+	case key == ipamDataKey:
+		return KindIPAM
+	case isUnder(key, PoliciesPrefix):
+		return KindPolicy
+	}
+	return ""
+}
+
+func objectVersion(fields map[string]json.RawMessage) (int, error) {
+	raw, ok := fields[APIVersionField]
+	if !ok {
+		return 1, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, fmt.Errorf("invalid %s %s", APIVersionField, raw)
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(s, "v"))
+	if err != nil || !strings.HasPrefix(s, "v") || version < 1 {
+		return 0, fmt.Errorf("invalid %s %q", APIVersionField, s)
+	}
+	return version, nil
+}
+
+func setVersion(fields map[string]json.RawMessage, version int) {
+	fields[APIVersionField] = json.RawMessage(strconv.Quote("v" + strconv.Itoa(version)))
+}
+
+// warnNewer reports object of newer version once per kind and version.
+func warnNewer(kind string, version int, current int) {
+	key := fmt.Sprintf("%s/v%d", kind, version)
+
+	schemasMu.Lock()
+	warned := newerWarned[key]
+	newerWarned[key] = true
+	schemasMu.Unlock()
+
+	if !warned {
+		log.Warnf("Reading %s of version v%d, newer than known v%d, as is", kind, version, current)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSchemaUpgrade(t *testing.T) {
+	const kind = "TestObject"
+
+	type object struct {
+		Name  string `json:"name"`
+		Ports []int  `json:"ports"`
+	}
+
+	// v1 had a single port, v2 has a list of ports.
+	RegisterSchemaUpgrade(kind, 1, func(obj map[string]json.RawMessage) error {
+		if port, ok := obj["port"]; ok {
+			obj["ports"] = json.RawMessage("[" + string(port) + "]")
+			delete(obj, "port")
+		}
+		return nil
+	})
+	if SchemaVersion(kind) != 2 {
+		t.Fatalf("expected version 2, got %d", SchemaVersion(kind))
+	}
+
+	for _, data := range []string{
+		`{"name":"a","port":80}`,
+		`{"apiVersion":"v1","name":"a","port":80}`,
+		`{"apiVersion":"v2","name":"a","ports":[80]}`,
+	} {
+		var obj object
+		if err := DecodeObject(kind, []byte(data), &obj); err != nil {
+			t.Fatalf("failed to decode %s: %s", data, err)
+		}
+		if obj.Name != "a" || len(obj.Ports) != 1 || obj.Ports[0] != 80 {
+			t.Fatalf("unexpected object %+v decoded from %s", obj, data)
+		}
+	}
+
+	upgraded, err := UpgradeObject(kind, []byte(`{"name":"a","port":80}`))
+	if err != nil || string(upgraded) != `{"apiVersion":"v2","name":"a","ports":[80]}` {
+		t.Fatalf("unexpected upgraded object %s, %v", upgraded, err)
+	}
+
+	// objects of newer version are read as is.
+	newer := `{"apiVersion":"v3","name":"a","ports":[80],"weight":1}`
+	if upgraded, err := UpgradeObject(kind, []byte(newer)); err != nil || string(upgraded) != newer {
+		t.Fatalf("expected newer object as is, got %s, %v", upgraded, err)
+	}
+
+	for _, data := range []string{`[]`, `{"apiVersion":"2"}`, `{"apiVersion":"v0"}`, `{"apiVersion":2}`} {
+		if _, err := UpgradeObject(kind, []byte(data)); err == nil {
+			t.Errorf("expected error for %s", data)
+		}
+	}
+
+	b, err := EncodeObject(kind, object{Name: "b", Ports: []int{443}})
+	if err != nil || string(b) != `{"apiVersion":"v2","name":"b","ports":[443]}` {
+		t.Fatalf("unexpected encoded object %s, %v", b, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected upgrade registered out of order to panic")
+		}
+	}()
+	RegisterSchemaUpgrade(kind, 1, func(map[string]json.RawMessage) error { return nil })
+}
+
+func TestKindOfKey(t *testing.T) {
+	for key, expect := range map[string]string{
+		ipamDataKey:            KindIPAM,
+		PoliciesPrefix + "/p1": KindPolicy,
+		PoliciesPrefix:         "",
+		"/hosts/h1":            "",
+	} {
+		if kind := KindOfKey(key); kind != expect {
+			t.Errorf("expected kind of %s to be %q, got %q", key, expect, kind)
+		}
+	}
+}
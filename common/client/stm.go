@@ -0,0 +1,445 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+	log "github.com/romana/rlog"
+)
+
+// Transactions let callers read several keys and write several
+// keys so that either all writes are applied or none are.
+// Backends don't support multi-key transactions, so commit swaps
+// every written key if it is still at the revision the transaction
+// saw, and undoes swaps done so far if one of them fails. Before
+// the swaps, commit stores a record of the transaction under
+// txnPrefix, record left by a crashed committer is completed or
+// rolled back by commits of other clients. Commit fails with a
+// conflict if any key read by the transaction was modified since
+// it was read, in which case the transaction is retried from scratch.
+
+const (
+	// txnMaxAttempts is how many times transaction is attempted
+	// before giving up on conflicts.
+	txnMaxAttempts = 10
+
+	txnPrefix = "/txn"
+
+	// txnRecoverAfter is how old record of a transaction must be
+	// for other clients to consider its committer gone.
+	txnRecoverAfter = time.Minute
+)
+
+// ErrTxnConflict is returned when keys read by transaction kept
+// being modified by others until attempts ran out, or when key
+// given to STM.Expect was modified.
+var ErrTxnConflict = errors.New("transaction conflict")
+
+// STM is a software transactional memory over the store,
+// it buffers writes and tracks revisions of keys read.
+type STM struct {
+	store *Store
+
+	// reads are LastIndex of keys when they were read, 0 for missing keys.
+	reads map[string]uint64
+
+	// expected are keys given to Expect, conflict on them is not retried.
+	expected map[string]bool
+
+	writes map[string]txnWrite
+
+	// committed are keys as written by the commit.
+	committed map[string]*libkvStore.KVPair
+}
+
+// txnWrite is a single write of transaction as stored in its record.
+type txnWrite struct {
+	Key    string `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+
+	// Revision and Previous are the key before the write,
+	// Revision is 0 if the key didn't exist.
+	Revision uint64 `json:"revision,omitempty"`
+	Previous []byte `json:"previous,omitempty"`
+}
+
+// txnRecord is kept under txnPrefix while writes are applied.
+type txnRecord struct {
+	Time   time.Time  `json:"time"`
+	Writes []txnWrite `json:"writes"`
+}
+
+// Get returns value of the key as seen by the transaction,
+// libkv ErrKeyNotFound if the key doesn't exist.
+func (t *STM) Get(key string) ([]byte, error) {
+	key = normalize(key)
+	if w, ok := t.writes[key]; ok {
+		if w.Delete {
+			return nil, libkvStore.ErrKeyNotFound
+		}
+		return w.Value, nil
+	}
+
+	kvp, err := t.store.GetLatest(key)
+	if err == libkvStore.ErrKeyNotFound {
+		t.read(key, 0)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.read(key, kvp.LastIndex)
+	return kvp.Value, nil
+}
+
+// List returns values of keys under the directory by key. Keys
+// listed are tracked as read, keys added to the directory later
+// are not detected as conflict.
+func (t *STM) List(dir string) (map[string][]byte, error) {
+	kvps, err := t.store.listLatest(dir)
+	if err != nil && err != libkvStore.ErrKeyNotFound {
+		return nil, err
+	}
+
+	result := make(map[string][]byte)
+	for _, kvp := range kvps {
+		if kvp == nil || normalize(kvp.Key) == t.store.getKey(dir) {
+			continue
+		}
+		key := t.store.unprefixed(kvp.Key)
+		t.read(key, kvp.LastIndex)
+		result[key] = kvp.Value
+	}
+
+	for key, w := range t.writes {
+		if !isUnder(key, dir) {
+			continue
+		}
+		if w.Delete {
+			delete(result, key)
+		} else {
+			result[key] = w.Value
+		}
+	}
+
+	return result, nil
+}
+
+// Expect makes commit fail with ErrTxnConflict unless the key is
+// still at revision of kvp, nil kvp means the key must not exist.
+func (t *STM) Expect(key string, kvp *libkvStore.KVPair) {
+	key = normalize(key)
+	var index uint64
+	if kvp != nil {
+		index = kvp.LastIndex
+	}
+	t.reads[key] = index
+	t.expected[key] = true
+}
+
+// Put sets value of the key when transaction commits.
+func (t *STM) Put(key string, value []byte) {
+	key = normalize(key)
+	t.writes[key] = txnWrite{Key: key, Value: value}
+}
+
+// Delete deletes the key when transaction commits.
+func (t *STM) Delete(key string) {
+	key = normalize(key)
+	t.writes[key] = txnWrite{Key: key, Delete: true}
+}
+
+// Committed returns the key as written by committed transaction,
+// nil if the key was deleted or not written.
+func (t *STM) Committed(key string) *libkvStore.KVPair {
+	return t.committed[normalize(key)]
+}
+
+func (t *STM) read(key string, index uint64) {
+	if _, ok := t.reads[key]; !ok {
+		t.reads[key] = index
+	}
+}
+
+// Transaction runs apply and commits its writes, apply is run again
+// if keys it read were modified before commit. Error returned by
+// apply aborts the transaction.
+func (s *Store) Transaction(apply func(stm *STM) error) (*STM, error) {
+	for attempt := 1; attempt <= txnMaxAttempts; attempt++ {
+		stm := &STM{
+			store:     s,
+			reads:     make(map[string]uint64),
+			expected:  make(map[string]bool),
+			writes:    make(map[string]txnWrite),
+			committed: make(map[string]*libkvStore.KVPair),
+		}
+
+		if err := apply(stm); err != nil {
+			return nil, err
+		}
+
+		retry, err := s.commit(stm)
+		if err == nil {
+			return stm, nil
+		}
+		if !retry {
+			return nil, err
+		}
+		log.Debugf("Transaction attempt %d failed: %s", attempt, err)
+	}
+
+	return nil, ErrTxnConflict
+}
+
+// commit validates reads and applies writes of the transaction,
+// returns true if transaction should be retried.
+func (s *Store) commit(stm *STM) (bool, error) {
+	if err := s.recoverTransactions(); err != nil {
+		return false, err
+	}
+
+	keys := make([]string, 0, len(stm.reads)+len(stm.writes))
+	for key := range stm.reads {
+		keys = append(keys, key)
+	}
+	for key := range stm.writes {
+		if _, ok := stm.reads[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var record txnRecord
+	for _, key := range keys {
+		var current uint64
+		var value []byte
+		kvp, err := s.GetLatest(key)
+		switch {
+		case err == nil:
+			current = kvp.LastIndex
+			value = kvp.Value
+		case err != libkvStore.ErrKeyNotFound:
+			return false, err
+		}
+		if index, ok := stm.reads[key]; ok && index != current {
+			return !stm.expected[key], ErrTxnConflict
+		}
+
+		w, ok := stm.writes[key]
+		if !ok || (w.Delete && current == 0) {
+			continue
+		}
+		w.Revision = current
+		w.Previous = value
+		record.Writes = append(record.Writes, w)
+	}
+
+	if len(record.Writes) == 0 {
+		return false, nil
+	}
+
+	id, err := newTxnID()
+	if err != nil {
+		return false, err
+	}
+	recordKey := txnPrefix + "/" + id
+	record.Time = time.Now()
+	b, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.putIfRevision(recordKey, b, 0); err != nil {
+		return false, err
+	}
+
+	// from now on, the record lets other clients complete or
+	// roll back the writes if this one crashes.
+	for i, w := range record.Writes {
+		kvp, err := s.applyTxnWrite(w)
+		if isRevisionConflict(err) {
+			// key was modified after it was validated.
+			for _, done := range record.Writes[:i] {
+				if err := s.undoTxnWrite(done, stm.committed[done.Key]); err != nil {
+					return false, err
+				}
+			}
+			s.deleteTxnRecord(recordKey)
+			return true, ErrTxnConflict
+		}
+		if err != nil {
+			return false, err
+		}
+		stm.committed[w.Key] = kvp
+	}
+
+	s.deleteTxnRecord(recordKey)
+	return false, nil
+}
+
+// recoverTransactions completes or rolls back transactions
+// whose committers didn't delete their records in time.
+func (s *Store) recoverTransactions() error {
+	kvps, err := s.listLatest(txnPrefix)
+	if err == libkvStore.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, kvp := range kvps {
+		if kvp == nil || normalize(kvp.Key) == s.getKey(txnPrefix) {
+			continue
+		}
+		var record txnRecord
+		if err := json.Unmarshal(kvp.Value, &record); err != nil {
+			return fmt.Errorf("failed to parse transaction record %s: %s", kvp.Key, err)
+		}
+		if time.Since(record.Time) < txnRecoverAfter {
+			continue
+		}
+		if err := s.recoverTransaction(kvp, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverTransaction applies writes of the record not applied yet,
+// unless some key was modified by others since, in which case
+// writes already applied are undone.
+func (s *Store) recoverTransaction(kvp *libkvStore.KVPair, record txnRecord) error {
+	applied := make([]*libkvStore.KVPair, len(record.Writes))
+	pending := make([]bool, len(record.Writes))
+	rollback := false
+	for i, w := range record.Writes {
+		current, err := s.GetLatest(w.Key)
+		switch {
+		case err == libkvStore.ErrKeyNotFound:
+			if w.Delete {
+				applied[i] = &libkvStore.KVPair{Key: w.Key}
+			} else if w.Revision == 0 {
+				pending[i] = true
+			} else {
+				rollback = true
+			}
+		case err != nil:
+			return err
+		case current.LastIndex == w.Revision:
+			pending[i] = true
+		case !w.Delete && bytes.Equal(current.Value, w.Value):
+			applied[i] = current
+		default:
+			rollback = true
+		}
+	}
+
+	if rollback {
+		log.Infof("Rolling back interrupted transaction %s", kvp.Key)
+	} else {
+		log.Infof("Completing interrupted transaction %s", kvp.Key)
+	}
+	for i, w := range record.Writes {
+		var err error
+		switch {
+		case rollback && applied[i] != nil:
+			err = s.undoTxnWrite(w, applied[i])
+		case !rollback && pending[i]:
+			_, err = s.applyTxnWrite(w)
+		}
+		if isRevisionConflict(err) {
+			// another client is recovering the transaction.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.deleteIfRevision(s.unprefixed(kvp.Key), kvp.LastIndex)
+	if err != nil && !isRevisionConflict(err) {
+		return err
+	}
+	return nil
+}
+
+// applyTxnWrite swaps the key if it is still at revision of the write.
+func (s *Store) applyTxnWrite(w txnWrite) (*libkvStore.KVPair, error) {
+	if w.Delete {
+		return nil, s.deleteIfRevision(w.Key, w.Revision)
+	}
+	return s.putIfRevision(w.Key, w.Value, w.Revision)
+}
+
+// undoTxnWrite restores the key to its value before the write,
+// written is the key as left by the write, nil if it was deleted.
+func (s *Store) undoTxnWrite(w txnWrite, written *libkvStore.KVPair) error {
+	var err error
+	switch {
+	case w.Delete:
+		_, err = s.putIfRevision(w.Key, w.Previous, 0)
+	case w.Revision == 0:
+		err = s.deleteIfRevision(w.Key, written.LastIndex)
+	default:
+		_, err = s.putIfRevision(w.Key, w.Previous, written.LastIndex)
+	}
+	if isRevisionConflict(err) {
+		log.Errorf("Failed to undo transaction write to %s, it was modified by another client", w.Key)
+		return nil
+	}
+	return err
+}
+
+// deleteTxnRecord deletes record of transaction whose writes
+// were applied or undone, record left behind is recovered later.
+func (s *Store) deleteTxnRecord(key string) {
+	if _, err := s.Delete(key); err != nil {
+		log.Errorf("Failed to delete transaction record %s: %s", key, err)
+	}
+}
+
+// listLatest lists keys under the directory bypassing the read cache.
+func (s *Store) listLatest(dir string) ([]*libkvStore.KVPair, error) {
+	var kvps []*libkvStore.KVPair
+	err := s.withAuth("list", s.getKey(dir), func(kv KV) error {
+		var err error
+		kvps, err = kv.List(s.getKey(dir))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptPairs(kvps)
+}
+
+func newTxnID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
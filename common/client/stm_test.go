@@ -0,0 +1,228 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/romana/core/common"
+)
+
+// racingKV runs race before atomic put of the key,
+// e.g. to modify the key after transaction validated it.
+type racingKV struct {
+	*MemoryKV
+	key  string
+	race func()
+}
+
+func (r *racingKV) AtomicPut(key string, value []byte, previous *libkvStore.KVPair, options *libkvStore.WriteOptions) (bool, *libkvStore.KVPair, error) {
+	if key == r.key && r.race != nil {
+		race := r.race
+		r.race = nil
+		race()
+	}
+	return r.MemoryKV.AtomicPut(key, value, previous, options)
+}
+
+func TestTransaction(t *testing.T) {
+	store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.PutObject("/counter", []byte("1"))
+
+	t.Run("retry on conflict", func(t *testing.T) {
+		attempts := 0
+		stm, err := store.Transaction(func(stm *STM) error {
+			attempts++
+			value, err := stm.Get("/counter")
+			if err != nil {
+				return err
+			}
+			if attempts == 1 {
+				// concurrent writer changes key read by transaction.
+				store.PutObject("/counter", []byte("2"))
+			}
+			stm.Put("/counter", append(value, '0'))
+			stm.Put("/index/counter", value)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts != 2 {
+			t.Fatalf("expected 2 attempts, got %d", attempts)
+		}
+
+		counter, _ := store.GetString("/counter", "")
+		index, _ := store.GetString("/index/counter", "")
+		if counter != "20" || index != "2" {
+			t.Fatalf("unexpected values counter=%s index=%s", counter, index)
+		}
+		if kvp := stm.Committed("/counter"); kvp == nil || kvp.LastIndex != store.revision("/counter") {
+			t.Fatalf("unexpected committed value %v", kvp)
+		}
+	})
+
+	t.Run("expect", func(t *testing.T) {
+		attempts := 0
+		_, err := store.Transaction(func(stm *STM) error {
+			attempts++
+			stm.Expect("/counter", &libkvStore.KVPair{LastIndex: 1})
+			stm.Put("/counter", []byte("3"))
+			return nil
+		})
+		if err != ErrTxnConflict || attempts != 1 {
+			t.Fatalf("expected conflict without retries, got %v after %d attempts", err, attempts)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		_, err := store.Transaction(func(stm *STM) error {
+			stm.Put("/index/other", []byte("x"))
+			stm.Delete("/index/counter")
+			values, err := stm.List("/index")
+			if err != nil {
+				return err
+			}
+			if len(values) != 1 || string(values["/index/other"]) != "x" {
+				t.Errorf("unexpected values %v", values)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := store.Exists("/index/counter"); ok {
+			t.Fatal("expected /index/counter to be deleted")
+		}
+	})
+}
+
+func TestTransactionUndo(t *testing.T) {
+	kv := &racingKV{MemoryKV: NewMemoryKV(), key: "/romanaTest/b"}
+	store, err := NewStoreWithKV(&common.Config{EtcdPrefix: "/romanaTest"}, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.PutObject("/a", []byte("a0"))
+	store.PutObject("/b", []byte("b0"))
+	kv.race = func() {
+		// /b is modified after validation, when /a was already swapped.
+		kv.MemoryKV.Put("/romanaTest/b", []byte("b1"), nil)
+	}
+
+	var seen []string
+	_, err = store.Transaction(func(stm *STM) error {
+		a, err := stm.Get("/a")
+		if err != nil {
+			return err
+		}
+		b, err := stm.Get("/b")
+		if err != nil {
+			return err
+		}
+		seen = append(seen, string(a)+","+string(b))
+		stm.Put("/a", append(b, 'a'))
+		stm.Put("/b", append(a, 'b'))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// second attempt must see /a undone.
+	if len(seen) != 2 || seen[1] != "a0,b1" {
+		t.Fatalf("unexpected attempts %v", seen)
+	}
+	a, _ := store.GetString("/a", "")
+	b, _ := store.GetString("/b", "")
+	if a != "b1a" || b != "a0b" {
+		t.Fatalf("unexpected values a=%s b=%s", a, b)
+	}
+	if records, _ := store.ListTree(txnPrefix); len(records) != 0 {
+		t.Fatalf("expected transaction records to be deleted, got %v", records)
+	}
+}
+
+func TestRecoverTransaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		modified bool
+		a, b     string
+	}{
+		{name: "complete", a: "a1", b: "b1"},
+		{name: "roll back", modified: true, a: "a0", b: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewStore(&common.Config{Backend: BackendMemory, EtcdPrefix: "/romanaTest"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.PutObject("/a", []byte("a0"))
+			store.PutObject("/b", []byte("b0"))
+			a0, b0 := store.revision("/a"), store.revision("/b")
+
+			// committer crashed after it swapped /a.
+			store.PutObject("/a", []byte("a1"))
+			if tt.modified {
+				store.PutObject("/b", []byte("other"))
+			}
+			record := txnRecord{
+				Time: time.Now().Add(-2 * txnRecoverAfter),
+				Writes: []txnWrite{
+					{Key: "/a", Value: []byte("a1"), Revision: a0, Previous: []byte("a0")},
+					{Key: "/b", Value: []byte("b1"), Revision: b0, Previous: []byte("b0")},
+				},
+			}
+			recordJSON, _ := json.Marshal(record)
+			store.PutObject(txnPrefix+"/crashed", recordJSON)
+			fresh := record
+			fresh.Time = time.Now()
+			freshJSON, _ := json.Marshal(fresh)
+			store.PutObject(txnPrefix+"/running", freshJSON)
+
+			_, err = store.Transaction(func(stm *STM) error {
+				stm.Put("/c", []byte("c"))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a, _ := store.GetString("/a", "")
+			b, _ := store.GetString("/b", "")
+			if a != tt.a || b != tt.b {
+				t.Fatalf("expected a=%s b=%s, got a=%s b=%s", tt.a, tt.b, a, b)
+			}
+			records, _ := store.ListTree(txnPrefix)
+			if _, ok := records[txnPrefix+"/crashed"]; ok {
+				t.Fatal("expected record of crashed transaction to be deleted")
+			}
+			if _, ok := records[txnPrefix+"/running"]; !ok {
+				t.Fatal("expected record of running transaction to be kept")
+			}
+		})
+	}
+}
@@ -17,8 +17,14 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,34 +32,76 @@ import (
 
 	"github.com/docker/libkv"
 	libkvStore "github.com/docker/libkv/store"
+	libkvConsul "github.com/docker/libkv/store/consul"
 	libkvEtcd "github.com/docker/libkv/store/etcd"
 	"github.com/romana/core/common"
 	"github.com/romana/core/common/log/trace"
 	log "github.com/romana/rlog"
 )
 
+// KV is a subset of libkv store operations used by Store,
+// implemented by libkv stores and by in-memory store.
+type KV interface {
+	Put(key string, value []byte, options *libkvStore.WriteOptions) error
+	Get(key string) (*libkvStore.KVPair, error)
+	Delete(key string) error
+	Exists(key string) (bool, error)
+	Watch(key string, stopCh <-chan struct{}) (<-chan *libkvStore.KVPair, error)
+	WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*libkvStore.KVPair, error)
+	NewLock(key string, options *libkvStore.LockOptions) (libkvStore.Locker, error)
+	List(directory string) ([]*libkvStore.KVPair, error)
+	AtomicPut(key string, value []byte, previous *libkvStore.KVPair, options *libkvStore.WriteOptions) (bool, *libkvStore.KVPair, error)
+	AtomicDelete(key string, previous *libkvStore.KVPair) (bool, error)
+	Close()
+}
+
 // Store is a structure storing information specific to KV-based
 // implementation of Store.
 type Store struct {
 	prefix string
+
+	// Store is libkv store of etcd and consul backends,
+	// it is nil for in-memory backend.
 	libkvStore.Store
 	//	etcdCli *clientv3.Client
+
+	// backend is used by wrapper methods below.
+	backend KV
+
+	config *common.Config
+
+	// cache is nil unless read cache is enabled.
+	cache *readCache
+
+	// encryptor is nil unless encryption is configured.
+	encryptor *encryptor
+
+	// mu guards replacing of libkv store on re-authentication,
+	// wrapper methods below use the store through kv(), methods
+	// of embedded libkv store called directly don't re-authenticate.
+	mu sync.RWMutex
 }
 
-func NewStore(etcdEndpoints []string, prefix string) (*Store, error) {
+// NewStore connects to the store backend using endpoints,
+// prefix, TLS and authentication settings of the config.
+func NewStore(config *common.Config) (*Store, error) {
 	var err error
 
-	myStore := &Store{prefix: prefix}
+	if config.Backend == BackendMemory {
+		return NewStoreWithKV(config, NewMemoryKV())
+	}
 
-	myStore.Store, err = libkv.NewStore(
-		libkvStore.ETCD,
-		etcdEndpoints,
-		&libkvStore.Config{},
-	)
+	myStore := &Store{prefix: config.EtcdPrefix, config: config}
+	myStore.encryptor, err = newEncryptor(config.Encryption)
+	if err != nil {
+		return nil, err
+	}
 
+	myStore.Store, err = connect(config)
 	if err != nil {
 		return nil, err
 	}
+	myStore.backend = wrapKV(myStore.Store, config)
 
 	// BEGIN EXPERIMENT...
 	//	myStore.etcdCli, err := clientv3.New(clientv3.Config{
@@ -73,6 +121,199 @@ func NewStore(etcdEndpoints []string, prefix string) (*Store, error) {
 	return myStore, nil
 }
 
+// NewStoreWithKV returns store keeping data in kv under prefix of
+// the config, e.g. MemoryKV shared by stores of several clients.
+func NewStoreWithKV(config *common.Config, kv KV) (*Store, error) {
+	encryptor, err := newEncryptor(config.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{prefix: config.EtcdPrefix, config: config, backend: wrapKV(kv, config), encryptor: encryptor}, nil
+}
+
+// connect creates libkv store for the configured backend,
+// reading etcd password from file if configured.
+func connect(config *common.Config) (libkvStore.Store, error) {
+	var err error
+	options := &libkvStore.Config{}
+
+	var backend libkvStore.Backend
+	switch config.Backend {
+	case "", BackendEtcd:
+		backend = libkvStore.ETCD
+	case BackendConsul:
+		backend = libkvStore.CONSUL
+		if config.EtcdAuth.IsEnabled() {
+			return nil, fmt.Errorf("user authentication is only supported with %s backend", BackendEtcd)
+		}
+	default:
+		return nil, fmt.Errorf("unknown store backend %s", config.Backend)
+	}
+
+	if config.EtcdTLS.IsEnabled() {
+		options.TLS, err = MakeTLSConfig(config.EtcdTLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.EtcdAuth.IsEnabled() {
+		options.Username = config.EtcdAuth.Username
+		options.Password = config.EtcdAuth.Password
+		if config.EtcdAuth.PasswordFile != "" {
+			password, err := ioutil.ReadFile(config.EtcdAuth.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read etcd password file: %s", err)
+			}
+			options.Password = strings.TrimSpace(string(password))
+		}
+	}
+
+	return libkv.NewStore(
+		backend,
+		config.EtcdEndpoints,
+		options,
+	)
+}
+
+// IsEtcd returns true if data is stored in etcd, extended
+// methods of libkv store (GetExt, WatchExt, WatchTreeExt)
+// are only available with etcd.
+func (s *Store) IsEtcd() bool {
+	return s.config.Backend == "" || s.config.Backend == BackendEtcd
+}
+
+// Ping returns error if the store can't be reached.
+func (s *Store) Ping() error {
+	_, err := s.Exists(ipamKey)
+	return err
+}
+
+// kv returns current store backend.
+func (s *Store) kv() KV {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.backend
+}
+
+// Close closes connection to the store backend.
+func (s *Store) Close() {
+	s.kv().Close()
+}
+
+// reauthenticate replaces libkv store with a new one created with
+// current credentials, e.g. after password in the file was rotated.
+func (s *Store) reauthenticate(failed KV) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// another goroutine already did it.
+	if s.backend != failed {
+		return nil
+	}
+
+	kv, err := connect(s.config)
+	if err != nil {
+		return err
+	}
+	s.Store = kv
+	s.backend = wrapKV(kv, s.config)
+	log.Infof("Re-authenticated to etcd as %s", s.config.EtcdAuth.Username)
+	return nil
+}
+
+// withAuth runs op named name on the key and, if etcd rejected
+// credentials, re-authenticates and runs op once again.
+func (s *Store) withAuth(name string, key string, op func(kv KV) error) (err error) {
+	start := time.Now()
+	defer func() {
+		s.observeOp(name, key, start, opOutcome(err))
+	}()
+
+	kv := s.kv()
+	err = op(kv)
+	if !s.config.EtcdAuth.IsEnabled() || !isAuthError(err) {
+		return err
+	}
+
+	log.Errorf("etcd rejected credentials of %s, re-authenticating: %s", s.config.EtcdAuth.Username, err)
+	if authErr := s.reauthenticate(kv); authErr != nil {
+		log.Errorf("Failed to re-authenticate to etcd: %s", authErr)
+		return err
+	}
+	err = op(s.kv())
+	return err
+}
+
+// isAuthError returns true for errors etcd returns when
+// credentials are missing, wrong or expired.
+func isAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"insufficient credentials", "requires user authentication",
+		"authentication failed", "invalid auth token", "permission denied"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// MakeTLSConfig loads certificates for etcd connection, e.g. of
+// romana store or of another etcd cluster a component writes to.
+func MakeTLSConfig(conf common.EtcdTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         conf.ServerName,
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+	}
+
+	if conf.CAFile != "" {
+		pem, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read etcd CA file: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in etcd CA file %s", conf.CAFile)
+		}
+	}
+
+	if (conf.CertFile == "") != (conf.KeyFile == "") {
+		return nil, fmt.Errorf("both etcd client certificate and key must be specified")
+	}
+	if conf.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load etcd client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// unprefixed returns key without the store prefix.
+func (s *Store) unprefixed(key string) string {
+	prefix := normalize(s.prefix)
+	key = normalize(key)
+	if prefix != "/" && isUnder(key, prefix) {
+		return normalize(key[len(prefix):])
+	}
+	return key
+}
+
+// isUnder returns true if key is under the directory.
+func isUnder(key string, dir string) bool {
+	dir = normalize(dir)
+	if dir == "/" {
+		return true
+	}
+	key = normalize(key)
+	return len(key) > len(dir) && key[:len(dir)] == dir && key[len(dir)] == '/'
+}
+
 func normalize(key string) string {
 	key2 := strings.TrimSpace(key)
 	elts := strings.Split(key2, "/")
@@ -97,6 +338,12 @@ func (s *Store) getKey(key string) string {
 	return normalizedKey
 }
 
+// Key returns key with the store prefix, for use with methods
+// of embedded libkv store that don't add prefix on their own.
+func (s *Store) Key(key string) string {
+	return s.getKey(key)
+}
+
 // BEGIN WRAPPER METHODS
 
 // For now, the wrapper methods (below) just ensure the specified
@@ -104,13 +351,48 @@ func (s *Store) getKey(key string) string {
 // run concurrently). Perhaps other things can be added later.
 
 func (s *Store) Exists(key string) (bool, error) {
-	return s.Store.Exists(s.getKey(key))
+	var exists bool
+	err := s.withAuth("exists", s.getKey(key), func(kv KV) error {
+		var err error
+		exists, err = kv.Exists(s.getKey(key))
+		return err
+	})
+	return exists, err
 }
 
 func (s *Store) PutObject(key string, value []byte) error {
 	key = s.getKey(key)
 	log.Tracef(trace.Inside, "Saving object under key %s: %s", key, string(value))
-	return s.Store.Put(key, value, nil)
+	value, err := s.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	s.readCache().written(key, value, false)
+	err = s.withAuth("put", key, func(kv KV) error {
+		return kv.Put(key, value, nil)
+	})
+	if err != nil {
+		s.readCache().discard(key)
+	}
+	return err
+}
+
+// PutObjectWithTTL stores value under the key which expires after
+// ttl unless it is put again, e.g. to publish liveness of a process.
+func (s *Store) PutObjectWithTTL(key string, value []byte, ttl time.Duration) error {
+	key = s.getKey(key)
+	value, err := s.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	s.readCache().written(key, value, false)
+	err = s.withAuth("put", key, func(kv KV) error {
+		return kv.Put(key, value, &libkvStore.WriteOptions{TTL: ttl})
+	})
+	if err != nil {
+		s.readCache().discard(key)
+	}
+	return err
 }
 
 // Atomizable defines an interface on which it is possible to execute
@@ -126,8 +408,22 @@ func (s *Store) AtomicPut(key string, value Atomizable) error {
 	if err != nil {
 		return err
 	}
+	b, err = s.encrypt(key, b)
+	if err != nil {
+		return err
+	}
 	prevVal := value.GetPrevKVPair()
-	ok, kvp, err := s.Store.AtomicPut(key, b, prevVal, nil)
+	s.readCache().written(key, b, false)
+	var ok bool
+	var kvp *libkvStore.KVPair
+	err = s.withAuth("atomic_put", key, func(kv KV) error {
+		var err error
+		ok, kvp, err = kv.AtomicPut(key, b, prevVal, nil)
+		return err
+	})
+	if err != nil || !ok {
+		s.readCache().discard(key)
+	}
 	if err != nil {
 		return err
 	}
@@ -144,11 +440,36 @@ func (s *Store) AtomicPut(key string, value Atomizable) error {
 }
 
 func (s *Store) Get(key string) (*libkvStore.KVPair, error) {
-	return s.Store.Get(s.getKey(key))
+	start := time.Now()
+	kvp, err, ok := s.readCache().get(s.getKey(key))
+	if !ok {
+		return s.GetLatest(key)
+	}
+	s.observeOp("get", s.getKey(key), start, outcomeCached)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptPair(kvp)
+}
+
+// GetLatest reads the key from the store bypassing the read cache,
+// it must be used when revision of the key is compared on write,
+// since cached revision may lag behind writes of other clients.
+func (s *Store) GetLatest(key string) (*libkvStore.KVPair, error) {
+	var kvp *libkvStore.KVPair
+	err := s.withAuth("get", s.getKey(key), func(kv KV) error {
+		var err error
+		kvp, err = kv.Get(s.getKey(key))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptPair(kvp)
 }
 
 func (s *Store) GetBool(key string, defaultValue bool) (bool, error) {
-	kvp, err := s.Store.Get(s.getKey(key))
+	kvp, err := s.Get(key)
 	if err != nil {
 		if err == libkvStore.ErrKeyNotFound {
 			return defaultValue, nil
@@ -159,15 +480,43 @@ func (s *Store) GetBool(key string, defaultValue bool) (bool, error) {
 }
 
 func (s *Store) ListObjects(key string) ([]*libkvStore.KVPair, error) {
-	kvps, err := s.Store.List(s.getKey(key))
+	start := time.Now()
+	kvps, err, ok := s.readCache().list(s.getKey(key))
+	if ok {
+		s.observeOp("list", s.getKey(key), start, outcomeCached)
+	} else {
+		err = s.withAuth("list", s.getKey(key), func(kv KV) error {
+			var err error
+			kvps, err = kv.List(s.getKey(key))
+			return err
+		})
+	}
 	if err != nil {
 		return nil, err
 	}
-	return kvps, nil
+	return s.decryptPairs(kvps)
+}
+
+// ListTree returns values of all keys under the directory by key,
+// keys are returned without the store prefix.
+func (s *Store) ListTree(dir string) (map[string][]byte, error) {
+	kvps, err := s.ListObjects(dir)
+	if err != nil && err != libkvStore.ErrKeyNotFound {
+		return nil, err
+	}
+
+	values := make(map[string][]byte)
+	for _, kvp := range kvps {
+		if kvp == nil || normalize(kvp.Key) == s.getKey(dir) {
+			continue
+		}
+		values[s.unprefixed(kvp.Key)] = kvp.Value
+	}
+	return values, nil
 }
 
 func (s *Store) GetObject(key string) (*libkvStore.KVPair, error) {
-	kvp, err := s.Store.Get(s.getKey(key))
+	kvp, err := s.Get(key)
 	if err != nil {
 		if err == libkvStore.ErrKeyNotFound {
 			return nil, nil
@@ -178,7 +527,7 @@ func (s *Store) GetObject(key string) (*libkvStore.KVPair, error) {
 }
 
 func (s *Store) GetString(key string, defaultValue string) (string, error) {
-	kvp, err := s.Store.Get(s.getKey(key))
+	kvp, err := s.Get(key)
 	if err != nil {
 		if err == libkvStore.ErrKeyNotFound {
 			return defaultValue, nil
@@ -189,7 +538,7 @@ func (s *Store) GetString(key string, defaultValue string) (string, error) {
 }
 
 func (s *Store) GetInt(key string, defaultValue int) (int, error) {
-	kvp, err := s.Store.Get(s.getKey(key))
+	kvp, err := s.Get(key)
 	if err != nil {
 		if err == libkvStore.ErrKeyNotFound {
 			return defaultValue, nil
@@ -206,10 +555,14 @@ func (s *Store) GetInt(key string, defaultValue int) (int, error) {
 // - false and no error if deletion failed because key was not found
 // - false and error if another error occurred
 func (s *Store) Delete(key string) (bool, error) {
-	err := s.Store.Delete(s.getKey(key))
+	s.readCache().written(s.getKey(key), nil, true)
+	err := s.withAuth("delete", s.getKey(key), func(kv KV) error {
+		return kv.Delete(s.getKey(key))
+	})
 	if err == nil {
 		return true, nil
 	}
+	s.readCache().discard(s.getKey(key))
 	if err == libkvStore.ErrKeyNotFound {
 		return false, nil
 	}
@@ -222,7 +575,7 @@ func (s *Store) Delete(key string) (bool, error) {
 // the watch if it drop.
 func (s *Store) ReconnectingWatch(key string, stopCh <-chan struct{}) (<-chan *libkvStore.KVPair, error) {
 	outCh := make(chan *libkvStore.KVPair)
-	inCh, err := s.Watch(s.getKey(key), stopCh)
+	inCh, err := s.kv().Watch(s.getKey(key), stopCh)
 	if err != nil {
 		return nil, err
 	}
@@ -233,8 +586,7 @@ func (s *Store) ReconnectingWatch(key string, stopCh <-chan struct{}) (<-chan *l
 func (s *Store) reconnectingWatcher(key string, stopCh <-chan struct{}, inCh <-chan *libkvStore.KVPair, outCh chan *libkvStore.KVPair) {
 	var err error
 	log.Tracef(trace.Private, "Entering ReconnectingWatch goroutine: %d", getGID())
-	channelClosed := false
-	retryDelay := 1 * time.Millisecond
+	backoff := Backoff{Initial: watchReconnectInitialDelay, Max: watchReconnectMaxDelay}
 	for {
 		select {
 		case <-stopCh:
@@ -242,33 +594,137 @@ func (s *Store) reconnectingWatcher(key string, stopCh <-chan struct{}, inCh <-c
 			return
 		case kv, ok := <-inCh:
 			if ok {
-				channelClosed = false
-				outCh <- kv
+				// Watch delivers events again, so the next
+				// reconnect starts with the initial delay.
+				backoff.Reset()
+				kv, err = s.decryptPair(kv)
+				if err != nil {
+					log.Errorf("ReconnectingWatch: %s", err)
+					break
+				}
+				select {
+				case outCh <- kv:
+				case <-stopCh:
+					return
+				}
 				break
 			}
-			// Not ok - channel continues to be closed
-
-			if channelClosed {
-				// We got here because we attempted to re-create
-				// a watch but it came back with a closed channel again.
-				// So we should increase the retry
-				retryDelay *= 2
-			} else {
-				channelClosed = true
-				retryDelay = 1 * time.Millisecond
-			}
+			// Not ok - channel is closed, re-create the watch
+			// with increasing delay until it succeeds.
 			log.Infof("ReconnectingWatch: Lost watch on %s, trying to re-establish...", key)
 			for {
-				inCh, err = s.Watch(s.getKey(key), stopCh)
+				select {
+				case <-stopCh:
+					return
+				case <-time.After(backoff.Next()):
+				}
+
+				inCh, err = s.kv().Watch(s.getKey(key), stopCh)
+				if err == nil {
+					break
+				}
+				log.Errorf("ReconnectingWatch: Error reconnecting: %v (%T)", err, err)
+			}
+		}
+	}
+}
+
+const (
+	// watchReconnectInitialDelay and watchReconnectMaxDelay
+	// bound delays between attempts to re-establish a watch.
+	watchReconnectInitialDelay = 100 * time.Millisecond
+	watchReconnectMaxDelay     = 30 * time.Second
+)
+
+// KVChange is a change of a single key, Value is nil
+// when key was deleted and PrevValue is nil when created.
+type KVChange struct {
+	Key       string
+	Value     []byte
+	PrevValue []byte
+}
+
+// WatchTreeChanges watches keys under the directory and reports
+// changes of individual keys, current keys are reported as created
+// first. It is meant for backends that deliver full listings of
+// the directory on every change, such as consul, and reconnects if
+// the watch drops. Key must include prefix, see Key.
+func (s *Store) WatchTreeChanges(dir string, stopCh <-chan struct{}) (<-chan []KVChange, error) {
+	listings, err := s.kv().WatchTree(dir, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan []KVChange)
+	go func() {
+		defer close(out)
+		known := make(map[string][]byte)
+		backoff := Backoff{Initial: watchReconnectInitialDelay, Max: watchReconnectMaxDelay}
+		for {
+			for pairs := range listings {
+				backoff.Reset()
+				pairs, err := s.decryptPairs(pairs)
+				if err != nil {
+					log.Errorf("Failed to decrypt listing of %s: %s", dir, err)
+					continue
+				}
+				var changes []KVChange
+				known, changes = diffListing(known, dir, pairs)
+				if len(changes) == 0 {
+					continue
+				}
+				select {
+				case out <- changes:
+				case <-stopCh:
+					return
+				}
+			}
+
+			// listings closed either because of stopCh
+			// or because watch dropped.
+			for {
+				select {
+				case <-stopCh:
+					return
+				case <-time.After(backoff.Next()):
+				}
+
+				listings, err = s.kv().WatchTree(dir, stopCh)
 				if err == nil {
 					break
-				} else {
-					log.Errorf("ReconnectingWatch: Error reconnecting: %v (%T)", err, err)
-					retryDelay *= 2
 				}
+				log.Errorf("Failed to re-establish watch on %s: %s", dir, err)
 			}
 		}
+	}()
+
+	return out, nil
+}
+
+// diffListing compares listing of the directory with known
+// values, returns new known values and changes sorted by key.
+func diffListing(known map[string][]byte, dir string, pairs []*libkvStore.KVPair) (map[string][]byte, []KVChange) {
+	current := make(map[string][]byte)
+	var changes []KVChange
+
+	for _, pair := range pairs {
+		if pair == nil || normalize(pair.Key) == normalize(dir) {
+			continue
+		}
+		current[pair.Key] = pair.Value
+		if prev, ok := known[pair.Key]; !ok || !bytes.Equal(prev, pair.Value) {
+			changes = append(changes, KVChange{Key: pair.Key, Value: pair.Value, PrevValue: prev})
+		}
 	}
+
+	for key, prev := range known {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, KVChange{Key: key, PrevValue: prev})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return current, changes
 }
 
 // Locker implements an interface for locking and unlocking.
@@ -287,7 +743,7 @@ type Locker interface {
 type storeLocker struct {
 	key   string
 	owner uint64
-	libkvStore.Locker
+	lock  *DistributedLock
 }
 
 // See https://blog.sgmansfield.com/2015/12/goroutine-ids/
@@ -306,8 +762,7 @@ func (sl *storeLocker) GetOwner() uint64 {
 
 // Lock implements Lock method of Locker interface.
 func (sl *storeLocker) Lock() (<-chan struct{}, error) {
-	stopChan := make(chan struct{})
-	ch, err := sl.Locker.Lock(stopChan)
+	ch, err := sl.lock.Lock(context.Background())
 	if err == nil {
 		sl.owner = getGID()
 	} else {
@@ -320,7 +775,7 @@ func (sl *storeLocker) Lock() (<-chan struct{}, error) {
 func (sl *storeLocker) Unlock() {
 	prevOwner := sl.owner
 	sl.owner = 0
-	err := sl.Locker.Unlock()
+	err := sl.lock.Unlock()
 	if err != nil {
 		sl.owner = prevOwner
 		//		switch err := err.(type) {
@@ -336,13 +791,13 @@ func (sl *storeLocker) Unlock() {
 	}
 }
 
+// NewLocker returns Locker backed by DistributedLock with DefaultLockTTL.
 func (store *Store) NewLocker(name string) (Locker, error) {
-	key := store.getKey("/lock/" + name)
-	l, err := store.Store.NewLock(key, nil)
+	l, err := store.NewDistributedLock(name, DefaultLockTTL)
 	if err != nil {
 		return nil, err
 	}
-	return &storeLocker{key: key, Locker: l}, nil
+	return &storeLocker{key: l.key, lock: l}, nil
 }
 
 // mutexLocker implements Locker interface with a sync.Mutex
@@ -377,6 +832,7 @@ func newMutexLocker() *mutexLocker {
 }
 
 func init() {
-	// Register etcd store to libkv
+	// Register etcd and consul stores to libkv
 	libkvEtcd.Register()
+	libkvConsul.Register()
 }
@@ -0,0 +1,113 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/tlstest"
+)
+
+func TestMakeTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romana-etcd-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := tlstest.WriteCertificate(t, dir, "etcd")
+
+	tlsConfig, err := MakeTLSConfig(common.EtcdTLS{
+		CAFile:     certFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "etcd.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.RootCAs == nil || len(tlsConfig.Certificates) != 1 || tlsConfig.ServerName != "etcd.example.com" {
+		t.Fatalf("unexpected tls config %+v", tlsConfig)
+	}
+
+	cases := []struct {
+		name string
+		conf common.EtcdTLS
+	}{
+		{name: "missing CA", conf: common.EtcdTLS{CAFile: filepath.Join(dir, "nope.pem")}},
+		{name: "CA without certificates", conf: common.EtcdTLS{CAFile: keyFile}},
+		{name: "certificate without key", conf: common.EtcdTLS{CertFile: certFile}},
+		{name: "key mismatch", conf: common.EtcdTLS{CertFile: keyFile, KeyFile: keyFile}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := MakeTLSConfig(tc.conf); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	for err, expect := range map[error]bool{
+		nil: false,
+		errors.New(`{"message":"Insufficient credentials"}`):                                       true,
+		errors.New("110: The request requires user authentication (Insufficient credentials) [0]"): true,
+		errors.New("etcdserver: invalid auth token"):                                               true,
+		errors.New("client: etcd cluster is unavailable or misconfigured"):                         false,
+	} {
+		if got := isAuthError(err); got != expect {
+			t.Errorf("expected isAuthError(%v) to be %t", err, expect)
+		}
+	}
+}
+
+func TestDiffListing(t *testing.T) {
+	dir := "/romana/policies"
+	known, changes := diffListing(nil, dir, []*libkvStore.KVPair{
+		{Key: "romana/policies"},
+		{Key: "romana/policies/a", Value: []byte("1")},
+		{Key: "romana/policies/b", Value: []byte("2")},
+	})
+	if len(changes) != 2 || changes[0].Key != "romana/policies/a" || changes[0].PrevValue != nil {
+		t.Fatalf("expected 2 keys to be created, got %+v", changes)
+	}
+
+	_, changes = diffListing(known, dir, []*libkvStore.KVPair{
+		{Key: "romana/policies/a", Value: []byte("1")},
+		{Key: "romana/policies/b", Value: []byte("3")},
+		{Key: "romana/policies/c", Value: []byte("4")},
+	})
+	expect := []KVChange{
+		{Key: "romana/policies/b", Value: []byte("3"), PrevValue: []byte("2")},
+		{Key: "romana/policies/c", Value: []byte("4")},
+	}
+	if !reflect.DeepEqual(changes, expect) {
+		t.Fatalf("expected changes %+v, got %+v", expect, changes)
+	}
+
+	_, changes = diffListing(known, dir, nil)
+	if len(changes) != 2 || changes[1].Value != nil || string(changes[1].PrevValue) != "2" {
+		t.Fatalf("expected 2 keys to be deleted, got %+v", changes)
+	}
+}
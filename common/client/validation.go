@@ -0,0 +1,230 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+)
+
+// topologyValidator collects problems of a TopologyUpdateRequest.
+type topologyValidator struct {
+	errs []api.FieldError
+}
+
+func (v *topologyValidator) addf(path string, text string, args ...interface{}) {
+	v.errs = append(v.errs, api.FieldError{Path: path, Message: fmt.Sprintf(text, args...)})
+}
+
+// validateTopology checks the whole of req before any of it is applied,
+// so that all problems are reported at once instead of the first one
+// setTopology stumbles upon. Returned error is a 400 HttpError with
+// a list of api.FieldError as details, nil if req is valid.
+func validateTopology(req api.TopologyUpdateRequest) error {
+	v := &topologyValidator{}
+
+	cidrs := make(map[string]CIDR)
+	blockMasks := make(map[string]uint)
+	names := make(map[string]int)
+	for i, netDef := range req.Networks {
+		path := fmt.Sprintf("networks[%d]", i)
+		if netDef.Name == "" {
+			v.addf(path+".name", "network name is required")
+		} else if j, ok := names[netDef.Name]; ok {
+			v.addf(path+".name", "Network with name %s already defined in networks[%d]", netDef.Name, j)
+		} else {
+			names[netDef.Name] = i
+		}
+
+		for j, tenantName := range netDef.Tenants {
+			// "*" is how topology read back lists all tenants.
+			if tenantName != "*" && !tenantNameRegexp.MatchString(tenantName) {
+				v.addf(fmt.Sprintf("%s.tenants[%d]", path, j), "Bad tenant name: %s", tenantName)
+			}
+		}
+		if netDef.MTU != 0 && (netDef.MTU < MinMTU || netDef.MTU > MaxMTU) {
+			v.addf(path+".mtu", "invalid mtu(%d) for network(%s), must be %d <= mtu <= %d",
+				netDef.MTU, netDef.Name, MinMTU, MaxMTU)
+		}
+		if netDef.RouteTable != 0 && (netDef.RouteTable < MinRouteTable || netDef.RouteTable > MaxRouteTable) {
+			v.addf(path+".route_table", "invalid route_table(%d) for network(%s), must be %d <= route_table <= %d",
+				netDef.RouteTable, netDef.Name, MinRouteTable, MaxRouteTable)
+		}
+
+		cidr, err := NewCIDR(netDef.CIDR)
+		if err != nil {
+			v.addf(path+".cidr", "%s", err)
+			continue
+		}
+		blockMaskMin, blockMaskMax := cidr.Mask.Size()
+		blockMask := netDef.BlockMask
+		if blockMask == 0 {
+			blockMask = DefaultBlockMask
+			if blockMask < uint(blockMaskMin) {
+				blockMask = uint(blockMaskMin)
+			}
+		}
+		if blockMask < uint(blockMaskMin) || blockMask > uint(blockMaskMax) {
+			v.addf(path+".block_mask", "invalid blockmask(%d) for network(%s), must be %d <= blockmask <= %d",
+				blockMask, netDef.Name, blockMaskMin, blockMaskMax)
+			continue
+		}
+
+		for j := 0; j < i; j++ {
+			other, ok := cidrs[req.Networks[j].Name]
+			if !ok {
+				continue
+			}
+			if cidr.Contains(other) || other.Contains(cidr) {
+				v.addf(path+".cidr", "CIDR %s of network %s overlaps with CIDR %s of network %s (networks[%d])",
+					cidr, netDef.Name, other, req.Networks[j].Name, j)
+			}
+		}
+		if names[netDef.Name] == i {
+			cidrs[netDef.Name] = cidr
+			blockMasks[netDef.Name] = blockMask
+		}
+	}
+
+	topologyOf := make(map[string]int)
+	for i, topoDef := range req.Topologies {
+		path := fmt.Sprintf("topologies[%d]", i)
+		for j, netName := range topoDef.Networks {
+			netPath := fmt.Sprintf("%s.networks[%d]", path, j)
+			if k, ok := topologyOf[netName]; ok {
+				v.addf(netPath, "Network %s appears more than once, already in topologies[%d]", netName, k)
+				continue
+			}
+			topologyOf[netName] = i
+			if _, ok := names[netName]; !ok {
+				v.addf(netPath, "Network with name %s not defined", netName)
+				continue
+			}
+			cidr, ok := cidrs[netName]
+			if !ok {
+				// Network itself is invalid and reported already.
+				continue
+			}
+			ones, _ := cidr.Mask.Size()
+			v.checkGroupSizes(path, path+".map", topoDef.Map, ones, blockMasks[netName], netName)
+		}
+
+		hostNames := make(map[string]string)
+		hostIPs := make(map[string]string)
+		v.checkHosts(path+".map", topoDef.Map, hostNames, hostIPs)
+		v.checkAssignments(path+".map", topoDef.Map, nil)
+	}
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	err := common.NewError400(v.errs)
+	err.Message = fmt.Sprintf("Invalid topology: %d problem(s) found", len(v.errs))
+	return err
+}
+
+// bitsForElements returns how many bits of a CIDR are used to
+// number n groups, which are padded to a power of 2 by parse().
+func bitsForElements(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return big.NewInt(int64(n - 1)).BitLen()
+}
+
+// checkGroupSizes walks groups the way parseMap and parse split CIDR
+// among them and reports groups that end up smaller than a block,
+// as no block could be allocated in them.
+func (v *topologyValidator) checkGroupSizes(path string, arrPath string, arr []api.GroupOrHost, ones int, blockMask uint, netName string) {
+	if len(arr) == 0 || arr[0].IP != nil {
+		if ones > int(blockMask) {
+			v.addf(path, "group CIDR of prefix length %d in network %s is smaller than its block mask %d",
+				ones, netName, blockMask)
+		}
+		return
+	}
+	ones += bitsForElements(len(arr))
+	if ones > 32 {
+		v.addf(arrPath, "%d groups do not fit in network %s", len(arr), netName)
+		return
+	}
+	for i, elt := range arr {
+		eltPath := fmt.Sprintf("%s[%d]", arrPath, i)
+		v.checkGroupSizes(eltPath, eltPath+".groups", elt.Groups, ones, blockMask, netName)
+	}
+}
+
+// checkHosts reports hosts without a name or with next hops, which
+// are set on groups, and host names or IPs that appear more than once
+// in a topology.
+func (v *topologyValidator) checkHosts(path string, arr []api.GroupOrHost, hostNames map[string]string, hostIPs map[string]string) {
+	for i, elt := range arr {
+		eltPath := fmt.Sprintf("%s[%d]", path, i)
+		if elt.IP == nil {
+			v.checkHosts(eltPath+".groups", elt.Groups, hostNames, hostIPs)
+			continue
+		}
+		if elt.Name == "" {
+			v.addf(eltPath+".name", "Both name and IP are required for hosts, host %s has no name", elt.IP)
+		} else if other, ok := hostNames[elt.Name]; ok {
+			v.addf(eltPath+".name", "Host name %s is already used by %s", elt.Name, other)
+		} else {
+			hostNames[elt.Name] = eltPath
+		}
+		if len(elt.NextHops) > 0 {
+			v.addf(eltPath+".next_hops", "Next hops are set on groups, not on host %s", elt.Name)
+		}
+		if elt.Capacity < 0 {
+			v.addf(eltPath+".capacity", "Capacity of host %s must not be negative", elt.Name)
+		}
+		ip := elt.IP.String()
+		if other, ok := hostIPs[ip]; ok {
+			v.addf(eltPath+".ip", "Host IP %s is already used by %s", ip, other)
+		} else {
+			hostIPs[ip] = eltPath
+		}
+	}
+}
+
+// checkAssignments reports groups whose assignment requires a tag value
+// other than an enclosing group does, no host could be assigned to them.
+func (v *topologyValidator) checkAssignments(path string, arr []api.GroupOrHost, inherited map[string]string) {
+	for i, elt := range arr {
+		eltPath := fmt.Sprintf("%s[%d]", path, i)
+		assignment := make(map[string]string)
+		for k, val := range inherited {
+			assignment[k] = val
+		}
+		keys := make([]string, 0, len(elt.Assignment))
+		for k := range elt.Assignment {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			val := elt.Assignment[k]
+			if prev, ok := inherited[k]; ok && prev != val {
+				v.addf(fmt.Sprintf("%s.assignment.%s", eltPath, k),
+					"assignment %s=%s conflicts with %s=%s of enclosing group", k, val, k, prev)
+			}
+			assignment[k] = val
+		}
+		v.checkAssignments(eltPath+".groups", elt.Groups, assignment)
+	}
+}
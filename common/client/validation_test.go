@@ -0,0 +1,171 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+)
+
+func TestValidateTopology(t *testing.T) {
+	testCases := []struct {
+		name     string
+		conf     string
+		expected []string
+	}{
+		{
+			name: "valid",
+			conf: `{"networks": [{"name": "net1", "cidr": "10.0.0.0/16", "block_mask": 28},
+				{"name": "net2", "cidr": "10.1.0.0/16"}],
+				"topologies": [{"networks": ["net1", "net2"], "map": [
+					{"assignment": {"zone": "a"}, "groups": [{"name": "h1", "ip": "192.168.0.1"}]},
+					{"assignment": {"zone": "b"}, "groups": [{"name": "h2", "ip": "192.168.0.2"}]}]}]}`,
+		},
+		{
+			name: "networks",
+			conf: `{"networks": [{"name": "net1", "cidr": "10.0.0.0/16", "block_mask": 8},
+				{"name": "net2", "cidr": "10.0.1.0/24"},
+				{"name": "net1", "cidr": "10.2.0.0/16", "mtu": 10},
+				{"name": "net3", "cidr": "10.3.0.0/33", "tenants": ["a b"]}],
+				"topologies": [{"networks": ["net2", "net4"], "map": []}, {"networks": ["net2"], "map": []}]}`,
+			expected: []string{
+				"networks[0].block_mask",
+				"networks[2].name",
+				"networks[2].mtu",
+				"networks[3].tenants[0]",
+				"networks[3].cidr",
+				"topologies[0].networks[1]",
+				"topologies[1].networks[0]",
+			},
+		},
+		{
+			name: "overlapping networks",
+			conf: `{"networks": [{"name": "net1", "cidr": "10.0.0.0/16"},
+				{"name": "net2", "cidr": "10.0.1.0/24"},
+				{"name": "net3", "cidr": "10.0.0.0/8"}],
+				"topologies": []}`,
+			expected: []string{
+				"networks[1].cidr",
+				"networks[2].cidr",
+				"networks[2].cidr",
+			},
+		},
+		{
+			name: "groups do not fit",
+			conf: `{"networks": [{"name": "net1", "cidr": "10.0.0.0/24", "block_mask": 26}],
+				"topologies": [{"networks": ["net1"], "map": [
+					{"groups": [{"groups": []}, {"groups": []}]},
+					{"groups": []},
+					{"groups": [{"name": "h1", "ip": "192.168.0.1"}]}]}]}`,
+			expected: []string{
+				"topologies[0].map[0].groups[0]",
+				"topologies[0].map[0].groups[1]",
+			},
+		},
+		{
+			name: "hosts",
+			conf: `{"networks": [{"name": "net1", "cidr": "10.0.0.0/16"}],
+				"topologies": [{"networks": ["net1"], "map": [
+					{"groups": [{"name": "h1", "ip": "192.168.0.1"}, {"name": "h2", "ip": "192.168.0.1"}]},
+					{"groups": [{"name": "h1", "ip": "192.168.0.3"}, {"ip": "192.168.0.4"}]},
+					{"next_hops": ["192.168.1.1"], "groups": [{"name": "h5", "ip": "192.168.0.5", "next_hops": ["192.168.1.2"]}]}]}]}`,
+			expected: []string{
+				"topologies[0].map[0].groups[1].ip",
+				"topologies[0].map[1].groups[0].name",
+				"topologies[0].map[1].groups[1].name",
+				"topologies[0].map[2].groups[0].next_hops",
+			},
+		},
+		{
+			name: "assignment",
+			conf: `{"networks": [{"name": "net1", "cidr": "10.0.0.0/16"}],
+				"topologies": [{"networks": ["net1"], "map": [
+					{"assignment": {"zone": "a"}, "groups": [
+						{"assignment": {"rack": "1", "zone": "a"}, "groups": []},
+						{"assignment": {"rack": "2", "zone": "b"}, "groups": []}]}]}]}`,
+			expected: []string{
+				"topologies[0].map[0].groups[1].assignment.zone",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var req api.TopologyUpdateRequest
+			err := json.Unmarshal([]byte(tc.conf), &req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = validateTopology(req)
+			if tc.expected == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got %s", err)
+				}
+				return
+			}
+			httpErr, ok := err.(common.HttpError)
+			if !ok {
+				t.Fatalf("expected HttpError, got %T: %v", err, err)
+			}
+			if httpErr.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d", http.StatusBadRequest, httpErr.StatusCode)
+			}
+			var paths []string
+			for _, fieldErr := range httpErr.Details.([]api.FieldError) {
+				paths = append(paths, fieldErr.Path)
+			}
+			if !reflect.DeepEqual(paths, tc.expected) {
+				t.Errorf("expected errors in %v, got %v", tc.expected, httpErr.Details)
+			}
+		})
+	}
+}
+
+func TestUpdateTopologyInvalid(t *testing.T) {
+	ipam, err := NewIPAM(testSaver.save, nil)
+	if err != nil {
+		t.Fatalf("error initializing ipam: %v", err)
+	}
+	ipam.load = testSaver.load
+	initial := api.TopologyUpdateRequest{
+		Networks: []api.NetworkDefinition{{Name: "net1", CIDR: "10.0.0.0/16"}},
+	}
+	err = ipam.UpdateTopology(initial, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// First network is fine, but the second one overlaps it, so
+	// neither should be set.
+	req := api.TopologyUpdateRequest{
+		Networks: []api.NetworkDefinition{
+			{Name: "net2", CIDR: "10.1.0.0/24"},
+			{Name: "net3", CIDR: "10.1.0.0/16"},
+		},
+	}
+	err = ipam.UpdateTopology(req, false)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, ok := ipam.Networks["net1"]; !ok || len(ipam.Networks) != 1 {
+		t.Errorf("expected networks to remain unchanged, got %v", ipam.Networks)
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to
+// compressing responses and answering conditional requests,
+// so that clients polling large lists don't transfer them
+// again and again.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	// HeaderETag identifies contents of a response,
+	// clients send it back in HeaderIfNoneMatch.
+	HeaderETag = "ETag"
+
+	// HeaderIfNoneMatch has ETags of responses the client
+	// has, it gets 304 Not Modified if one is still current.
+	HeaderIfNoneMatch = "If-None-Match"
+
+	// gzipMinSize is the size of the smallest response
+	// compressed, smaller ones gain little.
+	gzipMinSize = 1024
+)
+
+// acceptsGzip returns true if the client accepts
+// gzip content encoding.
+func acceptsGzip(request *http.Request) bool {
+	for _, coding := range strings.Split(request.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(coding, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		rejected := false
+		for _, param := range params[1:] {
+			param = strings.Replace(param, " ", "", -1)
+			if strings.HasPrefix(param, "q=0") && strings.Trim(param[3:], ".0") == "" {
+				rejected = true
+			}
+		}
+		if !rejected {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipMiddleware compresses responses for clients that
+// accept gzip, apart from small ones and event streams.
+type gzipMiddleware struct{}
+
+func newGzipMiddleware() gzipMiddleware {
+	return gzipMiddleware{}
+}
+
+func (m gzipMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	writer.Header().Add("Vary", "Accept-Encoding")
+	if request.Method == http.MethodHead || !acceptsGzip(request) {
+		next(writer, request)
+		return
+	}
+	gzipWriter := &gzipResponseWriter{ResponseWriter: writer}
+	next(gzipWriter, request)
+	gzipWriter.close()
+}
+
+// gzipResponseWriter is http.ResponseWriter that holds the
+// beginning of the response until it knows whether it's
+// worth compressing, and compresses the rest if it is.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	pending []byte
+	started bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	// responses without body are not held.
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.start(false)
+	}
+}
+
+func (w *gzipResponseWriter) Write(buf []byte) (int, error) {
+	if !w.started {
+		w.pending = append(w.pending, buf...)
+		if len(w.pending) >= gzipMinSize {
+			if err := w.start(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(buf), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(buf)
+	}
+	return w.ResponseWriter.Write(buf)
+}
+
+// Flush implements http.Flusher, so that streamed
+// responses are sent as they are written.
+func (w *gzipResponseWriter) Flush() {
+	if !w.started {
+		w.start(false)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// start writes the header and whatever was held, compressed
+// if compress is set and the response can be compressed.
+func (w *gzipResponseWriter) start(compress bool) error {
+	w.started = true
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || strings.HasPrefix(header.Get("Content-Type"), ContentTypeEventStream) {
+		compress = false
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if compress {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	pending := w.pending
+	w.pending = nil
+	if len(pending) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(pending)
+	} else {
+		_, err = w.ResponseWriter.Write(pending)
+	}
+	return err
+}
+
+// close writes out the rest of the response.
+func (w *gzipResponseWriter) close() {
+	if !w.started {
+		if w.status == 0 && len(w.pending) == 0 {
+			// nothing was written, e.g. the connection was hijacked.
+			return
+		}
+		w.start(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+// etagMiddleware sets ETag of successful responses to GET
+// requests, derived from their body, and answers requests
+// with a current ETag in HeaderIfNoneMatch with 304 Not Modified
+// and no body. Streamed responses are passed through as they are.
+type etagMiddleware struct{}
+
+func newETagMiddleware() etagMiddleware {
+	return etagMiddleware{}
+}
+
+func (m etagMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	if request.Method != http.MethodGet {
+		next(writer, request)
+		return
+	}
+	buffer := &bufferedResponseWriter{ResponseWriter: writer}
+	next(buffer, request)
+	if buffer.flushed {
+		return
+	}
+	if buffer.status == 0 {
+		buffer.status = http.StatusOK
+	}
+	if buffer.status == http.StatusOK && writer.Header().Get(HeaderETag) == "" {
+		etag := responseETag(buffer.body.Bytes())
+		writer.Header().Set(HeaderETag, etag)
+		if etagMatches(request.Header.Get(HeaderIfNoneMatch), etag) {
+			writer.Header().Del("Content-Type")
+			writer.Header().Del("Content-Length")
+			writer.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	buffer.flush()
+}
+
+// responseETag returns ETag of a response with the body.
+// It's weak, since the body may be compressed
+// differently for different clients.
+func responseETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches returns true if the etag is one of those in
+// If-None-Match header, compared weakly.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedResponseWriter is http.ResponseWriter that holds
+// the response until it's flushed.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	flushed bool
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if w.flushed {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferedResponseWriter) Write(buf []byte) (int, error) {
+	if w.flushed {
+		return w.ResponseWriter.Write(buf)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(buf)
+}
+
+// Flush implements http.Flusher, streamed responses
+// are passed through once they are flushed.
+func (w *bufferedResponseWriter) Flush() {
+	if !w.flushed {
+		w.flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// flush writes out the response held so far.
+func (w *bufferedResponseWriter) flush() {
+	w.flushed = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.body.Len() > 0 {
+		w.ResponseWriter.Write(w.body.Bytes())
+		w.body.Reset()
+	}
+}
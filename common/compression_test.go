@@ -0,0 +1,161 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+)
+
+func TestGzipMiddleware(t *testing.T) {
+	large := strings.Repeat("romana ", gzipMinSize)
+	n := negroni.New()
+	n.Use(newGzipMiddleware())
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(large))
+		case "/small":
+			w.Write([]byte("small"))
+		case "/stream":
+			w.Header().Set("Content-Type", ContentTypeEventStream)
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			w.Write([]byte(large))
+		}
+	})
+
+	tests := []struct {
+		path     string
+		encoding string
+		gzipped  bool
+		body     string
+	}{
+		{"/large", "gzip, deflate", true, large},
+		{"/large", "", false, large},
+		{"/large", "gzip;q=0", false, large},
+		{"/small", "gzip", false, "small"},
+		{"/stream", "gzip", false, large},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest("GET", tt.path, nil)
+		if tt.encoding != "" {
+			request.Header.Set("Accept-Encoding", tt.encoding)
+		}
+		recorder := httptest.NewRecorder()
+		n.ServeHTTP(recorder, request)
+
+		gzipped := recorder.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != tt.gzipped {
+			t.Errorf("%s with %q: expected gzipped %t, got %t", tt.path, tt.encoding, tt.gzipped, gzipped)
+			continue
+		}
+		if recorder.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s with %q: expected Vary header, got %q", tt.path, tt.encoding, recorder.Header().Get("Vary"))
+		}
+		body := recorder.Body.String()
+		if gzipped {
+			reader, err := gzip.NewReader(recorder.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recorder.Body.Len() >= len(tt.body) {
+				t.Errorf("%s with %q: expected compressed body, got %d bytes", tt.path, tt.encoding, recorder.Body.Len())
+			}
+			body = string(buf)
+		}
+		if body != tt.body {
+			t.Errorf("%s with %q: unexpected body of %d bytes", tt.path, tt.encoding, len(body))
+		}
+	}
+}
+
+func TestETagMiddleware(t *testing.T) {
+	items := `["a","b"]`
+	n := negroni.New()
+	n.Use(newETagMiddleware())
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/items":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(items))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("{}"))
+		case "/stream":
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			w.Write([]byte("event"))
+		}
+	})
+	get := func(method string, path string, ifNoneMatch string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, nil)
+		if ifNoneMatch != "" {
+			request.Header.Set(HeaderIfNoneMatch, ifNoneMatch)
+		}
+		recorder := httptest.NewRecorder()
+		n.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := get("GET", "/items", "")
+	etag := first.Header().Get(HeaderETag)
+	if first.Code != http.StatusOK || first.Body.String() != items || etag == "" {
+		t.Fatalf("Expected 200 with items and ETag, got %d %q %q", first.Code, first.Body.String(), etag)
+	}
+
+	unchanged := get("GET", "/items", `"other", `+etag)
+	if unchanged.Code != http.StatusNotModified || unchanged.Body.Len() != 0 {
+		t.Errorf("Expected 304 without body, got %d %q", unchanged.Code, unchanged.Body.String())
+	}
+	if unchanged.Header().Get(HeaderETag) != etag {
+		t.Errorf("Expected ETag %s with 304, got %s", etag, unchanged.Header().Get(HeaderETag))
+	}
+
+	items = `["a","b","c"]`
+	changed := get("GET", "/items", etag)
+	if changed.Code != http.StatusOK || changed.Body.String() != items {
+		t.Errorf("Expected 200 with changed items, got %d %q", changed.Code, changed.Body.String())
+	}
+	if changed.Header().Get(HeaderETag) == etag {
+		t.Errorf("Expected new ETag for changed items")
+	}
+
+	missing := get("GET", "/missing", "*")
+	if missing.Code != http.StatusNotFound || missing.Header().Get(HeaderETag) != "" {
+		t.Errorf("Expected 404 without ETag, got %d %q", missing.Code, missing.Header().Get(HeaderETag))
+	}
+
+	if post := get("POST", "/items", ""); post.Header().Get(HeaderETag) != "" {
+		t.Errorf("Expected no ETag for POST, got %q", post.Header().Get(HeaderETag))
+	}
+
+	stream := get("GET", "/stream", "")
+	if stream.Body.String() != "event" || !stream.Flushed || stream.Header().Get(HeaderETag) != "" {
+		t.Errorf("Expected streamed response without ETag, got %q %q", stream.Body.String(), stream.Header().Get(HeaderETag))
+	}
+}
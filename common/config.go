@@ -15,11 +15,377 @@
 
 package common
 
+import (
+	"flag"
+	"time"
+)
+
 // Config is the configuration required for a Romana client library.
 // TODO it is here temporarily until circular imports are resolved.
 type Config struct {
+	// Backend is a kv store holding romana data, etcd
+	// when empty. EtcdEndpoints and EtcdPrefix are used
+	// for any backend.
+	Backend             string
 	EtcdEndpoints       []string
 	EtcdPrefix          string
+	EtcdTLS             EtcdTLS
+	EtcdAuth            EtcdAuth
+	Encryption          Encryption
+	APIAuth             APIAuth
+	ServerTLS           ServerTLS
+	RequestLimits       RequestLimits
+	Webhooks            Webhooks
+	Audit               Audit
+	Federation          Federation
 	InitialTopologyFile *string
 	Mock                bool
+
+	// SlowOpThreshold is duration of store operation above
+	// which it is logged, default threshold is used when zero
+	// and negative value disables logging.
+	SlowOpThreshold time.Duration
+
+	// IdempotencyTTL is how long responses to POST requests with
+	// idempotency keys are replayed to their retries, default TTL
+	// is used when zero and negative value disables replaying.
+	IdempotencyTTL time.Duration
+
+	// StoreFaults are faults injected into store operations,
+	// only meant for tests.
+	StoreFaults StoreFaults
+}
+
+// EtcdTLS configures TLS for connections to etcd, zero value
+// means plain connections. Client certificate is presented when
+// both CertFile and KeyFile are set.
+type EtcdTLS struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+
+	// InsecureSkipVerify disables verification of etcd
+	// certificate, only meant for testing.
+	InsecureSkipVerify bool
+}
+
+// IsEnabled returns true if TLS is configured.
+func (t EtcdTLS) IsEnabled() bool {
+	return t != EtcdTLS{}
+}
+
+// RegisterFlags adds command line flags for etcd TLS to fs.
+func (t *EtcdTLS) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&t.CAFile, "etcd-cafile", "", "verify etcd certificate using this CA bundle")
+	fs.StringVar(&t.CertFile, "etcd-certfile", "", "client certificate for etcd")
+	fs.StringVar(&t.KeyFile, "etcd-keyfile", "", "client certificate key for etcd")
+	fs.StringVar(&t.ServerName, "etcd-server-name", "", "expected server name in etcd certificate, overrides name of the endpoint")
+	fs.BoolVar(&t.InsecureSkipVerify, "etcd-insecure-skip-verify", false, "don't verify etcd certificate (insecure)")
+}
+
+// EtcdAuth holds credentials of etcd user, zero value means
+// etcd authentication is disabled. Password is read from
+// PasswordFile when set, which allows to rotate it without
+// restarting services.
+type EtcdAuth struct {
+	Username     string
+	Password     string
+	PasswordFile string
+}
+
+// IsEnabled returns true if credentials are configured.
+func (a EtcdAuth) IsEnabled() bool {
+	return a.Username != ""
+}
+
+// RegisterFlags adds command line flags for etcd authentication to fs,
+// password can only be provided in a file.
+func (a *EtcdAuth) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&a.Username, "etcd-username", "", "etcd user name")
+	fs.StringVar(&a.PasswordFile, "etcd-password-file", "", "file with the password of etcd user")
+}
+
+// Encryption configures encryption of values stored under selected
+// prefixes, zero value means values are stored in plain text.
+// KeyFile holds base64 encoded 256-bit keys, one per line as
+// "<key id> <key>", first key encrypts new values and others
+// are kept to decrypt values written before the key was rotated.
+type Encryption struct {
+	KeyFile string
+
+	// Prefixes is a comma-separated list of encrypted key prefixes.
+	Prefixes string
+}
+
+// IsEnabled returns true if encryption is configured.
+func (e Encryption) IsEnabled() bool {
+	return e.KeyFile != ""
+}
+
+// RegisterFlags adds command line flags for encryption to fs.
+func (e *Encryption) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&e.KeyFile, "encryption-key-file", "", "file with keys encrypting stored values, empty means no encryption")
+	fs.StringVar(&e.Prefixes, "encrypted-prefixes", "/policies", "comma-separated list of key prefixes encrypted with -encryption-key-file")
+}
+
+// APIAuth configures authentication of requests to services, zero
+// value means requests are not authenticated. Tokens are JWTs signed
+// with RS256, services verify them with the public key in
+// PublicKeyFile. Services that have PrivateKeyFile and UsersFile
+// also issue tokens to users at AuthPath.
+type APIAuth struct {
+	PublicKeyFile  string
+	PrivateKeyFile string
+	UsersFile      string
+
+	// Audience is required in tokens and set in issued ones.
+	Audience string
+	// TokenTTL is how long issued tokens are valid.
+	TokenTTL time.Duration
+
+	// AnonymousReads allows GET requests without a token,
+	// so that only mutating requests are authenticated.
+	AnonymousReads bool
+}
+
+// IsEnabled returns true if requests are authenticated.
+func (a APIAuth) IsEnabled() bool {
+	return a.PublicKeyFile != ""
+}
+
+// IssuesTokens returns true if tokens are issued at AuthPath.
+func (a APIAuth) IssuesTokens() bool {
+	return a.IsEnabled() && a.PrivateKeyFile != "" && a.UsersFile != ""
+}
+
+// RegisterFlags adds command line flags for authentication to fs.
+func (a *APIAuth) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&a.PublicKeyFile, "auth-public-key", "", "PEM file with RSA public key verifying tokens, empty means requests are not authenticated")
+	fs.StringVar(&a.PrivateKeyFile, "auth-private-key", "", "PEM file with RSA private key signing tokens issued at "+AuthPath)
+	fs.StringVar(&a.UsersFile, "auth-users-file", "", "YAML file with users that can get tokens at "+AuthPath)
+	fs.StringVar(&a.Audience, "auth-audience", DefaultAuthAudience, "audience of tokens, tokens for other audiences are rejected")
+	fs.DurationVar(&a.TokenTTL, "auth-token-ttl", DefaultAuthTokenTTL, "how long issued tokens are valid")
+	fs.BoolVar(&a.AnonymousReads, "auth-anonymous-reads", false, "allow GET requests without a token")
+}
+
+// ServerTLS configures TLS termination of service APIs, zero value
+// means services serve plain HTTP. Clients must present certificates
+// signed by CA in ClientCAFile when it is set. Files are re-read on
+// SIGHUP, so that certificates can be rotated without restarting
+// services.
+type ServerTLS struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// IsEnabled returns true if TLS is configured.
+func (t ServerTLS) IsEnabled() bool {
+	return t.CertFile != ""
+}
+
+// RegisterFlags adds command line flags for TLS of service API to fs.
+func (t *ServerTLS) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&t.CertFile, "tls-cert-file", "", "certificate of the service API, empty means plain HTTP")
+	fs.StringVar(&t.KeyFile, "tls-key-file", "", "key of the certificate in -tls-cert-file")
+	fs.StringVar(&t.ClientCAFile, "tls-client-ca-file", "", "require client certificates signed by this CA bundle")
+}
+
+// RequestLimits protects services and the store from clients
+// sending too many or too large requests. Requests are limited
+// per client, which is the user of the token when requests are
+// authenticated and the IP address otherwise.
+type RequestLimits struct {
+	// Rate is requests per second allowed to each client
+	// on average, zero means no limit.
+	Rate float64
+	// Burst is number of requests each client can send
+	// at once above Rate.
+	Burst int
+
+	// MaxBodySize is maximum size of request body in bytes,
+	// zero means no limit.
+	MaxBodySize int64
+}
+
+// RegisterFlags adds command line flags for request limits to fs.
+func (l *RequestLimits) RegisterFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&l.Rate, "rate-limit", 0, "requests per second allowed to each client, 0 means no limit")
+	fs.IntVar(&l.Burst, "rate-limit-burst", DefaultRateLimitBurst, "requests each client can send at once above -rate-limit")
+	fs.Int64Var(&l.MaxBodySize, "max-request-body", DefaultMaxRequestBody, "maximum size of request body in bytes, 0 means no limit")
+}
+
+// Webhooks configures notifications of changes of resources, such
+// as policies, hosts, topology and allocations, posted to webhook
+// URLs, zero value means no notifications are sent. Events are
+// signed with the key in SecretFile, and events that can't be
+// delivered after Retries are appended to DeadLetterFile.
+type Webhooks struct {
+	// URLs is a comma-separated list of webhook URLs.
+	URLs       string
+	SecretFile string
+	Retries    int
+
+	// DeadLetterFile is appended with events that couldn't be
+	// delivered, empty means they are only logged.
+	DeadLetterFile string
+}
+
+// IsEnabled returns true if webhooks are configured.
+func (w Webhooks) IsEnabled() bool {
+	return w.URLs != ""
+}
+
+// RegisterFlags adds command line flags for webhooks to fs.
+func (w *Webhooks) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&w.URLs, "webhook-urls", "", "comma-separated list of URLs changes of resources are posted to")
+	fs.StringVar(&w.SecretFile, "webhook-secret-file", "", "file with the key signing events posted to -webhook-urls, empty means events are not signed")
+	fs.IntVar(&w.Retries, "webhook-retries", DefaultWebhookRetries, "number of times delivery of an event to a webhook is retried")
+	fs.StringVar(&w.DeadLetterFile, "webhook-dead-letter-file", "", "file appended with events that couldn't be delivered to webhooks")
+}
+
+// Audit configures the audit log of mutating API calls, which is
+// kept apart from debug logging, in File, under EtcdPrefix of the
+// store, or both; zero value means calls are not audited. File is
+// rotated when it grows over MaxSize megabytes, and MaxBackups
+// rotated files are kept. Records older than MaxAge are removed,
+// zero means they are kept forever.
+type Audit struct {
+	File       string
+	EtcdPrefix string
+	MaxSize    int
+	MaxBackups int
+	MaxAge     time.Duration
+}
+
+// IsEnabled returns true if audit log is configured.
+func (a Audit) IsEnabled() bool {
+	return a.File != "" || a.EtcdPrefix != ""
+}
+
+// RegisterFlags adds command line flags for audit log to fs.
+func (a *Audit) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&a.File, "audit-file", "", "file mutating API calls are appended to")
+	fs.StringVar(&a.EtcdPrefix, "audit-etcd-prefix", "", "prefix of keys in the store mutating API calls are kept under")
+	fs.IntVar(&a.MaxSize, "audit-max-size", DefaultAuditMaxSize, "size in megabytes -audit-file is rotated at")
+	fs.IntVar(&a.MaxBackups, "audit-max-backups", DefaultAuditMaxBackups, "number of rotated audit files kept, 0 means all")
+	fs.DurationVar(&a.MaxAge, "audit-max-age", DefaultAuditMaxAge, "how long audit records are kept, 0 means forever")
+}
+
+// Logging configures logs of a component, see ConfigureLogging.
+// Level is the level of rlog, optionally followed by levels of
+// modules matching patterns of source file names, e.g.
+// "INFO,policycache*=DEBUG"; RLOG_LOG_LEVEL is used when empty.
+type Logging struct {
+	Format string
+	Level  string
+}
+
+// RegisterFlags adds command line flags for logging to fs.
+func (l *Logging) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&l.Format, "log-format", LogFormatText, "format of logs, text or json")
+	fs.StringVar(&l.Level, "log-level", "", "log level, optionally followed by levels of modules, e.g. INFO,policycache*=DEBUG")
+}
+
+// Tracing configures export of OpenTelemetry traces of a component
+// over OTLP/gRPC to Endpoint, see ConfigureTracing; traces aren't
+// exported when Endpoint is empty. SampleRatio is the fraction of
+// traces started by the component that are sampled, all of them
+// when zero. Traces continued from other components keep their
+// sampling decision.
+type Tracing struct {
+	Endpoint    string
+	Insecure    bool
+	SampleRatio float64
+}
+
+// IsEnabled returns true if traces are exported.
+func (t Tracing) IsEnabled() bool {
+	return t.Endpoint != ""
+}
+
+// RegisterFlags adds command line flags for tracing to fs.
+func (t *Tracing) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&t.Endpoint, "otlp-endpoint", "", "host:port of OTLP/gRPC collector traces are exported to, disabled when empty")
+	fs.BoolVar(&t.Insecure, "otlp-insecure", false, "export traces to -otlp-endpoint without TLS")
+	fs.Float64Var(&t.SampleRatio, "trace-sample-ratio", 1, "fraction of traces started by the component that are sampled")
+}
+
+// DefaultFederationReportInterval is how often romanad of
+// a federated cluster reports utilization to the parent.
+const DefaultFederationReportInterval = time.Minute
+
+// Federation configures romanad of a cluster sharing address space
+// with other clusters, zero value means the cluster is standalone.
+// Networks of the topology of the cluster must be in super-blocks
+// the parent romanad at ParentURL delegated to Cluster, and their
+// utilization is reported to the parent every ReportInterval.
+// Requests to the parent are authenticated with tokens the parent
+// issues to Username with the password in PasswordFile, tokens are
+// issued again once they expire. Certificate of the parent is
+// verified with CAFile if set.
+type Federation struct {
+	ParentURL      string
+	Cluster        string
+	Username       string
+	PasswordFile   string
+	CAFile         string
+	ReportInterval time.Duration
+}
+
+// IsEnabled returns true if the cluster is federated.
+func (f Federation) IsEnabled() bool {
+	return f.ParentURL != ""
+}
+
+// RegisterFlags adds command line flags for federation to fs.
+func (f *Federation) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&f.ParentURL, "federation-parent", "", "URL of the parent romanad delegating address space to this cluster")
+	fs.StringVar(&f.Cluster, "federation-cluster", "", "name of this cluster in the federation")
+	fs.StringVar(&f.Username, "federation-username", "", "user authenticating requests to -federation-parent")
+	fs.StringVar(&f.PasswordFile, "federation-password-file", "", "file with password of -federation-username")
+	fs.StringVar(&f.CAFile, "federation-ca-file", "", "CA bundle verifying certificate of -federation-parent")
+	fs.DurationVar(&f.ReportInterval, "federation-report-interval", DefaultFederationReportInterval, "how often utilization of networks is reported to -federation-parent")
+}
+
+// StoreFaults configures faults injected into operations of the
+// store, so that behavior of components when etcd is flaky can be
+// tested, zero value injects no faults. Rates are probabilities
+// from 0 to 1 of a fault for every operation.
+type StoreFaults struct {
+	// ErrorRate is the rate of operations failing
+	// without being done.
+	ErrorRate float64
+	// PartialFailureRate is the rate of writes failing after
+	// they were done, the way requests to etcd may time out
+	// after etcd applied them.
+	PartialFailureRate float64
+	// WatchDropRate is the rate of watches being closed
+	// instead of delivering a change.
+	WatchDropRate float64
+	// Latency is added to every operation, with up to
+	// LatencyJitter more at random.
+	Latency       time.Duration
+	LatencyJitter time.Duration
+	// Seed of random faults, faults of operations done in the
+	// same order are the same for the same seed. Random seed
+	// is used when zero.
+	Seed int64
+}
+
+// IsEnabled returns true if any faults are configured.
+func (f StoreFaults) IsEnabled() bool {
+	return f.ErrorRate > 0 || f.PartialFailureRate > 0 || f.WatchDropRate > 0 ||
+		f.Latency > 0 || f.LatencyJitter > 0
+}
+
+// RegisterFlags adds command line flags for store faults to fs.
+func (f *StoreFaults) RegisterFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&f.ErrorRate, "store-fault-error-rate", 0, "rate of store operations failing, for testing only")
+	fs.Float64Var(&f.PartialFailureRate, "store-fault-partial-rate", 0, "rate of store writes failing after they were done, for testing only")
+	fs.Float64Var(&f.WatchDropRate, "store-fault-watch-drop-rate", 0, "rate of store watches closed instead of delivering a change, for testing only")
+	fs.DurationVar(&f.Latency, "store-fault-latency", 0, "latency added to store operations, for testing only")
+	fs.DurationVar(&f.LatencyJitter, "store-fault-latency-jitter", 0, "maximum random latency added to -store-fault-latency, for testing only")
+	fs.Int64Var(&f.Seed, "store-fault-seed", 0, "seed of random store faults, random if 0")
 }
@@ -48,8 +48,8 @@ const (
 
 	Starting ServiceMessage = "Starting."
 
-	// JSON
-	TimeoutMessage = "{ \"error\" : \"Timed out\" }"
+	// JSON body of requests that timed out, in the format of HttpError.
+	TimeoutMessage = `{"code":"unavailable","message":"Timed out","status_code":503}`
 
 	// Empty string returned when there is a string return
 	// but there is an error so no point in returning any
@@ -18,8 +18,10 @@ package common
 // Various errors.
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 
 	"net/http"
 	"os/exec"
@@ -31,8 +33,32 @@ func NewError(text string, args ...interface{}) error {
 	return errors.New(fmt.Sprintf(text, args...))
 }
 
+// Codes of errors returned by services, clients can
+// branch on them and they will not change.
+const (
+	ErrorCodeBadRequest      = "bad_request"
+	ErrorCodeUnauthorized    = "unauthorized"
+	ErrorCodeForbidden       = "forbidden"
+	ErrorCodeNotFound        = "not_found"
+	ErrorCodeConflict        = "conflict"
+	ErrorCodeAlreadyExists   = "already_exists"
+	ErrorCodeQuotaExceeded   = "quota_exceeded"
+	ErrorCodeTooLarge        = "request_too_large"
+	ErrorCodeUnprocessable   = "unprocessable_entity"
+	ErrorCodeTooManyRequests = "too_many_requests"
+	ErrorCodeUnavailable     = "unavailable"
+	ErrorCodeInternal        = "internal"
+)
+
 // HttpError is a structure that represents, well, an HTTP error.
+// Services respond with it to failed requests, with Code, Message
+// and RequestID filled in.
 type HttpError struct {
+	// Code is one of ErrorCode constants, by default
+	// the one of StatusCode.
+	Code string `json:"code,omitempty"`
+	// Message describes the error for humans.
+	Message string `json:"message,omitempty"`
 	// HTTP status code
 	StatusCode int         `json:"status_code"`
 	Details    interface{} `json:"details,omitempty"`
@@ -41,6 +67,8 @@ type HttpError struct {
 	// ResourceType specifies the relevant resource type, if applicable
 	ResourceType string `json:"resource_type,omitempty"`
 	SeeAlso      string `json:"see_also, omitempty"`
+	// RequestID identifies the failed request in logs of the service.
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // StatusText returns the string value of the HttpError corresponding
@@ -100,6 +128,18 @@ func NewErrorConflict(details interface{}) HttpError {
 	return HttpError{StatusCode: http.StatusConflict, Details: details}
 }
 
+// NewErrorExists creates an HttpError with 409 (http.StatusConflict)
+// status code for objects that exist already.
+func NewErrorExists(details interface{}) HttpError {
+	return HttpError{StatusCode: http.StatusConflict, Code: ErrorCodeAlreadyExists, Details: details}
+}
+
+// NewErrorQuotaExceeded creates an HttpError with 403 (http.StatusForbidden)
+// status code for requests that would exceed a quota or capacity.
+func NewErrorQuotaExceeded(details interface{}) HttpError {
+	return HttpError{StatusCode: http.StatusForbidden, Code: ErrorCodeQuotaExceeded, Details: details}
+}
+
 // NewUnprocessableEntityError creates an HttpError with 423
 // (StatusUnprocessableEntity) status code.
 func NewUnprocessableEntityError(details interface{}) HttpError {
@@ -128,6 +168,9 @@ func (httpErr HttpError) Error() string {
 	if httpErr.Details != nil {
 		s += fmt.Sprintf("\nDetails: %v", httpErr.Details)
 	}
+	if httpErr.RequestID != "" {
+		s += fmt.Sprintf("\nRequest ID: %s", httpErr.RequestID)
+	}
 	return s
 }
 
@@ -139,6 +182,132 @@ func NewHttpError(code int, details interface{}) HttpError {
 	}
 }
 
+// errorCode returns the code of errors with the HTTP status.
+func errorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrorCodeForbidden
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusConflict:
+		return ErrorCodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return ErrorCodeTooLarge
+	case StatusUnprocessableEntity:
+		return ErrorCodeUnprocessable
+	case http.StatusTooManyRequests:
+		return ErrorCodeTooManyRequests
+	case http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ErrorCodeUnavailable
+	}
+	if status >= http.StatusInternalServerError {
+		return ErrorCodeInternal
+	}
+	return ErrorCodeBadRequest
+}
+
+// envelope returns the error as services respond with it,
+// with code and message filled in if they are not set.
+func (httpErr HttpError) envelope(requestID string) HttpError {
+	if httpErr.Code == "" {
+		httpErr.Code = errorCode(httpErr.StatusCode)
+	}
+	if httpErr.Message == "" {
+		httpErr.Message = httpErr.message()
+	}
+	// errors marshal to empty objects.
+	if err, ok := httpErr.Details.(error); ok {
+		httpErr.Details = err.Error()
+	}
+	httpErr.RequestID = requestID
+	return httpErr
+}
+
+// message returns details of the error if they are text,
+// or describes the error by its status and resource.
+func (httpErr HttpError) message() string {
+	switch details := httpErr.Details.(type) {
+	case string:
+		if details != "" {
+			return details
+		}
+	case error:
+		return details.Error()
+	}
+	if httpErr.ResourceType != "" || httpErr.ResourceID != "" {
+		resource := strings.TrimSpace(httpErr.ResourceType + " " + httpErr.ResourceID)
+		return fmt.Sprintf("%s: %s", httpErr.StatusText(), resource)
+	}
+	return httpErr.StatusText()
+}
+
+// ParseHttpError returns the error services responded with, from
+// status and body of the response. Responses of older services,
+// and those that aren't from services, get code of the status and
+// the body as details.
+func ParseHttpError(status int, body []byte) HttpError {
+	var httpErr HttpError
+	if err := json.Unmarshal(body, &httpErr); err != nil || httpErr.StatusCode == 0 {
+		httpErr = HttpError{StatusCode: status}
+		if text := strings.TrimSpace(string(body)); text != "" {
+			httpErr.Details = text
+		}
+	}
+	if httpErr.Code == "" {
+		httpErr.Code = errorCode(httpErr.StatusCode)
+	}
+	return httpErr
+}
+
+// asHttpError returns HttpError err is, if it is one.
+func asHttpError(err error) (HttpError, bool) {
+	switch err := err.(type) {
+	case HttpError:
+		return err, true
+	case *HttpError:
+		if err != nil {
+			return *err, true
+		}
+	}
+	return HttpError{}, false
+}
+
+// errorCodeOf returns code of HttpError err, the code of its
+// status if it has none, or empty string if err isn't HttpError.
+func errorCodeOf(err error) string {
+	httpErr, ok := asHttpError(err)
+	if !ok {
+		return ""
+	}
+	if httpErr.Code == "" {
+		return errorCode(httpErr.StatusCode)
+	}
+	return httpErr.Code
+}
+
+// IsNotFound returns true if err is HttpError
+// of an object that doesn't exist.
+func IsNotFound(err error) bool {
+	return errorCodeOf(err) == ErrorCodeNotFound
+}
+
+// IsConflict returns true if err is HttpError of an object that
+// exists already or was modified concurrently.
+func IsConflict(err error) bool {
+	code := errorCodeOf(err)
+	return code == ErrorCodeConflict || code == ErrorCodeAlreadyExists
+}
+
+// IsQuotaExceeded returns true if err is HttpError of a request
+// that would exceed a quota or capacity.
+func IsQuotaExceeded(err error) bool {
+	return errorCodeOf(err) == ErrorCodeQuotaExceeded
+}
+
 // MultiError is a facility to collect multiple number of errors but
 // present them as a single error interface. For example,
 // GORM does not return errors at every turn. It accumulates them and returns
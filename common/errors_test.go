@@ -0,0 +1,95 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorResponse(t *testing.T) {
+	router := newRouter(Routes{
+		Route{Method: "GET", Pattern: "/tenants/{name}", Handler: func(input interface{}, ctx RestContext) (interface{}, error) {
+			return nil, NewError404("tenant", ctx.PathVariables["name"])
+		}},
+		Route{Method: "POST", Pattern: "/addresses", Handler: func(input interface{}, ctx RestContext) (interface{}, error) {
+			return nil, NewErrorQuotaExceeded("Tenant t1 has 1 addresses allocated, which is its quota")
+		}},
+		Route{Method: "DELETE", Pattern: "/hosts", Handler: func(input interface{}, ctx RestContext) (interface{}, error) {
+			return nil, fmt.Errorf("store is down")
+		}},
+	})
+
+	for _, tc := range []struct {
+		method  string
+		path    string
+		status  int
+		code    string
+		message string
+	}{
+		{"GET", "/v1/tenants/t1", http.StatusNotFound, ErrorCodeNotFound, "Not Found: tenant t1"},
+		{"POST", "/v1/addresses", http.StatusForbidden, ErrorCodeQuotaExceeded, "Tenant t1 has 1 addresses allocated, which is its quota"},
+		{"DELETE", "/v1/hosts", http.StatusInternalServerError, ErrorCodeInternal, "store is down"},
+		{"GET", "/v1/things", http.StatusNotFound, ErrorCodeNotFound, "Not Found: URI /v1/things"},
+	} {
+		request := httptest.NewRequest(tc.method, tc.path, nil)
+		request.Header.Set(HeaderRequestID, "0123456789abcdef")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		httpErr := ParseHttpError(recorder.Code, recorder.Body.Bytes())
+		if recorder.Code != tc.status || httpErr.StatusCode != tc.status || httpErr.Code != tc.code ||
+			httpErr.Message != tc.message || httpErr.RequestID != "0123456789abcdef" {
+			t.Errorf("%s %s: expected %d %s %q, got %d %s", tc.method, tc.path, tc.status, tc.code, tc.message,
+				recorder.Code, recorder.Body)
+		}
+	}
+}
+
+func TestParseHttpError(t *testing.T) {
+	body, _ := json.Marshal(NewErrorExists("host host1 exists"))
+	for i, tc := range []struct {
+		status        int
+		body          string
+		notFound      bool
+		conflict      bool
+		quotaExceeded bool
+	}{
+		{http.StatusConflict, string(body), false, true, false},
+		{http.StatusForbidden, `{"code":"quota_exceeded","status_code":403}`, false, false, true},
+		{http.StatusForbidden, `{"code":"forbidden","status_code":403}`, false, false, false},
+		// older services respond without code.
+		{http.StatusNotFound, `{"status_code":404,"resource_type":"host"}`, true, false, false},
+		{http.StatusConflict, "", false, true, false},
+		{http.StatusBadGateway, "<html>Bad Gateway</html>", false, false, false},
+	} {
+		err := ParseHttpError(tc.status, []byte(tc.body))
+		if IsNotFound(err) != tc.notFound || IsConflict(err) != tc.conflict || IsQuotaExceeded(err) != tc.quotaExceeded {
+			t.Errorf("%d: unexpected kind of error %+v", i, err)
+		}
+	}
+
+	err := ParseHttpError(http.StatusBadGateway, []byte("<html>Bad Gateway</html>\n"))
+	if err.StatusCode != http.StatusBadGateway || err.Code != ErrorCodeInternal || err.Details != "<html>Bad Gateway</html>" {
+		t.Errorf("Expected error from status and body, got %+v", err)
+	}
+	if IsNotFound(fmt.Errorf("not found")) || IsConflict(nil) {
+		t.Error("Expected errors other than HttpError to be of no kind")
+	}
+}
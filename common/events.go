@@ -0,0 +1,108 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to
+// streaming events to clients of REST services.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/romana/rlog"
+)
+
+const (
+	// ContentTypeEventStream is content type of server-sent events,
+	// see https://html.spec.whatwg.org/multipage/server-sent-events.html.
+	ContentTypeEventStream = "text/event-stream"
+
+	// eventKeepAliveInterval is how often a comment is sent to
+	// idle streams, so that proxies don't close them.
+	eventKeepAliveInterval = 15 * time.Second
+)
+
+// Event is sent to clients of streaming routes, Data is
+// sent encoded as JSON.
+type Event struct {
+	Name string
+	Data interface{}
+}
+
+// Watch starts watching for changes, which are sent as events
+// to the returned channel until stopCh is closed.
+type Watch func(stopCh <-chan struct{}) (<-chan Event, error)
+
+// StreamEvents serves a request to a streaming route by sending
+// events of the watch as server-sent events, until the client
+// disconnects. Events are numbered by their id field from 1.
+func StreamEvents(input UnwrappedRestHandlerInput, watch Watch) {
+	writer := input.ResponseWriter
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		write500(writer, input.Request, ContentTypeMarshallers["application/json"], NewError("Streaming is not supported"))
+		return
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	events, err := watch(stopCh)
+	if err != nil {
+		write500(writer, input.Request, ContentTypeMarshallers["application/json"], err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", ContentTypeEventStream)
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	done := input.Request.Context().Done()
+	for id := 1; ; {
+		var err error
+		select {
+		case <-done:
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(writer, ": keep-alive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			err = writeEvent(writer, id, event)
+			id++
+		}
+		if err != nil {
+			log.Infof("Stopped streaming events to %s: %s", input.Request.RemoteAddr, err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes the event in the format of server-sent events.
+func writeEvent(writer http.ResponseWriter, id int, event Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "id: %d\nevent: %s\ndata: %s\n\n", id, event.Name, data)
+	return err
+}
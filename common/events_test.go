@@ -0,0 +1,88 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamEvents(t *testing.T) {
+	stopped := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		StreamEvents(UnwrappedRestHandlerInput{w, r}, func(stopCh <-chan struct{}) (<-chan Event, error) {
+			events := make(chan Event)
+			go func() {
+				defer close(stopped)
+				for i := 1; ; i++ {
+					select {
+					case events <- Event{Name: "count", Data: map[string]int{"n": i}}:
+					case <-stopCh:
+						return
+					}
+				}
+			}()
+			return events, nil
+		})
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != ContentTypeEventStream {
+		t.Errorf("Expected content type %s, got %s", ContentTypeEventStream, ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for i := 1; i <= 2; i++ {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				break
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+		expect := []string{fmt.Sprintf("id: %d", i), "event: count", fmt.Sprintf(`data: {"n":%d}`, i)}
+		if strings.Join(lines, "|") != strings.Join(expect, "|") {
+			t.Errorf("Expected event %v, got %v", expect, lines)
+		}
+	}
+
+	// watch is stopped when the client disconnects.
+	resp.Body.Close()
+	<-stopped
+}
+
+func TestStreamEventsWatchError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/watch/hosts", nil)
+	StreamEvents(UnwrappedRestHandlerInput{recorder, request}, func(stopCh <-chan struct{}) (<-chan Event, error) {
+		return nil, NewError("store unavailable")
+	})
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, recorder.Code)
+	}
+}
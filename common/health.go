@@ -0,0 +1,118 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to
+// health, readiness and status of REST services.
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// HealthzPath is the path every service reports it's up at.
+	HealthzPath = "/healthz"
+
+	// ReadyzPath is the path every service reports at whether
+	// it's ready to serve requests.
+	ReadyzPath = "/readyz"
+
+	// StatusPath is the path every service reports its
+	// version, uptime and readiness at.
+	StatusPath = "/status"
+
+	// healthOK is the status of healthy and ready services.
+	healthOK = "ok"
+)
+
+// ReadinessChecker is implemented by services that depend on
+// the store or other components to serve requests. Services that
+// don't implement it are ready as soon as they are up.
+type ReadinessChecker interface {
+	// Ready returns error describing why the service
+	// can't serve requests, or nil if it can.
+	Ready() error
+}
+
+// HealthStatus is returned at HealthzPath and ReadyzPath.
+type HealthStatus struct {
+	Status string `json:"status"`
+}
+
+// ServiceStatus is returned at StatusPath.
+type ServiceStatus struct {
+	Service string      `json:"service"`
+	Version VersionInfo `json:"version"`
+	Started time.Time   `json:"started"`
+	Uptime  string      `json:"uptime"`
+	Ready   bool        `json:"ready"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// serviceReady returns error describing why the service can't
+// serve requests, if it implements ReadinessChecker.
+func serviceReady(service Service) error {
+	if checker, ok := service.(ReadinessChecker); ok {
+		return checker.Ready()
+	}
+	return nil
+}
+
+// healthRoutes returns routes every service reports its health
+// and status at, started is when the service was started.
+func healthRoutes(service Service, started time.Time) Routes {
+	return Routes{
+		Route{
+			Method:  http.MethodGet,
+			Pattern: HealthzPath,
+			Handler: func(input interface{}, ctx RestContext) (interface{}, error) {
+				return HealthStatus{Status: healthOK}, nil
+			},
+			Unversioned: true,
+		},
+		Route{
+			Method:  http.MethodGet,
+			Pattern: ReadyzPath,
+			Handler: func(input interface{}, ctx RestContext) (interface{}, error) {
+				if err := serviceReady(service); err != nil {
+					return nil, NewHttpError(http.StatusServiceUnavailable, err.Error())
+				}
+				return HealthStatus{Status: healthOK}, nil
+			},
+			Unversioned: true,
+		},
+		Route{
+			Method:  http.MethodGet,
+			Pattern: StatusPath,
+			Handler: func(input interface{}, ctx RestContext) (interface{}, error) {
+				status := ServiceStatus{
+					Service: service.Name(),
+					Version: GetVersionInfo(),
+					Started: started.UTC(),
+					Uptime:  time.Since(started).Round(time.Second).String(),
+					Ready:   true,
+				}
+				if err := serviceReady(service); err != nil {
+					status.Ready = false
+					status.Error = err.Error()
+				}
+				return status, nil
+			},
+			Unversioned: true,
+		},
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+type healthTestService struct {
+	openAPITestService
+	err error
+}
+
+func (s healthTestService) Ready() error { return s.err }
+
+func TestHealthRoutes(t *testing.T) {
+	started := time.Now().Add(-time.Minute)
+	handlers := make(map[string]RestHandler)
+	notReady := healthTestService{err: NewError("store is not reachable")}
+	for _, route := range healthRoutes(notReady, started) {
+		handlers[route.Pattern] = route.Handler
+	}
+
+	// service is up even when it's not ready.
+	health, err := handlers[HealthzPath](nil, RestContext{})
+	if err != nil {
+		t.Fatalf("Unexpected error from %s: %s", HealthzPath, err)
+	}
+	if health.(HealthStatus).Status != healthOK {
+		t.Errorf("Expected %s status, got %v", healthOK, health)
+	}
+
+	_, err = handlers[ReadyzPath](nil, RestContext{})
+	if httpErr, ok := err.(HttpError); !ok || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected %d from %s, got %v", http.StatusServiceUnavailable, ReadyzPath, err)
+	}
+
+	status, err := handlers[StatusPath](nil, RestContext{})
+	if err != nil {
+		t.Fatalf("Unexpected error from %s: %s", StatusPath, err)
+	}
+	s := status.(ServiceStatus)
+	if s.Service != "test" || s.Ready || s.Error != notReady.err.Error() || s.Uptime != "1m0s" {
+		t.Errorf("Unexpected status %+v", s)
+	}
+
+	for _, route := range healthRoutes(healthTestService{}, started) {
+		if _, err := route.Handler(nil, RestContext{}); err != nil {
+			t.Errorf("Unexpected error from %s of ready service: %s", route.Pattern, err)
+		}
+	}
+}
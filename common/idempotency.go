@@ -0,0 +1,263 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to
+// replaying responses to retried requests with idempotency keys.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/context"
+	log "github.com/romana/rlog"
+)
+
+const (
+	// HeaderIdempotencyKey of POST requests identifies them across
+	// retries, so that retried requests get the response to the
+	// first one rather than making changes again.
+	HeaderIdempotencyKey = "Idempotency-Key"
+
+	// HeaderIdempotentReplayed is set to "true" in responses
+	// replayed for retried requests.
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	// DefaultIdempotencyTTL is how long responses to requests
+	// with idempotency keys are kept.
+	DefaultIdempotencyTTL = 24 * time.Hour
+)
+
+// IdempotencyKey returns idempotency key of the request, from
+// HeaderIdempotencyKey or, for older clients, RequestToken query
+// parameter, or empty string if it has none.
+func IdempotencyKey(request *http.Request) string {
+	if key := request.Header.Get(HeaderIdempotencyKey); key != "" {
+		return key
+	}
+	return request.URL.Query().Get(RequestTokenQueryParameter)
+}
+
+// IdempotentResponse is a response kept for replaying
+// to retries of a request with idempotency key.
+type IdempotentResponse struct {
+	// Request is SHA-256 digest of the request body, retries
+	// must have the same body.
+	Request     string `json:"request"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// IdempotencyStore keeps responses to requests with idempotency
+// keys. GetResponse returns nil if there is no response for the key.
+type IdempotencyStore interface {
+	GetResponse(key string) (*IdempotentResponse, error)
+	PutResponse(key string, response IdempotentResponse, ttl time.Duration) error
+}
+
+// IdempotencyStorer is implemented by services that keep responses
+// to requests with idempotency keys in the store, so that they are
+// replayed by any instance of the service. Others keep them in memory.
+type IdempotencyStorer interface {
+	IdempotencyStore() IdempotencyStore
+}
+
+// memoryIdempotencyStore is IdempotencyStore keeping
+// responses in memory of the service.
+type memoryIdempotencyStore struct {
+	mutex     sync.Mutex
+	responses map[string]IdempotentResponse
+	expires   map[string]time.Time
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{
+		responses: make(map[string]IdempotentResponse),
+		expires:   make(map[string]time.Time),
+	}
+}
+
+// GetResponse implements IdempotencyStore.
+func (s *memoryIdempotencyStore) GetResponse(key string) (*IdempotentResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	response, ok := s.responses[key]
+	if !ok || time.Now().After(s.expires[key]) {
+		return nil, nil
+	}
+	return &response, nil
+}
+
+// PutResponse implements IdempotencyStore, expired
+// responses are removed as new ones are put.
+func (s *memoryIdempotencyStore) PutResponse(key string, response IdempotentResponse, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	for k, expires := range s.expires {
+		if now.After(expires) {
+			delete(s.responses, k)
+			delete(s.expires, k)
+		}
+	}
+	s.responses[key] = response
+	s.expires[key] = now.Add(ttl)
+	return nil
+}
+
+// idempotencyMiddleware replays responses to POST requests with
+// idempotency keys that were served already. Keys are scoped to
+// the user and path of requests. It follows the authentication
+// middleware, so that the user of the request is known.
+type idempotencyMiddleware struct {
+	store IdempotencyStore
+	ttl   time.Duration
+
+	mutex    sync.Mutex
+	inFlight map[string]bool
+}
+
+// newIdempotencyMiddleware returns middleware keeping responses
+// for ttl, DefaultIdempotencyTTL when zero.
+func newIdempotencyMiddleware(service Service, ttl time.Duration) *idempotencyMiddleware {
+	if ttl == 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	m := &idempotencyMiddleware{ttl: ttl, inFlight: make(map[string]bool)}
+	if storer, ok := service.(IdempotencyStorer); ok {
+		m.store = storer.IdempotencyStore()
+	} else {
+		m.store = newMemoryIdempotencyStore()
+	}
+	return m
+}
+
+func (m *idempotencyMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	key := IdempotencyKey(request)
+	if request.Method != http.MethodPost || key == "" {
+		next(writer, request)
+		return
+	}
+
+	marshaller := ContentTypeMarshallers[writer.Header().Get("Content-Type")]
+	if marshaller == nil {
+		marshaller = ContentTypeMarshallers["application/json"]
+	}
+	user, _ := context.Get(request, ContextKeyUser).(User)
+	key = idempotencyStoreKey(user.Username, request.URL.Path, key)
+	body, _ := context.Get(request, ContextKeyOriginalBody).([]byte)
+	digest := auditDigest(body)
+
+	if !m.begin(key) {
+		writeError(writer, request, marshaller, NewErrorConflict(
+			"Request with the same "+HeaderIdempotencyKey+" is in progress"))
+		return
+	}
+	defer m.end(key)
+
+	stored, err := m.store.GetResponse(key)
+	if err != nil {
+		log.Errorf("Failed to get response for idempotency key of request %s: %s", RequestID(request), err)
+	}
+	if stored != nil {
+		if stored.Request != digest {
+			writeError(writer, request, marshaller, NewUnprocessableEntityError(
+				HeaderIdempotencyKey+" was used for a request with different body"))
+			return
+		}
+		if stored.ContentType != "" {
+			writer.Header().Set("Content-Type", stored.ContentType)
+		}
+		writer.Header().Set(HeaderIdempotentReplayed, "true")
+		writer.WriteHeader(stored.Status)
+		writer.Write(stored.Body)
+		return
+	}
+
+	recorder := &responseRecorder{ResponseWriter: writer}
+	next(recorder, request)
+
+	// failures of the service are not kept,
+	// so that retries can succeed.
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	if recorder.status >= http.StatusInternalServerError {
+		return
+	}
+	response := IdempotentResponse{
+		Request:     digest,
+		Status:      recorder.status,
+		ContentType: writer.Header().Get("Content-Type"),
+		Body:        recorder.body.Bytes(),
+	}
+	if err := m.store.PutResponse(key, response, m.ttl); err != nil {
+		log.Errorf("Failed to keep response for idempotency key of request %s: %s", RequestID(request), err)
+	}
+}
+
+// begin marks request with the key in flight, it returns
+// false if one is in flight already.
+func (m *idempotencyMiddleware) begin(key string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.inFlight[key] {
+		return false
+	}
+	m.inFlight[key] = true
+	return true
+}
+
+func (m *idempotencyMiddleware) end(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.inFlight, key)
+}
+
+// idempotencyStoreKey returns key responses are kept under, which
+// is a digest so that it is safe to use in paths of the store.
+func idempotencyStoreKey(user string, path string, key string) string {
+	sum := sha256.Sum256([]byte(user + "\n" + path + "\n" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// responseRecorder is http.ResponseWriter that
+// keeps status and body of the response it writes.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(buf []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(buf)
+	return r.ResponseWriter.Write(buf)
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+)
+
+// idempotencyTestService counts things added by POST /things,
+// and fails while failing is set.
+type idempotencyTestService struct {
+	openAPITestService
+	added   int
+	failing bool
+}
+
+func (s *idempotencyTestService) Routes() Routes {
+	return Routes{
+		Route{
+			Method:      "POST",
+			Pattern:     "/things",
+			Handler:     s.addThing,
+			MakeMessage: func() interface{} { return &auditTestThing{} },
+		},
+	}
+}
+
+func (s *idempotencyTestService) addThing(input interface{}, ctx RestContext) (interface{}, error) {
+	if s.failing {
+		return nil, fmt.Errorf("store is down")
+	}
+	s.added++
+	return s.added, nil
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	service := &idempotencyTestService{}
+	n := negroni.New()
+	n.Use(newRequestIDMiddleware())
+	n.Use(NewNegotiator())
+	n.Use(NewUnmarshaller())
+	n.Use(newIdempotencyMiddleware(service, 0))
+	n.UseHandler(newRouter(service.Routes()))
+
+	post := func(path string, key string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			request.Header.Set(HeaderIdempotencyKey, key)
+		}
+		recorder := httptest.NewRecorder()
+		n.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	for i, tc := range []struct {
+		path     string
+		key      string
+		body     string
+		status   int
+		expect   string
+		replayed bool
+	}{
+		{"/v1/things", "key1", `{"name":"a"}`, http.StatusOK, "1", false},
+		// retry gets response to the first request.
+		{"/v1/things", "key1", `{"name":"a"}`, http.StatusOK, "1", true},
+		{"/v1/things", "key2", `{"name":"a"}`, http.StatusOK, "2", false},
+		// keys are scoped to paths.
+		{"/things", "key1", `{"name":"a"}`, http.StatusOK, "3", false},
+		{"/v1/things", "", `{"name":"a"}`, http.StatusOK, "4", false},
+		{"/v1/things", "", `{"name":"a"}`, http.StatusOK, "5", false},
+		// legacy request token is an idempotency key.
+		{"/v1/things?RequestToken=key3", "", `{"name":"a"}`, http.StatusOK, "6", false},
+		{"/v1/things?RequestToken=key3", "", `{"name":"a"}`, http.StatusOK, "6", true},
+	} {
+		recorder := post(tc.path, tc.key, tc.body)
+		replayed := recorder.Header().Get(HeaderIdempotentReplayed) == "true"
+		if recorder.Code != tc.status || recorder.Body.String() != tc.expect || replayed != tc.replayed {
+			t.Errorf("%d: expected %d %s replayed %t, got %d %s replayed %t", i, tc.status, tc.expect, tc.replayed,
+				recorder.Code, recorder.Body, replayed)
+		}
+	}
+
+	// key can't be reused with different request.
+	recorder := post("/v1/things", "key1", `{"name":"b"}`)
+	if err := ParseHttpError(recorder.Code, recorder.Body.Bytes()); err.StatusCode != StatusUnprocessableEntity {
+		t.Errorf("Expected %d for reused key, got %d %s", StatusUnprocessableEntity, recorder.Code, recorder.Body)
+	}
+
+	// failures are not replayed, so that retries can succeed.
+	service.failing = true
+	if recorder := post("/v1/things", "key4", `{"name":"a"}`); recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected %d, got %d %s", http.StatusInternalServerError, recorder.Code, recorder.Body)
+	}
+	service.failing = false
+	if recorder := post("/v1/things", "key4", `{"name":"a"}`); recorder.Code != http.StatusOK || recorder.Body.String() != "7" {
+		t.Errorf("Expected retry to succeed, got %d %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	s := newMemoryIdempotencyStore()
+	if err := s.PutResponse("key1", IdempotentResponse{Status: http.StatusOK}, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if response, _ := s.GetResponse("key1"); response == nil || response.Status != http.StatusOK {
+		t.Fatalf("Expected response for key1, got %v", response)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if response, _ := s.GetResponse("key1"); response != nil {
+		t.Errorf("Expected response for key1 to expire, got %v", response)
+	}
+
+	s.PutResponse("key2", IdempotentResponse{Status: http.StatusOK}, time.Minute)
+	if _, ok := s.responses["key1"]; ok {
+		t.Error("Expected expired response to be removed")
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package leader elects a single leader among replicas of
+// a component, so that controllers like kubernetes listener
+// can run with multiple replicas, only leader doing the work.
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/romana/core/common/client"
+	log "github.com/romana/rlog"
+)
+
+const (
+	// DefaultTTL is how long leadership outlives a crashed leader.
+	DefaultTTL = 20 * time.Second
+
+	// leaderPrefix is where leaders publish their Info.
+	leaderPrefix = "/leader"
+)
+
+// Info describes current leader of the election.
+type Info struct {
+	Election string    `json:"election"`
+	Identity string    `json:"identity"`
+	Since    time.Time `json:"since"`
+
+	// Renewed is updated by leader every third of TTL, leader
+	// that stopped renewing it has likely crashed and its
+	// leadership expires within TTL.
+	Renewed time.Time `json:"renewed"`
+}
+
+// Elector campaigns for leadership of the election on behalf
+// of this replica, using a store lock with TTL.
+type Elector struct {
+	election string
+	identity string
+	ttl      time.Duration
+	store    *client.Store
+	lock     *client.DistributedLock
+
+	mu     sync.Mutex
+	leader bool
+	since  time.Time
+}
+
+// NewElector returns Elector for the election, empty identity means
+// hostname and pid of the process, ttl <= 0 means DefaultTTL.
+func NewElector(store *client.Store, election string, identity string, ttl time.Duration) (*Elector, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		identity = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	lock, err := store.NewDistributedLock("leader/"+election, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Elector{
+		election: election,
+		identity: identity,
+		ttl:      ttl,
+		store:    store,
+		lock:     lock,
+	}, nil
+}
+
+// Identity returns identity of this replica.
+func (e *Elector) Identity() string {
+	return e.identity
+}
+
+// IsLeader returns true while this replica is the leader.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Leader returns Info of the current leader as published
+// in the store, nil if no leader was elected yet.
+func (e *Elector) Leader() (*Info, error) {
+	kvp, err := e.store.GetObject(e.key())
+	if err != nil || kvp == nil {
+		return nil, err
+	}
+
+	var info Info
+	if err := json.Unmarshal(kvp.Value, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Run campaigns for leadership until ctx is done. Every time this
+// replica becomes the leader, lead is called and runs for as long
+// as leadership lasts, context passed to it is cancelled when
+// leadership is lost. If lead returns, replica steps down and
+// campaigns again.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	backoff := client.Backoff{Initial: time.Second, Max: e.ttl}
+	for {
+		lost, err := e.lock.Lock(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			ElectionErrors.WithLabelValues(e.election).Inc()
+			log.Errorf("Election %s: failed to campaign, %s", e.election, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff.Next()):
+			}
+			continue
+		}
+		backoff.Reset()
+
+		e.lead(ctx, lost, lead)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// lead runs lead while the lock is held, then steps down.
+func (e *Elector) lead(ctx context.Context, lost <-chan struct{}, lead func(ctx context.Context)) {
+	e.setLeader(true)
+	log.Infof("Election %s: %s became the leader", e.election, e.identity)
+	e.publish()
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leaderCtx)
+	}()
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-lost:
+			log.Errorf("Election %s: %s lost the leadership", e.election, e.identity)
+			break loop
+		case <-done:
+			break loop
+		case <-ticker.C:
+			e.publish()
+		}
+	}
+
+	cancel()
+	<-done
+
+	e.setLeader(false)
+	e.unpublish()
+	if err := e.lock.Unlock(); err != nil {
+		log.Errorf("Election %s: failed to step down, %s", e.election, err)
+	}
+	log.Infof("Election %s: %s stepped down", e.election, e.identity)
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.leader = leader
+	if leader {
+		e.since = time.Now()
+		IsLeader.WithLabelValues(e.election).Set(1)
+		Transitions.WithLabelValues(e.election).Inc()
+	} else {
+		IsLeader.WithLabelValues(e.election).Set(0)
+	}
+}
+
+func (e *Elector) key() string {
+	return leaderPrefix + "/" + e.election
+}
+
+// publish stores Info of this replica as the leader.
+func (e *Elector) publish() {
+	e.mu.Lock()
+	info := Info{Election: e.election, Identity: e.identity, Since: e.since, Renewed: time.Now()}
+	e.mu.Unlock()
+
+	b, err := json.Marshal(info)
+	if err == nil {
+		err = e.store.PutObject(e.key(), b)
+	}
+	if err != nil {
+		ElectionErrors.WithLabelValues(e.election).Inc()
+		log.Errorf("Election %s: failed to publish leader, %s", e.election, err)
+	}
+}
+
+// unpublish removes Info of this replica, unless
+// another replica took over already.
+func (e *Elector) unpublish() {
+	info, err := e.Leader()
+	if err != nil || info == nil || info.Identity != e.identity {
+		return
+	}
+	if _, err := e.store.Delete(e.key()); err != nil {
+		log.Errorf("Election %s: failed to remove leader, %s", e.election, err)
+	}
+}
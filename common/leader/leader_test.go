@@ -0,0 +1,89 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/client"
+)
+
+func TestElector(t *testing.T) {
+	store, err := client.NewStore(&common.Config{Backend: client.BackendMemory, EtcdPrefix: "/romanaTest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	first, err := NewElector(store, "test", "first", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewElector(store, "test", "second", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leading := make(chan string, 2)
+	run := func(e *Elector) context.CancelFunc {
+		ctx, cancel := context.WithCancel(context.Background())
+		go e.Run(ctx, func(ctx context.Context) {
+			leading <- e.Identity()
+			<-ctx.Done()
+		})
+		return cancel
+	}
+	expectLeader := func(identity string) {
+		select {
+		case got := <-leading:
+			if got != identity {
+				t.Fatalf("expected %s to lead, got %s", identity, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s to lead", identity)
+		}
+
+		info, err := first.Leader()
+		if err != nil || info == nil || info.Identity != identity {
+			t.Fatalf("expected published leader %s, got %v, %v", identity, info, err)
+		}
+	}
+
+	stopFirst := run(first)
+	expectLeader("first")
+	if !first.IsLeader() {
+		t.Fatal("expected first to be the leader")
+	}
+
+	stopSecond := run(second)
+	defer stopSecond()
+
+	select {
+	case got := <-leading:
+		t.Fatalf("expected single leader, %s leads too", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// first steps down, second takes over.
+	stopFirst()
+	expectLeader("second")
+	if first.IsLeader() {
+		t.Fatal("expected first to step down")
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package leader
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	IsLeader = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "romana_leader",
+			Help: "1 when this replica is the leader of the election, 0 otherwise.",
+		},
+		[]string{"election"},
+	)
+	Transitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "romana_leader_transitions_total",
+			Help: "Number of times this replica became the leader of the election.",
+		},
+		[]string{"election"},
+	)
+	ElectionErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "romana_err_leader_election_total",
+			Help: "Number of errors campaigning for leadership or publishing the leader.",
+		},
+		[]string{"election"},
+	)
+)
+
+// MetricsRegister registers package global metrics into registry provided,
+// for later exposure.
+func MetricsRegister(registry *prometheus.Registry) error {
+	if registry == nil {
+		return fmt.Errorf("registry must not be nil")
+	}
+
+	for _, collector := range []prometheus.Collector{
+		IsLeader,
+		Transitions,
+		ElectionErrors,
+	} {
+		err := registry.Register(collector)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has middleware limiting
+// requests to REST services.
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+const (
+	// DefaultRateLimitBurst is default number of requests
+	// each client can send at once above the rate limit.
+	DefaultRateLimitBurst = 20
+	// DefaultMaxRequestBody is default maximum size
+	// of request body in bytes.
+	DefaultMaxRequestBody = 4 << 20
+
+	// rateLimitPurgeInterval is how often buckets of clients
+	// that stopped sending requests are purged.
+	rateLimitPurgeInterval = time.Minute
+)
+
+// bodyTooLargeError is returned reading request
+// body larger than RequestLimits.MaxBodySize.
+type bodyTooLargeError struct {
+	maxSize int64
+}
+
+func (err bodyTooLargeError) Error() string {
+	return fmt.Sprintf("Request body is larger than %d bytes", err.maxSize)
+}
+
+// bodyLimitMiddleware rejects requests with body larger than
+// maxSize with 413 REQUEST ENTITY TOO LARGE status. Body of
+// requests without Content-Length is cut at maxSize, reading
+// more of it fails with bodyTooLargeError.
+type bodyLimitMiddleware struct {
+	maxSize int64
+}
+
+func newBodyLimitMiddleware(maxSize int64) bodyLimitMiddleware {
+	return bodyLimitMiddleware{maxSize: maxSize}
+}
+
+func (m bodyLimitMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	if m.maxSize <= 0 || request.Body == nil {
+		next(writer, request)
+		return
+	}
+	if request.ContentLength > m.maxSize {
+		writeHttpError(writer, request, ContentTypeMarshallers["application/json"],
+			http.StatusRequestEntityTooLarge, bodyTooLargeError{m.maxSize}.Error())
+		return
+	}
+	request.Body = &limitedBody{ReadCloser: request.Body, maxSize: m.maxSize, remaining: m.maxSize}
+	next(writer, request)
+}
+
+// limitedBody is request body which fails to be read
+// beyond remaining bytes.
+type limitedBody struct {
+	io.ReadCloser
+	maxSize   int64
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, bodyTooLargeError{b.maxSize}
+	}
+	// read one more byte than remaining to tell
+	// body of exactly maxSize from larger one.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), bodyTooLargeError{b.maxSize}
+	}
+	return n, err
+}
+
+// tokenBucket holds tokens of a client, each request takes
+// a token and tokens are added at the rate of the limit.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter limits rate of requests per client.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	purged  time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		purged:  time.Now(),
+	}
+}
+
+// allow takes a token of the client. It returns false if the
+// client has no tokens left, along with the time until it gets one.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.purged) > rateLimitPurgeInterval {
+		l.purge(now)
+	}
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = l.refill(bucket, now)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// refill returns tokens in the bucket at now.
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.updated).Seconds()*l.rate
+	return math.Min(tokens, l.burst)
+}
+
+// purge removes full buckets, which are same as buckets
+// of clients that haven't sent requests yet.
+func (l *rateLimiter) purge(now time.Time) {
+	for client, bucket := range l.buckets {
+		if l.refill(bucket, now) >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.purged = now
+}
+
+// rateLimitMiddleware rejects requests of clients exceeding
+// the rate limit with 429 TOO MANY REQUESTS status. It must
+// follow AuthMiddleware, which sets user sending the request.
+type rateLimitMiddleware struct {
+	limiter *rateLimiter
+}
+
+func newRateLimitMiddleware(rate float64, burst int) rateLimitMiddleware {
+	return rateLimitMiddleware{limiter: newRateLimiter(rate, burst)}
+}
+
+func (m rateLimitMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	client := requestClient(request)
+	ok, wait := m.limiter.allow(client, time.Now())
+	if !ok {
+		contentType := writer.Header().Get("Content-Type")
+		marshaller := ContentTypeMarshallers[contentType]
+		if marshaller == nil {
+			marshaller = ContentTypeMarshallers["application/json"]
+		}
+		seconds := int(math.Ceil(wait.Seconds()))
+		writer.Header().Set("Retry-After", strconv.Itoa(seconds))
+		writeHttpError(writer, request, marshaller, http.StatusTooManyRequests,
+			fmt.Sprintf("Too many requests from %s, retry in %ds", client, seconds))
+		return
+	}
+	next(writer, request)
+}
+
+// requestClient returns the client requests are limited for,
+// user of the token or IP address of anonymous requests.
+func requestClient(request *http.Request) string {
+	if user, ok := context.Get(request, ContextKeyUser).(User); ok && user.Username != "" {
+		return "user " + user.Username
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	return host
+}
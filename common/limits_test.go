@@ -0,0 +1,129 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(2, 3)
+	now := time.Now()
+
+	// burst is allowed at once.
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.allow("a", now); !ok {
+			t.Fatalf("Expected request %d of the burst to be allowed", i)
+		}
+	}
+	ok, wait := limiter.allow("a", now)
+	if ok {
+		t.Fatal("Expected request above the burst to be rejected")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms for a token, got %s", wait)
+	}
+
+	// other clients have their own buckets.
+	if ok, _ := limiter.allow("b", now); !ok {
+		t.Error("Expected request of another client to be allowed")
+	}
+
+	// tokens are added at the rate.
+	if ok, _ := limiter.allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Error("Expected request to be allowed after a token was added")
+	}
+	if ok, _ := limiter.allow("a", now.Add(500*time.Millisecond)); ok {
+		t.Error("Expected request to be rejected before next token is added")
+	}
+
+	// buckets that got full are purged.
+	limiter.allow("c", now.Add(time.Hour))
+	if len(limiter.buckets) != 1 {
+		t.Errorf("Expected only bucket of the last client to be kept, got %d", len(limiter.buckets))
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	m := newRateLimitMiddleware(1, 1)
+	next := func(w http.ResponseWriter, r *http.Request) {}
+
+	for i, tc := range []struct {
+		remoteAddr string
+		user       User
+		expect     int
+	}{
+		{"10.0.0.1:1000", User{}, http.StatusOK},
+		{"10.0.0.1:1001", User{}, http.StatusTooManyRequests},
+		{"10.0.0.2:1000", User{}, http.StatusOK},
+		{"10.0.0.1:1002", User{Username: "admin"}, http.StatusOK},
+		{"10.0.0.3:1000", User{Username: "admin"}, http.StatusTooManyRequests},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/hosts", nil)
+		request.RemoteAddr = tc.remoteAddr
+		context.Set(request, ContextKeyUser, tc.user)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, request, next)
+		context.Clear(request)
+
+		if recorder.Code != tc.expect {
+			t.Errorf("%d: expected status %d, got %d", i, tc.expect, recorder.Code)
+		}
+		if tc.expect == http.StatusTooManyRequests && recorder.Header().Get("Retry-After") != "1" {
+			t.Errorf("%d: expected Retry-After 1, got %q", i, recorder.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	m := newBodyLimitMiddleware(10)
+	unmarshaller := NewUnmarshaller()
+
+	for i, tc := range []struct {
+		body          string
+		contentLength bool
+		expect        int
+	}{
+		{`{"a":"b"}`, true, http.StatusOK},
+		{`{"a":"b"}`, false, http.StatusOK},
+		{`{"a":"bc"}`, false, http.StatusOK},
+		{`{"a":"bcd"}`, true, http.StatusRequestEntityTooLarge},
+		{`{"a":"bcd"}`, false, http.StatusRequestEntityTooLarge},
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/hosts", ioutil.NopCloser(strings.NewReader(tc.body)))
+		request.Header.Set(HeaderContentType, "application/json")
+		request.ContentLength = -1
+		if tc.contentLength {
+			request.ContentLength = int64(len(tc.body))
+		}
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, request, func(w http.ResponseWriter, r *http.Request) {
+			unmarshaller.ServeHTTP(w, r, func(http.ResponseWriter, *http.Request) {})
+		})
+		context.Clear(request)
+
+		if recorder.Code != tc.expect {
+			t.Errorf("%d: expected status %d for body %s, got %d", i, tc.expect, tc.body, recorder.Code)
+		}
+	}
+}
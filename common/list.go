@@ -0,0 +1,266 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality shared by list
+// endpoints of REST services: filtering, sorting and pagination.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Query parameters of list endpoints, other parameters
+	// filter items by fields of the endpoint.
+	ListLimitParameter    = "limit"
+	ListContinueParameter = "continue"
+	ListSortParameter     = "sort"
+
+	// HeaderContinue is set in response to request with limit
+	// when there are more items, to the value of the continue
+	// parameter getting the next page.
+	HeaderContinue = "X-Continue"
+	// HeaderTotalCount is the number of items matching
+	// filters of the request, on all pages.
+	HeaderTotalCount = "X-Total-Count"
+
+	// continuePrefix marks continue tokens, so that values
+	// of other parameters are not taken for tokens.
+	continuePrefix = "offset:"
+)
+
+// ListFields are fields of items of a list endpoint, by which
+// items can be filtered with ?<field>=<value> and sorted with
+// ?sort=<field>, or ?sort=-<field> in descending order. Field
+// can have many values, e.g. tenants of a policy; item matches
+// the filter if any of them does, and is sorted by the first one.
+type ListFields map[string]func(item interface{}) []string
+
+// ListOptions select items of a list endpoint, see ParseListOptions.
+type ListOptions struct {
+	// Filters map fields to values items must have.
+	Filters    map[string]string
+	Sort       string
+	Descending bool
+
+	// Limit is the maximum number of items returned,
+	// zero means no limit.
+	Limit int
+	// Offset is the number of items skipped, which is
+	// given by the continue token of the previous page.
+	Offset int
+}
+
+// ListPage is returned by handlers of list endpoints. Body is
+// written out as any other returned value, Continue and Total
+// are returned in HeaderContinue and HeaderTotalCount headers.
+type ListPage struct {
+	Body     interface{}
+	Continue string
+	Total    int
+}
+
+// ParseListOptions parses options of a list endpoint from query
+// parameters, query parameters that are not fields are ignored.
+func ParseListOptions(values url.Values, fields ListFields) (ListOptions, error) {
+	opts := ListOptions{Filters: make(map[string]string)}
+	for field := range fields {
+		if value := values.Get(field); value != "" {
+			opts.Filters[field] = value
+		}
+	}
+
+	if s := values.Get(ListSortParameter); s != "" {
+		opts.Descending = strings.HasPrefix(s, "-")
+		opts.Sort = strings.TrimPrefix(s, "-")
+		if _, ok := fields[opts.Sort]; !ok {
+			return opts, NewError400(fmt.Sprintf("Cannot sort by %s, expected one of %s",
+				opts.Sort, strings.Join(fields.names(), ", ")))
+		}
+	}
+
+	if s := values.Get(ListLimitParameter); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			return opts, NewError400("Invalid limit " + s)
+		}
+		opts.Limit = limit
+	}
+
+	if s := values.Get(ListContinueParameter); s != "" {
+		offset, err := decodeContinue(s)
+		if err != nil {
+			return opts, NewError400("Invalid continue token " + s)
+		}
+		opts.Offset = offset
+	}
+	return opts, nil
+}
+
+// names returns sorted names of the fields.
+func (fields ListFields) names() []string {
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select returns items of the slice pointed to by items which
+// match filters of the options, sorted and cut to the page, and
+// stores them to the slice. Continue token is returned unless
+// the page is the last one, along with the number of items
+// matching filters. Items are kept in the order they were in
+// unless sorted, so pages of lists in stable order don't overlap
+// as long as no items are added or removed in between.
+func (opts ListOptions) Select(items interface{}, fields ListFields) (string, int) {
+	slice := reflect.ValueOf(items).Elem()
+
+	selected := reflect.MakeSlice(slice.Type(), 0, slice.Len())
+	for i := 0; i < slice.Len(); i++ {
+		if opts.matches(slice.Index(i).Interface(), fields) {
+			selected = reflect.Append(selected, slice.Index(i))
+		}
+	}
+
+	if opts.Sort != "" {
+		field := fields[opts.Sort]
+		keys := make([]string, selected.Len())
+		for i := range keys {
+			if values := field(selected.Index(i).Interface()); len(values) > 0 {
+				keys[i] = values[0]
+			}
+		}
+		swap := reflect.Swapper(selected.Interface())
+		sort.Stable(listSorter{keys: keys, swap: swap, descending: opts.Descending})
+	}
+
+	total := selected.Len()
+	start := opts.Offset
+	if start > total {
+		start = total
+	}
+	end := total
+	var next string
+	if opts.Limit > 0 && start+opts.Limit < total {
+		end = start + opts.Limit
+		next = encodeContinue(end)
+	}
+	slice.Set(selected.Slice(start, end))
+	return next, total
+}
+
+// matches returns true if item has values of all filters.
+func (opts ListOptions) matches(item interface{}, fields ListFields) bool {
+	for name, value := range opts.Filters {
+		found := false
+		for _, v := range fields[name](item) {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ListItems selects items of the slice pointed to by items with
+// options given by query parameters of the request, and returns
+// page with the body, which is the response of the endpoint
+// holding the slice, e.g. api.HostList holding hosts.
+func ListItems(ctx RestContext, items interface{}, fields ListFields, body interface{}) (interface{}, error) {
+	opts, err := ParseListOptions(ctx.QueryVariables, fields)
+	if err != nil {
+		return nil, err
+	}
+	next, total := opts.Select(items, fields)
+	return ListPage{Body: body, Continue: next, Total: total}, nil
+}
+
+// listSorter sorts keys of items along with the items.
+type listSorter struct {
+	keys       []string
+	swap       func(i, j int)
+	descending bool
+}
+
+func (s listSorter) Len() int { return len(s.keys) }
+
+func (s listSorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.swap(i, j)
+}
+
+func (s listSorter) Less(i, j int) bool {
+	if s.descending {
+		i, j = j, i
+	}
+	return compareListKeys(s.keys[i], s.keys[j]) < 0
+}
+
+// compareListKeys compares numbers and IP addresses by their
+// values and other keys as strings.
+func compareListKeys(a, b string) int {
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, y := net.ParseIP(a), net.ParseIP(b); x != nil && y != nil {
+		return bytes.Compare(x.To16(), y.To16())
+	}
+	return strings.Compare(a, b)
+}
+
+// encodeContinue returns continue token for the page starting at offset.
+func encodeContinue(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(continuePrefix + strconv.Itoa(offset)))
+}
+
+// decodeContinue returns offset of the page of continue token.
+func decodeContinue(token string) (int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	s := string(buf)
+	if !strings.HasPrefix(s, continuePrefix) {
+		return 0, fmt.Errorf("not a continue token")
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(s, continuePrefix))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid offset in continue token")
+	}
+	return offset, nil
+}
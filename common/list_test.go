@@ -0,0 +1,108 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+type listItem struct {
+	Name string
+	IP   string
+	Tags []string
+}
+
+var listItemFields = ListFields{
+	"name": func(i interface{}) []string { return []string{i.(listItem).Name} },
+	"ip":   func(i interface{}) []string { return []string{i.(listItem).IP} },
+	"tag":  func(i interface{}) []string { return i.(listItem).Tags },
+}
+
+func TestListItems(t *testing.T) {
+	all := []listItem{
+		{"c", "10.0.0.10", []string{"x"}},
+		{"a", "10.0.0.9", []string{"x", "y"}},
+		{"b", "10.0.0.100", nil},
+	}
+
+	for i, tc := range []struct {
+		query  string
+		expect []string
+		total  int
+		more   bool
+	}{
+		{"", []string{"c", "a", "b"}, 3, false},
+		{"tag=y", []string{"a"}, 1, false},
+		{"tag=x&name=c", []string{"c"}, 1, false},
+		{"sort=name", []string{"a", "b", "c"}, 3, false},
+		{"sort=-name", []string{"c", "b", "a"}, 3, false},
+		{"sort=ip", []string{"a", "c", "b"}, 3, false},
+		{"sort=name&limit=2", []string{"a", "b"}, 3, true},
+		{"sort=name&limit=2&continue=" + encodeContinue(2), []string{"c"}, 3, false},
+		{"limit=5", []string{"c", "a", "b"}, 3, false},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items := append([]listItem(nil), all...)
+		result, err := ListItems(RestContext{QueryVariables: values}, &items, listItemFields, &items)
+		if err != nil {
+			t.Errorf("%d: unexpected error for %s: %s", i, tc.query, err)
+			continue
+		}
+		page := result.(ListPage)
+
+		var names []string
+		for _, item := range *page.Body.(*[]listItem) {
+			names = append(names, item.Name)
+		}
+		if !reflect.DeepEqual(names, tc.expect) {
+			t.Errorf("%d: expected %v for %s, got %v", i, tc.expect, tc.query, names)
+		}
+		if page.Total != tc.total {
+			t.Errorf("%d: expected total %d for %s, got %d", i, tc.total, tc.query, page.Total)
+		}
+		if tc.more != (page.Continue != "") {
+			t.Errorf("%d: expected more pages %t for %s, got continue %q", i, tc.more, tc.query, page.Continue)
+		}
+	}
+}
+
+func TestParseListOptionsErrors(t *testing.T) {
+	for i, query := range []string{
+		"sort=size",
+		"limit=-1",
+		"limit=all",
+		"continue=abc",
+		"continue=" + encodeContinue(-1),
+	} {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ParseListOptions(values, listItemFields)
+		if httpErr, ok := err.(HttpError); !ok || httpErr.StatusCode != 400 {
+			t.Errorf("%d: expected 400 for %s, got %v", i, query, err)
+		}
+	}
+
+	if offset, err := decodeContinue(encodeContinue(42)); err != nil || offset != 42 {
+		t.Errorf("expected offset 42 from continue token, got %d, %v", offset, err)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to
+// configuring logs of Romana components.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/romana/rlog"
+)
+
+// Formats of logs, see Logging.
+const (
+	// LogFormatText logs lines formatted by rlog.
+	LogFormatText = "text"
+
+	// LogFormatJSON logs a JSON object per line, with the time,
+	// level, component, host and request ID fields.
+	LogFormatJSON = "json"
+)
+
+// logLevels are levels of rlog, as they appear in log lines.
+var logLevels = map[string]bool{
+	"critical": true,
+	"error":    true,
+	"warn":     true,
+	"info":     true,
+	"debug":    true,
+	"trace":    true,
+}
+
+// ConfigureLogging configures rlog with logging of the component
+// running on host, hostname of the machine is used when host is
+// empty. It should be called once flags are parsed, before
+// anything is logged.
+func ConfigureLogging(logging Logging, component string, host string) error {
+	if logging.Level != "" {
+		os.Setenv("RLOG_LOG_LEVEL", logging.Level)
+	}
+
+	switch logging.Format {
+	case LogFormatText, "":
+		log.UpdateEnv()
+	case LogFormatJSON:
+		if host == "" {
+			host, _ = os.Hostname()
+		}
+		// Time is a field of JSON lines.
+		os.Setenv("RLOG_LOG_NOTIME", "yes")
+		log.UpdateEnv()
+		log.SetOutput(NewJSONLogWriter(os.Stderr, component, host))
+	default:
+		return fmt.Errorf("unknown log format %s, expected %s or %s", logging.Format, LogFormatText, LogFormatJSON)
+	}
+	return nil
+}
+
+// jsonLogLine is a line of logs in LogFormatJSON.
+type jsonLogLine struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Component string `json:"component"`
+	Host      string `json:"host,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Message   string `json:"msg"`
+}
+
+// jsonLogWriter converts lines written by rlog to JSON.
+type jsonLogWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	component string
+	host      string
+}
+
+// NewJSONLogWriter returns a writer converting lines of rlog
+// into JSON objects of LogFormatJSON written to w. Messages about
+// requests served, starting with "Request <ID>: ", have the ID
+// in the request_id field.
+func NewJSONLogWriter(w io.Writer, component string, host string) io.Writer {
+	return &jsonLogWriter{w: w, component: component, host: host}
+}
+
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	line := jsonLogLine{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Component: w.component,
+		Host:      w.host,
+	}
+	line.Level, line.Message = parseLogLine(string(p))
+	line.RequestID = requestIDOfMessage(line.Message)
+
+	buf, err := json.Marshal(line)
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(append(buf, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// parseLogLine returns level and message of a line logged by rlog,
+// e.g. "WARN     : message" or "TRACE(3)   : message", lines without
+// a level are logged at info level.
+func parseLogLine(s string) (string, string) {
+	s = strings.TrimRight(s, "\n")
+	i := strings.Index(s, ": ")
+	if i < 0 {
+		return "info", s
+	}
+	fields := strings.Fields(s[:i])
+	if len(fields) == 0 {
+		return "info", s[i+2:]
+	}
+	level := strings.ToLower(strings.SplitN(fields[len(fields)-1], "(", 2)[0])
+	if !logLevels[level] {
+		return "info", s
+	}
+	return level, s[i+2:]
+}
+
+// requestIDOfMessage returns ID of the request the message is
+// about, see NewJSONLogWriter, or empty string.
+func requestIDOfMessage(msg string) string {
+	if !strings.HasPrefix(msg, "Request ") {
+		return ""
+	}
+	fields := strings.Fields(msg)
+	if len(fields) < 2 || !strings.HasSuffix(fields[1], ":") {
+		return ""
+	}
+	id := strings.TrimSuffix(fields[1], ":")
+	if !validRequestID(id) {
+		return ""
+	}
+	return id
+}
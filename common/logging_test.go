@@ -0,0 +1,60 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONLogWriter(t *testing.T) {
+	for i, tc := range []struct {
+		line      string
+		level     string
+		message   string
+		requestID string
+	}{
+		{"WARN     : Request cli-1234: POST /hosts from 10.0.0.1:5000: 500 in 1ms\n",
+			"warn", "Request cli-1234: POST /hosts from 10.0.0.1:5000: 500 in 1ms", "cli-1234"},
+		{"2017-10-01T10:00:00Z INFO     : Started\n", "info", "Started", ""},
+		{"TRACE(3)   : Made\n\tdetails\n", "trace", "Made\n\tdetails", ""},
+		{"         : Stopping policy watcher module.\n", "info", "Stopping policy watcher module.", ""},
+		{"no level: here\n", "info", "no level: here", ""},
+	} {
+		var buf bytes.Buffer
+		w := NewJSONLogWriter(&buf, "romanad", "host1")
+		if n, err := w.Write([]byte(tc.line)); err != nil || n != len(tc.line) {
+			t.Fatalf("%d: expected %d bytes written, got %d, %v", i, len(tc.line), n, err)
+		}
+
+		var line jsonLogLine
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("%d: expected JSON line, got %q: %s", i, buf.String(), err)
+		}
+		if line.Level != tc.level || line.Message != tc.message || line.RequestID != tc.requestID {
+			t.Errorf("%d: expected level %q, message %q and request ID %q, got %+v",
+				i, tc.level, tc.message, tc.requestID, line)
+		}
+		if line.Component != "romanad" || line.Host != "host1" || line.Time == "" {
+			t.Errorf("%d: expected component, host and time fields, got %+v", i, line)
+		}
+	}
+
+	if err := ConfigureLogging(Logging{Format: "xml"}, "romanad", ""); err == nil {
+		t.Error("expected error for unknown log format")
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to
+// metrics of REST services.
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// MetricsPath is the path every service publishes
+	// prometheus metrics of requests it serves at.
+	MetricsPath = "/metrics"
+
+	// unmatchedRoute is the route label of requests
+	// that don't match any route of the service.
+	unmatchedRoute = "unmatched"
+)
+
+// Labels of request metrics.
+var requestLabels = []string{"service", "route", "code"}
+
+// Buckets of request and response size histograms, 64B to 1MB.
+var sizeBuckets = prometheus.ExponentialBuckets(64, 4, 8)
+
+var (
+	RequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "romana_http_requests_total",
+			Help: "Number of requests served by service, route and status code.",
+		},
+		requestLabels,
+	)
+	RequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "romana_http_request_duration_seconds",
+			Help:    "Duration of requests by service, route and status code.",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		requestLabels,
+	)
+	RequestSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "romana_http_request_size_bytes",
+			Help:    "Size of request bodies by service, route and status code.",
+			Buckets: sizeBuckets,
+		},
+		requestLabels,
+	)
+	ResponseSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "romana_http_response_size_bytes",
+			Help:    "Size of response bodies by service, route and status code.",
+			Buckets: sizeBuckets,
+		},
+		requestLabels,
+	)
+)
+
+// MetricsRegister registers package global metrics into registry provided,
+// for later exposure.
+func MetricsRegister(registry *prometheus.Registry) error {
+	if registry == nil {
+		return fmt.Errorf("registry must not be nil")
+	}
+
+	for _, collector := range []prometheus.Collector{
+		RequestsTotal,
+		RequestDuration,
+		RequestSize,
+		ResponseSize,
+	} {
+		err := registry.Register(collector)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MetricsRegisterer is implemented by services that have metrics
+// of their own to publish at MetricsPath along with request metrics.
+type MetricsRegisterer interface {
+	MetricsRegister(registry *prometheus.Registry) error
+}
+
+// metricsHandler returns handler publishing request metrics
+// and metrics of the service, if it has any.
+func metricsHandler(service Service) (http.Handler, error) {
+	registry := prometheus.NewRegistry()
+	err := MetricsRegister(registry)
+	if err != nil {
+		return nil, err
+	}
+	if registerer, ok := service.(MetricsRegisterer); ok {
+		err = registerer.MetricsRegister(registry)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.HTTPErrorOnError}), nil
+}
+
+// metricsMiddleware records metrics of requests to the service,
+// labelled by pattern of the route rather than the path, so that
+// the number of label values stays small. It follows the request
+// ID middleware, so that requests rejected by others are counted.
+type metricsMiddleware struct {
+	service string
+	router  *mux.Router
+}
+
+func newMetricsMiddleware(service string, router *mux.Router) metricsMiddleware {
+	return metricsMiddleware{service: service, router: router}
+}
+
+func (m metricsMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	route := routeLabel(m.router, request)
+	start := time.Now()
+	next(writer, request)
+
+	status := http.StatusOK
+	size := 0
+	if rw, ok := writer.(negroni.ResponseWriter); ok {
+		if rw.Status() != 0 {
+			status = rw.Status()
+		}
+		size = rw.Size()
+	}
+	var requestSize int64
+	if request.ContentLength > 0 {
+		requestSize = request.ContentLength
+	}
+
+	labels := []string{m.service, route, strconv.Itoa(status)}
+	RequestsTotal.WithLabelValues(labels...).Inc()
+	RequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	RequestSize.WithLabelValues(labels...).Observe(float64(requestSize))
+	ResponseSize.WithLabelValues(labels...).Observe(float64(size))
+}
+
+// routeLabel returns pattern of the route of router matching
+// the request, or unmatchedRoute if there is none.
+func routeLabel(router *mux.Router, request *http.Request) string {
+	var match mux.RouteMatch
+	if !router.Match(request, &match) || match.Route == nil {
+		return unmatchedRoute
+	}
+	pattern, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return unmatchedRoute
+	}
+	return pattern
+}
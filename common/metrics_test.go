@@ -0,0 +1,77 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+)
+
+func TestRouteLabel(t *testing.T) {
+	router := newRouter(openAPITestService{}.Routes())
+	for _, tc := range []struct {
+		method string
+		path   string
+		expect string
+	}{
+		{"GET", "/items", "/items"},
+		{"DELETE", "/items/10", "/items/{itemID:[0-9]+}"},
+		{"DELETE", "/items/first", unmatchedRoute},
+		{"GET", "/things", unmatchedRoute},
+	} {
+		request := httptest.NewRequest(tc.method, tc.path, nil)
+		if route := routeLabel(router, request); route != tc.expect {
+			t.Errorf("Expected route of %s %s to be %s, got %s", tc.method, tc.path, tc.expect, route)
+		}
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	service := openAPITestService{}
+	router := newRouter(service.Routes())
+	n := negroni.New()
+	n.Use(newMetricsMiddleware("metricstest", router))
+	n.UseHandler(router)
+
+	for _, path := range []string{"/items", "/items", "/things"} {
+		n.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	handler, err := metricsHandler(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", MetricsPath, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d from %s, got %d", http.StatusOK, MetricsPath, recorder.Code)
+	}
+	body, _ := ioutil.ReadAll(recorder.Body)
+	for _, expect := range []string{
+		`romana_http_requests_total{code="200",route="/items",service="metricstest"} 2`,
+		`romana_http_requests_total{code="404",route="unmatched",service="metricstest"} 1`,
+		`romana_http_request_duration_seconds_count{code="200",route="/items",service="metricstest"} 2`,
+	} {
+		if !strings.Contains(string(body), expect) {
+			t.Errorf("Expected %s in metrics, got\n%s", expect, body)
+		}
+	}
+}
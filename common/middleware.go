@@ -19,13 +19,16 @@ package common
 
 import (
 	"bytes"
+	stdcontext "context"
 	"encoding/json"
 
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/romana/core/common/log/trace"
 
@@ -49,6 +52,10 @@ type RestContext struct {
 	// Unique identifier for a request.
 	RequestToken string
 	User         User
+	// RequestID identifies the request in logs, see HeaderRequestID.
+	RequestID string
+	// Context of the request, holding its span, see StartSpan.
+	Context stdcontext.Context
 	// Output of the hook if any run before the execution of the handler.
 	HookOutput string
 }
@@ -103,6 +110,27 @@ type Route struct {
 	UseRequestToken bool
 
 	AuthZChecker AuthZChecker
+
+	// Streaming routes write their response for as long as the
+	// client is connected, see StreamEvents, so they are not cut
+	// off after DefaultTimeout like other routes.
+	Streaming bool
+
+	// Version of the API the route is part of, APIVersion if
+	// empty. Route is served at Pattern prefixed with its version,
+	// e.g. /v1/hosts, see VersionedPath.
+	Version string
+
+	// Unversioned routes, such as health and documentation of
+	// services, are only served at Pattern.
+	Unversioned bool
+
+	// Deprecated routes are still served, but their responses
+	// have Deprecation header, and Sunset header if Sunset is set.
+	Deprecated bool
+
+	// Sunset is when deprecated route may be removed.
+	Sunset time.Time
 }
 
 // Routes provided by each service.
@@ -125,32 +153,31 @@ var requestType = reflect.TypeOf(http.Request{})
 // For comparing to the type of string.
 var stringType = reflect.TypeOf("")
 
-// write500 writes out a 500 error based on provided err
-func write500(writer http.ResponseWriter, m Marshaller, err error) {
-	writer.WriteHeader(http.StatusInternalServerError)
-	httpErr := NewError500(err)
+// writeError writes out the error as the response to the request,
+// with its code, message and ID of the request filled in.
+func writeError(writer http.ResponseWriter, request *http.Request, m Marshaller, httpErr HttpError) {
+	httpErr = httpErr.envelope(RequestID(request))
+	writer.WriteHeader(httpErr.StatusCode)
 	// Should never error out - it's a struct we know.
 	outData, _ := m.Marshal(httpErr)
-	log.Infof("Made\n\t%+v\n\tfrom\n\t%+v\n\t%s", httpErr, err, string(outData))
 	writer.Write(outData)
 }
 
+// write500 writes out a 500 error based on provided err
+func write500(writer http.ResponseWriter, request *http.Request, m Marshaller, err error) {
+	httpErr := NewError500(err)
+	log.Infof("Made\n\t%+v\n\tfrom\n\t%+v", httpErr, err)
+	writeError(writer, request, m, httpErr)
+}
+
 // write400 writes out a 400 error based on provided err
-func write400(writer http.ResponseWriter, m Marshaller, err error) {
-	writer.WriteHeader(http.StatusInternalServerError)
-	httpErr := NewError400(err)
-	// Should never error out - it's a struct we know.
-	outData, _ := m.Marshal(httpErr)
-	writer.Write(outData)
+func write400(writer http.ResponseWriter, request *http.Request, m Marshaller, err error) {
+	writeError(writer, request, m, NewError400(err))
 }
 
 // write403 writes out a 403 error
-func write403(writer http.ResponseWriter, m Marshaller) {
-	writer.WriteHeader(http.StatusForbidden)
-	httpErr := NewError403()
-	// Should never error out - it's a struct we know.
-	outData, _ := m.Marshal(httpErr)
-	writer.Write(outData)
+func write403(writer http.ResponseWriter, request *http.Request, m Marshaller) {
+	writeError(writer, request, m, NewError403())
 }
 
 // wrapHandler wraps the RestHandler function, which deals
@@ -175,7 +202,8 @@ func wrapHandler(restHandler RestHandler, route Route) http.Handler {
 				return
 			}
 			user := context.Get(request, ContextKeyUser).(User)
-			restContext := RestContext{PathVariables: mux.Vars(request), QueryVariables: request.Form, User: user}
+			restContext := RestContext{PathVariables: mux.Vars(request), QueryVariables: request.Form, User: user,
+				RequestID: RequestID(request), Context: request.Context()}
 			respReq := UnwrappedRestHandlerInput{writer, request}
 
 			marshaller := ContentTypeMarshallers["application/json"]
@@ -192,7 +220,7 @@ func wrapHandler(restHandler RestHandler, route Route) http.Handler {
 				userOk = route.AuthZChecker(restContext)
 			}
 			if !userOk {
-				write403(writer, marshaller)
+				write403(writer, request, marshaller)
 				return
 			}
 
@@ -238,7 +266,7 @@ func wrapHandler(restHandler RestHandler, route Route) http.Handler {
 				log.Infof("Read %s\n", bufStr)
 				if err != nil {
 					// Error reading...
-					write500(writer, marshaller, err)
+					write500(writer, request, marshaller, err)
 				}
 
 				if unmarshaller, ok := ContentTypeMarshallers[ct]; ok {
@@ -246,7 +274,7 @@ func wrapHandler(restHandler RestHandler, route Route) http.Handler {
 					log.Tracef(trace.Inside, "httpHandler %s %s: Attempting to unmarshal [%s] into %T: %v", route.Method, route.Pattern, string(buf), inData, err)
 					if err != nil {
 						// Error unmarshalling...
-						write400(writer, marshaller, err)
+						write400(writer, request, marshaller, err)
 						return
 					}
 				} else {
@@ -262,12 +290,14 @@ func wrapHandler(restHandler RestHandler, route Route) http.Handler {
 		err = request.ParseForm()
 		if err != nil {
 			// Cannot parse form...
-			write400(writer, marshaller, err)
+			write400(writer, request, marshaller, err)
 			return
 		}
 
-		var token string
-		if route.UseRequestToken {
+		// idempotency key of the request is its token,
+		// otherwise it is taken from the payload.
+		token := IdempotencyKey(request)
+		if route.UseRequestToken && token == "" {
 			if inData != nil {
 				v := reflect.Indirect(reflect.ValueOf(inData)).FieldByName(RequestTokenQueryParameter)
 				if v.IsValid() {
@@ -301,7 +331,9 @@ func wrapHandler(restHandler RestHandler, route Route) http.Handler {
 		restContext := RestContext{PathVariables: mux.Vars(request),
 			QueryVariables: request.Form,
 			RequestToken:   token,
+			RequestID:      RequestID(request),
 			User:           user,
+			Context:        request.Context(),
 		}
 
 		// Currently disabled authenticator
@@ -317,7 +349,7 @@ func wrapHandler(restHandler RestHandler, route Route) http.Handler {
 		//			userOk = route.AuthZChecker(restContext)
 		//		}
 		//		if !userOk {
-		//			write403(writer, marshaller)
+		//			write403(writer, request, marshaller)
 		//			return
 		//		}
 
@@ -327,6 +359,12 @@ func wrapHandler(restHandler RestHandler, route Route) http.Handler {
 			switch outData := outData.(type) {
 			case Raw:
 				wireData = []byte(outData.Body)
+			case ListPage:
+				if outData.Continue != "" {
+					writer.Header().Set(HeaderContinue, outData.Continue)
+				}
+				writer.Header().Set(HeaderTotalCount, strconv.Itoa(outData.Total))
+				wireData, err = marshaller.Marshal(outData.Body)
 			default:
 				wireData, err = marshaller.Marshal(outData)
 			}
@@ -336,18 +374,15 @@ func wrapHandler(restHandler RestHandler, route Route) http.Handler {
 				writer.Write(wireData)
 				return
 			}
-			write500(writer, marshaller, err)
+			write500(writer, request, marshaller, err)
 			return
 		} else {
 			switch err := err.(type) {
 			case HttpError:
-				writer.WriteHeader(err.StatusCode)
-				// Should never error out - it's a struct we know.
-				outData, _ := marshaller.Marshal(err)
-				writer.Write(outData)
+				writeError(writer, request, marshaller, err)
 			default:
 				// Error reading...
-				write500(writer, marshaller, err)
+				write500(writer, request, marshaller, err)
 			}
 			return
 		}
@@ -400,8 +435,7 @@ func (n notFoundHandler) ServeHTTP(writer http.ResponseWriter, request *http.Req
 			resource += "#..."
 		}
 	}
-	dataOut, _ := marshaller.Marshal(NewError404("URI", resource))
-	http.Error(writer, string(dataOut), http.StatusNotFound)
+	writeError(writer, request, marshaller, NewError404("URI", resource))
 	return
 }
 
@@ -412,10 +446,30 @@ func newRouter(routes []Route) *mux.Router {
 	for _, route := range routes {
 		handler := route.Handler
 		wrappedHandler := wrapHandler(handler, route)
+		if !route.Streaming {
+			wrappedHandler = http.TimeoutHandler(wrappedHandler, DefaultTimeout, TimeoutMessage)
+		}
+		if route.Unversioned {
+			router.
+				Methods(route.Method).
+				Path(route.Pattern).
+				Handler(wrappedHandler)
+			continue
+		}
+
+		version := routeVersion(route)
 		router.
 			Methods(route.Method).
-			Path(route.Pattern).
-			Handler(wrappedHandler)
+			Path(VersionedPath(version, route.Pattern)).
+			Handler(deprecatedHandler(wrappedHandler, route.Deprecated, route.Sunset, ""))
+		if version == APIVersion {
+			// clients of API from before it was versioned
+			// are served the current version, as deprecated.
+			router.
+				Methods(route.Method).
+				Path(route.Pattern).
+				Handler(deprecatedHandler(wrappedHandler, true, route.Sunset, version))
+		}
 	}
 	return router
 }
@@ -618,6 +672,10 @@ func (m UnmarshallerMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request
 	ct := r.Header.Get(HeaderContentType)
 
 	buf, err := ioutil.ReadAll(r.Body)
+	if err, ok := err.(bodyTooLargeError); ok {
+		writeHttpError(w, r, ContentTypeMarshallers["application/json"], http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
@@ -665,7 +723,7 @@ func (p panicRecoveryHandler) ServeHTTP(writer http.ResponseWriter, request *htt
 	defer func() {
 		if err := recover(); err != nil {
 			log.Errorf("Panic occurred: %s", err)
-			write500(writer, ContentTypeMarshallers["application/json"], NewError("Panic: %s", err))
+			write500(writer, request, ContentTypeMarshallers["application/json"], NewError("Panic: %s", err))
 		}
 	}()
 	next(writer, request)
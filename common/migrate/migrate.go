@@ -0,0 +1,258 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package migrate copies romana keys from one prefix of the store to
+// another and transforms them between schema versions, so that upgrades
+// don't require hand-written etcdctl scripts. Migration is planned first,
+// plan can be reviewed (dry run), applied and then verified.
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/romana/core/common/client"
+	log "github.com/romana/rlog"
+)
+
+// Transform converts key and value of the source to key and value
+// of the destination, empty key means the key is not migrated.
+type Transform func(key string, value []byte) (string, []byte, error)
+
+var (
+	transformsMu sync.Mutex
+	transforms   = make(map[string]Transform)
+)
+
+// Register makes transform available by name, e.g. to
+// the migrate command. It panics if name is already taken.
+func Register(name string, transform Transform) {
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+
+	if _, ok := transforms[name]; ok {
+		panic(fmt.Sprintf("migrate: transform %s registered twice", name))
+	}
+	transforms[name] = transform
+}
+
+// Lookup returns transform registered under the name.
+func Lookup(name string) (Transform, error) {
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+
+	transform, ok := transforms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown transform %s, known are %s", name, strings.Join(names(), ", "))
+	}
+	return transform, nil
+}
+
+// names must be called with transformsMu held.
+func names() []string {
+	var result []string
+	for name := range transforms {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func init() {
+	Register("upgrade-schema", UpgradeSchema)
+}
+
+// UpgradeSchema is a transform that upgrades versioned objects,
+// such as policies and IPAM, to the current version of their
+// schema, see client.RegisterSchemaUpgrade. Other keys are kept.
+func UpgradeSchema(key string, value []byte) (string, []byte, error) {
+	kind := client.KindOfKey(key)
+	if kind == "" {
+		return key, value, nil
+	}
+	upgraded, err := client.UpgradeObject(kind, value)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, upgraded, nil
+}
+
+// ephemeralPrefixes hold keys of running processes,
+// such as locks and liveness, which are not migrated.
+var ephemeralPrefixes = []string{"/lock", "/leader", "/txn", client.LivenessPrefix}
+
+// Action is what migration does with a key.
+type Action string
+
+const (
+	// Create means key doesn't exist in the destination.
+	Create Action = "create"
+
+	// Update means key exists in the destination with different
+	// value, it is only overwritten if Migrator.Overwrite is set.
+	Update Action = "update"
+
+	// Unchanged means key exists in the destination with the same value.
+	Unchanged Action = "unchanged"
+)
+
+// Change is a planned change of a key in the destination.
+type Change struct {
+	// Source is the key in the source the change is made from.
+	Source string
+	Key    string
+	Action Action
+	Value  []byte
+}
+
+// Plan is a list of changes sorted by key.
+type Plan struct {
+	Changes []Change
+}
+
+// Count returns number of changes with the action.
+func (p *Plan) Count(action Action) int {
+	n := 0
+	for _, change := range p.Changes {
+		if change.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Migrator migrates keys from Source to Dest, the stores may use
+// the same backend with different prefixes, or the same prefix
+// when keys are only transformed.
+type Migrator struct {
+	Source *client.Store
+	Dest   *client.Store
+
+	// Transforms are applied to every key in order.
+	Transforms []Transform
+
+	// Overwrite allows Apply to replace keys that exist
+	// in the destination with different values.
+	Overwrite bool
+}
+
+// Plan returns changes that migration would make, without
+// making them.
+func (m *Migrator) Plan() (*Plan, error) {
+	source, err := m.Source.ListTree("/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source keys: %s", err)
+	}
+	dest, err := m.Dest.ListTree("/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination keys: %s", err)
+	}
+
+	plan := &Plan{}
+	planned := make(map[string]string)
+	for _, sourceKey := range sortedKeys(source) {
+		if isEphemeral(sourceKey) {
+			continue
+		}
+
+		key, value := sourceKey, source[sourceKey]
+		for _, transform := range m.Transforms {
+			key, value, err = transform(key, value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to transform %s: %s", sourceKey, err)
+			}
+			if key == "" {
+				break
+			}
+		}
+		if key == "" {
+			log.Debugf("Migration drops key %s", sourceKey)
+			continue
+		}
+		if other, ok := planned[key]; ok {
+			return nil, fmt.Errorf("keys %s and %s both migrate to %s", other, sourceKey, key)
+		}
+		planned[key] = sourceKey
+
+		change := Change{Source: sourceKey, Key: key, Value: value, Action: Create}
+		if current, ok := dest[key]; ok {
+			change.Action = Update
+			if bytes.Equal(current, value) {
+				change.Action = Unchanged
+			}
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	sort.Slice(plan.Changes, func(i, j int) bool { return plan.Changes[i].Key < plan.Changes[j].Key })
+	return plan, nil
+}
+
+// Apply makes changes of the plan in the destination. Updates are
+// refused unless Overwrite is set, before anything is written.
+func (m *Migrator) Apply(plan *Plan) error {
+	if n := plan.Count(Update); n > 0 && !m.Overwrite {
+		return fmt.Errorf("%d keys exist in the destination with different values, refusing to overwrite them", n)
+	}
+
+	for _, change := range plan.Changes {
+		if change.Action == Unchanged {
+			continue
+		}
+		if err := m.Dest.PutObject(change.Key, change.Value); err != nil {
+			return fmt.Errorf("failed to write %s: %s", change.Key, err)
+		}
+		log.Infof("Migrated %s to %s (%s)", change.Source, change.Key, change.Action)
+	}
+	return nil
+}
+
+// Verify plans migration again and returns keys of the destination
+// that don't match the source, empty after successful migration.
+func (m *Migrator) Verify() ([]string, error) {
+	plan, err := m.Plan()
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatched []string
+	for _, change := range plan.Changes {
+		if change.Action != Unchanged {
+			mismatched = append(mismatched, change.Key)
+		}
+	}
+	return mismatched, nil
+}
+
+func isEphemeral(key string) bool {
+	for _, prefix := range ephemeralPrefixes {
+		if key == prefix || strings.HasPrefix(key, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(values map[string][]byte) []string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
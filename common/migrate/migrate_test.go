@@ -0,0 +1,128 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package migrate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/client"
+)
+
+func newStore(t *testing.T, values map[string]string) *client.Store {
+	store, err := client.NewStore(&common.Config{Backend: client.BackendMemory, EtcdPrefix: "/romana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range values {
+		if err := store.PutObject(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestMigrator(t *testing.T) {
+	source := newStore(t, map[string]string{
+		"/ipam/data":         "ipam",
+		"/policies/default":  "policy",
+		"/romanavip/service": "vip",
+		"/lock/txn":          "held",
+		"/liveness/host1":    "alive",
+	})
+	defer source.Close()
+	dest := newStore(t, map[string]string{
+		"/ipam/data":         "old",
+		"/romanavip/service": "vip",
+	})
+	defer dest.Close()
+
+	upper := func(key string, value []byte) (string, []byte, error) {
+		if strings.HasPrefix(key, "/romanavip") {
+			return "", nil, nil
+		}
+		return key, bytes.ToUpper(value), nil
+	}
+	m := &Migrator{Source: source, Dest: dest, Transforms: []Transform{upper}}
+
+	plan, err := m.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 2 || plan.Count(Create) != 1 || plan.Count(Update) != 1 {
+		t.Fatalf("unexpected plan %+v", plan.Changes)
+	}
+	if c := plan.Changes[1]; c.Key != "/policies/default" || string(c.Value) != "POLICY" {
+		t.Fatalf("expected transformed policy, got %+v", c)
+	}
+
+	if err := m.Apply(plan); err == nil {
+		t.Fatal("expected apply to refuse overwriting /ipam/data")
+	}
+	if mismatched, err := m.Verify(); err != nil || len(mismatched) != 2 {
+		t.Fatalf("expected nothing to be migrated, got %v, %v", mismatched, err)
+	}
+
+	m.Overwrite = true
+	if err := m.Apply(plan); err != nil {
+		t.Fatal(err)
+	}
+	if mismatched, err := m.Verify(); err != nil || len(mismatched) != 0 {
+		t.Fatalf("expected migrated keys to match, got %v, %v", mismatched, err)
+	}
+	if ok, _ := dest.Exists("/lock/txn"); ok {
+		t.Fatal("expected locks not to be migrated")
+	}
+}
+
+func TestTransformRegistry(t *testing.T) {
+	Register("test-identity", func(key string, value []byte) (string, []byte, error) {
+		return key, value, nil
+	})
+	if _, err := Lookup("test-identity"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Lookup("missing"); err == nil {
+		t.Fatal("expected lookup of unknown transform to fail")
+	}
+}
+
+func TestUpgradeSchema(t *testing.T) {
+	store := newStore(t, map[string]string{
+		"/policies/default":  `{"name":"default"}`,
+		"/romanavip/service": "vip",
+	})
+	defer store.Close()
+
+	m := &Migrator{Source: store, Dest: store, Transforms: []Transform{UpgradeSchema}, Overwrite: true}
+	plan, err := m.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Count(Update) != 1 || plan.Count(Unchanged) != 1 {
+		t.Fatalf("expected only policy to be upgraded, got %+v", plan.Changes)
+	}
+	if err := m.Apply(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	kvp, err := store.Get("/policies/default")
+	if err != nil || !strings.Contains(string(kvp.Value), `"apiVersion":"v1"`) {
+		t.Fatalf("expected policy to be stamped with version, got %v, %v", kvp, err)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// OpenAPI documents of services, see https://swagger.io/specification/.
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// OpenAPIVersion is the version of OpenAPI specification
+	// that documents of services follow.
+	OpenAPIVersion = "3.0.0"
+
+	// APIDocsPath is the path every service serves
+	// its OpenAPI document at.
+	APIDocsPath = "/apidocs"
+
+	// APIVersion is the version of romana REST API, the one
+	// of application/vnd.romana.v1+json content type.
+	APIVersion = "v1"
+)
+
+// OpenAPIDoc is an OpenAPI document describing routes of a service.
+type OpenAPIDoc struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       OpenAPIInfo                `json:"info"`
+	Paths      map[string]OpenAPIPathItem `json:"paths"`
+	Components OpenAPIComponents          `json:"components"`
+}
+
+// OpenAPIInfo is metadata of the API.
+type OpenAPIInfo struct {
+	Title   string            `json:"title"`
+	Version string            `json:"version"`
+	License map[string]string `json:"license,omitempty"`
+}
+
+// OpenAPIPathItem maps lower case HTTP methods to
+// operations on a path.
+type OpenAPIPathItem map[string]*OpenAPIOperation
+
+// OpenAPIOperation describes a single route.
+type OpenAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+}
+
+// OpenAPIParameter describes a path variable of a route.
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIRequestBody describes the message a route expects.
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIMediaType is the schema of a body in a content type.
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIResponse describes a response of a route.
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIComponents holds schemas of named types,
+// referred to by OpenAPISchema.Ref.
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas"`
+}
+
+// OpenAPISchema is the schema of a value as it's encoded in JSON,
+// empty schema allows any value.
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+	pathVarRegexp = regexp.MustCompile(`\{([^{}:]+)(:[^{}]*)?\}`)
+)
+
+// openAPIBuilder builds OpenAPI document, keeping
+// schemas of named types it came across.
+type openAPIBuilder struct {
+	doc *OpenAPIDoc
+}
+
+// NewOpenAPIDoc returns OpenAPI document describing routes of
+// the service. Paths, path variables and messages routes expect
+// are taken from routes, responses other than errors are not
+// described as routes don't declare them.
+func NewOpenAPIDoc(service Service) *OpenAPIDoc {
+	b := openAPIBuilder{
+		doc: &OpenAPIDoc{
+			OpenAPI: OpenAPIVersion,
+			Info: OpenAPIInfo{
+				Title:   fmt.Sprintf("Romana %s API", service.Name()),
+				Version: APIVersion,
+				License: map[string]string{
+					"name": "Apache License 2.0",
+					"url":  "https://github.com/romana/core/blob/master/LICENSE",
+				},
+			},
+			Paths:      make(map[string]OpenAPIPathItem),
+			Components: OpenAPIComponents{Schemas: make(map[string]*OpenAPISchema)},
+		},
+	}
+
+	errorSchema := b.schema(reflect.TypeOf(HttpError{}))
+	operationIDs := make(map[string]bool)
+	for _, route := range service.Routes() {
+		// gorilla patterns of path variables, e.g. {id:[0-9]+},
+		// are not part of OpenAPI paths.
+		pattern := pathVarRegexp.ReplaceAllString(route.Pattern, "{$1}")
+		path := pattern
+		if !route.Unversioned {
+			path = VersionedPath(routeVersion(route), pattern)
+		}
+		item := b.doc.Paths[path]
+		if item == nil {
+			item = make(OpenAPIPathItem)
+			b.doc.Paths[path] = item
+		}
+
+		op := &OpenAPIOperation{
+			OperationID: handlerName(route.Handler),
+			Deprecated:  route.Deprecated,
+			Responses: map[string]OpenAPIResponse{
+				"200": {Description: "Success"},
+				"400": errorResponse("Bad request", errorSchema),
+				"404": errorResponse("Not found", errorSchema),
+				"409": errorResponse("Conflict", errorSchema),
+				"500": errorResponse("Unexpected error", errorSchema),
+			},
+		}
+		if route.Streaming {
+			op.Responses["200"] = OpenAPIResponse{
+				Description: "Stream of server-sent events",
+				Content: map[string]OpenAPIMediaType{
+					ContentTypeEventStream: {Schema: &OpenAPISchema{Type: "string"}},
+				},
+			}
+		}
+		if tag := strings.SplitN(strings.TrimPrefix(pattern, "/"), "/", 2)[0]; tag != "" {
+			op.Tags = []string{tag}
+		}
+
+		for _, match := range pathVarRegexp.FindAllStringSubmatch(route.Pattern, -1) {
+			op.Parameters = append(op.Parameters, OpenAPIParameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema:   &OpenAPISchema{Type: "string"},
+			})
+		}
+		// the same handler may serve several routes, e.g.
+		// DELETE /policies and DELETE /policies/{policyID}.
+		if operationIDs[op.OperationID] {
+			for _, p := range op.Parameters {
+				op.OperationID += "By" + strings.Title(p.Name)
+			}
+			if operationIDs[op.OperationID] {
+				op.OperationID = strings.ToLower(route.Method) + path
+			}
+		}
+		operationIDs[op.OperationID] = true
+
+		if route.MakeMessage != nil {
+			msgType := reflect.TypeOf(route.MakeMessage())
+			// Routes consuming requests as they are
+			// don't expect any particular body.
+			if msgType != nil && msgType != requestType {
+				op.RequestBody = &OpenAPIRequestBody{
+					Required: true,
+					Content: map[string]OpenAPIMediaType{
+						"application/json": {Schema: b.schema(msgType)},
+					},
+				}
+			}
+		}
+
+		item[strings.ToLower(route.Method)] = op
+	}
+	return b.doc
+}
+
+// errorResponse returns response with HttpError body.
+func errorResponse(description string, schema *OpenAPISchema) OpenAPIResponse {
+	return OpenAPIResponse{
+		Description: description,
+		Content:     map[string]OpenAPIMediaType{"application/json": {Schema: schema}},
+	}
+}
+
+// handlerName returns name of the function or method of the handler,
+// e.g. addPolicy for github.com/romana/core/server.(*Romanad).addPolicy-fm.
+func handlerName(handler RestHandler) string {
+	f := runtime.FuncForPC(reflect.ValueOf(handler).Pointer())
+	if f == nil {
+		return ""
+	}
+	name := strings.TrimSuffix(f.Name(), "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// schema returns schema of values of type t as they are encoded in JSON,
+// structs that have names are referred to by their schemas in components.
+func (b openAPIBuilder) schema(t reflect.Type) *OpenAPISchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType):
+		return &OpenAPISchema{}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return &OpenAPISchema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16:
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Int32, reflect.Uint32:
+		return &OpenAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &OpenAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &OpenAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes []byte as base64 string.
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+		return &OpenAPISchema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := t.String()
+		if _, ok := b.doc.Components.Schemas[name]; !ok {
+			// placeholder stops recursion of types referring to themselves.
+			b.doc.Components.Schemas[name] = &OpenAPISchema{}
+			b.doc.Components.Schemas[name] = b.structSchema(t)
+		}
+		return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+	}
+	// interfaces can hold any value.
+	return &OpenAPISchema{}
+}
+
+// structSchema returns schema of the struct with its fields as properties,
+// the way encoding/json encodes them. Fields without omitempty are required.
+func (b openAPIBuilder) structSchema(t reflect.Type) *OpenAPISchema {
+	s := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := field.Name
+		omitEmpty := false
+		if tag != "" {
+			opts := strings.Split(tag, ",")
+			if opts[0] != "" {
+				name = opts[0]
+			}
+			for _, opt := range opts[1:] {
+				if opt == "omitempty" {
+					omitEmpty = true
+				}
+			}
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		// fields of embedded structs are encoded as fields of the struct.
+		if field.Anonymous && (tag == "" || strings.HasPrefix(tag, ",")) && fieldType.Kind() == reflect.Struct {
+			embedded := b.structSchema(fieldType)
+			for n, p := range embedded.Properties {
+				if _, ok := s.Properties[n]; !ok {
+					s.Properties[n] = p
+				}
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		s.Properties[name] = b.schema(field.Type)
+		if !omitEmpty {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+// apiDocsRoute returns route serving OpenAPI document of the service.
+func apiDocsRoute(service Service) Route {
+	doc := NewOpenAPIDoc(service)
+	return Route{
+		Method:  http.MethodGet,
+		Pattern: APIDocsPath,
+		Handler: func(input interface{}, ctx RestContext) (interface{}, error) {
+			return doc, nil
+		},
+		Unversioned: true,
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type openAPITestBase struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type openAPITestItem struct {
+	openAPITestBase
+	Created  time.Time          `json:"created"`
+	IP       net.IP             `json:"ip,omitempty"`
+	Labels   map[string]string  `json:"labels,omitempty"`
+	Children []*openAPITestItem `json:"children,omitempty"`
+	Ignored  string             `json:"-"`
+	internal int
+}
+
+type openAPITestService struct{}
+
+func (s openAPITestService) Initialize(config Config) error { return nil }
+func (s openAPITestService) Name() string                   { return "test" }
+func (s openAPITestService) GetAddress() string             { return "" }
+
+func (s openAPITestService) Routes() Routes {
+	return Routes{
+		Route{Method: "GET", Pattern: "/items", Handler: s.listItems},
+		Route{
+			Method:      "POST",
+			Pattern:     "/items",
+			Handler:     s.addItem,
+			MakeMessage: func() interface{} { return &openAPITestItem{} },
+		},
+		Route{Method: "DELETE", Pattern: "/items", Handler: s.deleteItem,
+			MakeMessage: func() interface{} { return &openAPITestItem{} },
+		},
+		Route{Method: "DELETE", Pattern: "/items/{itemID:[0-9]+}", Handler: s.deleteItem},
+	}
+}
+
+func (s openAPITestService) listItems(input interface{}, ctx RestContext) (interface{}, error) {
+	return nil, nil
+}
+
+func (s openAPITestService) addItem(input interface{}, ctx RestContext) (interface{}, error) {
+	return nil, nil
+}
+
+func (s openAPITestService) deleteItem(input interface{}, ctx RestContext) (interface{}, error) {
+	return nil, nil
+}
+
+func TestNewOpenAPIDoc(t *testing.T) {
+	doc := NewOpenAPIDoc(openAPITestService{})
+	if _, err := json.Marshal(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Paths) != 2 {
+		t.Fatalf("expected paths /v1/items and /v1/items/{itemID}, got %v", doc.Paths)
+	}
+	items := doc.Paths["/v1/items"]
+	if items["get"] == nil || items["post"] == nil || items["delete"] == nil {
+		t.Fatalf("expected get, post and delete of /v1/items, got %v", items)
+	}
+	if id := items["post"].OperationID; id != "addItem" {
+		t.Errorf("expected operation addItem, got %s", id)
+	}
+	if tags := items["get"].Tags; !reflect.DeepEqual(tags, []string{"items"}) {
+		t.Errorf("expected tag items, got %v", tags)
+	}
+
+	deleteByID := doc.Paths["/v1/items/{itemID}"]["delete"]
+	if deleteByID == nil {
+		t.Fatalf("expected delete of /v1/items/{itemID}, got %v", doc.Paths)
+	}
+	if deleteByID.OperationID != "deleteItemByItemID" {
+		t.Errorf("expected operation deleteItemByItemID, got %s", deleteByID.OperationID)
+	}
+	if len(deleteByID.Parameters) != 1 || deleteByID.Parameters[0].Name != "itemID" ||
+		deleteByID.Parameters[0].In != "path" {
+		t.Errorf("expected path parameter itemID, got %+v", deleteByID.Parameters)
+	}
+	if deleteByID.RequestBody != nil {
+		t.Errorf("expected no request body, got %+v", deleteByID.RequestBody)
+	}
+
+	body := items["post"].RequestBody
+	if body == nil {
+		t.Fatal("expected request body of POST /v1/items")
+	}
+	ref := body.Content["application/json"].Schema.Ref
+	if ref != "#/components/schemas/common.openAPITestItem" {
+		t.Fatalf("expected reference to common.openAPITestItem, got %s", ref)
+	}
+
+	item := doc.Components.Schemas["common.openAPITestItem"]
+	for name, expect := range map[string]OpenAPISchema{
+		"id":      {Type: "integer"},
+		"name":    {Type: "string"},
+		"created": {Type: "string", Format: "date-time"},
+		"ip":      {Type: "string"},
+	} {
+		if p := item.Properties[name]; p == nil || !reflect.DeepEqual(*p, expect) {
+			t.Errorf("expected property %s %+v, got %+v", name, expect, p)
+		}
+	}
+	if p := item.Properties["labels"]; p == nil || p.AdditionalProperties == nil || p.AdditionalProperties.Type != "string" {
+		t.Errorf("expected labels to be map of strings, got %+v", p)
+	}
+	if p := item.Properties["children"]; p == nil || p.Items == nil || p.Items.Ref != ref {
+		t.Errorf("expected children to refer to the item, got %+v", p)
+	}
+	for _, name := range []string{"Ignored", "internal", "openAPITestBase"} {
+		if _, ok := item.Properties[name]; ok {
+			t.Errorf("unexpected property %s", name)
+		}
+	}
+	if !reflect.DeepEqual(item.Required, []string{"created", "id"}) {
+		t.Errorf("expected created and id to be required, got %v", item.Required)
+	}
+
+	if _, ok := doc.Components.Schemas["common.HttpError"]; !ok {
+		t.Errorf("expected schema of errors")
+	}
+}
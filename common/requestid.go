@@ -0,0 +1,103 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to
+// identifying requests to REST services.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	log "github.com/romana/rlog"
+)
+
+const (
+	// HeaderRequestID is the header identifying a request in
+	// logs of clients and services. Services generate it for
+	// requests that don't have one, and return it in responses.
+	HeaderRequestID = "X-Request-ID"
+
+	// maxRequestIDLength is the longest request ID accepted
+	// from clients, longer ones are replaced.
+	maxRequestIDLength = 128
+)
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		// IDs only have to be unique enough to tell requests apart.
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(buf)
+}
+
+// validRequestID returns true if ID provided by the client can
+// be used as is, i.e. it's not too long and is printable ASCII.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware sets ID of each request in its header,
+// which is kept by the router unlike the context, and logs requests
+// with their IDs once they are served. It must be the first
+// middleware, so that requests rejected by others are logged too.
+type requestIDMiddleware struct{}
+
+func newRequestIDMiddleware() requestIDMiddleware {
+	return requestIDMiddleware{}
+}
+
+func (m requestIDMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	id := request.Header.Get(HeaderRequestID)
+	if !validRequestID(id) {
+		id = NewRequestID()
+		request.Header.Set(HeaderRequestID, id)
+	}
+	writer.Header().Set(HeaderRequestID, id)
+
+	start := time.Now()
+	next(writer, request)
+
+	status := http.StatusOK
+	if rw, ok := writer.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+		status = rw.Status()
+	}
+	if status >= http.StatusInternalServerError {
+		log.Warnf("Request %s: %s %s from %s: %d in %s",
+			id, request.Method, request.URL.Path, request.RemoteAddr, status, time.Since(start))
+	} else {
+		log.Debugf("Request %s: %s %s from %s: %d in %s",
+			id, request.Method, request.URL.Path, request.RemoteAddr, status, time.Since(start))
+	}
+}
+
+// RequestID returns ID of the request served by the service.
+func RequestID(request *http.Request) string {
+	return request.Header.Get(HeaderRequestID)
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	m := newRequestIDMiddleware()
+
+	for i, tc := range []struct {
+		header   string
+		expectID bool
+	}{
+		{"", false},
+		{"cli-1234", true},
+		{"with space", false},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/hosts", nil)
+		if tc.header != "" {
+			request.Header.Set(HeaderRequestID, tc.header)
+		}
+		recorder := httptest.NewRecorder()
+		var seen string
+		m.ServeHTTP(recorder, request, func(w http.ResponseWriter, r *http.Request) {
+			seen = RequestID(r)
+		})
+
+		returned := recorder.Header().Get(HeaderRequestID)
+		if returned == "" || returned != seen {
+			t.Errorf("%d: expected same request ID in handler and response, got %q and %q", i, seen, returned)
+		}
+		if tc.expectID != (returned == tc.header) {
+			t.Errorf("%d: expected ID %q to be kept %t, got %q", i, tc.header, tc.expectID, returned)
+		}
+	}
+}
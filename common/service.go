@@ -19,6 +19,7 @@ package common
 // interfaces.
 
 import (
+	"crypto/tls"
 	clog "log"
 	"net"
 	"net/http"
@@ -108,10 +109,37 @@ type Service interface {
 }
 
 // initNegroni initializes Negroni with all the middleware and starts it.
-func initNegroni(service Service) (*RestServiceInfo, error) {
+func initNegroni(service Service, config Config) (*RestServiceInfo, error) {
 	var err error
+	auth := config.APIAuth
+
+	// every service documents its routes at APIDocsPath,
+	// and reports its health and status.
+	routes := append(service.Routes(), apiDocsRoute(service))
+	routes = append(routes, healthRoutes(service, time.Now())...)
+	if auth.IssuesTokens() {
+		route, err := authRoute(auth)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	// routes time out after DefaultTimeout, unless streaming.
+	router := newRouter(routes)
+	// requests are counted by metricsMiddleware below,
+	// metrics are published at MetricsPath.
+	metrics, err := metricsHandler(service)
+	if err != nil {
+		return nil, err
+	}
+	router.Methods(http.MethodGet).Path(MetricsPath).Handler(metrics)
+
 	// Create negroni
 	negroni := negroni.New()
+	negroni.Use(newRequestIDMiddleware())
+	negroni.Use(newTracingMiddleware(service.Name(), router))
+	negroni.Use(newMetricsMiddleware(service.Name(), router))
+	negroni.Use(newGzipMiddleware())
 	negroni.Use(newPanicRecoveryHandler())
 
 	// Add content-negotiation middleware.
@@ -122,21 +150,57 @@ func initNegroni(service Service) (*RestServiceInfo, error) {
 	// where w is http.ResponseWriter
 	negroni.Use(NewNegotiator())
 
+	// Requests with too large body are rejected
+	// before it is read by the unmarshaller.
+	negroni.Use(newBodyLimitMiddleware(config.RequestLimits.MaxBodySize))
+
 	// Unmarshal data from the content-type format
 	// into a map
 	negroni.Use(NewUnmarshaller())
 
-	//	authMiddleware, err := NewAuthMiddleware(service, config, client)
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	//	negroni.Use(authMiddleware)
+	authMiddleware, err := NewAuthMiddleware(auth)
+	if err != nil {
+		return nil, err
+	}
+	negroni.Use(authMiddleware)
+
+	// mutating calls are audited once their user is known,
+	// including those rejected by rate limiting.
+	if config.Audit.IsEnabled() {
+		audit, err := newAuditMiddleware(service, config.Audit, router)
+		if err != nil {
+			return nil, err
+		}
+		negroni.Use(audit)
+	}
+
+	// rate is limited per user, so it follows authentication.
+	if config.RequestLimits.Rate > 0 {
+		negroni.Use(newRateLimitMiddleware(config.RequestLimits.Rate, config.RequestLimits.Burst))
+	}
+
+	// retries of POST requests with idempotency keys
+	// get the response to the first one.
+	if config.IdempotencyTTL >= 0 {
+		negroni.Use(newIdempotencyMiddleware(service, config.IdempotencyTTL))
+	}
+
+	// clients polling lists send back ETags of responses
+	// they have, to get 304 if those didn't change.
+	negroni.Use(newETagMiddleware())
 
-	router := newRouter(service.Routes())
-	timeoutHandler := http.TimeoutHandler(router, DefaultTimeout, TimeoutMessage)
-	negroni.UseHandler(timeoutHandler)
+	negroni.UseHandler(router)
 
-	svcInfo, err := RunNegroni(negroni, service.GetAddress())
+	var tlsConfig *tls.Config
+	if config.ServerTLS.IsEnabled() {
+		reloader, err := newTLSReloader(config.ServerTLS)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = reloader.TLSConfig()
+	}
+
+	svcInfo, err := RunNegroniTLS(negroni, service.GetAddress(), tlsConfig)
 	return svcInfo, err
 }
 
@@ -152,7 +216,7 @@ func InitializeService(service Service, config Config) (*RestServiceInfo, error)
 		return nil, err
 	}
 
-	svcInfo, err := initNegroni(service)
+	svcInfo, err := initNegroni(service, config)
 	if err != nil {
 		return nil, err
 	}
@@ -165,7 +229,13 @@ func InitializeService(service Service, config Config) (*RestServiceInfo, error)
 // 1. the Handler field of the provided serverConfig should be nil,
 //    because the Handler used will be the n Negroni object.
 func RunNegroni(n *negroni.Negroni, addr string) (*RestServiceInfo, error) {
-	svr := &http.Server{Addr: addr}
+	return RunNegroniTLS(n, addr, nil)
+}
+
+// RunNegroniTLS is same as RunNegroni, except connections
+// are served over TLS unless tlsConfig is nil.
+func RunNegroniTLS(n *negroni.Negroni, addr string, tlsConfig *tls.Config) (*RestServiceInfo, error) {
+	svr := &http.Server{Addr: addr, TLSConfig: tlsConfig}
 	l := clog.New(os.Stderr, "[negroni] ", 0)
 	svr.Handler = n
 	svr.ErrorLog = l
@@ -194,7 +264,8 @@ func (ln tcpKeepAliveListener) Accept() (c net.Conn, err error) {
 
 // ListenAndServe is same as http.ListenAndServe except it returns
 // the address that will be listened on (which is useful when using
-// arbitrary ports).
+// arbitrary ports). Connections are served over TLS when
+// svr.TLSConfig is set.
 // See https://github.com/golang/go/blob/master/src/net/http/server.go
 func ListenAndServe(svr *http.Server) (*RestServiceInfo, error) {
 	log.Infof("Entering ListenAndServe(%p)", svr)
@@ -214,7 +285,11 @@ func ListenAndServe(svr *http.Server) (*RestServiceInfo, error) {
 	go func() {
 		channel <- Starting
 		l.Printf("ListenAndServe(%p): listening on %s (asked for %s)\n", svr, realAddr, svr.Addr)
-		err := svr.Serve(tcpKeepAliveListener{ln.(*net.TCPListener)})
+		var listener net.Listener = tcpKeepAliveListener{ln.(*net.TCPListener)}
+		if svr.TLSConfig != nil {
+			listener = tls.NewListener(listener, svr.TLSConfig)
+		}
+		err := svr.Serve(listener)
 		if err != nil {
 			log.Criticalf("RestService: Fatal error %v", err)
 			os.Exit(255)
@@ -0,0 +1,119 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to TLS
+// termination of REST services.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/romana/rlog"
+)
+
+// tlsReloader holds TLS configuration of a service loaded from
+// files of ServerTLS, and loads it again on SIGHUP. Connections
+// made before the reload keep their configuration.
+type tlsReloader struct {
+	conf ServerTLS
+
+	mu     sync.RWMutex
+	config *tls.Config
+}
+
+// newTLSReloader loads TLS configuration of the service
+// and starts reloading it on SIGHUP.
+func newTLSReloader(conf ServerTLS) (*tlsReloader, error) {
+	r := &tlsReloader{conf: conf}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := r.reload(); err != nil {
+				log.Errorf("Failed to reload TLS certificates, keeping previous ones: %s", err)
+				continue
+			}
+			log.Infof("Reloaded TLS certificates from %s", conf.CertFile)
+		}
+	}()
+	return r, nil
+}
+
+// reload loads TLS configuration from files, current
+// configuration is kept if any of them is invalid.
+func (r *tlsReloader) reload() error {
+	config, err := makeServerTLSConfig(r.conf)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.config = config
+	r.mu.Unlock()
+	return nil
+}
+
+// TLSConfig returns configuration for http.Server, which
+// hands out current configuration to each new connection.
+func (r *tlsReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.config, nil
+		},
+	}
+}
+
+// makeServerTLSConfig loads certificate of the service
+// and CA verifying clients.
+func makeServerTLSConfig(conf ServerTLS) (*tls.Config, error) {
+	if conf.KeyFile == "" {
+		return nil, fmt.Errorf("both certificate and key must be specified")
+	}
+	cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %s", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if conf.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(conf.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %s", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", conf.ClientCAFile)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/romana/core/common/tlstest"
+)
+
+// serveTLS serves requests over TLS with configuration
+// of the reloader, and returns address of the server.
+func serveTLS(t *testing.T, r *tlsReloader) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", r.TLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	return ln.Addr().String()
+}
+
+// servedName returns common name of the certificate served at addr.
+func servedName(t *testing.T, addr string) string {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestTLSReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romana-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := tlstest.WriteCertificate(t, dir, "first")
+	r, err := newTLSReloader(ServerTLS{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTLS(t, r)
+	if name := servedName(t, addr); name != "first" {
+		t.Fatalf("Expected certificate first, got %s", name)
+	}
+
+	// invalid files are not loaded.
+	if err := ioutil.WriteFile(certFile, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err == nil {
+		t.Fatal("Expected error reloading invalid certificate")
+	}
+	if name := servedName(t, addr); name != "first" {
+		t.Fatalf("Expected certificate first after failed reload, got %s", name)
+	}
+
+	secondCert, secondKey := tlstest.WriteCertificate(t, dir, "second")
+	for from, to := range map[string]string{secondCert: certFile, secondKey: keyFile} {
+		if err := os.Rename(from, to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for servedName(t, addr) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("Certificate was not reloaded on SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romana-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := tlstest.WriteCertificate(t, dir, "server")
+	clientCertFile, clientKeyFile := tlstest.WriteCertificate(t, dir, "client")
+	r, err := newTLSReloader(ServerTLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCertFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTLS(t, r)
+
+	serverCA, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AppendCertsFromPEM(serverCA)
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(certs []tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: rootCAs, Certificates: certs},
+		}}
+		resp, err := client.Get("https://" + addr)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err := get(nil); err == nil {
+		t.Error("Expected request without client certificate to fail")
+	}
+	if err := get([]tls.Certificate{clientCert}); err != nil {
+		t.Errorf("Expected request with client certificate to succeed, got %s", err)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to
+// tracing operations across Romana components with OpenTelemetry.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tracerName is the name of the tracer of spans of Romana,
+	// the same for all packages, as components are told apart
+	// by the service name of their traces.
+	tracerName = "github.com/romana/core"
+
+	// tracingShutdownTimeout is how long spans that aren't
+	// exported yet are flushed for when a component exits.
+	tracingShutdownTimeout = 5 * time.Second
+)
+
+// ConfigureTracing configures export of traces of the component,
+// and propagation of their context in W3C Trace Context headers.
+// It returns a function flushing spans that aren't exported yet,
+// which should be called before the component exits. Spans are
+// not recorded when tracing isn't enabled, but their context is
+// still propagated.
+func ConfigureTracing(tracing Tracing, component string) (func(), error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	if !tracing.IsEnabled() {
+		return func() {}, nil
+	}
+
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(tracing.Endpoint)}
+	if tracing.Insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exporter to %s: %s", tracing.Endpoint, err)
+	}
+
+	ratio := tracing.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", component))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		provider.Shutdown(ctx)
+	}, nil
+}
+
+// StartSpan starts a span of the operation, child of the span
+// in ctx if any, and returns it with the context holding it.
+func StartSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, oteltrace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, oteltrace.WithAttributes(attributes...))
+}
+
+// EndSpan ends the span, marking it failed if err is not nil.
+func EndSpan(span oteltrace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectTraceContext sets headers of a request to another
+// component, so that it continues the trace of ctx.
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// tracingMiddleware starts a span of each request, continuing the
+// trace of the client if its context is in headers. The span is in
+// context of the request, see RestContext.Context. It follows the
+// request ID middleware, so that spans have request IDs.
+type tracingMiddleware struct {
+	service string
+	router  *mux.Router
+}
+
+func newTracingMiddleware(service string, router *mux.Router) tracingMiddleware {
+	return tracingMiddleware{service: service, router: router}
+}
+
+func (m tracingMiddleware) ServeHTTP(writer http.ResponseWriter, request *http.Request, next http.HandlerFunc) {
+	ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+	route := routeLabel(m.router, request)
+	ctx, span := otel.Tracer(tracerName).Start(ctx, request.Method+" "+route,
+		oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+		oteltrace.WithAttributes(
+			attribute.String("romana.service", m.service),
+			attribute.String("http.method", request.Method),
+			attribute.String("http.route", route),
+			attribute.String("romana.request_id", RequestID(request)),
+		))
+	defer span.End()
+
+	next(writer, request.WithContext(ctx))
+
+	status := http.StatusOK
+	if rw, ok := writer.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+		status = rw.Status()
+	}
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
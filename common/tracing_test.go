@@ -0,0 +1,59 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+	"github.com/gorilla/mux"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	if _, err := ConfigureTracing(Tracing{}, "tracingtest"); err != nil {
+		t.Fatal(err)
+	}
+
+	var spanContext oteltrace.SpanContext
+	router := mux.NewRouter()
+	router.HandleFunc("/items/{itemID}", func(writer http.ResponseWriter, request *http.Request) {
+		spanContext = oteltrace.SpanContextFromContext(request.Context())
+	})
+	n := negroni.New()
+	n.Use(newTracingMiddleware("tracingtest", router))
+	n.UseHandler(router)
+
+	// Trace of the client continues in the service.
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := httptest.NewRequest("GET", "/items/10", nil)
+	request.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	n.ServeHTTP(httptest.NewRecorder(), request)
+	if spanContext.TraceID().String() != traceID {
+		t.Fatalf("Expected request in trace %s, got %s", traceID, spanContext.TraceID())
+	}
+
+	// and in requests the service sends to others.
+	header := http.Header{}
+	InjectTraceContext(oteltrace.ContextWithSpanContext(context.Background(), spanContext), header)
+	if !strings.Contains(header.Get("traceparent"), traceID) {
+		t.Errorf("Expected trace %s in traceparent header, got %q", traceID, header.Get("traceparent"))
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to
+// versions of REST APIs of services.
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// HeaderDeprecation is set to "true" in responses of deprecated
+	// routes, see https://tools.ietf.org/html/draft-dalal-deprecation-header.
+	HeaderDeprecation = "Deprecation"
+
+	// HeaderSunset has the date after which deprecated
+	// route may be removed, see RFC 8594.
+	HeaderSunset = "Sunset"
+
+	// HeaderLink of responses of deprecated unversioned routes
+	// refers to the same route of the current version.
+	HeaderLink = "Link"
+)
+
+// VersionedPath returns path the route with the pattern
+// is served at in the version of API, e.g. /v1/hosts.
+func VersionedPath(version string, pattern string) string {
+	return "/" + version + pattern
+}
+
+// routeVersion returns version of API the route is part of.
+func routeVersion(route Route) string {
+	if route.Version == "" {
+		return APIVersion
+	}
+	return route.Version
+}
+
+// deprecatedHandler returns handler setting deprecation headers of
+// responses of handler, if deprecated. Responses of unversioned
+// routes refer to the successor route of the version given.
+func deprecatedHandler(handler http.Handler, deprecated bool, sunset time.Time, successor string) http.Handler {
+	if !deprecated {
+		return handler
+	}
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		header := writer.Header()
+		header.Set(HeaderDeprecation, "true")
+		if !sunset.IsZero() {
+			header.Set(HeaderSunset, sunset.UTC().Format(http.TimeFormat))
+		}
+		if successor != "" {
+			header.Add(HeaderLink, fmt.Sprintf(`<%s>; rel="successor-version"`,
+				VersionedPath(successor, request.URL.Path)))
+		}
+		handler.ServeHTTP(writer, request)
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVersionedRoutes(t *testing.T) {
+	sunset := time.Date(2018, time.June, 1, 0, 0, 0, 0, time.UTC)
+	routes := append(openAPITestService{}.Routes(),
+		Route{Method: "GET", Pattern: "/things", Handler: openAPITestService{}.listItems,
+			Version: "v2"},
+		Route{Method: "GET", Pattern: "/olditems", Handler: openAPITestService{}.listItems,
+			Deprecated: true, Sunset: sunset},
+		Route{Method: "GET", Pattern: "/ping", Handler: openAPITestService{}.listItems,
+			Unversioned: true},
+	)
+	router := newRouter(routes)
+
+	for _, tc := range []struct {
+		path        string
+		status      int
+		deprecation string
+		sunset      string
+		link        string
+	}{
+		{path: "/v1/items", status: http.StatusOK},
+		{path: "/items", status: http.StatusOK, deprecation: "true", link: `</v1/items>; rel="successor-version"`},
+		{path: "/v2/things", status: http.StatusOK},
+		// only routes of the current version are served unversioned.
+		{path: "/things", status: http.StatusNotFound},
+		{path: "/v2/items", status: http.StatusNotFound},
+		{path: "/v1/olditems", status: http.StatusOK, deprecation: "true", sunset: "Fri, 01 Jun 2018 00:00:00 GMT"},
+		{path: "/ping", status: http.StatusOK},
+		{path: "/v1/ping", status: http.StatusNotFound},
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", tc.path, nil))
+		if recorder.Code != tc.status {
+			t.Errorf("Expected status %d of %s, got %d", tc.status, tc.path, recorder.Code)
+			continue
+		}
+		header := recorder.Header()
+		if header.Get(HeaderDeprecation) != tc.deprecation || header.Get(HeaderSunset) != tc.sunset ||
+			header.Get(HeaderLink) != tc.link {
+			t.Errorf("Unexpected deprecation headers of %s: %v", tc.path, header)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+// This file in package common has functionality related to
+// notifying webhooks of changes of resources.
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/romana/rlog"
+)
+
+const (
+	// HeaderWebhookEvent of events posted to webhooks has
+	// resource and action of the event, e.g. policy.created.
+	HeaderWebhookEvent = "X-Romana-Event"
+
+	// HeaderWebhookDelivery has ID of the event, which is the
+	// same for all attempts to deliver it.
+	HeaderWebhookDelivery = "X-Romana-Delivery"
+
+	// HeaderWebhookSignature has "sha256=" followed by hex encoded
+	// HMAC-SHA256 of the body keyed with the webhook secret,
+	// it's only set when the secret is configured.
+	HeaderWebhookSignature = "X-Romana-Signature"
+
+	// DefaultWebhookRetries is the number of times delivery
+	// of an event is retried unless configured otherwise.
+	DefaultWebhookRetries = 5
+
+	// webhookTimeout limits each attempt to deliver an event.
+	webhookTimeout = 10 * time.Second
+
+	// webhookQueueSize is the number of events waiting for delivery
+	// to a webhook, events above it go to the dead-letter log.
+	webhookQueueSize = 1000
+
+	// Delays between attempts to deliver an event, the delay
+	// doubles after each attempt up to webhookMaxRetryDelay.
+	webhookRetryDelay    = time.Second
+	webhookMaxRetryDelay = time.Minute
+)
+
+// Resources and actions of webhook events.
+const (
+	ResourcePolicy     = "policy"
+	ResourceHost       = "host"
+	ResourceTopology   = "topology"
+	ResourceAllocation = "allocation"
+
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionDeleted = "deleted"
+)
+
+// WebhookEvent is posted to webhooks when a resource changes.
+type WebhookEvent struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Service  string    `json:"service"`
+	Resource string    `json:"resource"`
+	Action   string    `json:"action"`
+	// Name identifies the resource, e.g. ID of a policy
+	// or name of a host.
+	Name string `json:"name"`
+	// Object is the resource as it was created or updated.
+	Object interface{} `json:"object,omitempty"`
+
+	// User and RequestID identify the request that made the change.
+	User      string `json:"user,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// NewWebhookEvent returns event of the change of a resource
+// made by the request of the context.
+func NewWebhookEvent(ctx RestContext, resource string, action string, name string, object interface{}) WebhookEvent {
+	return WebhookEvent{
+		Resource:  resource,
+		Action:    action,
+		Name:      name,
+		Object:    object,
+		User:      ctx.User.Username,
+		RequestID: ctx.RequestID,
+	}
+}
+
+// deadLetter is a record of the dead-letter log.
+type deadLetter struct {
+	Time  time.Time    `json:"time"`
+	URL   string       `json:"url"`
+	Error string       `json:"error"`
+	Event WebhookEvent `json:"event"`
+}
+
+// webhook is an URL events are posted to in the order
+// they are queued.
+type webhook struct {
+	url   string
+	queue chan WebhookEvent
+}
+
+// Notifier posts events to webhooks, retrying failed deliveries.
+// Events that can't be delivered are logged and appended to the
+// dead-letter log. Nil Notifier, returned when webhooks are not
+// configured, ignores events.
+type Notifier struct {
+	service    string
+	secret     []byte
+	retries    int
+	retryDelay time.Duration
+	client     *http.Client
+	webhooks   []webhook
+
+	deadLetterMutex sync.Mutex
+	deadLetters     io.Writer
+}
+
+// NewNotifier returns notifier of the service posting events
+// to webhooks in config, nil if none are configured.
+func NewNotifier(service string, config Webhooks) (*Notifier, error) {
+	if !config.IsEnabled() {
+		return nil, nil
+	}
+
+	n := &Notifier{
+		service:    service,
+		retries:    config.Retries,
+		retryDelay: webhookRetryDelay,
+		client:     &http.Client{Timeout: webhookTimeout},
+	}
+	if config.SecretFile != "" {
+		secret, err := ioutil.ReadFile(config.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		n.secret = bytes.TrimSpace(secret)
+		if len(n.secret) == 0 {
+			return nil, NewError("Webhook secret file %s is empty", config.SecretFile)
+		}
+	}
+	if config.DeadLetterFile != "" {
+		f, err := os.OpenFile(config.DeadLetterFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		n.deadLetters = f
+	}
+
+	for _, u := range strings.Split(config.URLs, ",") {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, NewError("Invalid webhook URL %s", u)
+		}
+		n.webhooks = append(n.webhooks, webhook{url: u, queue: make(chan WebhookEvent, webhookQueueSize)})
+	}
+	for _, hook := range n.webhooks {
+		go n.deliver(hook)
+	}
+	return n, nil
+}
+
+// Notify queues the event for delivery to all webhooks, it doesn't
+// block, events that don't fit into the queue of a webhook go to the
+// dead-letter log.
+func (n *Notifier) Notify(event WebhookEvent) {
+	if n == nil {
+		return
+	}
+	event.ID = NewRequestID()
+	event.Time = time.Now().UTC()
+	event.Service = n.service
+	for _, hook := range n.webhooks {
+		select {
+		case hook.queue <- event:
+		default:
+			n.deadLetter(hook.url, event, NewError("Queue of webhook is full"))
+		}
+	}
+}
+
+// deliver posts events queued for the webhook until delivered
+// or retries run out.
+func (n *Notifier) deliver(hook webhook) {
+	for event := range hook.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			n.deadLetter(hook.url, event, err)
+			continue
+		}
+
+		delay := n.retryDelay
+		for attempt := 0; ; attempt++ {
+			err = n.post(hook.url, event, body)
+			if err == nil || attempt >= n.retries {
+				break
+			}
+			log.Warnf("Failed to post event %s to webhook %s, retrying in %s: %s", event.ID, hook.url, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+			if delay > webhookMaxRetryDelay {
+				delay = webhookMaxRetryDelay
+			}
+		}
+		if err != nil {
+			n.deadLetter(hook.url, event, err)
+		}
+	}
+}
+
+// post posts the event to the webhook once.
+func (n *Notifier) post(target string, event WebhookEvent, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderWebhookEvent, event.Resource+"."+event.Action)
+	request.Header.Set(HeaderWebhookDelivery, event.ID)
+	if n.secret != nil {
+		request.Header.Set(HeaderWebhookSignature, webhookSignature(n.secret, body))
+	}
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return NewError("Webhook returned %s", response.Status)
+	}
+	return nil
+}
+
+// deadLetter logs the event that couldn't be delivered to the
+// webhook and appends it to the dead-letter log.
+func (n *Notifier) deadLetter(target string, event WebhookEvent, cause error) {
+	log.Errorf("Failed to deliver event %s (%s.%s %s) to webhook %s: %s",
+		event.ID, event.Resource, event.Action, event.Name, target, cause)
+	if n.deadLetters == nil {
+		return
+	}
+
+	record, err := json.Marshal(deadLetter{Time: time.Now().UTC(), URL: target, Error: cause.Error(), Event: event})
+	if err != nil {
+		log.Errorf("Failed to write event %s to dead-letter log: %s", event.ID, err)
+		return
+	}
+	n.deadLetterMutex.Lock()
+	defer n.deadLetterMutex.Unlock()
+	if _, err := n.deadLetters.Write(append(record, '\n')); err != nil {
+		log.Errorf("Failed to write event %s to dead-letter log: %s", event.ID, err)
+	}
+}
+
+// webhookSignature returns signature of the body of an event,
+// receivers compute it the same way to verify the event.
+func webhookSignature(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	deliveries := make(chan delivery, 10)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// first attempt fails, so that delivery is retried.
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		deliveries <- delivery{header: r.Header, body: body}
+	}))
+	defer server.Close()
+
+	n, err := NewNotifier("romanad", Webhooks{URLs: server.URL, SecretFile: secretFile, Retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.retryDelay = time.Millisecond
+
+	ctx := RestContext{User: User{Username: "admin"}, RequestID: "req1"}
+	n.Notify(NewWebhookEvent(ctx, ResourceHost, ActionCreated, "host1", nil))
+
+	var d delivery
+	select {
+	case d = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	if sig := d.header.Get(HeaderWebhookSignature); sig != webhookSignature([]byte("s3cret"), d.body) {
+		t.Errorf("Unexpected signature %s", sig)
+	}
+	if name := d.header.Get(HeaderWebhookEvent); name != "host.created" {
+		t.Errorf("Expected event host.created, got %s", name)
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(d.body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID == "" || event.ID != d.header.Get(HeaderWebhookDelivery) || event.Service != "romanad" ||
+		event.Name != "host1" || event.User != "admin" || event.RequestID != "req1" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+}
+
+func TestNotifierDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	deadLetterFile := filepath.Join(dir, "dead")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n, err := NewNotifier("romanad", Webhooks{URLs: server.URL, Retries: 1, DeadLetterFile: deadLetterFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.retryDelay = time.Millisecond
+	n.Notify(NewWebhookEvent(RestContext{}, ResourcePolicy, ActionDeleted, "p1", nil))
+
+	var record deadLetter
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		buf, err := ioutil.ReadFile(deadLetterFile)
+		if err == nil && len(buf) > 0 {
+			if err := json.Unmarshal(buf, &record); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	if record.URL != server.URL || record.Event.Name != "p1" || record.Error == "" {
+		t.Errorf("Unexpected dead letter %+v", record)
+	}
+}
+
+func TestNewNotifier(t *testing.T) {
+	n, err := NewNotifier("romanad", Webhooks{})
+	if n != nil || err != nil {
+		t.Errorf("Expected no notifier without webhooks, got %v, %v", n, err)
+	}
+	// nil notifier ignores events.
+	n.Notify(WebhookEvent{})
+
+	if _, err := NewNotifier("romanad", Webhooks{URLs: "ftp://example.com"}); err == nil {
+		t.Error("Expected error for invalid webhook URL")
+	}
+}
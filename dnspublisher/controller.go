@@ -0,0 +1,235 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+// Package dnspublisher publishes DNS records of addresses allocated
+// by romana IPAM, address records of their names and pointer records
+// of their IPs, so that workloads can be resolved by name, and removes
+// them once addresses are deallocated.
+package dnspublisher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/romana/core/common/api"
+
+	log "github.com/romana/rlog"
+)
+
+// DefaultNameTemplate makes DNS names of addresses from their names,
+// e.g. <pod>.<namespace>.<container> for addresses of pods.
+const DefaultNameTemplate = "{{.Name}}"
+
+// Record is the address record of the name, A or AAAA depending
+// on the IP, along with the pointer record of the IP. Name is fully
+// qualified, without the trailing dot.
+type Record struct {
+	Name string
+	IP   net.IP
+}
+
+func (r Record) String() string {
+	return r.Name + " " + r.IP.String()
+}
+
+// Interface publishes records to a DNS server.
+type Interface interface {
+	// Add publishes the record, publishing it
+	// again must not be an error.
+	Add(Record) error
+	// Remove removes the record, removing a record
+	// which is not published must not be an error.
+	Remove(Record) error
+}
+
+// Lister is implemented by publishers which can list records they
+// published, so that records of addresses deallocated while the
+// controller was down are removed once it starts.
+type Lister interface {
+	List() ([]Record, error)
+}
+
+// Controller keeps records of allocated addresses published.
+type Controller struct {
+	publisher Interface
+	zone      string
+	template  *template.Template
+
+	// published are records published by their String,
+	// nil until records are listed or synced first.
+	published map[string]Record
+}
+
+// NewController returns controller publishing records of addresses
+// in the zone, with names made of api.IPAMAddress by nameTemplate,
+// DefaultNameTemplate if empty.
+func NewController(publisher Interface, zone string, nameTemplate string) (*Controller, error) {
+	zone = strings.Trim(zone, ".")
+	if zone == "" {
+		return nil, fmt.Errorf("zone of records is required")
+	}
+	if nameTemplate == "" {
+		nameTemplate = DefaultNameTemplate
+	}
+	tmpl, err := template.New("name").Parse(nameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template %s: %s", nameTemplate, err)
+	}
+	return &Controller{publisher: publisher, zone: zone, template: tmpl}, nil
+}
+
+// recordOf returns record of the address.
+func (c *Controller) recordOf(address api.IPAMAddress) (Record, error) {
+	var buf bytes.Buffer
+	if err := c.template.Execute(&buf, address); err != nil {
+		return Record{}, err
+	}
+	name := makeName(buf.String())
+	if name == "" {
+		return Record{}, fmt.Errorf("no DNS name for address %s", address.Name)
+	}
+	return Record{Name: name + "." + c.zone, IP: address.IP}, nil
+}
+
+// makeName turns name into DNS labels of lower case letters, digits
+// and hyphens, at most 63 of them, not starting or ending with hyphen.
+func makeName(name string) string {
+	var labels []string
+	for _, label := range strings.Split(strings.ToLower(name), ".") {
+		label = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+				return r
+			}
+			return '-'
+		}, label)
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		label = strings.Trim(label, "-")
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// loadPublished lists records published before,
+// if the publisher can list them.
+func (c *Controller) loadPublished() error {
+	c.published = make(map[string]Record)
+	lister, ok := c.publisher.(Lister)
+	if !ok {
+		return nil
+	}
+	records, err := lister.List()
+	if err != nil {
+		c.published = nil
+		return err
+	}
+	for _, r := range records {
+		c.published[r.String()] = r
+	}
+	log.Infof("Found %d published records", len(records))
+	return nil
+}
+
+// Sync publishes records of the addresses, and removes records which
+// were published for addresses which are gone or changed since. Records
+// which failed to be published or removed are retried by the next Sync.
+func (c *Controller) Sync(addresses []api.IPAMAddress) error {
+	if c.published == nil {
+		if err := c.loadPublished(); err != nil {
+			return fmt.Errorf("failed to list published records: %s", err)
+		}
+	}
+
+	desired := make(map[string]Record)
+	for _, address := range addresses {
+		r, err := c.recordOf(address)
+		if err != nil {
+			log.Errorf("Skipping address %s: %s", address.Name, err)
+			continue
+		}
+		desired[r.String()] = r
+	}
+
+	var failed int
+	var firstErr error
+	fail := func(err error) {
+		failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for key, r := range c.published {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		if err := c.publisher.Remove(r); err != nil {
+			fail(fmt.Errorf("failed to remove %s: %s", r, err))
+			continue
+		}
+		log.Infof("Removed %s", r)
+		delete(c.published, key)
+	}
+
+	for key, r := range desired {
+		if _, ok := c.published[key]; ok {
+			continue
+		}
+		if err := c.publisher.Add(r); err != nil {
+			fail(fmt.Errorf("failed to publish %s: %s", r, err))
+			continue
+		}
+		log.Infof("Published %s", r)
+		c.published[key] = r
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d records failed, first: %s", failed, firstErr)
+	}
+	return nil
+}
+
+// Run syncs records with addresses received from the channel until
+// ctx is done, and every resync interval with the last addresses
+// received to retry records which failed.
+func (c *Controller) Run(ctx context.Context, addresses <-chan []api.IPAMAddress, resync time.Duration) {
+	ticker := time.NewTicker(resync)
+	defer ticker.Stop()
+
+	var last []api.IPAMAddress
+	var received bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case last = <-addresses:
+			received = true
+		case <-ticker.C:
+			if !received {
+				continue
+			}
+		}
+		if err := c.Sync(last); err != nil {
+			log.Errorf("Failed to sync DNS records: %s", err)
+		}
+	}
+}
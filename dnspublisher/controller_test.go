@@ -0,0 +1,128 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package dnspublisher
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/romana/core/common/api"
+)
+
+// fakePublisher keeps published records, and fails
+// records of names in fail.
+type fakePublisher struct {
+	records map[string]Record
+	fail    map[string]bool
+}
+
+func newFakePublisher() *fakePublisher {
+	return &fakePublisher{records: make(map[string]Record), fail: make(map[string]bool)}
+}
+
+func (f *fakePublisher) Add(r Record) error {
+	if f.fail[r.Name] {
+		return errors.New("failed")
+	}
+	f.records[r.String()] = r
+	return nil
+}
+
+func (f *fakePublisher) Remove(r Record) error {
+	if f.fail[r.Name] {
+		return errors.New("failed")
+	}
+	delete(f.records, r.String())
+	return nil
+}
+
+func (f *fakePublisher) List() ([]Record, error) {
+	var records []Record
+	for _, r := range f.records {
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func (f *fakePublisher) names() []string {
+	var names []string
+	for key := range f.records {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestController(t *testing.T) {
+	publisher := newFakePublisher()
+	// published before the controller started,
+	// the address was deallocated since.
+	publisher.Add(Record{Name: "gone.pods.example.com", IP: net.ParseIP("10.0.0.9")})
+
+	c, err := NewController(publisher, "pods.example.com.", "{{.Name}}.{{.Tenant}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addresses := []api.IPAMAddress{
+		{Name: "web_1", IP: net.ParseIP("10.0.0.1"), Tenant: "Shop"},
+		{Name: "db", IP: net.ParseIP("fd00::1"), Tenant: "shop"},
+	}
+	publisher.fail["db.shop.pods.example.com"] = true
+	if err := c.Sync(addresses); err == nil {
+		t.Fatal("expected failure of db to be reported")
+	}
+	expect := []string{"web-1.shop.pods.example.com 10.0.0.1"}
+	if got := publisher.names(); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expected %v, got %v", expect, got)
+	}
+
+	// failed record is retried.
+	delete(publisher.fail, "db.shop.pods.example.com")
+	if err := c.Sync(addresses); err != nil {
+		t.Fatal(err)
+	}
+	expect = []string{"db.shop.pods.example.com fd00::1", "web-1.shop.pods.example.com 10.0.0.1"}
+	if got := publisher.names(); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expected %v, got %v", expect, got)
+	}
+
+	// deallocated and reallocated at another IP.
+	addresses = []api.IPAMAddress{{Name: "web_1", IP: net.ParseIP("10.0.0.2"), Tenant: "shop"}}
+	if err := c.Sync(addresses); err != nil {
+		t.Fatal(err)
+	}
+	expect = []string{"web-1.shop.pods.example.com 10.0.0.2"}
+	if got := publisher.names(); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestMakeName(t *testing.T) {
+	testCases := map[string]string{
+		"nginx-1.default.0a1b2c3d": "nginx-1.default.0a1b2c3d",
+		"Web_Server..prod":         "web-server.prod",
+		"-edge-.":                  "edge",
+		"___":                      "",
+	}
+	for name, expect := range testCases {
+		if got := makeName(name); got != expect {
+			t.Errorf("expected %q for %q, got %q", expect, name, got)
+		}
+	}
+}
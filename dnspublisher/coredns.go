@@ -0,0 +1,230 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package dnspublisher
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/client"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// DefaultCoreDNSPath is the default path of the etcd plugin of CoreDNS.
+const DefaultCoreDNSPath = "/skydns"
+
+// coreDNSLeafPrefix starts names of keys of address records,
+// which tells them from keys of records not published by romana.
+const coreDNSLeafPrefix = "romana-"
+
+// CoreDNSConfig configures connection to etcd of CoreDNS,
+// which is usually not the etcd romana keeps its data in.
+type CoreDNSConfig struct {
+	Endpoints []string
+	TLS       common.EtcdTLS
+	Username  string
+	Password  string
+
+	// Path is the path of the etcd plugin,
+	// DefaultCoreDNSPath if empty.
+	Path string
+	// TTL of records, default TTL of the plugin if zero.
+	TTL uint32
+	// Timeout of requests to etcd.
+	Timeout time.Duration
+}
+
+// CoreDNS publishes records to etcd, where the etcd plugin of CoreDNS
+// serves them from. Address record of a name is kept under a key of
+// its own below the key of the name, so that a name with several
+// addresses has all of them. Pointer record is kept under the key of
+// the reverse name of the IP, which is served if CoreDNS is configured
+// with the reverse zone.
+type CoreDNS struct {
+	client *clientv3.Client
+	// kv is the KV API of client records are published through.
+	kv     clientv3.KV
+	config CoreDNSConfig
+}
+
+// coreDNSService is the value of a key of the etcd plugin.
+type coreDNSService struct {
+	Host string `json:"host"`
+	TTL  uint32 `json:"ttl,omitempty"`
+}
+
+// NewCoreDNS connects to etcd of CoreDNS.
+func NewCoreDNS(config CoreDNSConfig) (*CoreDNS, error) {
+	if config.Path == "" {
+		config.Path = DefaultCoreDNSPath
+	}
+	config.Path = "/" + strings.Trim(config.Path, "/")
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	etcdConfig := clientv3.Config{
+		Endpoints:   config.Endpoints,
+		Username:    config.Username,
+		Password:    config.Password,
+		DialTimeout: config.Timeout,
+	}
+	if config.TLS.IsEnabled() {
+		tlsConfig, err := client.MakeTLSConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		etcdConfig.TLS = tlsConfig
+	}
+	etcd, err := clientv3.New(etcdConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to etcd of CoreDNS: %s", err)
+	}
+	return &CoreDNS{client: etcd, kv: etcd, config: config}, nil
+}
+
+// Close closes connection to etcd.
+func (c *CoreDNS) Close() error {
+	return c.client.Close()
+}
+
+// coreDNSKey returns key of the DNS name under path, which
+// has labels of the name in reverse order.
+func coreDNSKey(path string, name string) string {
+	labels := dns.SplitDomainName(name)
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return path + "/" + strings.Join(labels, "/")
+}
+
+// addressKey returns key of the address record of r.
+func (c *CoreDNS) addressKey(r Record) string {
+	ip := r.IP.To4()
+	if ip == nil {
+		ip = r.IP.To16()
+	}
+	return coreDNSKey(c.config.Path, r.Name) + "/" + coreDNSLeafPrefix + hex.EncodeToString(ip)
+}
+
+// pointerKey returns key of the pointer record of r.
+func (c *CoreDNS) pointerKey(r Record) (string, error) {
+	reverse, err := dns.ReverseAddr(r.IP.String())
+	if err != nil {
+		return "", err
+	}
+	return coreDNSKey(c.config.Path, reverse), nil
+}
+
+func (c *CoreDNS) value(host string) (string, error) {
+	b, err := json.Marshal(coreDNSService{Host: host, TTL: c.config.TTL})
+	return string(b), err
+}
+
+// Add implements Interface.
+func (c *CoreDNS) Add(r Record) error {
+	address, err := c.value(r.IP.String())
+	if err != nil {
+		return err
+	}
+	pointer, err := c.value(dns.Fqdn(r.Name))
+	if err != nil {
+		return err
+	}
+	pointerKey, err := c.pointerKey(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+	defer cancel()
+	_, err = c.kv.Txn(ctx).Then(
+		clientv3.OpPut(c.addressKey(r), address),
+		clientv3.OpPut(pointerKey, pointer),
+	).Commit()
+	return err
+}
+
+// Remove implements Interface, pointer record is only removed
+// if it still points to the name of r.
+func (c *CoreDNS) Remove(r Record) error {
+	pointer, err := c.value(dns.Fqdn(r.Name))
+	if err != nil {
+		return err
+	}
+	pointerKey, err := c.pointerKey(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+	defer cancel()
+	if _, err := c.kv.Delete(ctx, c.addressKey(r)); err != nil {
+		return err
+	}
+	_, err = c.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(pointerKey), "=", pointer)).
+		Then(clientv3.OpDelete(pointerKey)).
+		Commit()
+	return err
+}
+
+// List implements Lister, it returns address records published by romana.
+func (c *CoreDNS) List() ([]Record, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+	defer cancel()
+	resp, err := c.kv.Get(ctx, c.config.Path+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, kv := range resp.Kvs {
+		r, ok := parseCoreDNSKey(c.config.Path, string(kv.Key))
+		if ok {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// parseCoreDNSKey returns address record of the key under
+// path, false if it is not a key of an address record.
+func parseCoreDNSKey(path string, key string) (Record, bool) {
+	elems := strings.Split(strings.TrimPrefix(key, path+"/"), "/")
+	if len(elems) < 2 {
+		return Record{}, false
+	}
+	leaf := elems[len(elems)-1]
+	if !strings.HasPrefix(leaf, coreDNSLeafPrefix) {
+		return Record{}, false
+	}
+	ip, err := hex.DecodeString(strings.TrimPrefix(leaf, coreDNSLeafPrefix))
+	if err != nil || (len(ip) != net.IPv4len && len(ip) != net.IPv6len) {
+		return Record{}, false
+	}
+	labels := elems[:len(elems)-1]
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return Record{Name: strings.Join(labels, "."), IP: net.IP(ip)}, true
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package dnspublisher
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romana/core/common/client/clienttest"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestCoreDNSKeys(t *testing.T) {
+	c := &CoreDNS{config: CoreDNSConfig{Path: "/skydns"}}
+	r := Record{Name: "web.shop.pods.example.com", IP: net.ParseIP("10.0.0.1")}
+
+	key := c.addressKey(r)
+	if key != "/skydns/com/example/pods/shop/web/romana-0a000001" {
+		t.Fatalf("unexpected address key %s", key)
+	}
+	parsed, ok := parseCoreDNSKey("/skydns", key)
+	if !ok || parsed.String() != r.String() {
+		t.Fatalf("expected %s parsed from %s, got %s", r, key, parsed)
+	}
+	if _, ok := parseCoreDNSKey("/skydns", "/skydns/com/example/pods/other"); ok {
+		t.Fatal("expected key not published by romana to be skipped")
+	}
+
+	key, err := c.pointerKey(r)
+	if err != nil || key != "/skydns/arpa/in-addr/10/0/0/1" {
+		t.Fatalf("unexpected pointer key %s, %v", key, err)
+	}
+}
+
+// fakeKV keeps keys in memory behind the etcd v3 KV API,
+// its transactions only compare values for equality.
+type fakeKV struct {
+	clientv3.KV
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{data: make(map[string]string)}
+}
+
+func (f *fakeKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	resp, err := f.Do(ctx, clientv3.OpGet(key, opts...))
+	return resp.Get(), err
+}
+
+func (f *fakeKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	resp, err := f.Do(ctx, clientv3.OpDelete(key, opts...))
+	return resp.Del(), err
+}
+
+func (f *fakeKV) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.do(op), nil
+}
+
+func (f *fakeKV) do(op clientv3.Op) clientv3.OpResponse {
+	key := string(op.KeyBytes())
+	end := string(op.RangeBytes())
+	matches := func(k string) bool {
+		if end == "" {
+			return k == key
+		}
+		return k >= key && k < end
+	}
+
+	switch {
+	case op.IsGet():
+		resp := &clientv3.GetResponse{}
+		for k, v := range f.data {
+			if matches(k) {
+				resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte(v)})
+			}
+		}
+		return resp.OpResponse()
+	case op.IsPut():
+		f.data[key] = string(op.ValueBytes())
+		return (&clientv3.PutResponse{}).OpResponse()
+	case op.IsDelete():
+		resp := &clientv3.DeleteResponse{}
+		for k := range f.data {
+			if matches(k) {
+				delete(f.data, k)
+				resp.Deleted++
+			}
+		}
+		return resp.OpResponse()
+	}
+	return clientv3.OpResponse{}
+}
+
+func (f *fakeKV) Txn(ctx context.Context) clientv3.Txn {
+	return &fakeTxn{kv: f}
+}
+
+type fakeTxn struct {
+	kv      *fakeKV
+	cmps    []clientv3.Cmp
+	thenOps []clientv3.Op
+	elseOps []clientv3.Op
+}
+
+func (t *fakeTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.cmps = append(t.cmps, cs...)
+	return t
+}
+
+func (t *fakeTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.thenOps = append(t.thenOps, ops...)
+	return t
+}
+
+func (t *fakeTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	t.elseOps = append(t.elseOps, ops...)
+	return t
+}
+
+func (t *fakeTxn) Commit() (*clientv3.TxnResponse, error) {
+	t.kv.mu.Lock()
+	defer t.kv.mu.Unlock()
+
+	succeeded := true
+	for _, cmp := range t.cmps {
+		if cmp.Target != pb.Compare_VALUE || cmp.Result != pb.Compare_EQUAL {
+			return nil, fmt.Errorf("unsupported comparison %v", cmp)
+		}
+		value, ok := t.kv.data[string(cmp.KeyBytes())]
+		if !ok || value != string(cmp.ValueBytes()) {
+			succeeded = false
+		}
+	}
+
+	ops := t.thenOps
+	if !succeeded {
+		ops = t.elseOps
+	}
+	for _, op := range ops {
+		t.kv.do(op)
+	}
+	return &clientv3.TxnResponse{Succeeded: succeeded}, nil
+}
+
+// coreDNSKV returns KV API of etcd at ROMANA_TEST_ETCD_ENDPOINTS
+// if it is set, or a fake of it, and a function deleting keys
+// under path once the test is done.
+func coreDNSKV(t *testing.T, path string) (clientv3.KV, func()) {
+	endpoints := os.Getenv(clienttest.EnvEtcdEndpoints)
+	if endpoints == "" {
+		return newFakeKV(), func() {}
+	}
+	etcd, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(endpoints, ","), DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return etcd, func() {
+		etcd.Delete(context.Background(), path+"/", clientv3.WithPrefix())
+		etcd.Close()
+	}
+}
+
+func TestCoreDNS(t *testing.T) {
+	path := fmt.Sprintf("/romanaTest%d/skydns", time.Now().UnixNano())
+	kv, done := coreDNSKV(t, path)
+	defer done()
+	c := &CoreDNS{kv: kv, config: CoreDNSConfig{Path: path, TTL: 60, Timeout: 5 * time.Second}}
+	ctx := context.Background()
+
+	pointer := func() (string, bool) {
+		resp, err := kv.Get(ctx, path+"/arpa/in-addr/10/0/0/1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Kvs) == 0 {
+			return "", false
+		}
+		return string(resp.Kvs[0].Value), true
+	}
+
+	web := Record{Name: "web.pods.example.com", IP: net.ParseIP("10.0.0.1")}
+	db := Record{Name: "db.pods.example.com", IP: net.ParseIP("10.0.0.1")}
+	if err := c.Add(web); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := pointer(); value != `{"host":"web.pods.example.com.","ttl":60}` {
+		t.Fatalf("unexpected pointer record %s", value)
+	}
+
+	// IP moved to another name, pointer record of the old one is kept.
+	if err := c.Add(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Remove(web); err != nil {
+		t.Fatal(err)
+	}
+	records, err := c.List()
+	if err != nil || len(records) != 1 || records[0].String() != db.String() {
+		t.Fatalf("expected record %s, got %v, %v", db, records, err)
+	}
+	if value, _ := pointer(); value != `{"host":"db.pods.example.com.","ttl":60}` {
+		t.Fatalf("expected pointer record of %s to be kept, got %s", db, value)
+	}
+
+	if err := c.Remove(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pointer(); ok {
+		t.Fatal("expected pointer record to be removed")
+	}
+	// removing a record which is not published is not an error.
+	if err := c.Remove(db); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package dnspublisher
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultRFC2136TTL is TTL of records published with RFC2136 without one.
+const DefaultRFC2136TTL = 60
+
+// RFC2136Config configures dynamic updates of DNS server.
+type RFC2136Config struct {
+	// Server is host:port of the primary server of the zones,
+	// port 53 is used if omitted.
+	Server string
+	// Zone is the zone of address records.
+	Zone string
+	// ReverseZones are zones of pointer records, e.g.
+	// 10.in-addr.arpa, pointer records of IPs not in
+	// any of them are not published.
+	ReverseZones []string
+	// TTL of records, DefaultRFC2136TTL if zero.
+	TTL uint32
+
+	// TSIGKey is the name of the key updates are signed with,
+	// they are not signed if empty. TSIGSecret is the base64
+	// secret of the key, and TSIGAlgorithm the algorithm of
+	// the key, hmac-sha256 if empty.
+	TSIGKey       string
+	TSIGSecret    string
+	TSIGAlgorithm string
+
+	// Timeout of updates.
+	Timeout time.Duration
+}
+
+// RFC2136 publishes records with dynamic updates (RFC 2136)
+// sent to the primary server of their zones.
+type RFC2136 struct {
+	config RFC2136Config
+	client *dns.Client
+}
+
+// NewRFC2136 returns publisher sending updates to the server.
+func NewRFC2136(config RFC2136Config) (*RFC2136, error) {
+	if config.Server == "" || config.Zone == "" {
+		return nil, fmt.Errorf("server and zone of dynamic updates are required")
+	}
+	if _, _, err := net.SplitHostPort(config.Server); err != nil {
+		config.Server = net.JoinHostPort(config.Server, "53")
+	}
+	config.Zone = dns.Fqdn(config.Zone)
+	reverseZones := make([]string, len(config.ReverseZones))
+	for i, zone := range config.ReverseZones {
+		reverseZones[i] = dns.Fqdn(zone)
+	}
+	config.ReverseZones = reverseZones
+	if config.TTL == 0 {
+		config.TTL = DefaultRFC2136TTL
+	}
+	if config.TSIGAlgorithm == "" {
+		config.TSIGAlgorithm = dns.HmacSHA256
+	}
+	config.TSIGAlgorithm = dns.Fqdn(config.TSIGAlgorithm)
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	client := &dns.Client{Net: "tcp", Timeout: config.Timeout}
+	if config.TSIGKey != "" {
+		config.TSIGKey = dns.Fqdn(config.TSIGKey)
+		client.TsigSecret = map[string]string{config.TSIGKey: config.TSIGSecret}
+	}
+	return &RFC2136{config: config, client: client}, nil
+}
+
+// addressRR returns address record of r.
+func (p *RFC2136) addressRR(r Record) dns.RR {
+	hdr := dns.RR_Header{Name: dns.Fqdn(r.Name), Class: dns.ClassINET, Ttl: p.config.TTL}
+	if ip := r.IP.To4(); ip != nil {
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: ip}
+	}
+	hdr.Rrtype = dns.TypeAAAA
+	return &dns.AAAA{Hdr: hdr, AAAA: r.IP}
+}
+
+// pointerRR returns pointer record of r and its zone,
+// nil if the IP is not in any of reverse zones.
+func (p *RFC2136) pointerRR(r Record) (dns.RR, string) {
+	reverse, err := dns.ReverseAddr(r.IP.String())
+	if err != nil {
+		return nil, ""
+	}
+	var zone string
+	for _, z := range p.config.ReverseZones {
+		if dns.IsSubDomain(z, reverse) && len(z) > len(zone) {
+			zone = z
+		}
+	}
+	if zone == "" {
+		return nil, ""
+	}
+	hdr := dns.RR_Header{Name: reverse, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: p.config.TTL}
+	return &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(r.Name)}, zone
+}
+
+// update sends the update to the server.
+func (p *RFC2136) update(m *dns.Msg) error {
+	if p.config.TSIGKey != "" {
+		m.SetTsig(p.config.TSIGKey, p.config.TSIGAlgorithm, 300, time.Now().Unix())
+	}
+	resp, _, err := p.client.Exchange(m, p.config.Server)
+	if err != nil {
+		return err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("update of %s rejected by %s: %s", m.Question[0].Name, p.config.Server, dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
+
+// Add implements Interface, pointer record of the IP
+// replaces the ones it had.
+func (p *RFC2136) Add(r Record) error {
+	m := new(dns.Msg)
+	m.SetUpdate(p.config.Zone)
+	m.Insert([]dns.RR{p.addressRR(r)})
+	if err := p.update(m); err != nil {
+		return err
+	}
+
+	ptr, zone := p.pointerRR(r)
+	if ptr == nil {
+		return nil
+	}
+	m = new(dns.Msg)
+	m.SetUpdate(zone)
+	m.RemoveRRset([]dns.RR{ptr})
+	m.Insert([]dns.RR{ptr})
+	return p.update(m)
+}
+
+// Remove implements Interface, pointer record is only
+// removed if it still points to the name of r.
+func (p *RFC2136) Remove(r Record) error {
+	m := new(dns.Msg)
+	m.SetUpdate(p.config.Zone)
+	m.Remove([]dns.RR{p.addressRR(r)})
+	if err := p.update(m); err != nil {
+		return err
+	}
+
+	ptr, zone := p.pointerRR(r)
+	if ptr == nil {
+		return nil
+	}
+	m = new(dns.Msg)
+	m.SetUpdate(zone)
+	m.Remove([]dns.RR{ptr})
+	return p.update(m)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+package dnspublisher
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// updateRecorder is a DNS server recording updates
+// it receives, which must be signed.
+type updateRecorder struct {
+	mu      sync.Mutex
+	updates []string
+}
+
+func (u *updateRecorder) ServeDNS(w dns.ResponseWriter, m *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(m)
+	if m.IsTsig() == nil || w.TsigStatus() != nil {
+		resp.Rcode = dns.RcodeNotAuth
+		w.WriteMsg(resp)
+		return
+	}
+
+	u.mu.Lock()
+	for _, rr := range m.Ns {
+		u.updates = append(u.updates, m.Question[0].Name+" "+rr.String())
+	}
+	u.mu.Unlock()
+	w.WriteMsg(resp)
+}
+
+func TestRFC2136(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &updateRecorder{}
+	secret := "c2VjcmV0"
+	server := &dns.Server{
+		Listener:   listener,
+		Handler:    recorder,
+		TsigSecret: map[string]string{"romana.": secret},
+		// default one rejects updates.
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	p, err := NewRFC2136(RFC2136Config{
+		Server:       listener.Addr().String(),
+		Zone:         "pods.example.com",
+		ReverseZones: []string{"in-addr.arpa", "10.in-addr.arpa"},
+		TSIGKey:      "romana",
+		TSIGSecret:   secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := Record{Name: "web.pods.example.com", IP: net.ParseIP("10.0.0.1")}
+	if err := p.Add(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Remove(r); err != nil {
+		t.Fatal(err)
+	}
+
+	wrongKey, err := NewRFC2136(RFC2136Config{
+		Server:     listener.Addr().String(),
+		Zone:       "pods.example.com",
+		TSIGKey:    "romana",
+		TSIGSecret: "d3Jvbmc=",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wrongKey.Add(r); err == nil {
+		t.Fatal("expected update signed with wrong secret to be rejected")
+	}
+
+	expect := []string{
+		"pods.example.com. web.pods.example.com.\t60\tIN\tA\t10.0.0.1",
+		"10.in-addr.arpa. 1.0.0.10.in-addr.arpa.\t0\tCLASS255\tPTR\t",
+		"10.in-addr.arpa. 1.0.0.10.in-addr.arpa.\t60\tIN\tPTR\tweb.pods.example.com.",
+		"pods.example.com. web.pods.example.com.\t0\tNONE\tA\t10.0.0.1",
+		"10.in-addr.arpa. 1.0.0.10.in-addr.arpa.\t0\tNONE\tPTR\tweb.pods.example.com.",
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	updates := recorder.updates
+	recorder.updates = nil
+	if len(updates) != len(expect) {
+		t.Fatalf("expected updates %q, got %q", expect, updates)
+	}
+	for i := range expect {
+		if updates[i] != expect[i] {
+			t.Errorf("expected update %q, got %q", expect[i], updates[i])
+		}
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package dockeripam implements Docker libnetwork remote IPAM driver
+// backed by romana IPAM, so that containers of standalone Docker hosts
+// get addresses from the same networks as Kubernetes pods:
+//
+//	docker network create -d bridge --ipam-driver romana \
+//		--ipam-opt romana.tenant=web --ipam-opt romana.segment=frontend web
+//
+// A pool is a romana network in which addresses of the tenant are
+// allocated on the host, addresses of the pool are allocated in
+// romana IPAM under names unique to the host and released by IP.
+package dockeripam
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/client"
+
+	log "github.com/romana/rlog"
+)
+
+const (
+	// AddressSpace is the only address space of the driver,
+	// both local and global.
+	AddressSpace = "romana"
+
+	// DefaultTenant is used for pools without OptionTenant.
+	DefaultTenant = "default"
+
+	// OptionTenant and OptionSegment are pool options, given
+	// by --ipam-opt, of tenant and segment of addresses.
+	OptionTenant  = "romana.tenant"
+	OptionSegment = "romana.segment"
+
+	// contentType is the content type of plugin API responses.
+	contentType = "application/vnd.docker.plugins.v1+json"
+
+	// requestAddressType is the address option
+	// telling that gateway address is requested.
+	requestAddressType = "RequestAddressType"
+	gatewayAddressType = "com.docker.network.gateway"
+)
+
+type activateResponse struct {
+	Implements []string
+}
+
+type capabilitiesResponse struct {
+	RequiresMACAddress    bool
+	RequiresRequestReplay bool
+}
+
+type addressSpacesResponse struct {
+	LocalDefaultAddressSpace  string
+	GlobalDefaultAddressSpace string
+}
+
+type requestPoolRequest struct {
+	AddressSpace string
+	Pool         string
+	SubPool      string
+	Options      map[string]string
+	V6           bool
+}
+
+type requestPoolResponse struct {
+	PoolID string
+	Pool   string
+	Data   map[string]string
+}
+
+type releasePoolRequest struct {
+	PoolID string
+}
+
+type requestAddressRequest struct {
+	PoolID  string
+	Address string
+	Options map[string]string
+}
+
+type requestAddressResponse struct {
+	Address string
+	Data    map[string]string
+}
+
+type releaseAddressRequest struct {
+	PoolID  string
+	Address string
+}
+
+type errorResponse struct {
+	Err string
+}
+
+// pool is what ID of a pool encodes, so that
+// driver keeps no state of its own.
+type pool struct {
+	Network string
+	Tenant  string
+	Segment string
+}
+
+func (p pool) id() string {
+	return url.Values{
+		"network": {p.Network},
+		"tenant":  {p.Tenant},
+		"segment": {p.Segment},
+	}.Encode()
+}
+
+func parsePoolID(id string) (pool, error) {
+	values, err := url.ParseQuery(id)
+	if err != nil || values.Get("network") == "" || values.Get("tenant") == "" {
+		return pool{}, fmt.Errorf("invalid pool ID %s", id)
+	}
+	return pool{
+		Network: values.Get("network"),
+		Tenant:  values.Get("tenant"),
+		Segment: values.Get("segment"),
+	}, nil
+}
+
+// Driver serves libnetwork IPAM plugin API.
+type Driver struct {
+	client *client.Client
+	host   string
+}
+
+// NewDriver returns driver allocating addresses
+// in romana IPAM of the client on the host.
+func NewDriver(client *client.Client, host string) *Driver {
+	return &Driver{client: client, host: host}
+}
+
+// Handler returns handler of plugin API calls,
+// to be served on the plugin socket.
+func (d *Driver) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, nil, func() (interface{}, error) {
+			return activateResponse{Implements: []string{"IpamDriver"}}, nil
+		})
+	})
+	mux.HandleFunc("/IpamDriver.GetCapabilities", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, nil, func() (interface{}, error) {
+			return capabilitiesResponse{}, nil
+		})
+	})
+	mux.HandleFunc("/IpamDriver.GetDefaultAddressSpaces", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, nil, func() (interface{}, error) {
+			return addressSpacesResponse{
+				LocalDefaultAddressSpace:  AddressSpace,
+				GlobalDefaultAddressSpace: AddressSpace,
+			}, nil
+		})
+	})
+	mux.HandleFunc("/IpamDriver.RequestPool", func(w http.ResponseWriter, r *http.Request) {
+		var req requestPoolRequest
+		serve(w, r, &req, func() (interface{}, error) { return d.requestPool(req) })
+	})
+	mux.HandleFunc("/IpamDriver.ReleasePool", func(w http.ResponseWriter, r *http.Request) {
+		var req releasePoolRequest
+		serve(w, r, &req, func() (interface{}, error) { return d.releasePool(req) })
+	})
+	mux.HandleFunc("/IpamDriver.RequestAddress", func(w http.ResponseWriter, r *http.Request) {
+		var req requestAddressRequest
+		serve(w, r, &req, func() (interface{}, error) { return d.requestAddress(req) })
+	})
+	mux.HandleFunc("/IpamDriver.ReleaseAddress", func(w http.ResponseWriter, r *http.Request) {
+		var req releaseAddressRequest
+		serve(w, r, &req, func() (interface{}, error) { return d.releaseAddress(req) })
+	})
+	return mux
+}
+
+// serve decodes request into req unless it's nil, and writes
+// response returned by f, or its error as plugin API error.
+func serve(w http.ResponseWriter, r *http.Request, req interface{}, f func() (interface{}, error)) {
+	w.Header().Set("Content-Type", contentType)
+
+	var resp interface{}
+	var err error
+	if req != nil {
+		if err = json.NewDecoder(r.Body).Decode(req); err != nil {
+			err = fmt.Errorf("failed to decode %s request: %s", r.URL.Path, err)
+		}
+	}
+	if err == nil {
+		resp, err = f()
+	}
+	if err != nil {
+		log.Errorf("%s: %s", r.URL.Path, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		resp = errorResponse{Err: err.Error()}
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Failed to write %s response: %s", r.URL.Path, err)
+	}
+}
+
+// network returns network of romana IPAM with the name.
+func (d *Driver) network(name string) (*client.Network, error) {
+	network, ok := d.client.IPAM.Networks[name]
+	if !ok {
+		return nil, fmt.Errorf("network %s not found", name)
+	}
+	return network, nil
+}
+
+// requestPool returns the first network of the tenant on the
+// host, or the one with CIDR of the requested pool.
+func (d *Driver) requestPool(req requestPoolRequest) (requestPoolResponse, error) {
+	if req.V6 {
+		return requestPoolResponse{}, fmt.Errorf("IPv6 pools are not supported")
+	}
+	if req.SubPool != "" {
+		return requestPoolResponse{}, fmt.Errorf("sub pools are not supported")
+	}
+	p := pool{Tenant: req.Options[OptionTenant], Segment: req.Options[OptionSegment]}
+	if p.Tenant == "" {
+		p.Tenant = DefaultTenant
+	}
+
+	names, err := d.client.IPAM.NetworksForTenant(p.Tenant, d.host)
+	if err != nil {
+		return requestPoolResponse{}, err
+	}
+	for _, name := range names {
+		network, err := d.network(name)
+		if err != nil {
+			return requestPoolResponse{}, err
+		}
+		cidr := network.CIDR.IPNet.String()
+		if req.Pool != "" && req.Pool != cidr {
+			continue
+		}
+		p.Network = name
+		log.Infof("Pool %s of tenant %s on network %s", cidr, p.Tenant, name)
+		return requestPoolResponse{PoolID: p.id(), Pool: cidr}, nil
+	}
+	return requestPoolResponse{}, fmt.Errorf("pool %s isn't a network of tenant %s on host %s, expected one of %v",
+		req.Pool, p.Tenant, d.host, names)
+}
+
+// releasePool has nothing to release,
+// since a pool is a network of romana.
+func (d *Driver) releasePool(req releasePoolRequest) (struct{}, error) {
+	_, err := parsePoolID(req.PoolID)
+	return struct{}{}, err
+}
+
+// addressName returns name of an address allocated on the host,
+// unique since addresses are released by IP.
+func (d *Driver) addressName() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("docker.%s.%s", d.host, hex.EncodeToString(id)), nil
+}
+
+// requestAddress allocates an address in the network of the pool,
+// the requested one if given.
+func (d *Driver) requestAddress(req requestAddressRequest) (requestAddressResponse, error) {
+	p, err := parsePoolID(req.PoolID)
+	if err != nil {
+		return requestAddressResponse{}, err
+	}
+	network, err := d.network(p.Network)
+	if err != nil {
+		return requestAddressResponse{}, err
+	}
+	name, err := d.addressName()
+	if err != nil {
+		return requestAddressResponse{}, err
+	}
+	if req.Options[requestAddressType] == gatewayAddressType {
+		name += ".gateway"
+	}
+
+	var ip net.IP
+	if req.Address != "" {
+		ip = net.ParseIP(req.Address)
+		if ip == nil || !network.CIDR.IPNet.Contains(ip) {
+			return requestAddressResponse{}, fmt.Errorf("address %s isn't in pool %s", req.Address, network.CIDR.IPNet)
+		}
+		_, err = d.client.IPAM.ImportAddresses([]api.IPAMAddress{{
+			Name:    name,
+			IP:      ip,
+			Host:    d.host,
+			Tenant:  p.Tenant,
+			Segment: p.Segment,
+		}})
+	} else {
+		ip, err = d.client.IPAM.AllocateIP(name, d.host, p.Tenant, p.Segment)
+	}
+	if err != nil {
+		return requestAddressResponse{}, err
+	}
+	if ip == nil {
+		return requestAddressResponse{}, fmt.Errorf("no address available in pool %s", network.CIDR.IPNet)
+	}
+
+	// Tenant with several networks may get an address in another one
+	// when network of the pool is full.
+	if !network.CIDR.IPNet.Contains(ip) {
+		if err := d.client.IPAM.DeallocateIP(name); err != nil {
+			log.Errorf("Failed to release %s allocated outside of pool %s: %s", ip, network.CIDR.IPNet, err)
+		}
+		return requestAddressResponse{}, fmt.Errorf("no address available in pool %s", network.CIDR.IPNet)
+	}
+	log.Infof("Allocated %s as %s", ip, name)
+
+	address := net.IPNet{IP: ip, Mask: network.CIDR.IPNet.Mask}
+	return requestAddressResponse{Address: address.String()}, nil
+}
+
+// releaseAddress deallocates address by its IP.
+func (d *Driver) releaseAddress(req releaseAddressRequest) (struct{}, error) {
+	if _, err := parsePoolID(req.PoolID); err != nil {
+		return struct{}{}, err
+	}
+	ip := net.ParseIP(req.Address)
+	if ip == nil {
+		return struct{}{}, fmt.Errorf("invalid address %s", req.Address)
+	}
+	if err := d.client.IPAM.DeallocateIP(ip.String()); err != nil {
+		return struct{}{}, err
+	}
+	log.Infof("Released %s", ip)
+	return struct{}{}, nil
+}
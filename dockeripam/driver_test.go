@@ -0,0 +1,151 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package dockeripam
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/romana/core/common/client/clienttest"
+)
+
+const topology = `{
+	"networks": [
+		{"name": "net1", "cidr": "10.0.0.0/16", "block_mask": 28},
+		{"name": "net2", "cidr": "10.1.0.0/16", "block_mask": 28}
+	],
+	"topologies": [{"networks": ["net1", "net2"], "map": [{"groups": [
+		{"name": "host1", "ip": "192.168.0.1"},
+		{"name": "host2", "ip": "192.168.0.2"}
+	]}]}]
+}`
+
+// call posts request to the plugin API method, and decodes
+// response into resp, returning error of the response.
+func call(t *testing.T, url string, method string, req interface{}, resp interface{}) string {
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.Post(url+"/"+method, contentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		var e errorResponse
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil || e.Err == "" {
+			t.Fatalf("%s: expected error in response with status %d, got %v", method, r.StatusCode, err)
+		}
+		return e.Err
+	}
+	if resp != nil {
+		if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+			t.Fatalf("%s: %s", method, err)
+		}
+	}
+	return ""
+}
+
+func TestDriver(t *testing.T) {
+	store := clienttest.NewStore(t)
+	defer store.Close()
+	store.SetTopology(topology)
+
+	romanaClient := store.NewClient()
+	server := httptest.NewServer(NewDriver(romanaClient, "host1").Handler())
+	defer server.Close()
+
+	var activate activateResponse
+	if err := call(t, server.URL, "Plugin.Activate", nil, &activate); err != "" {
+		t.Fatal(err)
+	}
+	if len(activate.Implements) != 1 || activate.Implements[0] != "IpamDriver" {
+		t.Fatalf("Expected IpamDriver implemented, got %v", activate.Implements)
+	}
+
+	var pool requestPoolResponse
+	options := map[string]string{OptionTenant: "web", OptionSegment: "frontend"}
+	if err := call(t, server.URL, "IpamDriver.RequestPool", requestPoolRequest{Options: options}, &pool); err != "" {
+		t.Fatal(err)
+	}
+	if pool.Pool != "10.0.0.0/16" {
+		t.Fatalf("Expected pool of the first network, got %s", pool.Pool)
+	}
+	var pool2 requestPoolResponse
+	if err := call(t, server.URL, "IpamDriver.RequestPool", requestPoolRequest{Pool: "10.1.0.0/16", Options: options}, &pool2); err != "" {
+		t.Fatal(err)
+	}
+	if pool2.Pool != "10.1.0.0/16" || pool2.PoolID == pool.PoolID {
+		t.Fatalf("Expected pool of the second network, got %+v", pool2)
+	}
+	if err := call(t, server.URL, "IpamDriver.RequestPool", requestPoolRequest{Pool: "10.2.0.0/16"}, nil); err == "" {
+		t.Fatal("Expected error requesting pool which isn't a network")
+	}
+	if err := call(t, server.URL, "IpamDriver.RequestPool", requestPoolRequest{V6: true}, nil); err == "" {
+		t.Fatal("Expected error requesting IPv6 pool")
+	}
+
+	var address requestAddressResponse
+	if err := call(t, server.URL, "IpamDriver.RequestAddress", requestAddressRequest{PoolID: pool.PoolID}, &address); err != "" {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(address.Address, "10.0.") || !strings.HasSuffix(address.Address, "/16") {
+		t.Fatalf("Expected address in the pool, got %s", address.Address)
+	}
+	var requested requestAddressResponse
+	req := requestAddressRequest{
+		PoolID:  pool.PoolID,
+		Address: "10.0.0.1",
+		Options: map[string]string{requestAddressType: gatewayAddressType},
+	}
+	if err := call(t, server.URL, "IpamDriver.RequestAddress", req, &requested); err != "" {
+		t.Fatal(err)
+	}
+	if requested.Address != "10.0.0.1/16" {
+		t.Fatalf("Expected requested address 10.0.0.1/16, got %s", requested.Address)
+	}
+	req.Address = "10.1.0.1"
+	if err := call(t, server.URL, "IpamDriver.RequestAddress", req, nil); err == "" {
+		t.Fatal("Expected error requesting address outside of the pool")
+	}
+
+	addresses := romanaClient.IPAM.ListAddresses()
+	if len(addresses) != 2 {
+		t.Fatalf("Expected 2 addresses allocated, got %+v", addresses)
+	}
+	for _, addr := range addresses {
+		if !strings.HasPrefix(addr.Name, "docker.host1.") || addr.Host != "host1" || addr.Tenant != "web" || addr.Segment != "frontend" {
+			t.Errorf("Unexpected address %+v", addr)
+		}
+	}
+
+	ip := strings.Split(address.Address, "/")[0]
+	if err := call(t, server.URL, "IpamDriver.ReleaseAddress", releaseAddressRequest{PoolID: pool.PoolID, Address: ip}, nil); err != "" {
+		t.Fatal(err)
+	}
+	if err := call(t, server.URL, "IpamDriver.ReleaseAddress", releaseAddressRequest{PoolID: pool.PoolID, Address: ip}, nil); err == "" {
+		t.Fatal("Expected error releasing address twice")
+	}
+	if err := call(t, server.URL, "IpamDriver.ReleasePool", releasePoolRequest{PoolID: pool.PoolID}, nil); err != "" {
+		t.Fatal(err)
+	}
+}
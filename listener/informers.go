@@ -0,0 +1,388 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+// This file in package listener has shared informers of namespaces,
+// network policies and pods, and workers syncing romana with them.
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	romanaApi "github.com/romana/core/common/api"
+	romanaErrors "github.com/romana/core/common/api/errors"
+	log "github.com/romana/rlog"
+
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/pkg/fields"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// defaultResyncIntervalStr is the default of resyncInterval.
+	defaultResyncIntervalStr = "5m"
+
+	// defaultPolicyPrefix is the prefix of IDs of default
+	// policies, see getDefaultPolicyID.
+	defaultPolicyPrefix = "AllowAllPods2Talk_"
+)
+
+// startInformers starts informers of namespaces, network policies
+// and pods and waits for their caches to sync. Then policies and
+// endpoints are reconciled with the caches, and workers sync
+// changes found by informers until done is closed.
+func (l *KubeListener) startInformers(done <-chan struct{}) {
+	l.namespaceQueue = newWorkQueue("namespace")
+	l.policyQueue = newWorkQueue("networkpolicy")
+	l.podQueue = newWorkQueue("pod")
+	l.deletedNamespaces = make(map[string]*v1.Namespace)
+	l.staleEndpoints = make(map[string]bool)
+
+	coreClient := l.kubeClientSet.CoreV1Client.RESTClient()
+	l.namespaceInformer = l.newInformer(coreClient, "namespaces", &v1.Namespace{})
+	l.policyInformer = l.newInformer(l.kubeClientSet.ExtensionsV1beta1Client.RESTClient(), "networkpolicies", &v1beta1.NetworkPolicy{})
+	l.podInformer = l.newInformer(coreClient, "pods", &v1.Pod{})
+
+	namespaceHandler := queueEventHandler(l.namespaceQueue)
+	namespaceHandler.DeleteFunc = l.namespaceDeleted
+	handlers := []struct {
+		informer cache.SharedIndexInformer
+		handler  cache.ResourceEventHandlerFuncs
+	}{
+		{l.namespaceInformer, namespaceHandler},
+		{l.policyInformer, queueEventHandler(l.policyQueue)},
+		// endpoints of pods are allocated by CNI, listener
+		// only releases ones CNI failed to release.
+		{l.podInformer, cache.ResourceEventHandlerFuncs{DeleteFunc: queueEventHandler(l.podQueue).DeleteFunc}},
+	}
+	for _, h := range handlers {
+		if err := h.informer.AddEventHandler(h.handler); err != nil {
+			log.Criticalf("Failed to add informer event handler: %s", err)
+			os.Exit(255)
+		}
+		go h.informer.Run(done)
+	}
+
+	log.Info("Waiting for namespace, networkpolicy and pod caches to synchronize")
+	if !waitForCacheSync(done, l.namespaceInformer.HasSynced, l.policyInformer.HasSynced, l.podInformer.HasSynced) {
+		return
+	}
+	log.Info("namespace, networkpolicy and pod synchronization completed")
+
+	l.reconcilePolicies()
+	l.reconcileEndpoints()
+
+	go l.namespaceQueue.process(l.syncNamespace)
+	go l.policyQueue.process(l.syncNetworkPolicy)
+	go l.podQueue.process(l.syncPodEndpoints)
+
+	go func() {
+		ticker := time.NewTicker(l.resyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.reconcileEndpoints()
+			case <-done:
+				l.namespaceQueue.ShutDown()
+				l.policyQueue.ShutDown()
+				l.podQueue.ShutDown()
+				return
+			}
+		}
+	}()
+}
+
+// newInformer returns a shared informer of resources of all
+// namespaces, which resyncs them every resyncInterval.
+func (l *KubeListener) newInformer(c cache.Getter, resource string, objType runtime.Object) cache.SharedIndexInformer {
+	watcher := cache.NewListWatchFromClient(c, resource, api.NamespaceAll, fields.Everything())
+	return cache.NewSharedIndexInformer(watcher, objType, l.resyncInterval, cache.Indexers{})
+}
+
+// queueEventHandler returns handler adding keys of
+// added, updated and deleted objects to the queue.
+func queueEventHandler(q *workQueue) cache.ResourceEventHandlerFuncs {
+	add := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			log.Errorf("Failed to get key of %s %v: %s", q.name, obj, err)
+			return
+		}
+		q.Add(key)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    add,
+		UpdateFunc: func(old, obj interface{}) { add(obj) },
+		DeleteFunc: add,
+	}
+}
+
+// waitForCacheSync waits for informers to sync, it returns false
+// if done is closed first and exits on timeout.
+func waitForCacheSync(done <-chan struct{}, synced ...func() bool) bool {
+	timeout := time.After(initialSyncDuration)
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timeout:
+			log.Errorf("timeout after %s while synchronizing informer caches", initialSyncDuration)
+			os.Exit(1)
+		case <-ticker.C:
+			allSynced := true
+			for _, s := range synced {
+				allSynced = allSynced && s()
+			}
+			if allSynced {
+				return true
+			}
+		case <-done:
+			return false
+		}
+	}
+}
+
+// namespaceDeleted keeps the deleted namespace until its key is
+// synced, since its default policy and tenant are found by UID.
+func (l *KubeListener) namespaceDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	ns, ok := obj.(*v1.Namespace)
+	if !ok {
+		log.Errorf("Namespace informer received object of unknown type %T, ignoring", obj)
+		return
+	}
+
+	l.Lock()
+	l.deletedNamespaces[ns.GetName()] = ns
+	l.Unlock()
+	l.namespaceQueue.Add(ns.GetName())
+}
+
+// syncNamespace syncs default policy and tenant of the namespace,
+// or deletes them if the namespace was deleted.
+func (l *KubeListener) syncNamespace(key string) error {
+	l.RLock()
+	deleted := l.deletedNamespaces[key]
+	l.RUnlock()
+
+	if deleted != nil {
+		log.Infof("Deleting default policy and tenant of deleted namespace %s (%s)", deleted.GetName(), deleted.GetUID())
+		if err := deleteDefaultPolicy(deleted, l); err != nil {
+			return err
+		}
+		if err := l.deleteNamespaceTenant(deleted); err != nil {
+			return err
+		}
+		l.Lock()
+		if l.deletedNamespaces[key] == deleted {
+			delete(l.deletedNamespaces, key)
+		}
+		l.Unlock()
+	}
+
+	obj, exists, err := l.namespaceInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+	namespace := obj.(*v1.Namespace)
+	if namespace.Status.Phase == v1.NamespaceTerminating {
+		log.Debugf("Namespace %s is terminating, ignoring", key)
+		return nil
+	}
+	return handleAnnotations(namespace, l)
+}
+
+// syncNetworkPolicy translates the network policy and adds it to
+// the policy service, or updates it there. Policies of the network
+// policy with another UID, e.g. of one deleted, are deleted.
+func (l *KubeListener) syncNetworkPolicy(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	obj, exists, err := l.policyInformer.GetStore().GetByKey(key)
+	if err != nil {
+		return err
+	}
+
+	var policyID string
+	if exists {
+		kubePolicy := obj.(*v1beta1.NetworkPolicy)
+		policy, err := PTranslator.translateNetworkPolicy(kubePolicy)
+		if err != nil {
+			return fmt.Errorf("failed to translate policy: %s", err)
+		}
+		if err := l.applyNetworkPolicy(policy); err != nil {
+			return err
+		}
+		policyID = policy.ID
+	}
+
+	policies, err := l.client.ListPolicies()
+	if err != nil {
+		return err
+	}
+	for _, policy := range policies {
+		if policy.ID == policyID || !isNetworkPolicyID(policy.ID, namespace, name) {
+			continue
+		}
+		if _, err := l.deleteNetworkPolicy(policy.ID); err != nil {
+			return err
+		}
+		log.Infof("Deleted policy %s of network policy %s", policy.ID, key)
+	}
+	return nil
+}
+
+// isNetworkPolicyID returns true if id is an ID of a policy
+// translated from the network policy, see getPolicyID.
+func isNetworkPolicyID(id string, namespace string, name string) bool {
+	prefix := fmt.Sprintf("kube.%s.%s.", namespace, name)
+	return strings.HasPrefix(id, prefix) && !strings.Contains(id[len(prefix):], ".")
+}
+
+// reconcilePolicies deletes policies of network policies and default
+// policies of namespaces that were deleted while the listener wasn't
+// watching, policies of the rest are synced by workers.
+func (l *KubeListener) reconcilePolicies() {
+	var kubePolicies []*v1beta1.NetworkPolicy
+	for _, obj := range l.policyInformer.GetStore().List() {
+		kubePolicies = append(kubePolicies, obj.(*v1beta1.NetworkPolicy))
+	}
+	_, oldPolicies, err := l.syncNetworkPolicies(kubePolicies)
+	if err != nil {
+		log.Errorf("Failed to reconcile romana policies with kubernetes policies: %s", err)
+		return
+	}
+
+	namespaceUIDs := make(map[string]bool)
+	for _, obj := range l.namespaceInformer.GetStore().List() {
+		namespaceUIDs[string(obj.(*v1.Namespace).GetUID())] = true
+	}
+	policies, err := l.client.ListPolicies()
+	if err != nil {
+		log.Errorf("Failed to reconcile default policies with namespaces: %s", err)
+		return
+	}
+	for _, policy := range policies {
+		if !strings.HasPrefix(policy.ID, defaultPolicyPrefix) {
+			continue
+		}
+		uid := strings.TrimSuffix(strings.TrimPrefix(policy.ID, defaultPolicyPrefix), "_")
+		if !namespaceUIDs[uid] {
+			oldPolicies = append(oldPolicies, policy)
+		}
+	}
+
+	log.Infof("Reconciliation found %d obsolete romana policies", len(oldPolicies))
+	for _, policy := range oldPolicies {
+		ok, err := l.deleteNetworkPolicy(policy.ID)
+		if err != nil {
+			log.Errorf("Failed to delete obsolete policy %s: %s", policy.ID, err)
+		}
+		if !ok {
+			log.Tracef(4, "can't delete policy %s, not found", policy.ID)
+		}
+	}
+}
+
+// endpointPod returns namespace and name of the pod the address was
+// allocated to by CNI, see cni.K8sArgs.MakePodName, or false if the
+// address wasn't allocated to a pod.
+func endpointPod(address romanaApi.IPAMAddress) (string, string, bool) {
+	parts := strings.Split(address.Name, ".")
+	if len(parts) < 3 {
+		return "", "", false
+	}
+	namespace := parts[len(parts)-2]
+	name := strings.Join(parts[:len(parts)-2], ".")
+	if name == "" || address.Tenant != GetTenantIDFromNamespaceName(namespace) {
+		return "", "", false
+	}
+	return namespace, name, true
+}
+
+// syncPodEndpoints releases addresses of the pod
+// if it was deleted and CNI didn't release them.
+func (l *KubeListener) syncPodEndpoints(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	_, exists, err := l.podInformer.GetStore().GetByKey(key)
+	if err != nil || exists {
+		return err
+	}
+
+	for _, address := range l.client.IPAM.ListAddresses() {
+		if podNamespace, podName, ok := endpointPod(address); ok && podNamespace == namespace && podName == name {
+			if err := l.releaseEndpoint(address); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reconcileEndpoints releases addresses of pods that are not in the
+// cache, e.g. deleted while the listener wasn't watching. Addresses
+// are released once they are found in two passes in a row, so that
+// ones allocated to pods that the cache doesn't have yet are kept.
+func (l *KubeListener) reconcileEndpoints() {
+	stale := make(map[string]bool)
+	for _, address := range l.client.IPAM.ListAddresses() {
+		namespace, name, ok := endpointPod(address)
+		if !ok {
+			continue
+		}
+		_, exists, err := l.podInformer.GetStore().GetByKey(namespace + "/" + name)
+		if err != nil || exists {
+			continue
+		}
+		if !l.staleEndpoints[address.Name] {
+			stale[address.Name] = true
+			continue
+		}
+		if err := l.releaseEndpoint(address); err != nil {
+			log.Errorf("Failed to release address %s (%s) of pod %s/%s: %s", address.Name, address.IP, namespace, name, err)
+			stale[address.Name] = true
+		}
+	}
+	l.staleEndpoints = stale
+}
+
+// releaseEndpoint deallocates the address of a pod that's gone.
+func (l *KubeListener) releaseEndpoint(address romanaApi.IPAMAddress) error {
+	err := l.client.IPAM.DeallocateIP(address.Name)
+	if _, ok := err.(romanaErrors.RomanaNotFoundError); ok {
+		// released by CNI meanwhile.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("Released address %s (%s) of deleted pod", address.Name, address.IP)
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+import (
+	"testing"
+
+	romanaApi "github.com/romana/core/common/api"
+)
+
+func TestIsNetworkPolicyID(t *testing.T) {
+	tests := []struct {
+		id     string
+		expect bool
+	}{
+		{"kube.default.allow.0d4e2c6b-5b8f", true},
+		{"kube.default.allow.http.0d4e2c6b-5b8f", false},
+		{"kube.default.allow.", true},
+		{"kube.web.allow.0d4e2c6b-5b8f", false},
+		{"AllowAllPods2Talk_0d4e2c6b-5b8f_", false},
+	}
+	for _, tt := range tests {
+		if got := isNetworkPolicyID(tt.id, "default", "allow"); got != tt.expect {
+			t.Errorf("%s: expected %t, got %t", tt.id, tt.expect, got)
+		}
+	}
+}
+
+func TestEndpointPod(t *testing.T) {
+	tests := []struct {
+		address   romanaApi.IPAMAddress
+		namespace string
+		name      string
+		ok        bool
+	}{
+		{romanaApi.IPAMAddress{Name: "nginx.web.01234567", Tenant: "web"}, "web", "nginx", true},
+		{romanaApi.IPAMAddress{Name: "web-0.db.v2.web.01234567", Tenant: "web"}, "web", "web-0.db.v2", true},
+		// not allocated by CNI to a pod.
+		{romanaApi.IPAMAddress{Name: "abcdef.eth0", Tenant: "default"}, "", "", false},
+		{romanaApi.IPAMAddress{Name: "nginx.web.01234567", Tenant: "t1"}, "", "", false},
+		{romanaApi.IPAMAddress{Name: ".web.01234567", Tenant: "web"}, "", "", false},
+	}
+	for _, tt := range tests {
+		namespace, name, ok := endpointPod(tt.address)
+		if ok != tt.ok || namespace != tt.namespace || name != tt.name {
+			t.Errorf("%s: expected %q, %q, %t, got %q, %q, %t", tt.address.Name, tt.namespace, tt.name, tt.ok, namespace, name, ok)
+		}
+	}
+}
@@ -17,6 +17,9 @@
 package listener
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -26,9 +29,13 @@ import (
 	"github.com/romana/core/common"
 	"github.com/romana/core/common/api"
 	"github.com/romana/core/common/client"
+	"github.com/romana/core/common/leader"
 
+	libkvStore "github.com/docker/libkv/store"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/romana/rlog"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -39,6 +46,9 @@ const (
 	initialSyncDuration     = 60 * time.Second
 	initialSyncInterval     = 10 * time.Millisecond
 	defaultNodeAttributes   = "spec.unschedulable"
+
+	// leaderElection is a name of election among listener replicas.
+	leaderElection = "kubernetesListener"
 )
 
 // KubeListener is a Service that listens to updates
@@ -52,17 +62,48 @@ type KubeListener struct {
 	Addr   string
 	client *client.Client
 
-	segmentLabelName    string
-	tenantLabelName     string
-	namespaceBufferSize uint64
+	// LeaderElect enables running several replicas of the listener,
+	// only the elected leader watches kubernetes.
+	LeaderElect bool
+
+	// Kubeconfig is a kubeconfig file used to connect to kubernetes,
+	// in-cluster service account is used when it's empty.
+	Kubeconfig string
+	elector     *leader.Elector
+
+	// BootstrapCIDR, if set, is CIDR of the network of initial topology
+	// generated from nodes when romana has none, see bootstrapTopology.
+	// BootstrapBlockMask is block mask of the network, 0 for default.
+	BootstrapCIDR      string
+	BootstrapBlockMask uint
+
+	segmentLabelName string
+	tenantLabelName  string
 
 	kubeClientSet *kubernetes.Clientset
 
-	// Maintains state about what things have been synchronized.
-	// A mutex is required because of watchers emitting events in
-	// separate goroutines
+	// notifier notifies webhooks of policies
+	// added and deleted by the listener.
+	notifier *common.Notifier
+
+	// resyncInterval is how often informers of namespaces, network
+	// policies and pods resync, and endpoints are reconciled.
+	resyncInterval    time.Duration
+	namespaceInformer cache.SharedIndexInformer
+	policyInformer    cache.SharedIndexInformer
+	podInformer       cache.SharedIndexInformer
+	namespaceQueue    *workQueue
+	policyQueue       *workQueue
+	podQueue          *workQueue
+
+	// Guards deletedNamespaces, a mutex is required because
+	// of informers calling handlers in separate goroutines.
 	sync.RWMutex
-	policiesSynced bool
+	deletedNamespaces map[string]*v1.Namespace
+
+	// staleEndpoints are addresses of pods that were not in
+	// the cache during the last reconciliation of endpoints.
+	staleEndpoints map[string]bool
 
 	nodeStore    cache.Store
 	nodeInformer *cache.Controller
@@ -82,10 +123,59 @@ type KubeListener struct {
 
 // Routes returns various routes used in the service.
 func (l *KubeListener) Routes() common.Routes {
-	routes := common.Routes{}
+	routes := common.Routes{
+		common.Route{
+			Method:  "GET",
+			Pattern: "/leader",
+			Handler: l.getLeader,
+		},
+	}
 	return routes
 }
 
+// getLeader returns leader of listener replicas.
+func (l *KubeListener) getLeader(input interface{}, ctx common.RestContext) (interface{}, error) {
+	if l.elector == nil {
+		return nil, common.NewError404("leader", leaderElection)
+	}
+
+	info, err := l.elector.Leader()
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, common.NewError404("leader", leaderElection)
+	}
+	return info, nil
+}
+
+// Ready implements common.ReadinessChecker, listener is ready
+// when both the store and kubernetes API can be reached.
+func (l *KubeListener) Ready() error {
+	if err := l.client.Store.Ping(); err != nil {
+		return err
+	}
+	if _, err := l.kubeClientSet.Discovery().ServerVersion(); err != nil {
+		return fmt.Errorf("kubernetes API is not reachable: %s", err)
+	}
+	return nil
+}
+
+// MetricsRegister implements common.MetricsRegisterer, leader
+// election and store metrics are published along with request metrics.
+func (l *KubeListener) MetricsRegister(registry *prometheus.Registry) error {
+	if err := leader.MetricsRegister(registry); err != nil {
+		return err
+	}
+	return client.MetricsRegister(registry)
+}
+
+// AuditStore implements common.AuditStorer, audit records
+// are kept in the store listener uses.
+func (l *KubeListener) AuditStore(prefix string, maxAge time.Duration) (common.AuditSink, error) {
+	return client.NewAuditStore(l.client.Store, prefix, maxAge), nil
+}
+
 func (l *KubeListener) GetAddress() string {
 	return l.Addr
 }
@@ -119,6 +209,16 @@ func (l *KubeListener) loadConfig() error {
 		return err
 	}
 
+	var resyncInterval string
+	resyncInterval, err = l.client.Store.GetString(configPrefix+"resyncInterval", defaultResyncIntervalStr)
+	if err != nil {
+		return err
+	}
+	l.resyncInterval, err = time.ParseDuration(resyncInterval)
+	if err != nil {
+		return err
+	}
+
 	var nodeAttrStr string
 	nodeAttrStr, err = l.client.Store.GetString(configPrefix+"nodeAttributes", defaultNodeAttributes)
 	if err != nil {
@@ -139,7 +239,62 @@ var PTranslator Translator
 
 // addNetworkPolicy adds the policy to the policy service.
 func (l *KubeListener) addNetworkPolicy(policy api.Policy) error {
-	return l.client.AddPolicy(policy)
+	if err := l.client.AddPolicy(policy); err != nil {
+		return err
+	}
+	l.notifier.Notify(common.NewWebhookEvent(common.RestContext{},
+		common.ResourcePolicy, common.ActionCreated, policy.ID, policy))
+	return nil
+}
+
+// updateNetworkPolicy replaces the policy in the policy service.
+func (l *KubeListener) updateNetworkPolicy(policy api.Policy) error {
+	if err := l.client.AddPolicy(policy); err != nil {
+		return err
+	}
+	l.notifier.Notify(common.NewWebhookEvent(common.RestContext{},
+		common.ResourcePolicy, common.ActionUpdated, policy.ID, policy))
+	return nil
+}
+
+// applyNetworkPolicy adds the policy to the policy service, or
+// updates it there unless it's the same already.
+func (l *KubeListener) applyNetworkPolicy(policy api.Policy) error {
+	current, _, err := l.client.GetPolicyWithRevision(policy.ID)
+	if err == libkvStore.ErrKeyNotFound {
+		return l.addNetworkPolicy(policy)
+	}
+	if err != nil {
+		return err
+	}
+
+	// policies are compared as JSON, since they may have
+	// empty lists instead of omitted ones, e.g. RomanaPolicy
+	// resources.
+	currentJSON, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(currentJSON, policyJSON) {
+		return nil
+	}
+	return l.updateNetworkPolicy(policy)
+}
+
+// deleteNetworkPolicy deletes the policy, returning
+// false if it's not found.
+func (l *KubeListener) deleteNetworkPolicy(policyID string) (bool, error) {
+	ok, err := l.client.DeletePolicy(policyID)
+	if err != nil || !ok {
+		return ok, err
+	}
+	l.notifier.Notify(common.NewWebhookEvent(common.RestContext{},
+		common.ResourcePolicy, common.ActionDeleted, policyID, nil))
+	return true, nil
 }
 
 func (l *KubeListener) Initialize(clientConfig common.Config) error {
@@ -153,6 +308,10 @@ func (l *KubeListener) Initialize(clientConfig common.Config) error {
 	if err != nil {
 		return err
 	}
+	l.notifier, err = common.NewNotifier(l.Name(), clientConfig.Webhooks)
+	if err != nil {
+		return err
+	}
 	// TODO, find a better place to initialize
 	// the translator. Stas.
 	PTranslator.Init(l.client, l.segmentLabelName, l.tenantLabelName)
@@ -162,8 +321,36 @@ func (l *KubeListener) Initialize(clientConfig common.Config) error {
 		os.Exit(255)
 	}
 
-	// Channel for stopping watching kubernetes events.
-	done := make(chan struct{})
+	if !l.LeaderElect {
+		// Channel for stopping watching kubernetes events.
+		done := make(chan struct{})
+		l.start(done)
+		return nil
+	}
+
+	l.elector, err = leader.NewElector(l.client.Store, leaderElection, "", 0)
+	if err != nil {
+		return err
+	}
+	go l.elector.Run(context.Background(), func(ctx context.Context) {
+		done := make(chan struct{})
+		l.start(done)
+		<-ctx.Done()
+		log.Infof("%s: Stopping, no longer the leader", l.Name())
+		close(done)
+	})
+
+	log.Infof("%s: Campaigning for leadership as %s", l.Name(), l.elector.Identity())
+	return nil
+}
+
+// start starts watching kubernetes until done is closed.
+func (l *KubeListener) start(done chan struct{}) {
+	if l.BootstrapCIDR != "" {
+		if err := l.bootstrapTopology(); err != nil {
+			log.Error(err)
+		}
+	}
 
 	// l.ProcessNodeEvents listens and processes kubernetes node events,
 	// mainly allowing nodes to be added/removed to/from romana cluster
@@ -171,19 +358,15 @@ func (l *KubeListener) Initialize(clientConfig common.Config) error {
 	l.ProcessNodeEvents(done)
 
 	log.Infof("%s: Starting server", l.Name())
-	eventc, err := l.nsWatch(done)
-	if err != nil {
-		log.Critical("Namespace watcher failed to start", err)
-		os.Exit(255)
-	}
+	// Namespaces, network policies and pods are watched by
+	// informers and synced by workers.
+	l.startInformers(done)
 
-	l.process(eventc, done)
-
-	ProduceNewPolicyEvents(eventc, done, l)
+	// RomanaPolicy resources are synced along with network policies.
+	l.startRomanaPolicySync(done)
 
 	l.romanaExposedIPSpecMap = ExposedIPSpecMap{IPForService: make(map[string]api.ExposedIPSpec)}
 	l.startRomanaVIPSync(done)
 
 	log.Info("All routines started")
-	return nil
 }
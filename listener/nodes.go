@@ -24,14 +24,17 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"reflect"
 	"strings"
 	"time"
 
 	"github.com/elgs/gojq"
 	log "github.com/romana/rlog"
 
+	"github.com/romana/core/common"
 	romanaApi "github.com/romana/core/common/api"
 	romanaErrors "github.com/romana/core/common/api/errors"
+	"github.com/romana/core/common/client"
 
 	"github.com/romana/core/common/log/trace"
 	"k8s.io/client-go/kubernetes"
@@ -40,17 +43,54 @@ import (
 	"k8s.io/client-go/pkg/fields"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-func (l *KubeListener) kubeClientInit() error {
-	var err error
+const (
+	// ZoneTag of romana hosts is zone of their nodes.
+	ZoneTag = "zone"
+
+	// RegionTag of romana hosts is region of their nodes.
+	RegionTag = "region"
+
+	// Labels kubernetes sets on nodes with their zone and region,
+	// legacy ones are set by kubernetes before 1.17.
+	zoneLabel         = "topology.kubernetes.io/zone"
+	regionLabel       = "topology.kubernetes.io/region"
+	legacyZoneLabel   = "failure-domain.beta.kubernetes.io/zone"
+	legacyRegionLabel = "failure-domain.beta.kubernetes.io/region"
+
+	// bootstrapNetwork is the name of the network
+	// of topology bootstrapped from nodes.
+	bootstrapNetwork = "default"
+)
+
+// kubeClientConfig returns config of the kubernetes client. In cluster,
+// the client authenticates with the token of the service account of
+// the listener's pod and verifies the API server with its CA, so that
+// the listener is authorized by RBAC roles of the service account.
+// Outside of a cluster, Kubeconfig has to be given.
+func (l *KubeListener) kubeClientConfig() (*rest.Config, error) {
+	if l.Kubeconfig != "" {
+		log.Infof("Connecting to kubernetes with %s", l.Kubeconfig)
+		return clientcmd.BuildConfigFromFlags("", l.Kubeconfig)
+	}
 
 	// Try generating config for kubernetes client-go from
 	// in-cluster variables like KUBERNETES_SERVICE_HOST, etc
 	// so that we can connect to kubernetes using them.
 	kConfig, err := rest.InClusterConfig()
 	if err != nil {
-		return fmt.Errorf("error: %s", err)
+		return nil, fmt.Errorf("not running in a kubernetes cluster, kubeconfig has to be given: %s", err)
+	}
+	log.Infof("Connecting to kubernetes at %s with service account token", kConfig.Host)
+	return kConfig, nil
+}
+
+func (l *KubeListener) kubeClientInit() error {
+	kConfig, err := l.kubeClientConfig()
+	if err != nil {
+		return err
 	}
 
 	// Get a set of REST clients which connect to kubernetes services
@@ -76,12 +116,12 @@ func (l *KubeListener) nodeToHost(n interface{}) (romanaApi.Host, error) {
 		return host, fmt.Errorf("Received invalid node name or IP Address: (%#v)", node)
 	}
 	host.Name = node.Name
-	hostIP := net.ParseIP(node.Status.Addresses[0].Address)
-	if hostIP == nil {
-		return host, fmt.Errorf("Cannot parse address of node %s: %s", node.Name, node.Status.Addresses[0].Address)
+	hostIP, err := nodeIP(node)
+	if err != nil {
+		return host, err
 	}
 	host.IP = hostIP
-	host.Tags = node.GetLabels()
+	host.Tags = nodeTags(node.GetLabels())
 	if l.nodeAttributes != nil && len(l.nodeAttributes) > 0 {
 		host.K8SInfo = make(map[string]interface{})
 		json, err := json.Marshal(node)
@@ -111,6 +151,60 @@ func (l *KubeListener) nodeToHost(n interface{}) (romanaApi.Host, error) {
 	return host, nil
 }
 
+// nodeIP returns address of the node romana host has, its internal
+// IP, or external IP if it has none, or the first one otherwise.
+func nodeIP(node *v1.Node) (net.IP, error) {
+	for _, addressType := range []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP} {
+		for _, address := range node.Status.Addresses {
+			if address.Type != addressType {
+				continue
+			}
+			if ip := net.ParseIP(address.Address); ip != nil {
+				return ip, nil
+			}
+		}
+	}
+	ip := net.ParseIP(node.Status.Addresses[0].Address)
+	if ip == nil {
+		return nil, fmt.Errorf("Cannot parse address of node %s: %s", node.Name, node.Status.Addresses[0].Address)
+	}
+	return ip, nil
+}
+
+// nodeTags returns tags of romana host of a node with the labels,
+// which are the labels, along with ZoneTag and RegionTag taken
+// from well-known topology labels, so that topology can assign
+// hosts to groups by zone or region.
+func nodeTags(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	tags := make(map[string]string, len(labels)+2)
+	for k, v := range labels {
+		tags[k] = v
+	}
+	derived := []struct {
+		tag    string
+		labels []string
+	}{
+		{ZoneTag, []string{zoneLabel, legacyZoneLabel}},
+		{RegionTag, []string{regionLabel, legacyRegionLabel}},
+	}
+	for _, d := range derived {
+		// node labels with the same name as the tag are kept.
+		if _, ok := tags[d.tag]; ok {
+			continue
+		}
+		for _, label := range d.labels {
+			if v, ok := labels[label]; ok {
+				tags[d.tag] = v
+				break
+			}
+		}
+	}
+	return tags
+}
+
 // syncNodes checks what nodes are defined in K8S cluster vs
 // hosts defined in Romana and synchronizes them.
 // In case syncNodes() is called multiple
@@ -160,6 +254,15 @@ func (l *KubeListener) syncNodes() {
 		for _, romanaHost := range romanaHostList.Hosts {
 			if romanaHost.IP.String() == host.IP.String() {
 				nodeInRomana = true
+				// tags of hosts are kept up to date in case
+				// updates of nodes were missed.
+				if (len(romanaHost.Tags) > 0 || len(host.Tags) > 0) && !reflect.DeepEqual(romanaHost.Tags, host.Tags) {
+					if err = l.client.IPAM.UpdateHostLabels(host); err != nil {
+						log.Errorf("Cannot update tags of host %s: %s", host, err)
+					} else {
+						l.notifyHost(common.ActionUpdated, host)
+					}
+				}
 				break
 			}
 		}
@@ -327,6 +430,8 @@ func (l *KubeListener) kubernetesUpdateNodeEventHandler(o, n interface{}) {
 	err = l.client.IPAM.UpdateHostLabels(host)
 	if err != nil {
 		log.Errorf("Cannot update node %s: %s", node.Name, err)
+	} else if old, ok := o.(*v1.Node); ok && !reflect.DeepEqual(nodeTags(old.GetLabels()), host.Tags) {
+		l.notifyHost(common.ActionUpdated, host)
 	}
 	err = l.client.IPAM.UpdateHostK8SInfo(host)
 	if err != nil {
@@ -344,6 +449,7 @@ func (l *KubeListener) romanaHostAdd(host romanaApi.Host) error {
 		return nil
 	} else if err == nil {
 		log.Infof("Host (%s) successfully added to Romana cluster.", host)
+		l.notifyHost(common.ActionCreated, host)
 		return nil
 	}
 	return err
@@ -358,7 +464,56 @@ func (l *KubeListener) romanaHostRemove(host romanaApi.Host) error {
 		return nil
 	} else if err == nil {
 		log.Infof("Host %s successfully removed from Romana cluster", host)
+		l.notifyHost(common.ActionDeleted, host)
 		return nil
 	}
 	return err
 }
+
+// notifyHost notifies webhooks of the host added,
+// updated or removed by the listener.
+func (l *KubeListener) notifyHost(action string, host romanaApi.Host) {
+	var object interface{}
+	if action != common.ActionDeleted {
+		object = host
+	}
+	l.notifier.Notify(common.NewWebhookEvent(common.RestContext{},
+		common.ResourceHost, action, host.Name, object))
+}
+
+// bootstrapTopology sets initial topology of romana from nodes of
+// the cluster, unless romana has a topology already: network with
+// BootstrapCIDR is defined and nodes are grouped by their zone.
+func (l *KubeListener) bootstrapTopology() error {
+	if len(l.client.IPAM.Networks) > 0 || l.client.IPAM.TopologyRevision > 0 {
+		log.Infof("Topology is set already, not bootstrapping it")
+		return nil
+	}
+
+	nodes, err := l.kubeClientSet.CoreV1Client.Nodes().List(v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("Cannot list nodes to bootstrap topology: %s", err)
+	}
+	var hosts []romanaApi.Host
+	for i := range nodes.Items {
+		host, err := l.nodeToHost(&nodes.Items[i])
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+
+	networks := []romanaApi.NetworkDefinition{{
+		Name:      bootstrapNetwork,
+		CIDR:      l.BootstrapCIDR,
+		BlockMask: l.BootstrapBlockMask,
+	}}
+	topology := client.GenerateTopology(networks, hosts, ZoneTag)
+	err = l.client.IPAM.UpdateTopology(topology, true)
+	if err != nil {
+		return fmt.Errorf("Cannot bootstrap topology: %s", err)
+	}
+	log.Infof("Bootstrapped topology of network %s (%s) with %d nodes", bootstrapNetwork, l.BootstrapCIDR, len(hosts))
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestNodeTags(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		expect map[string]string
+	}{
+		{nil, nil},
+		{
+			map[string]string{"rack": "r1"},
+			map[string]string{"rack": "r1"},
+		},
+		{
+			map[string]string{zoneLabel: "us-east-1a", regionLabel: "us-east-1"},
+			map[string]string{zoneLabel: "us-east-1a", regionLabel: "us-east-1", ZoneTag: "us-east-1a", RegionTag: "us-east-1"},
+		},
+		{
+			map[string]string{legacyZoneLabel: "us-east-1b"},
+			map[string]string{legacyZoneLabel: "us-east-1b", ZoneTag: "us-east-1b"},
+		},
+		{
+			// labels of nodes win over derived tags.
+			map[string]string{zoneLabel: "us-east-1a", ZoneTag: "z1"},
+			map[string]string{zoneLabel: "us-east-1a", ZoneTag: "z1"},
+		},
+	}
+	for i, tt := range tests {
+		if tags := nodeTags(tt.labels); !reflect.DeepEqual(tags, tt.expect) {
+			t.Errorf("%d: expected tags %v, got %v", i, tt.expect, tags)
+		}
+	}
+}
+
+func TestNodeIP(t *testing.T) {
+	node := &v1.Node{}
+	node.Name = "node1"
+	node.Status.Addresses = []v1.NodeAddress{
+		{Type: v1.NodeHostName, Address: "node1"},
+		{Type: v1.NodeExternalIP, Address: "203.0.113.10"},
+		{Type: v1.NodeInternalIP, Address: "10.0.0.10"},
+	}
+	ip, err := nodeIP(node)
+	if err != nil || ip.String() != "10.0.0.10" {
+		t.Errorf("Expected internal IP 10.0.0.10, got %s, %v", ip, err)
+	}
+
+	node.Status.Addresses = node.Status.Addresses[:2]
+	ip, err = nodeIP(node)
+	if err != nil || ip.String() != "203.0.113.10" {
+		t.Errorf("Expected external IP 203.0.113.10, got %s, %v", ip, err)
+	}
+
+	node.Status.Addresses = node.Status.Addresses[:1]
+	if ip, err = nodeIP(node); err == nil {
+		t.Errorf("Expected error for node without IP, got %s", ip)
+	}
+}
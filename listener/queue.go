@@ -0,0 +1,90 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+// This file in package listener has a work queue of keys
+// of kubernetes objects that informers found changed.
+
+import (
+	"time"
+
+	log "github.com/romana/rlog"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// queueRetryInitialDelay and queueRetryMaxDelay bound delays
+	// between attempts to sync a key that failed to sync.
+	queueRetryInitialDelay = time.Second
+	queueRetryMaxDelay     = 5 * time.Minute
+
+	// maxQueueRetries is the number of attempts to sync a key
+	// after which it's dropped until informers resync it.
+	maxQueueRetries = 10
+)
+
+// workQueue is a rate limiting queue of keys to sync. A key is
+// queued once no matter how many times it's added before it's
+// taken, and is not taken by another worker while it's synced,
+// so that workers always sync the latest state of an object.
+type workQueue struct {
+	workqueue.RateLimitingInterface
+
+	// name is used in logs.
+	name string
+}
+
+// newWorkQueue returns an empty queue, keys that failed to sync
+// are retried with exponential backoff.
+func newWorkQueue(name string) *workQueue {
+	limiter := workqueue.NewItemExponentialFailureRateLimiter(queueRetryInitialDelay, queueRetryMaxDelay)
+	return &workQueue{
+		RateLimitingInterface: workqueue.NewNamedRateLimitingQueue(limiter, name),
+		name:                  name,
+	}
+}
+
+// process syncs keys taken from the queue until it's shut down.
+// Keys that failed to sync are retried with backoff, up to
+// maxQueueRetries times.
+func (q *workQueue) process(sync func(key string) error) {
+	for q.processNext(sync) {
+	}
+}
+
+// processNext syncs the next key, it returns false
+// once the queue is shut down.
+func (q *workQueue) processNext(sync func(key string) error) bool {
+	item, shutdown := q.Get()
+	if shutdown {
+		return false
+	}
+	defer q.Done(item)
+
+	key := item.(string)
+	err := sync(key)
+	switch {
+	case err == nil:
+		q.Forget(key)
+	case q.NumRequeues(key) < maxQueueRetries:
+		log.Errorf("Failed to sync %s %s, retrying: %s", q.name, key, err)
+		q.AddRateLimited(key)
+	default:
+		log.Errorf("Failed to sync %s %s after %d retries, giving up until resync: %s", q.name, key, maxQueueRetries, err)
+		q.Forget(key)
+	}
+	return true
+}
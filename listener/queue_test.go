@@ -0,0 +1,69 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestWorkQueue(t *testing.T) {
+	q := newWorkQueue("test")
+	q.Add("a")
+	q.Add("b")
+	q.Add("a")
+	if q.Len() != 2 {
+		t.Fatalf("expected 2 keys queued, got %d", q.Len())
+	}
+
+	key, shutdown := q.Get()
+	if shutdown || key != "a" {
+		t.Fatalf("expected key a, got %v, shutdown %t", key, shutdown)
+	}
+	// key added while it's synced is queued once it's done.
+	q.Add("a")
+	if q.Len() != 1 {
+		t.Fatalf("expected 1 key queued while a is synced, got %d", q.Len())
+	}
+	q.Done("a")
+	if q.Len() != 2 {
+		t.Fatalf("expected 2 keys queued after a is done, got %d", q.Len())
+	}
+	q.ShutDown()
+}
+
+func TestWorkQueueProcess(t *testing.T) {
+	q := newWorkQueue("test")
+	q.Add("a")
+	q.Add("b")
+	q.ShutDown()
+
+	var synced []string
+	q.process(func(key string) error {
+		synced = append(synced, key)
+		if key == "b" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	if !reflect.DeepEqual(synced, []string{"a", "b"}) {
+		t.Errorf("expected keys [a b] synced, got %v", synced)
+	}
+	if q.NumRequeues("a") != 0 || q.NumRequeues("b") != 1 {
+		t.Errorf("expected 0 retries of a and 1 of b, got %d and %d", q.NumRequeues("a"), q.NumRequeues("b"))
+	}
+}
@@ -18,22 +18,15 @@ package listener
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"os"
 	"strings"
-	"time"
 
-	"github.com/romana/core/common"
 	romanaApi "github.com/romana/core/common/api"
 	"github.com/romana/core/common/client"
 	"github.com/romana/core/common/log/trace"
 	log "github.com/romana/rlog"
 
-	"k8s.io/client-go/pkg/api"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
-	"k8s.io/client-go/pkg/fields"
-	"k8s.io/client-go/tools/cache"
 )
 
 // Event is a representation of a structure that we receive from kubernetes API.
@@ -48,58 +41,6 @@ const (
 	KubeEventModified = "MODIFIED"
 )
 
-// handleNetworkPolicyEvents by creating or deleting romana policies.
-func handleNetworkPolicyEvents(events []Event, l *KubeListener) {
-	// TODO optimise deletion, search policy by name/id
-	// and delete by id rather then sending full policy body.
-	// Stas.
-	var deleteEvents []v1beta1.NetworkPolicy
-	var createEvents []v1beta1.NetworkPolicy
-
-	for _, event := range events {
-		switch event.Type {
-		case KubeEventAdded:
-			createEvents = append(createEvents, *event.Object.(*v1beta1.NetworkPolicy))
-		case KubeEventDeleted:
-			deleteEvents = append(deleteEvents, *event.Object.(*v1beta1.NetworkPolicy))
-		default:
-			log.Tracef(trace.Inside, "Ignoring %s event in handleNetworkPolicyEvents", event.Type)
-		}
-	}
-
-	// Translate new network policies into romana policies.
-	createPolicyList, kubePolicy, err := PTranslator.Kube2RomanaBulk(createEvents)
-	if err != nil {
-		log.Errorf("Not all kubernetes policies could be translated to Romana policies. Attempted %d, success %d, fail %d, error %s", len(createEvents), len(createPolicyList), len(kubePolicy), err)
-	}
-	for kn, _ := range kubePolicy {
-		log.Errorf("Failed to translate kubernetes policy %v", kubePolicy[kn])
-	}
-
-	// Create new policies.
-	for pn, _ := range createPolicyList {
-		err = l.addNetworkPolicy(createPolicyList[pn])
-		if err != nil {
-			log.Errorf("Error adding policy with Kubernetes ID %s: %s", createPolicyList[pn].ID, err)
-		}
-	}
-
-	// Delete old policies.
-	for _, policy := range deleteEvents {
-		// policy name is derived as below in translator and thus use the
-		// same technique to derive the policy name here for deleting it.
-		policyID := getPolicyID(policy)
-		ok, err := l.client.DeletePolicy(policyID)
-		if err != nil {
-			log.Errorf("Error deleting policy %s: %s", policyID, err)
-		}
-		if !ok {
-			log.Tracef(4, "can't delete policy %s, not found", policyID)
-		}
-
-	}
-}
-
 // TODO: see GetTenantIDFromNamespaceName
 func GetTenantIDFromNamespaceObject(ns *v1.Namespace) string {
 	return ns.GetName()
@@ -121,48 +62,20 @@ func GetTenantIDFromNamespaceName(nsName string) string {
 	return nsName
 }
 
-// handleNamespaceEvent by creating or deleting romana tenants.
-func handleNamespaceEvent(e Event, l *KubeListener) {
-	namespace, ok := e.Object.(*v1.Namespace)
-	if !ok {
-		panic("Failed to cast namespace in handleNamespaceEvent")
-	}
-
-	log.Debugf("KubeEvent: Processing namespace event == %v and phase %v", e.Type, namespace.Status)
-
-	if e.Type == KubeEventAdded {
-		// Noop for now, as we do not need to create tenants explicitly now
-		// But see comment to GetTenantIDFromNamespaceName() above --
-		// leaving this code path for if we want to use this for caching
-		// ns ID-name correspondence
-	} else if e.Type == KubeEventDeleted {
-		log.Infof("KubeEventDeleted: deleting default policy for namespace %s (%s)", namespace.GetName(), namespace.GetUID())
-		deleteDefaultPolicy(namespace, l)
-		return
-	}
-
-	// Ignore repeated events during namespace termination
-	if namespace.Status.Phase == v1.NamespaceTerminating {
-		if e.Type != KubeEventModified {
-			handleAnnotations(namespace, l)
-		}
-	} else {
-		handleAnnotations(namespace, l)
-	}
-
-}
-
 // handleAnnotations on a namespace by implementing extra features requested through the annotation
-func handleAnnotations(o *v1.Namespace, l *KubeListener) {
+func handleAnnotations(o *v1.Namespace, l *KubeListener) error {
 	log.Tracef(trace.Private, "In handleAnnotations")
 
-	// We only care about one annotation for now.
-	HandleDefaultPolicy(o, l)
+	if err := HandleDefaultPolicy(o, l); err != nil {
+		return err
+	}
+	return l.syncNamespaceTenant(o)
 }
 
 // HandleDefaultPolicy handles isolation flag on a namespace by creating/deleting
 // default network policy. See http://kubernetes.io/docs/user-guide/networkpolicies/
-func HandleDefaultPolicy(o *v1.Namespace, l *KubeListener) {
+// Namespaces with DefaultDenyAnnotation are isolated too.
+func HandleDefaultPolicy(o *v1.Namespace, l *KubeListener) error {
 	var defaultDeny bool
 	annotationKey := "net.beta.kubernetes.io/networkpolicy"
 	if np, ok := o.ObjectMeta.Annotations[annotationKey]; ok {
@@ -178,7 +91,7 @@ func HandleDefaultPolicy(o *v1.Namespace, l *KubeListener) {
 		err := json.NewDecoder(strings.NewReader(np)).Decode(&isolationPolicy)
 		if err != nil {
 			log.Errorf("In HandleDefaultPolicy :: Error decoding annotation %s: %s", annotationKey, err)
-			return
+			return nil
 		}
 		log.Debugf("Decoded to policy: %v", isolationPolicy)
 		defaultDeny = isolationPolicy.Ingress.Isolation == "DefaultDeny"
@@ -186,11 +99,10 @@ func HandleDefaultPolicy(o *v1.Namespace, l *KubeListener) {
 		log.Debugf("Handling default policy on a namespace, no annotation detected assuming non isolated namespace")
 		defaultDeny = false
 	}
-	if defaultDeny {
-		deleteDefaultPolicy(o, l)
-	} else {
-		addDefaultPolicy(o, l)
+	if defaultDeny || namespaceDefaultDeny(o) {
+		return deleteDefaultPolicy(o, l)
 	}
+	return addDefaultPolicy(o, l)
 }
 
 // getPolicyID generates a policyID based on the
@@ -205,29 +117,28 @@ func getPolicyID(kubePolicy v1beta1.NetworkPolicy) string {
 // prefix followed by the namespace's Name.
 func getDefaultPolicyID(o *v1.Namespace) string {
 	// TODO this should be ExternalID, not Name...
-	return fmt.Sprintf("AllowAllPods2Talk_%s_", o.GetUID())
+	return fmt.Sprintf("%s%s_", defaultPolicyPrefix, o.GetUID())
 }
 
 // deleteDefaultPolicy deletes the policy, thus enabling isolation
 // effectively setting DefaultDeny to on.
-func deleteDefaultPolicy(o *v1.Namespace, l *KubeListener) {
-	var err error
+func deleteDefaultPolicy(o *v1.Namespace, l *KubeListener) error {
 	// TODO this should be ExternalID, not Name...
 	policyID := getDefaultPolicyID(o)
 
-	ok, err := l.client.DeletePolicy(policyID)
+	ok, err := l.deleteNetworkPolicy(policyID)
 	if err != nil {
-		log.Errorf("In deleteDefaultPolicy :: Error :: failed to delete policy %s: %s\n", policyID, err)
+		return fmt.Errorf("failed to delete default policy %s: %s", policyID, err)
 	}
 	if !ok {
 		log.Tracef(4, "can't delete policy %s, not found", policyID)
 	}
+	return nil
 }
 
 // addDefaultPolicy adds the default policy which is to allow
 // all ingres.
-func addDefaultPolicy(o *v1.Namespace, l *KubeListener) {
-	var err error
+func addDefaultPolicy(o *v1.Namespace, l *KubeListener) error {
 	// Find tenant, to properly set up policy
 	// TODO This really should be by external ID...
 	tenantID := GetTenantIDFromNamespaceObject(o)
@@ -244,169 +155,13 @@ func addDefaultPolicy(o *v1.Namespace, l *KubeListener) {
 		},
 	}
 
-	err = l.addNetworkPolicy(*romanaPolicy)
-	switch err := err.(type) {
-	default:
-		log.Errorf("In addDefaultPolicy :: Error :: failed to create policy  %s: %s\n", policyID, err)
-	case nil:
-		log.Debugf("In addDefaultPolicy: Succesfully created policy  %s\n", policyID)
-	case common.HttpError:
-		if err.StatusCode == http.StatusConflict {
-			log.Infof("In addDefaultPolicy ::Policy %s already exists.\n", policyID)
-		} else {
-			log.Errorf("In addDefaultPolicy :: Error :: failed to create policy %s: %s\n", policyID, err)
-		}
-	}
-}
-
-// NsWatch is a generator that watches namespace related events in
-// kubernetes API and publishes this events to a channel.
-func (l *KubeListener) nsWatch(done <-chan struct{}) (chan Event, error) {
-	out := make(chan Event, l.namespaceBufferSize)
-
-	// watcher watches all namespaces.
-	watcher := cache.NewListWatchFromClient(
-		l.kubeClientSet.CoreV1Client.RESTClient(),
-		"namespaces",
-		api.NamespaceAll,
-		fields.Everything(),
-	)
-
-	_, controller := cache.NewInformer(
-		watcher,
-		&v1.Namespace{},
-		0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				out <- Event{
-					Type:   KubeEventAdded,
-					Object: obj,
-				}
-			},
-			UpdateFunc: func(old, obj interface{}) {
-				out <- Event{
-					Type:   KubeEventModified,
-					Object: obj,
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				out <- Event{
-					Type:   KubeEventDeleted,
-					Object: obj,
-				}
-			},
-		})
-
-	go controller.Run(done)
-
-	return out, nil
-}
-
-// ProduceNewPolicyEvents produces kubernetes network policy events that arent applied
-// in romana policy service yet.
-func ProduceNewPolicyEvents(out chan Event, done <-chan struct{}, KubeListener *KubeListener) {
-	log.Infof("Listening for kubernetes network policies")
-
-	// watcher watches all network policy.
-	watcher := cache.NewListWatchFromClient(
-		KubeListener.kubeClientSet.ExtensionsV1beta1Client.RESTClient(),
-		"networkpolicies",
-		api.NamespaceAll,
-		fields.Everything(),
-	)
-
-	store, controller := cache.NewInformer(
-		watcher,
-		&v1beta1.NetworkPolicy{},
-		0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				KubeListener.RLock()
-				defer KubeListener.RUnlock()
-				if !KubeListener.policiesSynced {
-					return
-				}
-				out <- Event{
-					Type:   KubeEventAdded,
-					Object: obj,
-				}
-			},
-			UpdateFunc: func(old, obj interface{}) {
-				KubeListener.RLock()
-				defer KubeListener.RUnlock()
-				if !KubeListener.policiesSynced {
-					return
-				}
-				out <- Event{
-					Type:   KubeEventModified,
-					Object: obj,
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				KubeListener.RLock()
-				defer KubeListener.RUnlock()
-				if !KubeListener.policiesSynced {
-					return
-				}
-				out <- Event{
-					Type:   KubeEventDeleted,
-					Object: obj,
-				}
-			},
-		})
-
-	go controller.Run(done)
-
-	duration := 60 * time.Second
-	timeout := time.After(duration)
-
-	ticker := time.NewTicker(250 * time.Millisecond)
-	defer ticker.Stop()
-
-	log.Info("Waiting for networkpolicy list to synchronize")
-synchronizationLoop:
-	for {
-		select {
-		case <-timeout:
-			log.Errorf("timeout after %s while synchronizing networkpolicy", duration)
-			os.Exit(1)
-		case <-ticker.C:
-			if controller.HasSynced() {
-				log.Info("networkpolicy synchronization completed")
-				KubeListener.Lock()
-				KubeListener.policiesSynced = true
-				KubeListener.Unlock()
-				break synchronizationLoop
-			}
-		}
-	}
-
-	var kubePolicyList []*v1beta1.NetworkPolicy
-	for _, kp := range store.List() {
-		kubePolicyList = append(kubePolicyList, kp.(*v1beta1.NetworkPolicy))
-	}
-
-	newEvents, oldPolicies, err := KubeListener.syncNetworkPolicies(kubePolicyList)
-	if err != nil {
-		log.Errorf("Failed to sync romana policies with kube policies, sync failed with %s", err)
-	}
-
-	log.Infof("Produce policies detected %d new kubernetes policies and %d old romana policies", len(newEvents), len(oldPolicies))
-
-	// Create new kubernetes policies
-	for en, _ := range newEvents {
-		out <- newEvents[en]
-	}
-
-	for k, _ := range oldPolicies {
-		ok, err := KubeListener.client.DeletePolicy(oldPolicies[k].ID)
-		if err != nil {
-			log.Errorf("Sync policies detected obsolete policy %s but failed to delete, %s", oldPolicies[k].ID, err)
-		}
-		if !ok {
-			log.Tracef(4, "can't delete policy %s, not found", oldPolicies[k].ID)
-		}
+	// namespaces are resynced periodically, so the policy
+	// is only added or updated if it's not the same already.
+	if err := l.applyNetworkPolicy(*romanaPolicy); err != nil {
+		return fmt.Errorf("failed to create default policy %s: %s", policyID, err)
 	}
+	log.Debugf("In addDefaultPolicy: Succesfully synced policy  %s\n", policyID)
+	return nil
 }
 
 // getAllPoliciesFunc wraps request to Policy for the purpose of unit testing.
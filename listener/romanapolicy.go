@@ -0,0 +1,373 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+// This file in package listener has functionality related to
+// RomanaPolicy custom resources, which are synced into the policy
+// service as they are, unlike kubernetes network policies which
+// are translated.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/pkg/policytools"
+
+	log "github.com/romana/rlog"
+	kubeErrors "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	romanaPolicyGroup    = "romana.io"
+	romanaPolicyVersion  = "v1"
+	romanaPolicyPlural   = "romanapolicies"
+	romanaPolicySingular = "romanapolicy"
+	romanaPolicyKind     = "RomanaPolicy"
+
+	// romanaPolicyIDPrefix is the prefix of IDs of policies synced
+	// from RomanaPolicy resources, other policies are left alone.
+	romanaPolicyIDPrefix = "crd."
+
+	// customResourceDefinitionsPath is where custom resource
+	// definitions are created.
+	customResourceDefinitionsPath = "/apis/apiextensions.k8s.io/v1beta1/customresourcedefinitions"
+
+	// romanaPolicyResyncInterval is how long RomanaPolicy resources
+	// are watched before all of them are synced again.
+	romanaPolicyResyncInterval = 5 * time.Minute
+
+	// romanaPolicyRetryInterval is how long to wait before
+	// syncing again after watching failed or ended.
+	romanaPolicyRetryInterval = 5 * time.Second
+)
+
+// Types of conditions of RomanaPolicy status.
+const (
+	// RomanaPolicyValid tells whether spec of the policy is valid.
+	RomanaPolicyValid = "Valid"
+
+	// RomanaPolicyConverged tells whether the policy service
+	// has the policy as in spec of the resource.
+	RomanaPolicyConverged = "Converged"
+)
+
+// RomanaPolicy is a custom resource with a Romana policy in its spec.
+// ID of the policy is derived from namespace and name of the resource.
+type RomanaPolicy struct {
+	unversioned.TypeMeta `json:",inline"`
+	Metadata             v1.ObjectMeta      `json:"metadata"`
+	Spec                 api.Policy         `json:"spec"`
+	Status               RomanaPolicyStatus `json:"status,omitempty"`
+}
+
+// RomanaPolicyList is a list of RomanaPolicy resources.
+type RomanaPolicyList struct {
+	unversioned.TypeMeta `json:",inline"`
+	Metadata             unversioned.ListMeta `json:"metadata"`
+	Items                []RomanaPolicy       `json:"items"`
+}
+
+// RomanaPolicyStatus is status of a RomanaPolicy resource
+// set by the listener.
+type RomanaPolicyStatus struct {
+	// PolicyID is ID of the policy in the policy service.
+	PolicyID   string                  `json:"policyID,omitempty"`
+	Conditions []RomanaPolicyCondition `json:"conditions,omitempty"`
+}
+
+// RomanaPolicyCondition is a condition of a RomanaPolicy resource,
+// Type is one of RomanaPolicyValid and RomanaPolicyConverged.
+type RomanaPolicyCondition struct {
+	Type               string             `json:"type"`
+	Status             v1.ConditionStatus `json:"status"`
+	Reason             string             `json:"reason,omitempty"`
+	Message            string             `json:"message,omitempty"`
+	LastTransitionTime unversioned.Time   `json:"lastTransitionTime,omitempty"`
+}
+
+// romanaPolicyEvent is an event of a watch of RomanaPolicy resources.
+type romanaPolicyEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// romanaPolicyID returns ID of the policy of the resource.
+func romanaPolicyID(rp RomanaPolicy) string {
+	return fmt.Sprintf("%s%s.%s", romanaPolicyIDPrefix, rp.Metadata.Namespace, rp.Metadata.Name)
+}
+
+// romanaPolicyPath returns path of RomanaPolicy resources in the
+// namespace, or in all namespaces if it's empty, or path of the
+// resource with the name if it's not empty.
+func romanaPolicyPath(namespace string, name string) string {
+	path := "/apis/" + romanaPolicyGroup + "/" + romanaPolicyVersion
+	if namespace != "" {
+		path += "/namespaces/" + namespace
+	}
+	path += "/" + romanaPolicyPlural
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+// setRomanaPolicyCondition sets the condition in the status, keeping
+// its transition time unless status of the condition changes. It
+// returns false if the status already had the same condition.
+func setRomanaPolicyCondition(status *RomanaPolicyStatus, condition RomanaPolicyCondition) bool {
+	if condition.LastTransitionTime.IsZero() {
+		condition.LastTransitionTime = unversioned.Now()
+	}
+	for i, c := range status.Conditions {
+		if c.Type != condition.Type {
+			continue
+		}
+		if c.Status == condition.Status && c.Reason == condition.Reason && c.Message == condition.Message {
+			return false
+		}
+		if c.Status == condition.Status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+		status.Conditions[i] = condition
+		return true
+	}
+	status.Conditions = append(status.Conditions, condition)
+	return true
+}
+
+// ensureRomanaPolicyCRD creates the definition of RomanaPolicy
+// resources unless it exists already.
+func (l *KubeListener) ensureRomanaPolicyCRD() error {
+	crd := map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1beta1",
+		"kind":       "CustomResourceDefinition",
+		"metadata": map[string]interface{}{
+			"name": romanaPolicyPlural + "." + romanaPolicyGroup,
+		},
+		"spec": map[string]interface{}{
+			"group":   romanaPolicyGroup,
+			"version": romanaPolicyVersion,
+			"scope":   "Namespaced",
+			"names": map[string]interface{}{
+				"plural":     romanaPolicyPlural,
+				"singular":   romanaPolicySingular,
+				"kind":       romanaPolicyKind,
+				"shortNames": []string{"rp"},
+			},
+		},
+	}
+	body, err := json.Marshal(crd)
+	if err != nil {
+		return err
+	}
+	_, err = l.kubeClientSet.CoreV1Client.RESTClient().Post().
+		AbsPath(customResourceDefinitionsPath).
+		Body(body).
+		Do().Raw()
+	if kubeErrors.IsAlreadyExists(err) {
+		return nil
+	}
+	if kubeErrors.IsForbidden(err) {
+		return fmt.Errorf("listener is not allowed to create customresourcedefinitions, "+
+			"they have to be allowed by RBAC or the definition created by an administrator: %s", err)
+	}
+	return err
+}
+
+// startRomanaPolicySync creates the definition of RomanaPolicy
+// resources and syncs them into the policy service until done is closed.
+func (l *KubeListener) startRomanaPolicySync(done <-chan struct{}) {
+	if err := l.ensureRomanaPolicyCRD(); err != nil {
+		log.Errorf("Failed to create definition of %s resources: %s", romanaPolicyKind, err)
+	}
+
+	go func() {
+		for {
+			if err := l.syncRomanaPolicies(done); err != nil {
+				log.Errorf("Failed to sync %s resources: %s", romanaPolicyKind, err)
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(romanaPolicyRetryInterval):
+			}
+		}
+	}()
+}
+
+// syncRomanaPolicies syncs all RomanaPolicy resources, deletes
+// policies of resources that are gone, and then syncs resources
+// as they change until watching them ends or done is closed.
+func (l *KubeListener) syncRomanaPolicies(done <-chan struct{}) error {
+	raw, err := l.kubeClientSet.CoreV1Client.RESTClient().Get().
+		AbsPath(romanaPolicyPath("", "")).
+		Do().Raw()
+	if err != nil {
+		return err
+	}
+	var list RomanaPolicyList
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return err
+	}
+
+	ids := make(map[string]bool)
+	for _, rp := range list.Items {
+		ids[romanaPolicyID(rp)] = true
+		l.syncRomanaPolicy(rp)
+	}
+
+	policies, err := l.client.ListPolicies()
+	if err != nil {
+		return err
+	}
+	for _, policy := range policies {
+		if !strings.HasPrefix(policy.ID, romanaPolicyIDPrefix) || ids[policy.ID] {
+			continue
+		}
+		log.Infof("Deleting policy %s, its %s resource is gone", policy.ID, romanaPolicyKind)
+		if _, err := l.deleteNetworkPolicy(policy.ID); err != nil {
+			log.Errorf("Failed to delete policy %s: %s", policy.ID, err)
+		}
+	}
+
+	return l.watchRomanaPolicies(list.Metadata.ResourceVersion, done)
+}
+
+// watchRomanaPolicies syncs RomanaPolicy resources changed after
+// the resource version until watching them ends or done is closed.
+func (l *KubeListener) watchRomanaPolicies(resourceVersion string, done <-chan struct{}) error {
+	stream, err := l.kubeClientSet.CoreV1Client.RESTClient().Get().
+		AbsPath(romanaPolicyPath("", "")).
+		Param("watch", "true").
+		Param("resourceVersion", resourceVersion).
+		Param("timeoutSeconds", strconv.Itoa(int(romanaPolicyResyncInterval/time.Second))).
+		Stream()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// closing the stream stops decoding when done is closed.
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-done:
+			stream.Close()
+		case <-stopped:
+		}
+	}()
+
+	decoder := json.NewDecoder(stream)
+	for {
+		var event romanaPolicyEvent
+		if err := decoder.Decode(&event); err != nil {
+			select {
+			case <-done:
+				return nil
+			default:
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if event.Type == "ERROR" {
+			return fmt.Errorf("watching %s resources failed: %s", romanaPolicyKind, event.Object)
+		}
+		var rp RomanaPolicy
+		if err := json.Unmarshal(event.Object, &rp); err != nil {
+			log.Errorf("Failed to decode %s resource: %s", romanaPolicyKind, err)
+			continue
+		}
+
+		switch event.Type {
+		case KubeEventAdded, KubeEventModified:
+			l.syncRomanaPolicy(rp)
+		case KubeEventDeleted:
+			policyID := romanaPolicyID(rp)
+			ok, err := l.deleteNetworkPolicy(policyID)
+			if err != nil {
+				log.Errorf("Failed to delete policy %s: %s", policyID, err)
+			}
+			if !ok {
+				log.Tracef(4, "can't delete policy %s, not found", policyID)
+			}
+		}
+	}
+}
+
+// syncRomanaPolicy validates policy of the resource and adds or
+// updates it in the policy service, and then updates status of
+// the resource if its conditions changed.
+func (l *KubeListener) syncRomanaPolicy(rp RomanaPolicy) {
+	policy := rp.Spec
+	policy.ID = romanaPolicyID(rp)
+
+	changed := rp.Status.PolicyID != policy.ID
+	rp.Status.PolicyID = policy.ID
+	setCondition := func(conditionType string, status v1.ConditionStatus, reason string, message string) {
+		condition := RomanaPolicyCondition{Type: conditionType, Status: status, Reason: reason, Message: message}
+		if setRomanaPolicyCondition(&rp.Status, condition) {
+			changed = true
+		}
+	}
+
+	if err := policytools.ValidatePolicy(policy); err != nil {
+		setCondition(RomanaPolicyValid, v1.ConditionFalse, "Invalid", err.Error())
+		setCondition(RomanaPolicyConverged, v1.ConditionFalse, "Invalid", "Policy is not synced while it's invalid")
+	} else {
+		setCondition(RomanaPolicyValid, v1.ConditionTrue, "Valid", "")
+		if err := l.applyNetworkPolicy(policy); err != nil {
+			log.Errorf("Failed to sync policy %s: %s", policy.ID, err)
+			setCondition(RomanaPolicyConverged, v1.ConditionFalse, "SyncFailed", err.Error())
+		} else {
+			setCondition(RomanaPolicyConverged, v1.ConditionTrue, "Synced", "")
+		}
+	}
+
+	if !changed {
+		return
+	}
+	if err := l.updateRomanaPolicy(rp); err != nil {
+		// newer version of the resource is synced when it's seen.
+		log.Infof("Failed to update status of %s %s/%s: %s", romanaPolicyKind, rp.Metadata.Namespace, rp.Metadata.Name, err)
+	}
+}
+
+// updateRomanaPolicy replaces the resource, it fails with
+// conflict if the resource changed since it was read.
+func (l *KubeListener) updateRomanaPolicy(rp RomanaPolicy) error {
+	rp.APIVersion = romanaPolicyGroup + "/" + romanaPolicyVersion
+	rp.Kind = romanaPolicyKind
+	body, err := json.Marshal(rp)
+	if err != nil {
+		return err
+	}
+	_, err = l.kubeClientSet.CoreV1Client.RESTClient().Put().
+		AbsPath(romanaPolicyPath(rp.Metadata.Namespace, rp.Metadata.Name)).
+		Body(body).
+		Do().Raw()
+	return err
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestRomanaPolicyPath(t *testing.T) {
+	tests := []struct {
+		namespace string
+		name      string
+		expect    string
+	}{
+		{"", "", "/apis/romana.io/v1/romanapolicies"},
+		{"default", "", "/apis/romana.io/v1/namespaces/default/romanapolicies"},
+		{"default", "web", "/apis/romana.io/v1/namespaces/default/romanapolicies/web"},
+	}
+	for _, tt := range tests {
+		if path := romanaPolicyPath(tt.namespace, tt.name); path != tt.expect {
+			t.Errorf("Expected %s for %q/%q, got %s", tt.expect, tt.namespace, tt.name, path)
+		}
+	}
+
+	rp := RomanaPolicy{Metadata: v1.ObjectMeta{Namespace: "default", Name: "web"}}
+	if id := romanaPolicyID(rp); id != "crd.default.web" {
+		t.Errorf("Expected policy ID crd.default.web, got %s", id)
+	}
+}
+
+func TestSetRomanaPolicyCondition(t *testing.T) {
+	var status RomanaPolicyStatus
+	valid := RomanaPolicyCondition{Type: RomanaPolicyValid, Status: v1.ConditionTrue, Reason: "Valid"}
+	if !setRomanaPolicyCondition(&status, valid) {
+		t.Fatal("Expected new condition to be set")
+	}
+	if len(status.Conditions) != 1 || status.Conditions[0].LastTransitionTime.IsZero() {
+		t.Fatalf("Expected one condition with transition time, got %v", status.Conditions)
+	}
+	transition := unversioned.NewTime(time.Now().Add(-time.Hour))
+	status.Conditions[0].LastTransitionTime = transition
+
+	if setRomanaPolicyCondition(&status, valid) {
+		t.Error("Expected same condition not to change status")
+	}
+
+	// transition time is kept while status of the condition is the same.
+	valid.Message = "checked again"
+	if !setRomanaPolicyCondition(&status, valid) {
+		t.Error("Expected condition with new message to change status")
+	}
+	if !status.Conditions[0].LastTransitionTime.Time.Equal(transition.Time) {
+		t.Errorf("Expected transition time %s to be kept, got %s", transition, status.Conditions[0].LastTransitionTime)
+	}
+
+	invalid := RomanaPolicyCondition{Type: RomanaPolicyValid, Status: v1.ConditionFalse, Reason: "Invalid"}
+	if !setRomanaPolicyCondition(&status, invalid) {
+		t.Error("Expected invalid condition to change status")
+	}
+	if status.Conditions[0].LastTransitionTime.Time.Equal(transition.Time) {
+		t.Error("Expected transition time to change with status of the condition")
+	}
+
+	converged := RomanaPolicyCondition{Type: RomanaPolicyConverged, Status: v1.ConditionTrue, Reason: "Synced"}
+	setRomanaPolicyCondition(&status, converged)
+	if len(status.Conditions) != 2 || status.Conditions[0].Status != v1.ConditionFalse {
+		t.Errorf("Expected conditions Valid=False and Converged=True, got %v", status.Conditions)
+	}
+}
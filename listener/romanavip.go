@@ -65,7 +65,7 @@ func (l *KubeListener) startRomanaVIPSync(stop <-chan struct{}) {
 		},
 	)
 
-	log.Println("Started receiving service events.")
+	log.Info("Started receiving service events.")
 	go serviceInformer.Run(stop)
 
 	// Wait for the list of services to synchronize
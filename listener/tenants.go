@@ -0,0 +1,137 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+// This file in package listener has functionality related to
+// provisioning romana tenants for kubernetes namespaces.
+
+import (
+	"fmt"
+	"strconv"
+
+	romanaApi "github.com/romana/core/common/api"
+	romanaErrors "github.com/romana/core/common/api/errors"
+	log "github.com/romana/rlog"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// TenantAnnotation of a namespace set to "false" opts it out of
+	// provisioning of its tenant, tenants are provisioned otherwise.
+	TenantAnnotation = "romana.io/tenant"
+
+	// DefaultDenyAnnotation of a namespace set to "true" makes its
+	// tenant default deny, i.e. its pods are isolated and only
+	// reachable as allowed by policies.
+	DefaultDenyAnnotation = "romana.io/default-deny"
+
+	// defaultSegmentName is the segment defined for provisioned
+	// tenants, same as cni.DefaultSegmentID pods get unless
+	// they have a segment label.
+	defaultSegmentName = "default"
+)
+
+// namespaceDefaultDeny returns true if the namespace
+// has DefaultDenyAnnotation set.
+func namespaceDefaultDeny(ns *v1.Namespace) bool {
+	defaultDeny, _ := strconv.ParseBool(ns.Annotations[DefaultDenyAnnotation])
+	return defaultDeny
+}
+
+// namespaceTenant returns definition of the tenant of the namespace,
+// or false if the namespace opted out of provisioning. ExternalID of
+// the tenant is UID of the namespace, so that tenants defined
+// otherwise are not touched.
+func namespaceTenant(ns *v1.Namespace) (romanaApi.TenantDefinition, bool) {
+	if value, ok := ns.Annotations[TenantAnnotation]; ok {
+		if provision, err := strconv.ParseBool(value); err == nil && !provision {
+			return romanaApi.TenantDefinition{}, false
+		}
+	}
+	return romanaApi.TenantDefinition{
+		Name:        GetTenantIDFromNamespaceObject(ns),
+		ExternalID:  string(ns.GetUID()),
+		DefaultDeny: namespaceDefaultDeny(ns),
+	}, true
+}
+
+// syncNamespaceTenant defines the tenant of the namespace and its
+// default segment, or updates the tenant if annotations of the
+// namespace changed. Tenant is deleted if the namespace opted out.
+func (l *KubeListener) syncNamespaceTenant(ns *v1.Namespace) error {
+	tenant, ok := namespaceTenant(ns)
+	if !ok {
+		return l.deleteNamespaceTenant(ns)
+	}
+	if ns.Status.Phase == v1.NamespaceTerminating {
+		return nil
+	}
+
+	err := l.client.IPAM.CreateTenant(tenant)
+	switch err.(type) {
+	case nil:
+		log.Infof("Created tenant %s for namespace %s", tenant.Name, ns.GetName())
+	case romanaErrors.RomanaExistsError:
+		current, err := l.client.IPAM.GetTenant(tenant.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get tenant %s: %s", tenant.Name, err)
+		}
+		if current.ExternalID != tenant.ExternalID {
+			log.Debugf("Tenant %s is not provisioned for namespace %s, leaving it as it is", tenant.Name, ns.GetName())
+			return nil
+		}
+		// quota of the tenant is not set from namespaces.
+		tenant.MaxAddresses = current.MaxAddresses
+		if current.TenantDefinition != tenant {
+			if err := l.client.IPAM.UpdateTenant(tenant); err != nil {
+				return fmt.Errorf("failed to update tenant %s: %s", tenant.Name, err)
+			}
+			log.Infof("Updated tenant %s for namespace %s", tenant.Name, ns.GetName())
+		}
+	default:
+		return fmt.Errorf("failed to create tenant %s: %s", tenant.Name, err)
+	}
+
+	segment := romanaApi.SegmentDefinition{Tenant: tenant.Name, Name: defaultSegmentName}
+	err = l.client.IPAM.CreateSegment(segment)
+	if _, ok := err.(romanaErrors.RomanaExistsError); err != nil && !ok {
+		return fmt.Errorf("failed to create segment %s of tenant %s: %s", segment.Name, tenant.Name, err)
+	}
+	return nil
+}
+
+// deleteNamespaceTenant deletes the tenant of the namespace and
+// its default segment, unless the tenant wasn't provisioned for
+// the namespace. Addresses still allocated to the tenant are
+// released as pods are deleted.
+func (l *KubeListener) deleteNamespaceTenant(ns *v1.Namespace) error {
+	name := GetTenantIDFromNamespaceObject(ns)
+	current, err := l.client.IPAM.GetTenant(name)
+	if err != nil || !current.Defined || current.ExternalID != string(ns.GetUID()) {
+		return nil
+	}
+
+	err = l.client.IPAM.DeleteSegment(name, defaultSegmentName, true)
+	if _, ok := err.(romanaErrors.RomanaNotFoundError); err != nil && !ok {
+		return fmt.Errorf("failed to delete segment %s of tenant %s: %s", defaultSegmentName, name, err)
+	}
+	if err := l.client.IPAM.DeleteTenant(name, true); err != nil {
+		return fmt.Errorf("failed to delete tenant %s: %s", name, err)
+	}
+	log.Infof("Deleted tenant %s of namespace %s", name, ns.GetName())
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package listener
+
+import (
+	"testing"
+
+	romanaApi "github.com/romana/core/common/api"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestNamespaceTenant(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		provisioned bool
+		defaultDeny bool
+	}{
+		{nil, true, false},
+		{map[string]string{DefaultDenyAnnotation: "true"}, true, true},
+		{map[string]string{DefaultDenyAnnotation: "false", TenantAnnotation: "true"}, true, false},
+		{map[string]string{TenantAnnotation: "false"}, false, false},
+		// invalid values are ignored.
+		{map[string]string{TenantAnnotation: "no", DefaultDenyAnnotation: "yes"}, true, false},
+	}
+	for i, tt := range tests {
+		ns := &v1.Namespace{}
+		ns.Name = "ns1"
+		ns.UID = "0d4e2c6b"
+		ns.Annotations = tt.annotations
+
+		tenant, ok := namespaceTenant(ns)
+		if ok != tt.provisioned {
+			t.Errorf("%d: expected provisioned %t, got %t", i, tt.provisioned, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		expect := romanaApi.TenantDefinition{Name: "ns1", ExternalID: "0d4e2c6b", DefaultDeny: tt.defaultDeny}
+		if tenant != expect {
+			t.Errorf("%d: expected tenant %+v, got %+v", i, expect, tenant)
+		}
+	}
+}
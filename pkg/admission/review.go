@@ -0,0 +1,78 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package admission implements a validating admission webhook, which
+// kubernetes calls to validate RomanaPolicy resources and Romana
+// labels and annotations of pods and namespaces before they are stored.
+package admission
+
+import (
+	"encoding/json"
+)
+
+// Operations of admission requests.
+const (
+	OperationCreate = "CREATE"
+	OperationUpdate = "UPDATE"
+	OperationDelete = "DELETE"
+)
+
+// AdmissionReview is a request to admit an object that kubernetes
+// sends to the webhook, and the response the webhook sends back,
+// as defined by admission.k8s.io/v1beta1.
+type AdmissionReview struct {
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Kind       string             `json:"kind,omitempty"`
+	Request    *AdmissionRequest  `json:"request,omitempty"`
+	Response   *AdmissionResponse `json:"response,omitempty"`
+}
+
+// GroupVersionKind identifies kind of the object in AdmissionRequest.
+type GroupVersionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+// AdmissionRequest describes the object to admit
+// and the operation on it.
+type AdmissionRequest struct {
+	UID       string           `json:"uid"`
+	Kind      GroupVersionKind `json:"kind"`
+	Namespace string           `json:"namespace,omitempty"`
+	Name      string           `json:"name,omitempty"`
+
+	// Operation is one of OperationCreate,
+	// OperationUpdate and OperationDelete.
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object,omitempty"`
+}
+
+// AdmissionResponse tells whether the object in request with
+// UID is allowed, and why not in Result if it's not.
+type AdmissionResponse struct {
+	UID     string           `json:"uid"`
+	Allowed bool             `json:"allowed"`
+	Result  *AdmissionStatus `json:"status,omitempty"`
+}
+
+// AdmissionStatus is the reason of denying an object,
+// its Message is shown to users of kubernetes API.
+type AdmissionStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason,omitempty"`
+	Code    int    `json:"code,omitempty"`
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/romana/core/cni"
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/pkg/policytools"
+)
+
+const (
+	// ValidatePath is the path kubernetes posts AdmissionReview to.
+	ValidatePath = "/validate"
+
+	// DefaultSegmentLabelName is the label, or annotation, of pods
+	// with their segment, same as the default of the listener.
+	DefaultSegmentLabelName = "romana.io/segment"
+
+	// isolationAnnotation of namespaces enables isolation
+	// of their pods, see listener.HandleDefaultPolicy.
+	isolationAnnotation = "net.beta.kubernetes.io/networkpolicy"
+
+	// tenantAnnotation and defaultDenyAnnotation of namespaces control
+	// their tenants, see listener.TenantAnnotation and
+	// listener.DefaultDenyAnnotation.
+	tenantAnnotation      = "romana.io/tenant"
+	defaultDenyAnnotation = "romana.io/default-deny"
+)
+
+// Webhook is a Service validating objects kubernetes is about to
+// admit: RomanaPolicy resources must have valid policies, and pods
+// and namespaces must have valid Romana labels and annotations.
+type Webhook struct {
+	Addr string
+
+	// SegmentLabelName is the label, or annotation,
+	// of pods with their segment.
+	SegmentLabelName string
+}
+
+// Routes implements method of Service interface.
+func (w *Webhook) Routes() common.Routes {
+	return common.Routes{
+		common.Route{
+			Method:      "POST",
+			Pattern:     ValidatePath,
+			Handler:     w.validate,
+			MakeMessage: func() interface{} { return &AdmissionReview{} },
+			Unversioned: true,
+		},
+	}
+}
+
+// Name implements method of Service interface.
+func (w *Webhook) Name() string {
+	return "admission"
+}
+
+// GetAddress implements method of Service interface.
+func (w *Webhook) GetAddress() string {
+	return w.Addr
+}
+
+// Initialize implements method of Service interface.
+func (w *Webhook) Initialize(config common.Config) error {
+	if w.SegmentLabelName == "" {
+		w.SegmentLabelName = DefaultSegmentLabelName
+	}
+	return nil
+}
+
+// validate responds to the AdmissionReview, denying the
+// object with messages telling what's wrong with it.
+func (w *Webhook) validate(input interface{}, ctx common.RestContext) (interface{}, error) {
+	review := input.(*AdmissionReview)
+	if review.Request == nil {
+		return nil, common.NewError400("AdmissionReview without request")
+	}
+
+	response := &AdmissionResponse{UID: review.Request.UID, Allowed: true}
+	problems, err := w.Validate(*review.Request)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("failed to decode %s: %s", review.Request.Kind.Kind, err))
+	}
+	if len(problems) > 0 {
+		response.Allowed = false
+		response.Result = &AdmissionStatus{
+			Status:  "Failure",
+			Message: strings.Join(problems, "; "),
+			Reason:  "Invalid",
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+
+	return AdmissionReview{
+		APIVersion: review.APIVersion,
+		Kind:       review.Kind,
+		Response:   response,
+	}, nil
+}
+
+// admissionObject has the parts of objects the webhook validates.
+type admissionObject struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec json.RawMessage `json:"spec"`
+}
+
+// Validate returns what's wrong with the object in the request,
+// nothing if it can be admitted. Objects of other kinds, and
+// deletions, are always admitted.
+func (w *Webhook) Validate(request AdmissionRequest) ([]string, error) {
+	if request.Operation == OperationDelete || len(request.Object) == 0 {
+		return nil, nil
+	}
+	var object admissionObject
+	if err := json.Unmarshal(request.Object, &object); err != nil {
+		return nil, err
+	}
+
+	switch request.Kind.Kind {
+	case "Pod":
+		return w.validatePod(object), nil
+	case "Namespace":
+		return validateNamespace(object), nil
+	case "RomanaPolicy":
+		return validateRomanaPolicy(object)
+	}
+	return nil, nil
+}
+
+// validatePod checks segment and bandwidth of the pod.
+func (w *Webhook) validatePod(object admissionObject) []string {
+	var problems []string
+	// CNI plugin takes the segment from either,
+	// depending on its configuration.
+	for _, source := range []struct {
+		kind   string
+		values map[string]string
+	}{
+		{"label", object.Metadata.Labels},
+		{"annotation", object.Metadata.Annotations},
+	} {
+		segment, ok := source.values[w.SegmentLabelName]
+		if !ok {
+			continue
+		}
+		if segment == "" || strings.Contains(segment, ":") {
+			problems = append(problems, fmt.Sprintf("%s %s: invalid segment %q, segment must be a non-empty name without ':'",
+				source.kind, w.SegmentLabelName, segment))
+		}
+	}
+
+	if _, err := cni.PodBandwidth(object.Metadata.Annotations); err != nil {
+		problems = append(problems, fmt.Sprintf("%s, bandwidth is bits per second with optional k, M or G suffix, e.g. 10M", err))
+	}
+	return problems
+}
+
+// validateNamespace checks isolation and tenant of the namespace.
+func validateNamespace(object admissionObject) []string {
+	var problems []string
+	for _, annotation := range []string{tenantAnnotation, defaultDenyAnnotation} {
+		value, ok := object.Metadata.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		if _, err := strconv.ParseBool(value); err != nil {
+			problems = append(problems, fmt.Sprintf(`annotation %s: invalid value %q, expected "true" or "false"`, annotation, value))
+		}
+	}
+
+	value, ok := object.Metadata.Annotations[isolationAnnotation]
+	if !ok {
+		return problems
+	}
+	isolation := struct {
+		Ingress struct {
+			Isolation string `json:"isolation"`
+		} `json:"ingress"`
+	}{}
+	if err := json.Unmarshal([]byte(value), &isolation); err != nil {
+		return append(problems, fmt.Sprintf(`annotation %s: %s, expected e.g. {"ingress": {"isolation": "DefaultDeny"}}`, isolationAnnotation, err))
+	}
+	if isolation.Ingress.Isolation != "" && isolation.Ingress.Isolation != "DefaultDeny" {
+		return append(problems, fmt.Sprintf("annotation %s: invalid isolation %q, only DefaultDeny is supported",
+			isolationAnnotation, isolation.Ingress.Isolation))
+	}
+	return problems
+}
+
+// validateRomanaPolicy checks policy in spec of the resource,
+// ID of the policy is set the way the listener sets it.
+func validateRomanaPolicy(object admissionObject) ([]string, error) {
+	var policy api.Policy
+	if len(object.Spec) > 0 {
+		if err := json.Unmarshal(object.Spec, &policy); err != nil {
+			return nil, err
+		}
+	}
+	policy.ID = fmt.Sprintf("crd.%s.%s", object.Metadata.Namespace, object.Metadata.Name)
+
+	if err := policytools.ValidatePolicy(policy); err != nil {
+		return []string{fmt.Sprintf("spec: %s", err)}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package admission
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/romana/core/common"
+)
+
+func TestValidate(t *testing.T) {
+	w := &Webhook{}
+	if err := w.Initialize(common.Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		kind      string
+		operation string
+		object    string
+		problems  []string
+	}{
+		{
+			name:   "pod without romana labels",
+			kind:   "Pod",
+			object: `{"metadata": {"name": "web", "labels": {"app": "web"}}}`,
+		},
+		{
+			name:   "pod with segment and bandwidth",
+			kind:   "Pod",
+			object: `{"metadata": {"labels": {"romana.io/segment": "frontend"}, "annotations": {"romana.io/ingress-bandwidth": "10M"}}}`,
+		},
+		{
+			name:     "pod with empty segment annotation",
+			kind:     "Pod",
+			object:   `{"metadata": {"annotations": {"romana.io/segment": ""}}}`,
+			problems: []string{"annotation romana.io/segment: invalid segment"},
+		},
+		{
+			name:     "pod with invalid bandwidth",
+			kind:     "Pod",
+			object:   `{"metadata": {"annotations": {"romana.io/egress-bandwidth": "fast"}}}`,
+			problems: []string{"invalid romana.io/egress-bandwidth annotation"},
+		},
+		{
+			name:      "pod deleted",
+			kind:      "Pod",
+			operation: OperationDelete,
+			object:    `{"metadata": {"annotations": {"romana.io/egress-bandwidth": "fast"}}}`,
+		},
+		{
+			name:   "isolated namespace",
+			kind:   "Namespace",
+			object: `{"metadata": {"annotations": {"net.beta.kubernetes.io/networkpolicy": "{\"ingress\": {\"isolation\": \"DefaultDeny\"}}"}}}`,
+		},
+		{
+			name:     "namespace with unknown isolation",
+			kind:     "Namespace",
+			object:   `{"metadata": {"annotations": {"net.beta.kubernetes.io/networkpolicy": "{\"ingress\": {\"isolation\": \"Deny\"}}"}}}`,
+			problems: []string{`invalid isolation "Deny"`},
+		},
+		{
+			name:     "namespace with malformed isolation",
+			kind:     "Namespace",
+			object:   `{"metadata": {"annotations": {"net.beta.kubernetes.io/networkpolicy": "DefaultDeny"}}}`,
+			problems: []string{"annotation net.beta.kubernetes.io/networkpolicy"},
+		},
+		{
+			name:   "namespace with tenant annotations",
+			kind:   "Namespace",
+			object: `{"metadata": {"annotations": {"romana.io/tenant": "false", "romana.io/default-deny": "true"}}}`,
+		},
+		{
+			name:     "namespace with invalid default deny",
+			kind:     "Namespace",
+			object:   `{"metadata": {"annotations": {"romana.io/default-deny": "yes please"}}}`,
+			problems: []string{`annotation romana.io/default-deny: invalid value "yes please"`},
+		},
+		{
+			name:   "valid policy",
+			kind:   "RomanaPolicy",
+			object: `{"metadata": {"name": "allow-all", "namespace": "default"}, "spec": {"direction": "ingress", "applied_to": [{"tenant_id": "default"}], "ingress": [{"peers": [{"peer": "any"}], "rules": [{"protocol": "any"}]}]}}`,
+		},
+		{
+			name:     "policy without target",
+			kind:     "RomanaPolicy",
+			object:   `{"metadata": {"name": "allow-all", "namespace": "default"}, "spec": {"direction": "ingress", "ingress": [{"peers": [{"peer": "any"}], "rules": [{"protocol": "any"}]}]}}`,
+			problems: []string{"spec: "},
+		},
+	}
+
+	for _, tt := range tests {
+		operation := tt.operation
+		if operation == "" {
+			operation = OperationCreate
+		}
+		request := AdmissionRequest{
+			UID:       "1",
+			Kind:      GroupVersionKind{Kind: tt.kind},
+			Operation: operation,
+			Object:    []byte(tt.object),
+		}
+		problems, err := w.Validate(request)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+			continue
+		}
+		if len(problems) != len(tt.problems) {
+			t.Errorf("%s: expected %d problems, got %q", tt.name, len(tt.problems), problems)
+			continue
+		}
+		for i := range problems {
+			if !strings.Contains(problems[i], tt.problems[i]) {
+				t.Errorf("%s: expected problem with %q, got %q", tt.name, tt.problems[i], problems[i])
+			}
+		}
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	w := &Webhook{}
+	w.Initialize(common.Config{})
+
+	review := &AdmissionReview{
+		APIVersion: "admission.k8s.io/v1beta1",
+		Kind:       "AdmissionReview",
+		Request: &AdmissionRequest{
+			UID:       "705ab4f5",
+			Kind:      GroupVersionKind{Kind: "Pod"},
+			Operation: OperationUpdate,
+			Object:    []byte(`{"metadata": {"annotations": {"romana.io/segment": "", "romana.io/ingress-bandwidth": "1"}}}`),
+		},
+	}
+	output, err := w.validate(review, common.RestContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := output.(AdmissionReview).Response
+	if response == nil || response.UID != "705ab4f5" || response.Allowed {
+		t.Fatalf("Expected denial of request 705ab4f5, got %+v", response)
+	}
+	if response.Result == nil || response.Result.Code != 422 ||
+		!strings.Contains(response.Result.Message, "invalid segment") ||
+		!strings.Contains(response.Result.Message, "too low") {
+		t.Errorf("Expected status with both problems, got %+v", response.Result)
+	}
+
+	if _, err := w.validate(&AdmissionReview{}, common.RestContext{}); err == nil {
+		t.Error("Expected error for review without request")
+	}
+}
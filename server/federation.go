@@ -0,0 +1,290 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+// This file has handlers of the parent romanad of federated clusters,
+// and the client of romanad of a federated cluster to the parent.
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/api/errors"
+	"github.com/romana/core/common/client"
+	log "github.com/romana/rlog"
+)
+
+// federationTimeout is the timeout of requests to the parent romanad.
+const federationTimeout = 10 * time.Second
+
+// listFederationPools returns pools shared by federated clusters
+// with utilization of their super-blocks.
+func (r *Romanad) listFederationPools(input interface{}, ctx common.RestContext) (interface{}, error) {
+	pools := r.client.IPAM.ListFederationPools()
+	return common.ListItems(ctx, &pools, poolFields, &pools)
+}
+
+// addFederationPool defines the pool given in the request.
+func (r *Romanad) addFederationPool(input interface{}, ctx common.RestContext) (interface{}, error) {
+	pool := input.(*api.FederationPool)
+	err := r.client.IPAM.AddFederationPool(*pool)
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
+
+// deleteFederationPool deletes the pool, it fails while super-blocks
+// of the pool are delegated unless force query parameter is true.
+func (r *Romanad) deleteFederationPool(input interface{}, ctx common.RestContext) (interface{}, error) {
+	force, err := forceParam(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = r.client.IPAM.DeleteFederationPool(ctx.PathVariables["pool"], force)
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
+
+// listDelegations returns super-blocks delegated to clusters,
+// e.g. /federation/delegations?cluster=east for one cluster.
+func (r *Romanad) listDelegations(input interface{}, ctx common.RestContext) (interface{}, error) {
+	delegations := r.client.IPAM.ListDelegations()
+	return common.ListItems(ctx, &delegations, delegationFields, &delegations)
+}
+
+// delegateSuperBlock delegates a super-block of the pool
+// to the cluster given in the request.
+func (r *Romanad) delegateSuperBlock(input interface{}, ctx common.RestContext) (interface{}, error) {
+	req := input.(*api.DelegationRequest)
+	delegation, err := r.client.IPAM.DelegateSuperBlock(*req)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	return delegation, nil
+}
+
+// releaseDelegation returns the super-block given by cidr query
+// parameter to its pool, it fails while the cluster reports
+// addresses allocated in it unless force query parameter is true.
+func (r *Romanad) releaseDelegation(input interface{}, ctx common.RestContext) (interface{}, error) {
+	cidr := ctx.QueryVariables.Get("cidr")
+	if cidr == "" {
+		return nil, common.NewError400("cidr required")
+	}
+	force, err := forceParam(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = r.client.IPAM.ReleaseDelegation(cidr, force)
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
+
+// reportClusterUsage records utilization of networks
+// reported by romanad of the cluster.
+func (r *Romanad) reportClusterUsage(input interface{}, ctx common.RestContext) (interface{}, error) {
+	usage := input.(*api.ClusterUsage)
+	err := r.client.IPAM.ReportClusterUsage(ctx.PathVariables["cluster"], *usage)
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
+
+// federation is the client of romanad of a federated
+// cluster to the parent romanad, see common.Federation.
+type federation struct {
+	config   common.Federation
+	password string
+	client   *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+func newFederation(config common.Federation) (*federation, error) {
+	parsed, err := url.Parse(config.ParentURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, common.NewError("Invalid federation parent URL %s", config.ParentURL)
+	}
+	if config.Cluster == "" {
+		return nil, common.NewError("Cluster name required in federation with %s", config.ParentURL)
+	}
+	if config.ReportInterval <= 0 {
+		config.ReportInterval = common.DefaultFederationReportInterval
+	}
+	if (config.Username == "") != (config.PasswordFile == "") {
+		return nil, common.NewError("Both username and password file required to authenticate to %s", config.ParentURL)
+	}
+
+	f := &federation{
+		config: config,
+		client: &http.Client{Timeout: federationTimeout},
+	}
+	if config.PasswordFile != "" {
+		password, err := ioutil.ReadFile(config.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		f.password = strings.TrimSpace(string(password))
+	}
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, common.NewError("No certificates in %s", config.CAFile)
+		}
+		f.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	return f, nil
+}
+
+// authToken returns the token authenticating requests to the parent,
+// empty if requests are not authenticated. Token is issued by the
+// parent when there's none yet, or when expired is the current one.
+func (f *federation) authToken(expired string) (string, error) {
+	if f.config.Username == "" {
+		return "", nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.token != "" && f.token != expired {
+		return f.token, nil
+	}
+
+	var msg common.AuthTokenMessage
+	target := strings.TrimSuffix(f.config.ParentURL, "/") + common.AuthPath
+	req := common.AuthRequest{Username: f.config.Username, Password: f.password}
+	if _, err := f.send(http.MethodPost, target, "", req, &msg); err != nil {
+		return "", err
+	}
+	f.token = msg.Token
+	return f.token, nil
+}
+
+// do sends the request to the parent and decodes the response into
+// result, unless it is nil. Request rejected as unauthorized is sent
+// again once with a new token, as the parent's tokens expire.
+func (f *federation) do(method string, path string, body interface{}, result interface{}) error {
+	target := strings.TrimSuffix(f.config.ParentURL, "/") + common.VersionedPath(common.APIVersion, path)
+	token, err := f.authToken("")
+	if err != nil {
+		return err
+	}
+	status, err := f.send(method, target, token, body, result)
+	if status == http.StatusUnauthorized && token != "" {
+		if token, err = f.authToken(token); err != nil {
+			return err
+		}
+		_, err = f.send(method, target, token, body, result)
+	}
+	return err
+}
+
+// send sends the request with the token to target and decodes the
+// response into result, unless it is nil. It returns status code
+// of the response, or 0 if there's none.
+func (f *federation) send(method string, target string, token string, body interface{}, result interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	request, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := f.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		b, _ := ioutil.ReadAll(response.Body)
+		return response.StatusCode, fmt.Errorf("%s %s: %s %s", method, target, response.Status, bytes.TrimSpace(b))
+	}
+	if result == nil {
+		return response.StatusCode, nil
+	}
+	return response.StatusCode, json.NewDecoder(response.Body).Decode(result)
+}
+
+// delegations returns super-blocks delegated to the cluster.
+func (f *federation) delegations() ([]api.Delegation, error) {
+	var delegations []api.Delegation
+	err := f.do(http.MethodGet, "/federation/delegations?cluster="+url.QueryEscape(f.config.Cluster), nil, &delegations)
+	return delegations, err
+}
+
+// report reports utilization of networks of the cluster.
+func (f *federation) report(networks []api.IPAMNetworkResponse) error {
+	path := "/federation/clusters/" + url.PathEscape(f.config.Cluster) + "/usage"
+	return f.do(http.MethodPut, path, api.ClusterUsage{Networks: networks}, nil)
+}
+
+// reportUsage reports utilization of networks of
+// the cluster to the parent every report interval.
+func (r *Romanad) reportUsage() {
+	ticker := time.NewTicker(r.federation.config.ReportInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := r.federation.report(r.client.IPAM.ListNetworks())
+		if err != nil {
+			log.Errorf("Error reporting usage of cluster %s to %s: %s",
+				r.federation.config.Cluster, r.federation.config.ParentURL, err)
+		}
+	}
+}
+
+// checkDelegations returns an error if networks of the topology
+// are not in super-blocks delegated to the federated cluster.
+func (r *Romanad) checkDelegations(topoReq *api.TopologyUpdateRequest) error {
+	if r.federation == nil {
+		return nil
+	}
+	delegations, err := r.federation.delegations()
+	if err != nil {
+		return common.HttpError{
+			StatusCode: http.StatusServiceUnavailable,
+			Details:    fmt.Sprintf("Cannot get super-blocks delegated to cluster %s: %s", r.federation.config.Cluster, err),
+		}
+	}
+	outside := client.NetworksOutsideDelegations(topoReq.Networks, delegations)
+	if len(outside) > 0 {
+		return common.NewUnprocessableEntityError(fmt.Sprintf(
+			"Networks %s are not in super-blocks delegated to cluster %s",
+			strings.Join(outside, ", "), r.federation.config.Cluster))
+	}
+	return nil
+}
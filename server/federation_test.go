@@ -0,0 +1,108 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+)
+
+func TestFederation(t *testing.T) {
+	var reported api.ClusterUsage
+	var issued int
+	var valid string
+	parent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == common.AuthPath {
+			var auth common.AuthRequest
+			if err := json.NewDecoder(req.Body).Decode(&auth); err != nil || auth.Username != "east" || auth.Password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			issued++
+			valid = fmt.Sprintf("token%d", issued)
+			json.NewEncoder(w).Encode(common.AuthTokenMessage{Token: valid})
+			return
+		}
+		if valid == "" || req.Header.Get("Authorization") != "Bearer "+valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case req.Method == http.MethodGet && req.URL.Path == "/v1/federation/delegations":
+			if req.URL.Query().Get("cluster") != "east" {
+				t.Errorf("Expected delegations of east, got %s", req.URL)
+			}
+			w.Write([]byte(`[{"pool": "pool1", "cluster": "east", "cidr": "10.1.0.0/17"}]`))
+		case req.Method == http.MethodPut && req.URL.Path == "/v1/federation/clusters/east/usage":
+			if err := json.NewDecoder(req.Body).Decode(&reported); err != nil {
+				t.Error(err)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer parent.Close()
+
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFederation(common.Federation{ParentURL: "parent", Cluster: "east"}); err == nil {
+		t.Fatal("Expected federation with invalid parent URL to fail")
+	}
+	if _, err := newFederation(common.Federation{ParentURL: parent.URL, Cluster: "east", Username: "east"}); err == nil {
+		t.Fatal("Expected federation with username and no password file to fail")
+	}
+	f, err := newFederation(common.Federation{ParentURL: parent.URL, Cluster: "east", Username: "east", PasswordFile: passwordFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Romanad{federation: f}
+
+	topoReq := &api.TopologyUpdateRequest{Networks: []api.NetworkDefinition{{Name: "net1", CIDR: "10.1.0.0/24"}}}
+	if err := r.checkDelegations(topoReq); err != nil {
+		t.Fatal(err)
+	}
+	topoReq.Networks = append(topoReq.Networks, api.NetworkDefinition{Name: "net2", CIDR: "10.2.0.0/24"})
+	err = r.checkDelegations(topoReq)
+	if httpErr, ok := err.(common.HttpError); !ok || httpErr.StatusCode != common.StatusUnprocessableEntity {
+		t.Fatalf("Expected 422 for network outside of delegations, got %v", err)
+	}
+
+	// token expired, a new one is issued and the request is sent again.
+	valid = ""
+	networks := []api.IPAMNetworkResponse{{Name: "net1", Allocated: 3}}
+	if err := networks[0].CIDR.UnmarshalText([]byte("10.1.0.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.report(networks); err != nil {
+		t.Fatal(err)
+	}
+	if len(reported.Networks) != 1 || reported.Networks[0].Allocated != 3 {
+		t.Fatalf("Unexpected usage reported %+v", reported)
+	}
+	if issued != 2 {
+		t.Fatalf("Expected 2 tokens issued, got %d", issued)
+	}
+}
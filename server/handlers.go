@@ -16,12 +16,16 @@
 package server
 
 import (
+	"net"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/romana/core/common"
 	"github.com/romana/core/common/api"
 	"github.com/romana/core/common/api/errors"
 	"github.com/romana/core/common/client"
+	"github.com/romana/core/pkg/policytools"
 )
 
 // deallocateIP deallocates IP specified by query parameter
@@ -29,7 +33,11 @@ import (
 func (r *Romanad) deallocateIP(input interface{}, ctx common.RestContext) (interface{}, error) {
 	addressName := ctx.QueryVariables.Get("addressName")
 	err := r.client.IPAM.DeallocateIP(addressName)
-	return nil, errors.RomanaErrorToHTTPError(err)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	r.notify(ctx, common.ResourceAllocation, common.ActionDeleted, addressName, nil)
+	return nil, nil
 }
 
 func (r *Romanad) allocateIP(input interface{}, ctx common.RestContext) (interface{}, error) {
@@ -40,46 +48,123 @@ func (r *Romanad) allocateIP(input interface{}, ctx common.RestContext) (interfa
 	if req.Host == "" {
 		return nil, common.NewError400("Host required")
 	}
-	retval, err := r.client.IPAM.AllocateIP(req.Name, req.Host, req.Tenant, req.Segment)
-	return retval, errors.RomanaErrorToHTTPError(err)
+	if req.RequireZone && req.Zone == "" {
+		return nil, common.NewError400("Zone required with require_zone")
+	}
+	retval, err := r.client.IPAM.AllocateIPInZoneContext(ctx.Context, req.Name, req.Host, req.Tenant, req.Segment, req.Zone, req.RequireZone)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	r.notify(ctx, common.ResourceAllocation, common.ActionCreated, req.Name, api.IPAMAddress{
+		Name:    req.Name,
+		IP:      retval,
+		Host:    req.Host,
+		Tenant:  req.Tenant,
+		Segment: req.Segment,
+	})
+	return retval, nil
 }
 
 // listHosts returns all hosts.
 func (r *Romanad) listHosts(input interface{}, ctx common.RestContext) (interface{}, error) {
-	return r.client.IPAM.ListHosts(), nil
+	hosts := r.client.IPAM.ListHosts()
+	return common.ListItems(ctx, &hosts.Hosts, hostFields, &hosts)
 }
 
 func (r *Romanad) listNetworkBlocks(input interface{}, ctx common.RestContext) (interface{}, error) {
 	netName := ctx.PathVariables["network"]
-	return r.client.IPAM.ListNetworkBlocks(netName), nil
+	blocks := r.client.IPAM.ListNetworkBlocks(netName)
+	if blocks == nil {
+		return nil, nil
+	}
+	return common.ListItems(ctx, &blocks.Blocks, blockFields, blocks)
 }
 
+// listAllBlocks returns blocks, optionally only those of
+// the host and network given by query parameters "host"
+// and "network".
 func (r *Romanad) listAllBlocks(input interface{}, ctx common.RestContext) (interface{}, error) {
-	return r.client.IPAM.ListAllBlocks(), nil
+	host := ctx.QueryVariables.Get("host")
+	network := ctx.QueryVariables.Get("network")
+	blocks := r.client.IPAM.ListBlocks(host, network)
+	return common.ListItems(ctx, &blocks.Blocks, blockFields, blocks)
 }
 
 func (r *Romanad) listNetworks(input interface{}, ctx common.RestContext) (interface{}, error) {
-	resp := make([]api.IPAMNetworkResponse, 0)
-	for _, network := range r.client.IPAM.Networks {
-		n := api.IPAMNetworkResponse{
-			CIDR:     api.IPNet{IPNet: *network.CIDR.IPNet},
-			Name:     network.Name,
-			Revision: network.Revison,
-		}
-		resp = append(resp, n)
+	networks := r.client.IPAM.ListNetworks()
+	return common.ListItems(ctx, &networks, networkFields, &networks)
+}
+
+// listAddresses returns allocated addresses.
+func (r *Romanad) listAddresses(input interface{}, ctx common.RestContext) (interface{}, error) {
+	addresses := r.client.IPAM.ListAddresses()
+	return common.ListItems(ctx, &addresses, addressFields, &addresses)
+}
+
+// importAddresses allocates addresses at the IPs given, and returns
+// how many were imported and how many skipped.
+func (r *Romanad) importAddresses(input interface{}, ctx common.RestContext) (interface{}, error) {
+	addresses := input.(*[]api.IPAMAddress)
+	resp, err := r.client.IPAM.ImportAddresses(*addresses)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	for _, address := range resp.Addresses {
+		r.notify(ctx, common.ResourceAllocation, common.ActionCreated, address.Name, address)
 	}
 	return resp, nil
 }
 
+// blackOut blacks out CIDR given in the request.
+func (r *Romanad) blackOut(input interface{}, ctx common.RestContext) (interface{}, error) {
+	req := input.(*api.IPAMBlackOutRequest)
+	if req.CIDR == "" {
+		return nil, common.NewError400("CIDR required")
+	}
+	err := r.client.IPAM.BlackOut(req.CIDR)
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
+
+// unBlackOut returns CIDR given by query parameter "cidr" to the pool.
+func (r *Romanad) unBlackOut(input interface{}, ctx common.RestContext) (interface{}, error) {
+	cidr := ctx.QueryVariables.Get("cidr")
+	if cidr == "" {
+		return nil, common.NewError400("CIDR required")
+	}
+	err := r.client.IPAM.UnBlackOut(cidr)
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
+
 // getTopology returns the latest Romana Topology in kvstore (etcd).
 func (r *Romanad) getTopology(input interface{}, ctx common.RestContext) (interface{}, error) {
 	return r.client.GetTopology()
 }
 
-// updateTopology serves to update topology information in the Romana service
+// updateTopology serves to update topology information in the Romana service,
+// with dry_run=true it returns plan of the update without applying it.
 func (r *Romanad) updateTopology(input interface{}, ctx common.RestContext) (interface{}, error) {
 	topoReq := input.(*api.TopologyUpdateRequest)
-	return nil, r.client.IPAM.UpdateTopology(*topoReq, true)
+	dryRun, err := boolParam(ctx, "dry_run")
+	if err != nil {
+		return nil, err
+	}
+	err = r.checkDelegations(topoReq)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		plan, err := r.client.IPAM.PlanUpdateTopology(*topoReq)
+		if err != nil {
+			return nil, errors.RomanaErrorToHTTPError(err)
+		}
+		return plan, nil
+	}
+	err = r.client.IPAM.UpdateTopology(*topoReq, true)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	r.notify(ctx, common.ResourceTopology, common.ActionUpdated, "", topoReq)
+	return nil, nil
 }
 
 // getPolicy is a handler for the /policy/{name} URL that
@@ -113,6 +198,7 @@ func (r *Romanad) deletePolicy(input interface{}, ctx common.RestContext) (inter
 		return nil, err
 	}
 	if found {
+		r.notify(ctx, common.ResourcePolicy, common.ActionDeleted, policyID, nil)
 		return nil, nil
 	} else {
 		return nil, common.NewError404("policy", policyID)
@@ -121,18 +207,347 @@ func (r *Romanad) deletePolicy(input interface{}, ctx common.RestContext) (inter
 
 // listPolicies lists all policices.
 func (r *Romanad) listPolicies(input interface{}, ctx common.RestContext) (interface{}, error) {
-	return r.client.ListPolicies()
+	policies, err := r.client.ListPolicies()
+	if err != nil {
+		return nil, err
+	}
+	return common.ListItems(ctx, &policies, policyFields, &policies)
 }
 
 // addPolicy stores the new policy and sends it to all agents.
 func (r *Romanad) addPolicy(input interface{}, ctx common.RestContext) (interface{}, error) {
 	policy := input.(*api.Policy)
-	return nil, r.client.AddPolicy(*policy)
+	revision := ctx.QueryVariables.Get("revision")
+	if revision == "" {
+		if err := r.client.AddPolicy(*policy); err != nil {
+			return nil, err
+		}
+		r.notify(ctx, common.ResourcePolicy, common.ActionCreated, policy.ID, policy)
+		return nil, nil
+	}
+
+	// revision makes the update fail if policy was modified since.
+	rev, err := strconv.ParseUint(revision, 10, 64)
+	if err != nil {
+		return nil, common.NewError400("Invalid revision " + revision)
+	}
+	_, err = r.client.UpdatePolicy(*policy, rev)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	r.notify(ctx, common.ResourcePolicy, common.ActionUpdated, policy.ID, policy)
+	return nil, nil
 }
 
-// addPolicy stores the new policy and sends it to all agents.
+// applyPolicies stores all policies in the request, see
+// Client.ApplyPolicies. Policies are validated first, and if any
+// of them is invalid none is stored and 422 is returned with results
+// telling which policies are invalid.
+func (r *Romanad) applyPolicies(input interface{}, ctx common.RestContext) (interface{}, error) {
+	policies := *input.(*[]api.Policy)
+	results := make([]api.PolicyResult, len(policies))
+	valid := true
+	ids := make(map[string]bool)
+	for i, policy := range policies {
+		results[i].ID = policy.ID
+		var err error
+		switch {
+		case policy.ID == "":
+			err = common.NewError("policy ID required")
+		case ids[policy.ID]:
+			err = common.NewError("duplicate policy ID %s", policy.ID)
+		default:
+			err = policytools.ValidatePolicy(policy)
+		}
+		ids[policy.ID] = true
+		if err != nil {
+			results[i].Result = api.PolicyInvalid
+			results[i].Error = err.Error()
+			valid = false
+		}
+	}
+	if !valid {
+		return nil, common.NewUnprocessableEntityError(results)
+	}
+
+	results, err := r.client.ApplyPolicies(policies)
+	if err != nil {
+		return results, errors.RomanaErrorToHTTPError(err)
+	}
+	for i, result := range results {
+		switch result.Result {
+		case api.PolicyCreated:
+			r.notify(ctx, common.ResourcePolicy, common.ActionCreated, result.ID, policies[i])
+		case api.PolicyUpdated:
+			r.notify(ctx, common.ResourcePolicy, common.ActionUpdated, result.ID, policies[i])
+		}
+	}
+	return results, nil
+}
+
+// addHost adds host to the topology.
 func (r *Romanad) addHost(input interface{}, ctx common.RestContext) (interface{}, error) {
 	host := input.(*api.Host)
 	err := r.client.IPAM.AddHost(*host)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	r.notify(ctx, common.ResourceHost, common.ActionCreated, host.Name, host)
+	return nil, nil
+}
+
+// removeHost removes host given by its name or IP.
+func (r *Romanad) removeHost(input interface{}, ctx common.RestContext) (interface{}, error) {
+	err := r.client.IPAM.RemoveHost(hostFromPath(ctx))
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	r.notify(ctx, common.ResourceHost, common.ActionDeleted, ctx.PathVariables["host"], nil)
+	return nil, nil
+}
+
+// hostFromPath returns host given by its name or IP
+// in the path of the request.
+func hostFromPath(ctx common.RestContext) api.Host {
+	var host api.Host
+	if ip := net.ParseIP(ctx.PathVariables["host"]); ip != nil {
+		host.IP = ip
+	} else {
+		host.Name = ctx.PathVariables["host"]
+	}
+	return host
+}
+
+// cordonHost stops allocation of new addresses on the host.
+func (r *Romanad) cordonHost(input interface{}, ctx common.RestContext) (interface{}, error) {
+	err := r.client.IPAM.CordonHost(hostFromPath(ctx), true)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	r.notify(ctx, common.ResourceHost, common.ActionUpdated, ctx.PathVariables["host"], nil)
+	return nil, nil
+}
+
+// uncordonHost resumes allocation of new addresses on the host.
+func (r *Romanad) uncordonHost(input interface{}, ctx common.RestContext) (interface{}, error) {
+	err := r.client.IPAM.CordonHost(hostFromPath(ctx), false)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	r.notify(ctx, common.ResourceHost, common.ActionUpdated, ctx.PathVariables["host"], nil)
+	return nil, nil
+}
+
+// listHostAddresses returns addresses still allocated on the host.
+func (r *Romanad) listHostAddresses(input interface{}, ctx common.RestContext) (interface{}, error) {
+	addresses, err := r.client.IPAM.ListHostAddresses(hostFromPath(ctx))
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	return common.ListItems(ctx, &addresses, addressFields, &addresses)
+}
+
+// listTenants returns defined tenants and tenants that have blocks.
+func (r *Romanad) listTenants(input interface{}, ctx common.RestContext) (interface{}, error) {
+	tenants := r.client.IPAM.ListTenantDefinitions()
+	return common.ListItems(ctx, &tenants, tenantFields, &tenants)
+}
+
+// createTenant defines the tenant given in the request.
+func (r *Romanad) createTenant(input interface{}, ctx common.RestContext) (interface{}, error) {
+	tenant := input.(*api.TenantDefinition)
+	if tenant.Name == "" {
+		return nil, common.NewError400("Name required")
+	}
+	if strings.Contains(tenant.Name, ":") {
+		return nil, common.NewError400("Name must not contain ':'")
+	}
+	if tenant.MaxAddresses < 0 {
+		return nil, common.NewError400("Address quota must not be negative")
+	}
+	err := r.client.IPAM.CreateTenant(*tenant)
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
+
+// getTenant returns the tenant given by its name.
+func (r *Romanad) getTenant(input interface{}, ctx common.RestContext) (interface{}, error) {
+	tenant, err := r.client.IPAM.GetTenant(ctx.PathVariables["tenant"])
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	return tenant, nil
+}
+
+// deleteTenant deletes definition of the tenant, it fails while the
+// tenant has addresses allocated unless query parameter "force" is true.
+func (r *Romanad) deleteTenant(input interface{}, ctx common.RestContext) (interface{}, error) {
+	force, err := forceParam(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = r.client.IPAM.DeleteTenant(ctx.PathVariables["tenant"], force)
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
+
+// listSegments returns segments of the tenant along with IDs
+// of policies applied to them or allowing them as peers.
+func (r *Romanad) listSegments(input interface{}, ctx common.RestContext) (interface{}, error) {
+	tenant := ctx.PathVariables["tenant"]
+	segments := r.client.IPAM.ListSegments(tenant)
+
+	policies, err := r.client.ListPolicies()
+	if err != nil {
+		return nil, err
+	}
+	for i := range segments {
+		for _, p := range policies {
+			if policyReferencesSegment(p, tenant, segments[i].Name) {
+				segments[i].Policies = append(segments[i].Policies, p.ID)
+			}
+		}
+	}
+	return common.ListItems(ctx, &segments, segmentFields, &segments)
+}
+
+// policyReferencesSegment returns true if the policy is applied
+// to the segment of the tenant or has it as an ingress peer.
+func policyReferencesSegment(policy api.Policy, tenant string, segment string) bool {
+	endpoints := policy.AppliedTo
+	for _, ingress := range policy.Ingress {
+		endpoints = append(endpoints[:len(endpoints):len(endpoints)], ingress.Peers...)
+	}
+	for _, e := range endpoints {
+		if e.TenantID == tenant && e.SegmentID == segment {
+			return true
+		}
+	}
+	return false
+}
+
+// createSegment defines the segment given in the request
+// for the tenant of the path.
+func (r *Romanad) createSegment(input interface{}, ctx common.RestContext) (interface{}, error) {
+	segment := input.(*api.SegmentDefinition)
+	segment.Tenant = ctx.PathVariables["tenant"]
+	if segment.Name == "" {
+		return nil, common.NewError400("Name required")
+	}
+	if strings.Contains(segment.Tenant, ":") {
+		return nil, common.NewError400("Tenant must not contain ':'")
+	}
+	err := r.client.IPAM.CreateSegment(*segment)
 	return nil, errors.RomanaErrorToHTTPError(err)
 }
+
+// deleteSegment deletes the segment, it fails while the segment has
+// addresses allocated unless query parameter "force" is true, in which
+// case its addresses and blocks are released.
+func (r *Romanad) deleteSegment(input interface{}, ctx common.RestContext) (interface{}, error) {
+	force, err := forceParam(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = r.client.IPAM.DeleteSegment(ctx.PathVariables["tenant"], ctx.PathVariables["segment"], force)
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
+
+// forceParam returns value of query parameter "force", false if not given.
+func forceParam(ctx common.RestContext) (bool, error) {
+	return boolParam(ctx, "force")
+}
+
+// boolParam returns value of boolean query parameter, false if not given.
+func boolParam(ctx common.RestContext, name string) (bool, error) {
+	s := ctx.QueryVariables.Get(name)
+	if s == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, common.NewError400("Invalid " + name + " " + s)
+	}
+	return value, nil
+}
+
+// getVersion returns build information of romanad and
+// versions reported by live agents.
+func (r *Romanad) getVersion(input interface{}, ctx common.RestContext) (interface{}, error) {
+	hosts, err := r.client.LiveHosts()
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+
+	resp := api.VersionResponse{
+		Romanad: common.GetVersionInfo(),
+		Agents:  make([]api.AgentVersion, 0, len(hosts)),
+	}
+	for _, liveness := range hosts {
+		resp.Agents = append(resp.Agents, api.AgentVersion{Host: liveness.Host, Version: liveness.Version})
+	}
+	sort.Slice(resp.Agents, func(i, j int) bool { return resp.Agents[i].Host < resp.Agents[j].Host })
+	return resp, nil
+}
+
+// getStats returns summary of the cluster, including how far
+// live agents are behind on programming routes for blocks.
+func (r *Romanad) getStats(input interface{}, ctx common.RestContext) (interface{}, error) {
+	stats := r.client.IPAM.Stats()
+
+	hosts, err := r.client.LiveHosts()
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	stats.LiveHosts = len(hosts)
+	for _, liveness := range hosts {
+		agent := api.AgentStats{
+			Host:           liveness.Host,
+			Version:        liveness.Version,
+			Renewed:        liveness.Renewed,
+			BlocksRevision: liveness.BlocksRevision,
+			Converged:      liveness.Converged,
+		}
+		if agent.BlocksRevision < stats.BlocksRevision {
+			agent.Lag = stats.BlocksRevision - agent.BlocksRevision
+		}
+		stats.Agents = append(stats.Agents, agent)
+	}
+	sort.Slice(stats.Agents, func(i, j int) bool { return stats.Agents[i].Host < stats.Agents[j].Host })
+
+	policies, err := r.client.ListPolicies()
+	if err != nil {
+		return nil, err
+	}
+	stats.Policies = len(policies)
+	tenantPolicies := make(map[string]int)
+	for _, p := range policies {
+		tenants := make(map[string]bool)
+		for _, e := range p.AppliedTo {
+			if e.TenantID != "" {
+				tenants[e.TenantID] = true
+			}
+		}
+		for tenant := range tenants {
+			tenantPolicies[tenant]++
+		}
+	}
+	for i := range stats.Tenants {
+		stats.Tenants[i].Policies = tenantPolicies[stats.Tenants[i].Name]
+		delete(tenantPolicies, stats.Tenants[i].Name)
+	}
+	// tenants with policies but without endpoints.
+	for tenant, count := range tenantPolicies {
+		stats.Tenants = append(stats.Tenants, api.TenantStats{Name: tenant, Policies: count})
+	}
+	sort.Slice(stats.Tenants, func(i, j int) bool { return stats.Tenants[i].Name < stats.Tenants[j].Name })
+
+	return stats, nil
+}
+
+// listEvents lists events of the cluster, oldest first.
+func (r *Romanad) listEvents(input interface{}, ctx common.RestContext) (interface{}, error) {
+	events, err := r.client.ListEvents()
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	return common.ListItems(ctx, &events, eventFields, &events)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+// Fields of items of list endpoints, see common.ListFields.
+
+import (
+	"strconv"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+)
+
+var hostFields = common.ListFields{
+	"name": func(i interface{}) []string { return []string{i.(api.Host).Name} },
+	"ip":   func(i interface{}) []string { return []string{i.(api.Host).IP.String()} },
+}
+
+var blockFields = common.ListFields{
+	"cidr": func(i interface{}) []string {
+		cidr := i.(api.IPAMBlockResponse).CIDR
+		return []string{cidr.String()}
+	},
+	"host":    func(i interface{}) []string { return []string{i.(api.IPAMBlockResponse).Host} },
+	"tenant":  func(i interface{}) []string { return []string{i.(api.IPAMBlockResponse).Tenant} },
+	"segment": func(i interface{}) []string { return []string{i.(api.IPAMBlockResponse).Segment} },
+	"network": func(i interface{}) []string { return []string{i.(api.IPAMBlockResponse).Network} },
+	"group":   func(i interface{}) []string { return []string{i.(api.IPAMBlockResponse).Group} },
+	"allocated": func(i interface{}) []string {
+		return []string{strconv.Itoa(i.(api.IPAMBlockResponse).AllocatedIPCount)}
+	},
+}
+
+var networkFields = common.ListFields{
+	"name": func(i interface{}) []string { return []string{i.(api.IPAMNetworkResponse).Name} },
+	"cidr": func(i interface{}) []string {
+		cidr := i.(api.IPAMNetworkResponse).CIDR
+		return []string{cidr.String()}
+	},
+	"allocated": func(i interface{}) []string { return []string{strconv.Itoa(i.(api.IPAMNetworkResponse).Allocated)} },
+}
+
+var addressFields = common.ListFields{
+	"name":    func(i interface{}) []string { return []string{i.(api.IPAMAddress).Name} },
+	"ip":      func(i interface{}) []string { return []string{i.(api.IPAMAddress).IP.String()} },
+	"network": func(i interface{}) []string { return []string{i.(api.IPAMAddress).Network} },
+	"host":    func(i interface{}) []string { return []string{i.(api.IPAMAddress).Host} },
+	"tenant":  func(i interface{}) []string { return []string{i.(api.IPAMAddress).Tenant} },
+	"segment": func(i interface{}) []string { return []string{i.(api.IPAMAddress).Segment} },
+}
+
+var tenantFields = common.ListFields{
+	"name":        func(i interface{}) []string { return []string{i.(api.TenantResponse).Name} },
+	"external_id": func(i interface{}) []string { return []string{i.(api.TenantResponse).ExternalID} },
+	"defined":     func(i interface{}) []string { return []string{strconv.FormatBool(i.(api.TenantResponse).Defined)} },
+	"addresses":   func(i interface{}) []string { return []string{strconv.Itoa(i.(api.TenantResponse).Addresses)} },
+}
+
+var segmentFields = common.ListFields{
+	"name":      func(i interface{}) []string { return []string{i.(api.SegmentResponse).Name} },
+	"defined":   func(i interface{}) []string { return []string{strconv.FormatBool(i.(api.SegmentResponse).Defined)} },
+	"addresses": func(i interface{}) []string { return []string{strconv.Itoa(i.(api.SegmentResponse).Addresses)} },
+	"policy":    func(i interface{}) []string { return i.(api.SegmentResponse).Policies },
+}
+
+// policyFields have tenants and segments of endpoints
+// the policy is applied to.
+var policyFields = common.ListFields{
+	"id":        func(i interface{}) []string { return []string{i.(api.Policy).ID} },
+	"direction": func(i interface{}) []string { return []string{i.(api.Policy).Direction} },
+	"tenant": func(i interface{}) []string {
+		var tenants []string
+		for _, e := range i.(api.Policy).AppliedTo {
+			tenants = append(tenants, e.TenantID)
+		}
+		return tenants
+	},
+	"segment": func(i interface{}) []string {
+		var segments []string
+		for _, e := range i.(api.Policy).AppliedTo {
+			segments = append(segments, e.SegmentID)
+		}
+		return segments
+	},
+}
+
+var poolFields = common.ListFields{
+	"name": func(i interface{}) []string { return []string{i.(api.FederationPoolResponse).Name} },
+	"cidr": func(i interface{}) []string { return []string{i.(api.FederationPoolResponse).CIDR} },
+}
+
+var delegationFields = common.ListFields{
+	"pool":    func(i interface{}) []string { return []string{i.(api.Delegation).Pool} },
+	"cluster": func(i interface{}) []string { return []string{i.(api.Delegation).Cluster} },
+	"cidr": func(i interface{}) []string {
+		cidr := i.(api.Delegation).CIDR
+		return []string{cidr.String()}
+	},
+	"allocated": func(i interface{}) []string { return []string{strconv.Itoa(i.(api.Delegation).Allocated)} },
+}
+
+var eventFields = common.ListFields{
+	"type":      func(i interface{}) []string { return []string{i.(api.Event).Type} },
+	"reason":    func(i interface{}) []string { return []string{i.(api.Event).Reason} },
+	"component": func(i interface{}) []string { return []string{i.(api.Event).Component} },
+	"host":      func(i interface{}) []string { return []string{i.(api.Event).Host} },
+	"kind":      func(i interface{}) []string { return []string{i.(api.Event).Object.Kind} },
+	"name":      func(i interface{}) []string { return []string{i.(api.Event).Object.Name} },
+}
+
+var neutronSubnetFields = common.ListFields{
+	"tenant_id": func(i interface{}) []string { return []string{i.(api.NeutronSubnet).TenantID} },
+	"cidr":      func(i interface{}) []string { return []string{i.(api.NeutronSubnet).CIDR} },
+	"network":   func(i interface{}) []string { return []string{i.(api.NeutronSubnet).Network} },
+}
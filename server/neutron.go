@@ -0,0 +1,102 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+// This file has handlers of the REST facade of romana IPAM used by
+// romana IPAM driver of OpenStack Neutron, see common/client/neutron.go.
+
+import (
+	"net"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/api/errors"
+)
+
+// listNeutronSubnets returns Neutron subnets.
+func (r *Romanad) listNeutronSubnets(input interface{}, ctx common.RestContext) (interface{}, error) {
+	subnets := r.client.IPAM.ListNeutronSubnets()
+	return common.ListItems(ctx, &subnets, neutronSubnetFields, &subnets)
+}
+
+// allocateNeutronSubnet allocates the subnet given in the request
+// on a network of the topology, and returns it with its CIDR.
+func (r *Romanad) allocateNeutronSubnet(input interface{}, ctx common.RestContext) (interface{}, error) {
+	subnet := input.(*api.NeutronSubnet)
+	allocated, err := r.client.IPAM.AllocateNeutronSubnet(*subnet)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	return allocated, nil
+}
+
+// getNeutronSubnet returns the subnet.
+func (r *Romanad) getNeutronSubnet(input interface{}, ctx common.RestContext) (interface{}, error) {
+	subnet, err := r.client.IPAM.GetNeutronSubnet(ctx.PathVariables["subnet"])
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	return subnet, nil
+}
+
+// updateNeutronSubnet updates gateway and allocation pools of the subnet.
+func (r *Romanad) updateNeutronSubnet(input interface{}, ctx common.RestContext) (interface{}, error) {
+	subnet := input.(*api.NeutronSubnet)
+	subnet.ID = ctx.PathVariables["subnet"]
+	updated, err := r.client.IPAM.UpdateNeutronSubnet(*subnet)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	return updated, nil
+}
+
+// removeNeutronSubnet removes the subnet and its addresses.
+func (r *Romanad) removeNeutronSubnet(input interface{}, ctx common.RestContext) (interface{}, error) {
+	err := r.client.IPAM.RemoveNeutronSubnet(ctx.PathVariables["subnet"])
+	return nil, errors.RomanaErrorToHTTPError(err)
+}
+
+// allocateNeutronAddress allocates the address requested
+// for a port in the subnet.
+func (r *Romanad) allocateNeutronAddress(input interface{}, ctx common.RestContext) (interface{}, error) {
+	req := input.(*api.NeutronAddressRequest)
+	subnetID := ctx.PathVariables["subnet"]
+	resp, err := r.client.IPAM.AllocateNeutronAddress(subnetID, *req)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	subnet, _ := r.client.IPAM.GetNeutronSubnet(subnetID)
+	r.notify(ctx, common.ResourceAllocation, common.ActionCreated, resp.Name, api.IPAMAddress{
+		Name:    resp.Name,
+		IP:      net.ParseIP(resp.IPAddress),
+		Network: subnet.Network,
+		Host:    req.Host,
+		Tenant:  subnet.TenantID,
+		Segment: req.Segment,
+	})
+	return resp, nil
+}
+
+// deallocateNeutronAddress deallocates the address of the subnet.
+func (r *Romanad) deallocateNeutronAddress(input interface{}, ctx common.RestContext) (interface{}, error) {
+	address := ctx.PathVariables["address"]
+	err := r.client.IPAM.DeallocateNeutronAddress(ctx.PathVariables["subnet"], address)
+	if err != nil {
+		return nil, errors.RomanaErrorToHTTPError(err)
+	}
+	r.notify(ctx, common.ResourceAllocation, common.ActionDeleted, address, nil)
+	return nil, nil
+}
@@ -16,14 +16,29 @@
 package server
 
 import (
+	"strings"
+	"time"
+
 	"github.com/romana/core/common"
 	"github.com/romana/core/common/api"
 	"github.com/romana/core/common/client"
+	log "github.com/romana/rlog"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Romanad struct {
-	Addr   string
-	client *client.Client
+	Addr string
+	// MaxEvents is the number of most recent events kept
+	// in the event stream, client.DefaultMaxEvents if 0.
+	MaxEvents int
+	client    *client.Client
+	notifier  *common.Notifier
+	events    *client.EventRecorder
+
+	// federation is the client to the parent romanad
+	// if the cluster is federated, nil otherwise.
+	federation *federation
 }
 
 func (r *Romanad) GetAddress() string {
@@ -40,9 +55,77 @@ func (r *Romanad) Initialize(clientConfig common.Config) error {
 	if err != nil {
 		return err
 	}
+	r.notifier, err = common.NewNotifier(r.Name(), clientConfig.Webhooks)
+	if err != nil {
+		return err
+	}
+	r.events = r.client.RecordEvents(r.Name(), "")
+	go r.trimEvents()
+	if clientConfig.Federation.IsEnabled() {
+		r.federation, err = newFederation(clientConfig.Federation)
+		if err != nil {
+			return err
+		}
+		go r.reportUsage()
+	}
 	return nil
 }
 
+// notify notifies webhooks of the change of a resource
+// made by the request of the context.
+func (r *Romanad) notify(ctx common.RestContext, resource string, action string, name string, object interface{}) {
+	r.notifier.Notify(common.NewWebhookEvent(ctx, resource, action, name, object))
+	if resource == common.ResourcePolicy {
+		r.events.Eventf(api.EventNormal, "Policy"+strings.Title(action),
+			api.EventObject{Kind: api.EventKindPolicy, Name: name}, "Policy %s %s", name, action)
+	}
+}
+
+// trimEvents periodically drops the oldest events so that
+// the event stream stays bounded.
+func (r *Romanad) trimEvents() {
+	maxEvents := r.MaxEvents
+	if maxEvents <= 0 {
+		maxEvents = client.DefaultMaxEvents
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := r.client.TrimEvents(maxEvents)
+		if err != nil {
+			log.Errorf("Error trimming events: %s", err)
+			continue
+		}
+		if n > 0 {
+			log.Debugf("Trimmed %d events", n)
+		}
+	}
+}
+
+// Ready implements common.ReadinessChecker, romanad is
+// ready when the store can be reached.
+func (r *Romanad) Ready() error {
+	return r.client.Store.Ping()
+}
+
+// MetricsRegister implements common.MetricsRegisterer,
+// store metrics are published along with request metrics.
+func (r *Romanad) MetricsRegister(registry *prometheus.Registry) error {
+	return client.MetricsRegister(registry)
+}
+
+// IdempotencyStore implements common.IdempotencyStorer, responses
+// are kept in the store so that any romanad replays them.
+func (r *Romanad) IdempotencyStore() common.IdempotencyStore {
+	return client.NewIdempotencyStore(r.client.Store)
+}
+
+// AuditStore implements common.AuditStorer, audit records
+// are kept in the store romanad uses.
+func (r *Romanad) AuditStore(prefix string, maxAge time.Duration) (common.AuditSink, error) {
+	return client.NewAuditStore(r.client.Store, prefix, maxAge), nil
+}
+
 // Routes provided by ipam.
 func (r *Romanad) Routes() common.Routes {
 	routes := common.Routes{
@@ -53,6 +136,13 @@ func (r *Romanad) Routes() common.Routes {
 			MakeMessage:     func() interface{} { return &api.Policy{} },
 			UseRequestToken: false,
 		},
+		common.Route{
+			Method:          "PUT",
+			Pattern:         "/policies",
+			Handler:         r.applyPolicies,
+			MakeMessage:     func() interface{} { return &[]api.Policy{} },
+			UseRequestToken: false,
+		},
 		common.Route{
 			Method:          "DELETE",
 			Pattern:         "/policies",
@@ -107,6 +197,28 @@ func (r *Romanad) Routes() common.Routes {
 			Pattern: "/networks",
 			Handler: r.listNetworks,
 		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/addresses",
+			Handler: r.listAddresses,
+		},
+		common.Route{
+			Method:      "POST",
+			Pattern:     "/addresses",
+			Handler:     r.importAddresses,
+			MakeMessage: func() interface{} { return &[]api.IPAMAddress{} },
+		},
+		common.Route{
+			Method:      "POST",
+			Pattern:     "/blackout",
+			Handler:     r.blackOut,
+			MakeMessage: func() interface{} { return &api.IPAMBlackOutRequest{} },
+		},
+		common.Route{
+			Method:  "DELETE",
+			Pattern: "/blackout",
+			Handler: r.unBlackOut,
+		},
 		common.Route{
 			Method:  "GET",
 			Pattern: "/topology",
@@ -129,6 +241,194 @@ func (r *Romanad) Routes() common.Routes {
 			Handler:     r.addHost,
 			MakeMessage: func() interface{} { return &api.Host{} },
 		},
+		common.Route{
+			Method:  "DELETE",
+			Pattern: "/hosts/{host}",
+			Handler: r.removeHost,
+		},
+		common.Route{
+			Method:  "PUT",
+			Pattern: "/hosts/{host}/cordon",
+			Handler: r.cordonHost,
+		},
+		common.Route{
+			Method:  "DELETE",
+			Pattern: "/hosts/{host}/cordon",
+			Handler: r.uncordonHost,
+		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/hosts/{host}/addresses",
+			Handler: r.listHostAddresses,
+		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/tenants",
+			Handler: r.listTenants,
+		},
+		common.Route{
+			Method:      "POST",
+			Pattern:     "/tenants",
+			Handler:     r.createTenant,
+			MakeMessage: func() interface{} { return &api.TenantDefinition{} },
+		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/tenants/{tenant}",
+			Handler: r.getTenant,
+		},
+		common.Route{
+			Method:  "DELETE",
+			Pattern: "/tenants/{tenant}",
+			Handler: r.deleteTenant,
+		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/tenants/{tenant}/segments",
+			Handler: r.listSegments,
+		},
+		common.Route{
+			Method:      "POST",
+			Pattern:     "/tenants/{tenant}/segments",
+			Handler:     r.createSegment,
+			MakeMessage: func() interface{} { return &api.SegmentDefinition{} },
+		},
+		common.Route{
+			Method:  "DELETE",
+			Pattern: "/tenants/{tenant}/segments/{segment}",
+			Handler: r.deleteSegment,
+		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/federation/pools",
+			Handler: r.listFederationPools,
+		},
+		common.Route{
+			Method:      "POST",
+			Pattern:     "/federation/pools",
+			Handler:     r.addFederationPool,
+			MakeMessage: func() interface{} { return &api.FederationPool{} },
+		},
+		common.Route{
+			Method:  "DELETE",
+			Pattern: "/federation/pools/{pool}",
+			Handler: r.deleteFederationPool,
+		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/federation/delegations",
+			Handler: r.listDelegations,
+		},
+		common.Route{
+			Method:      "POST",
+			Pattern:     "/federation/delegations",
+			Handler:     r.delegateSuperBlock,
+			MakeMessage: func() interface{} { return &api.DelegationRequest{} },
+		},
+		common.Route{
+			Method:  "DELETE",
+			Pattern: "/federation/delegations",
+			Handler: r.releaseDelegation,
+		},
+		common.Route{
+			Method:      "PUT",
+			Pattern:     "/federation/clusters/{cluster}/usage",
+			Handler:     r.reportClusterUsage,
+			MakeMessage: func() interface{} { return &api.ClusterUsage{} },
+		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/neutron/subnets",
+			Handler: r.listNeutronSubnets,
+		},
+		common.Route{
+			Method:      "POST",
+			Pattern:     "/neutron/subnets",
+			Handler:     r.allocateNeutronSubnet,
+			MakeMessage: func() interface{} { return &api.NeutronSubnet{} },
+		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/neutron/subnets/{subnet}",
+			Handler: r.getNeutronSubnet,
+		},
+		common.Route{
+			Method:      "PUT",
+			Pattern:     "/neutron/subnets/{subnet}",
+			Handler:     r.updateNeutronSubnet,
+			MakeMessage: func() interface{} { return &api.NeutronSubnet{} },
+		},
+		common.Route{
+			Method:  "DELETE",
+			Pattern: "/neutron/subnets/{subnet}",
+			Handler: r.removeNeutronSubnet,
+		},
+		common.Route{
+			Method:      "POST",
+			Pattern:     "/neutron/subnets/{subnet}/addresses",
+			Handler:     r.allocateNeutronAddress,
+			MakeMessage: func() interface{} { return &api.NeutronAddressRequest{} },
+		},
+		common.Route{
+			Method:  "DELETE",
+			Pattern: "/neutron/subnets/{subnet}/addresses/{address}",
+			Handler: r.deallocateNeutronAddress,
+		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/version",
+			Handler: r.getVersion,
+		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/stats",
+			Handler: r.getStats,
+		},
+		common.Route{
+			Method:  "GET",
+			Pattern: "/events",
+			Handler: r.listEvents,
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/watch/hosts",
+			Handler:      r.watchHosts,
+			MakeMessage:  makeStreamingRequest,
+			AuthZChecker: allowReads,
+			Streaming:    true,
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/watch/blocks",
+			Handler:      r.watchBlocks,
+			MakeMessage:  makeStreamingRequest,
+			AuthZChecker: allowReads,
+			Streaming:    true,
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/watch/addresses",
+			Handler:      r.watchAddresses,
+			MakeMessage:  makeStreamingRequest,
+			AuthZChecker: allowReads,
+			Streaming:    true,
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/watch/policies",
+			Handler:      r.watchPolicies,
+			MakeMessage:  makeStreamingRequest,
+			AuthZChecker: allowReads,
+			Streaming:    true,
+		},
+		common.Route{
+			Method:       "GET",
+			Pattern:      "/watch/events",
+			Handler:      r.watchEvents,
+			MakeMessage:  makeStreamingRequest,
+			AuthZChecker: allowReads,
+			Streaming:    true,
+		},
 	}
 	return routes
 }
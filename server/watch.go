@@ -0,0 +1,211 @@
+// Copyright (c) 2017 Pani Networks
+// All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package server
+
+// Handlers of routes streaming changes as server-sent events,
+// for clients that are not linked against the client library.
+
+import (
+	"net/http"
+
+	"github.com/romana/core/common"
+	"github.com/romana/core/common/api"
+	"github.com/romana/core/common/client"
+	log "github.com/romana/rlog"
+)
+
+// Names of events streamed by watch routes.
+const (
+	eventHosts     = "hosts"
+	eventBlocks    = "blocks"
+	eventAddresses = "addresses"
+	eventPolicy    = "policy"
+	eventEvent     = "event"
+)
+
+// makeStreamingRequest makes input of watch routes,
+// which write to the response directly.
+func makeStreamingRequest() interface{} {
+	return http.Request{}
+}
+
+// allowReads lets any user that passed authentication
+// watch, like other GET requests.
+func allowReads(ctx common.RestContext) bool {
+	return true
+}
+
+// watchHosts streams host list as "hosts" event
+// whenever it changes, starting with current one.
+func (r *Romanad) watchHosts(input interface{}, ctx common.RestContext) (interface{}, error) {
+	r.streamIPAM(input, eventHosts, func(ipam *client.IPAM) (interface{}, int) {
+		hosts := ipam.ListHosts()
+		return hosts, hosts.Revision
+	})
+	return nil, nil
+}
+
+// watchBlocks streams blocks as "blocks" event
+// whenever they change, starting with current ones.
+func (r *Romanad) watchBlocks(input interface{}, ctx common.RestContext) (interface{}, error) {
+	r.streamIPAM(input, eventBlocks, func(ipam *client.IPAM) (interface{}, int) {
+		blocks := ipam.ListAllBlocks()
+		return blocks, blocks.Revision
+	})
+	return nil, nil
+}
+
+// watchAddresses streams allocated addresses as "addresses"
+// event whenever addresses are allocated or deallocated,
+// starting with current ones.
+func (r *Romanad) watchAddresses(input interface{}, ctx common.RestContext) (interface{}, error) {
+	r.streamIPAM(input, eventAddresses, func(ipam *client.IPAM) (interface{}, int) {
+		return ipam.ListAddresses(), ipam.AllocationRevision
+	})
+	return nil, nil
+}
+
+// streamIPAM streams what decode returns for IPAM as events of the
+// name, starting with current IPAM. IPAM is a single blob in the store,
+// so it changes with any of its parts, and events are only sent when
+// revision returned by decode is newer than the last one sent.
+func (r *Romanad) streamIPAM(input interface{}, name string, decode func(*client.IPAM) (interface{}, int)) {
+	common.StreamEvents(input.(common.UnwrappedRestHandlerInput), func(stopCh <-chan struct{}) (<-chan common.Event, error) {
+		ipams, err := r.client.WatchIPAM(stopCh)
+		if err != nil {
+			return nil, err
+		}
+		events := make(chan common.Event)
+		go func() {
+			lastRevision := -1
+			for {
+				select {
+				case ipam := <-ipams:
+					data, revision := decode(ipam)
+					if revision <= lastRevision {
+						continue
+					}
+					lastRevision = revision
+					if !sendEvent(events, common.Event{Name: name, Data: data}, stopCh) {
+						return
+					}
+				case <-stopCh:
+					return
+				}
+			}
+		}()
+		return events, nil
+	})
+}
+
+// watchPolicies streams changes of policies as "policy" events
+// holding api.PolicyEvent, starting with existing policies
+// as created.
+func (r *Romanad) watchPolicies(input interface{}, ctx common.RestContext) (interface{}, error) {
+	common.StreamEvents(input.(common.UnwrappedRestHandlerInput), func(stopCh <-chan struct{}) (<-chan common.Event, error) {
+		changes, err := r.client.Store.WatchTreeChanges(r.client.Store.Key(client.PoliciesPrefix), stopCh)
+		if err != nil {
+			return nil, err
+		}
+		events := make(chan common.Event)
+		go func() {
+			for {
+				select {
+				case batch, ok := <-changes:
+					if !ok {
+						close(events)
+						return
+					}
+					for _, change := range batch {
+						event, err := policyEvent(change)
+						if err != nil {
+							log.Errorf("Failed to decode policy %s: %s", change.Key, err)
+							continue
+						}
+						if !sendEvent(events, common.Event{Name: eventPolicy, Data: event}, stopCh) {
+							return
+						}
+					}
+				case <-stopCh:
+					return
+				}
+			}
+		}()
+		return events, nil
+	})
+	return nil, nil
+}
+
+// watchEvents streams events of the cluster as "event" events
+// as they are recorded, starting with the ones kept in the store.
+func (r *Romanad) watchEvents(input interface{}, ctx common.RestContext) (interface{}, error) {
+	common.StreamEvents(input.(common.UnwrappedRestHandlerInput), func(stopCh <-chan struct{}) (<-chan common.Event, error) {
+		recorded, err := r.client.WatchEvents(stopCh)
+		if err != nil {
+			return nil, err
+		}
+		events := make(chan common.Event)
+		go func() {
+			for {
+				select {
+				case e, ok := <-recorded:
+					if !ok {
+						close(events)
+						return
+					}
+					if !sendEvent(events, common.Event{Name: eventEvent, Data: e}, stopCh) {
+						return
+					}
+				case <-stopCh:
+					return
+				}
+			}
+		}()
+		return events, nil
+	})
+	return nil, nil
+}
+
+// policyEvent returns event of the change of a policy.
+func policyEvent(change client.KVChange) (api.PolicyEvent, error) {
+	var event api.PolicyEvent
+	value := change.Value
+	switch {
+	case change.Value == nil:
+		event.Type = api.PolicyDeleted
+		value = change.PrevValue
+	case change.PrevValue == nil:
+		event.Type = api.PolicyCreated
+	default:
+		event.Type = api.PolicyUpdated
+	}
+	if err := client.DecodeObject(client.KindPolicy, value, &event.Policy); err != nil {
+		return event, err
+	}
+	event.ID = event.Policy.ID
+	return event, nil
+}
+
+// sendEvent sends the event unless stopCh is closed first,
+// returns false if it was.
+func sendEvent(events chan<- common.Event, event common.Event, stopCh <-chan struct{}) bool {
+	select {
+	case events <- event:
+		return true
+	case <-stopCh:
+		return false
+	}
+}
@@ -34,6 +34,11 @@ func main() {
 	var err error
 	endpointsStr := flag.String("etcd-endpoints", client.DefaultEtcdEndpoints, "Comma-separated list of etcd endpoints.")
 	prefix := flag.String("etcd-prefix", client.DefaultEtcdPrefix, "Prefix to use for etcd data.")
+	storeBackend := flag.String("store-backend", client.BackendEtcd, "kv store holding romana data, etcd, consul or memory (for development, data is lost on exit)")
+	var etcdTLS common.EtcdTLS
+	etcdTLS.RegisterFlags(flag.CommandLine)
+	var etcdAuth common.EtcdAuth
+	etcdAuth.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 	if endpointsStr == nil {
 		log.Errorf("No etcd endpoints specified")
@@ -47,6 +52,9 @@ func main() {
 	}
 	config := common.Config{EtcdEndpoints: endpoints,
 		EtcdPrefix: pr,
+		EtcdTLS:    etcdTLS,
+		Backend:    *storeBackend,
+		EtcdAuth:   etcdAuth,
 	}
 	cl, err := client.NewClient(&config)
 	if err != nil {